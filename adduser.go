@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/droslean/thyranew/server"
+	"golang.org/x/crypto/ssh"
+)
+
+// adduser saves a new player, like the first admin of a server, and lets
+// the keys given in as theirs.
+func adduser(args []string) int {
+	fs := newFlags("adduser", "name")
+	static := staticFlag(fs)
+	dbPath, dataDir := dataFlags(fs)
+	role := fs.String("role", "", "Role of the player: "+strings.Join(server.Roles, " or ")+", a plain player unless set")
+	keyFile := fs.String("key", "", "File of public keys the player logs in with, in the format of authorized_keys")
+	fs.Parse(args)
+	quiet()
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return 2
+	}
+	nick := fs.Arg(0)
+
+	keys := []ssh.PublicKey{}
+	if *keyFile != "" {
+		data, err := ioutil.ReadFile(*keyFile)
+		if err != nil {
+			return fail(err)
+		}
+		for len(strings.TrimSpace(string(data))) > 0 {
+			key, _, _, rest, err := ssh.ParseAuthorizedKey(data)
+			if err != nil {
+				return fail(fmt.Errorf("%s: %v", *keyFile, err))
+			}
+			keys = append(keys, key)
+			data = rest
+		}
+	}
+	if *dataDir == "" {
+		*dataDir = staticDir(*static)
+	}
+
+	db, err := server.OpenDatabase(*dbPath, false)
+	if err != nil {
+		return fail(err)
+	}
+	defer db.Close()
+	if err := server.AddPlayer(db, *dataDir, nick, *role, keys); err != nil {
+		return fail(err)
+	}
+	fmt.Printf("Added %s", nick)
+	if *role != "" {
+		fmt.Printf(" as %s", *role)
+	}
+	fmt.Printf(", with %d keys\n", len(keys))
+	return 0
+}
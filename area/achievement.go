@@ -0,0 +1,12 @@
+package area
+
+// Achievement is a feat players are recognised for, along with the title it
+// earns them. Every requirement it sets has to be met.
+type Achievement struct {
+	Name     string `toml:"name"`
+	Title    string `toml:"title"`   // What the player may wear after their name
+	Level    int    `toml:"level"`   // The level it takes
+	Quest    string `toml:"quest"`   // A quest that has to be done
+	Faction  string `toml:"faction"` // A faction whose standing it takes
+	Standing int    `toml:"standing"`
+}
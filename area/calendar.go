@@ -0,0 +1,66 @@
+package area
+
+import (
+	"strings"
+	"time"
+)
+
+// CalendarEvent is a world event that runs at set times, like a double
+// experience weekend, an invasion or a seasonal festival.
+type CalendarEvent struct {
+	Name     string `toml:"name"`
+	Announce string `toml:"announce"` // Told to everyone when the event starts
+	Farewell string `toml:"farewell"` // Told to everyone when the event ends
+
+	// When the event runs. Empty fields do not restrict it.
+	Weekdays []string `toml:"weekdays"` // Like "saturday"
+	From     string   `toml:"from"`     // First day of a season, as "MM-DD"
+	To       string   `toml:"to"`       // Last day of a season, as "MM-DD"
+	Start    int      `toml:"start"`    // Hour of the day the event starts
+	Stop     int      `toml:"stop"`     // Hour of the day the event stops; zero is midnight
+
+	XPMultiplier int          `toml:"xpmultiplier"`
+	Invasion     Invasion     `toml:"invasion"`
+	Decorations  []Decoration `toml:"decorations"`
+}
+
+// Invasion sends waves of NPCs into a room while the event runs.
+type Invasion struct {
+	Area     string  `toml:"area"`
+	Room     string  `toml:"room"`
+	Waves    int     `toml:"waves"`
+	Interval int     `toml:"interval"` // Minutes between two waves
+	Spawns   []Spawn `toml:"spawns"`
+}
+
+// Decoration is added to the description of a room while the event runs.
+type Decoration struct {
+	Area string `toml:"area"`
+	Room string `toml:"room"`
+	Text string `toml:"text"`
+}
+
+// ActiveAt reports whether the event runs at the given time.
+func (e CalendarEvent) ActiveAt(t time.Time) bool {
+	if len(e.Weekdays) > 0 {
+		found := false
+		for _, day := range e.Weekdays {
+			if strings.EqualFold(day, t.Weekday().String()) {
+				found = true
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	if !withinDays(t, e.From, e.To) {
+		return false
+	}
+
+	stop := e.Stop
+	if stop == 0 {
+		stop = 24
+	}
+	return t.Hour() >= e.Start && t.Hour() < stop
+}
@@ -0,0 +1,27 @@
+package area
+
+// Colour depths of terminals.
+const (
+	ColorNone = "none"
+	Color16   = "16"
+	Color256  = "256"
+	ColorTrue = "truecolor"
+)
+
+// ClientInfo is what the client of a player can do, as detected when they
+// connect, or as the player set it.
+type ClientInfo struct {
+	Terminal string `toml:"terminal"` // Terminal type the client sent, like xterm-256color
+	// Color is the colour depth of the terminal. Empty is not known, and
+	// drawn as truecolor.
+	Color string `toml:"color"`
+	ASCII bool   `toml:"ascii"` // Set for terminals that don't take UTF-8
+	GMCP  bool   `toml:"gmcp"`  // Set for clients that read the tagged output
+	Mouse bool   `toml:"mouse"` // Set for terminals that report the mouse, xterm style
+	// Plain is set for players who read the game with a screen reader, and
+	// want plain lines of text instead of boxes and columns.
+	Plain bool `toml:"plain"`
+	// Override is set when the player picked these themselves. They are
+	// kept as they are, instead of detected again.
+	Override bool `toml:"override"`
+}
@@ -0,0 +1,48 @@
+package area
+
+import (
+	"strings"
+	"time"
+)
+
+// ContentFlag is a window of time seasonal content is there in, like a
+// holiday or a season of the game world. Rooms, NPCs, wares and dialogue
+// options tagged with the flag are there only while it is on. Empty fields
+// do not restrict it.
+type ContentFlag struct {
+	Name    string   `toml:"name"`
+	From    string   `toml:"from"`    // First day, as "MM-DD"
+	To      string   `toml:"to"`      // Last day, as "MM-DD"
+	Seasons []string `toml:"seasons"` // Seasons of the game world, like "winter"
+}
+
+// OnAt reports whether the flag is on at the given time, in the given season
+// of the game world.
+func (f ContentFlag) OnAt(t time.Time, season string) bool {
+	if !withinDays(t, f.From, f.To) {
+		return false
+	}
+	if len(f.Seasons) == 0 {
+		return true
+	}
+	for _, s := range f.Seasons {
+		if strings.EqualFold(s, season) {
+			return true
+		}
+	}
+	return false
+}
+
+// withinDays reports whether the time falls between the days, as "MM-DD".
+// The days may run over the new year, and do not restrict it unless both are
+// given.
+func withinDays(t time.Time, from, to string) bool {
+	if from == "" || to == "" {
+		return true
+	}
+	day := t.Format("01-02")
+	if from <= to {
+		return day >= from && day <= to
+	}
+	return day >= from || day <= to
+}
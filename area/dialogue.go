@@ -0,0 +1,119 @@
+package area
+
+import "strings"
+
+/*
+Dialogues. The conversation with an NPC is a graph of nodes. Every node is something the NPC says, followed by
+the options the player may answer with, either by their number or by saying one of their keywords. Nodes and
+options carry conditions on the state of the player, so the NPC greets differently whoever is on a quest for
+them, and options lead to hooks that start quests, open shops or run scripts.
+*/
+
+// Dialogue is the conversation held by every NPC with the given name.
+type Dialogue struct {
+	NPC   string         `toml:"npc"`
+	Nodes []DialogueNode `toml:"nodes"`
+}
+
+// DialogueNode is something the NPC says.
+type DialogueNode struct {
+	ID       string           `toml:"id"`
+	Text     string           `toml:"text"`
+	Greeting bool             `toml:"greeting"` // The conversation may start from this node
+	If       Condition        `toml:"if"`
+	Options  []DialogueOption `toml:"options"`
+}
+
+// DialogueOption is an answer of the player.
+type DialogueOption struct {
+	Text     string    `toml:"text"`
+	Keywords []string  `toml:"keywords"`
+	Next     string    `toml:"next"` // Empty ends the conversation
+	If       Condition `toml:"if"`
+
+	// Hooks run when the option is picked.
+	Quest  string `toml:"quest"`  // Quest whose state is set
+	State  string `toml:"state"`  // New state of the quest, defaults to "started"
+	Shop   string `toml:"shop"`   // Shop opened to the player
+	Script string `toml:"script"` // Script run by the server
+	// Flag is the content flag the option is offered under, like for a
+	// holiday quest.
+	Flag string `toml:"flag"`
+}
+
+// Condition restricts a node or an option to some players. Empty fields are ignored.
+type Condition struct {
+	Quest    string `toml:"quest"`
+	State    string `toml:"state"` // Required state of the quest; "none" when it must not be taken
+	Faction  string `toml:"faction"`
+	Standing int    `toml:"standing"` // Minimum standing with the faction
+	Item     string `toml:"item"`
+}
+
+// Met reports whether the player fulfills the condition. The standing of the
+// player with a faction is given by the server, which knows their defaults.
+func (c Condition) Met(p *Player, standing func(faction string) int) bool {
+	if c.Quest != "" {
+		state, taken := p.Quests[c.Quest]
+		switch {
+		case c.State == "none" && taken:
+			return false
+		case c.State == "" && !taken:
+			return false
+		case c.State != "" && c.State != "none" && c.State != state:
+			return false
+		}
+	}
+	if c.Faction != "" && standing(c.Faction) < c.Standing {
+		return false
+	}
+	if c.Item != "" && !p.HasItem(c.Item) {
+		return false
+	}
+	return true
+}
+
+// Node returns the node with the given ID.
+func (d Dialogue) Node(id string) (DialogueNode, bool) {
+	for _, n := range d.Nodes {
+		if n.ID == id {
+			return n, true
+		}
+	}
+	return DialogueNode{}, false
+}
+
+// Greeting returns the first greeting the player qualifies for.
+func (d Dialogue) Greeting(p *Player, standing func(string) int) (DialogueNode, bool) {
+	for _, n := range d.Nodes {
+		if n.Greeting && n.If.Met(p, standing) {
+			return n, true
+		}
+	}
+	return DialogueNode{}, false
+}
+
+// Available returns the options of the node the player qualifies for. Options
+// tagged with a content flag are left out while the server has it off.
+func (n DialogueNode) Available(p *Player, standing func(string) int, on func(flag string) bool) []DialogueOption {
+	options := []DialogueOption{}
+	for _, o := range n.Options {
+		if o.If.Met(p, standing) && (o.Flag == "" || on(o.Flag)) {
+			options = append(options, o)
+		}
+	}
+	return options
+}
+
+// Match returns the first of the options with a keyword found in what the player said.
+func Match(options []DialogueOption, said string) (DialogueOption, bool) {
+	said = strings.ToLower(said)
+	for _, o := range options {
+		for _, k := range o.Keywords {
+			if strings.Contains(said, strings.ToLower(k)) {
+				return o, true
+			}
+		}
+	}
+	return DialogueOption{}, false
+}
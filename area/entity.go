@@ -0,0 +1,136 @@
+package area
+
+import (
+	"strings"
+
+	"github.com/droslean/thyranew/game"
+)
+
+// Players, NPCs and whatever else lives in the world are put together from
+// components, embedded in their structs. Every component has a method that
+// returns it, which the structs embedding it pick up, so code that deals with
+// one side of things takes the interface of that component and works with
+// anything made with it. A new mechanic is a new component, rather than more
+// fields on every struct. Stats are the game.PC of the rules.
+//
+// Items carried are the names of their prototypes in the Belongings of
+// whoever has them, with an ItemInstance for what they have of their own.
+// Items left lying in the world, on the corpses of players and the remains
+// of NPCs, are entities of their own: GroundItems, with a Location and an
+// Ownership besides the ItemInstance.
+
+// Location is where something is in the world.
+type Location struct {
+	Area     string `toml:"area"`
+	Room     string `toml:"room"`
+	Position string `toml:"position"` // ID of the cube
+}
+
+// Locate returns the location.
+func (l *Location) Locate() *Location {
+	return l
+}
+
+// MoveTo puts the location at the cube the exit leads to.
+func (l *Location) MoveTo(to Exit) {
+	l.Area, l.Room, l.Position = to.ToArea, to.ToRoom, to.ToCubeID
+}
+
+// InRoom reports whether the location is in the given room.
+func (l *Location) InRoom(areaName, room string) bool {
+	return l.Area == areaName && l.Room == room
+}
+
+// Belongings are the items and the gold something carries.
+type Belongings struct {
+	// Inventory holds the names of the items carried.
+	Inventory []string `toml:"inventory"`
+	Gold      int      `toml:"gold"`
+	// Instances are what the items of the inventory with something of their
+	// own have. Each stands for one of the items of its prototype.
+	Instances []ItemInstance `toml:"instances,omitempty"`
+}
+
+// Carried returns the belongings.
+func (b *Belongings) Carried() *Belongings {
+	return b
+}
+
+// Ownership is who may take something.
+type Ownership struct {
+	Owners []string `toml:"owners,omitempty"` // Anyone may when there are none
+}
+
+// Owned returns the ownership.
+func (o *Ownership) Owned() *Ownership {
+	return o
+}
+
+// OwnedBy reports whether the player may take it: whether they are one of
+// the owners, or there are none.
+func (o *Ownership) OwnedBy(nick string) bool {
+	if len(o.Owners) == 0 {
+		return true
+	}
+	for _, owner := range o.Owners {
+		if strings.EqualFold(owner, nick) {
+			return true
+		}
+	}
+	return false
+}
+
+// GroundItem is an item lying in the world rather than carried, like those
+// on a corpse: where it lies and who may take it, besides the item itself.
+type GroundItem struct {
+	ItemInstance
+	Location
+	Ownership
+}
+
+// AI is what drives an NPC: where it is meant to be, and who it is after.
+type AI struct {
+	// Schedule tells where the NPC is and what it does at every hour of the day.
+	Schedule   []ScheduleEntry `toml:"schedule"`
+	Target     string          `toml:"-"` // Nickname of the player the NPC is after
+	Activity   string          `toml:"-"` // Activity of the current schedule entry
+	PatrolStep int             `toml:"-"` // Room of the patrol the NPC is heading to
+}
+
+// Mind returns the AI.
+func (ai *AI) Mind() *AI {
+	return ai
+}
+
+// Look is how something is drawn on the map of a room. Things without a symbol
+// are left off the map.
+type Look struct {
+	Symbol string `toml:"symbol"`
+}
+
+// Appearance returns the look.
+func (l *Look) Appearance() *Look {
+	return l
+}
+
+// Interfaces of the components.
+type (
+	Locatable interface {
+		Locate() *Location
+	}
+	Carrier interface {
+		Carried() *Belongings
+	}
+	Ownable interface {
+		Owned() *Ownership
+	}
+	Thinker interface {
+		Mind() *AI
+	}
+	Renderable interface {
+		Appearance() *Look
+	}
+	Fighter interface {
+		Stats() *game.PC
+	}
+)
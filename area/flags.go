@@ -0,0 +1,11 @@
+package area
+
+// Flags are what a player tells others about how they play, and what they
+// let other players do to them.
+type Flags struct {
+	PK       bool `toml:"pk"`       // Open to fights and theft with other players
+	Roleplay bool `toml:"roleplay"` // Playing in character
+	AFK      bool `toml:"afk"`      // Away from the keyboard
+	Busy     bool `toml:"busy"`     // Not to be followed or bothered
+	Private  bool `toml:"private"`  // Kept off the public web pages
+}
@@ -0,0 +1,398 @@
+package area
+
+import (
+	"fmt"
+	"math/rand"
+	"strconv"
+
+	"github.com/droslean/thyranew/game"
+)
+
+/*
+Procedural areas. A generator builds a graph of rooms connected by doors. Every room is a grid of cubes
+laid out according to the style of the generator:
+	maze    - corridors carved by a randomized depth-first search
+	cave    - open caverns grown by a cellular automaton
+	dungeon - rectangular chambers with scattered pillars
+The outer ring of every room is kept free for the doors. The same seed always builds the same area.
+*/
+
+// Generator describes how a procedural area is built.
+type Generator struct {
+	Style  string  `toml:"style"`
+	Seed   int64   `toml:"seed"` // Zero picks a new seed on every reset
+	Rooms  int     `toml:"rooms"`
+	Width  int     `toml:"width"`
+	Height int     `toml:"height"`
+	Exit   Exit    `toml:"exit"` // Where the way out of the first room leads
+	Spawns []Spawn `toml:"spawns"`
+	// Scaling fits the NPCs of instances to the group that enters them.
+	Scaling Scaling `toml:"scaling"`
+	// Locks is the percentage of the doors between rooms that are locked,
+	// all with the lock named Lock.
+	Locks int    `toml:"locks"`
+	Lock  string `toml:"lock"`
+}
+
+// Scaling fits the NPCs of an instance to the group entering it. Spawns are
+// made for one player of the level, and go up or down a level for every
+// level the group is above or below it on average. Every member past the
+// first gives them HP percent more hit points, and Loot percent more luck
+// with what they drop. A zero level turns scaling off.
+type Scaling struct {
+	Level int `toml:"level"`
+	HP    int `toml:"hp"`
+	Loot  int `toml:"loot"`
+}
+
+// Spawn is a template of an NPC that may be placed in generated rooms.
+type Spawn struct {
+	Name   string `toml:"name"`
+	Level  int    `toml:"level"`
+	Loot   string `toml:"loot"`
+	Chance int    `toml:"chance"` // Percentage of rooms the NPC shows up in
+	Blows  string `toml:"blows"`  // Type of damage the blows of the NPC deal
+	// Pursuit is how many rooms away the NPC stops chasing players.
+	Pursuit int `toml:"pursuit"`
+	game.Defenses
+}
+
+type cell struct {
+	x, y int
+}
+
+// Generate builds the rooms, the NPCs and the entry of the area from its generator,
+// using the given seed.
+func Generate(a *Area, seed int64) error {
+	g := a.Generator
+	switch g.Style {
+	case "maze", "cave", "dungeon":
+	default:
+		return fmt.Errorf("unknown generator style %q", g.Style)
+	}
+	if g.Rooms < 1 {
+		g.Rooms = 1
+	}
+	// Mazes are carved on odd cells, so they need odd dimensions.
+	g.Width = oddAtLeast(g.Width, 9)
+	g.Height = oddAtLeast(g.Height, 9)
+
+	r := rand.New(rand.NewSource(seed))
+
+	floors := make([][][]bool, g.Rooms)
+	for i := range floors {
+		switch g.Style {
+		case "maze":
+			floors[i] = carveMaze(g.Width, g.Height, r)
+		case "cave":
+			floors[i] = growCave(g.Width, g.Height, r)
+		case "dungeon":
+			floors[i] = buildChamber(g.Width, g.Height, r)
+		}
+	}
+
+	rooms := make([]map[cell]Cube, g.Rooms)
+	for i := range rooms {
+		rooms[i] = map[cell]Cube{}
+		for x := 0; x < g.Width; x++ {
+			for y := 0; y < g.Height; y++ {
+				if floors[i][x][y] {
+					rooms[i][cell{x, y}] = Cube{
+						ID:   cubeID(g.Width, x, y),
+						POSX: strconv.Itoa(x),
+						POSY: strconv.Itoa(y),
+					}
+				}
+			}
+		}
+	}
+
+	// Every room links to one of the rooms before it, so the graph is always
+	// connected. A few extra links add loops to anything but a maze.
+	links := [][2]int{}
+	for i := 1; i < g.Rooms; i++ {
+		links = append(links, [2]int{r.Intn(i), i})
+	}
+	if g.Style != "maze" {
+		for i := 0; i < g.Rooms/3; i++ {
+			from, to := r.Intn(g.Rooms), r.Intn(g.Rooms)
+			if from != to {
+				links = append(links, [2]int{from, to})
+			}
+		}
+	}
+
+	for _, l := range links {
+		doorA, insideA, okA := pickDoor(floors[l[0]], rooms[l[0]], r)
+		doorB, insideB, okB := pickDoor(floors[l[1]], rooms[l[1]], r)
+		if !okA || !okB {
+			continue
+		}
+		sideA, sideB := doorCube(g.Width, doorA, Exit{ToArea: a.Name, ToRoom: roomName(l[1]), ToCubeID: cubeID(g.Width, insideB.x, insideB.y)}),
+			doorCube(g.Width, doorB, Exit{ToArea: a.Name, ToRoom: roomName(l[0]), ToCubeID: cubeID(g.Width, insideA.x, insideA.y)})
+		// Areas without locks roll no dice for them, and come out as they
+		// always did for their seed.
+		if g.Locks > 0 && r.Intn(100) < g.Locks {
+			sideA, sideB = lockDoor(sideA, g.Lock), lockDoor(sideB, g.Lock)
+		}
+		rooms[l[0]][doorA], rooms[l[1]][doorB] = sideA, sideB
+	}
+
+	exitDoor, entry, ok := pickDoor(floors[0], rooms[0], r)
+	if !ok {
+		return fmt.Errorf("area %q has no room for an entry", a.Name)
+	}
+	if g.Exit.ToArea != "" {
+		rooms[0][exitDoor] = doorCube(g.Width, exitDoor, g.Exit)
+	}
+
+	a.Rooms = make(map[string]Room)
+	for i := range rooms {
+		room := Room{
+			Name:        roomName(i),
+			Description: fmt.Sprintf("%s, part %d of %d.", a.Intro, i+1, g.Rooms),
+		}
+		for x := 0; x < g.Width; x++ {
+			for y := 0; y < g.Height; y++ {
+				if c, ok := rooms[i][cell{x, y}]; ok {
+					room.Cubes = append(room.Cubes, c)
+				}
+			}
+		}
+		a.Rooms[room.Name] = room
+	}
+
+	a.NPCs = nil
+	for i := range rooms {
+		for _, spawn := range g.Spawns {
+			if r.Intn(100) >= spawn.Chance {
+				continue
+			}
+			x, y := randomFloor(floors[i], r)
+			a.NPCs = append(a.NPCs, NPC{
+				Name:     spawn.Name,
+				Location: Location{Area: a.Name, Room: roomName(i), Position: cubeID(g.Width, x, y)},
+				PC:       game.PC{Level: spawn.Level},
+				Loot:     spawn.Loot,
+				Defenses: spawn.Defenses,
+				Blows:    spawn.Blows,
+				Pursuit:  spawn.Pursuit,
+			})
+		}
+	}
+
+	a.Entry = Exit{ToArea: a.Name, ToRoom: roomName(0), ToCubeID: cubeID(g.Width, entry.x, entry.y)}
+	return nil
+}
+
+func roomName(i int) string {
+	return strconv.Itoa(i + 1)
+}
+
+// cubeID numbers the cubes row by row. IDs start from 1 since 0 means no cube.
+func cubeID(width, x, y int) string {
+	return strconv.Itoa(y*width + x + 1)
+}
+
+func oddAtLeast(n, min int) int {
+	if n < min {
+		n = min
+	}
+	if n%2 == 0 {
+		n++
+	}
+	return n
+}
+
+func newGrid(width, height int) [][]bool {
+	grid := make([][]bool, width)
+	for x := range grid {
+		grid[x] = make([]bool, height)
+	}
+	return grid
+}
+
+func doorCube(width int, c cell, exit Exit) Cube {
+	return Cube{
+		ID:    cubeID(width, c.x, c.y),
+		POSX:  strconv.Itoa(c.x),
+		POSY:  strconv.Itoa(c.y),
+		Type:  "door",
+		Exits: []Exit{exit},
+	}
+}
+
+// lockDoor puts the lock on the door, and locks it.
+func lockDoor(door Cube, lock string) Cube {
+	door.Lock, door.Locked, door.Closed = lock, true, true
+	return door
+}
+
+// pickDoor picks a free cell on the outer ring of the room that touches the floor.
+// It returns the door cell and the floor cell right inside of it.
+func pickDoor(floor [][]bool, cubes map[cell]Cube, r *rand.Rand) (cell, cell, bool) {
+	width, height := len(floor), len(floor[0])
+	type candidate struct {
+		door, inside cell
+	}
+	candidates := []candidate{}
+	for x := 1; x < width-1; x++ {
+		candidates = append(candidates,
+			candidate{cell{x, 0}, cell{x, 1}},
+			candidate{cell{x, height - 1}, cell{x, height - 2}})
+	}
+	for y := 1; y < height-1; y++ {
+		candidates = append(candidates,
+			candidate{cell{0, y}, cell{1, y}},
+			candidate{cell{width - 1, y}, cell{width - 2, y}})
+	}
+
+	free := []candidate{}
+	for _, c := range candidates {
+		if _, taken := cubes[c.door]; taken {
+			continue
+		}
+		if inside, ok := cubes[c.inside]; !ok || inside.Type == "door" {
+			continue
+		}
+		free = append(free, c)
+	}
+	if len(free) == 0 {
+		return cell{}, cell{}, false
+	}
+	c := free[r.Intn(len(free))]
+	return c.door, c.inside, true
+}
+
+func randomFloor(floor [][]bool, r *rand.Rand) (int, int) {
+	cells := []cell{}
+	for x := range floor {
+		for y := range floor[x] {
+			if floor[x][y] {
+				cells = append(cells, cell{x, y})
+			}
+		}
+	}
+	c := cells[r.Intn(len(cells))]
+	return c.x, c.y
+}
+
+// carveMaze carves corridors between the odd cells of the grid.
+func carveMaze(width, height int, r *rand.Rand) [][]bool {
+	floor := newGrid(width, height)
+	stack := []cell{{1, 1}}
+	floor[1][1] = true
+
+	for len(stack) > 0 {
+		current := stack[len(stack)-1]
+		next := []cell{}
+		for _, d := range []cell{{2, 0}, {-2, 0}, {0, 2}, {0, -2}} {
+			n := cell{current.x + d.x, current.y + d.y}
+			if n.x > 0 && n.x < width-1 && n.y > 0 && n.y < height-1 && !floor[n.x][n.y] {
+				next = append(next, n)
+			}
+		}
+		if len(next) == 0 {
+			stack = stack[:len(stack)-1]
+			continue
+		}
+		n := next[r.Intn(len(next))]
+		floor[(current.x+n.x)/2][(current.y+n.y)/2] = true
+		floor[n.x][n.y] = true
+		stack = append(stack, n)
+	}
+	return floor
+}
+
+// growCave fills the room with noise and smooths it into caverns. Only the
+// biggest cavern is kept, so every floor cube can be reached.
+func growCave(width, height int, r *rand.Rand) [][]bool {
+	floor := newGrid(width, height)
+	for x := 1; x < width-1; x++ {
+		for y := 1; y < height-1; y++ {
+			floor[x][y] = r.Intn(100) >= 40
+		}
+	}
+
+	for step := 0; step < 4; step++ {
+		next := newGrid(width, height)
+		for x := 1; x < width-1; x++ {
+			for y := 1; y < height-1; y++ {
+				walls := 0
+				for dx := -1; dx <= 1; dx++ {
+					for dy := -1; dy <= 1; dy++ {
+						if (dx != 0 || dy != 0) && !floor[x+dx][y+dy] {
+							walls++
+						}
+					}
+				}
+				next[x][y] = walls < 5
+			}
+		}
+		floor = next
+	}
+
+	biggest := []cell{}
+	seen := newGrid(width, height)
+	for x := 1; x < width-1; x++ {
+		for y := 1; y < height-1; y++ {
+			if floor[x][y] && !seen[x][y] {
+				if region := floodFill(floor, seen, cell{x, y}); len(region) > len(biggest) {
+					biggest = region
+				}
+			}
+		}
+	}
+
+	// A cave that collapsed completely falls back to a plain chamber.
+	if len(biggest) < (width*height)/4 {
+		return buildChamber(width, height, r)
+	}
+
+	cave := newGrid(width, height)
+	for _, c := range biggest {
+		cave[c.x][c.y] = true
+	}
+	return cave
+}
+
+func floodFill(floor, seen [][]bool, start cell) []cell {
+	region := []cell{}
+	queue := []cell{start}
+	seen[start.x][start.y] = true
+	for len(queue) > 0 {
+		c := queue[0]
+		queue = queue[1:]
+		region = append(region, c)
+		for _, d := range []cell{{1, 0}, {-1, 0}, {0, 1}, {0, -1}} {
+			n := cell{c.x + d.x, c.y + d.y}
+			if n.x < 0 || n.y < 0 || n.x >= len(floor) || n.y >= len(floor[0]) {
+				continue
+			}
+			if floor[n.x][n.y] && !seen[n.x][n.y] {
+				seen[n.x][n.y] = true
+				queue = append(queue, n)
+			}
+		}
+	}
+	return region
+}
+
+// buildChamber lays out an open chamber with a few pillars on even cells, which
+// never cut the floor in two.
+func buildChamber(width, height int, r *rand.Rand) [][]bool {
+	floor := newGrid(width, height)
+	for x := 1; x < width-1; x++ {
+		for y := 1; y < height-1; y++ {
+			floor[x][y] = true
+		}
+	}
+	for x := 2; x < width-2; x += 2 {
+		for y := 2; y < height-2; y += 2 {
+			if r.Intn(100) < 15 {
+				floor[x][y] = false
+			}
+		}
+	}
+	return floor
+}
@@ -0,0 +1,8 @@
+package area
+
+// Highlight colours the given text wherever it shows up among the messages of
+// the player.
+type Highlight struct {
+	Text  string `toml:"text"`
+	Color string `toml:"color"`
+}
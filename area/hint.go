@@ -0,0 +1,33 @@
+package area
+
+import "strings"
+
+// Hint is a tip new players are shown the first time something happens to
+// them. What shows it is a kind of event, like "combat", "level" or
+// "death", and may name where it happens, like "arrive City/Market" or
+// "talk Innkeeper".
+type Hint struct {
+	Name string `toml:"name"`
+	On   string `toml:"on"`
+	Text string `toml:"text"`
+}
+
+// TutorialStep is a step of the guided tutorial: what the player is asked to
+// do, and the event that does it, in the same form as what shows a hint.
+type TutorialStep struct {
+	Text string `toml:"text"`
+	Done string `toml:"done"`
+}
+
+// Triggers reports whether the event, like "arrive City/Market", is what the
+// trigger waits for. Triggers leaving out the end of the event wait for any
+// such event, so "arrive" waits for arriving anywhere, and "arrive Crypt"
+// for arriving in any room of the Crypt or of its instances.
+func Triggers(trigger, event string) bool {
+	trigger, event = strings.ToLower(trigger), strings.ToLower(event)
+	if !strings.HasPrefix(event, trigger) {
+		return false
+	}
+	rest := event[len(trigger):]
+	return rest == "" || strings.ContainsAny(rest[:1], " /#")
+}
@@ -0,0 +1,334 @@
+package area
+
+import "strings"
+
+// HasItem reports whether the named item is among the belongings.
+func (b *Belongings) HasItem(name string) bool {
+	return b.findItem(name) >= 0
+}
+
+// AddItem puts the named item among the belongings.
+func (b *Belongings) AddItem(name string) {
+	b.Inventory = append(b.Inventory, name)
+}
+
+// RemoveItem takes one of the named items out of the belongings. It reports
+// whether there was one. Plain items go before those with state of their own.
+func (b *Belongings) RemoveItem(name string) bool {
+	i := b.findItem(name)
+	if i < 0 {
+		return false
+	}
+	b.Inventory = append(b.Inventory[:i], b.Inventory[i+1:]...)
+	if b.count(name) < len(b.instances(name)) {
+		b.dropInstance(name, false)
+	}
+	return true
+}
+
+// TakeItem takes one of the named items out of the belongings, along with
+// its state, to be given to someone else as it is. Plain items go before
+// those with state of their own, and items bound to their owner stay. It
+// reports whether there was one to take.
+func (b *Belongings) TakeItem(name string) (ItemInstance, bool) {
+	i := b.findItem(name)
+	if i < 0 {
+		return ItemInstance{}, false
+	}
+	item := ItemInstance{Item: b.Inventory[i]}
+	if b.Plain(name) == 0 {
+		var ok bool
+		if item, ok = b.dropInstance(name, true); !ok {
+			return ItemInstance{}, false
+		}
+	}
+	b.Inventory = append(b.Inventory[:i], b.Inventory[i+1:]...)
+	return item, true
+}
+
+// GiveItem puts the item among the belongings, along with its state.
+func (b *Belongings) GiveItem(item ItemInstance) {
+	b.AddItem(item.Item)
+	if item.HasState() {
+		b.Instances = append(b.Instances, item)
+	}
+}
+
+// Lay returns the items among the belongings as items lying at the location,
+// each with its state, for the owners alone to take.
+func (b *Belongings) Lay(at Location, owners ...string) []GroundItem {
+	states := append([]ItemInstance(nil), b.Instances...)
+	items := []GroundItem{}
+	for _, name := range b.Inventory {
+		item := ItemInstance{Item: name}
+		for i, state := range states {
+			if strings.EqualFold(state.Item, name) {
+				item = state
+				states = append(states[:i], states[i+1:]...)
+				break
+			}
+		}
+		items = append(items, GroundItem{ItemInstance: item, Location: at, Ownership: Ownership{Owners: owners}})
+	}
+	return items
+}
+
+// DropLoose takes all but the items bound to their owner out of the
+// belongings, and returns them. The gold stays.
+func (b *Belongings) DropLoose() Belongings {
+	loose, kept := Belongings{}, Belongings{Gold: b.Gold}
+	bound := map[string]int{}
+	for _, item := range b.Instances {
+		if item.Bound != "" {
+			kept.GiveItem(item)
+			bound[strings.ToLower(item.Item)]++
+		} else {
+			loose.Instances = append(loose.Instances, item)
+		}
+	}
+	for _, name := range b.Inventory {
+		if bound[strings.ToLower(name)] > 0 {
+			bound[strings.ToLower(name)]--
+			continue
+		}
+		loose.AddItem(name)
+	}
+	*b = kept
+	return loose
+}
+
+// Weight returns what the items among the belongings weigh all told, as
+// weigh tells it for each.
+func (b *Belongings) Weight(weigh func(name string) int) int {
+	total := 0
+	for _, name := range b.Inventory {
+		total += weigh(name)
+	}
+	return total
+}
+
+// Prototype returns the name of the prototype of the named item among the
+// belongings, which it may go by or have been named by its owner.
+func (b *Belongings) Prototype(name string) (string, bool) {
+	if i := b.findItem(name); i >= 0 {
+		return b.Inventory[i], true
+	}
+	for _, item := range b.Instances {
+		if item.Named(name) {
+			return item.Item, true
+		}
+	}
+	return "", false
+}
+
+// Plain returns how many of the named items among the belongings have no
+// state of their own.
+func (b *Belongings) Plain(name string) int {
+	return b.count(name) - len(b.instances(name))
+}
+
+// Instance returns the state of the named item: of the one its owner named
+// so, or else of one of the prototype of the name. Those named by their
+// owner are only picked by their prototype when there is no other. It is nil
+// for a plain item, or when there is none.
+func (b *Belongings) Instance(name string) *ItemInstance {
+	if i := b.pick(name); i >= 0 {
+		return &b.Instances[i]
+	}
+	return nil
+}
+
+// ChangeItem changes the state of the named item through change, which gets
+// the state of the one Instance picks, or none for a plain item. It reports
+// whether there was such an item.
+func (b *Belongings) ChangeItem(name string, change func(item *ItemInstance)) bool {
+	if i := b.pick(name); i >= 0 {
+		b.Instances = changeIn(b.Instances, i, change)
+		return true
+	}
+	i := b.findItem(name)
+	if i < 0 {
+		return false
+	}
+	b.Instances = changeIn(append(b.Instances, ItemInstance{Item: b.Inventory[i]}), len(b.Instances), change)
+	return true
+}
+
+// pick returns the index of the state of the named item Instance picks, or
+// -1 for a plain item or none.
+func (b *Belongings) pick(name string) int {
+	if i := instanceIn(b.Instances, name, false); i >= 0 || b.Plain(name) > 0 {
+		return i
+	}
+	return instanceIn(b.Instances, name, true)
+}
+
+// instanceIn returns the index of the state of the named item in the list,
+// or -1 when there is none. Items go by the name their owner gave them, and
+// by that of their prototype only when they have none or named may be.
+func instanceIn(list []ItemInstance, name string, named bool) int {
+	for i, item := range list {
+		if item.Name != "" && strings.EqualFold(item.Name, name) {
+			return i
+		}
+	}
+	for i, item := range list {
+		if (item.Name == "" || named) && strings.EqualFold(item.Item, name) {
+			return i
+		}
+	}
+	return -1
+}
+
+// changeIn changes the state at the index of the list, and drops it when
+// nothing is left of it.
+func changeIn(list []ItemInstance, i int, change func(item *ItemInstance)) []ItemInstance {
+	change(&list[i])
+	if !list[i].HasState() {
+		list = append(list[:i], list[i+1:]...)
+	}
+	return list
+}
+
+func (b *Belongings) findItem(name string) int {
+	for i, item := range b.Inventory {
+		if strings.EqualFold(item, name) {
+			return i
+		}
+	}
+	return -1
+}
+
+func (b *Belongings) count(name string) int {
+	n := 0
+	for _, item := range b.Inventory {
+		if strings.EqualFold(item, name) {
+			n++
+		}
+	}
+	return n
+}
+
+// instances returns the indexes of the states of the items of the
+// prototype.
+func (b *Belongings) instances(proto string) []int {
+	indexes := []int{}
+	for i, item := range b.Instances {
+		if strings.EqualFold(item.Item, proto) {
+			indexes = append(indexes, i)
+		}
+	}
+	return indexes
+}
+
+// dropInstance takes the state of the last of the items of the prototype off
+// the belongings, and returns it. Unless bound ones may go too, it leaves
+// those and reports whether there was another.
+func (b *Belongings) dropInstance(proto string, loose bool) (ItemInstance, bool) {
+	indexes := b.instances(proto)
+	for n := len(indexes) - 1; n >= 0; n-- {
+		i := indexes[n]
+		if loose && b.Instances[i].Bound != "" {
+			continue
+		}
+		item := b.Instances[i]
+		b.Instances = append(b.Instances[:i], b.Instances[i+1:]...)
+		return item, true
+	}
+	return ItemInstance{}, false
+}
+
+// Load returns what the player carries and has on weighs, as weigh tells it
+// for each item.
+func (p *Player) Load(weigh func(name string) int) int {
+	load := p.Weight(weigh)
+	for _, name := range []string{p.Weapon, p.Armor, p.OffHand} {
+		if name != "" {
+			load += weigh(name)
+		}
+	}
+	return load
+}
+
+// Wears reports whether the named item is the weapon, the armor or what the
+// player holds in their off hand.
+func (p *Player) Wears(name string) bool {
+	return name != "" && (strings.EqualFold(p.Weapon, name) || strings.EqualFold(p.Armor, name) || strings.EqualFold(p.OffHand, name))
+}
+
+// GearInstance returns the state of the named weapon or armor the player has
+// on, nil when it has none.
+func (p *Player) GearInstance(name string) *ItemInstance {
+	for i := range p.Gear {
+		if p.Gear[i].Named(name) && p.Wears(p.Gear[i].Item) {
+			return &p.Gear[i]
+		}
+	}
+	return nil
+}
+
+// ChangeGear changes the state of the named weapon or armor the player has
+// on through change, the way ChangeItem does items carried. It reports
+// whether the player has it on.
+func (p *Player) ChangeGear(name string, change func(item *ItemInstance)) bool {
+	for i := range p.Gear {
+		if p.Gear[i].Named(name) && p.Wears(p.Gear[i].Item) {
+			p.Gear = changeIn(p.Gear, i, change)
+			return true
+		}
+	}
+	if !p.Wears(name) {
+		return false
+	}
+	item := p.Weapon
+	if strings.EqualFold(p.Armor, name) {
+		item = p.Armor
+	} else if strings.EqualFold(p.OffHand, name) {
+		item = p.OffHand
+	}
+	p.Gear = changeIn(append(p.Gear, ItemInstance{Item: item}), len(p.Gear), change)
+	return true
+}
+
+// Equip takes the named item out of the belongings and puts it in the slot,
+// the weapon or the off hand of the player, along with its state. What was
+// in the slot goes back among the belongings. It reports whether the player
+// carried the item.
+func (p *Player) Equip(slot *string, name string) bool {
+	item, ok := ItemInstance{}, false
+	if i := p.pick(name); i >= 0 {
+		item, ok = p.Instances[i], true
+		p.Instances = append(p.Instances[:i], p.Instances[i+1:]...)
+	} else if i := p.findItem(name); i >= 0 {
+		item, ok = ItemInstance{Item: p.Inventory[i]}, true
+	}
+	if !ok {
+		return false
+	}
+	i := p.findItem(item.Item)
+	p.Inventory = append(p.Inventory[:i], p.Inventory[i+1:]...)
+	p.Unequip(slot)
+	*slot = item.Item
+	if item.HasState() {
+		p.Gear = append(p.Gear, item)
+	}
+	return true
+}
+
+// Unequip takes what is in the slot off the player, back among their
+// belongings with its state.
+func (p *Player) Unequip(slot *string) {
+	if *slot == "" {
+		return
+	}
+	item := ItemInstance{Item: *slot}
+	for i := range p.Gear {
+		if strings.EqualFold(p.Gear[i].Item, *slot) {
+			item = p.Gear[i]
+			p.Gear = append(p.Gear[:i], p.Gear[i+1:]...)
+			break
+		}
+	}
+	*slot = ""
+	p.GiveItem(item)
+}
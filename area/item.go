@@ -0,0 +1,101 @@
+package area
+
+import "strings"
+
+// The kinds of items that can be compared with the gear of players.
+const (
+	ItemWeapon = "weapon"
+	ItemArmor  = "armor"
+	ItemShield = "shield"
+)
+
+// ItemContainer is the kind of items that hold others, such as bags.
+const ItemContainer = "container"
+
+// ItemKey is the kind of items that open locks. They go on the keyring of
+// whoever gets them rather than among their belongings.
+const ItemKey = "key"
+
+// Item is the prototype of the items of a name: what they are all like, as
+// the items file has it. Items are carried by the name of their prototype,
+// and what they are like is looked up by it each time, so prototypes can be
+// loaded again while the items are in use. What a single item has of its
+// own is kept in an ItemInstance.
+type Item struct {
+	Name        string `toml:"name"`
+	Kind        string `toml:"kind"` // A weapon, armor, or anything else
+	Description string `toml:"description"`
+	Value       int    `toml:"value"`    // What the item is worth, in gold
+	Die         int    `toml:"die"`      // The damage die of a weapon
+	Armor       int    `toml:"armor"`    // What armor adds to the armor class
+	MaxDex      int    `toml:"maxdex"`   // How much of the dexterity modifier armor lets count
+	Sockets     int    `toml:"sockets"`  // How many gems the item takes
+	Binds       bool   `toml:"binds"`    // The item binds to the first player who gets it
+	Weight      int    `toml:"weight"`   // What the item weighs, in pounds
+	Capacity    int    `toml:"capacity"` // How many more pounds a container lets its owner carry
+	Opens       string `toml:"opens"`    // The lock a key opens
+	// Family is the family of a weapon, which its wielders get skilled in,
+	// and Blows the type of damage it deals.
+	Family string `toml:"family"`
+	Blows  string `toml:"blows"`
+	Speed  int    `toml:"speed"` // Seconds from one swing of a weapon to the next
+	Hands  int    `toml:"hands"` // Two-handed weapons leave no hand free
+	Block  int    `toml:"block"` // Percent of the blows a shield blocks
+	// Gem is what a gem adds to the item it is set in.
+	Gem ItemProperties `toml:"gem"`
+	// Hidden are the properties of the item that only show once the player
+	// identified it.
+	Hidden ItemProperties `toml:"hidden"`
+}
+
+// ItemProperties are what an item adds to whoever uses it.
+type ItemProperties struct {
+	Hit    int    `toml:"hit"`    // Added to the attack rolls
+	Damage int    `toml:"damage"` // Added to the damage dealt
+	AC     int    `toml:"ac"`     // Added to the armor class
+	Resist string `toml:"resist"` // A type of damage the item resists
+	// Immune is a type of damage the item wards off whole, and Vulnerable
+	// one it lets through twice over.
+	Immune     string `toml:"immune"`
+	Vulnerable string `toml:"vulnerable"`
+	Cursed     bool   `toml:"cursed"`
+	Lore       string `toml:"lore"` // What identifying the item tells of its past
+}
+
+// Any reports whether there is anything to the properties.
+func (p ItemProperties) Any() bool {
+	return p != ItemProperties{}
+}
+
+// ItemInstance is what a single item has of its own, besides what its
+// prototype gives every item of its name. Only items with something of their
+// own have one; the rest are the name of their prototype alone, and only
+// what an item has is saved.
+type ItemInstance struct {
+	Item    string   `toml:"item"`             // The name of its prototype
+	Name    string   `toml:"name,omitempty"`   // What its owner named it
+	Enchant int      `toml:"enchant,omitzero"` // How many enchantments took on it
+	Gems    []string `toml:"gems,omitempty"`   // The gems set in its sockets
+	Wear    int      `toml:"wear,omitzero"`    // How worn it is, until a smith mends it
+	Bound   string   `toml:"bound,omitempty"`  // The player it is bound to, who alone may have it
+}
+
+// HasState reports whether there is anything to the item beyond its
+// prototype.
+func (i ItemInstance) HasState() bool {
+	return i.Name != "" || i.Enchant > 0 || len(i.Gems) > 0 || i.Wear > 0 || i.Bound != ""
+}
+
+// Named reports whether the item goes by the name: its own, or that of its
+// prototype.
+func (i ItemInstance) Named(name string) bool {
+	return (i.Name != "" && strings.EqualFold(i.Name, name)) || strings.EqualFold(i.Item, name)
+}
+
+// Key is a key on a keyring, cut for the keying of the area it came from.
+// It stops fitting once the area is reset.
+type Key struct {
+	Item   string `toml:"item"` // The name of its prototype
+	Area   string `toml:"area"`
+	Keying int64  `toml:"keying,omitzero"`
+}
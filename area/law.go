@@ -0,0 +1,47 @@
+package area
+
+import "time"
+
+// The crimes the law of an area punishes.
+const (
+	CrimeTheft  = "theft"
+	CrimeMurder = "murder"
+)
+
+// Law is how an area deals with crime. Areas without a faction to keep the
+// law have none.
+type Law struct {
+	// Faction is the faction whose guards keep the law.
+	Faction string `toml:"faction"`
+	// Fines is the gold each crime costs, by crime. Crimes without a fine
+	// can only be served in jail.
+	Fines map[string]int `toml:"fines"`
+	// Jail is how many minutes in jail each crime costs, five unless set.
+	Jail int `toml:"jail"`
+	// Arrest is set where the guards arrest the wanted instead of fighting them.
+	Arrest bool `toml:"arrest"`
+	// Memory is how many minutes crimes are remembered, forever when zero.
+	Memory int `toml:"memory"`
+}
+
+// Sentence returns how long the crimes keep their doer in jail.
+func (l Law) Sentence(crimes int) time.Duration {
+	minutes := l.Jail
+	if minutes <= 0 {
+		minutes = 5
+	}
+	return time.Duration(minutes*crimes) * time.Minute
+}
+
+// Remembers reports whether the law still holds the crime against its doer
+// at the given time.
+func (l Law) Remembers(c Crime, now time.Time) bool {
+	return l.Memory <= 0 || now.Before(c.Time.Add(time.Duration(l.Memory)*time.Minute))
+}
+
+// Crime is a crime a player was seen doing.
+type Crime struct {
+	Area string    `toml:"area"`
+	Kind string    `toml:"kind"`
+	Time time.Time `toml:"time"`
+}
@@ -0,0 +1,17 @@
+package area
+
+// LootPrefs are how the player takes the loot of what they kill.
+type LootPrefs struct {
+	// Manual leaves the loot on the corpse, for the player to take with the
+	// loot command, rather than taking it at the kill.
+	Manual bool `toml:"manual"`
+	// Split shares the gold the player loots evenly with their group.
+	Split bool `toml:"split"`
+	// Rarity is the least rarity of the items the player takes at the kill,
+	// and Skip the kinds of items they don't. Those are left on the corpse.
+	Rarity string   `toml:"rarity"`
+	Skip   []string `toml:"skip"`
+	// Rule is how the groups the player leads share out loot: round-robin,
+	// need or greed rolls, or free for all when empty.
+	Rule string `toml:"rule"`
+}
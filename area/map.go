@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"fmt"
 	"strconv"
+	"time"
 
 	"github.com/droslean/thyranew/game"
 	log "gopkg.in/inconshreveable/log15.v2"
@@ -15,23 +16,185 @@ type Area struct {
 	Name  string          `toml:"name"`
 	Intro string          `toml:"intro"`
 	Rooms map[string]Room `toml:"rooms"`
+	NPCs  []NPC           `toml:"npcs"`
+	// Generator is set for procedural areas, whose rooms are built on load.
+	Generator Generator `toml:"generator"`
+	// Climate decides the weather of the outdoor rooms of the area.
+	Climate string `toml:"climate"`
+	// Arena is set for areas whose fights anyone can watch.
+	Arena bool `toml:"arena"`
+	// Law is how the area punishes crime.
+	Law Law `toml:"law"`
+	// MinLevel and MaxLevel are the levels of the players the area is for,
+	// unless zero.
+	MinLevel int `toml:"minlevel"`
+	MaxLevel int `toml:"maxlevel"`
+	// Entry is the cube players arrive at when they are sent to the area.
+	Entry Exit `toml:"-"`
+	// Keying tells the locks of the area from those it had before a reset.
+	// Keys are cut for the keying of the area they come from.
+	Keying int64 `toml:"-"`
 }
 
 type Room struct {
 	Name        string `toml:"name"`
 	Description string `toml:"description"`
 	Cubes       []Cube `toml:"cubes"`
+	Dark        bool   `toml:"dark"`     // Nobody can be seen in dark rooms from afar
+	Outdoors    bool   `toml:"outdoors"` // Outdoor rooms are exposed to the weather
+	Water       bool   `toml:"water"`    // Players have to swim across water rooms
+	Bind        bool   `toml:"bind"`     // Players can bind themselves to the room
+	Upkeep      int    `toml:"upkeep"`   // Gold a day those bound to the room pay for it
+	Flag        string `toml:"flag"`     // Content flag the room is open under
+	// Decoration is added to the description while a world event runs.
+	Decoration string `toml:"-"`
 }
 
 // Player holds all variables for a character.
 type Player struct {
 	Nickname string `toml:"nickname"`
+	Role     string `toml:"role"` // Admins can use the admin commands, builders reach the files of the world
 	game.PC
-	Area         string `toml:"area"`
-	Room         string `toml:"room"`
-	Position     string `toml:"position"`
+	Location
 	PreviousRoom string `toml:"previousRoom"`
 	PreviousArea string `toml:"previousArea"`
+	// Quests maps the quests the player has taken to their current state.
+	Quests map[string]string `toml:"quests"`
+	Belongings
+	XP int `toml:"xp"`
+	// Description is what others see when they look at the player.
+	Description string `toml:"description"`
+	// Title is worn after the name, picked among those of the achievements.
+	Title string `toml:"title"`
+	// Keywords are what others can call the player by, besides the name.
+	Keywords []string `toml:"keywords"`
+	// Achievements lists the names of the achievements the player earned.
+	Achievements []string `toml:"achievements"`
+	// Identified lists the names of the items the player identified, whose
+	// hidden properties they know.
+	Identified []string `toml:"identified"`
+	// Gear is what the weapon and the armor the player has on have of their
+	// own, for those that have anything.
+	Gear []ItemInstance `toml:"gear,omitempty"`
+	// OffHand is the weapon or the shield the player holds in their other
+	// hand.
+	OffHand string `toml:"offhand"`
+	// WeaponSkills counts the blows the player landed with each family of
+	// weapons, which they get better with.
+	WeaponSkills map[string]int `toml:"weaponskills"`
+	// Practices are what the player earned going up levels to spend at
+	// trainers, and Trained the ranks they learned there of each skill.
+	Practices int            `toml:"practices"`
+	Trained   map[string]int `toml:"trained"`
+	// Keyring holds the keys the player collected. They weigh nothing, and
+	// stay with the player through death and theft.
+	Keyring []Key `toml:"keyring,omitempty"`
+	// Ghost is set for dead players, until they come back to life.
+	Ghost bool `toml:"ghost"`
+	Bind  Exit `toml:"bind"` // Where the player respawns and recalls to
+	// LastRecall is when the player last recalled, for the cooldown.
+	LastRecall time.Time `toml:"lastrecall"`
+	// Lodging is until when the upkeep of the room the player is bound to is
+	// paid.
+	Lodging time.Time `toml:"lodging"`
+	// Wear is how worn the gear of the player was before the wear was kept
+	// on their armor. It is moved there when they enter the world.
+	Wear int `toml:"wear,omitzero"`
+	// LastSeen is when the player last logged out, and Rested the experience
+	// they earn on top of their kills for the time they were away.
+	LastSeen time.Time `toml:"lastseen"`
+	Rested   int       `toml:"rested"`
+	// Played is how long the player has played, in seconds, up to their
+	// last logout. LoggedIn is when they logged in this time.
+	Played   int       `toml:"played"`
+	LoggedIn time.Time `toml:"-"`
+	// Food and Drink are the survival meters, when the server has survival on.
+	Food  int `toml:"food"`
+	Drink int `toml:"drink"`
+	// Reputation maps factions to the standing of the player with them.
+	Reputation map[string]int `toml:"reputation"`
+	// Crimes are the crimes the player was seen doing, until punished.
+	Crimes []Crime `toml:"crimes"`
+	// Mail lists the mail whose attachments changed hands in this file, while
+	// the post office has yet to hear about it.
+	Mail []uint64 `toml:"mail"`
+	// Tags is set for players whose client wants the output tagged.
+	Tags bool `toml:"tags"`
+	// Prompt lists the widgets of the status line of the player, in order.
+	Prompt []string `toml:"prompt"`
+	// CombatLog is where the combat output of the player goes. Empty is verbose.
+	CombatLog string `toml:"combatlog"`
+	// Recording is set for players whose sessions are recorded.
+	Recording bool `toml:"recording"`
+	// Highlights and Gags are what the player colours and hides among the messages.
+	Highlights []Highlight `toml:"highlights"`
+	Gags       []string    `toml:"gags"`
+	// Scripts maps the names of the scripts of the player to their commands.
+	Scripts map[string]string `toml:"scripts"`
+	// Theme is the colour theme of the player, and ThemeColors the colours the
+	// player picked for the meanings of each theme, by theme and meaning.
+	Theme       string            `toml:"theme"`
+	ThemeColors map[string]string `toml:"themecolors"`
+	// Gender picks the pronouns of the player: male, female, or they when empty.
+	Gender string `toml:"gender"`
+	// Channels are the chat channels the player listens to, and Ignores the
+	// players whose chat they don't want to see.
+	Channels []string `toml:"channels"`
+	Ignores  []string `toml:"ignores"`
+	// Languages are the languages the player knows besides Common. Speaking is
+	// the one they chat in, Common when empty.
+	Languages []string `toml:"languages"`
+	Speaking  string   `toml:"speaking"`
+	// Mutes, Warnings and Jail are what the admins did about the player.
+	Mutes    []Mute    `toml:"mutes"`
+	Warnings []Warning `toml:"warnings"`
+	Jail     Jail      `toml:"jail"`
+	// Review is until when the admins review the player. Meanwhile even what
+	// the player says in private is logged.
+	Review time.Time `toml:"review"`
+	// Deleting is when the account of the player is deleted, since they asked
+	// for it. It is zero unless they did, or once they change their mind.
+	Deleting time.Time `toml:"deleting"`
+	// ClientInfo is what the client of the player can do.
+	ClientInfo ClientInfo `toml:"client"`
+	// Mouse is set for players who want to use the mouse, where their
+	// terminal reports it.
+	Mouse bool `toml:"mouse"`
+	// Flags are what the player consents to, and tells others of themselves.
+	Flags Flags `toml:"flags"`
+	// Loot is how the player takes the loot of what they kill.
+	Loot LootPrefs `toml:"loot"`
+	// Tactics are how the player fights.
+	Tactics Tactics `toml:"tactics"`
+	// HideRoll is the stealth roll of a hidden player, zero when not hidden.
+	HideRoll int  `toml:"-"`
+	Sneaking bool `toml:"-"`
+	// Talking is the ID of the NPC the player is dealing with, zero when none.
+	Talking      int    `toml:"-"`
+	DialogueNode string `toml:"-"` // Empty once the NPC has nothing more to say
+	Shop         string `toml:"-"` // Shop of the NPC open to the player
+	// Effects are the lasting conditions on the player.
+	Effects  []game.Effect `toml:"-"`
+	NextMove time.Time     `toml:"-"` // Earliest time the player can move again
+	Resting  string        `toml:"-"` // Whether the player is resting or sleeping
+	Welcomed bool          `toml:"-"` // Whether the player got the news of the login
+	// Parcel holds what the player attaches to the next mail.
+	Parcel     []string `toml:"-"`
+	ParcelGold int      `toml:"-"`
+	TaggedRoom string   `toml:"-"` // Last room sent to the client as tagged output
+	Watching   string   `toml:"-"` // Player whose fight the player is watching
+	Replaying  bool     `toml:"-"` // Whether a recording plays on the screen of the player
+	// Following is the player this one goes along with, and LastHostile when
+	// they last attacked or robbed another player.
+	Following   string    `toml:"-"`
+	LastHostile time.Time `toml:"-"`
+	LastTaunt   time.Time `toml:"-"` // When the player last taunted an NPC
+	LastFlee    time.Time `toml:"-"` // When the player last failed to flee
+	LastAttack  time.Time `toml:"-"` // When the player last attacked, for the global cooldown
+	Swinging    int       `toml:"-"` // ID of the NPC the player fights hand to hand, zero when none
+	// TaggedCooldowns are when the cooldowns last sent to the client as
+	// tagged output started, by name.
+	TaggedCooldowns map[string]time.Time `toml:"-"`
 }
 
 type Cube struct {
@@ -40,6 +203,12 @@ type Cube struct {
 	POSY  string `toml:"posy"`
 	Exits []Exit `toml:"exits"`
 	Type  string `toml:"type"`
+	// Closed doors cannot be walked or seen through.
+	Closed bool `toml:"closed"`
+	// Lock names the lock of a door, which only keys made for it open.
+	// Locked doors are closed too, and have to be unlocked to open.
+	Lock   string `toml:"lock"`
+	Locked bool   `toml:"locked"`
 }
 
 type Exit struct {
@@ -92,6 +261,9 @@ func FindExits(s [][]Cube, area, room, pos string) [][]string {
 						exitarr[0][1] = s[x+1][y].Exits[0].ToCubeID
 						exitarr[0][2] = s[x+1][y].Exits[0].ToRoom
 						exitarr[0][3] = "door"
+						if s[x+1][y].Closed {
+							exitarr[0][3] = "closed"
+						}
 					} else {
 						exitarr[0][1] = s[x+1][y].ID //EAST
 					}
@@ -103,6 +275,9 @@ func FindExits(s [][]Cube, area, room, pos string) [][]string {
 						exitarr[1][1] = s[x-1][y].Exits[0].ToCubeID
 						exitarr[1][2] = s[x-1][y].Exits[0].ToRoom
 						exitarr[1][3] = "door"
+						if s[x-1][y].Closed {
+							exitarr[1][3] = "closed"
+						}
 					} else {
 						exitarr[1][1] = s[x-1][y].ID //WEST
 					}
@@ -114,6 +289,9 @@ func FindExits(s [][]Cube, area, room, pos string) [][]string {
 						exitarr[2][1] = s[x][y-1].Exits[0].ToCubeID
 						exitarr[2][2] = s[x][y-1].Exits[0].ToRoom
 						exitarr[2][3] = "door"
+						if s[x][y-1].Closed {
+							exitarr[2][3] = "closed"
+						}
 					} else {
 						exitarr[2][1] = s[x][y-1].ID //NORTH
 					}
@@ -125,6 +303,9 @@ func FindExits(s [][]Cube, area, room, pos string) [][]string {
 						exitarr[3][1] = s[x][y+1].Exits[0].ToCubeID
 						exitarr[3][2] = s[x][y+1].Exits[0].ToRoom
 						exitarr[3][3] = "door"
+						if s[x][y+1].Closed {
+							exitarr[3][3] = "closed"
+						}
 					} else {
 						exitarr[3][1] = s[x][y+1].ID //SOUTH
 					}
@@ -136,11 +317,36 @@ func FindExits(s [][]Cube, area, room, pos string) [][]string {
 	// First field denotes direction:
 	// [0] East, [1] West, [2] North, [3] South
 	// Second array holds the cube we will end up following the direction
-	// [][0] ToArea, [][1] ToCubeID, [][2] ToRoom
+	// [][0] ToArea, [][1] ToCubeID, [][2] ToRoom, [][3] cube, door or closed
 
 	return exitarr
 }
 
+// FindCube returns the coordinates of the cube with the given ID.
+func FindCube(s [][]Cube, id string) (int, int, bool) {
+	for x := range s {
+		for y := range s[x] {
+			if s[x][y].ID == id {
+				return x, y, true
+			}
+		}
+	}
+	return 0, 0, false
+}
+
+// Doors returns the coordinates of all the doors of the room.
+func Doors(s [][]Cube) [][2]int {
+	doors := [][2]int{}
+	for x := range s {
+		for y := range s[x] {
+			if s[x][y].Type == "door" && len(s[x][y].Exits) > 0 {
+				doors = append(doors, [2]int{x, y})
+			}
+		}
+	}
+	return doors
+}
+
 // Print Available Movement
 func PrintExits(exit_array [][]string) bytes.Buffer {
 	var buffer bytes.Buffer
@@ -167,9 +373,21 @@ func PrintExits(exit_array [][]string) bytes.Buffer {
 	return buffer
 }
 
-func PlayerCentricMap(p *Player, online map[string]bool, s [][]Cube) bytes.Buffer {
+// PlayerCentricMap draws the room around the player. Players are drawn where
+// they stand, and so are the things with a symbol of their own.
+func PlayerCentricMap(p *Player, online map[string]bool, symbols map[string]string, s [][]Cube) bytes.Buffer {
+	buffer, _ := PlayerCentricMapCubes(p, online, symbols, s)
+	return buffer
+}
+
+// PlayerCentricMapCubes draws the room around the player like
+// PlayerCentricMap, and also returns the ID of the cube under every rune of
+// each line of the map, empty for walls.
+func PlayerCentricMapCubes(p *Player, online map[string]bool, symbols map[string]string, s [][]Cube) (bytes.Buffer, [][]string) {
 	var buffer bytes.Buffer
 	var buffer2 bytes.Buffer
+	cubes := [][]string{}
+	line := []string{}
 
 	r := 8
 	px := 0
@@ -198,26 +416,38 @@ func PlayerCentricMap(p *Player, online map[string]bool, s [][]Cube) bytes.Buffe
 			// Calculating radius in Square shape.
 			if x1 >= px-r && x1 <= px+r && y1 >= py-r && y1 <= py+r {
 
+				cell := ""
 				current, ok := online[s[x1][y1].ID]
 				switch {
 				case s[x1][y1].Type == "door":
-					buffer.WriteString(string(ansi.Attribute(398)))
+					cell = string(ansi.Attribute(398))
+				case s[x1][y1].Type == "portal" && !ok:
+					cell = string(ansi.Attribute(937))
 				case ok && current:
-					buffer.WriteString(string(ansi.Attribute(198)))
+					cell = string(ansi.Attribute(198))
 				case ok && !current:
-					buffer.WriteString(string(ansi.Attribute(165)))
+					cell = string(ansi.Attribute(165))
+				case symbols[s[x1][y1].ID] != "":
+					cell = symbols[s[x1][y1].ID]
 				case s[x1][y1].ID == "":
-					if hasEmptyNeighbours(s, x1, y1) {
-						buffer.WriteString("")
-					} else {
-						buffer.WriteString(string(ansi.Attribute(182)))
+					if !hasEmptyNeighbours(s, x1, y1) {
+						cell = string(ansi.Attribute(182))
 					}
 				default:
-					buffer.WriteString(string(ansi.Attribute(183)))
+					cell = string(ansi.Attribute(183))
+				}
+				buffer.WriteString(cell)
+				for range cell {
+					line = append(line, s[x1][y1].ID)
 				}
 			}
 		}
 		buffer.WriteString("\n")
+		// Lines with nothing drawn on them are left out below.
+		if len(line) > 0 {
+			cubes = append(cubes, line)
+			line = []string{}
+		}
 	}
 
 	// Clear empty lines.
@@ -228,7 +458,7 @@ func PlayerCentricMap(p *Player, online map[string]bool, s [][]Cube) bytes.Buffe
 			buffer2.WriteString(line)
 		}
 	}
-	return buffer2
+	return buffer2, cubes
 
 }
 
@@ -267,6 +497,9 @@ func PrintIntro(room Room) bytes.Buffer {
 	var buffer bytes.Buffer
 	buffer.WriteString("| " + room.Name + " |\n\n")
 	buffer.WriteString(room.Description)
+	if room.Decoration != "" {
+		buffer.WriteString(room.Decoration + "\n")
+	}
 	return buffer
 }
 
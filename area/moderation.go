@@ -0,0 +1,76 @@
+package area
+
+import "time"
+
+// AllChannels mutes a player everywhere: on every channel, and in socials.
+const AllChannels = "all"
+
+// Mute keeps a player from talking on a channel, or everywhere, for a while.
+type Mute struct {
+	Channel string    `toml:"channel"`
+	Until   time.Time `toml:"until"` // Zero for a mute that lasts until lifted
+	By      string    `toml:"by"`
+	Reason  string    `toml:"reason"`
+}
+
+// Active reports whether the mute still holds at the given time.
+func (m Mute) Active(now time.Time) bool {
+	return m.Until.IsZero() || now.Before(m.Until)
+}
+
+// Warning is a formal warning an admin gave the player.
+type Warning struct {
+	Time   time.Time `toml:"time"`
+	By     string    `toml:"by"`
+	Reason string    `toml:"reason"`
+}
+
+// Jail holds a jailed player, and where they go once released.
+type Jail struct {
+	Jailed bool      `toml:"jailed"`
+	Until  time.Time `toml:"until"` // Zero for a sentence that lasts until release
+	Return Exit      `toml:"return"`
+	By     string    `toml:"by"`
+	Reason string    `toml:"reason"`
+}
+
+// Served reports whether the sentence is over at the given time.
+func (j Jail) Served(now time.Time) bool {
+	return j.Jailed && !j.Until.IsZero() && !now.Before(j.Until)
+}
+
+// Escalation is a rule that punishes players once they gather enough
+// warnings.
+type Escalation struct {
+	Warnings int    `toml:"warnings"` // Warnings that set the rule off
+	Days     int    `toml:"days"`     // Only warnings of the last days count; zero counts all
+	Action   string `toml:"action"`   // "mute" or "jail"
+	Minutes  int    `toml:"minutes"`  // How long the punishment lasts; zero until lifted
+}
+
+// MutedOn returns the mute that keeps the player from talking on the
+// channel, if any. Global mutes hold on every channel.
+func (p *Player) MutedOn(channel string, now time.Time) (Mute, bool) {
+	for _, m := range p.Mutes {
+		if (m.Channel == channel || m.Channel == AllChannels) && m.Active(now) {
+			return m, true
+		}
+	}
+	return Mute{}, false
+}
+
+// WarningsSince counts the warnings the player got from the given time on.
+func (p *Player) WarningsSince(since time.Time) int {
+	n := 0
+	for _, w := range p.Warnings {
+		if !w.Time.Before(since) {
+			n++
+		}
+	}
+	return n
+}
+
+// UnderReview reports whether the admins review the player at the given time.
+func (p *Player) UnderReview(now time.Time) bool {
+	return now.Before(p.Review)
+}
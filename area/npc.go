@@ -0,0 +1,59 @@
+package area
+
+import "github.com/droslean/thyranew/game"
+
+// NPC holds the definition of a non-player character placed in an area,
+// along with its state while the server runs. The Area of its location is
+// filled in when the area is loaded.
+type NPC struct {
+	Name string `toml:"name"`
+	Location
+	// The level of the stats is set, while HP and AC default to values based
+	// on it.
+	game.PC
+	AI
+	Look
+	Loot    string `toml:"loot"` // Name of the loot table rolled when the NPC dies
+	MaxHP   int    `toml:"-"`    // HP of the NPC before any fight
+	Luck    int    `toml:"-"`    // Percent its loot leans to rarer items by
+	Faction string `toml:"faction"`
+	// Reputation is the standing lost with the faction of the NPC by its killer.
+	Reputation int  `toml:"reputation"`
+	Guard      bool `toml:"guard"` // Guards attack players hostile to their faction on sight
+	Boss       bool `toml:"boss"`  // Fights with bosses can be watched from anywhere
+	// Pursuit is how many rooms away the NPC stops chasing players, when it
+	// gives up sooner or later than others.
+	Pursuit int `toml:"pursuit"`
+	// Defenses are the types of damage the NPC resists, is vulnerable to and
+	// is immune to.
+	game.Defenses
+	// Blows is the type of damage the blows of the NPC deal, bludgeoning
+	// unless set.
+	Blows string `toml:"blows"`
+	// Gender is male or female. NPCs without one are spoken of as they.
+	Gender string `toml:"gender"`
+	// Flag is the content flag the NPC is around under.
+	Flag string `toml:"flag"`
+	// Teaches are the skills the NPC trains players in, for those of their
+	// class that learn them.
+	Teaches []string `toml:"teaches"`
+	// Effects are the lasting conditions players put on the NPC.
+	Effects []game.Effect `toml:"-"`
+
+	ID    int    `toml:"-"` // Identifies the NPC while the server runs
+	UID   UID    `toml:"-"` // Identifies the NPC across restarts
+	Event string `toml:"-"` // World event that spawned the NPC, if any
+}
+
+// ScheduleEntry places an NPC somewhere between two hours of the day. NPCs walk
+// from room to room to reach the place of their current entry.
+type ScheduleEntry struct {
+	From     int      `toml:"from"` // Hour the entry starts
+	To       int      `toml:"to"`   // Hour the entry ends, may be past midnight
+	Room     string   `toml:"room"`
+	Position string   `toml:"position"`
+	Patrol   []string `toml:"patrol"` // Rooms walked in turn, instead of staying in one
+	// Activity is what the NPC is doing. Sleeping NPCs do not talk and closed
+	// ones do not trade.
+	Activity string `toml:"activity"`
+}
@@ -0,0 +1,76 @@
+package area
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// UID identifies a thing of the world for good. The server hands UIDs out and
+// keeps them, so a thing has the same UID across restarts. Zero is no UID.
+type UID uint64
+
+// Kinds of things with a UID.
+const (
+	KindItem = "item" // Items are identified by their name, not each copy
+	KindNPC  = "npc"
+	KindRoom = "room"
+)
+
+// Ref refers to a thing of the world. It is written as the kind and the UID,
+// e.g. npc#12, which is how quests, mail and scripts keep it.
+type Ref struct {
+	Kind string
+	UID  UID
+}
+
+func (r Ref) String() string {
+	return fmt.Sprintf("%s#%d", r.Kind, r.UID)
+}
+
+// IsZero reports whether the ref refers to nothing.
+func (r Ref) IsZero() bool {
+	return r.UID == 0
+}
+
+// ParseRef reads a ref written as kind#uid.
+func ParseRef(s string) (Ref, error) {
+	i := strings.Index(s, "#")
+	if i < 0 {
+		return Ref{}, fmt.Errorf("%q is not a ref", s)
+	}
+	kind := strings.ToLower(s[:i])
+	switch kind {
+	case KindItem, KindNPC, KindRoom:
+	default:
+		return Ref{}, fmt.Errorf("unknown kind %q", kind)
+	}
+	uid, err := strconv.ParseUint(s[i+1:], 10, 64)
+	if err != nil || uid == 0 {
+		return Ref{}, fmt.Errorf("%q is not a UID", s[i+1:])
+	}
+	return Ref{Kind: kind, UID: UID(uid)}, nil
+}
+
+// MarshalText writes the ref the way ParseRef reads it, so refs can be kept in
+// TOML and JSON.
+func (r Ref) MarshalText() ([]byte, error) {
+	if r.IsZero() {
+		return []byte{}, nil
+	}
+	return []byte(r.String()), nil
+}
+
+// UnmarshalText reads a ref written by MarshalText.
+func (r *Ref) UnmarshalText(text []byte) error {
+	if len(text) == 0 {
+		*r = Ref{}
+		return nil
+	}
+	ref, err := ParseRef(string(text))
+	if err != nil {
+		return err
+	}
+	*r = ref
+	return nil
+}
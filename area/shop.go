@@ -0,0 +1,16 @@
+package area
+
+// Shop is a list of items an NPC sells.
+type Shop struct {
+	Name     string `toml:"name"`
+	Faction  string `toml:"faction"`  // Only friends of the faction are served
+	Standing int    `toml:"standing"` // Minimum standing with the faction
+	Wares    []Ware `toml:"wares"`
+}
+
+// Ware is an item for sale.
+type Ware struct {
+	Item  string `toml:"item"`
+	Price int    `toml:"price"`
+	Flag  string `toml:"flag"` // Content flag the ware is sold under
+}
@@ -0,0 +1,28 @@
+package area
+
+// SkillTable is what the characters of a class can learn from trainers.
+type SkillTable struct {
+	Class  string   `toml:"class"`
+	Skills []Lesson `toml:"skills"`
+}
+
+// Lesson is a skill a class can learn, and what learning it costs. Every rank
+// costs as much more as the first.
+type Lesson struct {
+	Skill     string `toml:"skill"`
+	Level     int    `toml:"level"`     // Level the player has to be to learn it
+	Ranks     int    `toml:"ranks"`     // Most ranks the player can learn
+	Practices int    `toml:"practices"` // Practices the first rank costs
+	Gold      int    `toml:"gold"`      // Gold the first rank costs
+}
+
+// Lesson returns what the class learns of the skill, and whether it can
+// learn it at all.
+func (t SkillTable) Lesson(skill string) (Lesson, bool) {
+	for _, l := range t.Skills {
+		if l.Skill == skill {
+			return l, true
+		}
+	}
+	return Lesson{}, false
+}
@@ -0,0 +1,9 @@
+package area
+
+// Social is an emote players can do on their own or at someone. Its messages
+// are templates, which everyone reads from where they stand.
+type Social struct {
+	Name   string `toml:"name"`
+	Alone  string `toml:"alone"`
+	Target string `toml:"target"`
+}
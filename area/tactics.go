@@ -0,0 +1,12 @@
+package area
+
+// Tactics are how the player fights, which the combat engine follows each
+// round.
+type Tactics struct {
+	Stance string `toml:"stance"` // The stance the player fights in, normal when empty
+	// Wimpy is the share of their hit points, in percent, under which the
+	// player flees the fight. Zero never flees.
+	Wimpy  int  `toml:"wimpy"`
+	Assist bool `toml:"assist"` // Join the fights of the group
+	Rescue bool `toml:"rescue"` // Take the blows aimed at members of the group in trouble
+}
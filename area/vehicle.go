@@ -0,0 +1,27 @@
+package area
+
+// Vehicle is an object players can board, like a ship or a wagon. Its inside
+// is an area of its own, which stays the same wherever the vehicle goes, while
+// the vehicle itself moves across the wilderness or from cube to cube of rooms.
+type Vehicle struct {
+	Name  string `toml:"name"`
+	Area  string `toml:"area"`  // Area holding the rooms inside the vehicle
+	Deck  string `toml:"deck"`  // Room players board at and pilot the vehicle from
+	Hatch string `toml:"hatch"` // Cube of the deck players board at
+	// Terrain lists the terrain of the wilderness the vehicle can move on.
+	// Vehicles without terrain stay out of the wilderness.
+	Terrain  []string `toml:"terrain"`
+	Location Exit     `toml:"location"` // Where the vehicle stands
+
+	Pilot string `toml:"-"` // Nickname of the player at the helm
+}
+
+// CanCross reports whether the vehicle can move on the given terrain.
+func (v *Vehicle) CanCross(t Terrain) bool {
+	for _, name := range v.Terrain {
+		if name == t.Name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,193 @@
+package area
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+
+	"github.com/jpillora/ansi"
+)
+
+// WildernessRoom is the room of every player walking in a wilderness. The
+// position of those players is the ID of the cell they stand on.
+const WildernessRoom = "overland"
+
+// Wilderness is a large grid of terrain, loaded from a plain text map where
+// every character is a cell. Unlike rooms, it has no cubes; cells are found by
+// their coordinates and their ID is y*width + x + 1.
+type Wilderness struct {
+	Name    string     `toml:"name"`
+	Intro   string     `toml:"intro"`
+	MapFile string     `toml:"map"`
+	Climate string     `toml:"climate"`
+	Terrain []Terrain  `toml:"terrain"`
+	Links   []WildLink `toml:"links"`
+
+	width, height int
+	cells         [][]rune // [y][x]
+	terrain       map[rune]Terrain
+}
+
+// Terrain describes the cells drawn with the given symbol.
+type Terrain struct {
+	Symbol  string `toml:"symbol"`
+	Name    string `toml:"name"`
+	Blocked bool   `toml:"blocked"`
+	Water   bool   `toml:"water"` // Players have to swim across water
+}
+
+// WildLink leads from a cell of the wilderness into a room-based area.
+type WildLink struct {
+	X        int    `toml:"x"`
+	Y        int    `toml:"y"`
+	ToArea   string `toml:"toarea"`
+	ToRoom   string `toml:"toroom"`
+	ToCubeID string `toml:"tocubeid"`
+}
+
+// SetMap parses the text of the terrain map. Short lines are padded with
+// blocked cells, so the grid is always a rectangle.
+func (w *Wilderness) SetMap(text string) {
+	lines := strings.Split(strings.TrimRight(text, "\n"), "\n")
+	w.height = len(lines)
+	w.width = 0
+	for _, line := range lines {
+		if n := len([]rune(line)); n > w.width {
+			w.width = n
+		}
+	}
+
+	w.cells = make([][]rune, w.height)
+	for y, line := range lines {
+		w.cells[y] = make([]rune, w.width)
+		for x := range w.cells[y] {
+			w.cells[y][x] = ' '
+		}
+		copy(w.cells[y], []rune(line))
+	}
+
+	w.terrain = make(map[rune]Terrain)
+	for _, t := range w.Terrain {
+		if r := []rune(t.Symbol); len(r) > 0 {
+			w.terrain[r[0]] = t
+		}
+	}
+}
+
+// CellID returns the ID of the cell at the given coordinates.
+func (w *Wilderness) CellID(x, y int) string {
+	return strconv.Itoa(y*w.width + x + 1)
+}
+
+// Cell returns the coordinates of the cell with the given ID.
+func (w *Wilderness) Cell(id string) (int, int, bool) {
+	n, err := strconv.Atoi(id)
+	if err != nil || n < 1 || n > w.width*w.height {
+		return 0, 0, false
+	}
+	return (n - 1) % w.width, (n - 1) / w.width, true
+}
+
+// TerrainAt returns the terrain of the cell at the given coordinates.
+func (w *Wilderness) TerrainAt(x, y int) (Terrain, bool) {
+	if x < 0 || y < 0 || x >= w.width || y >= w.height {
+		return Terrain{}, false
+	}
+	t, ok := w.terrain[w.cells[y][x]]
+	return t, ok
+}
+
+// Passable reports whether the cell at the given coordinates can be walked on.
+// Cells with unknown symbols are treated as blocked.
+func (w *Wilderness) Passable(x, y int) bool {
+	t, ok := w.TerrainAt(x, y)
+	return ok && !t.Blocked
+}
+
+// LinkAt returns the link leaving the wilderness from the given cell, if any.
+func (w *Wilderness) LinkAt(x, y int) (WildLink, bool) {
+	for _, l := range w.Links {
+		if l.X == x && l.Y == y {
+			return l, true
+		}
+	}
+	return WildLink{}, false
+}
+
+// Step returns the coordinates reached from the given cell following the
+// direction: [0] East, [1] West, [2] North, [3] South.
+func Step(x, y, direction int) (int, int) {
+	switch direction {
+	case 0:
+		return x + 1, y
+	case 1:
+		return x - 1, y
+	case 2:
+		return x, y - 1
+	case 3:
+		return x, y + 1
+	}
+	return x, y
+}
+
+// FindExits returns the available movement from the given cell, in the same
+// form as the exits of rooms so they can be printed by PrintExits.
+func (w *Wilderness) FindExits(pos string) [][]string {
+	exits := [][]string{}
+	x, y, ok := w.Cell(pos)
+	for direction := 0; direction < 4; direction++ {
+		exit := []string{w.Name, "0", WildernessRoom, "cube"}
+		if nx, ny := Step(x, y, direction); ok && w.Passable(nx, ny) {
+			exit[1] = w.CellID(nx, ny)
+			if _, isLink := w.LinkAt(nx, ny); isLink {
+				exit[3] = "door"
+			}
+		}
+		exits = append(exits, exit)
+	}
+	return exits
+}
+
+// Render draws the part of the wilderness around the given cell, so the map
+// scrolls along with the player. Occupied cells are drawn like in rooms.
+func (w *Wilderness) Render(pos string, radius int, online map[string]bool) bytes.Buffer {
+	var buffer bytes.Buffer
+
+	px, py, _ := w.Cell(pos)
+	for y := py - radius; y <= py+radius; y++ {
+		for x := px - radius; x <= px+radius; x++ {
+			if x < 0 || y < 0 || x >= w.width || y >= w.height {
+				buffer.WriteString(" ")
+				continue
+			}
+
+			current, ok := online[w.CellID(x, y)]
+			_, isLink := w.LinkAt(x, y)
+			switch {
+			case ok && current:
+				buffer.WriteString(string(ansi.Attribute(198)))
+			case ok && !current:
+				buffer.WriteString(string(ansi.Attribute(165)))
+			case isLink:
+				buffer.WriteString(string(ansi.Attribute(398)))
+			default:
+				buffer.WriteRune(w.cells[y][x])
+			}
+		}
+		buffer.WriteString("\n")
+	}
+	return buffer
+}
+
+// PrintIntro prints the name of the wilderness and the terrain the player stands on.
+func (w *Wilderness) PrintIntro(pos string) bytes.Buffer {
+	var buffer bytes.Buffer
+	buffer.WriteString("| " + w.Name + " |\n\n")
+	buffer.WriteString(w.Intro + "\n")
+	if x, y, ok := w.Cell(pos); ok {
+		if t, ok := w.TerrainAt(x, y); ok {
+			buffer.WriteString("You are walking through " + t.Name + ".\n")
+		}
+	}
+	return buffer
+}
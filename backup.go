@@ -0,0 +1,47 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/droslean/thyranew/server"
+)
+
+// backup archives the database along with the static and data directories.
+// The server has to be stopped, since it keeps the database to itself.
+func backup(args []string) int {
+	fs := newFlags("backup", "")
+	static := staticFlag(fs)
+	dbPath, dataDir := dataFlags(fs)
+	out := fs.String("o", "", "Archive to write, thyra-<date>.tar.gz unless set")
+	fs.Parse(args)
+	quiet()
+
+	if *out == "" {
+		*out = fmt.Sprintf("thyra-%s.tar.gz", time.Now().Format("20060102-150405"))
+	}
+	if *dataDir == "" {
+		*dataDir = staticDir(*static)
+	}
+	db, err := server.OpenDatabase(*dbPath, true)
+	if err != nil {
+		return fail(err)
+	}
+	defer db.Close()
+
+	f, err := os.Create(*out)
+	if err != nil {
+		return fail(err)
+	}
+	if err := server.Backup(f, db, staticDir(*static), *dataDir); err != nil {
+		f.Close()
+		os.Remove(*out)
+		return fail(err)
+	}
+	if err := f.Close(); err != nil {
+		return fail(err)
+	}
+	fmt.Printf("Backed up to %s\n", *out)
+	return 0
+}
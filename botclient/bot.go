@@ -0,0 +1,295 @@
+// Package botclient plays scripted players against a running server, so the
+// load the event loop and the renderer can take is measured rather than
+// guessed.
+package botclient
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jpillora/ansi"
+	"golang.org/x/crypto/ssh"
+
+	log "gopkg.in/inconshreveable/log15.v2"
+)
+
+// Pattern is what a bot does. It runs the setup once it is in, and then the
+// loop over and over. "$bot" in a command is the name of the bot.
+type Pattern struct {
+	Setup []string
+	Loop  []string
+}
+
+// Patterns are the patterns bots can play.
+var Patterns = map[string]Pattern{
+	"walk": {
+		Loop: []string{"north", "east", "south", "west"},
+	},
+	"chat": {
+		Setup: []string{"channel join bots"},
+		Loop:  []string{"chat bots $bot is here", "say hello", "time"},
+	},
+	"combat": {
+		Loop: []string{"cast magic missile goblin", "cast firebolt goblin", "scan"},
+	},
+	"mixed": {
+		Setup: []string{"channel join bots"},
+		Loop:  []string{"north", "chat bots $bot is here", "cast magic missile goblin", "south", "say hello", "scan"},
+	},
+}
+
+// Config is the load to put on the server.
+type Config struct {
+	Addr     string        // Address of the server, host:port
+	Bots     int           // How many bots play at once
+	Prefix   string        // Names of the bots are the prefix and a number
+	Pattern  string        // Name of the pattern the bots play
+	Duration time.Duration // How long the bots play
+	Interval time.Duration // Time between the commands of each bot
+	Timeout  time.Duration // How long a bot waits for the answer to a command
+	Width    int           // Size of the terminal of the bots
+	Height   int
+}
+
+// keyDelay is the time between the keys a bot types.
+const keyDelay = 5 * time.Millisecond
+
+// frameMark starts every frame the server draws. A command is answered once
+// the frame after it comes in.
+var frameMark = append(append([]byte{}, ansi.CursorHide...), ansi.Goto(0, 0)...)
+
+// Run lets the bots play against the server, and reports how it went.
+func Run(cfg Config) (*Report, error) {
+	pattern, ok := Patterns[cfg.Pattern]
+	if !ok {
+		return nil, fmt.Errorf("unknown pattern %q", cfg.Pattern)
+	}
+	if cfg.Bots <= 0 {
+		return nil, fmt.Errorf("need at least one bot")
+	}
+
+	report := newReport()
+	var wg sync.WaitGroup
+	start := time.Now()
+	for i := 1; i <= cfg.Bots; i++ {
+		wg.Add(1)
+		go func(name string) {
+			defer wg.Done()
+			b, err := dial(cfg, name)
+			if err != nil {
+				log.Warn(fmt.Sprintf("Bot %s cannot connect: %v", name, err))
+				report.failed()
+				return
+			}
+			defer b.close()
+			b.play(cfg, pattern, start.Add(cfg.Duration), report)
+		}(fmt.Sprintf("%s%d", cfg.Prefix, i))
+		// Logins are spread out a little, so the handshakes don't all land
+		// on the server at once.
+		time.Sleep(10 * time.Millisecond)
+	}
+	wg.Wait()
+	report.Elapsed = time.Since(start)
+	return report, nil
+}
+
+// bot is one scripted player.
+type bot struct {
+	name    string
+	client  *ssh.Client
+	session *ssh.Session
+	stdin   io.WriteCloser
+	frames  chan struct{} // Gets a value whenever a frame comes in
+	read    chan int      // Gets the number of bytes of each read
+}
+
+// dial connects a bot to the server and starts its shell.
+func dial(cfg Config, name string) (*bot, error) {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	signer, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		return nil, err
+	}
+	client, err := ssh.Dial("tcp", cfg.Addr, &ssh.ClientConfig{
+		User:            name,
+		Auth:            []ssh.AuthMethod{ssh.PublicKeys(signer)},
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+		Timeout:         cfg.Timeout,
+	})
+	if err != nil {
+		return nil, err
+	}
+	session, err := client.NewSession()
+	if err != nil {
+		client.Close()
+		return nil, err
+	}
+	b := &bot{
+		name:    name,
+		client:  client,
+		session: session,
+		frames:  make(chan struct{}, 1),
+		read:    make(chan int, 64),
+	}
+	stdout, err := session.StdoutPipe()
+	if err != nil {
+		b.close()
+		return nil, err
+	}
+	if b.stdin, err = session.StdinPipe(); err != nil {
+		b.close()
+		return nil, err
+	}
+	if err := session.RequestPty("xterm", cfg.Height, cfg.Width, ssh.TerminalModes{}); err != nil {
+		b.close()
+		return nil, err
+	}
+	if err := session.Shell(); err != nil {
+		b.close()
+		return nil, err
+	}
+	go b.watch(stdout)
+	return b, nil
+}
+
+// watch reads what the server sends, and tells when a frame comes in.
+func (b *bot) watch(stdout io.Reader) {
+	buf := make([]byte, 32*1024)
+	// The mark can be split over two reads, so the end of the last one is
+	// kept.
+	var tail []byte
+	for {
+		n, err := stdout.Read(buf)
+		if n > 0 {
+			select {
+			case b.read <- n:
+			default:
+			}
+			data := append(tail, buf[:n]...)
+			if bytes.Contains(data, frameMark) {
+				select {
+				case b.frames <- struct{}{}:
+				default:
+				}
+			}
+			if len(data) >= len(frameMark) {
+				data = data[len(data)-len(frameMark)+1:]
+			}
+			tail = append(tail[:0], data...)
+		}
+		if err != nil {
+			close(b.frames)
+			return
+		}
+	}
+}
+
+// play runs the pattern until the deadline.
+func (b *bot) play(cfg Config, pattern Pattern, deadline time.Time, report *Report) {
+	for _, cmd := range pattern.Setup {
+		if !b.send(cfg, cmd, report) {
+			return
+		}
+	}
+	for i := 0; time.Now().Before(deadline); i++ {
+		if !b.send(cfg, pattern.Loop[i%len(pattern.Loop)], report) {
+			return
+		}
+		time.Sleep(cfg.Interval)
+	}
+}
+
+// send types the command, and times how long the server takes from the enter
+// key to the frame that answers it. It returns false once the connection is gone.
+func (b *bot) send(cfg Config, cmd string, report *Report) bool {
+	// Frames drawn for others in the meantime don't answer this command.
+	b.drain(report)
+	// The prompt bar takes one key a read, so the command is typed a key at
+	// a time.
+	for _, key := range []byte(strings.Replace(cmd, "$bot", b.name, -1)) {
+		if _, err := b.stdin.Write([]byte{key}); err != nil {
+			return false
+		}
+		time.Sleep(keyDelay)
+	}
+	b.drain(report)
+	if _, err := io.WriteString(b.stdin, "\r"); err != nil {
+		return false
+	}
+	start := time.Now()
+	if !b.await(cfg.Timeout, report) {
+		report.timedOut()
+		return b.alive()
+	}
+	report.answered(time.Since(start))
+	return true
+}
+
+// await waits for the next frame. It returns false if none comes in time.
+func (b *bot) await(timeout time.Duration, report *Report) bool {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	for {
+		select {
+		case _, ok := <-b.frames:
+			b.count(report)
+			return ok
+		case n := <-b.read:
+			report.received(n)
+		case <-timer.C:
+			return false
+		}
+	}
+}
+
+// drain drops the frames that came in since the last command.
+func (b *bot) drain(report *Report) {
+	for {
+		select {
+		case <-b.frames:
+		case n := <-b.read:
+			report.received(n)
+		default:
+			return
+		}
+	}
+}
+
+// count adds up the bytes read so far.
+func (b *bot) count(report *Report) {
+	for {
+		select {
+		case n := <-b.read:
+			report.received(n)
+		default:
+			return
+		}
+	}
+}
+
+// alive reports whether the server is still connected.
+func (b *bot) alive() bool {
+	select {
+	case _, ok := <-b.frames:
+		return ok
+	default:
+		return true
+	}
+}
+
+func (b *bot) close() {
+	if b.stdin != nil {
+		io.WriteString(b.stdin, "quit\r")
+	}
+	b.session.Close()
+	b.client.Close()
+}
@@ -0,0 +1,94 @@
+package botclient
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Report is how the server coped with the bots.
+type Report struct {
+	mu        sync.Mutex
+	latencies []time.Duration // Time to the frame that answered each command
+	timeouts  int             // Commands that got no frame in time
+	failures  int             // Bots that never got to play
+	bytes     int64           // Bytes the bots received
+	Elapsed   time.Duration
+}
+
+func newReport() *Report {
+	return &Report{}
+}
+
+func (r *Report) answered(d time.Duration) {
+	r.mu.Lock()
+	r.latencies = append(r.latencies, d)
+	r.mu.Unlock()
+}
+
+func (r *Report) timedOut() {
+	r.mu.Lock()
+	r.timeouts++
+	r.mu.Unlock()
+}
+
+func (r *Report) failed() {
+	r.mu.Lock()
+	r.failures++
+	r.mu.Unlock()
+}
+
+func (r *Report) received(n int) {
+	r.mu.Lock()
+	r.bytes += int64(n)
+	r.mu.Unlock()
+}
+
+// Percentile returns the latency that the given percentage of the commands
+// were answered within.
+func (r *Report) Percentile(p float64) time.Duration {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.latencies) == 0 {
+		return 0
+	}
+	sorted := append([]time.Duration{}, r.latencies...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	i := int(float64(len(sorted))*p/100+0.5) - 1
+	if i < 0 {
+		i = 0
+	}
+	if i >= len(sorted) {
+		i = len(sorted) - 1
+	}
+	return sorted[i]
+}
+
+// Throughput returns the commands answered a second.
+func (r *Report) Throughput() float64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.Elapsed <= 0 {
+		return 0
+	}
+	return float64(len(r.latencies)) / r.Elapsed.Seconds()
+}
+
+func (r *Report) String() string {
+	var b strings.Builder
+	r.mu.Lock()
+	answered, timeouts, failures, bytes, elapsed := len(r.latencies), r.timeouts, r.failures, r.bytes, r.Elapsed
+	r.mu.Unlock()
+
+	fmt.Fprintf(&b, "%d commands answered in %s, %.1f a second\n", answered, elapsed.Round(time.Millisecond), r.Throughput())
+	fmt.Fprintf(&b, "Latency: p50 %s, p90 %s, p99 %s, max %s\n",
+		r.Percentile(50).Round(time.Microsecond), r.Percentile(90).Round(time.Microsecond),
+		r.Percentile(99).Round(time.Microsecond), r.Percentile(100).Round(time.Microsecond))
+	if elapsed > 0 {
+		fmt.Fprintf(&b, "Received %d KB, %.1f KB a second\n", bytes/1024, float64(bytes)/1024/elapsed.Seconds())
+	}
+	fmt.Fprintf(&b, "%d commands timed out, %d bots could not play\n", timeouts, failures)
+	return b.String()
+}
@@ -0,0 +1,320 @@
+// Package cluster is the gRPC API thyra servers talk to each other with, so a
+// few of them can form a cluster: they tell each other how many play, pass
+// on tells and the chat of the channels they share, and take in the
+// characters moving over from each other. A staging server can join the
+// cluster of the live one the same way, to relay its chat.
+//
+// The API is defined in cluster.proto. It is served over HTTP/2 without TLS,
+// which any gRPC client can call on a private network, and guarded by a
+// secret the servers of the cluster share.
+//
+// The framing of gRPC and the encoding of the messages are written by hand
+// rather than with the grpc and protobuf modules: the server builds without
+// protoc or generated code, and the API is four unary calls of flat
+// messages, which the standard library's HTTP/2 carries as is. The tests
+// hold the encoding to the bytes generated code puts on the wire.
+package cluster
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// servicePath is where the methods of the service are served, followed by
+// their names.
+const servicePath = "/thyra.cluster.Cluster/"
+
+// maxMessage is the size of the biggest message taken, in bytes.
+const maxMessage = 4 << 20
+
+// Codes of the gRPC status of calls.
+const (
+	CodeOK               = 0
+	CodeInvalidArgument  = 3
+	CodeNotFound         = 5
+	CodePermissionDenied = 7
+	CodeUnimplemented    = 12
+	CodeInternal         = 13
+	CodeUnavailable      = 14
+	CodeUnauthenticated  = 16
+)
+
+// Error is a call that failed, with its gRPC status.
+type Error struct {
+	Code    int
+	Message string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("cluster call failed with code %d: %s", e.Code, e.Message)
+}
+
+// Errorf returns an error with the code of its gRPC status.
+func Errorf(code int, format string, args ...interface{}) error {
+	return &Error{Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+// Service is what a server does for the others of the cluster.
+type Service interface {
+	Status(ctx context.Context, req *StatusRequest) (*StatusReply, error)
+	Tell(ctx context.Context, req *TellRequest) (*TellReply, error)
+	Chat(ctx context.Context, req *ChatRequest) (*ChatReply, error)
+	Transfer(ctx context.Context, req *TransferRequest) (*TransferReply, error)
+}
+
+// methods are the methods of the service, which read their request and
+// return their reply.
+func methods(svc Service) map[string]func(ctx context.Context, b []byte) (message, error) {
+	return map[string]func(ctx context.Context, b []byte) (message, error){
+		"Status": func(ctx context.Context, b []byte) (message, error) {
+			req := &StatusRequest{}
+			if err := req.unmarshal(b); err != nil {
+				return nil, err
+			}
+			return svc.Status(ctx, req)
+		},
+		"Tell": func(ctx context.Context, b []byte) (message, error) {
+			req := &TellRequest{}
+			if err := req.unmarshal(b); err != nil {
+				return nil, err
+			}
+			return svc.Tell(ctx, req)
+		},
+		"Chat": func(ctx context.Context, b []byte) (message, error) {
+			req := &ChatRequest{}
+			if err := req.unmarshal(b); err != nil {
+				return nil, err
+			}
+			return svc.Chat(ctx, req)
+		},
+		"Transfer": func(ctx context.Context, b []byte) (message, error) {
+			req := &TransferRequest{}
+			if err := req.unmarshal(b); err != nil {
+				return nil, err
+			}
+			return svc.Transfer(ctx, req)
+		},
+	}
+}
+
+// handler serves the service over gRPC.
+type handler struct {
+	methods map[string]func(ctx context.Context, b []byte) (message, error)
+	secret  string
+}
+
+// NewHandler returns the handler serving the service to the servers that
+// know the secret.
+func NewHandler(svc Service, secret string) http.Handler {
+	return &handler{methods: methods(svc), secret: secret}
+}
+
+func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost || !strings.HasPrefix(r.Header.Get("Content-Type"), "application/grpc") {
+		http.Error(w, "gRPC only", http.StatusUnsupportedMediaType)
+		return
+	}
+	w.Header().Set("Content-Type", "application/grpc")
+	w.Header().Set("Trailer", "Grpc-Status, Grpc-Message")
+
+	method, ok := h.methods[strings.TrimPrefix(r.URL.Path, servicePath)]
+	if !ok || !strings.HasPrefix(r.URL.Path, servicePath) {
+		writeStatus(w, CodeUnimplemented, "unknown method "+r.URL.Path)
+		return
+	}
+	if h.secret == "" || subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte("Bearer "+h.secret)) != 1 {
+		writeStatus(w, CodeUnauthenticated, "wrong secret")
+		return
+	}
+	b, err := readFrame(r.Body)
+	if err != nil {
+		writeStatus(w, CodeInvalidArgument, err.Error())
+		return
+	}
+	reply, err := method(r.Context(), b)
+	if err != nil {
+		code, msg := CodeInternal, err.Error()
+		if e, ok := err.(*Error); ok {
+			code, msg = e.Code, e.Message
+		} else if err == errMalformed {
+			code = CodeInvalidArgument
+		}
+		writeStatus(w, code, msg)
+		return
+	}
+	w.Write(frame(reply.marshal()))
+	writeStatus(w, CodeOK, "")
+}
+
+// writeStatus sets the trailers with the status of the call.
+func writeStatus(w http.ResponseWriter, code int, msg string) {
+	w.Header().Set("Grpc-Status", strconv.Itoa(code))
+	if msg != "" {
+		w.Header().Set("Grpc-Message", encodeMessage(msg))
+	}
+}
+
+// encodeMessage percent-encodes the message of a status the way gRPC puts it
+// in Grpc-Message: every byte of its UTF-8 outside printable ASCII, and the
+// percent sign itself, becomes a percent sign and two upper case hex digits.
+func encodeMessage(msg string) string {
+	var b strings.Builder
+	for i := 0; i < len(msg); i++ {
+		c := msg[i]
+		if c < ' ' || c > '~' || c == '%' {
+			fmt.Fprintf(&b, "%%%02X", c)
+			continue
+		}
+		b.WriteByte(c)
+	}
+	return b.String()
+}
+
+// decodeMessage undoes encodeMessage. Percent signs that don't start an
+// escape are kept as they are, as gRPC asks of those reading the message.
+func decodeMessage(msg string) string {
+	if !strings.Contains(msg, "%") {
+		return msg
+	}
+	var b strings.Builder
+	for i := 0; i < len(msg); i++ {
+		if msg[i] == '%' && i+2 < len(msg) {
+			if c, err := strconv.ParseUint(msg[i+1:i+3], 16, 8); err == nil {
+				b.WriteByte(byte(c))
+				i += 2
+				continue
+			}
+		}
+		b.WriteByte(msg[i])
+	}
+	return b.String()
+}
+
+// frame prefixes the message with what gRPC puts before each: a byte for
+// whether it is compressed, which it never is here, and its length.
+func frame(b []byte) []byte {
+	out := make([]byte, 5, 5+len(b))
+	binary.BigEndian.PutUint32(out[1:], uint32(len(b)))
+	return append(out, b...)
+}
+
+// readFrame reads the one message of a call.
+func readFrame(r io.Reader) ([]byte, error) {
+	head := make([]byte, 5)
+	if _, err := io.ReadFull(r, head); err != nil {
+		return nil, fmt.Errorf("cannot read the message: %v", err)
+	}
+	if head[0] != 0 {
+		return nil, fmt.Errorf("compressed messages are not supported")
+	}
+	size := binary.BigEndian.Uint32(head[1:])
+	if size > maxMessage {
+		return nil, fmt.Errorf("message of %d bytes is too big", size)
+	}
+	b := make([]byte, size)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return nil, fmt.Errorf("cannot read the message: %v", err)
+	}
+	return b, nil
+}
+
+// h2c is HTTP/2 without TLS, the way gRPC goes over private networks.
+func h2c() *http.Protocols {
+	p := &http.Protocols{}
+	p.SetUnencryptedHTTP2(true)
+	return p
+}
+
+// Serve serves the handler on the listener until it fails.
+func Serve(l net.Listener, h http.Handler) error {
+	srv := &http.Server{Handler: h, Protocols: h2c(), ReadHeaderTimeout: 10 * time.Second}
+	return srv.Serve(l)
+}
+
+// Client calls the service of another server of the cluster.
+type Client struct {
+	addr   string
+	secret string
+	http   *http.Client
+}
+
+// NewClient returns a client of the server at the address, host and port,
+// which knows the secret.
+func NewClient(addr, secret string) *Client {
+	return &Client{
+		addr:   addr,
+		secret: secret,
+		http:   &http.Client{Transport: &http.Transport{Protocols: h2c()}, Timeout: 10 * time.Second},
+	}
+}
+
+func (c *Client) Status(ctx context.Context, req *StatusRequest) (*StatusReply, error) {
+	reply := &StatusReply{}
+	return reply, c.invoke(ctx, "Status", req, reply)
+}
+
+func (c *Client) Tell(ctx context.Context, req *TellRequest) (*TellReply, error) {
+	reply := &TellReply{}
+	return reply, c.invoke(ctx, "Tell", req, reply)
+}
+
+func (c *Client) Chat(ctx context.Context, req *ChatRequest) (*ChatReply, error) {
+	reply := &ChatReply{}
+	return reply, c.invoke(ctx, "Chat", req, reply)
+}
+
+func (c *Client) Transfer(ctx context.Context, req *TransferRequest) (*TransferReply, error) {
+	reply := &TransferReply{}
+	return reply, c.invoke(ctx, "Transfer", req, reply)
+}
+
+// invoke calls the method with the request, and reads its reply.
+func (c *Client) invoke(ctx context.Context, method string, req, reply message) error {
+	r, err := http.NewRequest(http.MethodPost, "http://"+c.addr+servicePath+method, bytes.NewReader(frame(req.marshal())))
+	if err != nil {
+		return err
+	}
+	r = r.WithContext(ctx)
+	r.Header.Set("Content-Type", "application/grpc")
+	r.Header.Set("TE", "trailers")
+	r.Header.Set("Authorization", "Bearer "+c.secret)
+	resp, err := c.http.Do(r)
+	if err != nil {
+		return Errorf(CodeUnavailable, "%v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return Errorf(CodeUnavailable, "%s answered %s", c.addr, resp.Status)
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return Errorf(CodeUnavailable, "%v", err)
+	}
+	// A call that fails at once has its status in the headers.
+	status := resp.Trailer.Get("Grpc-Status")
+	msg := resp.Trailer.Get("Grpc-Message")
+	if status == "" {
+		status, msg = resp.Header.Get("Grpc-Status"), resp.Header.Get("Grpc-Message")
+	}
+	if code, err := strconv.Atoi(status); err != nil || code != CodeOK {
+		if err != nil {
+			code = CodeInternal
+		}
+		return &Error{Code: code, Message: decodeMessage(msg)}
+	}
+	b, err := readFrame(bytes.NewReader(body))
+	if err != nil {
+		return Errorf(CodeInternal, "%v", err)
+	}
+	return reply.unmarshal(b)
+}
@@ -0,0 +1,284 @@
+package cluster
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// The messages of the API, as cluster.proto defines them. They are encoded
+// by hand, since they are few and flat.
+
+type StatusRequest struct {
+	FromServer string
+}
+
+type StatusReply struct {
+	Name    string
+	Players int32
+}
+
+type TellRequest struct {
+	FromServer string
+	From       string
+	To         string
+	Text       string
+}
+
+type TellReply struct {
+	Delivered bool
+}
+
+type ChatRequest struct {
+	FromServer string
+	Channel    string
+	From       string
+	Text       string
+}
+
+type ChatReply struct{}
+
+type TransferRequest struct {
+	FromServer string
+	Player     string
+	Character  []byte // The player, exported and signed
+}
+
+type TransferReply struct {
+	Accepted bool
+	Reason   string
+}
+
+// message is what goes over the wire.
+type message interface {
+	marshal() []byte
+	unmarshal(b []byte) error
+}
+
+func (m *StatusRequest) marshal() []byte {
+	var e encoder
+	e.string(1, m.FromServer)
+	return e
+}
+
+func (m *StatusRequest) unmarshal(b []byte) error {
+	return decode(b, func(num int, v uint64, data []byte) {
+		if num == 1 {
+			m.FromServer = string(data)
+		}
+	})
+}
+
+func (m *StatusReply) marshal() []byte {
+	var e encoder
+	e.string(1, m.Name)
+	e.varint(2, uint64(m.Players))
+	return e
+}
+
+func (m *StatusReply) unmarshal(b []byte) error {
+	return decode(b, func(num int, v uint64, data []byte) {
+		switch num {
+		case 1:
+			m.Name = string(data)
+		case 2:
+			m.Players = int32(v)
+		}
+	})
+}
+
+func (m *TellRequest) marshal() []byte {
+	var e encoder
+	e.string(1, m.FromServer)
+	e.string(2, m.From)
+	e.string(3, m.To)
+	e.string(4, m.Text)
+	return e
+}
+
+func (m *TellRequest) unmarshal(b []byte) error {
+	return decode(b, func(num int, v uint64, data []byte) {
+		switch num {
+		case 1:
+			m.FromServer = string(data)
+		case 2:
+			m.From = string(data)
+		case 3:
+			m.To = string(data)
+		case 4:
+			m.Text = string(data)
+		}
+	})
+}
+
+func (m *TellReply) marshal() []byte {
+	var e encoder
+	e.bool(1, m.Delivered)
+	return e
+}
+
+func (m *TellReply) unmarshal(b []byte) error {
+	return decode(b, func(num int, v uint64, data []byte) {
+		if num == 1 {
+			m.Delivered = v != 0
+		}
+	})
+}
+
+func (m *ChatRequest) marshal() []byte {
+	var e encoder
+	e.string(1, m.FromServer)
+	e.string(2, m.Channel)
+	e.string(3, m.From)
+	e.string(4, m.Text)
+	return e
+}
+
+func (m *ChatRequest) unmarshal(b []byte) error {
+	return decode(b, func(num int, v uint64, data []byte) {
+		switch num {
+		case 1:
+			m.FromServer = string(data)
+		case 2:
+			m.Channel = string(data)
+		case 3:
+			m.From = string(data)
+		case 4:
+			m.Text = string(data)
+		}
+	})
+}
+
+func (m *ChatReply) marshal() []byte {
+	return nil
+}
+
+func (m *ChatReply) unmarshal(b []byte) error {
+	return decode(b, func(int, uint64, []byte) {})
+}
+
+func (m *TransferRequest) marshal() []byte {
+	var e encoder
+	e.string(1, m.FromServer)
+	e.string(2, m.Player)
+	e.bytes(3, m.Character)
+	return e
+}
+
+func (m *TransferRequest) unmarshal(b []byte) error {
+	return decode(b, func(num int, v uint64, data []byte) {
+		switch num {
+		case 1:
+			m.FromServer = string(data)
+		case 2:
+			m.Player = string(data)
+		case 3:
+			m.Character = append([]byte(nil), data...)
+		}
+	})
+}
+
+func (m *TransferReply) marshal() []byte {
+	var e encoder
+	e.bool(1, m.Accepted)
+	e.string(2, m.Reason)
+	return e
+}
+
+func (m *TransferReply) unmarshal(b []byte) error {
+	return decode(b, func(num int, v uint64, data []byte) {
+		switch num {
+		case 1:
+			m.Accepted = v != 0
+		case 2:
+			m.Reason = string(data)
+		}
+	})
+}
+
+// Wire types of the protocol buffer encoding.
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+	wireFixed32 = 5
+)
+
+// encoder writes fields in the protocol buffer encoding. Fields with the
+// zero value are left out, as proto3 does.
+type encoder []byte
+
+func (e *encoder) tag(num, wire int) {
+	*e = binary.AppendUvarint(*e, uint64(num)<<3|uint64(wire))
+}
+
+func (e *encoder) varint(num int, v uint64) {
+	if v == 0 {
+		return
+	}
+	e.tag(num, wireVarint)
+	*e = binary.AppendUvarint(*e, v)
+}
+
+func (e *encoder) bool(num int, v bool) {
+	if v {
+		e.varint(num, 1)
+	}
+}
+
+func (e *encoder) bytes(num int, b []byte) {
+	if len(b) == 0 {
+		return
+	}
+	e.tag(num, wireBytes)
+	*e = binary.AppendUvarint(*e, uint64(len(b)))
+	*e = append(*e, b...)
+}
+
+func (e *encoder) string(num int, s string) {
+	e.bytes(num, []byte(s))
+}
+
+var errMalformed = errors.New("malformed message")
+
+// decode hands each field of the message to field, with its number and
+// either its varint or its bytes. Fixed size fields, which none of the
+// messages have, are skipped.
+func decode(b []byte, field func(num int, v uint64, data []byte)) error {
+	for len(b) > 0 {
+		key, n := binary.Uvarint(b)
+		if n <= 0 {
+			return errMalformed
+		}
+		b = b[n:]
+		num := int(key >> 3)
+		switch key & 7 {
+		case wireVarint:
+			v, n := binary.Uvarint(b)
+			if n <= 0 {
+				return errMalformed
+			}
+			b = b[n:]
+			field(num, v, nil)
+		case wireBytes:
+			l, n := binary.Uvarint(b)
+			if n <= 0 || uint64(len(b)-n) < l {
+				return errMalformed
+			}
+			field(num, 0, b[n:n+int(l)])
+			b = b[n+int(l):]
+		case wireFixed64:
+			if len(b) < 8 {
+				return errMalformed
+			}
+			b = b[8:]
+		case wireFixed32:
+			if len(b) < 4 {
+				return errMalformed
+			}
+			b = b[4:]
+		default:
+			return errMalformed
+		}
+	}
+	return nil
+}
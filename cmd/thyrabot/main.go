@@ -0,0 +1,57 @@
+// Command thyrabot puts a server under load with scripted players, and reports
+// the latency and throughput it keeps up.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/droslean/thyranew/botclient"
+
+	log "gopkg.in/inconshreveable/log15.v2"
+)
+
+var (
+	addr     = flag.String("addr", "localhost:3030", "Address of the server")
+	bots     = flag.Int("bots", 10, "Number of bots playing at once")
+	prefix   = flag.String("prefix", "bot", "Names of the bots start with this")
+	pattern  = flag.String("pattern", "mixed", fmt.Sprintf("What the bots do: %s", patternNames()))
+	duration = flag.Duration("duration", time.Minute, "How long the bots play")
+	interval = flag.Duration("interval", 500*time.Millisecond, "Time between the commands of each bot")
+	timeout  = flag.Duration("timeout", 5*time.Second, "How long a bot waits for an answer")
+)
+
+func patternNames() string {
+	names := []string{}
+	for name := range botclient.Patterns {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+func main() {
+	flag.Parse()
+	log.Info(fmt.Sprintf("%d bots play %s against %s for %s", *bots, *pattern, *addr, *duration))
+
+	report, err := botclient.Run(botclient.Config{
+		Addr:     *addr,
+		Bots:     *bots,
+		Prefix:   *prefix,
+		Pattern:  *pattern,
+		Duration: *duration,
+		Interval: *interval,
+		Timeout:  *timeout,
+		Width:    120,
+		Height:   40,
+	})
+	if err != nil {
+		log.Error(err.Error())
+		os.Exit(1)
+	}
+	fmt.Print(report)
+}
@@ -0,0 +1,65 @@
+// Command thyragraph writes the rooms of the world and the exits between them
+// as a graph, for Graphviz, for tools that read GraphML like Gephi and yEd, or
+// as JSON.
+//
+//	thyragraph -static static -format dot | dot -Tsvg > world.svg
+//	thyragraph -static static -validate
+//
+// With -validate it reports exits to rooms that do not exist, exits with no
+// way back and rooms that cannot be reached from the starts, and exits with 1
+// if it found any. Rooms players get to without exits, like where they start
+// and the jail, are given with -start.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/droslean/thyranew/worldgraph"
+
+	log "gopkg.in/inconshreveable/log15.v2"
+)
+
+var (
+	staticDir = flag.String("static", "static", "Static directory the world is read from")
+	format    = flag.String("format", "dot", "Format of the graph: dot, graphml or json")
+	npcs      = flag.Bool("npcs", false, "List the NPCs spawned in every room")
+	validate  = flag.Bool("validate", false, "Report broken and one-way exits and unreachable rooms instead of the graph")
+	starts    = flag.String("start", "City/Inn,Jail/Cell", "Rooms players get to without exits, as area/room separated by commas, to look for unreachable rooms from")
+)
+
+func main() {
+	flag.Parse()
+
+	g, err := worldgraph.Load(*staticDir, *npcs)
+	if err != nil {
+		log.Error(fmt.Sprintf("The world could not be loaded: %v", err))
+		os.Exit(1)
+	}
+
+	if *validate {
+		report := g.Validate(strings.Split(*starts, ",")...)
+		fmt.Print(report)
+		if !report.OK() {
+			os.Exit(1)
+		}
+		return
+	}
+
+	switch *format {
+	case "dot":
+		err = g.WriteDOT(os.Stdout)
+	case "graphml":
+		err = g.WriteGraphML(os.Stdout)
+	case "json":
+		err = g.WriteJSON(os.Stdout)
+	default:
+		err = fmt.Errorf("unknown format %q", *format)
+	}
+	if err != nil {
+		log.Error(err.Error())
+		os.Exit(1)
+	}
+}
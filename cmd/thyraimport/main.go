@@ -0,0 +1,56 @@
+// Command thyraimport brings the areas of classic MUDs over to thyra. It reads
+// ROM and Merc .are files, or the world files of CircleMUD zones, and writes
+// the areas and loot tables they make into a static directory.
+//
+//	thyraimport -format rom -static static midgaard.are school.are
+//	thyraimport -format circle -static static 30.wld 30.mob 30.obj 30.zon
+//
+// The world files of a CircleMUD zone are told apart by their extensions, and
+// named after their zone number. Areas converted together keep the exits
+// between them.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/droslean/thyranew/mudimport"
+
+	log "gopkg.in/inconshreveable/log15.v2"
+)
+
+var (
+	format    = flag.String("format", "rom", "Format of the files: rom for ROM and Merc, circle for CircleMUD")
+	staticDir = flag.String("static", "static", "Static directory the areas are written into")
+	dryRun    = flag.Bool("n", false, "Read and convert the files without writing anything")
+)
+
+func main() {
+	flag.Parse()
+	if flag.NArg() == 0 {
+		fmt.Fprintln(os.Stderr, "Usage: thyraimport [flags] files...")
+		flag.PrintDefaults()
+		os.Exit(2)
+	}
+
+	worlds, err := mudimport.ReadFiles(*format, flag.Args())
+	if err != nil {
+		log.Error(err.Error())
+		os.Exit(1)
+	}
+
+	for _, c := range mudimport.Convert(worlds...) {
+		for _, note := range c.Notes {
+			log.Warn(fmt.Sprintf("%s: %s", c.Area.Name, note))
+		}
+		log.Info(fmt.Sprintf("%s: %d rooms, %d NPCs, %d loot tables", c.Area.Name, len(c.Area.Rooms), len(c.Area.NPCs), len(c.Loot)))
+		if *dryRun {
+			continue
+		}
+		if err := c.Write(*staticDir); err != nil {
+			log.Error(fmt.Sprintf("%s could not be written: %v", c.Area.Name, err))
+			os.Exit(1)
+		}
+	}
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+
+	"github.com/droslean/thyranew/server"
+)
+
+// export writes the players saved in the data directory as JSON, all of
+// them or those named, for moving them elsewhere or handing players what is
+// kept of them.
+func export(args []string) int {
+	fs := newFlags("export", "[players...]")
+	static := staticFlag(fs)
+	_, dataDir := dataFlags(fs)
+	out := fs.String("o", "", "File to write, the standard output unless set")
+	fs.Parse(args)
+	quiet()
+
+	if *dataDir == "" {
+		*dataDir = staticDir(*static)
+	}
+	players, err := server.ReadPlayers(*dataDir, fs.Args()...)
+	if err != nil {
+		return fail(err)
+	}
+
+	var w io.Writer = os.Stdout
+	if *out != "" {
+		f, err := os.Create(*out)
+		if err != nil {
+			return fail(err)
+		}
+		defer f.Close()
+		w = f
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(players); err != nil {
+		return fail(err)
+	}
+	return 0
+}
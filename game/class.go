@@ -0,0 +1,12 @@
+package game
+
+// Classes are the classes a character can have.
+var Classes = []string{"Commoner", "Fighter", "Rogue"}
+
+// SetClass makes the character one of the classes, with the hit points and
+// the base attack bonus the class has at their level.
+func (pc *PC) SetClass(class string) {
+	pc.Class = class
+	pc.HP = calcHP(class, pc.Level)
+	pc.BAB = calcBAB(class, pc.Level)
+}
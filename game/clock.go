@@ -0,0 +1,67 @@
+package game
+
+import (
+	"fmt"
+	"time"
+)
+
+/*
+World clock. The game world runs faster than the real one: the length of a game hour in real time is given by
+the server, and game time is counted from a fixed epoch, so it carries on across restarts.
+*/
+
+// GameTime is a moment of the game world.
+type GameTime struct {
+	Day    int
+	Hour   int
+	Minute int
+}
+
+// WorldTime returns the game time after the given real time has passed since the epoch.
+func WorldTime(elapsed, hour time.Duration) GameTime {
+	minutes := int(elapsed / (hour / 60))
+	return GameTime{
+		Day:    minutes/(24*60) + 1,
+		Hour:   minutes / 60 % 24,
+		Minute: minutes % 60,
+	}
+}
+
+func (t GameTime) String() string {
+	return fmt.Sprintf("day %d, %02d:%02d", t.Day, t.Hour, t.Minute)
+}
+
+// SeasonDays is how many days of the game world a season lasts.
+const SeasonDays = 30
+
+// seasons are the seasons of the game world, in the order they come.
+var seasons = []string{"spring", "summer", "autumn", "winter"}
+
+// Season names the season of the game time.
+func (t GameTime) Season() string {
+	return seasons[(t.Day-1)/SeasonDays%len(seasons)]
+}
+
+// PartOfDay names the part of the day of the game time.
+func (t GameTime) PartOfDay() string {
+	switch {
+	case t.Hour >= 5 && t.Hour < 7:
+		return "dawn"
+	case t.Hour >= 7 && t.Hour < 12:
+		return "morning"
+	case t.Hour >= 12 && t.Hour < 18:
+		return "afternoon"
+	case t.Hour >= 18 && t.Hour < 20:
+		return "dusk"
+	}
+	return "night"
+}
+
+// Within reports whether the hour falls between from (inclusive) and to
+// (exclusive). Spans may wrap past midnight, like from 22 to 6.
+func Within(hour, from, to int) bool {
+	if from <= to {
+		return hour >= from && hour < to
+	}
+	return hour >= from || hour < to
+}
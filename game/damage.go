@@ -0,0 +1,75 @@
+package game
+
+import "strings"
+
+// The types of damage. Weapons deal physical damage, and most spells that of
+// an element.
+const (
+	DamageSlash    = "slash"
+	DamagePierce   = "pierce"
+	DamageBludgeon = "bludgeon"
+	DamageFire     = "fire"
+	DamageCold     = "cold"
+	DamageAcid     = "acid"
+	DamageForce    = "force"
+	DamageThunder  = "thunder"
+)
+
+// DamageTypes are all the types of damage.
+var DamageTypes = []string{DamageSlash, DamagePierce, DamageBludgeon, DamageFire, DamageCold, DamageAcid, DamageForce, DamageThunder}
+
+// How damage fares against the defenses of its target.
+const (
+	DamageFull       = ""           // All of it gets through
+	DamageResisted   = "resisted"   // Half of it, rounded up
+	DamageVulnerable = "vulnerable" // Twice of it
+	DamageImmune     = "immune"     // None of it
+)
+
+// Defenses are the types of damage a creature takes half of, twice of, and
+// none of.
+type Defenses struct {
+	Resists    []string `toml:"resists"`
+	Vulnerable []string `toml:"vulnerable"`
+	Immune     []string `toml:"immune"`
+}
+
+// Add adds the other defenses to these, and returns them.
+func (d Defenses) Add(o Defenses) Defenses {
+	return Defenses{
+		Resists:    append(append([]string{}, d.Resists...), o.Resists...),
+		Vulnerable: append(append([]string{}, d.Vulnerable...), o.Vulnerable...),
+		Immune:     append(append([]string{}, d.Immune...), o.Immune...),
+	}
+}
+
+// Against returns the damage of the type that gets through the defenses, and
+// how it fared. Immunity beats all else, and resisting a type the creature is
+// vulnerable to leaves the damage as it is. Damage of no type always gets
+// through in full.
+func (d Defenses) Against(damage int, kind string) (int, string) {
+	if kind == "" {
+		return damage, DamageFull
+	}
+	if hasType(d.Immune, kind) {
+		return 0, DamageImmune
+	}
+	resists, vulnerable := hasType(d.Resists, kind), hasType(d.Vulnerable, kind)
+	switch {
+	case resists && !vulnerable:
+		return (damage + 1) / 2, DamageResisted
+	case vulnerable && !resists:
+		return damage * 2, DamageVulnerable
+	}
+	return damage, DamageFull
+}
+
+// hasType reports whether the type of damage is in the list.
+func hasType(types []string, kind string) bool {
+	for _, t := range types {
+		if strings.EqualFold(t, kind) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,90 @@
+package game
+
+import "time"
+
+// KillXP returns the experience earned by killing a creature of the given level.
+func KillXP(level int) int {
+	if level < 1 {
+		level = 1
+	}
+	return 50 * level
+}
+
+// ScaleXP returns the experience for a kill worth xp, of a creature diff
+// levels above the killer, or below them when diff is negative. Each level
+// above is worth bonus percent more, up to maxBonus percent when it is not
+// zero, and each level below penalty percent less. From gap levels below on,
+// when gap is not zero, kills are worth nothing.
+func ScaleXP(xp, diff, bonus, maxBonus, penalty, gap int) int {
+	percent := 100
+	switch {
+	case diff > 0:
+		extra := diff * bonus
+		if maxBonus > 0 && extra > maxBonus {
+			extra = maxBonus
+		}
+		percent += extra
+	case diff < 0:
+		if gap > 0 && -diff >= gap {
+			return 0
+		}
+		percent += diff * penalty
+		if percent < 0 {
+			percent = 0
+		}
+	}
+	return xp * percent / 100
+}
+
+// LevelXP returns the experience a character of the given level needs to reach the next one.
+func LevelXP(level int) int {
+	if level < 1 {
+		level = 1
+	}
+	return 1000 * level
+}
+
+// RestedXP returns the rested experience of a character of the level once
+// it was away for the time, earning rate percent of what the level takes
+// every hour, up to max percent of it.
+func RestedXP(rested, level int, away time.Duration, rate, max int) int {
+	rested += int(float64(LevelXP(level)*rate) / 100 * away.Hours())
+	if limit := LevelXP(level) * max / 100; rested > limit {
+		rested = limit
+	}
+	return rested
+}
+
+// RestedBonus returns the experience a kill worth xp is worth on top while
+// rested, bonus percent of it for as long as the rested experience lasts.
+func RestedBonus(xp, rested, bonus int) int {
+	extra := xp * bonus / 100
+	if extra > rested {
+		extra = rested
+	}
+	return extra
+}
+
+// XPLoss returns the experience lost on death, as a percentage of the experience of the character.
+func XPLoss(xp, percent int) int {
+	if percent <= 0 || xp <= 0 {
+		return 0
+	}
+	if percent > 100 {
+		percent = 100
+	}
+	return xp * percent / 100
+}
+
+// LevelUp returns the level a character of the given level reaches with the
+// given experience, and the experience left towards the next one.
+func LevelUp(level, xp int) (int, int) {
+	if level < 1 {
+		level = 1
+	}
+	for xp >= LevelXP(level) {
+		xp -= LevelXP(level)
+		level++
+	}
+	return level, xp
+}
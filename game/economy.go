@@ -0,0 +1,46 @@
+package game
+
+import (
+	"math"
+	"time"
+)
+
+// Price returns what a ware of the base price goes for after demand of them
+// were bought lately. Every one bought puts the price up by elasticity
+// percent, up to maxMarkup percent over the base price, or without a cap
+// when maxMarkup is zero. Prices are rounded to the nearest gold coin.
+func Price(base int, demand float64, elasticity, maxMarkup int) int {
+	markup := int(demand * float64(elasticity))
+	if maxMarkup > 0 && markup > maxMarkup {
+		markup = maxMarkup
+	}
+	return (base*(100+markup) + 50) / 100
+}
+
+// Fade returns what is left of the demand once the time elapsed, half of it
+// fading every halfLife. Without a half life demand fades at once.
+func Fade(demand float64, elapsed, halfLife time.Duration) float64 {
+	if halfLife <= 0 {
+		return 0
+	}
+	return demand * math.Pow(0.5, float64(elapsed)/float64(halfLife))
+}
+
+// MaxWear is how worn the gear of a player gets at most.
+const MaxWear = 100
+
+// Wear returns the wear of gear after it took the given amount more.
+func Wear(wear, amount int) int {
+	wear += amount
+	if wear > MaxWear {
+		wear = MaxWear
+	}
+	return wear
+}
+
+// WornAC returns the armor class of gear with the given wear. Gear worn a
+// third of the way down protects a point less, and two points less two
+// thirds of the way down.
+func WornAC(ac, wear int) int {
+	return ac - wear*3/(MaxWear+1)
+}
@@ -0,0 +1,110 @@
+package game
+
+// The stacking policies of effects: what putting an effect on a character
+// that has it already does.
+const (
+	StackRefresh = ""       // The new effect takes the place of the old
+	StackExtend  = "extend" // The old effect lasts the ticks of the new one longer
+	StackAdd     = "stack"  // The old effect gets a stack more, up to its most
+	StackKeep    = "keep"   // The old effect stays as it is
+)
+
+// Effect is a lasting condition on a character, acting on it at every pulse.
+type Effect struct {
+	Name    string
+	Damage  int    // Damage dealt at every pulse; negative damage heals
+	Ticks   int    // Ticks left; zero lasts until the effect is removed
+	Period  int    // Ticks from one pulse to the next, every tick unless set
+	Message string // Shown to the character at every pulse
+	Element string // Type of the damage, which defenses act on
+	// Caster is the player who put the effect on, credited with what it does.
+	// Effects of the world have none.
+	Caster string
+	// Stacking is the stacking policy of the effect, and MaxStacks the most
+	// stacks it adds up to, without a limit unless set.
+	Stacking  string
+	MaxStacks int
+	Stacks    int
+	// Snapshot effects keep the power of their caster when they were put on,
+	// as Power. Others go by the power of the caster at every pulse.
+	Snapshot bool
+	Power    int
+	Age      int // Ticks since the effect was put on
+}
+
+// AddEffect puts the effect on the list, by the stacking policy of the
+// effect, and returns the list.
+func AddEffect(effects []Effect, e Effect) []Effect {
+	if e.Stacks == 0 {
+		e.Stacks = 1
+	}
+	for i, old := range effects {
+		if old.Name != e.Name {
+			continue
+		}
+		switch e.Stacking {
+		case StackKeep:
+			return effects
+		case StackExtend:
+			if old.Ticks > 0 {
+				effects[i].Ticks += e.Ticks
+			}
+			return effects
+		case StackAdd:
+			e.Stacks += old.Stacks
+			if e.MaxStacks > 0 && e.Stacks > e.MaxStacks {
+				e.Stacks = e.MaxStacks
+			}
+		}
+		effects[i] = e
+		return effects
+	}
+	return append(effects, e)
+}
+
+// Pulse moves the effect on by a tick. It reports whether the effect acts on
+// this tick, and whether it is over after it.
+func (e *Effect) Pulse() (acts, over bool) {
+	e.Age++
+	acts = e.Period <= 1 || e.Age%e.Period == 0
+	if e.Ticks == 0 {
+		return acts, false
+	}
+	e.Ticks--
+	return acts, e.Ticks == 0
+}
+
+// Amount returns what a pulse of the effect deals, negative when it heals,
+// with the power of the caster added, for every stack. Snapshot effects use
+// the power they kept instead.
+func (e Effect) Amount(power int) int {
+	if e.Snapshot {
+		power = e.Power
+	}
+	amount := e.Damage
+	switch {
+	case amount > 0:
+		amount += power
+	case amount < 0:
+		amount -= power
+	}
+	stacks := e.Stacks
+	if stacks < 1 {
+		stacks = 1
+	}
+	return amount * stacks
+}
+
+// EffectPower returns what the caster adds to every pulse of the effect:
+// their intelligence modifier to damage, their wisdom modifier to healing,
+// and never less than nothing.
+func EffectPower(caster *PC, e Effect) int {
+	power := attrModifier(caster.INT)
+	if e.Damage < 0 {
+		power = attrModifier(caster.WIS)
+	}
+	if power < 0 {
+		return 0
+	}
+	return power
+}
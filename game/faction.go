@@ -0,0 +1,70 @@
+package game
+
+/*
+Factions. Every character has a standing with every faction, starting from the faction's default. Standings
+are grouped in levels, from Hated to Exalted; hostile levels make guards attack on sight, friendly levels unlock
+what the faction only offers to its friends.
+*/
+
+// Faction is a group of NPCs the characters can earn or lose standing with.
+type Faction struct {
+	Name        string   `toml:"name"`
+	Description string   `toml:"description"`
+	Default     int      `toml:"default"` // Standing of characters that never dealt with the faction
+	Enemies     []string `toml:"enemies"` // Factions that like seeing this one hurt
+}
+
+// Standing thresholds.
+const (
+	StandingHostile    = -1000
+	StandingUnfriendly = -1
+	StandingFriendly   = 500
+	StandingHonored    = 3000
+	StandingExalted    = 9000
+	maxStanding        = 21000
+	minStanding        = -21000
+)
+
+// StandingLevel returns the name of the level of the given standing.
+func StandingLevel(standing int) string {
+	switch {
+	case standing <= -3000:
+		return "Hated"
+	case standing <= StandingHostile:
+		return "Hostile"
+	case standing <= StandingUnfriendly:
+		return "Unfriendly"
+	case standing < StandingFriendly:
+		return "Neutral"
+	case standing < StandingHonored:
+		return "Friendly"
+	case standing < StandingExalted:
+		return "Honored"
+	}
+	return "Exalted"
+}
+
+// IsHostile reports whether the given standing makes the faction attack on sight.
+func IsHostile(standing int) bool {
+	return standing <= StandingHostile
+}
+
+// ClampStanding keeps the standing within its limits.
+func ClampStanding(standing int) int {
+	if standing > maxStanding {
+		return maxStanding
+	}
+	if standing < minStanding {
+		return minStanding
+	}
+	return standing
+}
+
+// KillReputation returns how much standing is lost with the faction of an NPC
+// of the given level when it is killed.
+func KillReputation(level int) int {
+	if level < 1 {
+		level = 1
+	}
+	return 25 * level
+}
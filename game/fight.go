@@ -30,30 +30,48 @@ type PC struct { //Character's attributes.
 	Weapon     string `toml:"weapon"`     //type of weapon that the character weilds
 }
 
+// Stats returns the attributes, so whatever embeds them can be fought with.
+func (pc *PC) Stats() *PC {
+	return pc
+}
+
 /*
 Executing the function generateAttrib(), a random value from 8 to 18 is assigned for each of the attribute of the
 character and executing the function assignClass(), a random class from the three available is assigned.
 */
 func NewPC() *PC {
+	return rollPC(random)
+}
+
+// RollPC rolls a new character the way NewPC does, with the dice of r rather than those of the clock, so the
+// same dice roll the same character.
+func RollPC(r *rand.Rand) *PC {
+	return rollPC(func(min, max int) int {
+		return r.Intn(max-min+1) + min
+	})
+}
+
+// rollPC rolls a new character with the dice given, which pick a number from min to max.
+func rollPC(roll func(min, max int) int) *PC {
 	player := &PC{
-		STR:   generateAttrib(),
-		DEX:   generateAttrib(),
-		CON:   generateAttrib(),
-		INT:   generateAttrib(),
-		WIS:   generateAttrib(),
-		CHA:   generateAttrib(),
+		STR:   generateAttrib(roll),
+		DEX:   generateAttrib(roll),
+		CON:   generateAttrib(roll),
+		INT:   generateAttrib(roll),
+		WIS:   generateAttrib(roll),
+		CHA:   generateAttrib(roll),
 		Level: 1,
-		Class: assignClass(),
+		Class: assignClass(roll),
 	}
 	/*
 		Weapon and armor are assigned randomly to the characters, but Hit Points and BAB are based on algorithms
 		according to the appropriate class
 	*/
-	player.Armor, player.AC = wearArmor(player.DEX)
+	player.Armor, player.AC = wearArmor(roll, player.DEX)
 	player.HP = calcHP(player.Class, player.Level)
 	player.BAB = calcBAB(player.Class, player.Level)
-	player.Weapon, player.Weapondie = weildWeapon()
-	player.Initiative = random(1, 20) + attrModifier(player.DEX)
+	player.Weapon, player.Weapondie = weildWeapon(roll)
+	player.Initiative = roll(1, 20) + attrModifier(player.DEX)
 
 	return player
 }
@@ -67,8 +85,8 @@ func random(min, max int) int {
 }
 
 // General attribute creation function
-func generateAttrib() int {
-	return random(8, 18)
+func generateAttrib(roll func(min, max int) int) int {
+	return roll(8, 18)
 }
 
 /*
@@ -82,8 +100,8 @@ func attrModifier(attribute int) int {
 /*
 This function picks an armor randomly. Then, it will calculate the total AC based on the armor's traits.
 */
-func wearArmor(dexterity int) (string, int) {
-	lottery := random(1, 5)
+func wearArmor(roll func(min, max int) int, dexterity int) (string, int) {
+	lottery := roll(1, 5)
 	var armorname string
 	var armorBonus, dexBonus int
 	dexBonus = attrModifier(dexterity)
@@ -126,8 +144,8 @@ func wearArmor(dexterity int) (string, int) {
 This method provides a weapon to the character. The variable weapon is the name of the weapon and the variable weapondie
 is the die that the weapon uses to calculate damage.
 */
-func weildWeapon() (string, int) {
-	lottery := random(1, 5)
+func weildWeapon(roll func(min, max int) int) (string, int) {
+	lottery := roll(1, 5)
 	var weapon string
 	var weapondie int
 	switch lottery {
@@ -153,8 +171,8 @@ func weildWeapon() (string, int) {
 /*
 A function to assign a class randomly to the character. This is essential to calculate other factors, like HP etc.
 */
-func assignClass() string { //To start with, three classes.
-	lottery := random(1, 3)
+func assignClass(roll func(min, max int) int) string { //To start with, three classes.
+	lottery := roll(1, 3)
 	var class string
 	switch lottery {
 	case 1:
@@ -225,6 +243,25 @@ func calcHP(class string, level int) int {
 	return HP
 }
 
+/*
+Maximum hit points of the character, as if every roll of its hit die scored the maximum. Resting cannot heal the
+character beyond this value.
+*/
+func MaxHP(pc *PC) int {
+	HD := 4
+	switch pc.Class {
+	case "Fighter":
+		HD = 10
+	case "Rogue":
+		HD = 6
+	}
+	level := pc.Level
+	if level < 1 {
+		level = 1
+	}
+	return HD * level
+}
+
 /*
 Battle function. First strikes the comb1 and then comb2. Initiative is determined in main function
 Refactor of "for comb1.HP > 0 || comb2.HP > 0 {" gives fuzzy results. Don't know why.
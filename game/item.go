@@ -0,0 +1,49 @@
+package game
+
+import "math/rand"
+
+// ArmorAC returns the armor class of a character of the dexterity in armor
+// that adds armor to it, and lets at most maxDex of the dexterity modifier
+// count.
+func ArmorAC(dexterity, armor, maxDex int) int {
+	dexBonus := attrModifier(dexterity)
+	if dexBonus > maxDex {
+		dexBonus = maxDex
+	}
+	return 10 + armor + dexBonus
+}
+
+// AverageDamage returns the damage a weapon of the die deals on average, with
+// the bonus on top.
+func AverageDamage(die, bonus int) float64 {
+	return float64(die+1)/2 + float64(bonus)
+}
+
+// MaxEnchant is how many enchantments an item takes at most.
+const MaxEnchant = 5
+
+// The outcomes of enchanting an item.
+const (
+	EnchantTakes     = "takes"     // The item takes another enchantment
+	EnchantFizzles   = "fizzles"   // Nothing comes of it
+	EnchantBackfires = "backfires" // The item loses one of its enchantments
+)
+
+// Enchant rolls the outcome of enchanting an item that took level
+// enchantments before. Each of them makes another risk percent likelier to
+// fail, down to a chance in twenty, and half the failures on an enchanted
+// item undo one of its enchantments.
+func Enchant(level, risk int, r *rand.Rand) string {
+	chance := 100 - level*risk
+	if chance < 5 {
+		chance = 5
+	}
+	roll := r.Intn(100)
+	switch {
+	case roll < chance:
+		return EnchantTakes
+	case level > 0 && (roll-chance)%2 == 1:
+		return EnchantBackfires
+	}
+	return EnchantFizzles
+}
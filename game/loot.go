@@ -0,0 +1,244 @@
+package game
+
+import (
+	"math/rand"
+	"sort"
+)
+
+/*
+Loot tables. Every table holds a list of weighted entries; an entry either names an item or points to
+another (nested) table that is rolled in its place. When an entry has no explicit weight, the weight of
+its rarity tier is used, so rare items are naturally rolled less often than common ones.
+*/
+
+// Rarity tiers and their default weights.
+var rarityWeights = map[string]int{
+	"common":    100,
+	"uncommon":  40,
+	"rare":      10,
+	"epic":      3,
+	"legendary": 1,
+}
+
+// Rarities are the rarity tiers, from the commonest to the rarest.
+var Rarities = []string{"common", "uncommon", "rare", "epic", "legendary"}
+
+// AsRare reports whether the rarity tier is at least as rare as the other.
+// Tiers it doesn't know count as common.
+func AsRare(tier, than string) bool {
+	return rarityRank(tier) >= rarityRank(than)
+}
+
+func rarityRank(tier string) int {
+	for i, t := range Rarities {
+		if t == tier {
+			return i
+		}
+	}
+	return 0
+}
+
+// maxLootDepth protects against nested tables that reference each other.
+const maxLootDepth = 8
+
+// LootGold describes the gold dropped by a table, scaled by the level of the creature.
+type LootGold struct {
+	Min      int `toml:"min"`
+	Max      int `toml:"max"`
+	PerLevel int `toml:"perlevel"`
+}
+
+// LootEntry is a single weighted row of a loot table.
+type LootEntry struct {
+	Item     string `toml:"item"`     // Name of the dropped item
+	Table    string `toml:"table"`    // Nested table rolled instead of an item
+	Rarity   string `toml:"rarity"`   // Rarity tier, defaults to common
+	Weight   int    `toml:"weight"`   // Overrides the weight of the rarity tier
+	Quest    string `toml:"quest"`    // Only drops while the looter is on this quest
+	MinLevel int    `toml:"minlevel"` // Only drops from creatures of at least this level
+}
+
+// LootTable is a named list of weighted entries.
+type LootTable struct {
+	Name    string      `toml:"name"`
+	Rolls   int         `toml:"rolls"`
+	Gold    LootGold    `toml:"gold"`
+	Entries []LootEntry `toml:"entries"`
+}
+
+// Drop is an item that came out of a loot table.
+type Drop struct {
+	Item   string
+	Rarity string
+}
+
+// LootContext holds what the loot roll needs to know about the creature and the looter.
+type LootContext struct {
+	Level    int
+	HasQuest func(quest string) bool
+	// Luck is by how many percent the entries rarer than common weigh more.
+	Luck int
+}
+
+// tier returns the rarity tier of the entry, falling back to common.
+func (e LootEntry) tier() string {
+	if _, ok := rarityWeights[e.Rarity]; ok {
+		return e.Rarity
+	}
+	return "common"
+}
+
+func (e LootEntry) weight() int {
+	if e.Weight > 0 {
+		return e.Weight
+	}
+	return rarityWeights[e.tier()]
+}
+
+// weightIn returns the weight of the entry in the roll, with the luck of the
+// roll.
+func (e LootEntry) weightIn(ctx LootContext) int {
+	if ctx.Luck > 0 && e.tier() != "common" {
+		return e.weight() * (100 + ctx.Luck) / 100
+	}
+	return e.weight()
+}
+
+func (e LootEntry) allowed(ctx LootContext) bool {
+	if e.MinLevel > ctx.Level {
+		return false
+	}
+	if e.Quest != "" && (ctx.HasQuest == nil || !ctx.HasQuest(e.Quest)) {
+		return false
+	}
+	return true
+}
+
+// RollLoot rolls the named table and returns the dropped items and gold.
+func RollLoot(tables map[string]LootTable, name string, ctx LootContext, r *rand.Rand) ([]Drop, int) {
+	table, ok := tables[name]
+	if !ok {
+		return nil, 0
+	}
+
+	drops := rollEntries(tables, table, ctx, r, 0)
+	return drops, rollGold(table.Gold, ctx.Level, r)
+}
+
+func rollEntries(tables map[string]LootTable, table LootTable, ctx LootContext, r *rand.Rand, depth int) []Drop {
+	if depth > maxLootDepth {
+		return nil
+	}
+
+	rolls := table.Rolls
+	if rolls < 1 {
+		rolls = 1
+	}
+
+	drops := []Drop{}
+	for i := 0; i < rolls; i++ {
+		entry, ok := pickEntry(table.Entries, ctx, r)
+		if !ok {
+			continue
+		}
+		if entry.Table != "" {
+			if nested, ok := tables[entry.Table]; ok {
+				drops = append(drops, rollEntries(tables, nested, ctx, r, depth+1)...)
+			}
+			continue
+		}
+		// An entry without an item is an explicit "nothing" row.
+		if entry.Item != "" {
+			drops = append(drops, Drop{Item: entry.Item, Rarity: entry.tier()})
+		}
+	}
+	return drops
+}
+
+// pickEntry picks a single entry from the allowed ones, proportionally to its weight.
+func pickEntry(entries []LootEntry, ctx LootContext, r *rand.Rand) (LootEntry, bool) {
+	total := 0
+	for _, e := range entries {
+		if e.allowed(ctx) {
+			total += e.weightIn(ctx)
+		}
+	}
+	if total == 0 {
+		return LootEntry{}, false
+	}
+
+	n := r.Intn(total)
+	for _, e := range entries {
+		if !e.allowed(ctx) {
+			continue
+		}
+		if n < e.weightIn(ctx) {
+			return e, true
+		}
+		n -= e.weightIn(ctx)
+	}
+	return LootEntry{}, false
+}
+
+func rollGold(g LootGold, level int, r *rand.Rand) int {
+	if g.Max < g.Min {
+		g.Max = g.Min
+	}
+	gold := g.Min
+	if g.Max > g.Min {
+		gold += r.Intn(g.Max - g.Min + 1)
+	}
+	return gold + g.PerLevel*level
+}
+
+// LootRate is the drop rate of a single item in a simulation.
+type LootRate struct {
+	Item   string
+	Rarity string
+	Drops  int
+	Rate   float64 // Drops per kill
+}
+
+// LootReport summarizes a loot simulation.
+type LootReport struct {
+	Runs    int
+	AvgGold float64
+	Rates   []LootRate
+}
+
+// SimulateLoot rolls the named table the given number of times, so builders can verify drop rates.
+func SimulateLoot(tables map[string]LootTable, name string, ctx LootContext, runs int, r *rand.Rand) LootReport {
+	report := LootReport{Runs: runs}
+	if runs < 1 {
+		return report
+	}
+
+	counts := map[string]*LootRate{}
+	gold := 0
+	for i := 0; i < runs; i++ {
+		drops, g := RollLoot(tables, name, ctx, r)
+		gold += g
+		for _, d := range drops {
+			rate, ok := counts[d.Item]
+			if !ok {
+				rate = &LootRate{Item: d.Item, Rarity: d.Rarity}
+				counts[d.Item] = rate
+			}
+			rate.Drops++
+		}
+	}
+
+	for _, rate := range counts {
+		rate.Rate = float64(rate.Drops) / float64(runs)
+		report.Rates = append(report.Rates, *rate)
+	}
+	sort.Slice(report.Rates, func(i, j int) bool {
+		if report.Rates[i].Drops == report.Rates[j].Drops {
+			return report.Rates[i].Item < report.Rates[j].Item
+		}
+		return report.Rates[i].Drops > report.Rates[j].Drops
+	})
+	report.AvgGold = float64(gold) / float64(runs)
+
+	return report
+}
@@ -0,0 +1,127 @@
+package game
+
+import (
+	"math/rand"
+	"strings"
+)
+
+/*
+Ranged attacks. Bows shoot ammunition, thrown weapons are used up themselves and spells only cost the
+caster's concentration. The attack roll works like a melee blow, a d20 plus the Base Attack Bonus, but uses
+dexterity (intelligence for spells) and gets harder for every room the target is away.
+*/
+
+// RangedAttack describes a weapon or spell that can hit targets in other rooms.
+type RangedAttack struct {
+	Name  string
+	Kind  string // bow, thrown or spell
+	Die   int    // Multiside die of the damage
+	Ammo  string // Item used up by every attack, if any
+	Range int    // How many rooms away the attack reaches
+	// Element is the type of the damage: physical for weapons, that of an
+	// element for most spells. The weather affects some elements.
+	Element string
+	// Target is who a spell reaches, one target unless set.
+	Target string
+	// Save is the attribute targets roll to take half the damage, instead
+	// of the attack rolling to hit them.
+	Save string
+	// Heal is set for spells that mend their targets instead of hurting them.
+	Heal bool
+	// Effect is put on the targets the attack reaches, by the attacker, when
+	// it has a name.
+	Effect Effect
+}
+
+// Bows are used with the shoot command, thrown weapons with throw and spells with cast.
+var RangedAttacks = map[string]RangedAttack{
+	"shortbow":      {Name: "Shortbow", Kind: "bow", Die: 6, Ammo: "Arrow", Range: 2, Element: DamagePierce},
+	"longbow":       {Name: "Longbow", Kind: "bow", Die: 8, Ammo: "Arrow", Range: 3, Element: DamagePierce},
+	"sling":         {Name: "Sling", Kind: "bow", Die: 4, Ammo: "Stone", Range: 2, Element: DamageBludgeon},
+	"dagger":        {Name: "Dagger", Kind: "thrown", Die: 4, Ammo: "Dagger", Range: 1, Element: DamagePierce},
+	"throwing axe":  {Name: "Throwing Axe", Kind: "thrown", Die: 6, Ammo: "Throwing Axe", Range: 1, Element: DamageSlash},
+	"magic missile": {Name: "Magic Missile", Kind: "spell", Die: 4, Range: 3, Element: DamageForce},
+	"firebolt":      {Name: "Firebolt", Kind: "spell", Die: 10, Range: 2, Element: DamageFire},
+	"frost ray":     {Name: "Frost Ray", Kind: "spell", Die: 8, Range: 1, Element: DamageCold, Save: "con"},
+	"cure wounds":   {Name: "Cure Wounds", Kind: "spell", Die: 8, Target: TargetSelf, Heal: true},
+	"healing word":  {Name: "Healing Word", Kind: "spell", Die: 4, Target: TargetGroup, Heal: true},
+	"fireball":      {Name: "Fireball", Kind: "spell", Die: 12, Target: TargetRoom, Element: DamageFire, Save: "dex"},
+	"thunderclap":   {Name: "Thunderclap", Kind: "spell", Die: 6, Target: TargetAdjacent, Element: DamageThunder, Save: "con"},
+	"acid arrow": {Name: "Acid Arrow", Kind: "spell", Die: 4, Range: 2, Element: DamageAcid, Effect: Effect{
+		Name: "Acid", Damage: 2, Element: DamageAcid, Ticks: 4, Message: "Acid eats at your skin\n",
+		Stacking: StackAdd, MaxStacks: 3, Snapshot: true,
+	}},
+	"regrowth": {Name: "Regrowth", Kind: "spell", Die: 4, Target: TargetGroup, Heal: true, Effect: Effect{
+		Name: "Regrowth", Damage: -2, Ticks: 6, Period: 2, Message: "Your wounds close a little\n",
+	}},
+}
+
+// FindRangedAttack returns the ranged attack of the given kind and name.
+func FindRangedAttack(kind, name string) (RangedAttack, bool) {
+	attack, ok := RangedAttacks[strings.ToLower(name)]
+	if !ok || attack.Kind != kind {
+		return RangedAttack{}, false
+	}
+	return attack, true
+}
+
+// RangedRoll rolls a ranged attack against the armor class of a target the given
+// number of rooms away. It returns whether the attack hit and the damage dealt.
+func RangedRoll(pc *PC, attack RangedAttack, distance, ac int, r *rand.Rand) (bool, int) {
+	bonus := attrModifier(pc.DEX)
+	if attack.Kind == "spell" {
+		bonus = attrModifier(pc.INT)
+	}
+	if (r.Intn(20) + 1 + pc.BAB + bonus - 2*(distance-1)) < ac {
+		return false, 0
+	}
+	damage := r.Intn(attack.Die) + 1
+	if attack.Kind == "thrown" {
+		damage += attrModifier(pc.STR)
+	}
+	if damage < 1 {
+		damage = 1
+	}
+	return true, damage
+}
+
+// NPCHitPoints returns the default hit points of a creature of the given level.
+func NPCHitPoints(level int) int {
+	if level < 1 {
+		level = 1
+	}
+	return 6 * level
+}
+
+// NPCArmorClass returns the default armor class of a creature of the given level.
+func NPCArmorClass(level int) int {
+	return 10 + level
+}
+
+// NPCAttack rolls a blow of a creature of the given level against the armor class
+// of its target and returns the damage dealt.
+func NPCAttack(level, ac int, r *rand.Rand) int {
+	if r.Intn(20)+1+level < ac {
+		return 0
+	}
+	return r.Intn(level+3) + 1
+}
+
+// ScaleLevel returns the level of a creature made for players of the base
+// level, once fitted to a group of the average level.
+func ScaleLevel(level, base, average int) int {
+	level += average - base
+	if level < 1 {
+		level = 1
+	}
+	return level
+}
+
+// GroupBonus returns the bonus, in percent, of a group of the size at the
+// percent for each member past the first.
+func GroupBonus(size, percent int) int {
+	if size < 2 {
+		return 0
+	}
+	return (size - 1) * percent
+}
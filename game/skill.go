@@ -0,0 +1,51 @@
+package game
+
+/*
+Training. Players earn practices as they go up levels, and spend them, along with gold, at trainers to learn ranks
+of skills: the families of weapons, where trained ranks add to those earned by landing blows, and stealth. What a
+player can learn, and from what level, goes by their class.
+*/
+
+// SkillStealth is the skill of hiding and sneaking.
+const SkillStealth = "stealth"
+
+// Skills are all the skills trainers teach.
+var Skills = append(append([]string{}, WeaponFamilies...), SkillStealth)
+
+// IsSkill reports whether trainers teach the skill.
+func IsSkill(name string) bool {
+	for _, skill := range Skills {
+		if skill == name {
+			return true
+		}
+	}
+	return false
+}
+
+// LevelPractices returns the practices the character earns for going up a
+// level: two and their wisdom modifier, but never less than one.
+func LevelPractices(pc *PC) int {
+	practices := 2 + attrModifier(pc.WIS)
+	if practices < 1 {
+		return 1
+	}
+	return practices
+}
+
+// WeaponRank returns the rank of skill of a player with a family of weapons,
+// who landed the given number of blows with it and trained the given number
+// of ranks in it, up to master.
+func WeaponRank(blows, trained int) int {
+	rank := SkillRank(blows) + trained
+	if top := len(SkillTitles) - 1; rank > top {
+		return top
+	}
+	return rank
+}
+
+// TrainingCost returns what the next rank of a skill costs a player who
+// trained the given number of ranks in it already, when the first costs
+// base.
+func TrainingCost(base, trained int) int {
+	return base * (trained + 1)
+}
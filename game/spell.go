@@ -0,0 +1,62 @@
+package game
+
+import (
+	"math/rand"
+	"strings"
+)
+
+// Who a spell reaches. Single target spells fly like any ranged attack, the
+// others take effect at once on everyone they reach.
+const (
+	TargetSingle   = ""
+	TargetSelf     = "self"
+	TargetGroup    = "group"    // The caster and those who go about with them
+	TargetRoom     = "room"     // Everyone in the room of the caster
+	TargetAdjacent = "adjacent" // The room and the rooms behind its open doors
+)
+
+// SpellDC returns what the saving throws against the spells of the caster
+// have to beat.
+func SpellDC(caster *PC) int {
+	return 10 + attrModifier(caster.INT) + caster.Level/2
+}
+
+// SavingThrow rolls the save of the target with the named attribute against
+// the difficulty. Attributes that are not set, like those of most creatures,
+// count as average.
+func SavingThrow(target *PC, save string, dc int, r *rand.Rand) bool {
+	return r.Intn(20)+1+SaveBonus(target, save) >= dc
+}
+
+// SaveBonus returns what the target adds to its roll to save against an
+// attack on the attribute: its modifier and half its level.
+func SaveBonus(target *PC, save string) int {
+	attr := map[string]int{
+		"str": target.STR, "dex": target.DEX, "con": target.CON,
+		"int": target.INT, "wis": target.WIS, "cha": target.CHA,
+	}[strings.ToLower(save)]
+	if attr == 0 {
+		attr = 10
+	}
+	return attrModifier(attr) + target.Level/2
+}
+
+// SaveDamage rolls the damage of an attack that the target saves against
+// instead of being hit by. A target that saves takes half, rounded up.
+func SaveDamage(caster *PC, attack RangedAttack, target *PC, r *rand.Rand) (int, bool) {
+	damage := r.Intn(attack.Die) + 1
+	saved := SavingThrow(target, attack.Save, SpellDC(caster), r)
+	if saved {
+		damage = (damage + 1) / 2
+	}
+	return damage, saved
+}
+
+// HealRoll rolls how many hit points a healing spell of the caster mends.
+func HealRoll(caster *PC, spell RangedAttack, r *rand.Rand) int {
+	heal := r.Intn(spell.Die) + 1 + attrModifier(caster.WIS)
+	if heal < 1 {
+		heal = 1
+	}
+	return heal
+}
@@ -0,0 +1,60 @@
+package game
+
+import "math/rand"
+
+// The stances characters fight in. Normal is the stance of those who picked
+// none.
+const (
+	StanceNormal     = "normal"
+	StanceAggressive = "aggressive"
+	StanceDefensive  = "defensive"
+	StanceBerserk    = "berserk"
+)
+
+// Stances lists the stances, the normal one first.
+var Stances = []string{StanceNormal, StanceAggressive, StanceDefensive, StanceBerserk}
+
+// StanceMods are what a stance adds to the attacks and the armor class of
+// whoever fights in it.
+type StanceMods struct {
+	Hit    int
+	Damage int
+	AC     int
+}
+
+// stanceMods trade defence for offence or the other way around. Berserkers
+// trade the most, and never flee.
+var stanceMods = map[string]StanceMods{
+	StanceAggressive: {Hit: 2, AC: -2},
+	StanceDefensive:  {Hit: -2, AC: 2},
+	StanceBerserk:    {Hit: 2, Damage: 2, AC: -4},
+}
+
+// Stance returns what the stance adds. Those it doesn't know fight normally.
+func Stance(stance string) StanceMods {
+	return stanceMods[stance]
+}
+
+// Flees reports whether a character with the hit points left flees, for
+// the threshold they set in percent of their most hit points. Zero never
+// flees, and neither do berserkers.
+func Flees(hp, maxHP, wimpy int, stance string) bool {
+	return wimpy > 0 && stance != StanceBerserk && hp > 0 && hp*100 < wimpy*maxHP
+}
+
+// FleeRoll rolls how well the character gets away from a fight: a d20, their
+// dexterity modifier and half their level. Defensive fighters keep an eye on
+// the way out, and add two.
+func FleeRoll(pc *PC, stance string, r *rand.Rand) int {
+	roll := r.Intn(20) + 1 + attrModifier(pc.DEX) + pc.Level/2
+	if stance == StanceDefensive {
+		roll += 2
+	}
+	return roll
+}
+
+// FleeDC returns what a roll to flee has to reach to get away from a
+// creature of the level.
+func FleeDC(level int) int {
+	return 10 + level/2
+}
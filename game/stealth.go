@@ -0,0 +1,40 @@
+package game
+
+import "math/rand"
+
+/*
+Stealth. A character that hides rolls a d20 and adds the dexterity bonus; rogues add their training on top.
+Everyone else notices the hidden character only if their passive perception, ten plus the wisdom bonus
+and half their level, reaches that roll.
+*/
+
+// StealthRoll rolls how well the character hides or sneaks.
+func StealthRoll(pc *PC, r *rand.Rand) int {
+	return r.Intn(20) + 1 + attrModifier(pc.DEX) + stealthBonus(pc.Class, pc.Level)
+}
+
+// Perception returns the passive perception of the character.
+func Perception(pc *PC) int {
+	return 10 + attrModifier(pc.WIS) + pc.Level/2
+}
+
+func stealthBonus(class string, level int) int {
+	if class == "Rogue" {
+		return 3 + level/2
+	}
+	return 0
+}
+
+// NPCPerception returns the passive perception of a creature of the given level.
+func NPCPerception(level int) int {
+	return 10 + level/2
+}
+
+// PickpocketDC returns what a stealth roll has to beat to rob an NPC of the
+// level unnoticed. Sleeping NPCs are easy marks.
+func PickpocketDC(level int, asleep bool) int {
+	if asleep {
+		return NPCPerception(level) - 5
+	}
+	return NPCPerception(level) + 2
+}
@@ -0,0 +1,46 @@
+package game
+
+/*
+Survival. Characters get hungry and thirsty as time goes by, and have to eat and drink to keep their strength.
+Food and drink meters go from MaxFood and MaxDrink down to zero, where the character starts suffering.
+Resting and sleeping speed up the healing of wounds.
+*/
+
+const (
+	MaxFood  = 100
+	MaxDrink = 100
+)
+
+// Rest states.
+const (
+	Awake    = ""
+	Resting  = "resting"
+	Sleeping = "sleeping"
+)
+
+// Foods maps the items that can be eaten to how much they fill the food meter.
+var Foods = map[string]int{
+	"bread":      40,
+	"apple":      15,
+	"cheese":     25,
+	"dried meat": 50,
+}
+
+// Drinks maps the items that can be drunk to how much they fill the drink meter.
+var Drinks = map[string]int{
+	"ale":         30,
+	"water flask": 50,
+	"milk":        35,
+}
+
+// RegenInterval returns every how many ticks a character heals one hit point
+// in the given rest state.
+func RegenInterval(state string) int {
+	switch state {
+	case Resting:
+		return 2
+	case Sleeping:
+		return 1
+	}
+	return 6
+}
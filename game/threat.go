@@ -0,0 +1,34 @@
+package game
+
+// Threat returns how much a creature minds the damage the character dealt
+// it. Fighters draw the eyes of their foes, so others can fight behind them.
+func Threat(pc *PC, damage int) int {
+	if pc.Class == "Fighter" {
+		return damage * 2
+	}
+	return damage
+}
+
+// HealThreat returns how much the creatures fighting the healed mind the
+// healer: half of what was healed.
+func HealThreat(healed int) int {
+	return (healed + 1) / 2
+}
+
+// TauntThreat returns the threat a taunt leaves the taunter with, against
+// the highest threat of the others: enough for the creature to turn on them
+// from anywhere.
+func TauntThreat(highest int) int {
+	return highest*13/10 + 1
+}
+
+// SwitchesTarget reports whether a creature turns from its target to one
+// who caused more threat. Taking a creature away from its target takes a
+// tenth more threat than the target caused, or a third more for whoever is
+// not at hand.
+func SwitchesTarget(current, challenger int, atHand bool) bool {
+	if atHand {
+		return challenger*10 > current*11
+	}
+	return challenger*10 > current*13
+}
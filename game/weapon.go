@@ -0,0 +1,77 @@
+package game
+
+import "math/rand"
+
+/*
+Fighting hand to hand. Every weapon belongs to a family, and players get better with a family the more blows they land
+with its weapons. A weapon swings once every few seconds, as fast as it is. Players may hold a second, one-handed
+weapon in their off hand, which swings on its own at a penalty to both, or a shield, which blocks some of the blows
+they take.
+*/
+
+// The families of weapons.
+const (
+	FamilyUnarmed  = "unarmed"
+	FamilyDaggers  = "daggers"
+	FamilySwords   = "swords"
+	FamilyAxes     = "axes"
+	FamilyMaces    = "maces"
+	FamilyPolearms = "polearms"
+)
+
+// WeaponFamilies are all the families of weapons.
+var WeaponFamilies = []string{FamilyUnarmed, FamilyDaggers, FamilySwords, FamilyAxes, FamilyMaces, FamilyPolearms}
+
+// DefaultSpeed is how many seconds weapons that set no speed of their own
+// take between two swings.
+const DefaultSpeed = 3
+
+// What holding a weapon in each hand takes off the attack rolls of the main
+// hand and of the off hand.
+const (
+	MainHandPenalty = 2
+	OffHandPenalty  = 4
+)
+
+// skillRanks are how many blows a player lands with a family of weapons to
+// reach each rank of skill past the first.
+var skillRanks = []int{10, 30, 60, 100, 150}
+
+// SkillTitles name the ranks of skill.
+var SkillTitles = []string{"untrained", "novice", "trained", "skilled", "expert", "master"}
+
+// SkillRank returns the rank of skill of a player who landed the given
+// number of blows with a family of weapons. Each rank adds one to their
+// attack rolls with it.
+func SkillRank(blows int) int {
+	rank := 0
+	for _, need := range skillRanks {
+		if blows >= need {
+			rank++
+		}
+	}
+	return rank
+}
+
+// MeleeRoll rolls a blow of the character with a weapon of the damage die,
+// with the bonus to hit, against the armor class of the target. It returns
+// whether the blow landed and the damage dealt.
+func MeleeRoll(pc *PC, die, bonus, ac int, r *rand.Rand) (bool, int) {
+	if die < 1 {
+		die = 1
+	}
+	if r.Intn(20)+1+pc.BAB+attrModifier(pc.STR)+bonus < ac {
+		return false, 0
+	}
+	damage := r.Intn(die) + 1 + attrModifier(pc.STR)
+	if damage < 1 {
+		damage = 1
+	}
+	return true, damage
+}
+
+// Blocks rolls whether a shield that blocks the given percent of the blows
+// blocks this one.
+func Blocks(block int, r *rand.Rand) bool {
+	return block > 0 && r.Intn(100) < block
+}
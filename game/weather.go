@@ -0,0 +1,81 @@
+package game
+
+import "math/rand"
+
+/*
+Weather. Every area with a climate has its own weather, rolled again every game hour. Weather only matters
+outdoors: rain and storms make it hard to see far and douse fire, snow makes walking slow.
+*/
+
+// Weathers.
+const (
+	Clear = "clear"
+	Rain  = "rain"
+	Storm = "storm"
+	Snow  = "snow"
+)
+
+// Climates give the odds of every weather, out of a hundred.
+var Climates = map[string]map[string]int{
+	"temperate": {Clear: 60, Rain: 25, Storm: 10, Snow: 5},
+	"cold":      {Clear: 40, Snow: 45, Storm: 15},
+	"dry":       {Clear: 95, Storm: 5},
+}
+
+// weatherChange is the chance, out of a hundred, that the weather changes in an hour.
+const weatherChange = 30
+
+// NextWeather rolls the weather of the next hour in the given climate.
+func NextWeather(climate, current string, r *rand.Rand) string {
+	odds, ok := Climates[climate]
+	if !ok {
+		return Clear
+	}
+	if current != "" && r.Intn(100) >= weatherChange {
+		return current
+	}
+	roll := r.Intn(100)
+	// Maps are not ordered, so the weathers are walked in a fixed order.
+	for _, w := range []string{Clear, Rain, Storm, Snow} {
+		if roll < odds[w] {
+			return w
+		}
+		roll -= odds[w]
+	}
+	return Clear
+}
+
+// VisibilityPenalty returns how many rooms less can be seen in the given weather.
+func VisibilityPenalty(weather string) int {
+	switch weather {
+	case Rain, Snow:
+		return 1
+	case Storm:
+		return 2
+	}
+	return 0
+}
+
+// ElementalDamage adjusts the damage of an attack of the given element to the
+// weather. Rain halves fire and storms put it out.
+func ElementalDamage(weather, element string, damage int) int {
+	if element != DamageFire {
+		return damage
+	}
+	switch weather {
+	case Rain:
+		return (damage + 1) / 2
+	case Storm:
+		return 0
+	}
+	return damage
+}
+
+// SwimCheck rolls whether the character keeps afloat in deep water.
+func SwimCheck(pc *PC, r *rand.Rand) bool {
+	bonus := attrModifier(pc.STR)
+	if pc.Class == "Fighter" {
+		bonus += 2
+	}
+	return r.Intn(20)+1+bonus >= 10
+}
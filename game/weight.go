@@ -0,0 +1,47 @@
+package game
+
+/*
+Weight. Characters carry as much as their strength allows. The more of it they carry, the more burdened they are:
+slower on their feet, worse at hitting and easier to hit. Past it they can't move at all.
+*/
+
+// Encumbrance levels.
+const (
+	Unburdened = ""
+	Burdened   = "burdened"
+	Strained   = "strained"
+	Overloaded = "overloaded"
+)
+
+// Capacity returns how many pounds a character of the strength can carry.
+func Capacity(str int) int {
+	return str * 15
+}
+
+// Encumbrance returns how burdened a character carrying the load is, given
+// how much they can carry.
+func Encumbrance(load, capacity int) string {
+	switch {
+	case load > capacity:
+		return Overloaded
+	case load*4 > capacity*3:
+		return Strained
+	case load*2 > capacity:
+		return Burdened
+	}
+	return Unburdened
+}
+
+// EncumbrancePenalty returns how much the encumbrance takes off the attack
+// rolls and the armor class.
+func EncumbrancePenalty(level string) int {
+	switch level {
+	case Burdened:
+		return 1
+	case Strained:
+		return 3
+	case Overloaded:
+		return 5
+	}
+	return 0
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/droslean/thyranew/server"
+	"golang.org/x/crypto/ssh"
+)
+
+// genkey makes a new host key for the server. Players who connected before
+// are warned by their clients that the key changed, so a key already there
+// is only replaced with -force.
+func genkey(args []string) int {
+	fs := newFlags("genkey", "")
+	dbPath, _ := dataFlags(fs)
+	force := fs.Bool("force", false, "Replace the host key the server has")
+	fs.Parse(args)
+	quiet()
+
+	db, err := server.OpenDatabase(*dbPath, false)
+	if err != nil {
+		return fail(err)
+	}
+	defer db.Close()
+
+	if db.HasHostKey() && !*force {
+		return fail(fmt.Errorf("the server has a host key already, replace it with -force"))
+	}
+	key, err := db.NewHostKey()
+	if err != nil {
+		return fail(err)
+	}
+	fmt.Printf("New host key %s\n", ssh.FingerprintSHA256(key.PublicKey()))
+	return 0
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/droslean/thyranew/mudimport"
+
+	log "gopkg.in/inconshreveable/log15.v2"
+)
+
+// importAreas converts the areas of classic MUDs into the static directory,
+// as thyra import does.
+func importAreas(args []string) int {
+	fs := newFlags("import", "files...")
+	format := fs.String("format", "rom", "Format of the files: rom for ROM and Merc, circle for CircleMUD")
+	static := staticFlag(fs)
+	dryRun := fs.Bool("n", false, "Read and convert the files without writing anything")
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return 2
+	}
+
+	worlds, err := mudimport.ReadFiles(*format, fs.Args())
+	if err != nil {
+		log.Error(err.Error())
+		return 1
+	}
+	for _, c := range mudimport.Convert(worlds...) {
+		for _, note := range c.Notes {
+			log.Warn(fmt.Sprintf("%s: %s", c.Area.Name, note))
+		}
+		log.Info(fmt.Sprintf("%s: %d rooms, %d NPCs, %d loot tables", c.Area.Name, len(c.Area.Rooms), len(c.Area.NPCs), len(c.Loot)))
+		if *dryRun {
+			continue
+		}
+		if err := c.Write(staticDir(*static)); err != nil {
+			log.Error(fmt.Sprintf("%s could not be written: %v", c.Area.Name, err))
+			return 1
+		}
+	}
+	return 0
+}
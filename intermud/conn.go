@@ -0,0 +1,167 @@
+package intermud
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// loginTimeout is how long the hub has to take the login.
+const loginTimeout = 30 * time.Second
+
+// Config is how a server logs in to a hub.
+type Config struct {
+	Hub            string // Host and port of the hub
+	Name           string // What the network knows the server by
+	ClientPassword string // The password of the server
+	ServerPassword string // The password of the hub
+	Version        string // The name and version of the server software
+}
+
+// Message is a message on a channel of the network.
+type Message struct {
+	Channel string // The name of the channel on the network, like Server01:ichat
+	From    string // The player who sent it
+	Mud     string // The MUD the player is on
+	Text    string
+	Emote   bool // The text is what the player does rather than says
+}
+
+// Conn is the connection of a server to a hub.
+type Conn struct {
+	config   Config
+	hub      string // The name of the hub
+	network  string // The name of the network
+	conn     net.Conn
+	lines    *bufio.Reader
+	mu       sync.Mutex // Serializes the writes
+	sequence int64
+}
+
+// Dial connects to the hub and logs in, or tells why the hub refused.
+func Dial(ctx context.Context, config Config) (*Conn, error) {
+	conn, err := (&net.Dialer{}).DialContext(ctx, "tcp", config.Hub)
+	if err != nil {
+		return nil, err
+	}
+	c := &Conn{config: config, conn: conn, lines: bufio.NewReader(conn), sequence: time.Now().Unix()}
+	if err := c.login(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// login sends the passwords and reads the answer of the hub, which names
+// itself and the network.
+func (c *Conn) login() error {
+	c.conn.SetDeadline(time.Now().Add(loginTimeout))
+	defer c.conn.SetDeadline(time.Time{})
+
+	if err := c.writeLine(fmt.Sprintf("PW %s %s version=2 autosetup %s", c.config.Name, c.config.ClientPassword, c.config.ServerPassword)); err != nil {
+		return err
+	}
+	line, err := c.lines.ReadString('\n')
+	if err != nil {
+		return fmt.Errorf("the hub closed before the login: %v", err)
+	}
+	line = strings.TrimSpace(line)
+	fields := strings.Fields(line)
+	switch {
+	case len(fields) >= 5 && fields[0] == "PW":
+		if fields[2] != c.config.ServerPassword {
+			return fmt.Errorf("the hub %s does not know the server password", fields[1])
+		}
+		c.hub, c.network = fields[1], fields[4]
+	case len(fields) >= 4 && fields[0] == "autosetup" && fields[2] == "accept":
+		c.hub, c.network = fields[1], fields[3]
+	default:
+		return fmt.Errorf("the hub refused the login: %s", line)
+	}
+	// Asking the others whether they are alive tells them this one is.
+	return c.send(&Packet{From: "*@" + c.config.Name, Type: "keepalive-request", To: "*@*"})
+}
+
+// Hub returns the name of the hub.
+func (c *Conn) Hub() string {
+	return c.hub
+}
+
+// Network returns the name of the network.
+func (c *Conn) Network() string {
+	return c.network
+}
+
+// Close closes the connection.
+func (c *Conn) Close() error {
+	return c.conn.Close()
+}
+
+// Receive returns the next message on the channels of the network, from the
+// other MUDs. The keepalives of the hub are answered on the way.
+func (c *Conn) Receive() (*Message, error) {
+	for {
+		line, err := c.lines.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		p, err := Parse(line)
+		if err != nil {
+			// One packet the server does not understand is no reason to drop
+			// the whole network.
+			continue
+		}
+		from, mud := splitName(p.From)
+		switch p.Type {
+		case "keepalive-request":
+			reply := &Packet{From: "*@" + c.config.Name, Type: "is-alive", To: "*@*"}
+			reply.Set("versionid", c.config.Version)
+			reply.Set("networkname", c.network)
+			if err := c.send(reply); err != nil {
+				return nil, err
+			}
+		case "ice-msg-b":
+			if strings.EqualFold(mud, c.config.Name) {
+				continue
+			}
+			emote := p.Data["emote"] != "" && p.Data["emote"] != "0"
+			return &Message{Channel: p.Data["channel"], From: from, Mud: mud, Text: p.Data["text"], Emote: emote}, nil
+		}
+	}
+}
+
+// Say sends what the player said or did on the channel of the network.
+func (c *Conn) Say(from, channel, text string, emote bool) error {
+	p := &Packet{From: from + "@" + c.config.Name, Type: "ice-msg-b", To: "*@*"}
+	p.Set("channel", channel)
+	p.Set("text", text)
+	p.Set("emote", "0")
+	if emote {
+		p.Set("emote", "1")
+	}
+	p.Set("echo", "0")
+	return c.send(p)
+}
+
+// send numbers the packet and sends it from this server.
+func (c *Conn) send(p *Packet) error {
+	p.Sequence = atomic.AddInt64(&c.sequence, 1)
+	p.Route = c.config.Name
+	return c.writeLine(p.String())
+}
+
+// writeLine writes a line, ended the way the other clients of IMC2 end them.
+func (c *Conn) writeLine(line string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, err := c.conn.Write([]byte(line + "\n\r"))
+	return err
+}
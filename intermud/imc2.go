@@ -0,0 +1,134 @@
+// Package intermud speaks IMC2, the protocol of the intermud chat networks, so
+// the players of a server can chat with the players of other MUDs on the
+// channels of a network. A server connects to a hub of the network with the
+// passwords it registered there, and the hub passes the packets of its
+// channels on to it and from it.
+//
+// Only what the channels need is spoken: the login, the keepalives and the
+// broadcasts of the channels. Every other packet is ignored.
+package intermud
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Packet is one line of the protocol:
+//
+//	sender@origin sequence route type target@destination key=value...
+type Packet struct {
+	From     string // The sender, with the MUD it is on after an @
+	Sequence int64
+	Route    string // The MUDs the packet went through, separated by !
+	Type     string
+	To       string // The target, with the MUD it is for after an @, *@* for all
+	Data     map[string]string
+	// keys are the keys of the data in the order they are written.
+	keys []string
+}
+
+// Set sets a value of the data of the packet.
+func (p *Packet) Set(key, value string) {
+	if p.Data == nil {
+		p.Data = make(map[string]string)
+	}
+	if _, ok := p.Data[key]; !ok {
+		p.keys = append(p.keys, key)
+	}
+	p.Data[key] = value
+}
+
+// String returns the packet as a line of the protocol, without its end.
+func (p *Packet) String() string {
+	b := &strings.Builder{}
+	fmt.Fprintf(b, "%s %d %s %s %s", p.From, p.Sequence, p.Route, p.Type, p.To)
+	for _, key := range p.keys {
+		b.WriteString(" " + key + "=" + quote(p.Data[key]))
+	}
+	return b.String()
+}
+
+// Parse reads a line of the protocol.
+func Parse(line string) (*Packet, error) {
+	fields := strings.SplitN(strings.Trim(line, "\r\n"), " ", 6)
+	if len(fields) < 5 {
+		return nil, fmt.Errorf("malformed packet %q", line)
+	}
+	p := &Packet{From: fields[0], Route: fields[2], Type: fields[3], To: fields[4], Data: make(map[string]string)}
+	if _, err := fmt.Sscan(fields[1], &p.Sequence); err != nil {
+		return nil, fmt.Errorf("malformed sequence in packet %q", line)
+	}
+	if len(fields) == 6 {
+		if err := p.parseData(fields[5]); err != nil {
+			return nil, fmt.Errorf("%v in packet %q", err, line)
+		}
+	}
+	return p, nil
+}
+
+// parseData reads the key=value pairs after the header of a packet. Values
+// with spaces are quoted, and have their quotes, backslashes and line ends
+// escaped with a backslash.
+func (p *Packet) parseData(s string) error {
+	for {
+		s = strings.TrimLeft(s, " ")
+		if s == "" {
+			return nil
+		}
+		eq := strings.IndexByte(s, '=')
+		if eq <= 0 {
+			return fmt.Errorf("malformed data %q", s)
+		}
+		key := s[:eq]
+		s = s[eq+1:]
+		if !strings.HasPrefix(s, `"`) {
+			value := s
+			if sp := strings.IndexByte(s, ' '); sp >= 0 {
+				value, s = s[:sp], s[sp:]
+			} else {
+				s = ""
+			}
+			p.Set(key, value)
+			continue
+		}
+		value := &strings.Builder{}
+		i := 1
+		for ; i < len(s) && s[i] != '"'; i++ {
+			if s[i] == '\\' && i+1 < len(s) {
+				i++
+				switch s[i] {
+				case 'n':
+					value.WriteByte('\n')
+				case 'r':
+					value.WriteByte('\r')
+				default:
+					value.WriteByte(s[i])
+				}
+				continue
+			}
+			value.WriteByte(s[i])
+		}
+		if i == len(s) {
+			return fmt.Errorf("unterminated value of %s", key)
+		}
+		p.Set(key, value.String())
+		s = s[i+1:]
+	}
+}
+
+// quote returns the value as it goes in the data of a packet.
+func quote(value string) string {
+	if value != "" && !strings.ContainsAny(value, " \"\\\r\n=") {
+		return value
+	}
+	r := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`, "\r", `\r`)
+	return `"` + r.Replace(value) + `"`
+}
+
+// splitName splits a sender or target into the name and the MUD.
+func splitName(s string) (string, string) {
+	if i := strings.LastIndexByte(s, '@'); i >= 0 {
+		return s[:i], s[i+1:]
+	}
+	return s, ""
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/droslean/thyranew/worldlint"
+)
+
+// lint checks the world of the static directory, as thyra lint, and returns
+// the code to exit with: 1 if it found errors, 2 if the world did not load.
+//
+//	thyra lint -static static -json
+func lint(args []string) int {
+	fs := newFlags("lint", "")
+	static := staticFlag(fs)
+	asJSON := fs.Bool("json", false, "Write the report as JSON")
+	starts := fs.String("start", "City/Inn,Jail/Cell", "Rooms players get to without exits, as area/room separated by commas")
+	fs.Parse(args)
+
+	w, err := worldlint.Load(staticDir(*static), strings.Split(*starts, ","))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "The world could not be loaded: %v\n", err)
+		return 2
+	}
+	report := w.Lint()
+	if *asJSON {
+		err = report.WriteJSON(os.Stdout)
+	} else {
+		_, err = fmt.Print(report)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	if !report.OK() {
+		return 1
+	}
+	return 0
+}
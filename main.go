@@ -1,14 +1,28 @@
+// Command thyra runs the server, and the chores around it.
+//
+//	thyra serve -port 3030 -static static
+//	thyra lint -json
+//	thyra import -format rom midgaard.are
+//	thyra export -o players.json
+//	thyra backup -o thyra.tar.gz
+//	thyra genkey
+//	thyra adduser -role builder -key id_ed25519.pub Alice
+//	thyra simulate -seed 1 scenario.txt
+//	thyra replay thyra.journal
+//
+// Without a command it serves, so thyra -port 3030 still does. The flags
+// given override the environment, which overrides server.toml, so servers in
+// containers can be set up with the environment alone.
 package main
 
-// Test
 import (
 	"bytes"
 	"flag"
 	"fmt"
 	"os"
 	"path/filepath"
-
-	"github.com/droslean/thyranew/server"
+	"sort"
+	"strings"
 
 	log "gopkg.in/inconshreveable/log15.v2"
 	"gopkg.in/inconshreveable/log15.v2/stack"
@@ -36,26 +50,108 @@ func customFormat() log.Format {
 	})
 }
 
+// command is what thyra does, by its name on the command line. It returns
+// the code to exit with.
+type command struct {
+	run  func(args []string) int
+	help string
+}
+
+var commands map[string]command
+
 func init() {
 	h := log.StreamHandler(os.Stdout, customFormat())
 	log.Root().SetHandler(h)
-	flag.Parse()
-}
 
-var port = flag.Int("port", 3030, "Port to listen on incoming connections")
+	// The commands are set here, since their flags show the help of the
+	// commands.
+	commands = map[string]command{
+		"serve":    {serve, "Run the server"},
+		"lint":     {lint, "Check the world for broken exits, rooms nobody gets to and missing NPCs, loot tables and quests"},
+		"import":   {importAreas, "Bring the areas of ROM, Merc and CircleMUD over to the static directory"},
+		"export":   {export, "Write the saved players as JSON"},
+		"backup":   {backup, "Archive the database and the static and data directories"},
+		"genkey":   {genkey, "Make a new host key for the server"},
+		"adduser":  {adduser, "Add a player, like the first admin or a builder"},
+		"simulate": {simulate, "Play a scenario against a simulated world, and check what the players see"},
+		"replay":   {replay, "Play a journal of thyra serve -journal back against a simulated world, and check it comes out the same"},
+	}
+}
 
 func main() {
-	db, err := server.NewDatabase(filepath.Join(os.TempDir(), "thyra.db"), true)
-	if err != nil {
-		log.Error(err.Error())
-		os.Exit(1)
+	args := os.Args[1:]
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") && args[0] != "-h" && args[0] != "-help" {
+		os.Exit(serve(args))
 	}
+	c, ok := commands[args[0]]
+	if !ok {
+		usage()
+		if args[0] == "help" || args[0] == "-h" || args[0] == "-help" {
+			os.Exit(0)
+		}
+		os.Exit(2)
+	}
+	os.Exit(c.run(args[1:]))
+}
 
-	s, err := server.NewServer(db, *port)
-	if err != nil {
-		log.Error(err.Error())
-		os.Exit(1)
+func usage() {
+	fmt.Fprintln(os.Stderr, "Usage: thyra <command> [flags] [args]")
+	fmt.Fprintln(os.Stderr, "\nCommands:")
+	names := []string{}
+	for name := range commands {
+		names = append(names, name)
 	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  %-9s %s\n", name, commands[name].help)
+	}
+	fmt.Fprintln(os.Stderr, "\nRun thyra <command> -h for the flags of the command.")
+}
+
+// newFlags returns the flags of the command, with the usage of the arguments
+// it takes after them.
+func newFlags(name, args string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: thyra %s [flags] %s\n\n%s.\n\nFlags:\n", name, args, commands[name].help)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// staticFlag adds the flag of the static directory the world is read from.
+func staticFlag(fs *flag.FlagSet) *string {
+	return fs.String("static", os.Getenv("THYRA_STATIC"), "Static directory the world is read from, THYRA_STATIC or ./static unless set")
+}
+
+// staticDir returns the static directory the flag gave, or the default one.
+func staticDir(flagged string) string {
+	if flagged == "" {
+		return "static"
+	}
+	return flagged
+}
+
+// dataFlags adds the flags of where the database and the data directory
+// are, which THYRA_DB and THYRA_DATA set too.
+func dataFlags(fs *flag.FlagSet) (db, data *string) {
+	dbPath := os.Getenv("THYRA_DB")
+	if dbPath == "" {
+		dbPath = filepath.Join(os.TempDir(), "thyra.db")
+	}
+	db = fs.String("db", dbPath, "Database file, THYRA_DB unless set")
+	data = fs.String("data", os.Getenv("THYRA_DATA"), "Data directory the players are saved in, THYRA_DATA or the static directory unless set")
+	return db, data
+}
+
+// quiet sends the log to the standard error, from warnings up, so it stays
+// out of what commands write.
+func quiet() {
+	log.Root().SetHandler(log.LvlFilterHandler(log.LvlWarn, log.StreamHandler(os.Stderr, customFormat())))
+}
 
-	s.StartServer()
+// fail writes the error and returns the code to exit with.
+func fail(err error) int {
+	fmt.Fprintln(os.Stderr, err)
+	return 1
 }
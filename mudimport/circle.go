@@ -0,0 +1,64 @@
+package mudimport
+
+import (
+	"io"
+	"strings"
+)
+
+// CircleFiles are the world files of a CircleMUD zone. Any of them can be left
+// out.
+type CircleFiles struct {
+	World   io.Reader // .wld, the rooms
+	Mobs    io.Reader // .mob
+	Objects io.Reader // .obj
+	Zone    io.Reader // .zon, the name and the resets
+}
+
+// ParseCircle reads the world files of a CircleMUD zone.
+func ParseCircle(files CircleFiles) (*World, error) {
+	w := newWorld()
+	if files.World != nil {
+		if err := readRooms(newReader(files.World), w, false); err != nil && err != io.EOF {
+			return nil, err
+		}
+	}
+	if files.Mobs != nil {
+		if err := readMobs(newReader(files.Mobs), w, false); err != nil && err != io.EOF {
+			return nil, err
+		}
+	}
+	if files.Objects != nil {
+		if err := readObjects(newReader(files.Objects), w, false); err != nil && err != io.EOF {
+			return nil, err
+		}
+	}
+	if files.Zone != nil {
+		if err := readZone(newReader(files.Zone), w); err != nil && err != io.EOF {
+			return nil, err
+		}
+	}
+	return w, nil
+}
+
+// readZone reads the name and the resets of the zone. tbaMUD writes the
+// builders of the zone before its name.
+func readZone(rd *reader, w *World) error {
+	if _, _, err := rd.vnum(); err != nil {
+		return err
+	}
+	name, err := rd.str()
+	if err != nil {
+		return err
+	}
+	if b, err := rd.peek(); err == nil && (b < '0' || b > '9') {
+		if name, err = rd.str(); err != nil {
+			return err
+		}
+	}
+	w.Name = strings.TrimSpace(name)
+	// The vnums the zone spans, its lifespan and how it resets.
+	if _, err := rd.fields(); err != nil {
+		return err
+	}
+	return readResets(rd, w)
+}
@@ -0,0 +1,294 @@
+package mudimport
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/droslean/thyranew/area"
+	"github.com/droslean/thyranew/game"
+	"github.com/gothyra/toml"
+)
+
+// Rooms of classic MUDs are a single place, while the rooms of thyra are grids
+// of cubes. Every room is laid out on the same square: a floor with the doors
+// of its exits in the middle of its sides, and portals for up and down.
+const roomSize = 7
+
+type cell struct {
+	x, y int
+}
+
+// doorCells are where the doors of each direction go, and arrivals where those
+// coming in through them arrive, right inside the opposite door.
+var (
+	doorCells = map[int]cell{
+		North: {3, 0},
+		East:  {roomSize - 1, 3},
+		South: {3, roomSize - 1},
+		West:  {0, 3},
+		Up:    {1, 1},
+		Down:  {roomSize - 2, roomSize - 2},
+	}
+	arrivals = map[int]cell{
+		North: {3, roomSize - 2},
+		East:  {1, 3},
+		South: {3, 1},
+		West:  {roomSize - 2, 3},
+		Up:    {3, 3},
+		Down:  {3, 3},
+	}
+	// npcCells are where the NPCs of a room stand, in turn.
+	npcCells = []cell{{2, 3}, {4, 3}, {3, 2}, {3, 4}, {2, 2}, {4, 4}, {2, 4}, {4, 2}}
+)
+
+func cubeID(c cell) string {
+	return strconv.Itoa(c.y*roomSize + c.x + 1)
+}
+
+// Converted is an area of thyra made out of a world, along with the loot
+// tables of its NPCs.
+type Converted struct {
+	Area area.Area
+	Loot []game.LootTable
+	// Notes tell what could not be carried over.
+	Notes []string
+}
+
+// place is where a room of the worlds ended up.
+type place struct {
+	area, room string
+}
+
+// Convert makes an area out of every world. Exits between the worlds are kept,
+// so the areas of a whole MUD are best converted together.
+func Convert(worlds ...*World) []Converted {
+	places := map[int]place{}
+	for _, w := range worlds {
+		taken := map[string]bool{}
+		for _, r := range sortedRooms(w) {
+			name := strings.TrimSpace(r.Name)
+			if name == "" || taken[name] {
+				name = fmt.Sprintf("%s %d", name, r.Vnum)
+			}
+			taken[name] = true
+			places[r.Vnum] = place{area: w.Name, room: strings.TrimSpace(name)}
+		}
+	}
+
+	converted := []Converted{}
+	for _, w := range worlds {
+		converted = append(converted, convert(w, places))
+	}
+	return converted
+}
+
+func sortedRooms(w *World) []Room {
+	rooms := append([]Room(nil), w.Rooms...)
+	sort.Slice(rooms, func(i, j int) bool { return rooms[i].Vnum < rooms[j].Vnum })
+	return rooms
+}
+
+func convert(w *World, places map[int]place) Converted {
+	c := Converted{
+		Area:  area.Area{Name: w.Name, Intro: w.Name, Rooms: map[string]area.Room{}},
+		Notes: append([]string(nil), w.Notes...),
+	}
+
+	closed := map[[2]int]bool{}
+	for _, r := range w.Resets {
+		if r.Command == 'D' && r.Args[2] > 0 {
+			closed[[2]int{r.Args[0], r.Args[1]}] = true
+			if r.Args[2] > 1 {
+				c.note("The door %s of room %d is locked, but thyra only closes it", dirName(r.Args[1]), r.Args[0])
+			}
+		}
+	}
+
+	for _, r := range sortedRooms(w) {
+		c.Area.Rooms[places[r.Vnum].room] = c.room(r, places, closed)
+	}
+	c.spawn(w, places)
+	return c
+}
+
+func (c *Converted) note(format string, args ...interface{}) {
+	c.Notes = append(c.Notes, fmt.Sprintf(format, args...))
+}
+
+func dirName(dir int) string {
+	if dir < 0 || dir >= len(dirNames) {
+		return strconv.Itoa(dir)
+	}
+	return dirNames[dir]
+}
+
+// room lays out the room on its square.
+func (c *Converted) room(r Room, places map[int]place, closed map[[2]int]bool) area.Room {
+	room := area.Room{
+		Name:        places[r.Vnum].room,
+		Description: strings.TrimSpace(r.Description) + "\n",
+		Dark:        r.Flags&roomDark != 0,
+		Outdoors:    r.Flags&roomIndoors == 0 && r.Sector != sectorInside,
+		Water:       r.Sector == sectorWaterSwim || r.Sector == sectorWaterNoSwm,
+	}
+
+	special := map[cell]area.Cube{}
+	for _, e := range r.Exits {
+		at, ok := doorCells[e.Dir]
+		if !ok {
+			c.note("Room %d has an exit in direction %d, which was left out", r.Vnum, e.Dir)
+			continue
+		}
+		to, ok := places[e.To]
+		if !ok {
+			c.note("The exit %s of room %d leads to room %d, which was not imported", dirName(e.Dir), r.Vnum, e.To)
+			continue
+		}
+		cube := area.Cube{
+			ID:    cubeID(at),
+			POSX:  strconv.Itoa(at.x),
+			POSY:  strconv.Itoa(at.y),
+			Type:  "door",
+			Exits: []area.Exit{{ToArea: to.area, ToRoom: to.room, ToCubeID: cubeID(arrivals[e.Dir])}},
+		}
+		if e.Dir == Up || e.Dir == Down {
+			cube.Type = "portal"
+		} else {
+			cube.Closed = e.Door && closed[[2]int{r.Vnum, e.Dir}]
+		}
+		special[at] = cube
+	}
+
+	for x := 0; x < roomSize; x++ {
+		for y := 0; y < roomSize; y++ {
+			at := cell{x, y}
+			if cube, ok := special[at]; ok {
+				room.Cubes = append(room.Cubes, cube)
+				continue
+			}
+			if x == 0 || y == 0 || x == roomSize-1 || y == roomSize-1 {
+				continue
+			}
+			room.Cubes = append(room.Cubes, area.Cube{ID: cubeID(at), POSX: strconv.Itoa(x), POSY: strconv.Itoa(y)})
+		}
+	}
+	return room
+}
+
+// spawn places the mobs of the resets as NPCs. What they are given becomes
+// their loot.
+func (c *Converted) spawn(w *World, places map[int]place) {
+	standing := map[string]int{}
+	loot := map[int]*game.LootTable{}
+	// Mobs loaded again are given the same, so their loot is only made once.
+	lastMob, known := -1, false
+	floor := 0
+	for _, r := range w.Resets {
+		switch r.Command {
+		case 'M':
+			mob, ok := w.Mobs[r.Args[0]]
+			at, inArea := places[r.Args[2]]
+			if !ok || !inArea || at.area != w.Name {
+				c.note("Mob %d in room %d was not imported", r.Args[0], r.Args[2])
+				lastMob = -1
+				continue
+			}
+			lastMob = mob.Vnum
+			_, known = loot[mob.Vnum]
+			npc := area.NPC{
+				Name:     properName(mob.Short),
+				Location: area.Location{Area: w.Name, Room: at.room, Position: cubeID(npcCells[standing[at.room]%len(npcCells)])},
+				PC:       game.PC{Level: mob.Level},
+				Gender:   mob.Gender,
+			}
+			standing[at.room]++
+			if t, ok := loot[mob.Vnum]; ok {
+				npc.Loot = t.Name
+			}
+			c.Area.NPCs = append(c.Area.NPCs, npc)
+		case 'G', 'E':
+			obj, ok := w.Objects[r.Args[0]]
+			if lastMob < 0 || known || !ok {
+				continue
+			}
+			t := loot[lastMob]
+			if t == nil {
+				mob := w.Mobs[lastMob]
+				t = &game.LootTable{Name: slug(w.Name + " " + properName(mob.Short))}
+				loot[lastMob] = t
+				c.Area.NPCs[len(c.Area.NPCs)-1].Loot = t.Name
+			}
+			t.Entries = append(t.Entries, game.LootEntry{Item: properName(obj.Short)})
+			t.Rolls = len(t.Entries)
+		case 'O', 'P':
+			floor++
+		}
+	}
+	if floor > 0 {
+		c.note("%d objects left in rooms or containers were not imported, thyra has no items lying around", floor)
+	}
+
+	vnums := []int{}
+	for vnum := range loot {
+		vnums = append(vnums, vnum)
+	}
+	sort.Ints(vnums)
+	for _, vnum := range vnums {
+		c.Loot = append(c.Loot, *loot[vnum])
+	}
+}
+
+// properName turns "the cityguard" into "Cityguard", and "a loaf of bread"
+// into "Loaf of Bread".
+func properName(short string) string {
+	words := strings.Fields(short)
+	if len(words) > 1 && smallWords[strings.ToLower(words[0])] {
+		words = words[1:]
+	}
+	for i, word := range words {
+		if i > 0 && smallWords[word] {
+			continue
+		}
+		words[i] = strings.ToUpper(word[:1]) + word[1:]
+	}
+	return strings.Join(words, " ")
+}
+
+var smallWords = map[string]bool{"a": true, "an": true, "the": true, "some": true, "of": true, "and": true}
+
+// slug makes a name fit for a file.
+func slug(name string) string {
+	return strings.ToLower(strings.Join(strings.FieldsFunc(name, func(r rune) bool {
+		return !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9')
+	}), "-"))
+}
+
+// Write writes the area and its loot tables into the static directory.
+func (c Converted) Write(staticDir string) error {
+	if err := writeTOML(filepath.Join(staticDir, "areas", slug(c.Area.Name)+".toml"), c.Area); err != nil {
+		return err
+	}
+	for _, t := range c.Loot {
+		if err := writeTOML(filepath.Join(staticDir, "loot", t.Name+".toml"), t); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeTOML(path string, v interface{}) error {
+	var buf bytes.Buffer
+	if err := toml.NewEncoder(&buf).Encode(v); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, buf.Bytes(), 0644)
+}
@@ -0,0 +1,102 @@
+package mudimport
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// ReadFiles reads the files of the format, "rom" for ROM and Merc .are files
+// or "circle" for the world files of CircleMUD zones. The world files of a
+// zone are told apart by their extensions, and named after their zone
+// number. Worlds with no name of their own are named after their files.
+func ReadFiles(format string, paths []string) ([]*World, error) {
+	switch format {
+	case "rom":
+		return readROM(paths)
+	case "circle":
+		return readCircle(paths)
+	}
+	return nil, fmt.Errorf("unknown format %q", format)
+}
+
+// base returns the name of the file without its directory and extension.
+func base(path string) string {
+	return strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+}
+
+func readROM(paths []string) ([]*World, error) {
+	worlds := []*World{}
+	for _, path := range paths {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		w, err := ParseROM(f)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+		if w.Name == "" {
+			w.Name = base(path)
+		}
+		worlds = append(worlds, w)
+	}
+	return worlds, nil
+}
+
+func readCircle(paths []string) ([]*World, error) {
+	zones := map[string]map[string]string{}
+	for _, path := range paths {
+		ext := strings.TrimPrefix(filepath.Ext(path), ".")
+		switch ext {
+		case "wld", "mob", "obj", "zon":
+		default:
+			return nil, fmt.Errorf("%s is not a world file of CircleMUD", path)
+		}
+		if zones[base(path)] == nil {
+			zones[base(path)] = map[string]string{}
+		}
+		zones[base(path)][ext] = path
+	}
+	names := []string{}
+	for name := range zones {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	worlds := []*World{}
+	for _, name := range names {
+		files := CircleFiles{}
+		readers := map[string]*io.Reader{
+			"wld": &files.World,
+			"mob": &files.Mobs,
+			"obj": &files.Objects,
+			"zon": &files.Zone,
+		}
+		opened := []*os.File{}
+		for ext, path := range zones[name] {
+			f, err := os.Open(path)
+			if err != nil {
+				return nil, err
+			}
+			opened = append(opened, f)
+			*readers[ext] = f
+		}
+		w, err := ParseCircle(files)
+		for _, f := range opened {
+			f.Close()
+		}
+		if err != nil {
+			return nil, fmt.Errorf("zone %s: %v", name, err)
+		}
+		if w.Name == "" {
+			w.Name = name
+		}
+		worlds = append(worlds, w)
+	}
+	return worlds, nil
+}
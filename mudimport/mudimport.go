@@ -0,0 +1,323 @@
+// Package mudimport reads the areas of classic MUDs, the .are files of ROM
+// and Merc and the world files of CircleMUD, and turns them into areas of
+// thyra, so old content can be brought over instead of built again by hand.
+//
+// The files are read into a World first, which keeps what the formats share,
+// and Convert makes areas out of it.
+package mudimport
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Directions of the exits, numbered the way the files number them.
+const (
+	North = iota
+	East
+	South
+	West
+	Up
+	Down
+)
+
+var dirNames = []string{"north", "east", "south", "west", "up", "down"}
+
+// Flags of the rooms and sectors the import cares about. ROM, Merc and
+// CircleMUD all number them the same.
+const (
+	roomDark    = 1 << 0
+	roomIndoors = 1 << 3
+
+	sectorInside     = 0
+	sectorWaterSwim  = 6
+	sectorWaterNoSwm = 7
+)
+
+// World is what was read out of the files of one area.
+type World struct {
+	Name    string
+	Rooms   []Room
+	Mobs    map[int]Mob
+	Objects map[int]Object
+	Resets  []Reset
+	// Notes tell what was in the files but could not be read into the world.
+	Notes []string
+}
+
+func newWorld() *World {
+	return &World{Mobs: map[int]Mob{}, Objects: map[int]Object{}}
+}
+
+// Room is a room, with its exits.
+type Room struct {
+	Vnum        int
+	Name        string
+	Description string
+	Flags       int
+	Sector      int
+	Exits       []Exit
+}
+
+// Exit leads from a room to another.
+type Exit struct {
+	Dir  int
+	To   int  // Vnum of the room the exit leads to, below zero for none
+	Door bool // Exits with a door can be closed
+}
+
+// Mob is the template of an NPC.
+type Mob struct {
+	Vnum     int
+	Keywords string
+	Short    string // What the NPC is called, e.g. "the cityguard"
+	Level    int
+	Gender   string // Male, female, or empty
+}
+
+// Object is the template of an item.
+type Object struct {
+	Vnum     int
+	Keywords string
+	Short    string
+}
+
+// Reset puts things in the world when the area is reset. The commands are
+// the same in all the formats: M loads a mob in a room, G and E give an object
+// to the last mob loaded, O leaves an object in a room, P puts one in another
+// and D sets the state of a door.
+type Reset struct {
+	Command byte
+	Args    [3]int // The arguments past the if-flag
+}
+
+// note keeps what could not be read.
+func (w *World) note(format string, args ...interface{}) {
+	w.Notes = append(w.Notes, fmt.Sprintf(format, args...))
+}
+
+// readRooms reads rooms up to the end of their list. ROM and Merc end it with
+// #0, CircleMUD with $.
+func readRooms(rd *reader, w *World, zeroEnds bool) error {
+	for {
+		vnum, end, err := rd.vnum()
+		if err != nil {
+			return err
+		}
+		if end || (zeroEnds && vnum == 0) {
+			return nil
+		}
+		room := Room{Vnum: vnum}
+		if room.Name, err = rd.str(); err != nil {
+			return err
+		}
+		if room.Description, err = rd.str(); err != nil {
+			return err
+		}
+		// The area or zone of the room comes first and the sector last. Some
+		// versions of CircleMUD write the flags in more than one number.
+		fields, err := rd.fields()
+		if err != nil {
+			return err
+		}
+		if len(fields) < 3 {
+			return rd.errorf("room %d has too few numbers", vnum)
+		}
+		for _, f := range fields[1 : len(fields)-1] {
+			n, _ := parseNumber(f)
+			room.Flags |= n
+		}
+		room.Sector, _ = strconv.Atoi(fields[len(fields)-1])
+
+		if err := readRoomExtras(rd, &room); err != nil {
+			return err
+		}
+		w.Rooms = append(w.Rooms, room)
+	}
+}
+
+// readRoomExtras reads the exits and the rest of the room, up to its S.
+func readRoomExtras(rd *reader, room *Room) error {
+	for {
+		w, err := rd.word()
+		if err != nil {
+			return err
+		}
+		switch {
+		case w == "S":
+			return nil
+		case strings.HasPrefix(w, "D"):
+			dir, err := strconv.Atoi(w[1:])
+			if err != nil {
+				return rd.errorf("bad exit %q in room %d", w, room.Vnum)
+			}
+			// The description and the keywords of the door.
+			if _, err := rd.str(); err != nil {
+				return err
+			}
+			if _, err := rd.str(); err != nil {
+				return err
+			}
+			fields, err := rd.fields()
+			if err != nil {
+				return err
+			}
+			if len(fields) < 3 {
+				return rd.errorf("exit %q of room %d has too few numbers", w, room.Vnum)
+			}
+			locks, _ := parseNumber(fields[0])
+			to, _ := strconv.Atoi(fields[2])
+			room.Exits = append(room.Exits, Exit{Dir: dir, To: to, Door: locks != 0})
+		case w == "E":
+			// Extra descriptions, which thyra has no place for.
+			if _, err := rd.str(); err != nil {
+				return err
+			}
+			if _, err := rd.str(); err != nil {
+				return err
+			}
+		case w == "C" || w == "O":
+			// The clan and the owner of ROM rooms.
+			if _, err := rd.str(); err != nil {
+				return err
+			}
+		default:
+			// Healing and mana rates of ROM rooms, and whatever else other
+			// versions keep on a line of its own.
+			if _, err := rd.restOfLine(); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// readMobs reads mobs up to the end of their list. Only what thyra uses is
+// kept, the rest of each mob is skipped.
+func readMobs(rd *reader, w *World, zeroEnds bool) error {
+	for {
+		vnum, end, err := rd.vnum()
+		if err != nil {
+			return err
+		}
+		if end || (zeroEnds && vnum == 0) {
+			return nil
+		}
+		mob := Mob{Vnum: vnum}
+		if mob.Keywords, err = rd.str(); err != nil {
+			return err
+		}
+		if mob.Short, err = rd.str(); err != nil {
+			return err
+		}
+		// The long description and the description.
+		for i := 0; i < 2; i++ {
+			if _, err := rd.str(); err != nil {
+				return err
+			}
+		}
+
+		// ROM mobs go on with their race, and the rest with their flags.
+		first, err := rd.fields()
+		if err != nil {
+			return err
+		}
+		rom := len(first) > 0 && strings.HasSuffix(first[0], "~")
+		if rom {
+			if _, err := rd.fields(); err != nil {
+				return err
+			}
+		}
+		levels, err := rd.fields()
+		if err != nil {
+			return err
+		}
+		if len(levels) > 0 {
+			mob.Level, _ = strconv.Atoi(levels[0])
+		}
+		// ROM has the armor and the offense lines next, the others their gold.
+		skip := 1
+		if rom {
+			skip = 2
+		}
+		for i := 0; i < skip; i++ {
+			if _, err := rd.fields(); err != nil {
+				return err
+			}
+		}
+		positions, err := rd.fields()
+		if err != nil {
+			return err
+		}
+		if len(positions) >= 3 {
+			mob.Gender = gender(positions[2])
+		}
+
+		w.Mobs[vnum] = mob
+		if err := rd.skipRecord(); err != nil {
+			return err
+		}
+	}
+}
+
+// gender reads the sex of a mob, written as a number or, in ROM, as a word.
+func gender(sex string) string {
+	switch strings.ToLower(sex) {
+	case "1", "male":
+		return "male"
+	case "2", "female":
+		return "female"
+	}
+	return ""
+}
+
+// readObjects reads objects up to the end of their list, keeping their names.
+func readObjects(rd *reader, w *World, zeroEnds bool) error {
+	for {
+		vnum, end, err := rd.vnum()
+		if err != nil {
+			return err
+		}
+		if end || (zeroEnds && vnum == 0) {
+			return nil
+		}
+		obj := Object{Vnum: vnum}
+		if obj.Keywords, err = rd.str(); err != nil {
+			return err
+		}
+		if obj.Short, err = rd.str(); err != nil {
+			return err
+		}
+		w.Objects[vnum] = obj
+		if err := rd.skipRecord(); err != nil {
+			return err
+		}
+	}
+}
+
+// readResets reads resets up to their S.
+func readResets(rd *reader, w *World) error {
+	for {
+		fields, err := rd.fields()
+		if err != nil {
+			return err
+		}
+		if len(fields) == 0 || fields[0] == "*" || strings.HasPrefix(fields[0], "*") {
+			continue
+		}
+		cmd := fields[0][0]
+		if cmd == 'S' {
+			return nil
+		}
+		reset := Reset{Command: cmd}
+		// The if-flag comes first, then the arguments, then a comment.
+		for i := 0; i < len(reset.Args) && i+2 < len(fields); i++ {
+			n, err := strconv.Atoi(fields[i+2])
+			if err != nil {
+				break
+			}
+			reset.Args[i] = n
+		}
+		w.Resets = append(w.Resets, reset)
+	}
+}
@@ -0,0 +1,201 @@
+package mudimport
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// reader reads the text of the files of classic MUDs. Their strings end with a
+// tilde, and their numbers are written in decimal or as flag letters.
+type reader struct {
+	r    *bufio.Reader
+	line int
+}
+
+func newReader(r io.Reader) *reader {
+	return &reader{r: bufio.NewReader(r), line: 1}
+}
+
+// errorf tells where in the file things went wrong.
+func (rd *reader) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("line %d: %s", rd.line, fmt.Sprintf(format, args...))
+}
+
+func (rd *reader) readByte() (byte, error) {
+	b, err := rd.r.ReadByte()
+	if b == '\n' {
+		rd.line++
+	}
+	return b, err
+}
+
+func (rd *reader) unreadByte(b byte) {
+	rd.r.UnreadByte()
+	if b == '\n' {
+		rd.line--
+	}
+}
+
+// skipSpace skips the blanks before the next word.
+func (rd *reader) skipSpace() error {
+	for {
+		b, err := rd.readByte()
+		if err != nil {
+			return err
+		}
+		if b != ' ' && b != '\t' && b != '\r' && b != '\n' {
+			rd.unreadByte(b)
+			return nil
+		}
+	}
+}
+
+// peek returns the next character past the blanks, without reading it.
+func (rd *reader) peek() (byte, error) {
+	if err := rd.skipSpace(); err != nil {
+		return 0, err
+	}
+	b, err := rd.r.Peek(1)
+	if err != nil {
+		return 0, err
+	}
+	return b[0], nil
+}
+
+// word reads the next word.
+func (rd *reader) word() (string, error) {
+	if err := rd.skipSpace(); err != nil {
+		return "", err
+	}
+	var sb strings.Builder
+	for {
+		b, err := rd.readByte()
+		if err == io.EOF && sb.Len() > 0 {
+			return sb.String(), nil
+		}
+		if err != nil {
+			return "", err
+		}
+		if b == ' ' || b == '\t' || b == '\r' || b == '\n' {
+			rd.unreadByte(b)
+			return sb.String(), nil
+		}
+		sb.WriteByte(b)
+	}
+}
+
+// str reads a string up to its tilde. Blanks before it are skipped, and the
+// line endings in it are made plain.
+func (rd *reader) str() (string, error) {
+	if err := rd.skipSpace(); err != nil {
+		return "", err
+	}
+	var sb strings.Builder
+	for {
+		b, err := rd.readByte()
+		if err != nil {
+			return "", rd.errorf("string never ends")
+		}
+		if b == '~' {
+			return strings.Replace(sb.String(), "\r", "", -1), nil
+		}
+		sb.WriteByte(b)
+	}
+}
+
+// number reads a number, which may also be written as flag letters: A to Z
+// are the bits from 0 up, and a to z the bits from 26 up. Flags added up with
+// a pipe are read as well.
+func (rd *reader) number() (int, error) {
+	w, err := rd.word()
+	if err != nil {
+		return 0, err
+	}
+	n, ok := parseNumber(w)
+	if !ok {
+		return 0, rd.errorf("%q is not a number", w)
+	}
+	return n, nil
+}
+
+func parseNumber(w string) (int, bool) {
+	total := 0
+	for _, part := range strings.Split(w, "|") {
+		if n, err := strconv.Atoi(part); err == nil {
+			total += n
+			continue
+		}
+		for _, c := range part {
+			switch {
+			case c >= 'A' && c <= 'Z':
+				total |= 1 << uint(c-'A')
+			case c >= 'a' && c <= 'z':
+				total |= 1 << uint(26+c-'a')
+			default:
+				return 0, false
+			}
+		}
+	}
+	return total, true
+}
+
+// restOfLine reads the line up to its end.
+func (rd *reader) restOfLine() (string, error) {
+	s, err := rd.r.ReadString('\n')
+	if err == nil {
+		rd.line++
+	}
+	if err == io.EOF && s != "" {
+		err = nil
+	}
+	return strings.TrimSpace(s), err
+}
+
+// fields reads the next line, and splits it into its words.
+func (rd *reader) fields() ([]string, error) {
+	if err := rd.skipSpace(); err != nil {
+		return nil, err
+	}
+	line, err := rd.restOfLine()
+	return strings.Fields(line), err
+}
+
+// skipRecord skips lines up to the next record, the one starting with # or
+// the $ that ends the list, which is left to read.
+func (rd *reader) skipRecord() error {
+	for {
+		b, err := rd.peek()
+		if err != nil {
+			return err
+		}
+		if b == '#' || b == '$' {
+			return nil
+		}
+		if _, err := rd.restOfLine(); err != nil {
+			return err
+		}
+	}
+}
+
+// vnum reads the #vnum that starts a record. It reports the end instead at
+// the $ that ends a list of records.
+func (rd *reader) vnum() (int, bool, error) {
+	w, err := rd.word()
+	if err != nil {
+		return 0, false, err
+	}
+	if strings.HasPrefix(w, "$") || w == "#$" {
+		return 0, true, nil
+	}
+	if !strings.HasPrefix(w, "#") {
+		return 0, false, rd.errorf("expected a #vnum, got %q", w)
+	}
+	n, err := strconv.Atoi(w[1:])
+	if err != nil {
+		return 0, false, rd.errorf("expected a #vnum, got %q", w)
+	}
+	return n, false, nil
+}
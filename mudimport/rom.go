@@ -0,0 +1,109 @@
+package mudimport
+
+import (
+	"io"
+	"strings"
+)
+
+// ParseROM reads an .are file of ROM or Merc. Sections the import has no use
+// for, like helps and specials, are skipped.
+func ParseROM(r io.Reader) (*World, error) {
+	rd := newReader(r)
+	w := newWorld()
+
+	section, err := rd.word()
+	for err == nil {
+		switch section {
+		case "#$":
+			return w, nil
+		case "#AREA":
+			err = readAreaHeader(rd, w)
+		case "#MOBILES":
+			err = readMobs(rd, w, true)
+		case "#OBJECTS":
+			err = readObjects(rd, w, true)
+		case "#ROOMS":
+			err = readRooms(rd, w, true)
+		case "#RESETS":
+			err = readResets(rd, w)
+		default:
+			if !strings.HasPrefix(section, "#") {
+				return nil, rd.errorf("expected a section, got %q", section)
+			}
+			w.note("Section %s was skipped", section)
+			err = skipSection(rd)
+		}
+		if err == nil {
+			section, err = rd.word()
+		}
+	}
+	if err == io.EOF {
+		// Files cut short of their #$ still hold what was read.
+		return w, nil
+	}
+	return nil, err
+}
+
+// readAreaHeader reads the name of the area. ROM writes the file name, the
+// name and the credits as strings of their own, while Merc writes the levels,
+// the author and the name in one.
+func readAreaHeader(rd *reader, w *World) error {
+	strs := []string{}
+	for {
+		b, err := rd.peek()
+		if err != nil {
+			return err
+		}
+		if b == '#' || (b >= '0' && b <= '9') {
+			break
+		}
+		s, err := rd.str()
+		if err != nil {
+			return err
+		}
+		strs = append(strs, s)
+	}
+	switch {
+	case len(strs) >= 2:
+		w.Name = strings.TrimSpace(strs[1])
+	case len(strs) == 1:
+		header := strs[0]
+		if i := strings.Index(header, "}"); i >= 0 {
+			header = header[i+1:]
+		}
+		if fields := strings.Fields(header); len(fields) > 1 {
+			w.Name = strings.Join(fields[1:], " ")
+		} else {
+			w.Name = strings.TrimSpace(header)
+		}
+	}
+	// The levels of ROM areas are left on the line after the strings.
+	if b, err := rd.peek(); err == nil && b != '#' {
+		_, err = rd.restOfLine()
+		return err
+	}
+	return nil
+}
+
+// skipSection skips lines up to the next section, whose name is left to read.
+func skipSection(rd *reader) error {
+	for {
+		if _, err := rd.restOfLine(); err != nil {
+			return err
+		}
+		b, err := rd.peek()
+		if err != nil {
+			return err
+		}
+		if b != '#' {
+			continue
+		}
+		next, err := rd.r.Peek(2)
+		if err != nil {
+			return err
+		}
+		if next[1] == '$' || (next[1] >= 'A' && next[1] <= 'Z') {
+			return nil
+		}
+	}
+}
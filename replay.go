@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/droslean/thyranew/server"
+)
+
+// replay plays a journal, written by thyra serve -journal, back against a
+// simulated world started from the seed and the time it was written with,
+// to bring back a bug that is hard to come by the way it came. The ticks,
+// the commands and the players coming and going go into the world the way
+// they went, and the world events that come out are checked against those
+// of the journal.
+//
+// It returns 1 if the replay ended up somewhere else than the journal, or a
+// command panicked, and 2 if the journal did not read or the world did not
+// start.
+func replay(args []string) int {
+	fs := newFlags("replay", "journal")
+	static := staticFlag(fs)
+	until := fs.Int("until", 0, "Line of the journal to stop after, the end unless set")
+	verbose := fs.Bool("v", false, "Write the entries as they are played, and the events as they come")
+	fs.Parse(args)
+	quiet()
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return 2
+	}
+
+	f, err := os.Open(fs.Arg(0))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	entries, err := server.ReadJournal(f)
+	f.Close()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", fs.Arg(0), err)
+		return 2
+	}
+
+	// The harness reads the world where the server does.
+	os.Setenv("THYRA_STATIC", staticDir(*static))
+	h, err := server.NewReplayHarness(entries[0])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "The world did not start: %v\n", err)
+		return 2
+	}
+	defer h.Close()
+
+	// The events of the journal are matched in the order they were
+	// published, whichever entry they come after, since the tasks of the
+	// timers run between the entries.
+	expected := []int{}
+	for i, e := range entries {
+		if e.Kind == server.JournalEvent {
+			expected = append(expected, i)
+		}
+	}
+	played, matched := 0, 0
+	for i, e := range entries {
+		if *until > 0 && i+1 > *until {
+			break
+		}
+		if e.Kind == server.JournalEvent || e.Kind == server.JournalStart {
+			continue
+		}
+		if *verbose {
+			fmt.Printf("%d: %s\n", i+1, e)
+		}
+		events, err := h.Replay(e)
+		played++
+		for _, got := range events {
+			if *verbose {
+				fmt.Printf("\t%s %+v\n", got.Type, got)
+			}
+			if matched == len(expected) {
+				fmt.Fprintf(os.Stderr, "%s:%d: after %s, the replay published %s %+v, which the journal has not\n", fs.Arg(0), i+1, e, got.Type, got)
+				return 1
+			}
+			want := entries[expected[matched]]
+			if *want.Event != got {
+				fmt.Fprintf(os.Stderr, "%s:%d: after %s, the replay published %s %+v, where line %d has %s\n", fs.Arg(0), i+1, e, got.Type, got, expected[matched]+1, want)
+				return 1
+			}
+			matched++
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s:%d: %v\n", fs.Arg(0), i+1, err)
+			return 1
+		}
+	}
+	if *until == 0 && matched < len(expected) {
+		want := entries[expected[matched]]
+		fmt.Fprintf(os.Stderr, "%s:%d: the replay never published %s, nor the %d events after it\n", fs.Arg(0), expected[matched]+1, want, len(expected)-matched-1)
+		return 1
+	}
+	fmt.Printf("Played %d entries of the journal, with the %d events they had\n", played, matched)
+	return 0
+}
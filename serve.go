@@ -0,0 +1,152 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/droslean/thyranew/server"
+
+	log "gopkg.in/inconshreveable/log15.v2"
+)
+
+// world is another world served along with the first, as -world gives it:
+// name=dir, or name:port=dir for a world with a port of its own. Its static
+// directory holds its players and its database too.
+type world struct {
+	name string
+	port int
+	dir  string
+}
+
+// worldsFlag is the worlds of -world, which can be given many times.
+type worldsFlag []world
+
+func (f *worldsFlag) String() string {
+	specs := []string{}
+	for _, w := range *f {
+		specs = append(specs, w.String())
+	}
+	return strings.Join(specs, " ")
+}
+
+func (f *worldsFlag) Set(spec string) error {
+	eq := strings.Index(spec, "=")
+	if eq < 1 || eq == len(spec)-1 {
+		return fmt.Errorf("%q is not name=dir or name:port=dir", spec)
+	}
+	w := world{name: spec[:eq], dir: spec[eq+1:]}
+	if i := strings.Index(w.name, ":"); i >= 0 {
+		port, err := strconv.Atoi(w.name[i+1:])
+		if err != nil || port <= 0 {
+			return fmt.Errorf("%q has no valid port", spec)
+		}
+		w.name, w.port = w.name[:i], port
+	}
+	*f = append(*f, w)
+	return nil
+}
+
+func (w world) String() string {
+	if w.port != 0 {
+		return fmt.Sprintf("%s:%d=%s", w.name, w.port, w.dir)
+	}
+	return w.name + "=" + w.dir
+}
+
+// serve runs the server until it is interrupted. The flags given override
+// the settings of server.toml, and those left out keep them.
+func serve(args []string) int {
+	fs := newFlags("serve", "")
+	static := staticFlag(fs)
+	dbPath, dataDir := dataFlags(fs)
+	reset := fs.Bool("reset", false, "Forget the history, the news and the linked accounts of the players in the database")
+	port := fs.Int("port", 0, "Port to listen on incoming connections, 3030 unless server.toml says otherwise")
+	debug := fs.String("debug", "", "Address of the debug listener, with pprof, the stats and the admin API")
+	web := fs.String("web", "", "Address of the public web pages")
+	health := fs.String("health", "", "Address of the health probes, /healthz and /readyz")
+	review := fs.Bool("review", false, "Keep the edits of builders staged until an admin publishes them")
+	shards := fs.Int("shards", 0, "Goroutines the areas of the world are shared out between")
+	name := fs.String("name", "live", "Name of the world, when it hosts others")
+	journal := fs.String("journal", "", "File to write what goes into the world to, for thyra replay to play it back")
+	worlds := worldsFlag{}
+	for _, spec := range strings.Fields(os.Getenv("THYRA_WORLDS")) {
+		if err := worlds.Set(spec); err != nil {
+			return fail(fmt.Errorf("THYRA_WORLDS: %v", err))
+		}
+	}
+	fs.Var(&worlds, "world", "Another world to host, as name=dir, or name:port=dir for a port of its own, which players pick by logging in as name+world; THYRA_WORLDS gives them too, separated by spaces")
+	fs.Parse(args)
+
+	set := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	opts := server.Options{
+		Port:      *port,
+		StaticDir: *static,
+		DataDir:   *dataDir,
+		Journal:   *journal,
+		Config: func(c *server.Config) {
+			if set["debug"] {
+				c.Debug = *debug
+			}
+			if set["web"] {
+				c.Web = *web
+			}
+			if set["health"] {
+				c.Health = *health
+			}
+			if set["review"] {
+				c.Review = *review
+			}
+			if set["shards"] {
+				c.Shards = *shards
+			}
+		},
+	}
+
+	db, err := server.NewDatabase(*dbPath, *reset)
+	if err != nil {
+		log.Error(err.Error())
+		return 1
+	}
+	s, err := server.NewServerWith(db, opts)
+	if err != nil {
+		log.Error(err.Error())
+		return 1
+	}
+	if len(worlds) == 0 {
+		s.StartServer()
+		return 0
+	}
+
+	// The other worlds share the port of the first unless they have their
+	// own, and take no flags but -reset.
+	tenants := []server.Tenant{{Name: *name, Server: s}}
+	for _, w := range worlds {
+		port := w.port
+		if port == 0 {
+			port = s.Port()
+		}
+		db, err := server.NewDatabase(filepath.Join(w.dir, "thyra.db"), *reset)
+		if err != nil {
+			log.Error(fmt.Sprintf("World %s: %v", w.name, err))
+			return 1
+		}
+		other, err := server.NewServerWith(db, server.Options{Port: port, StaticDir: w.dir})
+		if err != nil {
+			log.Error(fmt.Sprintf("World %s: %v", w.name, err))
+			return 1
+		}
+		tenants = append(tenants, server.Tenant{Name: w.name, Server: other})
+	}
+	h, err := server.NewHost(tenants...)
+	if err != nil {
+		log.Error(err.Error())
+		return 1
+	}
+	h.Start()
+	return 0
+}
@@ -0,0 +1,170 @@
+package server
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/droslean/thyranew/area"
+	"github.com/gothyra/toml"
+
+	log "gopkg.in/inconshreveable/log15.v2"
+)
+
+const (
+	// achievementSweepTicks is every how many ticks the online players are
+	// checked for achievements they earned.
+	achievementSweepTicks = 10
+	// maxKeywords is how many keywords players can be called by.
+	maxKeywords = 5
+)
+
+// loadAchievements loads the achievements from the static directory into
+// memory.
+func (s *Server) loadAchievements() error {
+	path := s.staticDir + "/achievements.toml"
+	fileContent, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		log.Info(fmt.Sprintf("%s could not be loaded: %v", path, err))
+		return err
+	}
+
+	achievements := struct {
+		Achievements []area.Achievement `toml:"achievements"`
+	}{}
+	if _, err := toml.Decode(string(fileContent), &achievements); err != nil {
+		log.Info(fmt.Sprintf("%s could not be unmarshaled: %v", path, err))
+		return err
+	}
+
+	for _, a := range achievements.Achievements {
+		log.Info(fmt.Sprintf("Loaded achievement %q", a.Name))
+	}
+	s.Achievements = achievements.Achievements
+	return nil
+}
+
+// meetsAchievement reports whether the player meets every requirement of the
+// achievement.
+func (s *Server) meetsAchievement(p *area.Player, a area.Achievement) bool {
+	if p.Level < a.Level {
+		return false
+	}
+	if a.Quest != "" && p.Quests[a.Quest] != "done" {
+		return false
+	}
+	if a.Faction != "" && !s.meetsStanding(p, a.Faction, a.Standing) {
+		return false
+	}
+	return true
+}
+
+// hasAchievement reports whether the player earned the achievement.
+func hasAchievement(p *area.Player, name string) bool {
+	for _, earned := range p.Achievements {
+		if earned == name {
+			return true
+		}
+	}
+	return false
+}
+
+// checkAchievements gives the online players the achievements they earned
+// since the last check, and tells them about the titles that come with them.
+// Achievements are kept once earned, even if the player falls short of them
+// later.
+func (s *Server) checkAchievements(roomsMap map[string]map[string][][]area.Cube) {
+	if s.ticks%achievementSweepTicks != 0 {
+		return
+	}
+	for _, c := range s.OnlineClients() {
+		p := c.Player
+		earned := []area.Achievement{}
+		for _, a := range s.Achievements {
+			if !hasAchievement(p, a.Name) && s.meetsAchievement(p, a) {
+				p.Achievements = append(p.Achievements, a.Name)
+				earned = append(earned, a)
+			}
+		}
+		if len(earned) == 0 {
+			continue
+		}
+		if err := s.savePlayer(p); err != nil {
+			log.Error(fmt.Sprintf("Cannot save the achievements of %q: %v", p.Nickname, err))
+		}
+		for _, a := range earned {
+			s.notify(roomsMap, p.Nickname, "Achievements", fmt.Sprintf("You earned %s, and may wear the title %q", a.Name, a.Title))
+			s.publish(WorldEvent{Type: EventAchievement, Player: p.Nickname, Area: p.Area, Room: p.Room, Detail: a.Name})
+		}
+	}
+}
+
+// achievements lists the achievements the player earned, and those still
+// ahead of them.
+// Usage: achievements
+func (s *Server) achievements(p *area.Player) string {
+	if len(s.Achievements) == 0 {
+		return "There are no achievements to earn\n"
+	}
+	earned, ahead := []string{}, []string{}
+	for _, a := range s.Achievements {
+		if hasAchievement(p, a.Name) {
+			earned = append(earned, fmt.Sprintf("%s (%s)", a.Name, a.Title))
+		} else {
+			ahead = append(ahead, a.Name)
+		}
+	}
+	if len(earned) == 0 {
+		earned = []string{"none yet"}
+	}
+	if len(ahead) == 0 {
+		ahead = []string{"none"}
+	}
+	return fmt.Sprintf("Earned: %s\nAhead: %s\n", strings.Join(earned, ", "), strings.Join(ahead, ", "))
+}
+
+// title shows or picks the title the player wears, among those of the
+// achievements they earned.
+// Usage: title [title|none]
+func (s *Server) title(p *area.Player, args []string) string {
+	titles := []string{}
+	for _, a := range s.Achievements {
+		if hasAchievement(p, a.Name) {
+			titles = append(titles, a.Title)
+		}
+	}
+	if len(args) == 0 {
+		current := "no title"
+		if p.Title != "" {
+			current = "the title " + p.Title
+		}
+		if len(titles) == 0 {
+			return fmt.Sprintf("You wear %s, and have earned none yet\n", current)
+		}
+		return fmt.Sprintf("You wear %s. Titles: %s\n", current, strings.Join(titles, ", "))
+	}
+	name := strings.Join(args, " ")
+	if strings.EqualFold(name, "none") {
+		p.Title = ""
+		return s.savePreferences(p, "You wear no title\n")
+	}
+	for _, t := range titles {
+		if strings.EqualFold(t, name) {
+			p.Title = t
+			return s.savePreferences(p, fmt.Sprintf("You are %s\n", displayName(p)))
+		}
+	}
+	return fmt.Sprintf("You have not earned the title %s\n", name)
+}
+
+// displayName returns the name of the player with the title they wear.
+func displayName(p *area.Player) string {
+	if p.Title == "" {
+		return p.Nickname
+	}
+	return p.Nickname + " " + p.Title
+}
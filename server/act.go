@@ -0,0 +1,204 @@
+package server
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+
+	"github.com/droslean/thyranew/area"
+)
+
+// Actor is someone a message talks about, a player or an NPC.
+type Actor struct {
+	Name   string
+	Nick   string // Nickname of the player, empty for NPCs
+	Gender string // male or female, they for anything else
+}
+
+func playerActor(p *area.Player) *Actor {
+	return &Actor{Name: p.Nickname, Nick: p.Nickname, Gender: p.Gender}
+}
+
+func npcActor(npc *area.NPC) *Actor {
+	return &Actor{Name: npc.Name, Gender: npc.Gender}
+}
+
+// Forms of a pronoun.
+const (
+	subject = iota
+	object
+	possessive
+	reflexive
+)
+
+var pronouns = map[string][4]string{
+	"male":   {"he", "him", "his", "himself"},
+	"female": {"she", "her", "her", "herself"},
+	"":       {"they", "them", "their", "themselves"},
+}
+
+var secondPerson = [4]string{"you", "you", "your", "yourself"}
+
+// pronoun returns the pronoun of the actor, in the given form, as the viewer
+// reads it.
+func (a *Actor) pronoun(form int, viewer string) string {
+	if a.is(viewer) {
+		return secondPerson[form]
+	}
+	forms, ok := pronouns[a.Gender]
+	if !ok {
+		forms = pronouns[""]
+	}
+	return forms[form]
+}
+
+// is reports whether the actor is the given player.
+func (a *Actor) is(nick string) bool {
+	return a != nil && a.Nick != "" && a.Nick == nick
+}
+
+// render writes the template the way the viewer reads it. In the template
+//
+//	$n, $N    are the names of the actor and the target, "you" to themselves
+//	$e, $E    are the subject pronouns, he, she, they or you
+//	$m, $M    are the object pronouns, him, her, them or you
+//	$s, $S    are the possessive pronouns, his, her, their or your
+//	$p, $P    are the possessive names, Bob's or your
+//	{a|b}     picks a for the actor, b for everyone else: "$n {hit|hits} $N"
+//	$$        is a dollar sign
+//
+// A target that is the actor is spoken of as himself, herself, themselves or
+// yourself. Each line starts with a capital.
+func render(template string, actor, target *Actor, viewer string) string {
+	var b strings.Builder
+	runes := []rune(template)
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		switch {
+		case r == '$' && i+1 < len(runes):
+			i++
+			b.WriteString(token(runes[i], actor, target, viewer))
+		case r == '{':
+			end := i + 1
+			for end < len(runes) && runes[end] != '}' {
+				end++
+			}
+			forms := strings.SplitN(string(runes[i+1:end]), "|", 2)
+			if len(forms) == 2 && !actor.is(viewer) {
+				b.WriteString(forms[1])
+			} else {
+				b.WriteString(forms[0])
+			}
+			i = end
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return capitalize(b.String())
+}
+
+// token returns what the $ token stands for.
+func token(r rune, actor, target *Actor, viewer string) string {
+	if r == '$' {
+		return "$"
+	}
+	a := actor
+	if unicode.IsUpper(r) {
+		a = target
+	}
+	if a == nil {
+		return "someone"
+	}
+	// Targets who are the actor are spoken of reflexively.
+	self := unicode.IsUpper(r) && (target == actor || actor.is(target.Nick))
+	switch unicode.ToLower(r) {
+	case 'n':
+		if self {
+			return a.pronoun(reflexive, viewer)
+		}
+		if a.is(viewer) {
+			return "you"
+		}
+		return a.Name
+	case 'e':
+		return a.pronoun(subject, viewer)
+	case 'm':
+		if self {
+			return a.pronoun(reflexive, viewer)
+		}
+		return a.pronoun(object, viewer)
+	case 's':
+		return a.pronoun(possessive, viewer)
+	case 'p':
+		if a.is(viewer) {
+			return "your"
+		}
+		return a.Name + "'s"
+	}
+	return "$" + string(r)
+}
+
+// capitalize starts each line of the message with a capital.
+func capitalize(msg string) string {
+	lines := strings.SplitAfter(msg, "\n")
+	for i, line := range lines {
+		if line == "" {
+			continue
+		}
+		runes := []rune(line)
+		runes[0] = unicode.ToUpper(runes[0])
+		lines[i] = string(runes)
+	}
+	return strings.Join(lines, "")
+}
+
+// act prints the template to the recipients of the broadcast, each reading it
+// from where they stand. The actor gets it too when they aren't among them,
+// and so does a target who is a player. It returns the message as the actor
+// reads it.
+func (s *Server) act(roomsMap map[string]map[string][][]area.Cube, b Broadcast, actor, target *Actor) string {
+	clients := s.recipients(b)
+	seen := map[string]bool{}
+	for _, c := range clients {
+		seen[c.Player.Nickname] = true
+	}
+	for _, a := range []*Actor{actor, target} {
+		if a == nil || a.Nick == "" || seen[a.Nick] || a.Nick == b.From {
+			continue
+		}
+		if cl, ok := s.clientByNick(a.Nick); ok {
+			seen[a.Nick] = true
+			clients = append(clients, *cl)
+		}
+	}
+
+	for _, c := range clients {
+		msg := render(b.Text, actor, target, c.Player.Nickname)
+		if b.Kind != "" {
+			msg = tagged(b.Kind, msg)
+		}
+		s.godPrintRoom([]Client{c}, roomsMap, msg, "")
+	}
+	viewer := ""
+	if actor != nil {
+		viewer = actor.Nick
+	}
+	return render(b.Text, actor, target, viewer)
+}
+
+// gender shows or sets the pronouns others use for the player.
+// Usage: gender [male|female|none]
+func (s *Server) gender(p *area.Player, args []string) string {
+	if len(args) == 0 {
+		return fmt.Sprintf("Others speak of you as %s\n", playerActor(p).pronoun(subject, ""))
+	}
+	switch g := strings.ToLower(args[0]); g {
+	case "male", "female":
+		p.Gender = g
+	case "none":
+		p.Gender = ""
+	default:
+		return "Usage: gender [male|female|none]\n"
+	}
+	return s.savePreferences(p, fmt.Sprintf("Others speak of you as %s\n", playerActor(p).pronoun(subject, "")))
+}
@@ -0,0 +1,139 @@
+package server
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/droslean/thyranew/area"
+
+	log "gopkg.in/inconshreveable/log15.v2"
+)
+
+// checkAlts turns the player away when too many characters already play with
+// the same key, or from the same address. A character coming back to its
+// lost link doesn't count against itself.
+func (s *Server) checkAlts(name, key, ip string, exists bool) error {
+	if exists {
+		if p, ok := s.GetPlayerByNick(name); ok && isAdmin(&p) {
+			return nil
+		}
+	}
+	shared := map[string]bool{}
+	for _, v := range s.config.SharedAlts {
+		shared[v] = true
+	}
+	keys, ips := 0, 0
+	for _, c := range s.OnlineClients() {
+		if c.Name == name {
+			continue
+		}
+		if key != "" && c.hash == key {
+			keys++
+		}
+		if ip != "" && c.ip == ip {
+			ips++
+		}
+	}
+	switch {
+	case s.config.MaxPerKey > 0 && key != "" && !shared[key] && keys >= s.config.MaxPerKey:
+		log.Warn(fmt.Sprintf("%q turned away, %d other characters play with key %s", name, keys, key))
+		return fmt.Errorf("Too many characters play with your key already, at most %d may at once.", s.config.MaxPerKey)
+	case s.config.MaxPerIP > 0 && ip != "" && !shared[ip] && ips >= s.config.MaxPerIP:
+		log.Warn(fmt.Sprintf("%q turned away, %d other characters play from %s", name, ips, ip))
+		return fmt.Errorf("Too many characters play from your address already, at most %d may at once.", s.config.MaxPerIP)
+	}
+	return nil
+}
+
+// alt is another character that shares a key or an address with a player.
+type alt struct {
+	via  []string
+	last time.Time
+}
+
+// alts lists the characters that logged in with the same keys, or from the
+// same addresses, as the player.
+// Usage: alts <player>
+func (s *Server) alts(admin *area.Player, args []string) string {
+	if len(args) != 1 {
+		return "Usage: alts <player>\n"
+	}
+	nick := args[0]
+	ctx := s.ctxOf(admin.Nickname)
+	id, err := s.db.GetIdentity(ctx, nick)
+	if err != nil {
+		log.Error(fmt.Sprintf("Cannot load where %q logged in from: %v", nick, err))
+		return "The alts cannot be listed right now\n"
+	}
+	if len(id.Fingerprints)+len(id.IPs) == 0 {
+		return fmt.Sprintf("%s never logged in\n", nick)
+	}
+
+	found := map[string]*alt{}
+	gather := func(kind, label string, values map[string]time.Time) error {
+		for value := range values {
+			seen, err := s.db.SeenWith(ctx, kind, value)
+			if err != nil {
+				return err
+			}
+			for other, at := range seen {
+				if other == nick {
+					continue
+				}
+				a, ok := found[other]
+				if !ok {
+					a = &alt{}
+					found[other] = a
+				}
+				a.via = append(a.via, label+" "+value)
+				if at.After(a.last) {
+					a.last = at
+				}
+			}
+		}
+		return nil
+	}
+	err = gather("key", "key", id.Fingerprints)
+	if err == nil {
+		err = gather("ip", "address", id.IPs)
+	}
+	if err != nil {
+		log.Error(fmt.Sprintf("Cannot look up the alts of %q: %v", nick, err))
+		return "The alts cannot be listed right now\n"
+	}
+
+	lines := []string{fmt.Sprintf("%s logged in with keys: %s; from addresses: %s", nick, sortedKeys(id.Fingerprints), sortedKeys(id.IPs))}
+	if len(found) == 0 {
+		return lines[0] + ", and shares them with no one\n"
+	}
+	names := make([]string, 0, len(found))
+	for other := range found {
+		names = append(names, other)
+	}
+	sort.Strings(names)
+	for _, other := range names {
+		a := found[other]
+		sort.Strings(a.via)
+		seen := "last seen " + a.last.Format("2006-01-02 15:04")
+		if _, ok := s.clientByNick(other); ok {
+			seen = "online"
+		}
+		lines = append(lines, fmt.Sprintf("%s: %s, %s", other, strings.Join(a.via, ", "), seen))
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// sortedKeys lists the keys of the map in order, or "none".
+func sortedKeys(m map[string]time.Time) string {
+	if len(m) == 0 {
+		return "none"
+	}
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return strings.Join(keys, ", ")
+}
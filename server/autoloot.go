@@ -0,0 +1,316 @@
+package server
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/droslean/thyranew/area"
+	"github.com/droslean/thyranew/game"
+)
+
+// remainsDecay is how long the corpse of an NPC lasts with the loot nobody
+// took.
+const remainsDecay = 5 * time.Minute
+
+// otherKind is the kind loot filters call items of no kind by.
+const otherKind = "other"
+
+// Remains are the corpse of an NPC, with the loot still on it. Its owners
+// are who may loot it: the killer and their group.
+type Remains struct {
+	area.Location
+	area.Ownership
+	Name  string // The name of the NPC
+	Items []lootItem
+	Gold  int
+}
+
+// lootItem is an item on remains. Its owners are who alone may take it, any
+// of the owners of the remains when there are none.
+type lootItem struct {
+	area.GroundItem
+	Rarity string
+	Roll   int // The ID of the roll the group makes for the item, nobody may take it until then
+}
+
+// drop returns the item as it was rolled.
+func (l lootItem) drop() game.Drop {
+	return game.Drop{Item: l.Item, Rarity: l.Rarity}
+}
+
+// takable reports whether the player may take the item.
+func (l lootItem) takable(p *area.Player) bool {
+	return l.Roll == 0 && l.OwnedBy(p.Nickname)
+}
+
+// owns reports whether the player may loot the remains.
+func (r Remains) owns(p *area.Player) bool {
+	return r.OwnedBy(p.Nickname)
+}
+
+// lootKind returns the kind of the item, as loot filters call it.
+func (s *Server) lootKind(name string) string {
+	if kind := s.itemDef(name).Kind; kind != "" {
+		return kind
+	}
+	return otherKind
+}
+
+// passesFilter reports whether the loot filter of the player lets them take the
+// drop at the kill.
+func (s *Server) passesFilter(p *area.Player, d game.Drop) bool {
+	if p.Loot.Rarity != "" && !game.AsRare(d.Rarity, p.Loot.Rarity) {
+		return false
+	}
+	kind := s.lootKind(d.Item)
+	for _, skip := range p.Loot.Skip {
+		if skip == kind {
+			return false
+		}
+	}
+	return true
+}
+
+// leaveRemains leaves the corpse of the NPC the player killed with what was
+// rolled for its loot, shared out by the loot rule of their group. Those
+// who loot automatically take what their filter lets them of their share
+// right away, and the rest stays for the loot command until it rots.
+func (s *Server) leaveRemains(roomsMap map[string]map[string][][]area.Cube, p *area.Player, r Remains, drops []game.Drop) string {
+	group := s.group(p)
+	for _, member := range group {
+		r.Owners = append(r.Owners, member.Nickname)
+	}
+	rule := lootRule(group)
+	for _, d := range drops {
+		item := lootItem{GroundItem: area.GroundItem{ItemInstance: area.ItemInstance{Item: d.Item}, Location: r.Location}, Rarity: d.Rarity}
+		if rule == lootRoundRobin {
+			item.Owners = []string{s.nextTurn(group)}
+		}
+		r.Items = append(r.Items, item)
+	}
+
+	// Only the killer takes the gold, and under free loot the items too.
+	// Items the group rolls for wait for the roll.
+	looters := []*area.Player{p}
+	if rule == lootRoundRobin {
+		looters = group
+	}
+	msg := ""
+	for _, looter := range looters {
+		if looter.Loot.Manual {
+			continue
+		}
+		looter := looter
+		take := s.takeLoot(roomsMap, looter, &r, func(l lootItem) bool {
+			return rule != lootNeedGreed && s.passesFilter(looter, l.drop())
+		}, looter == p)
+		if looter == p {
+			msg += take
+		} else if take != "" {
+			s.tellPlayer(roomsMap, looter.Nickname, take)
+		}
+	}
+	if len(r.Items) == 0 && r.Gold == 0 {
+		return msg
+	}
+	left := []string{}
+	for _, l := range r.Items {
+		left = append(left, l.Item)
+	}
+	if r.Gold > 0 {
+		left = append(left, fmt.Sprintf("%d gold", r.Gold))
+	}
+	msg += fmt.Sprintf("Left on the corpse: %s\n", strings.Join(left, ", "))
+
+	s.lastRemains++
+	id := s.lastRemains
+	if rule == lootNeedGreed {
+		for i := range r.Items {
+			r.Items[i].Roll = s.startRoll(roomsMap, id, r.Owners, r.Items[i].Item)
+		}
+	}
+	s.remains[id] = r
+	s.after(remainsDecay, func() { delete(s.remains, id) })
+	return msg
+}
+
+// keepRemains keeps what is left of the remains, or lets them go once
+// nothing is.
+func (s *Server) keepRemains(id int, r Remains) {
+	if len(r.Items) == 0 && r.Gold == 0 {
+		delete(s.remains, id)
+		return
+	}
+	s.remains[id] = r
+}
+
+// takeLoot takes the items of the remains that the player may take, picks
+// and fit in their pack, and the gold when asked to, shared with their group
+// when they split it.
+func (s *Server) takeLoot(roomsMap map[string]map[string][][]area.Cube, p *area.Player, r *Remains, pick func(lootItem) bool, gold bool) string {
+	taken, heavy, kept := []string{}, []string{}, []lootItem{}
+	for _, l := range r.Items {
+		if !l.takable(p) || !pick(l) {
+			kept = append(kept, l)
+			continue
+		}
+		if !s.receive(p, l.Item) {
+			heavy = append(heavy, l.Item)
+			kept = append(kept, l)
+			continue
+		}
+		taken = append(taken, l.Item)
+		if game.AsRare(l.Rarity, rareLoot) {
+			s.publish(WorldEvent{Type: EventRareLoot, Player: p.Nickname, Area: r.Area, Room: r.Room, By: r.Name, Detail: l.Item})
+		}
+	}
+	r.Items = kept
+	if gold && r.Gold > 0 {
+		taken = append(taken, fmt.Sprintf("%d gold", s.lootGold(roomsMap, p, r.Gold)))
+		r.Gold = 0
+	}
+	msg := ""
+	if len(taken) > 0 {
+		msg += fmt.Sprintf("You loot %s\n", strings.Join(taken, ", "))
+	}
+	if len(heavy) > 0 {
+		msg += fmt.Sprintf("You leave %s behind, too heavy to carry\n", strings.Join(heavy, ", "))
+	}
+	return msg
+}
+
+// lootGold gives the player the gold, or their share of it when they split
+// gold with the group around them or its loot rule shares out the items, and
+// returns what they kept. What doesn't split evenly goes to the player.
+func (s *Server) lootGold(roomsMap map[string]map[string][][]area.Cube, p *area.Player, gold int) int {
+	s.goldFlow(flowLoot, gold)
+	group := s.group(p)
+	if !p.Loot.Split && lootRule(group) == lootFree {
+		group = group[:1]
+	}
+	share := gold / len(group)
+	for _, member := range group[1:] {
+		member.Gold += share
+		s.tellPlayer(roomsMap, member.Nickname, fmt.Sprintf("%s shares %d gold with you\n", p.Nickname, share))
+	}
+	kept := gold - share*(len(group)-1)
+	p.Gold += kept
+	return kept
+}
+
+// remainsHere returns the IDs of the remains the player may loot in their
+// room, the oldest first.
+func (s *Server) remainsHere(p *area.Player) []int {
+	ids := []int{}
+	for id, r := range s.remains {
+		if r.Area == p.Area && r.Room == p.Room && r.owns(p) {
+			ids = append(ids, id)
+		}
+	}
+	sort.Ints(ids)
+	return ids
+}
+
+// loot takes what is left on the corpses the player may loot in the room,
+// everything, the gold or the item named, whatever their loot filter says.
+// The player also sets how they loot with it.
+// Usage: loot [<item>|gold|auto on|off|split on|off|rarity <tier>|skip <kind>|keep <kind>|rule <rule>|filter]
+func (s *Server) loot(roomsMap map[string]map[string][][]area.Cube, p *area.Player, args []string) string {
+	if len(args) > 0 {
+		switch strings.ToLower(args[0]) {
+		case "auto", "split", "rarity", "skip", "keep", "filter", "rule":
+			return s.lootSettings(p, args)
+		}
+	}
+	ids := s.remainsHere(p)
+	if len(ids) == 0 {
+		return "There is nothing here for you to loot\n"
+	}
+	name := strings.Join(args, " ")
+	msg := ""
+	for _, id := range ids {
+		r := s.remains[id]
+		msg += s.takeLoot(roomsMap, p, &r, func(l lootItem) bool {
+			return name == "" || strings.EqualFold(l.Item, name)
+		}, name == "" || strings.EqualFold(name, "gold"))
+		s.keepRemains(id, r)
+	}
+	if msg == "" {
+		return fmt.Sprintf("There is no %s here to loot\n", name)
+	}
+	return msg
+}
+
+// lootSettings sets how the player loots, or shows it.
+func (s *Server) lootSettings(p *area.Player, args []string) string {
+	usage := "Usage: loot auto|split on|off, loot rarity <tier>, loot skip|keep <kind>, loot rule <rule>\n"
+	if len(args) < 2 {
+		if strings.ToLower(args[0]) == "filter" {
+			return lootFilterOf(p)
+		}
+		return usage
+	}
+	value := strings.ToLower(args[1])
+	switch strings.ToLower(args[0]) {
+	case "auto", "split":
+		if value != "on" && value != "off" {
+			return usage
+		}
+		if strings.ToLower(args[0]) == "auto" {
+			p.Loot.Manual = value == "off"
+			return fmt.Sprintf("Autoloot is %s\n", value)
+		}
+		p.Loot.Split = value == "on"
+		return fmt.Sprintf("Gold splitting is %s\n", value)
+	case "rarity":
+		for _, tier := range game.Rarities {
+			if tier == value {
+				p.Loot.Rarity = value
+				if value == game.Rarities[0] {
+					p.Loot.Rarity = ""
+				}
+				return fmt.Sprintf("You autoloot items %s or rarer\n", value)
+			}
+		}
+		return fmt.Sprintf("The rarities are %s\n", strings.Join(game.Rarities, ", "))
+	case "rule":
+		return setLootRule(p, value)
+	case "skip":
+		for _, kind := range p.Loot.Skip {
+			if kind == value {
+				return fmt.Sprintf("You skip %s already\n", value)
+			}
+		}
+		p.Loot.Skip = append(p.Loot.Skip, value)
+		return fmt.Sprintf("Autoloot leaves %s behind\n", value)
+	case "keep":
+		for i, kind := range p.Loot.Skip {
+			if kind == value {
+				p.Loot.Skip = append(p.Loot.Skip[:i], p.Loot.Skip[i+1:]...)
+				return fmt.Sprintf("Autoloot takes %s again\n", value)
+			}
+		}
+		return fmt.Sprintf("You don't skip %s\n", value)
+	}
+	return usage
+}
+
+// lootFilterOf describes how the player loots.
+func lootFilterOf(p *area.Player) string {
+	rarity := p.Loot.Rarity
+	if rarity == "" {
+		rarity = game.Rarities[0]
+	}
+	skip := "nothing"
+	if len(p.Loot.Skip) > 0 {
+		skip = strings.Join(p.Loot.Skip, ", ")
+	}
+	rule := p.Loot.Rule
+	if rule == "" {
+		rule = lootFree
+	}
+	return fmt.Sprintf("Autoloot: %s\nGold splitting: %s\nLeast rarity: %s\nSkipped: %s\nGroup rule: %s\n",
+		onOffOf(!p.Loot.Manual), onOffOf(p.Loot.Split), rarity, skip, rule)
+}
@@ -0,0 +1,138 @@
+package server
+
+import (
+	"strings"
+
+	"github.com/droslean/thyranew/area"
+	"github.com/droslean/thyranew/game"
+)
+
+// Scope is who a broadcast goes out to.
+type Scope int
+
+// Scopes of a broadcast.
+const (
+	ScopeGlobal  Scope = iota // Everyone online
+	ScopeArea                 // Everyone in Area
+	ScopeRoom                 // Everyone in Room of Area
+	ScopeGroup                // The players named in Nicks
+	ScopeChannel              // The players listening to Channel
+)
+
+// commonLanguage is the language everyone knows.
+const commonLanguage = "common"
+
+// Broadcast is a message for a number of players at once. Anything that talks
+// to more than the one player who gave the command goes through one, so the
+// rules on who gets what are kept here.
+type Broadcast struct {
+	Scope   Scope
+	Area    string
+	Room    string
+	Nicks   []string
+	Channel string
+
+	// Kind tags the text as one kind of output, unless it is tagged already.
+	Kind string
+	Text string
+	// From is the player the message comes from. They don't get it, and
+	// neither do those who ignore them.
+	From string
+	// Language is what the message is spoken in. Those who don't know it get
+	// Garbled instead, or nothing when there is none.
+	Language string
+	Garbled  string
+	// Filter drops the recipients it returns false for.
+	Filter func(*area.Player) bool
+}
+
+// recipients returns the online players the broadcast is for.
+func (s *Server) recipients(b Broadcast) []Client {
+	var candidates []Client
+	switch b.Scope {
+	case ScopeRoom:
+		candidates = s.OnlineClientsGetByRoom(b.Area, b.Room)
+	case ScopeGroup:
+		for _, nick := range b.Nicks {
+			if cl, ok := s.clientByNick(nick); ok {
+				candidates = append(candidates, *cl)
+			}
+		}
+	default:
+		candidates = s.OnlineClients()
+	}
+
+	clients := []Client{}
+	for _, c := range candidates {
+		p := c.Player
+		switch {
+		case b.Scope == ScopeArea && p.Area != b.Area:
+		case b.Scope == ScopeChannel && !hasName(p.Channels, b.Channel):
+		case b.From != "" && (p.Nickname == b.From || hasName(p.Ignores, b.From)):
+		// Sleeping players don't hear the chat.
+		case b.Kind == TagChat && p.Resting == game.Sleeping:
+		case b.Filter != nil && !b.Filter(p):
+		default:
+			clients = append(clients, c)
+		}
+	}
+	return clients
+}
+
+// broadcast prints the message to its recipients, and returns who they were.
+func (s *Server) broadcast(roomsMap map[string]map[string][][]area.Cube, b Broadcast) []Client {
+	clients := s.recipients(b)
+	if len(clients) == 0 {
+		return clients
+	}
+
+	text, garbled := b.Text, b.Garbled
+	if b.Kind != "" && !strings.Contains(text, tagMark) {
+		text, garbled = tagged(b.Kind, text), tagged(b.Kind, garbled)
+	}
+	if b.Language == "" || b.Language == commonLanguage {
+		s.printPieces(clients, roomsMap, around(text))
+		return clients
+	}
+
+	knows, others := []Client{}, []Client{}
+	for _, c := range clients {
+		if knowsLanguage(c.Player, b.Language) {
+			knows = append(knows, c)
+		} else {
+			others = append(others, c)
+		}
+	}
+	if len(knows) > 0 {
+		s.printPieces(knows, roomsMap, around(text))
+	}
+	if len(others) > 0 && garbled != "" {
+		s.printPieces(others, roomsMap, around(garbled))
+	}
+	return clients
+}
+
+// around returns the pieces of the broadcast message, as output that went to
+// everyone around.
+func around(msg string) []TaggedText {
+	pieces := untag(msg)
+	for i := range pieces {
+		pieces[i].Around = true
+	}
+	return pieces
+}
+
+// knowsLanguage reports whether the player understands the language.
+func knowsLanguage(p *area.Player, language string) bool {
+	return language == commonLanguage || hasName(p.Languages, language)
+}
+
+// hasName reports whether the name is on the list, whatever its case.
+func hasName(names []string, name string) bool {
+	for _, n := range names {
+		if strings.EqualFold(n, name) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,764 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/droslean/thyranew/area"
+	"github.com/gothyra/toml"
+
+	log "gopkg.in/inconshreveable/log15.v2"
+)
+
+// The admin API edits the areas and the items the way their files have
+// them, with the keys of the files in JSON, writes the files back and puts
+// what changed in the world at once, or stages it for an admin to publish
+// when the edits are reviewed. What the files had as comments is lost along
+// the way.
+//
+//	GET /admin/areas                                  the areas
+//	GET|PUT|DELETE /admin/areas/<area>                an area
+//	GET|PUT|DELETE /admin/areas/<area>/rooms/<room>   a room, with its cubes
+//	GET|PUT /admin/areas/<area>/rooms/<room>/exits/<cube>
+//	                                                  the exits of a cube, as {"exits": [...]}
+//	GET|POST /admin/areas/<area>/npcs                 the NPCs, or one more
+//	GET|PUT|DELETE /admin/areas/<area>/npcs/<n>       the nth NPC of the area, from 0
+//	GET /admin/items                                  the items
+//	GET|PUT|DELETE /admin/items/<item>                an item
+//	GET /admin/staging                                the changes waiting for an admin
+//	GET|POST|DELETE /admin/staging/<area>|items       what a change changes, to publish or discard it
+
+// apiError is an error of the admin API, with the status of the reply.
+type apiError struct {
+	status int
+	msg    string
+}
+
+func (e *apiError) Error() string {
+	return e.msg
+}
+
+func apiErrorf(status int, format string, args ...interface{}) error {
+	return &apiError{status: status, msg: fmt.Sprintf(format, args...)}
+}
+
+// replyError replies with the error, and the status it comes with.
+func replyError(w http.ResponseWriter, err error) {
+	if e, ok := err.(*apiError); ok {
+		http.Error(w, e.msg, e.status)
+		return
+	}
+	http.Error(w, err.Error(), http.StatusInternalServerError)
+}
+
+// reply replies to the request with the value, keyed the way its file has
+// it, or with the error. Changes that were staged are only accepted.
+func (s *Server) reply(w http.ResponseWriter, r *http.Request, v interface{}, err error) {
+	status := http.StatusOK
+	if r.Method != http.MethodGet && s.config.Review {
+		status = http.StatusAccepted
+	}
+	switch {
+	case err != nil:
+		replyError(w, err)
+	case v == nil && status == http.StatusOK:
+		w.WriteHeader(http.StatusNoContent)
+	case v == nil:
+		w.WriteHeader(status)
+	default:
+		replyFile(w, status, v)
+	}
+}
+
+// replyFile replies with the value, keyed the way its file has it.
+func replyFile(w http.ResponseWriter, status int, v interface{}) {
+	var buf bytes.Buffer
+	if err := toml.NewEncoder(&buf).Encode(v); err != nil {
+		replyError(w, err)
+		return
+	}
+	m := map[string]interface{}{}
+	if _, err := toml.Decode(buf.String(), &m); err != nil {
+		replyError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(m)
+}
+
+// readFile reads the JSON the caller sent into the value, the way its file
+// would be read. Keys the file wouldn't have are refused.
+func readFile(r io.Reader, v interface{}) error {
+	d := json.NewDecoder(io.LimitReader(r, 1<<20))
+	d.UseNumber()
+	m := map[string]interface{}{}
+	if err := d.Decode(&m); err != nil {
+		return apiErrorf(http.StatusBadRequest, "the body is not a JSON object: %v", err)
+	}
+	var buf bytes.Buffer
+	if err := toml.NewEncoder(&buf).Encode(tomlValue(m)); err != nil {
+		return apiErrorf(http.StatusBadRequest, "the body cannot be read: %v", err)
+	}
+	md, err := toml.Decode(buf.String(), v)
+	if err != nil {
+		return apiErrorf(http.StatusBadRequest, "the body cannot be read: %v", err)
+	}
+	if undecoded := md.Undecoded(); len(undecoded) > 0 {
+		keys := []string{}
+		for _, k := range undecoded {
+			keys = append(keys, k.String())
+		}
+		return apiErrorf(http.StatusBadRequest, "unknown keys: %s", strings.Join(keys, ", "))
+	}
+	return nil
+}
+
+// tomlValue turns the numbers of the JSON into those of TOML, integers
+// where they can be.
+func tomlValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return i
+		}
+		f, _ := v.Float64()
+		return f
+	case map[string]interface{}:
+		for k, e := range v {
+			v[k] = tomlValue(e)
+		}
+	case []interface{}:
+		for i, e := range v {
+			v[i] = tomlValue(e)
+		}
+	}
+	return v
+}
+
+// apiPath splits the path after the prefix into its parts.
+func apiPath(r *http.Request, prefix string) []string {
+	rest := strings.Trim(strings.TrimPrefix(r.URL.Path, prefix), "/")
+	if rest == "" {
+		return nil
+	}
+	return strings.Split(rest, "/")
+}
+
+// editable tells the callers that can't edit the world why, or lets them.
+func (s *Server) editable(w http.ResponseWriter, r *http.Request) bool {
+	if r.Method == http.MethodGet {
+		return true
+	}
+	if s.config.AdminToken == "" {
+		http.Error(w, "the world can only be edited with an admin token", http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// serveAreas serves the areas, their rooms, the exits of the rooms and their
+// NPCs.
+func (s *Server) serveAreas(w http.ResponseWriter, r *http.Request) {
+	if !s.editable(w, r) {
+		return
+	}
+	parts := apiPath(r, "/admin/areas")
+	if len(parts) == 0 {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		s.serveAreaList(w, r)
+		return
+	}
+
+	name := parts[0]
+	var reply interface{}
+	var err error
+	ran := s.inWorld(r.Context(), func() {
+		switch {
+		case len(parts) == 1:
+			reply, err = s.areaRequest(r, name)
+		case len(parts) == 3 && parts[1] == "rooms":
+			reply, err = s.roomRequest(r, name, parts[2])
+		case len(parts) == 5 && parts[1] == "rooms" && parts[3] == "exits":
+			reply, err = s.exitsRequest(r, name, parts[2], parts[4])
+		case len(parts) == 2 && parts[1] == "npcs", len(parts) == 3 && parts[1] == "npcs":
+			reply, err = s.npcRequest(r, name, parts[2:])
+		default:
+			err = apiErrorf(http.StatusNotFound, "not found")
+		}
+	})
+	if ran {
+		s.reply(w, r, reply, err)
+	}
+}
+
+// areaSummary is an area in the list of them.
+type areaSummary struct {
+	Name      string `json:"name"`
+	File      string `json:"file,omitempty"` // Empty for instances
+	Rooms     int    `json:"rooms"`
+	NPCs      int    `json:"npcs"`
+	Generated bool   `json:"generated"`
+	// Staged is set when a change to the area waits for an admin. Areas
+	// that are new only show once they are published.
+	Staged bool `json:"staged"`
+}
+
+func (s *Server) serveAreaList(w http.ResponseWriter, r *http.Request) {
+	list := []areaSummary{}
+	if !s.inWorld(r.Context(), func() {
+		for name, a := range s.Areas {
+			file := s.areaFiles[name]
+			if file == "" {
+				continue
+			}
+			_, staged := s.staged[file]
+			list = append(list, areaSummary{Name: name, File: file, Rooms: len(a.Rooms), NPCs: len(a.NPCs) + len(s.dormant[name]), Generated: a.Generator.Style != "", Staged: staged})
+		}
+	}) {
+		return
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list)
+}
+
+// areaRequest reads, replaces or deletes the area. It runs on the world.
+func (s *Server) areaRequest(r *http.Request, name string) (interface{}, error) {
+	switch r.Method {
+	case http.MethodGet:
+		return s.readAreaFile(name)
+	case http.MethodPut:
+		a := area.Area{}
+		if err := readFile(r.Body, &a); err != nil {
+			return nil, err
+		}
+		if a.Name != name {
+			return nil, apiErrorf(http.StatusBadRequest, "the area is named %q, not %q", a.Name, name)
+		}
+		return s.editArea(name, "admin API", true, func(old *area.Area) error {
+			*old = a
+			return nil
+		})
+	case http.MethodDelete:
+		return nil, s.deleteArea(name, "admin API")
+	}
+	return nil, apiErrorf(http.StatusMethodNotAllowed, "method not allowed")
+}
+
+// roomRequest reads, replaces or deletes a room of the area. It runs on the
+// world.
+func (s *Server) roomRequest(r *http.Request, areaName, roomName string) (interface{}, error) {
+	switch r.Method {
+	case http.MethodGet:
+		a, err := s.readAreaFile(areaName)
+		if err != nil {
+			return nil, err
+		}
+		room, ok := a.Rooms[roomName]
+		if !ok {
+			return nil, apiErrorf(http.StatusNotFound, "there is no room %q in %s", roomName, areaName)
+		}
+		return room, nil
+	case http.MethodPut:
+		room := area.Room{}
+		if err := readFile(r.Body, &room); err != nil {
+			return nil, err
+		}
+		if room.Name != roomName {
+			return nil, apiErrorf(http.StatusBadRequest, "the room is named %q, not %q", room.Name, roomName)
+		}
+		a, err := s.editArea(areaName, "admin API", false, func(a *area.Area) error {
+			if a.Rooms == nil {
+				a.Rooms = make(map[string]area.Room)
+			}
+			a.Rooms[roomName] = room
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		return a.Rooms[roomName], nil
+	case http.MethodDelete:
+		_, err := s.editArea(areaName, "admin API", false, func(a *area.Area) error {
+			if _, ok := a.Rooms[roomName]; !ok {
+				return apiErrorf(http.StatusNotFound, "there is no room %q in %s", roomName, areaName)
+			}
+			delete(a.Rooms, roomName)
+			return nil
+		})
+		return nil, err
+	}
+	return nil, apiErrorf(http.StatusMethodNotAllowed, "method not allowed")
+}
+
+// cubeExits are the exits of a cube, as the API has them.
+type cubeExits struct {
+	Exits []area.Exit `toml:"exits"`
+}
+
+// exitsRequest reads or replaces the exits of a cube. It runs on the world.
+func (s *Server) exitsRequest(r *http.Request, areaName, roomName, cubeID string) (interface{}, error) {
+	find := func(a *area.Area) (*area.Cube, error) {
+		room, ok := a.Rooms[roomName]
+		if !ok {
+			return nil, apiErrorf(http.StatusNotFound, "there is no room %q in %s", roomName, areaName)
+		}
+		for i := range room.Cubes {
+			if room.Cubes[i].ID == cubeID {
+				return &room.Cubes[i], nil
+			}
+		}
+		return nil, apiErrorf(http.StatusNotFound, "there is no cube %q in %s/%s", cubeID, areaName, roomName)
+	}
+	switch r.Method {
+	case http.MethodGet:
+		a, err := s.readAreaFile(areaName)
+		if err != nil {
+			return nil, err
+		}
+		cube, err := find(&a)
+		if err != nil {
+			return nil, err
+		}
+		exits := cubeExits{Exits: cube.Exits}
+		if exits.Exits == nil {
+			exits.Exits = []area.Exit{}
+		}
+		return exits, nil
+	case http.MethodPut:
+		exits := cubeExits{}
+		if err := readFile(r.Body, &exits); err != nil {
+			return nil, err
+		}
+		_, err := s.editArea(areaName, "admin API", false, func(a *area.Area) error {
+			cube, err := find(a)
+			if err != nil {
+				return err
+			}
+			cube.Exits = exits.Exits
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		return exits, nil
+	}
+	return nil, apiErrorf(http.StatusMethodNotAllowed, "method not allowed")
+}
+
+// npcRequest lists or adds to the NPCs of the area, or reads, replaces or
+// deletes one of them, by its place in the file. It runs on the world.
+func (s *Server) npcRequest(r *http.Request, areaName string, which []string) (interface{}, error) {
+	index := -1
+	if len(which) == 1 {
+		i, err := strconv.Atoi(which[0])
+		if err != nil || i < 0 {
+			return nil, apiErrorf(http.StatusNotFound, "NPCs go by their number, from 0")
+		}
+		index = i
+	}
+	find := func(a *area.Area) (*area.NPC, error) {
+		if index >= len(a.NPCs) {
+			return nil, apiErrorf(http.StatusNotFound, "%s has %d NPCs", areaName, len(a.NPCs))
+		}
+		return &a.NPCs[index], nil
+	}
+
+	switch {
+	case r.Method == http.MethodGet && index < 0:
+		a, err := s.readAreaFile(areaName)
+		if err != nil {
+			return nil, err
+		}
+		return struct {
+			NPCs []area.NPC `toml:"npcs"`
+		}{a.NPCs}, nil
+	case r.Method == http.MethodGet:
+		a, err := s.readAreaFile(areaName)
+		if err != nil {
+			return nil, err
+		}
+		return find(&a)
+	case r.Method == http.MethodPost && index < 0, r.Method == http.MethodPut && index >= 0:
+		npc := area.NPC{}
+		if err := readFile(r.Body, &npc); err != nil {
+			return nil, err
+		}
+		_, err := s.editArea(areaName, "admin API", false, func(a *area.Area) error {
+			if index < 0 {
+				a.NPCs = append(a.NPCs, npc)
+				return nil
+			}
+			old, err := find(a)
+			if err != nil {
+				return err
+			}
+			*old = npc
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		return npc, nil
+	case r.Method == http.MethodDelete && index >= 0:
+		_, err := s.editArea(areaName, "admin API", false, func(a *area.Area) error {
+			if _, err := find(a); err != nil {
+				return err
+			}
+			a.NPCs = append(a.NPCs[:index], a.NPCs[index+1:]...)
+			return nil
+		})
+		return nil, err
+	}
+	return nil, apiErrorf(http.StatusMethodNotAllowed, "method not allowed")
+}
+
+// areaFile returns the file of the area, from the static directory with
+// slashes, whether it is live or only staged.
+func (s *Server) areaFile(name string) (string, bool) {
+	for file, c := range s.staged {
+		if c.Area == name {
+			return file, true
+		}
+	}
+	file, ok := s.areaFiles[name]
+	return file, ok
+}
+
+// readAreaFile reads the area the way its file has it, with the change
+// waiting for an admin if there is one.
+func (s *Server) readAreaFile(name string) (area.Area, error) {
+	a := area.Area{}
+	file, ok := s.areaFile(name)
+	if !ok {
+		return a, apiErrorf(http.StatusNotFound, "there is no area %q", name)
+	}
+	data, err := s.worldData(file)
+	if os.IsNotExist(err) {
+		return a, apiErrorf(http.StatusNotFound, "there is no area %q", name)
+	}
+	if err != nil {
+		return a, err
+	}
+	if _, err := toml.Decode(string(data), &a); err != nil {
+		return a, fmt.Errorf("%s cannot be read: %v", file, err)
+	}
+	return a, nil
+}
+
+// areaFileName is what the file of a new area is called.
+var areaFileName = regexp.MustCompile(`[^a-z0-9]+`)
+
+// editArea changes the area the way its file has it, checks what it comes
+// to and writes it back, in the world or in the staging directory. New areas
+// are made when create is set. It runs on the world.
+func (s *Server) editArea(name, by string, create bool, edit func(a *area.Area) error) (area.Area, error) {
+	a, err := s.readAreaFile(name)
+	file, exists := s.areaFile(name)
+	if err != nil && (!create || !isNotFound(err)) {
+		return a, err
+	}
+	if !exists {
+		if strings.ContainsAny(name, "#/") {
+			return a, apiErrorf(http.StatusBadRequest, "area names have no # or /")
+		}
+		base := strings.Trim(areaFileName.ReplaceAllString(strings.ToLower(name), "-"), "-")
+		file = "areas/" + base + ".toml"
+		_, staged := s.staged[file]
+		if _, err := os.Stat(filepath.Join(s.staticDir, "areas", base+".toml")); base == "" || err == nil || staged {
+			return a, apiErrorf(http.StatusConflict, "there is a file for another area at %s", file)
+		}
+	}
+	if err := edit(&a); err != nil {
+		return a, err
+	}
+	if err := s.checkArea(a); err != nil {
+		return a, apiErrorf(http.StatusUnprocessableEntity, "%v", err)
+	}
+
+	var buf bytes.Buffer
+	if err := toml.NewEncoder(&buf).Encode(a); err != nil {
+		return a, err
+	}
+	if _, err := s.writeWorld(file, name, by, buf.Bytes()); err != nil {
+		return a, err
+	}
+	log.Info(fmt.Sprintf("Area %q was edited by %s", name, by))
+	return a, nil
+}
+
+// isNotFound reports whether the error of the admin API is that something is
+// not there.
+func isNotFound(err error) bool {
+	e, ok := err.(*apiError)
+	return ok && e.status == http.StatusNotFound
+}
+
+// deleteArea takes the area and its file away, unless other areas lead
+// there. Those inside are sent to their bind. It runs on the world.
+func (s *Server) deleteArea(name, by string) error {
+	file, ok := s.areaFile(name)
+	if _, err := s.worldData(file); !ok || os.IsNotExist(err) {
+		return apiErrorf(http.StatusNotFound, "there is no area %q", name)
+	}
+	if ways := s.waysInto(name, func(string, string) bool { return false }); len(ways) > 0 {
+		return apiErrorf(http.StatusConflict, "%s is still reached from %s", name, strings.Join(ways, ", "))
+	}
+	if _, err := s.writeWorld(file, name, by, nil); err != nil {
+		return err
+	}
+	log.Info(fmt.Sprintf("Area %q was taken away by %s", name, by))
+	return nil
+}
+
+// checkArea reports what is wrong with the area: rooms that don't go by
+// their names, cubes that can't be told apart, exits and NPCs that lead nowhere,
+// and exits of the other areas that would.
+func (s *Server) checkArea(a area.Area) error {
+	if a.Name == "" {
+		return fmt.Errorf("the area has no name")
+	}
+	cubeIn := func(areaName, roomName, id string) bool {
+		if roomName == area.WildernessRoom && areaName != a.Name {
+			_, ok := s.Wilderness[areaName]
+			return ok
+		}
+		if areaName == a.Name {
+			return hasCube(a.Rooms[roomName], id)
+		}
+		return hasCube(s.Areas[areaName].Rooms[roomName], id)
+	}
+	for key, room := range a.Rooms {
+		if room.Name != key {
+			return fmt.Errorf("room %q is named %q", key, room.Name)
+		}
+		ids := map[string]bool{}
+		for _, c := range room.Cubes {
+			x, errX := strconv.Atoi(c.POSX)
+			y, errY := strconv.Atoi(c.POSY)
+			switch {
+			case c.ID == "":
+				return fmt.Errorf("a cube of %s has no ID", key)
+			case ids[c.ID]:
+				return fmt.Errorf("%s has more than one cube %q", key, c.ID)
+			case errX != nil || errY != nil || x < 0 || y < 0:
+				return fmt.Errorf("cube %q of %s is not at a place in the room", c.ID, key)
+			}
+			ids[c.ID] = true
+		}
+	}
+	for key, room := range a.Rooms {
+		for _, c := range room.Cubes {
+			for _, e := range c.Exits {
+				if !cubeIn(e.ToArea, e.ToRoom, e.ToCubeID) {
+					return fmt.Errorf("cube %q of %s leads to %s/%s cube %q, which isn't there", c.ID, key, e.ToArea, e.ToRoom, e.ToCubeID)
+				}
+			}
+		}
+	}
+	// The rooms of generated areas, and where their NPCs go, are only known
+	// once they are built.
+	if a.Generator.Style != "" {
+		trial := area.Area{Name: a.Name, Generator: a.Generator}
+		if err := area.Generate(&trial, 1); err != nil {
+			return fmt.Errorf("the area can't be generated: %v", err)
+		}
+		return nil
+	}
+	for i, npc := range a.NPCs {
+		switch {
+		case npc.Name == "":
+			return fmt.Errorf("NPC %d has no name", i)
+		case npc.Position == "" && a.Rooms[npc.Room].Name == "":
+			return fmt.Errorf("%s is in %q, which isn't in %s", npc.Name, npc.Room, a.Name)
+		case npc.Position != "" && !cubeIn(a.Name, npc.Room, npc.Position):
+			return fmt.Errorf("%s is at %s cube %q, which isn't there", npc.Name, npc.Room, npc.Position)
+		}
+	}
+	if ways := s.waysInto(a.Name, func(room, id string) bool { return cubeIn(a.Name, room, id) }); len(ways) > 0 {
+		return fmt.Errorf("%s would lead nowhere", strings.Join(ways, ", "))
+	}
+	return nil
+}
+
+// waysInto returns the cubes of the other areas with exits into the area
+// that don't lead to a cube there.
+func (s *Server) waysInto(name string, there func(room, id string) bool) []string {
+	ways := []string{}
+	for other, a := range s.Areas {
+		if other == name || s.areaFiles[other] == "" {
+			continue
+		}
+		for _, room := range a.Rooms {
+			for _, c := range room.Cubes {
+				for _, e := range c.Exits {
+					if e.ToArea == name && !there(e.ToRoom, e.ToCubeID) {
+						ways = append(ways, fmt.Sprintf("%s/%s cube %q", other, room.Name, c.ID))
+					}
+				}
+			}
+		}
+	}
+	sort.Strings(ways)
+	return ways
+}
+
+// replaceArea puts the area in place of the one the world runs. Its NPCs
+// start over, and those in the rooms that are gone are sent to their bind.
+func (s *Server) replaceArea(roomsMap map[string]map[string][][]area.Cube, a area.Area) {
+	old := s.Areas[a.Name]
+	for _, npc := range append(old.NPCs, s.dormant[a.Name]...) {
+		delete(s.pursuits, npc.ID)
+		delete(s.threat, npc.ID)
+		delete(s.leashes, npc.ID)
+	}
+	npcs, dormant := []area.NPC{}, []area.NPC{}
+	for _, npc := range a.NPCs {
+		if s.contentOn(npc.Flag) {
+			npcs = append(npcs, npc)
+		} else {
+			dormant = append(dormant, npc)
+		}
+	}
+	a.NPCs = npcs
+	s.dormant[a.Name] = dormant
+	s.Areas[a.Name] = a
+	if err := s.loadRoomDescriptions(); err != nil {
+		log.Error(fmt.Sprintf("Cannot put the descriptions builders wrote back in %s: %v", a.Name, err))
+	}
+	s.buildAreaRooms(roomsMap, a.Name)
+	if a.Generator.Style != "" {
+		s.rekeyed(roomsMap, a.Name)
+	}
+
+	for _, c := range s.OnlineClients() {
+		p := c.Player
+		if p.Area != a.Name {
+			continue
+		}
+		if hasCube(a.Rooms[p.Room], p.Position) {
+			continue
+		}
+		to := p.Bind
+		if to.ToArea == "" || to.ToArea == a.Name && !hasCube(a.Rooms[to.ToRoom], to.ToCubeID) {
+			to = defaultBind
+		}
+		s.tellPlayer(roomsMap, p.Nickname, fmt.Sprintf("%s fades away, and you find yourself elsewhere\n", p.Room))
+		s.teleport(roomsMap, p, to, "rebuilt")
+	}
+}
+
+// hasCube reports whether the room has the cube.
+func hasCube(room area.Room, id string) bool {
+	for _, c := range room.Cubes {
+		if c.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
+// serveItems serves the items, the way the items file has them. Changes
+// are loaded at once.
+func (s *Server) serveItems(w http.ResponseWriter, r *http.Request) {
+	if !s.editable(w, r) {
+		return
+	}
+	parts := apiPath(r, "/admin/items")
+	if len(parts) > 1 {
+		http.NotFound(w, r)
+		return
+	}
+	var reply interface{}
+	var err error
+	if s.inWorld(r.Context(), func() { reply, err = s.itemRequest(r, parts) }) {
+		s.reply(w, r, reply, err)
+	}
+}
+
+// itemsFile is the items file.
+type itemsFile struct {
+	Items []area.Item `toml:"items"`
+}
+
+// itemRequest lists the items, or reads, replaces or deletes one of them.
+// It runs on the world.
+func (s *Server) itemRequest(r *http.Request, parts []string) (interface{}, error) {
+	file := itemsFile{}
+	data, err := s.worldData("items.toml")
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if _, err := toml.Decode(string(data), &file); err != nil {
+		return nil, fmt.Errorf("items.toml cannot be read: %v", err)
+	}
+	if len(parts) == 0 {
+		if r.Method != http.MethodGet {
+			return nil, apiErrorf(http.StatusMethodNotAllowed, "method not allowed")
+		}
+		return file, nil
+	}
+
+	name := parts[0]
+	at := -1
+	for i, it := range file.Items {
+		if strings.EqualFold(it.Name, name) {
+			at = i
+		}
+	}
+	switch r.Method {
+	case http.MethodGet:
+		if at < 0 {
+			return nil, apiErrorf(http.StatusNotFound, "there is no item %q", name)
+		}
+		return file.Items[at], nil
+	case http.MethodPut:
+		it := area.Item{}
+		if err := readFile(r.Body, &it); err != nil {
+			return nil, err
+		}
+		if it.Name != name {
+			return nil, apiErrorf(http.StatusBadRequest, "the item is named %q, not %q", it.Name, name)
+		}
+		if at < 0 {
+			file.Items = append(file.Items, it)
+		} else {
+			file.Items[at] = it
+		}
+		return it, s.writeItems(file)
+	case http.MethodDelete:
+		if at < 0 {
+			return nil, apiErrorf(http.StatusNotFound, "there is no item %q", name)
+		}
+		file.Items = append(file.Items[:at], file.Items[at+1:]...)
+		return nil, s.writeItems(file)
+	}
+	return nil, apiErrorf(http.StatusMethodNotAllowed, "method not allowed")
+}
+
+// writeItems writes the items file, and loads the items again unless the
+// change is staged.
+func (s *Server) writeItems(file itemsFile) error {
+	var buf bytes.Buffer
+	if err := toml.NewEncoder(&buf).Encode(file); err != nil {
+		return err
+	}
+	if _, err := s.writeWorld("items.toml", "", "admin API", buf.Bytes()); err != nil {
+		return err
+	}
+	log.Info("The items were edited through the admin API")
+	return nil
+}
@@ -0,0 +1,61 @@
+package server
+
+import (
+	"fmt"
+
+	log "gopkg.in/inconshreveable/log15.v2"
+)
+
+// Types of world events.
+const (
+	EventPlayerDied        = "player.died"
+	EventPlayerReleased    = "player.released"
+	EventPlayerResurrected = "player.resurrected"
+	EventNPCKilled         = "npc.killed"
+	EventPlayerDeparted    = "player.departed"
+	EventPlayerArrived     = "player.arrived"
+	EventPlayerState       = "player.state"
+	EventPlayerLeveled     = "player.leveled"
+	EventQuestDone         = "quest.done"
+	EventRareLoot          = "loot.rare"
+	EventAchievement       = "achievement.earned"
+	EventBossKilled        = "npc.boss.killed"
+	EventAnnouncement      = "server.announcement"
+)
+
+// WorldEvent is something that happened in the world, published on the event
+// bus for the systems that want to react to it.
+type WorldEvent struct {
+	Type   string
+	Player string // Nickname of the player involved, if any
+	NPC    int    // ID of the NPC involved, if any
+	Area   string
+	Room   string
+	// By is whoever caused the event, like the killer of a player.
+	By string
+	// From and To are the old and the new state, on changes of state.
+	From, To State
+	// Detail is what the event is about, like the quest done or the item
+	// looted.
+	Detail string
+}
+
+// subscribe registers the handler for the events of the given type.
+func (s *Server) subscribe(eventType string, handler func(WorldEvent)) {
+	s.handlers[eventType] = append(s.handlers[eventType], handler)
+}
+
+// publish hands the event to its handlers, in the order they subscribed. Like
+// everything that changes the world, it has to be called from the goroutine of
+// the world.
+func (s *Server) publish(e WorldEvent) {
+	log.Debug(fmt.Sprintf("World event %s: %+v", e.Type, e))
+	s.trail.add(e, s.now())
+	s.journal.write(JournalEntry{Kind: JournalEvent, Time: s.now(), Event: &e})
+	if s.replay != nil {
+		s.replay.events = append(s.replay.events, e)
+	}
+	for _, handler := range s.handlers[e.Type] {
+		handler(e)
+	}
+}
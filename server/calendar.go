@@ -0,0 +1,180 @@
+package server
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/droslean/thyranew/area"
+	"github.com/droslean/thyranew/game"
+	"github.com/gothyra/toml"
+
+	log "gopkg.in/inconshreveable/log15.v2"
+)
+
+// loadCalendar loads the world events from the static directory into memory.
+func (s *Server) loadCalendar() error {
+	path := s.staticDir + "/calendar.toml"
+	fileContent, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		log.Info(fmt.Sprintf("%s could not be loaded: %v", path, err))
+		return err
+	}
+
+	calendar := struct {
+		Events []area.CalendarEvent `toml:"events"`
+	}{}
+	if _, err := toml.Decode(string(fileContent), &calendar); err != nil {
+		log.Info(fmt.Sprintf("%s could not be unmarshaled: %v", path, err))
+		return err
+	}
+
+	for _, e := range calendar.Events {
+		log.Info(fmt.Sprintf("Loaded world event %q", e.Name))
+	}
+	s.calendar = calendar.Events
+	return nil
+}
+
+// checkCalendar starts the world events whose time has come and stops the
+// ones that are over. It runs on every tick.
+func (s *Server) checkCalendar(roomsMap map[string]map[string][][]area.Cube) {
+	now := s.localNow()
+	for _, e := range s.calendar {
+		active := e.ActiveAt(now) && s.featureOn(featureEvents) && s.featureOn(eventFeature+e.Name)
+		switch {
+		case active && !s.activeEvents[e.Name]:
+			s.startEvent(roomsMap, e)
+		case !active && s.activeEvents[e.Name]:
+			s.stopEvent(roomsMap, e)
+		}
+	}
+}
+
+func (s *Server) startEvent(roomsMap map[string]map[string][][]area.Cube, e area.CalendarEvent) {
+	log.Info(fmt.Sprintf("World event %q starts", e.Name))
+	s.activeEvents[e.Name] = true
+	s.decorate(e.Decorations, true)
+	if e.Announce != "" {
+		s.broadcast(roomsMap, Broadcast{Scope: ScopeGlobal, Kind: TagSystem, Text: e.Announce + "\n"})
+		s.publish(WorldEvent{Type: EventAnnouncement, By: e.Name, Detail: e.Announce})
+	}
+	if len(e.Invasion.Spawns) > 0 {
+		s.invade(roomsMap, e, 1)
+	}
+}
+
+func (s *Server) stopEvent(roomsMap map[string]map[string][][]area.Cube, e area.CalendarEvent) {
+	log.Info(fmt.Sprintf("World event %q ends", e.Name))
+	delete(s.activeEvents, e.Name)
+	s.decorate(e.Decorations, false)
+
+	// Invaders left behind go back where they came from.
+	for name, a := range s.Areas {
+		npcs := []area.NPC{}
+		for _, npc := range a.NPCs {
+			if npc.Event == e.Name {
+				delete(s.pursuits, npc.ID)
+				continue
+			}
+			npcs = append(npcs, npc)
+		}
+		a.NPCs = npcs
+		s.Areas[name] = a
+	}
+
+	if e.Farewell != "" {
+		s.broadcast(roomsMap, Broadcast{Scope: ScopeGlobal, Kind: TagSystem, Text: e.Farewell + "\n"})
+	}
+}
+
+// decorate adds the decorations of an event to their rooms, or takes them off.
+func (s *Server) decorate(decorations []area.Decoration, on bool) {
+	for _, d := range decorations {
+		room, ok := s.Areas[d.Area].Rooms[d.Room]
+		if !ok {
+			continue
+		}
+		room.Decoration = ""
+		if on {
+			room.Decoration = strings.TrimSpace(d.Text)
+		}
+		s.Areas[d.Area].Rooms[d.Room] = room
+	}
+}
+
+// invade sends a wave of the invasion of the event, and schedules the next
+// one as long as the event runs.
+func (s *Server) invade(roomsMap map[string]map[string][][]area.Cube, e area.CalendarEvent, wave int) {
+	inv := e.Invasion
+	if !s.activeEvents[e.Name] || wave > inv.Waves {
+		return
+	}
+	a, ok := s.Areas[inv.Area]
+	if !ok {
+		log.Error(fmt.Sprintf("World event %q invades unknown area %q", e.Name, inv.Area))
+		return
+	}
+
+	cubes := []string{}
+	for _, column := range roomsMap[inv.Area][inv.Room] {
+		for _, cube := range column {
+			if cube.ID != "" && cube.Type == "" {
+				cubes = append(cubes, cube.ID)
+			}
+		}
+	}
+	if len(cubes) == 0 {
+		return
+	}
+
+	for _, spawn := range inv.Spawns {
+		if spawn.Chance > 0 && s.rnd.Intn(100) >= spawn.Chance {
+			continue
+		}
+		a.NPCs = append(a.NPCs, area.NPC{
+			Name:     spawn.Name,
+			Location: area.Location{Area: inv.Area, Room: inv.Room, Position: cubes[s.rnd.Intn(len(cubes))]},
+			PC:       game.PC{Level: spawn.Level},
+			Loot:     spawn.Loot,
+			Event:    e.Name,
+		})
+	}
+	s.prepareNPCs(&a)
+	s.Areas[inv.Area] = a
+	s.printToRoom(roomsMap, inv.Area, inv.Room, fmt.Sprintf("%s: wave %d of %d arrives!\n", e.Name, wave, inv.Waves))
+
+	s.after(time.Duration(inv.Interval)*time.Minute, func() {
+		s.invade(roomsMap, e, wave+1)
+	})
+}
+
+// xpMultiplier returns the experience multiplier of the running events.
+func (s *Server) xpMultiplier() int {
+	multiplier := 1
+	for _, e := range s.calendar {
+		if s.activeEvents[e.Name] && e.XPMultiplier > multiplier {
+			multiplier = e.XPMultiplier
+		}
+	}
+	return multiplier
+}
+
+// listEvents tells which world events are running.
+func (s *Server) listEvents() string {
+	names := []string{}
+	for _, e := range s.calendar {
+		if s.activeEvents[e.Name] {
+			names = append(names, e.Name)
+		}
+	}
+	if len(names) == 0 {
+		return "No world events are running\n"
+	}
+	return fmt.Sprintf("Running: %s\n", strings.Join(names, ", "))
+}
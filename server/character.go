@@ -0,0 +1,319 @@
+package server
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/droslean/thyranew/area"
+	"github.com/gothyra/toml"
+
+	log "gopkg.in/inconshreveable/log15.v2"
+)
+
+const (
+	// characterFormat names the files characters are exported to, and
+	// characterVersion is the version of the format written. Files of
+	// newer versions are refused.
+	characterFormat  = "thyra-character"
+	characterVersion = 1
+	// maxExports is how many exports each character keeps, the newest.
+	maxExports = 10
+	// exportLife is how long players have to fetch their export from the
+	// web.
+	exportLife = time.Hour
+)
+
+// CharacterExport is a character as it is exported: to move it to another
+// server, to hand players what is kept of them, or to bring it back after it
+// was lost. The signature shows it was exported by a server with the key,
+// and that nothing was changed since.
+type CharacterExport struct {
+	Format    string          `json:"format"`
+	Version   int             `json:"version"`
+	Server    string          `json:"server"` // The server it was exported from
+	Exported  time.Time       `json:"exported"`
+	Character json.RawMessage `json:"character"` // The player, with their stats, inventory and achievements
+	Signature string          `json:"signature"` // HMAC-SHA256 of the rest, in hex
+}
+
+// exportCode is an export a player can fetch from the web, once, until it
+// expires.
+type exportCode struct {
+	Player  string
+	File    string
+	Expires time.Time
+}
+
+// ExportCharacter exports the player, signed with the key.
+func ExportCharacter(p area.Player, server string, key []byte, at time.Time) ([]byte, error) {
+	p, err := persistent(p)
+	if err != nil {
+		return nil, err
+	}
+	character, err := json.Marshal(p)
+	if err != nil {
+		return nil, err
+	}
+	e := CharacterExport{Format: characterFormat, Version: characterVersion, Server: server, Exported: at.UTC(), Character: character}
+	if e.Signature, err = e.sign(key); err != nil {
+		return nil, err
+	}
+	return json.MarshalIndent(e, "", "  ")
+}
+
+// ImportCharacter reads an exported character, if it is signed with the key.
+func ImportCharacter(data, key []byte) (area.Player, CharacterExport, error) {
+	e := CharacterExport{}
+	if err := json.Unmarshal(data, &e); err != nil {
+		return area.Player{}, e, fmt.Errorf("it is not an exported character: %v", err)
+	}
+	switch {
+	case e.Format != characterFormat:
+		return area.Player{}, e, fmt.Errorf("it is not an exported character")
+	case e.Version < 1 || e.Version > characterVersion:
+		return area.Player{}, e, fmt.Errorf("version %d of the format is not known here", e.Version)
+	}
+	want, err := e.sign(key)
+	if err != nil {
+		return area.Player{}, e, err
+	}
+	if !hmac.Equal([]byte(want), []byte(e.Signature)) {
+		return area.Player{}, e, fmt.Errorf("the signature does not match, it was changed or signed with another key")
+	}
+	p := area.Player{}
+	if err := json.Unmarshal(e.Character, &p); err != nil {
+		return area.Player{}, e, fmt.Errorf("the character cannot be read: %v", err)
+	}
+	if !IsValidUsername(p.Nickname) {
+		return area.Player{}, e, fmt.Errorf("%q is not a valid name", p.Nickname)
+	}
+	p, err = persistent(p)
+	return p, e, err
+}
+
+// sign returns the signature of the export. The character is signed
+// compacted, so exports indented again still import.
+func (e CharacterExport) sign(key []byte) (string, error) {
+	var character bytes.Buffer
+	if err := json.Compact(&character, e.Character); err != nil {
+		return "", fmt.Errorf("the character cannot be read: %v", err)
+	}
+	mac := hmac.New(sha256.New, key)
+	fmt.Fprintf(mac, "%s\n%d\n%s\n%s\n", e.Format, e.Version, e.Server, e.Exported.UTC().Format(time.RFC3339Nano))
+	mac.Write(character.Bytes())
+	return hex.EncodeToString(mac.Sum(nil)), nil
+}
+
+// persistent returns what is saved of the player, without what only lasts
+// while they play, like their fight or the shop they browse.
+func persistent(p area.Player) (area.Player, error) {
+	var buf bytes.Buffer
+	if err := toml.NewEncoder(&buf).Encode(p); err != nil {
+		return p, err
+	}
+	saved := area.Player{}
+	_, err := toml.Decode(buf.String(), &saved)
+	return saved, err
+}
+
+// characterKey returns the key characters are exported with: the one of
+// server.toml, the secret of the cluster, so its servers take each other's
+// characters, or else a key of the server's own.
+func (s *Server) characterKey() ([]byte, error) {
+	switch {
+	case s.config.CharacterKey != "":
+		return []byte(s.config.CharacterKey), nil
+	case s.config.Cluster.Secret != "":
+		return []byte(s.config.Cluster.Secret), nil
+	}
+	return s.db.CharacterKey()
+}
+
+// serverName returns the name the server goes by in the exports.
+func (s *Server) serverName() string {
+	switch {
+	case s.config.Cluster.Name != "":
+		return s.config.Cluster.Name
+	case s.name != "":
+		return s.name
+	}
+	return s.config.Host
+}
+
+// exportsDir returns where the exports of the player are kept.
+func (s *Server) exportsDir(nick string) string {
+	return filepath.Join(s.dataDir, "exports", nick)
+}
+
+// exports returns the exports kept of the player, the oldest first.
+func (s *Server) exports(nick string) ([]string, error) {
+	names, err := filepath.Glob(filepath.Join(s.exportsDir(nick), "*.json"))
+	sort.Strings(names)
+	return names, err
+}
+
+// keepExport exports the player to a file of its own, and forgets the
+// oldest exports past maxExports. It returns the file.
+func (s *Server) keepExport(p area.Player) (string, error) {
+	key, err := s.characterKey()
+	if err != nil {
+		return "", err
+	}
+	data, err := ExportCharacter(p, s.serverName(), key, s.now())
+	if err != nil {
+		return "", err
+	}
+	file := filepath.Join(s.exportsDir(p.Nickname), s.now().UTC().Format(versionStamp)+".json")
+	if err := writeAtomic(file, data); err != nil {
+		return "", err
+	}
+	exports, err := s.exports(p.Nickname)
+	if err != nil {
+		return "", err
+	}
+	for len(exports) > maxExports {
+		if err := os.Remove(exports[0]); err != nil {
+			return "", err
+		}
+		exports = exports[1:]
+	}
+	return file, nil
+}
+
+// exportCharacter exports the character of the player, or of another
+// player for admins. Players get a link to fetch it from the web, when
+// there are web pages.
+// Usage: export [player]
+func (s *Server) exportCharacter(p *area.Player, args []string) string {
+	target := *p
+	switch {
+	case len(args) == 1 && isAdmin(p):
+		other, ok := s.playerOf(args[0])
+		if !ok {
+			return fmt.Sprintf("There is no player %q\n", args[0])
+		}
+		target = other
+	case len(args) > 0:
+		return "Usage: export\n"
+	}
+	file, err := s.keepExport(target)
+	if err != nil {
+		log.Error(fmt.Sprintf("Cannot export %q: %v", target.Nickname, err))
+		return "Something went wrong, try again\n"
+	}
+	log.Info(fmt.Sprintf("%q exported %q to %s", p.Nickname, target.Nickname, file))
+	if target.Nickname != p.Nickname {
+		return fmt.Sprintf("Exported %s to %s\n", target.Nickname, file)
+	}
+
+	if s.config.Web == "" || s.config.OAuth.URL == "" {
+		return "Your character is exported, ask an admin for the file\n"
+	}
+	now := s.now()
+	for code, c := range s.exportCodes {
+		if now.After(c.Expires) {
+			delete(s.exportCodes, code)
+		}
+	}
+	code, err := randomCode("0123456789abcdef", 32)
+	if err != nil {
+		log.Error(fmt.Sprintf("Cannot make an export code: %v", err))
+		return "Your character is exported, ask an admin for the file\n"
+	}
+	s.exportCodes[code] = exportCode{Player: p.Nickname, File: file, Expires: now.Add(exportLife)}
+	return fmt.Sprintf("Within %d minutes, fetch your character from %s/export/%s\n",
+		int(exportLife.Minutes()), strings.TrimSuffix(s.config.OAuth.URL, "/"), code)
+}
+
+// serveExport hands players the export they were given the code of, once.
+func (s *Server) serveExport(w http.ResponseWriter, r *http.Request) {
+	code := strings.TrimPrefix(r.URL.Path, "/export/")
+	var c exportCode
+	found := false
+	if !s.inWorld(r.Context(), func() {
+		c, found = s.exportCodes[code]
+		delete(s.exportCodes, code)
+		found = found && !s.now().After(c.Expires)
+	}) {
+		return
+	}
+	if !found {
+		http.Error(w, "The link is wrong or has expired, type export in the game for another", http.StatusNotFound)
+		return
+	}
+	data, err := ioutil.ReadFile(c.File)
+	if err != nil {
+		log.Error(fmt.Sprintf("Cannot read the export of %q: %v", c.Player, err))
+		http.Error(w, "The export cannot be read, type export in the game for another", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", c.Player+".json"))
+	w.Write(data)
+}
+
+// restore brings a character back from an export: the newest kept of the
+// player, or the file. The character they have is exported first, so it
+// can be brought back in turn.
+// Usage: restore <player> [file]
+func (s *Server) restore(args []string) string {
+	if len(args) < 1 || len(args) > 2 {
+		return "Usage: restore <player> [file]\n"
+	}
+	nick := args[0]
+	if !IsValidUsername(nick) {
+		return fmt.Sprintf("%q is not a valid name\n", nick)
+	}
+	if _, online := s.clientByNick(nick); online {
+		return fmt.Sprintf("%s is online, they have to log out first\n", nick)
+	}
+	file := ""
+	if len(args) == 2 {
+		file = args[1]
+	} else {
+		exports, err := s.exports(nick)
+		if err != nil || len(exports) == 0 {
+			return fmt.Sprintf("There are no exports of %s\n", nick)
+		}
+		file = exports[len(exports)-1]
+	}
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return fmt.Sprintf("%s cannot be read\n", file)
+	}
+	key, err := s.characterKey()
+	if err != nil {
+		log.Error(fmt.Sprintf("Cannot read the character key: %v", err))
+		return "Something went wrong, try again\n"
+	}
+	p, e, err := ImportCharacter(data, key)
+	if err != nil {
+		return fmt.Sprintf("%s does not import: %v\n", file, err)
+	}
+	if p.Nickname != nick {
+		return fmt.Sprintf("%s holds %s, not %s\n", file, p.Nickname, nick)
+	}
+	if current, found := s.playerOf(nick); found {
+		if _, err := s.keepExport(current); err != nil {
+			log.Error(fmt.Sprintf("Cannot export %q before restoring them: %v", nick, err))
+			return "Something went wrong, try again\n"
+		}
+	}
+	if err := s.savePlayer(&p); err != nil {
+		log.Error(fmt.Sprintf("Cannot save %q, restored from %s: %v", nick, file, err))
+		return "Something went wrong, try again\n"
+	}
+	log.Info(fmt.Sprintf("Restored %q from %s", nick, file))
+	return fmt.Sprintf("Restored %s as %s exported on %s from %s\n", nick, nick, e.Exported.Format("2006-01-02 15:04"), e.Server)
+}
@@ -0,0 +1,148 @@
+package server
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/droslean/thyranew/area"
+)
+
+// maxChannels is how many channels each player can listen to.
+const maxChannels = 10
+
+var channelName = regexp.MustCompile(`^[a-z0-9]{1,16}$`)
+
+// channel lists the channels of the player, or joins or leaves one.
+// Usage: channel [join|leave <name>]
+func (s *Server) channel(p *area.Player, args []string) string {
+	if len(args) == 0 {
+		if len(p.Channels) == 0 {
+			return "You listen to no channels\n"
+		}
+		return fmt.Sprintf("Channels: %s\n", strings.Join(p.Channels, ", "))
+	}
+	if len(args) != 2 {
+		return "Usage: channel [join|leave <name>]\n"
+	}
+	name := strings.ToLower(args[1])
+	switch strings.ToLower(args[0]) {
+	case "join":
+		if !channelName.MatchString(name) {
+			return "Channel names are up to 16 letters and digits\n"
+		}
+		if hasName(p.Channels, name) {
+			return fmt.Sprintf("You already listen to %s\n", name)
+		}
+		if len(p.Channels) >= maxChannels {
+			return fmt.Sprintf("You can't listen to more than %d channels\n", maxChannels)
+		}
+		p.Channels = append(p.Channels, name)
+		return s.savePreferences(p, fmt.Sprintf("You join %s\n", name))
+	case "leave":
+		for i := range p.Channels {
+			if p.Channels[i] == name {
+				p.Channels = append(p.Channels[:i], p.Channels[i+1:]...)
+				return s.savePreferences(p, fmt.Sprintf("You leave %s\n", name))
+			}
+		}
+		return fmt.Sprintf("You don't listen to %s\n", name)
+	}
+	return "Usage: channel [join|leave <name>]\n"
+}
+
+// chat says something on a channel, in the language the player speaks.
+// Usage: chat <channel> <text>
+func (s *Server) chat(roomsMap map[string]map[string][][]area.Cube, p *area.Player, args []string) string {
+	if len(args) < 2 {
+		return "Usage: chat <channel> <text>\n"
+	}
+	name := strings.ToLower(args[0])
+	if !hasName(p.Channels, name) {
+		return fmt.Sprintf("You don't listen to %s\n", name)
+	}
+	if m, muted := p.MutedOn(name, s.now()); muted {
+		return mutedNotice(m)
+	}
+	text := strings.Join(args[1:], " ")
+	s.logChat(p, name, text)
+	s.relayChat(p, name, text)
+	s.relayIntermud(p, name, text)
+	language := speaking(p)
+	s.broadcast(roomsMap, Broadcast{
+		Scope:    ScopeChannel,
+		Channel:  name,
+		From:     p.Nickname,
+		Kind:     TagChat,
+		Text:     fmt.Sprintf("[%s] %s: %s\n", name, p.Nickname, text),
+		Language: language,
+		Garbled:  fmt.Sprintf("[%s] %s says something in %s\n", name, p.Nickname, strings.Title(language)),
+	})
+	return tagged(TagChat, fmt.Sprintf("[%s] You: %s\n", name, text))
+}
+
+// speaking returns the language the player chats in.
+func speaking(p *area.Player) string {
+	if p.Speaking == "" {
+		return commonLanguage
+	}
+	return p.Speaking
+}
+
+// speak shows or changes the language the player chats in.
+// Usage: speak [language]
+func (s *Server) speak(p *area.Player, args []string) string {
+	if len(args) == 0 {
+		known := append([]string{commonLanguage}, p.Languages...)
+		sort.Strings(known)
+		return fmt.Sprintf("You speak %s, and know %s\n", strings.Title(speaking(p)), strings.Join(known, ", "))
+	}
+	language := strings.ToLower(args[0])
+	if !knowsLanguage(p, language) {
+		return fmt.Sprintf("You don't know %s\n", strings.Title(language))
+	}
+	p.Speaking = language
+	if language == commonLanguage {
+		p.Speaking = ""
+	}
+	return s.savePreferences(p, fmt.Sprintf("You speak %s\n", strings.Title(language)))
+}
+
+// ignore lists the players the player ignores, or ignores one more.
+// Usage: ignore [player]
+func (s *Server) ignore(p *area.Player, args []string) string {
+	if len(args) == 0 {
+		if len(p.Ignores) == 0 {
+			return "You ignore nobody\n"
+		}
+		return fmt.Sprintf("You ignore %s\n", strings.Join(p.Ignores, ", "))
+	}
+	nick := args[0]
+	if strings.EqualFold(nick, p.Nickname) {
+		return "You can't ignore yourself\n"
+	}
+	if hasName(p.Ignores, nick) {
+		return fmt.Sprintf("You already ignore %s\n", nick)
+	}
+	if len(p.Ignores) >= maxRules {
+		return fmt.Sprintf("You can't ignore more than %d players\n", maxRules)
+	}
+	p.Ignores = append(p.Ignores, nick)
+	return s.savePreferences(p, fmt.Sprintf("You ignore %s\n", nick))
+}
+
+// unignore stops ignoring a player.
+// Usage: unignore <player>
+func (s *Server) unignore(p *area.Player, args []string) string {
+	if len(args) != 1 {
+		return "Usage: unignore <player>\n"
+	}
+	for i := range p.Ignores {
+		if strings.EqualFold(p.Ignores[i], args[0]) {
+			p.Ignores = append(p.Ignores[:i], p.Ignores[i+1:]...)
+			return s.savePreferences(p, fmt.Sprintf("You no longer ignore %s\n", args[0]))
+		}
+	}
+	return fmt.Sprintf("You don't ignore %s\n", args[0])
+}
@@ -0,0 +1,510 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/droslean/thyranew/area"
+
+	log "gopkg.in/inconshreveable/log15.v2"
+)
+
+const (
+	// chatLogQueue is how many entries wait to be written before more are
+	// dropped, so a slow disk never holds up the world.
+	chatLogQueue = 1000
+	// chatLogPrune is how often entries past their retention are dropped.
+	chatLogPrune = time.Hour
+	// logSearchLines is how many matches admins are shown.
+	logSearchLines = 20
+	// commandStream is the stream the commands are logged to. Each channel
+	// is logged to a stream of its own.
+	commandStream = "commands"
+	// logDay names the file of a day in a stream logged to files.
+	logDay = "2006-01-02"
+)
+
+// privateCommands carry what players say to each other alone. They are only
+// logged for players under review.
+var privateCommands = map[string]bool{"mail": true, "tell": true}
+
+// ChatLogConfig says what is logged of the chat and the commands, and for how
+// long.
+type ChatLogConfig struct {
+	// Store is where the logs go: "file" for files rotated every day, or "db"
+	// for the database. Nothing is logged when empty.
+	Store string `toml:"store"`
+	// Dir is the directory of the files, under the data directory unless
+	// absolute.
+	Dir string `toml:"dir"`
+	// Days is how long the channels are kept, and Channels how long each
+	// channel is, in its place. Channels kept for zero days are not logged.
+	Days     int            `toml:"days"`
+	Channels map[string]int `toml:"channels"`
+	// CommandDays is how long the commands are kept. They are not logged
+	// when it is zero.
+	CommandDays int `toml:"commanddays"`
+}
+
+// LogEntry is a line of chat or a command, as it was logged.
+type LogEntry struct {
+	Time   time.Time `json:"time"`
+	Stream string    `json:"stream"`
+	Player string    `json:"player"`
+	Area   string    `json:"area"`
+	Room   string    `json:"room"`
+	Text   string    `json:"text"`
+}
+
+func chatStream(channel string) string {
+	return "chat-" + channel
+}
+
+// logSink is where the log is written to.
+type logSink interface {
+	write(entries []LogEntry) error
+	// prune drops the entries older than what keep returns for their stream.
+	prune(keep func(stream string) time.Duration) error
+	search(streams []string, match func(LogEntry) bool, max int) ([]LogEntry, error)
+	// forget drops the entries of the player, and puts alias in place of
+	// their name in the others.
+	forget(nick, alias string) error
+}
+
+// chatLog writes the entries given to it on a goroutine of its own, and drops
+// the old ones now and then.
+type chatLog struct {
+	config  ChatLogConfig
+	sink    logSink
+	entries chan LogEntry
+	forgets chan forgetting
+	done    chan struct{}
+	dropped uint64
+}
+
+// forgetting is a player the log is to forget, and where it tells how that
+// went.
+type forgetting struct {
+	nick, alias string
+	done        chan error
+}
+
+func newChatLog(config ChatLogConfig, sink logSink) *chatLog {
+	l := &chatLog{
+		config:  config,
+		sink:    sink,
+		entries: make(chan LogEntry, chatLogQueue),
+		forgets: make(chan forgetting),
+		done:    make(chan struct{}),
+	}
+	go l.run()
+	return l
+}
+
+// openChatLog opens the log the server is set up for, or returns nil when
+// nothing is logged.
+func (s *Server) openChatLog() (*chatLog, error) {
+	config := s.config.ChatLog
+	var sink logSink
+	switch config.Store {
+	case "":
+		return nil, nil
+	case "db":
+		sink = dbSink{db: s.db}
+	case "file":
+		dir := config.Dir
+		if dir == "" {
+			dir = "logs"
+		}
+		if !filepath.IsAbs(dir) {
+			dir = filepath.Join(s.dataDir, dir)
+		}
+		sink = fileSink{dir: dir}
+	default:
+		return nil, fmt.Errorf("unknown chat log store %q", config.Store)
+	}
+	log.Info(fmt.Sprintf("Logging the chat to the %s", config.Store))
+	return newChatLog(config, sink), nil
+}
+
+// keep returns how long the entries of the stream are kept.
+func (l *chatLog) keep(stream string) time.Duration {
+	days := l.config.Days
+	if stream == commandStream {
+		days = l.config.CommandDays
+	} else if d, ok := l.config.Channels[strings.TrimPrefix(stream, "chat-")]; ok {
+		days = d
+	}
+	return time.Duration(days) * 24 * time.Hour
+}
+
+// add queues the entry to be written, unless its stream is not logged. A
+// full queue drops it.
+func (l *chatLog) add(e LogEntry) {
+	if l == nil || l.keep(e.Stream) <= 0 {
+		return
+	}
+	select {
+	case l.entries <- e:
+	default:
+		if atomic.AddUint64(&l.dropped, 1)%chatLogQueue == 1 {
+			log.Warn("The chat log falls behind and drops entries")
+		}
+	}
+}
+
+// close writes what is left in the queue and stops the log.
+func (l *chatLog) close() {
+	if l == nil {
+		return
+	}
+	close(l.entries)
+	<-l.done
+}
+
+func (l *chatLog) run() {
+	defer close(l.done)
+	ticker := time.NewTicker(chatLogPrune)
+	defer ticker.Stop()
+	l.prune()
+	for {
+		select {
+		case e, ok := <-l.entries:
+			if !ok {
+				return
+			}
+			// Whatever else is waiting goes along in the same write.
+			batch := []LogEntry{e}
+			for more := true; more && len(batch) < chatLogQueue; {
+				select {
+				case e, ok := <-l.entries:
+					if ok {
+						batch = append(batch, e)
+					}
+					more = ok
+				default:
+					more = false
+				}
+			}
+			if err := l.sink.write(batch); err != nil {
+				log.Error(fmt.Sprintf("Cannot write the chat log: %v", err))
+			}
+		case f := <-l.forgets:
+			f.done <- l.sink.forget(f.nick, f.alias)
+		case <-ticker.C:
+			l.prune()
+		}
+	}
+}
+
+// forget drops the entries of the player whose account was deleted, and puts
+// alias in place of their name in the others. It waits for the log to get to
+// it.
+func (l *chatLog) forget(nick, alias string) error {
+	if l == nil {
+		return nil
+	}
+	f := forgetting{nick: nick, alias: alias, done: make(chan error, 1)}
+	select {
+	case l.forgets <- f:
+	case <-l.done:
+		return fmt.Errorf("the chat log is closed")
+	}
+	return <-f.done
+}
+
+func (l *chatLog) prune() {
+	if err := l.sink.prune(l.keep); err != nil {
+		log.Error(fmt.Sprintf("Cannot prune the chat log: %v", err))
+	}
+}
+
+// logChat logs what the player said on the channel.
+func (s *Server) logChat(p *area.Player, channel, text string) {
+	s.chatLog.add(LogEntry{Time: s.now(), Stream: chatStream(channel), Player: p.Nickname, Area: p.Area, Room: p.Room, Text: text})
+}
+
+// logCommand logs the command of the player. Chat is logged on its channel,
+// and private commands only for players under review.
+func (s *Server) logCommand(p *area.Player, line string) {
+	cmd, _ := parseCommand(line)
+	if cmd == "" || cmd == "chat" || (privateCommands[cmd] && !p.UnderReview(s.now())) {
+		return
+	}
+	s.chatLog.add(LogEntry{Time: s.now(), Stream: commandStream, Player: p.Nickname, Area: p.Area, Room: p.Room, Text: line})
+}
+
+// searchLogs looks through the logs for the lines that hold all the words.
+// The search runs off the world, which tells the admin what was found.
+// Usage: logs [channel=<name>|commands] [player=<nick>] [words]
+func (s *Server) searchLogs(roomsMap map[string]map[string][][]area.Cube, cl *Client, args []string) string {
+	if s.chatLog == nil {
+		return "Nothing is logged on this server\n"
+	}
+	var streams []string
+	player := ""
+	words := []string{}
+	for _, arg := range args {
+		switch {
+		case strings.HasPrefix(arg, "channel="):
+			streams = append(streams, chatStream(strings.ToLower(strings.TrimPrefix(arg, "channel="))))
+		case arg == "commands":
+			streams = append(streams, commandStream)
+		case strings.HasPrefix(arg, "player="):
+			player = strings.TrimPrefix(arg, "player=")
+		default:
+			words = append(words, strings.ToLower(arg))
+		}
+	}
+	match := func(e LogEntry) bool {
+		if player != "" && !strings.EqualFold(e.Player, player) {
+			return false
+		}
+		text := strings.ToLower(e.Text)
+		for _, w := range words {
+			if !strings.Contains(text, w) {
+				return false
+			}
+		}
+		return true
+	}
+
+	admin := *cl
+	go func() {
+		found, err := s.chatLog.sink.search(streams, match, logSearchLines)
+		s.worldTasks <- func() {
+			msg := logSearchResult(found, err)
+			s.godPrintRoom([]Client{admin}, roomsMap, tagged(TagSystem, msg), "")
+		}
+	}()
+	return ""
+}
+
+func logSearchResult(found []LogEntry, err error) string {
+	if err != nil {
+		log.Error(fmt.Sprintf("Cannot search the chat log: %v", err))
+		return "The logs cannot be searched right now\n"
+	}
+	if len(found) == 0 {
+		return "Nothing in the logs matches\n"
+	}
+	lines := []string{}
+	for _, e := range found {
+		lines = append(lines, fmt.Sprintf("%s [%s] %s in %s/%s: %s", e.Time.Format("2006-01-02 15:04"), e.Stream, e.Player, e.Area, e.Room, e.Text))
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// latestEntries sorts the entries by time and keeps the latest of them.
+func latestEntries(entries []LogEntry, max int) []LogEntry {
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].Time.Before(entries[j].Time) })
+	if len(entries) > max {
+		entries = entries[len(entries)-max:]
+	}
+	return entries
+}
+
+// dbSink keeps the log in the database.
+type dbSink struct {
+	db *Database
+}
+
+func (d dbSink) write(entries []LogEntry) error {
+	return d.db.AddLogEntries(context.Background(), entries)
+}
+
+func (d dbSink) prune(keep func(string) time.Duration) error {
+	return d.db.PruneLog(context.Background(), keep)
+}
+
+func (d dbSink) forget(nick, alias string) error {
+	return d.db.ForgetInLog(context.Background(), nick, alias)
+}
+
+func (d dbSink) search(streams []string, match func(LogEntry) bool, max int) ([]LogEntry, error) {
+	return d.db.SearchLog(context.Background(), streams, match, max)
+}
+
+// fileSink keeps the log in files of JSON lines, in a directory for each
+// stream and a file for each day.
+type fileSink struct {
+	dir string
+}
+
+func (f fileSink) write(entries []LogEntry) error {
+	files := map[string]*os.File{}
+	defer func() {
+		for _, file := range files {
+			file.Close()
+		}
+	}()
+	for _, e := range entries {
+		path := filepath.Join(f.dir, e.Stream, e.Time.Format(logDay)+".log")
+		file, ok := files[path]
+		if !ok {
+			if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+				return err
+			}
+			var err error
+			if file, err = os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644); err != nil {
+				return err
+			}
+			files[path] = file
+		}
+		line, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		if _, err := file.Write(append(line, '\n')); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// streams returns the streams that have files.
+func (f fileSink) streams() ([]string, error) {
+	dirs, err := ioutil.ReadDir(f.dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	streams := []string{}
+	for _, d := range dirs {
+		if d.IsDir() {
+			streams = append(streams, d.Name())
+		}
+	}
+	return streams, nil
+}
+
+func (f fileSink) prune(keep func(string) time.Duration) error {
+	streams, err := f.streams()
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	for _, stream := range streams {
+		d := keep(stream)
+		files, err := ioutil.ReadDir(filepath.Join(f.dir, stream))
+		if err != nil {
+			return err
+		}
+		for _, file := range files {
+			day, err := time.ParseInLocation(logDay, strings.TrimSuffix(file.Name(), ".log"), time.Local)
+			if err != nil {
+				continue
+			}
+			// A day is dropped once all of it is past its retention.
+			if d <= 0 || now.Sub(day.AddDate(0, 0, 1)) >= d {
+				if err := os.Remove(filepath.Join(f.dir, stream, file.Name())); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func (f fileSink) search(streams []string, match func(LogEntry) bool, max int) ([]LogEntry, error) {
+	if len(streams) == 0 {
+		var err error
+		if streams, err = f.streams(); err != nil {
+			return nil, err
+		}
+	}
+	found := []LogEntry{}
+	for _, stream := range streams {
+		files, err := ioutil.ReadDir(filepath.Join(f.dir, stream))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		for _, file := range files {
+			entries, err := readLogFile(filepath.Join(f.dir, stream, file.Name()), match)
+			if err != nil {
+				return nil, err
+			}
+			found = append(found, entries...)
+		}
+	}
+	return latestEntries(found, max), nil
+}
+
+func (f fileSink) forget(nick, alias string) error {
+	streams, err := f.streams()
+	if err != nil {
+		return err
+	}
+	for _, stream := range streams {
+		files, err := ioutil.ReadDir(filepath.Join(f.dir, stream))
+		if err != nil {
+			return err
+		}
+		for _, file := range files {
+			path := filepath.Join(f.dir, stream, file.Name())
+			entries, err := readLogFile(path, func(LogEntry) bool { return true })
+			if err != nil {
+				return err
+			}
+			var kept bytes.Buffer
+			changed := false
+			for _, e := range entries {
+				if e.Player == nick {
+					changed = true
+					continue
+				}
+				if text := anonymize(e.Text, nick, alias); text != e.Text {
+					e.Text, changed = text, true
+				}
+				line, err := json.Marshal(e)
+				if err != nil {
+					return err
+				}
+				kept.Write(append(line, '\n'))
+			}
+			if !changed {
+				continue
+			}
+			if err := writeAtomic(path, kept.Bytes()); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func readLogFile(path string, match func(LogEntry) bool) ([]LogEntry, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	found := []LogEntry{}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		e := LogEntry{}
+		// A line cut short by a crash is skipped.
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			continue
+		}
+		if match(e) {
+			found = append(found, e)
+		}
+	}
+	return found, scanner.Err()
+}
@@ -1,9 +1,12 @@
 package server
 
 import (
+	"bytes"
+	"context"
 	"fmt"
 	"math"
 	"sync"
+	"sync/atomic"
 
 	"github.com/droslean/thyranew/area"
 	"github.com/jpillora/ansi"
@@ -19,6 +22,7 @@ type resize struct {
 type Client struct {
 	id                   ID     // identification
 	hash                 string //hash of public key
+	ip                   string // address the player connects from
 	SSHName, Name, cname string
 	w, h                 int // terminal size
 	ready                bool
@@ -26,14 +30,30 @@ type Client struct {
 	screen               *Screen
 	conn                 *ansi.Ansi
 	promptBar            *PromptBar
+	recorder             *recordingChannel
 	Player               *area.Player
+	// ctx is done once the connection is, and cancel drops the connection.
+	ctx    context.Context
+	cancel context.CancelFunc
+	life   *lifecycle
+	// probe is what the client told about itself.
+	probe *clientProbe
+	// ping is the round trip to the client.
+	ping *latency
+	// modalOpen is 1 while the world shows the player a modal, so the prompt
+	// bar hands it the keys that steer it. It is read and set atomically.
+	modalOpen int32
+	// created is set when the character was made on this connection.
+	created bool
 }
 
-// NewPlayer returns an initialized Player.
-func NewClient(id ID, sshName, name, hash string, conn ssh.Channel, player *area.Player) *Client {
+// NewPlayer returns an initialized Player. The client lives as long as the
+// context of its connection.
+func NewClient(ctx context.Context, cancel context.CancelFunc, life *lifecycle, id ID, sshName, name, hash string, conn ssh.Channel, player *area.Player) *Client {
 	if hash == "" {
 		hash = name //finally, hash fallsback to name
 	}
+	recorder := &recordingChannel{Channel: conn}
 	p := &Client{
 		id:        id,
 		hash:      hash,
@@ -41,9 +61,15 @@ func NewClient(id ID, sshName, name, hash string, conn ssh.Channel, player *area
 		Name:      name,
 		ready:     false,
 		resizes:   make(chan resize),
-		conn:      ansi.Wrap(conn),
+		conn:      ansi.Wrap(recorder),
 		promptBar: NewPromptBar(),
+		recorder:  recorder,
 		Player:    player,
+		ctx:       ctx,
+		cancel:    cancel,
+		life:      life,
+		probe:     newClientProbe(),
+		ping:      &latency{},
 	}
 	return p
 }
@@ -52,10 +78,13 @@ var resizeTmpl = string(ansi.Goto(2, 5)) +
 	string(ansi.Set(ansi.Blue)) +
 	"Please resize your terminal to %dx%d (+%dx+%d)" + string(ansi.Set(ansi.Default))
 
-func (c *Client) receiveActions(stopCh <-chan struct{}, wg *sync.WaitGroup) {
+func (c *Client) receiveActions(ctx context.Context, eventCh chan Event, wg *sync.WaitGroup) {
 	// defer wg.Done()
+	// Once the player is gone, so is the connection.
+	defer c.cancel()
 
 	buff := make([]byte, 3)
+	var partial []byte
 
 	for {
 		log.Debug(fmt.Sprintf("read buff is : %v", buff))
@@ -64,7 +93,9 @@ func (c *Client) receiveActions(stopCh <-chan struct{}, wg *sync.WaitGroup) {
 		if err != nil {
 			break
 		}
-		b := buff[:n]
+		// The buffer is read into again while the prompt bar works on the
+		// key, so the prompt bar gets a copy.
+		b := append([]byte(nil), buff[:n]...)
 		if b[0] == 3 {
 			break
 		}
@@ -74,10 +105,23 @@ func (c *Client) receiveActions(stopCh <-chan struct{}, wg *sync.WaitGroup) {
 			continue
 		}
 
+		// Reports of the mouse take more than one read. They are gathered
+		// until whole, and whatever follows them is read as keys.
+		if len(partial) > 0 || bytes.HasPrefix(b, mouseReportPrefix) {
+			var reports []mouseReport
+			reports, b, partial = splitMouse(append(partial, b...))
+			for _, r := range reports {
+				c.sendMouse(ctx, eventCh, r)
+			}
+			if len(b) == 0 {
+				continue
+			}
+		}
+
 		// Send byte array to Prompt bar channel
 		select {
 		case c.promptBar.promptChan <- b:
-		case <-stopCh:
+		case <-ctx.Done():
 			log.Info("receiveActions is exiting.")
 			return
 		}
@@ -93,17 +137,28 @@ func (c *Client) writeGoto(x, y int) {
 	c.conn.Write(ansi.Goto(uint16(x), uint16(y)))
 }
 
-func (c *Client) prepareClient(events chan Event, stopCh <-chan struct{}, wg *sync.WaitGroup) {
+// prepareClient starts the goroutines of the client. A panic in any of them
+// drops the client, and nobody else.
+func (s *Server) prepareClient(c *Client, wg *sync.WaitGroup) {
 	defer wg.Done()
 
 	// wg.Add(1)
-	go c.receiveActions(stopCh, wg)
+	go func() {
+		defer s.guard(c, "receiveActions")
+		c.receiveActions(c.ctx, s.Events, wg)
+	}()
 
 	wg.Add(1)
-	go c.promptBar.promptBar(c, events, stopCh, wg)
+	go func() {
+		defer s.guard(c, "promptBar")
+		c.promptBar.promptBar(c.ctx, c, s.Events, wg)
+	}()
 
 	wg.Add(1)
-	go c.resizeWatch(stopCh, wg)
+	go func() {
+		defer s.guard(c, "resizeWatch")
+		c.resizeWatch(c.ctx, s.Events, wg)
+	}()
 
 	log.Info("prepareClient complete.")
 }
@@ -118,12 +173,21 @@ func (c *Client) resetScreen() {
 	}
 }
 
-func (c *Client) resizeWatch(stopCh <-chan struct{}, wg *sync.WaitGroup) {
+// resize hands the new size of the terminal to resizeWatch, unless the
+// connection is done.
+func (c *Client) resize(r resize) {
+	select {
+	case c.resizes <- r:
+	case <-c.ctx.Done():
+	}
+}
+
+func (c *Client) resizeWatch(ctx context.Context, eventCh chan Event, wg *sync.WaitGroup) {
 	defer wg.Done()
 
 	for {
 		select {
-		case <-stopCh:
+		case <-ctx.Done():
 			log.Info("resizeWatch is exiting.")
 			return
 		case r := <-c.resizes:
@@ -137,6 +201,14 @@ func (c *Client) resizeWatch(stopCh <-chan struct{}, wg *sync.WaitGroup) {
 				// send updates!
 				c.ready = true
 				c.screen = NewScreen(c.w, c.h)
+				// An open modal is laid out again to fit.
+				if atomic.LoadInt32(&c.modalOpen) == 1 {
+					select {
+					case eventCh <- Event{Client: c, key: keyResize}:
+					case <-ctx.Done():
+						return
+					}
+				}
 			} else {
 				// doesnt fit
 				c.conn.EraseScreen()
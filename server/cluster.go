@@ -0,0 +1,342 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/droslean/thyranew/area"
+	"github.com/droslean/thyranew/cluster"
+
+	log "gopkg.in/inconshreveable/log15.v2"
+)
+
+// clusterTimeout is how long the other servers of the cluster have to answer.
+const clusterTimeout = 5 * time.Second
+
+// ClusterConfig holds the other servers this one forms a cluster with.
+type ClusterConfig struct {
+	// Name is what the other servers and their players know this one by.
+	Name string `toml:"name"`
+	// Listen is the address the API of the cluster is served on. It is off
+	// when empty, and shouldn't be reachable from outside the cluster.
+	Listen string `toml:"listen"`
+	// Secret is shared by all the servers of the cluster.
+	Secret string `toml:"secret"`
+	// Channels are the chat channels the servers share.
+	Channels []string `toml:"channels"`
+	// Peers are the other servers.
+	Peers []ClusterPeer `toml:"peers"`
+}
+
+// ClusterPeer is another server of the cluster.
+type ClusterPeer struct {
+	Name string `toml:"name"`
+	Addr string `toml:"addr"` // Host and port of its API
+}
+
+// startCluster connects to the other servers of the cluster, and serves them
+// the API of this one if it listens.
+func (s *Server) startCluster() {
+	c := s.config.Cluster
+	for _, peer := range c.Peers {
+		s.peers[strings.ToLower(peer.Name)] = cluster.NewClient(peer.Addr, c.Secret)
+	}
+	if c.Listen == "" {
+		return
+	}
+	l, err := net.Listen("tcp", c.Listen)
+	if err != nil {
+		log.Error(fmt.Sprintf("Cannot serve the cluster on %s: %v", c.Listen, err))
+		return
+	}
+	go func() {
+		log.Info(fmt.Sprintf("Serving the cluster on %s as %q", c.Listen, c.Name))
+		if err := cluster.Serve(l, cluster.NewHandler(clusterService{s}, c.Secret)); err != nil {
+			log.Error(fmt.Sprintf("Cluster listener on %s stopped: %v", c.Listen, err))
+		}
+	}()
+}
+
+// inRooms runs the task on the goroutine of the world, with its rooms, and
+// waits for it. It reports false when the context is done first.
+func (s *Server) inRooms(ctx context.Context, task func(roomsMap map[string]map[string][][]area.Cube)) bool {
+	return s.inWorld(ctx, func() { task(s.world.rooms) })
+}
+
+// tellLater tells the player something once the world gets to it, from off
+// the world.
+func (s *Server) tellLater(nick, msg string) {
+	s.worldTasks <- func() { s.tellPlayer(s.world.rooms, nick, msg) }
+}
+
+// clusterService is what this server does for the others of the cluster.
+type clusterService struct {
+	s *Server
+}
+
+func (c clusterService) Status(ctx context.Context, req *cluster.StatusRequest) (*cluster.StatusReply, error) {
+	return &cluster.StatusReply{Name: c.s.config.Cluster.Name, Players: int32(len(c.s.OnlineClients()))}, nil
+}
+
+func (c clusterService) Tell(ctx context.Context, req *cluster.TellRequest) (*cluster.TellReply, error) {
+	delivered := false
+	if !c.s.inRooms(ctx, func(roomsMap map[string]map[string][][]area.Cube) {
+		delivered = c.s.hear(roomsMap, req.From+"@"+req.FromServer, req.To, req.Text)
+	}) {
+		return nil, cluster.Errorf(cluster.CodeUnavailable, "the world is busy")
+	}
+	return &cluster.TellReply{Delivered: delivered}, nil
+}
+
+func (c clusterService) Chat(ctx context.Context, req *cluster.ChatRequest) (*cluster.ChatReply, error) {
+	if !hasName(c.s.config.Cluster.Channels, req.Channel) {
+		return nil, cluster.Errorf(cluster.CodePermissionDenied, "channel %q is not shared", req.Channel)
+	}
+	from := req.From + "@" + req.FromServer
+	if !c.s.inRooms(ctx, func(roomsMap map[string]map[string][][]area.Cube) {
+		c.s.chatLog.add(LogEntry{Time: c.s.now(), Stream: chatStream(req.Channel), Player: from, Text: req.Text})
+		c.s.broadcast(roomsMap, Broadcast{
+			Scope:   ScopeChannel,
+			Channel: req.Channel,
+			From:    from,
+			Kind:    TagChat,
+			Text:    fmt.Sprintf("[%s] %s: %s\n", req.Channel, from, req.Text),
+		})
+	}) {
+		return nil, cluster.Errorf(cluster.CodeUnavailable, "the world is busy")
+	}
+	return &cluster.ChatReply{}, nil
+}
+
+func (c clusterService) Transfer(ctx context.Context, req *cluster.TransferRequest) (*cluster.TransferReply, error) {
+	key, err := c.s.characterKey()
+	if err != nil {
+		log.Error(fmt.Sprintf("Cannot read the character key: %v", err))
+		return nil, cluster.Errorf(cluster.CodeUnavailable, "the character cannot be checked")
+	}
+	p, _, err := ImportCharacter(req.Character, key)
+	if err != nil {
+		return nil, cluster.Errorf(cluster.CodeInvalidArgument, "the character cannot be read: %v", err)
+	}
+	if p.Nickname != req.Player || !IsValidUsername(p.Nickname) {
+		return &cluster.TransferReply{Reason: "the name is not valid here"}, nil
+	}
+	reply := &cluster.TransferReply{}
+	if !c.s.inWorld(ctx, func() {
+		if _, online := c.s.clientByNick(p.Nickname); online {
+			reply.Reason = "the name is taken here"
+			return
+		}
+		if found, err := c.s.loadPlayer(p.Nickname); err != nil || found {
+			reply.Reason = "the name is taken here"
+			return
+		}
+		// What points into the world the character left means nothing here.
+		p.Bind = area.Exit{}
+		p.Mail = nil
+		p.Jail = area.Jail{}
+		sendTo(&p, defaultBind)
+		if err := c.s.savePlayer(&p); err != nil {
+			log.Error(fmt.Sprintf("Cannot save %q, transferred from %s: %v", p.Nickname, req.FromServer, err))
+			reply.Reason = "the character cannot be saved"
+			return
+		}
+		log.Info(fmt.Sprintf("%q transferred from %s", p.Nickname, req.FromServer))
+		reply.Accepted = true
+	}) {
+		return nil, cluster.Errorf(cluster.CodeUnavailable, "the world is busy")
+	}
+	return reply, nil
+}
+
+// hear tells the player online what another player told them, unless they
+// ignore the teller. It reports whether the player is online.
+func (s *Server) hear(roomsMap map[string]map[string][][]area.Cube, from, to, text string) bool {
+	cl, ok := s.clientByNick(to)
+	if !ok || cl.state() != StatePlaying {
+		return false
+	}
+	if !hasName(cl.Player.Ignores, from) {
+		s.godPrintRoom([]Client{*cl}, roomsMap, tagged(TagChat, fmt.Sprintf("%s tells you: %s\n", from, text)), "")
+	}
+	return true
+}
+
+// tell tells a player something in private, on this server or, with the
+// name of the server after theirs, on another of the cluster.
+// Usage: tell <player>[@server] <text>
+func (s *Server) tell(roomsMap map[string]map[string][][]area.Cube, p *area.Player, args []string) string {
+	if len(args) < 2 {
+		return "Usage: tell <player>[@server] <text>\n"
+	}
+	if m, muted := p.MutedOn("tell", s.now()); muted {
+		return mutedNotice(m)
+	}
+	to, text := args[0], strings.Join(args[1:], " ")
+	nick, server, remote := strings.Cut(to, "@")
+	if !remote {
+		if strings.EqualFold(nick, p.Nickname) {
+			return "You tell yourself nothing new\n"
+		}
+		if !s.hear(roomsMap, p.Nickname, nick, text) {
+			return fmt.Sprintf("%s is not online\n", nick)
+		}
+		return tagged(TagChat, fmt.Sprintf("You tell %s: %s\n", nick, text))
+	}
+
+	peer, ok := s.peers[strings.ToLower(server)]
+	if !ok {
+		return fmt.Sprintf("There is no server %q in the cluster\n", server)
+	}
+	req := &cluster.TellRequest{FromServer: s.config.Cluster.Name, From: p.Nickname, To: nick, Text: text}
+	teller := p.Nickname
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), clusterTimeout)
+		defer cancel()
+		reply, err := peer.Tell(ctx, req)
+		switch {
+		case err != nil:
+			log.Warn(fmt.Sprintf("Cannot tell %s: %v", to, err))
+			s.tellLater(teller, fmt.Sprintf("%s can't be reached\n", server))
+		case !reply.Delivered:
+			s.tellLater(teller, fmt.Sprintf("%s is not online\n", to))
+		}
+	}()
+	return tagged(TagChat, fmt.Sprintf("You tell %s: %s\n", to, text))
+}
+
+// relayChat passes what the player said on the channel on to the other
+// servers, if they share it.
+func (s *Server) relayChat(p *area.Player, channel, text string) {
+	if !hasName(s.config.Cluster.Channels, channel) {
+		return
+	}
+	req := &cluster.ChatRequest{FromServer: s.config.Cluster.Name, Channel: channel, From: p.Nickname, Text: text}
+	for name, peer := range s.peers {
+		name, peer := name, peer
+		go func() {
+			ctx, cancel := context.WithTimeout(context.Background(), clusterTimeout)
+			defer cancel()
+			if _, err := peer.Chat(ctx, req); err != nil {
+				log.Warn(fmt.Sprintf("Cannot relay %s to %s: %v", channel, name, err))
+			}
+		}()
+	}
+}
+
+// clusterStatus tells the player how many play on each server of the
+// cluster. The servers are asked off the world.
+// Usage: cluster
+func (s *Server) clusterStatus(p *area.Player) string {
+	if len(s.peers) == 0 {
+		return "This server is not in a cluster\n"
+	}
+	here := fmt.Sprintf("%s: %d online (here)", s.config.Cluster.Name, len(s.OnlineClients()))
+	nick := p.Nickname
+	go func() {
+		var mu sync.Mutex
+		var wg sync.WaitGroup
+		lines := []string{}
+		for name, peer := range s.peers {
+			name, peer := name, peer
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				ctx, cancel := context.WithTimeout(context.Background(), clusterTimeout)
+				defer cancel()
+				line := fmt.Sprintf("%s: unreachable", name)
+				if reply, err := peer.Status(ctx, &cluster.StatusRequest{FromServer: s.config.Cluster.Name}); err == nil {
+					line = fmt.Sprintf("%s: %d online", reply.Name, reply.Players)
+				}
+				mu.Lock()
+				lines = append(lines, line)
+				mu.Unlock()
+			}()
+		}
+		wg.Wait()
+		sort.Strings(lines)
+		s.tellLater(nick, strings.Join(append([]string{here}, lines...), "\n")+"\n")
+	}()
+	return ""
+}
+
+// migrate moves the character of the player to another server of the
+// cluster. Until the server takes it, the player can do nothing but quit;
+// once it does, the player is let go and the file of the character is kept
+// aside.
+// Usage: migrate <server>
+func (s *Server) migrate(cl *Client, args []string) string {
+	p := cl.Player
+	if len(args) != 1 {
+		return "Usage: migrate <server>\n"
+	}
+	server := strings.ToLower(args[0])
+	peer, ok := s.peers[server]
+	switch {
+	case !ok:
+		return fmt.Sprintf("There is no server %q in the cluster\n", args[0])
+	case s.inCombat(p):
+		return "You can't leave in the middle of a fight\n"
+	}
+	if err := s.savePlayer(p); err != nil {
+		log.Error(fmt.Sprintf("Cannot save player %q: %v", p.Nickname, err))
+		return "Something went wrong, try again\n"
+	}
+	key, err := s.characterKey()
+	if err != nil {
+		log.Error(fmt.Sprintf("Cannot read the character key: %v", err))
+		return "Something went wrong, try again\n"
+	}
+	character, err := ExportCharacter(*p, s.serverName(), key, s.now())
+	if err != nil {
+		log.Error(fmt.Sprintf("Cannot export player %q: %v", p.Nickname, err))
+		return "Something went wrong, try again\n"
+	}
+	s.migrating[p.Nickname] = server
+	req := &cluster.TransferRequest{FromServer: s.config.Cluster.Name, Player: p.Nickname, Character: character}
+	nick := p.Nickname
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), clusterTimeout)
+		defer cancel()
+		reply, err := peer.Transfer(ctx, req)
+		if err != nil {
+			log.Warn(fmt.Sprintf("Cannot transfer %q to %s: %v", nick, server, err))
+			reply = &cluster.TransferReply{Reason: "it can't be reached"}
+		}
+		s.worldTasks <- func() { s.migrated(nick, server, reply) }
+	}()
+	return fmt.Sprintf("You set out for %s\n", server)
+}
+
+// migrated lets the player go once the server they move to took their
+// character, or tells them why it didn't.
+func (s *Server) migrated(nick, server string, reply *cluster.TransferReply) {
+	delete(s.migrating, nick)
+	if !reply.Accepted {
+		s.tellPlayer(s.world.rooms, nick, fmt.Sprintf("%s won't take you: %s\n", server, reply.Reason))
+		return
+	}
+	if cl, ok := s.clientByNick(nick); ok {
+		cl.writeString(fmt.Sprintf("Your character now lives on %s\r\n", server))
+		s.disconnect(cl)
+	}
+	// An export is kept, should the character have to come back.
+	if p, found := s.playerOf(nick); found {
+		if _, err := s.keepExport(p); err != nil {
+			log.Error(fmt.Sprintf("Cannot export %q after they moved to %s: %v", nick, server, err))
+		}
+	}
+	if _, file := s.getPlayerFileName(nick); file != "" {
+		if err := os.Rename(file, file+".migrated"); err != nil {
+			log.Error(fmt.Sprintf("Cannot set %q aside after they moved to %s: %v", nick, server, err))
+		}
+	}
+	delete(s.Players, nick)
+	log.Info(fmt.Sprintf("%q moved to %s", nick, server))
+}
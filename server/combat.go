@@ -0,0 +1,511 @@
+package server
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/droslean/thyranew/area"
+	"github.com/droslean/thyranew/game"
+)
+
+const (
+	// projectileDelay is how long a projectile takes to cross a room.
+	projectileDelay = 600 * time.Millisecond
+	// npcStepDelay is how often an NPC that chases a player acts.
+	npcStepDelay = 2 * time.Second
+	// npcGiveUp is how many rooms away an NPC stops chasing its target,
+	// unless the config or the NPC set otherwise.
+	npcGiveUp = 5
+)
+
+// attackVerbs are what the room reads of a ranged attack, by its kind.
+var attackVerbs = map[string]string{
+	"bow":    "$n {shoot|shoots} an arrow at $N\n",
+	"thrown": "$n {throw|throws} a weapon at $N\n",
+	"spell":  "$n {cast|casts} a spell at $N\n",
+}
+
+// rangedAttack attacks a creature up to a few rooms away.
+// Usage: shoot <target>, throw <weapon> <target>, cast <spell> <target>
+func (s *Server) rangedAttack(roomsMap map[string]map[string][][]area.Cube, cl *Client, cmd string, args []string) string {
+	p := cl.Player
+	kind := map[string]string{"shoot": "bow", "throw": "thrown", "cast": "spell"}[cmd]
+
+	var attack game.RangedAttack
+	var targetName string
+	if kind == "bow" {
+		if attack = bestBow(p); attack.Name == "" {
+			return "You have nothing to shoot with\n"
+		}
+		targetName = strings.Join(args, " ")
+	} else {
+		var ok bool
+		attack, targetName, ok = splitAttack(kind, args)
+		if !ok {
+			return fmt.Sprintf("Usage: %s <%s> <target>\n", cmd, map[string]string{"thrown": "weapon", "spell": "spell"}[kind])
+		}
+	}
+	// Spells that reach more than one target, or the caster, need no target.
+	if attack.Target != game.TargetSingle {
+		return s.castArea(roomsMap, cl, attack)
+	}
+	if targetName == "" {
+		return fmt.Sprintf("What do you want to %s at?\n", cmd)
+	}
+	if attack.Ammo != "" && !p.HasItem(attack.Ammo) {
+		return fmt.Sprintf("You have no %s left\n", attack.Ammo)
+	}
+	// Players in the room are hit at once, when they take part in fights.
+	if o, ok := s.playerHere(p, targetName); ok {
+		return s.attackPlayer(roomsMap, p, o, attack)
+	}
+
+	// Bad weather hides whatever is far away.
+	reach := attack.Range - game.VisibilityPenalty(s.weatherAt(p))
+	if reach < 0 {
+		reach = 0
+	}
+	npc, distance, ok := s.findRangedTarget(roomsMap, p, targetName, reach)
+	if !ok {
+		return fmt.Sprintf("You can't see %s anywhere in range\n", targetName)
+	}
+
+	return s.fire(roomsMap, p, npc, attack, distance)
+}
+
+// bestBow returns the best bow the player carries, which is what they shoot
+// with, or nothing when they carry none.
+func bestBow(p *area.Player) game.RangedAttack {
+	var bow game.RangedAttack
+	for _, a := range game.RangedAttacks {
+		if a.Kind == "bow" && p.HasItem(a.Name) && a.Die > bow.Die {
+			bow = a
+		}
+	}
+	return bow
+}
+
+// fire sends the attack of the player off at the NPC, the given number of
+// rooms away. It hits once it gets there.
+func (s *Server) fire(roomsMap map[string]map[string][][]area.Cube, p *area.Player, npc *area.NPC, attack game.RangedAttack, distance int) string {
+	if attack.Ammo != "" {
+		p.RemoveItem(attack.Ammo)
+	}
+	s.startGlobalCooldown(roomsMap, p)
+
+	attacker := p.Nickname
+	npcID := npc.ID
+	s.after(projectileDelay*time.Duration(distance+1), func() {
+		s.projectileHit(roomsMap, attacker, npcID, attack, distance)
+	})
+
+	b := Broadcast{Scope: ScopeRoom, Area: p.Area, Room: p.Room, From: p.Nickname, Text: attackVerbs[attack.Kind]}
+	return s.act(roomsMap, b, playerActor(p), npcActor(npc))
+}
+
+// attackPlayer resolves a ranged attack of the player on another player in
+// the same room, if the other consents to fights.
+func (s *Server) attackPlayer(roomsMap map[string]map[string][][]area.Cube, p, o *area.Player, attack game.RangedAttack) string {
+	if err := s.consent(p, o, actAttack); err != nil {
+		return err.Error() + "\n"
+	}
+	if attack.Ammo != "" {
+		p.RemoveItem(attack.Ammo)
+	}
+	s.startGlobalCooldown(roomsMap, p)
+	p.LastHostile = s.now()
+	s.hint(roomsMap, p, "combat")
+	s.hint(roomsMap, o, "combat")
+
+	b := Broadcast{Scope: ScopeRoom, Area: p.Area, Room: p.Room, From: p.Nickname, Kind: TagCombat}
+	// Worn gear protects less, and enhanced gear more.
+	target := o.PC
+	target.AC = s.armorClass(o)
+	hit, damage, fared := s.rollHit(p, attack, 0, &target, s.gearBonus(o).defenses)
+	if hit {
+		damage = game.ElementalDamage(s.weatherAt(p), attack.Element, damage)
+	}
+	if hit && fared == game.DamageImmune {
+		s.recordCombat(CombatEvent{Player: p.Nickname, Kind: combatDealt, Source: attack.Name})
+		b.Text = defenseText(fared, attack.Element, false)
+		return s.act(roomsMap, b, playerActor(p), playerActor(o))
+	}
+	if !hit || damage == 0 {
+		s.recordCombat(CombatEvent{Player: p.Nickname, Kind: combatDealt, Source: attack.Name})
+		b.Text = fmt.Sprintf("$p %s misses $N\n", strings.ToLower(attack.Name))
+		return s.act(roomsMap, b, playerActor(p), playerActor(o))
+	}
+
+	o.HP -= damage
+	wearGear(o, 1)
+	s.recordCombat(CombatEvent{Player: p.Nickname, Kind: combatDealt, Source: attack.Name, Amount: damage})
+	s.recordCombat(CombatEvent{Player: o.Nickname, Kind: combatTaken, Source: p.Nickname, Amount: damage})
+	b.Text = fmt.Sprintf("$p %s hits $N for %d\n", strings.ToLower(attack.Name), damage) + defenseText(fared, attack.Element, false)
+	msg := s.act(roomsMap, b, playerActor(p), playerActor(o))
+	if o.HP <= 0 {
+		s.killPlayer(roomsMap, o, p.Nickname)
+		if s.witnessed(p, 0) {
+			s.reportCrime(roomsMap, p, area.CrimeMurder, "")
+		}
+	} else if attack.Effect.Name != "" {
+		addEffect(o, castEffect(p, attack.Effect))
+	}
+	return msg
+}
+
+// rollHit rolls the attack of the player on the target: to hit its armor,
+// or for attacks the target saves against, the damage it takes either way.
+// What the gear of the player adds to hit and to damage counts for attacks
+// that roll to hit, and so does the stance of the player. The defenses of
+// the target against the type of the damage act last, and it returns how
+// the damage fared against them too.
+func (s *Server) rollHit(p *area.Player, attack game.RangedAttack, distance int, target *game.PC, defenses game.Defenses) (bool, int, string) {
+	hit, damage := true, 0
+	if attack.Save != "" {
+		damage, _ = game.SaveDamage(&p.PC, attack, target, s.rnd)
+	} else {
+		gear := s.gearBonus(p)
+		stance := game.Stance(p.Tactics.Stance)
+		attacker := p.PC
+		attacker.BAB += gear.hit + stance.Hit - s.loadPenalty(p)
+		if hit, damage = game.RangedRoll(&attacker, attack, distance, target.AC, s.rnd); hit {
+			damage += gear.damage + stance.Damage
+		}
+	}
+	if !hit {
+		return false, 0, game.DamageFull
+	}
+	damage, fared := defenses.Against(damage, attack.Element)
+	return true, damage, fared
+}
+
+// splitAttack splits the arguments into the name of a thrown weapon or spell and the target.
+func splitAttack(kind string, args []string) (game.RangedAttack, string, bool) {
+	for i := len(args); i > 0; i-- {
+		if attack, ok := game.FindRangedAttack(kind, strings.Join(args[:i], " ")); ok {
+			return attack, strings.Join(args[i:], " "), true
+		}
+	}
+	return game.RangedAttack{}, "", false
+}
+
+// findRangedTarget looks for a visible NPC with the given name in the room of the
+// player and in the rooms behind the open doors, up to the given range. It returns
+// the NPC and how many rooms away it is.
+func (s *Server) findRangedTarget(roomsMap map[string]map[string][][]area.Cube, p *area.Player, name string, reach int) (*area.NPC, int, bool) {
+	name = strings.ToLower(name)
+	visited := map[roomKey]bool{}
+	current := []roomKey{{p.Area, p.Room}}
+
+	for distance := 0; distance <= reach && len(current) > 0; distance++ {
+		next := []roomKey{}
+		for _, k := range current {
+			if visited[k] {
+				continue
+			}
+			visited[k] = true
+
+			if distance == 0 || !s.Areas[k.area].Rooms[k.room].Dark {
+				for _, npc := range s.npcsInRoom(k.area, k.room) {
+					if strings.HasPrefix(strings.ToLower(npc.Name), name) {
+						found, _ := s.findNPC(npc.ID)
+						return found, distance, true
+					}
+				}
+			}
+
+			mapArray := roomsMap[k.area][k.room]
+			for _, d := range area.Doors(mapArray) {
+				if door := mapArray[d[0]][d[1]]; !door.Closed {
+					next = append(next, roomKey{door.Exits[0].ToArea, door.Exits[0].ToRoom})
+				}
+			}
+		}
+		current = next
+	}
+	return nil, 0, false
+}
+
+// projectileHit resolves a ranged attack once the projectile reaches its target.
+func (s *Server) projectileHit(roomsMap map[string]map[string][][]area.Cube, attacker string, npcID int, attack game.RangedAttack, distance int) {
+	cl, online := s.clientByNick(attacker)
+	npc, areaName := s.findNPC(npcID)
+	if npc == nil {
+		if online {
+			s.godPrintRoom([]Client{*cl}, roomsMap, tagged(TagCombat, fmt.Sprintf("Your %s misses its mark\n", attack.Name)), "")
+		}
+		return
+	}
+	if !online {
+		return
+	}
+
+	hit, damage, fared := s.rollHit(cl.Player, attack, distance, &npc.PC, npc.Defenses)
+	if !hit {
+		s.recordCombat(CombatEvent{Player: attacker, Kind: combatDealt, Source: attack.Name})
+		b := Broadcast{Scope: ScopeRoom, Area: areaName, Room: npc.Room, Kind: TagCombat, Text: fmt.Sprintf("$p %s misses $N\n", strings.ToLower(attack.Name))}
+		s.act(roomsMap, b, playerActor(cl.Player), npcActor(npc))
+		s.provoke(roomsMap, npc, attacker)
+		return
+	}
+
+	if fared == game.DamageImmune {
+		s.recordCombat(CombatEvent{Player: attacker, Kind: combatDealt, Source: attack.Name})
+		b := Broadcast{Scope: ScopeRoom, Area: areaName, Room: npc.Room, Kind: TagCombat, Text: defenseText(fared, attack.Element, true)}
+		s.act(roomsMap, b, playerActor(cl.Player), npcActor(npc))
+		s.provoke(roomsMap, npc, attacker)
+		return
+	}
+	if damage = game.ElementalDamage(s.weatherAt(cl.Player), attack.Element, damage); damage == 0 {
+		s.godPrintRoom([]Client{*cl}, roomsMap, tagged(TagCombat, fmt.Sprintf("The storm puts out your %s\n", attack.Name)), "")
+		return
+	}
+
+	npc.HP -= damage
+	s.recordCombat(CombatEvent{Player: attacker, Kind: combatDealt, Source: attack.Name, Amount: damage})
+	s.addThreat(npc, attacker, game.Threat(&cl.Player.PC, damage))
+	if npc.HP <= 0 {
+		s.killNPC(roomsMap, cl, npcID)
+		return
+	}
+	if attack.Effect.Name != "" {
+		addNPCEffect(npc, cl.Player, attack.Effect)
+	}
+
+	b := Broadcast{Scope: ScopeRoom, Area: areaName, Room: npc.Room, Kind: TagCombat, Text: fmt.Sprintf("$p %s hits $N for %d\n", strings.ToLower(attack.Name), damage) + defenseText(fared, attack.Element, true)}
+	s.act(roomsMap, b, playerActor(cl.Player), npcActor(npc))
+	s.provoke(roomsMap, npc, attacker)
+}
+
+// provoke puts the attacker on the mind of the NPC, and makes it go after
+// them unless it is after someone already. Whoever causes the most threat
+// gets its attention from then on.
+func (s *Server) provoke(roomsMap map[string]map[string][][]area.Cube, npc *area.NPC, attacker string) {
+	s.addThreat(npc, attacker, 0)
+	if cl, ok := s.clientByNick(attacker); ok {
+		s.hint(roomsMap, cl.Player, "combat")
+	}
+	if npc.Target == "" {
+		npc.Target = attacker
+	}
+	if s.pursuits[npc.ID] {
+		return
+	}
+	s.pursuits[npc.ID] = true
+	if _, ok := s.leashes[npc.ID]; !ok {
+		s.leashes[npc.ID] = npc.Location
+	}
+	id := npc.ID
+	s.after(s.npcDelay(), func() {
+		s.npcPursue(roomsMap, id)
+	})
+}
+
+// npcPursue moves an NPC one room closer to its target, or attacks it when they
+// are in the same room. The NPC loses interest in targets who get too far, and
+// goes back once it has nobody left to fight or was dragged too far.
+func (s *Server) npcPursue(roomsMap map[string]map[string][][]area.Cube, id int) {
+	npc, areaName := s.findNPC(id)
+	if npc == nil {
+		delete(s.pursuits, id)
+		delete(s.threat, id)
+		delete(s.leashes, id)
+		return
+	}
+
+	cl, ok := s.chooseTarget(roomsMap, npc, areaName)
+	if !ok {
+		s.resetNPC(roomsMap, npc, areaName)
+		return
+	}
+
+	p := cl.Player
+	if p.Room == npc.Room && s.arrests(*npc, p) {
+		delete(s.pursuits, id)
+		delete(s.leashes, id)
+		s.arrest(roomsMap, npc, p)
+		return
+	}
+	if p.Room == npc.Room {
+		p = s.fightRound(roomsMap, npc, p)
+		// Nobody rests through an attack.
+		p.Resting = game.Awake
+		damage, fared := game.NPCAttack(npc.Level, s.armorClass(p), s.rnd), game.DamageFull
+		b := Broadcast{Scope: ScopeRoom, Area: areaName, Room: npc.Room, Kind: TagCombat, Text: "$n {miss|misses} $N\n"}
+		shield, holds := s.gearOf(p, area.ItemShield)
+		switch {
+		case damage > 0 && holds && game.Blocks(shield.Block, s.rnd):
+			damage = 0
+			b.Text = "$p blow glances off $P shield\n"
+		case damage > 0:
+			damage, fared = s.gearBonus(p).defenses.Against(damage, blowsOf(npc))
+			b.Text = defenseText(fared, blowsOf(npc), false)
+		}
+		s.recordCombat(CombatEvent{Player: p.Nickname, Kind: combatTaken, Source: npc.Name, Amount: damage})
+		if damage > 0 {
+			p.HP -= damage
+			wearGear(p, 1)
+			b.Text = fmt.Sprintf("$n {hit|hits} $N for %d\n", damage) + b.Text
+		}
+		s.act(roomsMap, b, npcActor(npc), playerActor(p))
+		if p.HP <= 0 {
+			s.killPlayer(roomsMap, p, npc.Name)
+		} else {
+			// Players hit back at whatever attacks them.
+			if p.Swinging == 0 {
+				s.startSwinging(roomsMap, p, npc)
+			}
+			s.wimpy(roomsMap, p)
+		}
+	} else {
+		path, ok := roomPath(roomsMap, areaName, npc.Room, areaName, p.Room)
+		if !ok || len(path) > s.giveUp(npc) {
+			s.forgetThreat(id, p.Nickname)
+		} else {
+			from := npc.Room
+			npc.Room = path[0].ToRoom
+			npc.Position = path[0].ToCubeID
+			if s.ambient() {
+				s.printToRoom(roomsMap, areaName, from, fmt.Sprintf("%s rushes out of the room\n", npc.Name))
+				s.printToRoom(roomsMap, areaName, npc.Room, fmt.Sprintf("%s rushes in\n", npc.Name))
+			}
+			if s.leashed(roomsMap, npc, areaName) {
+				s.resetNPC(roomsMap, npc, areaName)
+				return
+			}
+		}
+	}
+
+	s.after(s.npcDelay(), func() {
+		s.npcPursue(roomsMap, id)
+	})
+}
+
+// giveUp returns how many rooms away the NPC stops chasing its target.
+func (s *Server) giveUp(npc *area.NPC) int {
+	switch {
+	case npc.Pursuit > 0:
+		return npc.Pursuit
+	case s.config.Combat.GiveUp > 0:
+		return s.config.Combat.GiveUp
+	}
+	return npcGiveUp
+}
+
+// inCombat reports whether an NPC is after the player.
+func (s *Server) inCombat(p *area.Player) bool {
+	return s.opponent(p) != nil
+}
+
+// opponent returns an NPC that is after the player, or nil when there is none.
+func (s *Server) opponent(p *area.Player) *area.NPC {
+	for id := range s.pursuits {
+		if npc, _ := s.findNPC(id); npc != nil && npc.Target == p.Nickname {
+			return npc
+		}
+	}
+	return nil
+}
+
+// killNPC removes a dead NPC from the world and hands its loot to the killer.
+func (s *Server) killNPC(roomsMap map[string]map[string][][]area.Cube, killer *Client, id int) {
+	npc, areaName := s.findNPC(id)
+	if npc == nil {
+		return
+	}
+	dead := *npc
+
+	a := s.Areas[areaName]
+	for i := range a.NPCs {
+		if a.NPCs[i].ID == id {
+			a.NPCs = append(a.NPCs[:i], a.NPCs[i+1:]...)
+			break
+		}
+	}
+	s.Areas[areaName] = a
+	delete(s.pursuits, id)
+	delete(s.threat, id)
+	delete(s.leashes, id)
+
+	s.printToRoom(roomsMap, areaName, dead.Room, tagged(TagCombat, fmt.Sprintf("%s dies\n", dead.Name)))
+	s.reputationForKill(killer.Player, dead)
+	// Killing those the law protects is murder, if anyone sees it.
+	if law := s.Areas[areaName].Law; law.Faction != "" && dead.Faction == law.Faction && s.witnessed(killer.Player, dead.ID) {
+		s.reportCrime(roomsMap, killer.Player, area.CrimeMurder, "")
+	}
+	s.publish(WorldEvent{Type: EventNPCKilled, Player: killer.Player.Nickname, NPC: id, Area: areaName, Room: dead.Room, By: killer.Player.Nickname})
+	if dead.Boss {
+		s.publish(WorldEvent{Type: EventBossKilled, Player: killer.Player.Nickname, NPC: id, Area: areaName, Room: dead.Room, By: killer.Player.Nickname, Detail: dead.Name})
+	}
+
+	ctx := lootContext(killer.Player, dead.Level)
+	ctx.Luck = dead.Luck
+	drops, gold := game.RollLoot(s.LootTables, dead.Loot, ctx, s.rnd)
+	before := s.encumbrance(killer.Player)
+	msg := tagged(TagCombat, fmt.Sprintf("You killed %s\n", dead.Name))
+	msg += s.leaveRemains(roomsMap, killer.Player, Remains{Location: area.Location{Area: areaName, Room: dead.Room, Position: dead.Position}, Name: dead.Name, Gold: gold}, drops)
+	msg += s.loadNote(killer.Player, before)
+	msg += tagged(TagSummary, s.fightSummary(killer.Player.Nickname))
+	s.godPrintRoom([]Client{*killer}, roomsMap, msg, "")
+	xp := s.killXP(killer.Player, dead.Level)
+	s.gainXP(roomsMap, killer.Player, xp+s.restedBonus(killer.Player, xp))
+	s.hint(roomsMap, killer.Player, "kill "+dead.Name)
+}
+
+// gainXP gives the player the experience, and the levels it is enough for.
+// Going up a level heals them and earns them practices to spend at trainers.
+func (s *Server) gainXP(roomsMap map[string]map[string][][]area.Cube, p *area.Player, xp int) {
+	was := p.Level
+	p.Level, p.XP = game.LevelUp(p.Level, p.XP+xp)
+	if p.Level == was {
+		return
+	}
+	p.HP = game.MaxHP(&p.PC)
+	practices := (p.Level - was) * game.LevelPractices(&p.PC)
+	p.Practices += practices
+	s.tellPlayer(roomsMap, p.Nickname, tagged(TagSystem, fmt.Sprintf("You reach level %d and earn %d practices\n", p.Level, practices)))
+	s.publish(WorldEvent{Type: EventPlayerLeveled, Player: p.Nickname, Area: p.Area, Room: p.Room})
+	s.hint(roomsMap, p, "level")
+}
+
+// findNPC returns the NPC with the given ID along with the name of its area.
+func (s *Server) findNPC(id int) (*area.NPC, string) {
+	for name, a := range s.Areas {
+		for i := range a.NPCs {
+			if a.NPCs[i].ID == id {
+				return &a.NPCs[i], name
+			}
+		}
+	}
+	return nil, ""
+}
+
+// prepareNPCs gives the NPCs of the area their IDs and fills in their default stats.
+func (s *Server) prepareNPCs(a *area.Area) {
+	seen := map[string]int{}
+	for i := range a.NPCs {
+		npc := &a.NPCs[i]
+		seen[npc.Name]++
+		npc.Area = a.Name
+		if npc.ID == 0 {
+			s.lastNPCID++
+			npc.ID = s.lastNPCID
+		}
+		if npc.UID == 0 {
+			npc.UID = s.uid(area.KindNPC, npcName(a.Name, npc.Name, seen[npc.Name]))
+		}
+		if npc.HP == 0 {
+			npc.HP = game.NPCHitPoints(npc.Level)
+		}
+		if npc.MaxHP == 0 {
+			npc.MaxHP = npc.HP
+		}
+		if npc.AC == 0 {
+			npc.AC = game.NPCArmorClass(npc.Level)
+		}
+		if npc.Faction != "" && npc.Reputation == 0 {
+			npc.Reputation = game.KillReputation(npc.Level)
+		}
+	}
+}
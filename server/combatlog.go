@@ -0,0 +1,204 @@
+package server
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/droslean/thyranew/area"
+)
+
+// How players want the combat output. Verbose players get every blow among
+// their messages, terse players only get the summary of the fight, and the
+// pane puts the blows in a pane of their own.
+const (
+	combatVerbose = "verbose"
+	combatTerse   = "terse"
+	combatPane    = "pane"
+)
+
+const (
+	// fightTimeout is how long a fight lasts without a blow before it is over.
+	fightTimeout = 30 * time.Second
+	// combatLogLines is how many lines of combat each player keeps, enough
+	// to scroll the combat pane back through a fight.
+	combatLogLines = 10 * maxMessageLines
+)
+
+// Kinds of combat events.
+const (
+	combatDealt  = "dealt"
+	combatTaken  = "taken"
+	combatHealed = "healed"
+)
+
+// CombatEvent is a blow, a miss or a heal in a fight, as it counts for the player.
+type CombatEvent struct {
+	Player string
+	Kind   string
+	Source string // The attack that dealt the damage, the NPC that took it or what healed
+	Amount int
+}
+
+// Fight adds up the combat events of a player, from the first blow until no
+// NPC is after the player any more.
+type Fight struct {
+	Start  time.Time
+	Last   time.Time
+	Dealt  map[string]int
+	Taken  map[string]int
+	Healed map[string]int
+}
+
+// recordCombat adds the event to the fight of the player. Blows start a fight,
+// heals only count during one.
+func (s *Server) recordCombat(ev CombatEvent) {
+	f, ok := s.fights[ev.Player]
+	if !ok {
+		if ev.Kind == combatHealed {
+			return
+		}
+		f = &Fight{Start: time.Now(), Dealt: map[string]int{}, Taken: map[string]int{}, Healed: map[string]int{}}
+		s.fights[ev.Player] = f
+	}
+	f.Last = time.Now()
+	switch ev.Kind {
+	case combatDealt:
+		f.Dealt[ev.Source] += ev.Amount
+	case combatTaken:
+		f.Taken[ev.Source] += ev.Amount
+	case combatHealed:
+		f.Healed[ev.Source] += ev.Amount
+	}
+}
+
+// endFight sends the summary of the fight to the player, once no NPC is after
+// the player any more.
+func (s *Server) endFight(roomsMap map[string]map[string][][]area.Cube, nick string) {
+	summary := s.fightSummary(nick)
+	if cl, ok := s.clientByNick(nick); ok && summary != "" {
+		s.godPrintRoom([]Client{*cl}, roomsMap, tagged(TagSummary, summary), "")
+	}
+}
+
+// fightSummary ends the fight of the player and returns its summary. Fights
+// go on while an NPC is after a living player.
+func (s *Server) fightSummary(nick string) string {
+	f, ok := s.fights[nick]
+	if !ok {
+		return ""
+	}
+	if cl, online := s.clientByNick(nick); online && s.inCombat(cl.Player) && !cl.Player.Ghost {
+		return ""
+	}
+	delete(s.fights, nick)
+	return f.Summary()
+}
+
+// endStaleFights ends the fights nobody struck a blow in for a while.
+func (s *Server) endStaleFights(roomsMap map[string]map[string][][]area.Cube) {
+	for nick, f := range s.fights {
+		if time.Since(f.Last) > fightTimeout {
+			s.endFight(roomsMap, nick)
+		}
+	}
+}
+
+// Summary breaks down the damage of the fight by source.
+func (f *Fight) Summary() string {
+	duration := f.Last.Sub(f.Start).Round(time.Second)
+	msg := fmt.Sprintf("The fight is over after %s", duration)
+	if len(f.Healed) > 0 {
+		msg += fmt.Sprintf(", healed %s", breakdown(f.Healed))
+	}
+	msg += fmt.Sprintf("\nDealt %s\n", breakdown(f.Dealt))
+	msg += fmt.Sprintf("Taken %s\n", breakdown(f.Taken))
+	return msg
+}
+
+// breakdown lists the amounts by source, the largest first, after their total.
+func breakdown(amounts map[string]int) string {
+	sources := []string{}
+	total := 0
+	for source, amount := range amounts {
+		sources = append(sources, source)
+		total += amount
+	}
+	if total == 0 {
+		return "0"
+	}
+	sort.Slice(sources, func(i, j int) bool {
+		if amounts[sources[i]] != amounts[sources[j]] {
+			return amounts[sources[i]] > amounts[sources[j]]
+		}
+		return sources[i] < sources[j]
+	})
+	parts := []string{}
+	for _, source := range sources {
+		if amounts[source] > 0 {
+			parts = append(parts, fmt.Sprintf("%s %d", source, amounts[source]))
+		}
+	}
+	return fmt.Sprintf("%d: %s", total, strings.Join(parts, ", "))
+}
+
+// combatOutput keeps the combat the player sees in the combat log, and returns
+// what goes among the messages of the player.
+func (s *Server) combatOutput(p *area.Player, pieces []TaggedText) string {
+	for _, piece := range pieces {
+		if piece.Kind != TagCombat {
+			continue
+		}
+		lines := append(s.combatLogs[p.Nickname], strings.Split(strings.TrimRight(piece.Text, "\n"), "\n")...)
+		if len(lines) > combatLogLines {
+			lines = lines[len(lines)-combatLogLines:]
+		}
+		s.combatLogs[p.Nickname] = lines
+	}
+	if p.CombatLog == combatTerse || p.CombatLog == combatPane {
+		return plainText(pieces, TagCombat)
+	}
+	return plainText(pieces, "")
+}
+
+// combatPane returns what goes in the combat pane of the player.
+func (s *Server) combatPane(p *area.Player) string {
+	if p.CombatLog != combatPane || len(s.combatLogs[p.Nickname]) == 0 {
+		return ""
+	}
+	off := 0
+	if f, ok := s.frames[p.Nickname]; ok {
+		off = f.scroll[paneCombat]
+	}
+	return paneWindow(s.combatLogs[p.Nickname], off)
+}
+
+// combatLog shows or changes where the combat output of the player goes.
+// Usage: combatlog [verbose|terse|pane]
+func (s *Server) combatLog(p *area.Player, args []string) string {
+	if len(args) == 0 {
+		mode := p.CombatLog
+		if mode == "" {
+			mode = combatVerbose
+		}
+		msg := fmt.Sprintf("Combat output is %s\n", mode)
+		if lines := s.combatLogs[p.Nickname]; len(lines) > 0 && mode != combatPane {
+			// The log takes the rest of the message lines.
+			if len(lines) > maxMessageLines-1 {
+				lines = lines[len(lines)-maxMessageLines+1:]
+			}
+			msg += strings.Join(lines, "\n") + "\n"
+		}
+		return msg
+	}
+	switch mode := strings.ToLower(args[0]); mode {
+	case combatVerbose, combatTerse, combatPane:
+		p.CombatLog = mode
+		if mode == combatVerbose {
+			p.CombatLog = ""
+		}
+		return fmt.Sprintf("Combat output is %s\n", mode)
+	}
+	return "Usage: combatlog [verbose|terse|pane]\n"
+}
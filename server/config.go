@@ -0,0 +1,133 @@
+package server
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/droslean/thyranew/area"
+	"github.com/gothyra/toml"
+
+	log "gopkg.in/inconshreveable/log15.v2"
+)
+
+// Config holds the settings of the server, read from server.toml.
+type Config struct {
+	Host string `toml:"host"`
+	Port int    `toml:"port"`
+	// Survival turns on hunger, thirst and resting. Servers meant for plain
+	// hack and slash can leave it off.
+	Survival bool `toml:"survival"`
+	// XPLoss is the percentage of experience lost on death.
+	XPLoss int `toml:"xploss"`
+	// ItemLoss tells what happens to the items of the dead: "corpse" leaves
+	// them on the corpse, "keep" lets the ghost keep them and "destroy" loses them.
+	ItemLoss string `toml:"itemloss"`
+	// RecordQuota is how many kilobytes of session recordings each player keeps.
+	RecordQuota int `toml:"recordquota"`
+	// FrameRate is how many times a second the screen of a player is redrawn
+	// at most. Output that comes in between is drawn with the next frame.
+	FrameRate int `toml:"framerate"`
+	// MaxPlayers is how many players can be online at once.
+	MaxPlayers int `toml:"maxplayers"`
+	// Debug is the address of the HTTP listener with pprof and the stats of
+	// the server. It is off when empty, and shouldn't be reachable by players.
+	Debug string `toml:"debug"`
+	// AdminToken is what the callers of the admin API on the debug listener
+	// send as a bearer token. Without one the API is open to whoever reaches
+	// the listener, and the world can't be edited through it.
+	AdminToken string `toml:"admintoken"`
+	// Review keeps the edits builders make in the game and through the admin
+	// API out of the world until an admin publishes them.
+	Review bool `toml:"review"`
+	// Health is the address of the HTTP listener with the probes of
+	// orchestrators, /healthz and /readyz. It is off when empty.
+	Health string `toml:"health"`
+	// CharacterKey signs the characters the server exports, and those it
+	// imports must be signed with it. The secret of the cluster is used
+	// unless set, or else a key the server keeps in its database.
+	CharacterKey string `toml:"characterkey"`
+	// Web is the address of the HTTP listener with the public web pages: the
+	// homepage of the server, the profiles of the players and the feeds of
+	// the news. It is off when empty.
+	Web string `toml:"web"`
+	// OAuth lets players link their characters to their accounts at outside
+	// providers, through the web pages.
+	OAuth OAuthConfig `toml:"oauth"`
+	// Cluster is the other servers this one shares players, tells and
+	// channels with.
+	Cluster ClusterConfig `toml:"cluster"`
+	// Intermud is the chat network of other MUDs the players can talk to
+	// on some of the channels.
+	Intermud IntermudConfig `toml:"intermud"`
+	// Shards is how many goroutines the areas of the world are shared out
+	// between. It is one for each CPU unless set.
+	Shards int `toml:"shards"`
+	// Jail is the cube jailed players are sent to. It is the cell of the Jail
+	// area unless set.
+	Jail area.Exit `toml:"jail"`
+	// ChatLog says what is logged of the chat and the commands.
+	ChatLog ChatLogConfig `toml:"chatlog"`
+	// Privacy says how players delete their accounts, and how long the audit
+	// log and where players logged in from are kept.
+	Privacy PrivacyConfig `toml:"privacy"`
+	// Maintenance says what players are told while the server is down for
+	// maintenance.
+	Maintenance MaintenanceConfig `toml:"maintenance"`
+	// Crash says where the bundles of crashes go, and who is told of them.
+	Crash CrashConfig `toml:"crash"`
+	// Timezone is the time zone of the server, an IANA name like
+	// "Europe/Athens", in which the calendar, the content flags and the jobs
+	// keep time. It is the local time of the machine unless set.
+	Timezone string `toml:"timezone"`
+	// Jobs are what the server does on a schedule, like warning of the daily
+	// reboot or backing up. Once admins change them in the game, the jobs
+	// of jobs.toml in the data directory stand in for these.
+	Jobs []Job `toml:"jobs"`
+	// MaxPerKey and MaxPerIP are how many characters may play at once with
+	// the same key, or from the same address, with zero for no limit. Admins
+	// are let in regardless, and so are the keys and addresses in SharedAlts,
+	// like those of a family or a school.
+	MaxPerKey  int      `toml:"maxperkey"`
+	MaxPerIP   int      `toml:"maxperip"`
+	SharedAlts []string `toml:"sharedalts"`
+	// PvP is what players may do to each other: "off", the default, keeps
+	// them from fighting and stealing from each other, "flagged" lets those
+	// who turned their pk flag on, and "open" lets everyone.
+	PvP string `toml:"pvp"`
+	// Economy tunes the prices, and what gold leaves the world through.
+	Economy EconomyConfig `toml:"economy"`
+	// Levels protects new players, keeps players to the areas of their level
+	// and scales the experience of kills by it.
+	Levels LevelConfig `toml:"levels"`
+	// Rested is the experience players earn while logged out.
+	Rested RestedConfig `toml:"rested"`
+	// Enchant is how risky enchanting items is, and what smiths charge for it.
+	Enchant EnchantConfig `toml:"enchant"`
+	// Combat is how hard it is for players to get out of fights.
+	Combat CombatConfig `toml:"combat"`
+}
+
+// loadConfig loads the settings of the server from the static directory.
+// A missing file leaves the defaults in place.
+func (s *Server) loadConfig() error {
+	path := s.staticDir + "/server.toml"
+	fileContent, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		log.Info(fmt.Sprintf("%s could not be loaded: %v", path, err))
+		return err
+	}
+
+	config := struct {
+		Config Config `toml:"config"`
+	}{}
+	if _, err := toml.Decode(string(fileContent), &config); err != nil {
+		log.Info(fmt.Sprintf("%s could not be unmarshaled: %v", path, err))
+		return err
+	}
+	s.config = config.Config
+	return nil
+}
@@ -0,0 +1,95 @@
+package server
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/droslean/thyranew/area"
+)
+
+// Priorities of output, for when a frame gathers more than the messages pane
+// shows.
+const (
+	// priorityCritical is what the player can't miss: the replies to their
+	// commands, what is said to them alone, like tells and prompts, what the
+	// server tells everyone, and the fight they are in.
+	priorityCritical = iota
+	// priorityChat is what is said around the player, and on their channels.
+	priorityChat
+	// priorityAmbient is what goes on around the player, like others moving
+	// and others' fights.
+	priorityAmbient
+)
+
+// priorityOf returns how much the piece of output matters to the player.
+func (s *Server) priorityOf(p *area.Player, piece TaggedText) int {
+	switch {
+	case !piece.Around:
+		return priorityCritical
+	case piece.Kind == TagSystem || piece.Kind == TagSummary || piece.Kind == TagHealing:
+		return priorityCritical
+	case piece.Kind == TagCombat && s.inCombat(p):
+		return priorityCritical
+	case piece.Kind == TagChat:
+		return priorityChat
+	}
+	return priorityAmbient
+}
+
+// outputLines returns how many lines of the messages pane the piece takes.
+func outputLines(piece TaggedText) int {
+	return strings.Count(strings.TrimRight(piece.Text, "\n"), "\n") + 1
+}
+
+// prioritize returns the output gathered for a frame of the player. When it
+// is more than the messages pane shows, what matters most is kept rather
+// than the latest, and the lines of chat and then of what goes on around
+// the player that don't fit are dropped, the oldest first, with a note of
+// how many were.
+func (s *Server) prioritize(p *area.Player, pieces []TaggedText) []TaggedText {
+	total := 0
+	for _, piece := range pieces {
+		total += outputLines(piece)
+	}
+	if total <= maxMessageLines {
+		return pieces
+	}
+
+	keep := make([]bool, len(pieces))
+	budget := maxMessageLines - 1 // The note of what was dropped takes a line
+	for i, piece := range pieces {
+		if s.priorityOf(p, piece) == priorityCritical {
+			keep[i] = true
+			budget -= outputLines(piece)
+		}
+	}
+	for _, priority := range []int{priorityChat, priorityAmbient} {
+		for i := len(pieces) - 1; i >= 0; i-- {
+			if keep[i] || s.priorityOf(p, pieces[i]) != priority {
+				continue
+			}
+			if n := outputLines(pieces[i]); n <= budget {
+				keep[i] = true
+				budget -= n
+			}
+		}
+	}
+
+	kept := []TaggedText{}
+	dropped := 0
+	for i, piece := range pieces {
+		if keep[i] {
+			kept = append(kept, piece)
+		} else {
+			dropped += outputLines(piece)
+		}
+	}
+	if dropped == 0 {
+		return pieces
+	}
+	note := "...1 message suppressed\n"
+	if dropped > 1 {
+		note = fmt.Sprintf("...%d messages suppressed\n", dropped)
+	}
+	return append([]TaggedText{{Kind: TagMessage, Text: note}}, kept...)
+}
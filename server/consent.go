@@ -0,0 +1,182 @@
+package server
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/droslean/thyranew/area"
+)
+
+// The PvP rulesets of the server.
+const (
+	pvpOff     = "off"
+	pvpFlagged = "flagged"
+	pvpOpen    = "open"
+)
+
+// The acts one player does to another, that need the consent of the other.
+const (
+	actAttack = "attack"
+	actSteal  = "steal from"
+	actFollow = "follow"
+)
+
+// pkLock is how long after attacking or robbing a player the pk flag stays
+// on, so nobody strikes and then hides behind the flag.
+const pkLock = 5 * time.Minute
+
+// consent tells whether the actor may do the act to the target, and why not
+// if they may not. Every act of a player against another asks here first.
+func (s *Server) consent(actor, target *area.Player, act string) error {
+	if actor.Nickname == target.Nickname {
+		return fmt.Errorf("You can't %s yourself", act)
+	}
+	if target.Flags.AFK {
+		return fmt.Errorf("%s is away from the keyboard", target.Nickname)
+	}
+	if act == actFollow {
+		if target.Flags.Busy {
+			return fmt.Errorf("%s is busy and wants no company", target.Nickname)
+		}
+		// Following around in circles would take the players nowhere.
+		seen := map[string]bool{}
+		for leader := target.Following; leader != "" && !seen[leader]; {
+			if leader == actor.Nickname {
+				return fmt.Errorf("%s is following you already", target.Nickname)
+			}
+			seen[leader] = true
+			cl, ok := s.clientByNick(leader)
+			if !ok {
+				break
+			}
+			leader = cl.Player.Following
+		}
+		return nil
+	}
+	if target.Ghost {
+		return fmt.Errorf("%s is a ghost", target.Nickname)
+	}
+	if newbie := s.config.Levels.Newbie; newbie > 0 {
+		if actor.Level < newbie {
+			return fmt.Errorf("New players can't %s other players until level %d", act, newbie)
+		}
+		if target.Level < newbie {
+			return fmt.Errorf("%s is new here, and protected until level %d", target.Nickname, newbie)
+		}
+	}
+	switch s.pvpRule(actor) {
+	case pvpOpen:
+		return nil
+	case pvpFlagged:
+		if !actor.Flags.PK {
+			return fmt.Errorf("Turn your pk flag on to %s other players", act)
+		}
+		if !target.Flags.PK {
+			return fmt.Errorf("%s doesn't take part in fights between players", target.Nickname)
+		}
+		return nil
+	}
+	return fmt.Errorf("Players can't %s each other here", act)
+}
+
+// pvpRule returns the PvP ruleset where the player is. Arenas are there to
+// fight in, so the flagged may fight in them even when PvP is off, unless an
+// admin turned it off outright.
+func (s *Server) pvpRule(p *area.Player) string {
+	if !s.featureOn(featurePvP) {
+		return pvpOff
+	}
+	switch rule := s.config.PvP; rule {
+	case pvpFlagged, pvpOpen:
+		return rule
+	}
+	if s.Areas[p.Area].Arena {
+		return pvpFlagged
+	}
+	return pvpOff
+}
+
+// flagNames are the flags of the players, as the flag command calls them.
+var flagNames = []string{"pk", "roleplay", "afk", "busy", "private"}
+
+// flagOf returns the flag of the player by name.
+func flagOf(p *area.Player, name string) (*bool, bool) {
+	switch name {
+	case "pk":
+		return &p.Flags.PK, true
+	case "roleplay":
+		return &p.Flags.Roleplay, true
+	case "afk":
+		return &p.Flags.AFK, true
+	case "busy":
+		return &p.Flags.Busy, true
+	case "private":
+		return &p.Flags.Private, true
+	}
+	return nil, false
+}
+
+// flag shows the flags of the player, or turns one of them on or off. A flag
+// without on or off is toggled.
+// Usage: flag [pk|roleplay|afk|busy|private] [on|off]
+func (s *Server) flag(p *area.Player, args []string) string {
+	if len(args) == 0 {
+		lines := []string{}
+		for _, name := range flagNames {
+			f, _ := flagOf(p, name)
+			lines = append(lines, fmt.Sprintf("%-9s %s", name, onOffOf(*f)))
+		}
+		return fmt.Sprintf("PvP here is %s\n%s\n", s.pvpRule(p), strings.Join(lines, "\n"))
+	}
+	f, ok := flagOf(p, strings.ToLower(args[0]))
+	if !ok || len(args) > 2 {
+		return "Usage: flag [pk|roleplay|afk|busy|private] [on|off]\n"
+	}
+	on := !*f
+	if len(args) == 2 {
+		switch strings.ToLower(args[1]) {
+		case "on":
+			on = true
+		case "off":
+			on = false
+		default:
+			return "Usage: flag [pk|roleplay|afk|busy|private] [on|off]\n"
+		}
+	}
+	if f == &p.Flags.PK && !on {
+		if left := pkLock - s.now().Sub(p.LastHostile); left > 0 {
+			return fmt.Sprintf("Your pk flag stays on for %s after a fight\n", left.Round(time.Second))
+		}
+	}
+	*f = on
+	return s.savePreferences(p, fmt.Sprintf("Your %s flag is %s\n", strings.ToLower(args[0]), onOffOf(on)))
+}
+
+// flagTags returns what others see of the flags of the player.
+func flagTags(p *area.Player) []string {
+	tags := []string{}
+	if p.Flags.PK {
+		tags = append(tags, "PK")
+	}
+	if p.Flags.Roleplay {
+		tags = append(tags, "roleplaying")
+	}
+	if p.Flags.AFK {
+		tags = append(tags, "AFK")
+	}
+	if p.Flags.Busy {
+		tags = append(tags, "busy")
+	}
+	return tags
+}
+
+// backAtKeyboard takes the afk flag off a player who does something. It
+// returns what to tell the player, if anything.
+func backAtKeyboard(p *area.Player, cmd string) string {
+	if !p.Flags.AFK || cmd == "" || cmd == "flag" {
+		return ""
+	}
+	p.Flags.AFK = false
+	return "You are back at the keyboard\n"
+}
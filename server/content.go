@@ -0,0 +1,178 @@
+package server
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/droslean/thyranew/area"
+	"github.com/gothyra/toml"
+
+	log "gopkg.in/inconshreveable/log15.v2"
+)
+
+// loadContentFlags loads the content flags from the static directory into
+// memory.
+func (s *Server) loadContentFlags() error {
+	path := s.staticDir + "/content.toml"
+	fileContent, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		log.Info(fmt.Sprintf("%s could not be loaded: %v", path, err))
+		return err
+	}
+
+	content := struct {
+		Flags []area.ContentFlag `toml:"flags"`
+	}{}
+	if _, err := toml.Decode(string(fileContent), &content); err != nil {
+		log.Info(fmt.Sprintf("%s could not be unmarshaled: %v", path, err))
+		return err
+	}
+
+	for _, f := range content.Flags {
+		log.Info(fmt.Sprintf("Loaded content flag %q", f.Name))
+	}
+	s.contentFlags = content.Flags
+	return nil
+}
+
+// contentOn reports whether the content tagged with the flag is there. Content
+// without a flag always is, and content with an unknown one never.
+func (s *Server) contentOn(flag string) bool {
+	return flag == "" || s.flagsOn[flag]
+}
+
+// roomOpen reports whether players can go into the room.
+func (s *Server) roomOpen(areaName, room string) bool {
+	return s.contentOn(s.Areas[areaName].Rooms[room].Flag)
+}
+
+// checkContent turns the content flags on and off as their time comes and
+// goes, unless an admin set them. It runs on every tick, and the first time
+// it sets every flag.
+func (s *Server) checkContent(roomsMap map[string]map[string][][]area.Cube) {
+	now, season := s.localNow(), s.worldTime().Season()
+	for _, f := range s.contentFlags {
+		on, forced := s.flagOverrides[f.Name]
+		if !forced {
+			on = f.OnAt(now, season)
+		}
+		if was, set := s.flagsOn[f.Name]; !set || on != was {
+			s.setContent(roomsMap, f.Name, on)
+		}
+	}
+}
+
+// setContent brings the content tagged with the flag into the world, or
+// takes it out. NPCs taken out wait for the flag to come back on, and players
+// in rooms that close are sent to their bind.
+func (s *Server) setContent(roomsMap map[string]map[string][][]area.Cube, flag string, on bool) {
+	log.Info(fmt.Sprintf("Content flag %q is %s", flag, onOffOf(on)))
+	s.flagsOn[flag] = on
+
+	for name, a := range s.Areas {
+		npcs := []area.NPC{}
+		dormant := []area.NPC{}
+		for _, npc := range s.dormant[name] {
+			if npc.Flag == flag && on {
+				npc.HP, npc.Target = npc.MaxHP, ""
+				npcs = append(npcs, npc)
+				continue
+			}
+			dormant = append(dormant, npc)
+		}
+		for _, npc := range a.NPCs {
+			if npc.Flag == flag && !on {
+				delete(s.pursuits, npc.ID)
+				delete(s.threat, npc.ID)
+				delete(s.leashes, npc.ID)
+				dormant = append(dormant, npc)
+				continue
+			}
+			npcs = append(npcs, npc)
+		}
+		a.NPCs = npcs
+		s.Areas[name] = a
+		s.dormant[name] = dormant
+	}
+
+	if on {
+		return
+	}
+	for _, c := range s.OnlineClients() {
+		p := c.Player
+		if s.roomOpen(p.Area, p.Room) {
+			continue
+		}
+		to := p.Bind
+		if to.ToArea == "" || !s.roomOpen(to.ToArea, to.ToRoom) {
+			to = defaultBind
+		}
+		s.tellPlayer(roomsMap, p.Nickname, fmt.Sprintf("%s closes, and you find yourself elsewhere\n", p.Room))
+		s.teleport(roomsMap, p, to, "content")
+	}
+}
+
+// closedRoom tells the player the room is closed for now.
+func closedRoom(room string) string {
+	return fmt.Sprintf("The way to %s is closed for now\n", room)
+}
+
+// content lists the content flags and whether they are on, or lets an admin
+// turn one on or off regardless of its time, or back to it with auto.
+// Usage: content [<flag> on|off|auto]
+func (s *Server) content(roomsMap map[string]map[string][][]area.Cube, args []string) string {
+	if len(args) == 0 {
+		if len(s.contentFlags) == 0 {
+			return "There are no content flags\n"
+		}
+		lines := []string{}
+		for _, f := range s.contentFlags {
+			when := []string{}
+			if f.From != "" && f.To != "" {
+				when = append(when, fmt.Sprintf("%s to %s", f.From, f.To))
+			}
+			if len(f.Seasons) > 0 {
+				when = append(when, strings.Join(f.Seasons, ", "))
+			}
+			if len(when) == 0 {
+				when = append(when, "always")
+			}
+			line := fmt.Sprintf("%-20s %-3s %s", f.Name, onOffOf(s.flagsOn[f.Name]), strings.Join(when, ", in "))
+			if _, forced := s.flagOverrides[f.Name]; forced {
+				line += " (set by an admin)"
+			}
+			lines = append(lines, line)
+		}
+		sort.Strings(lines)
+		return fmt.Sprintf("It is %s in the game world\n%s\n", s.worldTime().Season(), strings.Join(lines, "\n"))
+	}
+
+	if len(args) != 2 {
+		return "Usage: content [<flag> on|off|auto]\n"
+	}
+	known := false
+	for _, f := range s.contentFlags {
+		known = known || f.Name == args[0]
+	}
+	if !known {
+		return fmt.Sprintf("There is no content flag %q\n", args[0])
+	}
+	switch strings.ToLower(args[1]) {
+	case "on":
+		s.flagOverrides[args[0]] = true
+	case "off":
+		s.flagOverrides[args[0]] = false
+	case "auto":
+		delete(s.flagOverrides, args[0])
+	default:
+		return "Usage: content [<flag> on|off|auto]\n"
+	}
+	s.checkContent(roomsMap)
+	return fmt.Sprintf("Content flag %s is %s\n", args[0], onOffOf(s.flagsOn[args[0]]))
+}
@@ -0,0 +1,125 @@
+package server
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/droslean/thyranew/area"
+)
+
+// globalCooldown is how long players wait after an attack before they can
+// attack again, whatever the attack.
+const globalCooldown = 1500 * time.Millisecond
+
+// globalCooldownCommands are the attacks, which share the global cooldown.
+var globalCooldownCommands = map[string]bool{
+	"shoot": true, "throw": true, "cast": true, "taunt": true,
+	"attack": true, "kill": true,
+}
+
+// cooldown is something players wait for before they can do it again.
+type cooldown struct {
+	name   string
+	length time.Duration
+	since  func(p *area.Player) time.Time // When the player last did it
+}
+
+// cooldowns are what players wait for, the global cooldown first.
+var cooldowns = []cooldown{
+	{"global", globalCooldown, func(p *area.Player) time.Time { return p.LastAttack }},
+	{"taunt", tauntCooldown, func(p *area.Player) time.Time { return p.LastTaunt }},
+	{"recall", recallCooldown, func(p *area.Player) time.Time { return p.LastRecall }},
+	{"flee", fleeCooldown, func(p *area.Player) time.Time { return p.LastFlee }},
+}
+
+// CooldownInfo is sent to tagged clients when a cooldown starts, for them to
+// show a timer. Start is in milliseconds since the epoch, and Duration in
+// milliseconds.
+type CooldownInfo struct {
+	Name     string `json:"name"`
+	Start    int64  `json:"start"`
+	Duration int64  `json:"duration"`
+}
+
+// left returns how long the player has to wait yet, zero when they don't.
+func (cd cooldown) left(p *area.Player, now time.Time) time.Duration {
+	if since := cd.since(p); !since.IsZero() {
+		if wait := since.Add(cd.length).Sub(now); wait > 0 {
+			return wait
+		}
+	}
+	return 0
+}
+
+// startGlobalCooldown starts the global cooldown of the player, who just
+// attacked. Their status line is drawn again once it is over.
+func (s *Server) startGlobalCooldown(roomsMap map[string]map[string][][]area.Cube, p *area.Player) {
+	p.LastAttack = s.now()
+	nick := p.Nickname
+	s.after(globalCooldown, func() { s.tellPlayer(roomsMap, nick, "") })
+}
+
+// recovering tells the player how long until they can attack again.
+func (s *Server) recovering(p *area.Player) string {
+	return fmt.Sprintf("You can attack again in %s\n", shortDuration(cooldowns[0].left(p, s.now())))
+}
+
+// shortDuration writes the duration in a few letters: seconds, rounded up,
+// or whole minutes past a minute.
+func shortDuration(d time.Duration) string {
+	if d >= time.Minute {
+		return fmt.Sprintf("%dm", int(d/time.Minute))
+	}
+	return fmt.Sprintf("%ds", int((d+time.Second-1)/time.Second))
+}
+
+// showCooldowns lists the cooldowns of the player, and how long each has
+// left.
+// Usage: cooldowns
+func (s *Server) showCooldowns(p *area.Player) string {
+	var b strings.Builder
+	now := s.now()
+	for _, cd := range cooldowns {
+		state := "ready"
+		if wait := cd.left(p, now); wait > 0 {
+			state = wait.Round(100 * time.Millisecond).String()
+		}
+		fmt.Fprintf(&b, "%s: %s\n", strings.Title(cd.name), state)
+	}
+	return b.String()
+}
+
+// cooldownWidget shows the cooldowns the player waits for, in short.
+func cooldownWidget(s *Server, p *area.Player) (string, int, bool) {
+	parts := []string{}
+	now := s.now()
+	for _, cd := range cooldowns {
+		if wait := cd.left(p, now); wait > 0 {
+			parts = append(parts, cd.name+" "+shortDuration(wait))
+		}
+	}
+	if len(parts) == 0 {
+		return "", 0, false
+	}
+	text := "CD " + strings.Join(parts, " ")
+	return text, len(text), true
+}
+
+// sendCooldowns tells a tagged client of the cooldowns of the player that
+// started since it was last told.
+func (s *Server) sendCooldowns(c Client) {
+	p := c.Player
+	if p.TaggedCooldowns == nil {
+		p.TaggedCooldowns = map[string]time.Time{}
+	}
+	now := s.now()
+	for _, cd := range cooldowns {
+		since := cd.since(p)
+		if since.Equal(p.TaggedCooldowns[cd.name]) || cd.left(p, now) == 0 {
+			continue
+		}
+		p.TaggedCooldowns[cd.name] = since
+		writeTag(c, TagCooldown, CooldownInfo{Name: cd.name, Start: since.UnixNano() / int64(time.Millisecond), Duration: int64(cd.length / time.Millisecond)})
+	}
+}
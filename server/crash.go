@@ -0,0 +1,256 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	runtimepprof "runtime/pprof"
+	"sync"
+	"time"
+
+	log "gopkg.in/inconshreveable/log15.v2"
+)
+
+const (
+	// busTrailSize is how many of the latest world events a crash bundle
+	// has.
+	busTrailSize = 256
+	// crashWebhookTimeout is how long the webhook has to take a crash report.
+	crashWebhookTimeout = 10 * time.Second
+	// crashPlayersTimeout is how long a crash bundle waits for the players
+	// online, in case the crash left them locked.
+	crashPlayersTimeout = time.Second
+	// fatalLog is where the runtime writes what killed the process, in the
+	// crash directory. The next start makes a bundle of it.
+	fatalLog = "fatal.log"
+)
+
+// CrashConfig says where crash bundles go, and who is told of them.
+type CrashConfig struct {
+	// Dir is where the crash bundles are written, crashes in the data
+	// directory unless set.
+	Dir string `toml:"dir"`
+	// Webhook is posted a report of each crash, as JSON, when set.
+	Webhook string `toml:"webhook"`
+}
+
+// CrashReport tells of a crash, and where its bundle is.
+type CrashReport struct {
+	Server    string    `json:"server"`
+	World     string    `json:"world,omitempty"`
+	Subsystem string    `json:"subsystem"` // What crashed, like the world or the handler of a player
+	Panic     string    `json:"panic"`
+	Time      time.Time `json:"time"`
+	Bundle    string    `json:"bundle"` // The directory of the bundle
+	Players   int       `json:"players"`
+}
+
+// PlayerSummary is what a crash bundle has of each player online.
+type PlayerSummary struct {
+	Nickname string `json:"nickname"`
+	State    string `json:"state"`
+	Area     string `json:"area"`
+	Room     string `json:"room"`
+	Position string `json:"position"`
+	Level    int    `json:"level"`
+	HP       int    `json:"hp"`
+	IP       string `json:"ip"`
+	Ping     string `json:"ping,omitempty"`
+}
+
+// busRecord is a world event as it went over the bus.
+type busRecord struct {
+	Time  time.Time  `json:"time"`
+	Event WorldEvent `json:"event"`
+}
+
+// busTrail keeps the latest world events, for the crash bundles. The world
+// adds to it, and a crash anywhere reads it.
+type busTrail struct {
+	sync.Mutex
+	events []busRecord
+	next   int
+}
+
+// add keeps the event, in place of the oldest once the trail is full.
+func (t *busTrail) add(e WorldEvent, at time.Time) {
+	t.Lock()
+	defer t.Unlock()
+	if len(t.events) < busTrailSize {
+		t.events = append(t.events, busRecord{Time: at, Event: e})
+		return
+	}
+	t.events[t.next] = busRecord{Time: at, Event: e}
+	t.next = (t.next + 1) % busTrailSize
+}
+
+// recent returns the events kept, the oldest first.
+func (t *busTrail) recent() []busRecord {
+	t.Lock()
+	defer t.Unlock()
+	return append(append([]busRecord{}, t.events[t.next:]...), t.events[:t.next]...)
+}
+
+// crashFunc is told of a panic that was recovered: what panicked, with what,
+// and the stack it panicked on.
+type crashFunc func(name string, r interface{}, stack []byte)
+
+// crashDir returns where the crash bundles go.
+func (s *Server) crashDir() string {
+	if s.config.Crash.Dir != "" {
+		return s.config.Crash.Dir
+	}
+	return filepath.Join(s.dataDir, "crashes")
+}
+
+// reportCrash writes a bundle of the crash for the post-mortem: the stack it
+// panicked on, the stacks of all the goroutines, the latest world events and
+// the players online. The webhook is told of it, if there is one. It is
+// called from the goroutine that panicked, so the world may be the one
+// that is gone.
+func (s *Server) reportCrash(name string, r interface{}, stack []byte) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Crit(fmt.Sprintf("Cannot report the crash of %s: %v", name, r))
+		}
+	}()
+	now := time.Now()
+	var goroutines bytes.Buffer
+	runtimepprof.Lookup("goroutine").WriteTo(&goroutines, 2)
+	players := s.crashPlayers()
+	events, err := json.MarshalIndent(s.trail.recent(), "", "  ")
+	if err != nil {
+		events = []byte(err.Error())
+	}
+	summaries, err := json.MarshalIndent(players, "", "  ")
+	if err != nil {
+		summaries = []byte(err.Error())
+	}
+	crash := fmt.Sprintf("%s panicked on %s: %v\n\n%s", name, now.UTC().Format(time.RFC3339), r, stack)
+
+	dir, err := s.writeBundle(now, map[string][]byte{
+		"crash.txt":      []byte(crash),
+		"goroutines.txt": goroutines.Bytes(),
+		"events.json":    events,
+		"players.json":   summaries,
+	})
+	if err != nil {
+		log.Crit(fmt.Sprintf("Cannot write the crash bundle of %s: %v", name, err))
+		return
+	}
+	log.Crit(fmt.Sprintf("The crash of %s is bundled in %s", name, dir))
+	report := CrashReport{Server: s.serverName(), World: s.name, Subsystem: name, Panic: fmt.Sprint(r), Time: now.UTC(), Bundle: dir, Players: len(players)}
+	go s.postCrash(report)
+}
+
+// crashPlayers sums up the players online, unless that takes too long.
+func (s *Server) crashPlayers() []PlayerSummary {
+	done := make(chan []PlayerSummary, 1)
+	go func() {
+		players := []PlayerSummary{}
+		for _, c := range s.OnlineClients() {
+			p := c.Player
+			summary := PlayerSummary{Nickname: p.Nickname, State: c.state().String(), Area: p.Area, Room: p.Room, Position: p.Position, Level: p.Level, HP: p.HP, IP: c.ip}
+			if _, average, ok := c.ping.get(); ok {
+				summary.Ping = millis(average)
+			}
+			players = append(players, summary)
+		}
+		done <- players
+	}()
+	select {
+	case players := <-done:
+		return players
+	case <-time.After(crashPlayersTimeout):
+		return []PlayerSummary{}
+	}
+}
+
+// writeBundle writes the files of a crash bundle to a directory of their
+// own, named after when it crashed, and returns it. The directory is whole
+// once it has its name.
+func (s *Server) writeBundle(at time.Time, files map[string][]byte) (string, error) {
+	dir := filepath.Join(s.crashDir(), at.UTC().Format(versionStamp))
+	if err := os.MkdirAll(dir+".tmp", 0700); err != nil {
+		return "", err
+	}
+	for name, data := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir+".tmp", name), data, 0600); err != nil {
+			return "", err
+		}
+	}
+	return dir, os.Rename(dir+".tmp", dir)
+}
+
+// postCrash posts the report of the crash to the webhook, if there is one.
+func (s *Server) postCrash(report CrashReport) {
+	if s.config.Crash.Webhook == "" {
+		return
+	}
+	body, err := json.Marshal(report)
+	if err != nil {
+		log.Error(fmt.Sprintf("Cannot marshal the crash report: %v", err))
+		return
+	}
+	client := &http.Client{Timeout: crashWebhookTimeout}
+	resp, err := client.Post(s.config.Crash.Webhook, "application/json", bytes.NewReader(body))
+	if err != nil {
+		log.Error(fmt.Sprintf("Cannot post the crash report: %v", err))
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		log.Error(fmt.Sprintf("The crash webhook answered %s", resp.Status))
+	}
+}
+
+// catchFatal has the runtime write what kills the process, like a fatal
+// error no recover can catch, to the crash directory, with the stacks of all
+// the goroutines. What the last run left there is bundled first.
+func (s *Server) catchFatal() {
+	dir := s.crashDir()
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		log.Error(fmt.Sprintf("Cannot make the crash directory: %v", err))
+		return
+	}
+	path := filepath.Join(dir, fatalLog)
+	if info, err := os.Stat(path); err == nil && info.Size() > 0 {
+		s.bundleFatal(path, info.ModTime())
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		log.Error(fmt.Sprintf("Cannot open %s: %v", path, err))
+		return
+	}
+	debug.SetTraceback("all")
+	if err := debug.SetCrashOutput(f, debug.CrashOptions{}); err != nil {
+		log.Error(fmt.Sprintf("Cannot have fatal errors written to %s: %v", path, err))
+	}
+	f.Close()
+}
+
+// bundleFatal makes a bundle of what killed the last run. There is nothing
+// left of its events and its players, only the stacks.
+func (s *Server) bundleFatal(path string, at time.Time) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		log.Error(fmt.Sprintf("Cannot read %s: %v", path, err))
+		return
+	}
+	dir, err := s.writeBundle(at, map[string][]byte{"crash.txt": data})
+	if err != nil {
+		log.Error(fmt.Sprintf("Cannot bundle %s: %v", path, err))
+		return
+	}
+	log.Crit(fmt.Sprintf("The last run died of a fatal error, bundled in %s", dir))
+	firstLine := string(data)
+	if i := bytes.IndexByte(data, '\n'); i >= 0 {
+		firstLine = string(data[:i])
+	}
+	go s.postCrash(CrashReport{Server: s.serverName(), World: s.name, Subsystem: "the process", Panic: firstLine, Time: at.UTC(), Bundle: dir})
+}
@@ -0,0 +1,55 @@
+package server
+
+import (
+	"fmt"
+
+	"github.com/droslean/thyranew/game"
+)
+
+// classBlurbs tell new players what each class is like.
+var classBlurbs = map[string]string{
+	"Commoner": "gets by, but fights poorly",
+	"Fighter":  "hits often and takes blows well",
+	"Rogue":    "quick and sly, between the two",
+}
+
+// pronounChoices are how others may speak of a character, by their gender.
+var pronounChoices = []choice{
+	{label: "he/him", value: "male"},
+	{label: "she/her", value: "female"},
+	{label: "they/them", value: "none"},
+}
+
+// startCreation walks a player whose character was just made through making
+// it their own: its class, and how others speak of it. Closing it early
+// keeps the class the dice gave them.
+func (s *Server) startCreation(c *Client) {
+	classes := []choice{}
+	for _, class := range game.Classes {
+		classes = append(classes, choice{label: fmt.Sprintf("%s, %s", class, classBlurbs[class]), value: class})
+	}
+	s.openModal(c, &menu{
+		title:   "Pick your class",
+		choices: classes,
+		pick: func(s *Server, c *Client, class choice) string {
+			s.openModal(c, &menu{
+				title:   "How do others speak of you?",
+				choices: pronounChoices,
+				pick: func(s *Server, c *Client, pronoun choice) string {
+					question := fmt.Sprintf("Play a %s spoken of as %s?", class.value, pronoun.label)
+					s.openModal(c, newConfirm(question, func(s *Server, c *Client) string {
+						c.Player.SetClass(class.value)
+						s.gender(c.Player, []string{pronoun.value})
+						return s.savePreferences(c.Player, fmt.Sprintf("You are a %s with %d hit points\n", class.value, c.Player.HP))
+					}, func(s *Server, c *Client) string {
+						// Saying no starts over.
+						s.startCreation(c)
+						return ""
+					}))
+					return ""
+				},
+			})
+			return ""
+		},
+	})
+}
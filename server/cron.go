@@ -0,0 +1,160 @@
+package server
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cronMacros are the names cron gives the most common expressions.
+var cronMacros = map[string]string{
+	"@hourly":   "0 * * * *",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@weekly":   "0 0 * * 0",
+	"@monthly":  "0 0 1 * *",
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+}
+
+var (
+	monthNames = map[string]int{"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6,
+		"jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12}
+	weekdayNames = map[string]int{"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6}
+)
+
+// cronSpec is when a job runs, as the five fields of a cron expression give
+// it: the minutes, the hours, the days of the month, the months and the days
+// of the week, with a bit set for each that matches.
+type cronSpec struct {
+	minute, hour, dom, month, dow uint64
+	// Like cron, a day matches either field when both are restricted, and
+	// the restricted one when only one is.
+	domAll, dowAll bool
+}
+
+// parseCron reads a cron expression, like "30 4 * * mon-fri" or "@daily".
+// Fields take lists, ranges and steps, like "1,15" or "*/10", and months and
+// days of the week their names too. Sunday is 0 or 7.
+func parseCron(expr string) (cronSpec, error) {
+	if macro, ok := cronMacros[strings.ToLower(strings.TrimSpace(expr))]; ok {
+		expr = macro
+	}
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return cronSpec{}, fmt.Errorf("%q is not five fields: minute, hour, day of the month, month and day of the week", expr)
+	}
+	c := cronSpec{domAll: fields[2] == "*", dowAll: fields[4] == "*"}
+	var err error
+	if c.minute, err = parseCronField(fields[0], 0, 59, nil); err != nil {
+		return c, fmt.Errorf("minute: %v", err)
+	}
+	if c.hour, err = parseCronField(fields[1], 0, 23, nil); err != nil {
+		return c, fmt.Errorf("hour: %v", err)
+	}
+	if c.dom, err = parseCronField(fields[2], 1, 31, nil); err != nil {
+		return c, fmt.Errorf("day of the month: %v", err)
+	}
+	if c.month, err = parseCronField(fields[3], 1, 12, monthNames); err != nil {
+		return c, fmt.Errorf("month: %v", err)
+	}
+	if c.dow, err = parseCronField(fields[4], 0, 7, weekdayNames); err != nil {
+		return c, fmt.Errorf("day of the week: %v", err)
+	}
+	if c.dow&(1<<7) != 0 {
+		c.dow |= 1
+	}
+	return c, nil
+}
+
+// parseCronField returns the bits of the values the field matches, from min
+// to max.
+func parseCronField(field string, min, max int, names map[string]int) (uint64, error) {
+	value := func(s string) (int, error) {
+		if n, ok := names[strings.ToLower(s)]; ok {
+			return n, nil
+		}
+		n, err := strconv.Atoi(s)
+		if err != nil || n < min || n > max {
+			return 0, fmt.Errorf("%q is not from %d to %d", s, min, max)
+		}
+		return n, nil
+	}
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		span, step := part, 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			n, err := strconv.Atoi(part[i+1:])
+			if err != nil || n < 1 {
+				return 0, fmt.Errorf("%q has no valid step", part)
+			}
+			span, step = part[:i], n
+		}
+		lo, hi := min, max
+		switch {
+		case span == "*":
+		case strings.Contains(span, "-"):
+			i := strings.Index(span, "-")
+			var err error
+			if lo, err = value(span[:i]); err != nil {
+				return 0, err
+			}
+			if hi, err = value(span[i+1:]); err != nil {
+				return 0, err
+			}
+			if hi < lo {
+				return 0, fmt.Errorf("%q goes backwards", span)
+			}
+		default:
+			var err error
+			if lo, err = value(span); err != nil {
+				return 0, err
+			}
+			// A single value with a step starts a range, as in cron.
+			if step == 1 {
+				hi = lo
+			}
+		}
+		for n := lo; n <= hi; n += step {
+			bits |= 1 << uint(n)
+		}
+	}
+	return bits, nil
+}
+
+// cronHas reports whether the bits match n.
+func cronHas(bits uint64, n int) bool {
+	return bits&(1<<uint(n)) != 0
+}
+
+// dayMatches reports whether the job runs on the day of the time.
+func (c cronSpec) dayMatches(t time.Time) bool {
+	dom, dow := cronHas(c.dom, t.Day()), cronHas(c.dow, int(t.Weekday()))
+	if c.domAll || c.dowAll {
+		return dom && dow
+	}
+	return dom || dow
+}
+
+// next returns the first minute after the time the job runs at, in the time
+// zone of the time, or zero if it never does, like on February 30.
+func (c cronSpec) next(after time.Time) time.Time {
+	loc := after.Location()
+	t := time.Date(after.Year(), after.Month(), after.Day(), after.Hour(), after.Minute()+1, 0, 0, loc)
+	for limit := t.AddDate(5, 0, 0); t.Before(limit); {
+		switch {
+		case !cronHas(c.month, int(t.Month())):
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, loc)
+		case !c.dayMatches(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, loc)
+		case !cronHas(c.hour, t.Hour()):
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, loc)
+		case !cronHas(c.minute, t.Minute()):
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute()+1, 0, 0, loc)
+		default:
+			return t
+		}
+	}
+	return time.Time{}
+}
@@ -0,0 +1,72 @@
+package server
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/droslean/thyranew/area"
+	"github.com/droslean/thyranew/game"
+)
+
+// damageNouns are what messages call the damage of each type.
+var damageNouns = map[string]string{
+	game.DamageSlash:    "blade",
+	game.DamagePierce:   "point",
+	game.DamageBludgeon: "blow",
+	game.DamageFire:     "flames",
+	game.DamageCold:     "frost",
+	game.DamageAcid:     "acid",
+	game.DamageForce:    "magic",
+	game.DamageThunder:  "thunder",
+}
+
+// blowsOf returns the type of damage the blows of the NPC deal.
+func blowsOf(npc *area.NPC) string {
+	if npc.Blows == "" {
+		return game.DamageBludgeon
+	}
+	return npc.Blows
+}
+
+// defenseText returns the template that tells how damage of the type fared
+// against the defenses of the target, empty when it got through in full.
+// NPCs are spoken of by name; players read of themselves.
+func defenseText(fared, kind string, npcTarget bool) string {
+	noun, ok := damageNouns[kind]
+	if !ok {
+		noun = "attack"
+	}
+	texts := map[string]string{
+		game.DamageImmune:     "$p %s can't harm $N!\n",
+		game.DamageResisted:   "$p %s is blunted on $N\n",
+		game.DamageVulnerable: "$p %s tears into $N!\n",
+	}
+	if npcTarget {
+		texts = map[string]string{
+			game.DamageImmune:     "$N is immune to $p %s!\n",
+			game.DamageResisted:   "$N resists $p %s\n",
+			game.DamageVulnerable: "$N is vulnerable to $p %s!\n",
+		}
+	}
+	if text, ok := texts[fared]; ok {
+		return fmt.Sprintf(text, noun)
+	}
+	return ""
+}
+
+// defensesOf describes the defenses in lines of the sheet.
+func defensesOf(d game.Defenses) []string {
+	lines := []string{}
+	for _, l := range []struct {
+		title string
+		types []string
+	}{{"Resists", d.Resists}, {"Immune to", d.Immune}, {"Vulnerable to", d.Vulnerable}} {
+		if len(l.types) > 0 {
+			lines = append(lines, fmt.Sprintf("%s: %s", l.title, strings.Join(l.types, ", ")))
+		}
+	}
+	if len(lines) == 0 {
+		return []string{"Damage types: none"}
+	}
+	return lines
+}
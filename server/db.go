@@ -1,22 +1,73 @@
 package server
 
 import (
+	"context"
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/x509"
+	"encoding/binary"
+	"encoding/json"
 	"encoding/pem"
 	"fmt"
+	"io"
 	"strings"
+	"time"
 
 	"golang.org/x/crypto/ssh"
 
 	"github.com/boltdb/bolt"
+	"github.com/droslean/thyranew/area"
 )
 
 var (
 	playerBucket = []byte("players")
 	configBucket = []byte("config")
 	configSSHKey = []byte("ssh-private-key")
+	// The key characters are exported with, unless server.toml has one.
+	configCharacterKey = []byte("character-key")
+	// Notifications are kept by the nickname of their recipient. Unlike
+	// players, they survive a reset of the database.
+	notificationBucket = []byte("notifications")
+	// Mail is kept by its ID, along with the attachments held in escrow.
+	mailBucket = []byte("mail")
+	// UIDs are kept by the kind and the name of what they identify, and the
+	// other way round. They survive a reset of the database too.
+	uidBucket     = []byte("uids")
+	uidNameBucket = []byte("uid-names")
+	// Reports of bugs, typos and ideas are kept by their ID.
+	reportBucket = []byte("reports")
+	// What the admins did to moderate players is kept in order, by ID.
+	auditBucket = []byte("audit")
+	// What happened to the characters is kept in order too, by ID. It goes
+	// with the players on a reset of the database.
+	historyBucket = []byte("history")
+	// The news of the server is kept in order as well, by ID, and goes on a
+	// reset along with the players it tells of.
+	newsBucket = []byte("news")
+	// Logged chat and commands are kept in a bucket for each stream, in the
+	// order they were said.
+	chatLogBucket = []byte("chatlog")
+	// Where players logged in from is kept by their nickname, and who logged
+	// in from where by the key fingerprint or the IP, so alts can be found
+	// both ways.
+	identityBucket = []byte("identities")
+	sightingBucket = []byte("sightings")
+	// Room descriptions builders wrote in the game are kept by their area and
+	// room, and stand in for those of the area files.
+	descriptionBucket = []byte("descriptions")
+	// The gold that came into the world and left it is kept by the day, and
+	// by where it came from or went to.
+	economyBucket = []byte("economy")
+	// The hints each account was shown, and whether it wants them, are kept
+	// by the account. Like notifications, they survive a reset of the
+	// database.
+	hintBucket = []byte("hints")
+	// The outside identities linked to players are kept by their provider
+	// and their ID there, and go on a reset along with the players.
+	linkBucket = []byte("links")
+	// The subsystems admins turned off, or back on, are kept by their name.
+	// They survive a reset of the database, like the hints.
+	featureBucket = []byte("features")
 )
 
 //store is a storage mechanism for
@@ -35,6 +86,9 @@ func NewDatabase(loc string, reset bool) (*Database, error) {
 	}
 	if reset {
 		db.Update(func(tx *bolt.Tx) error {
+			tx.DeleteBucket(historyBucket)
+			tx.DeleteBucket(newsBucket)
+			tx.DeleteBucket(linkBucket)
 			return tx.DeleteBucket(playerBucket)
 		})
 	}
@@ -96,3 +150,1364 @@ func genPrivateKey() ([]byte, error) {
 	key := x509.MarshalPKCS1PrivateKey(priv)
 	return pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: key}), nil
 }
+
+// Notification is a message from the server waiting in the inbox of a player.
+type Notification struct {
+	From string    `json:"from"`
+	Text string    `json:"text"`
+	Time time.Time `json:"time"`
+	Read bool      `json:"read"`
+}
+
+// GetNotifications returns the inbox of the player, oldest first.
+func (db *Database) GetNotifications(ctx context.Context, nick string) ([]Notification, error) {
+	inbox := []Notification{}
+	err := db.view(ctx, func(tx *bolt.Tx) error {
+		b := tx.Bucket(notificationBucket)
+		if b == nil {
+			return nil
+		}
+		val := b.Get([]byte(nick))
+		if val == nil {
+			return nil
+		}
+		return json.Unmarshal(val, &inbox)
+	})
+	return inbox, err
+}
+
+// PutNotifications replaces the inbox of the player.
+func (db *Database) PutNotifications(ctx context.Context, nick string, inbox []Notification) error {
+	val, err := json.Marshal(inbox)
+	if err != nil {
+		return err
+	}
+	return db.update(ctx, func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists(notificationBucket)
+		if err != nil {
+			return err
+		}
+		return b.Put([]byte(nick), val)
+	})
+}
+
+// AddNotification appends the notification to the inbox of the player.
+func (db *Database) AddNotification(ctx context.Context, nick string, n Notification) error {
+	inbox, err := db.GetNotifications(ctx, nick)
+	if err != nil {
+		return err
+	}
+	return db.PutNotifications(ctx, nick, append(inbox, n))
+}
+
+// Mail is a letter between players, with the items and gold attached to it.
+type Mail struct {
+	ID     uint64    `json:"id"`
+	From   string    `json:"from"`
+	To     string    `json:"to"`
+	Text   string    `json:"text"`
+	Items  []string  `json:"items"`
+	Gold   int       `json:"gold"`
+	Sent   time.Time `json:"sent"`
+	Status string    `json:"status"`
+	// Returned is set on mail that went back to its sender unread.
+	Returned bool `json:"returned"`
+}
+
+// PutMail stores the mail, giving it an ID when it has none yet.
+func (db *Database) PutMail(ctx context.Context, m *Mail) error {
+	return db.update(ctx, func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists(mailBucket)
+		if err != nil {
+			return err
+		}
+		if m.ID == 0 {
+			if m.ID, err = b.NextSequence(); err != nil {
+				return err
+			}
+		}
+		val, err := json.Marshal(m)
+		if err != nil {
+			return err
+		}
+		return b.Put(idKey(m.ID), val)
+	})
+}
+
+// GetMail returns the mail with the given ID, or nil when there is none.
+func (db *Database) GetMail(ctx context.Context, id uint64) (*Mail, error) {
+	var m *Mail
+	err := db.view(ctx, func(tx *bolt.Tx) error {
+		b := tx.Bucket(mailBucket)
+		if b == nil {
+			return nil
+		}
+		val := b.Get(idKey(id))
+		if val == nil {
+			return nil
+		}
+		m = &Mail{}
+		return json.Unmarshal(val, m)
+	})
+	return m, err
+}
+
+// DeleteMail removes the mail with the given ID.
+func (db *Database) DeleteMail(ctx context.Context, id uint64) error {
+	return db.update(ctx, func(tx *bolt.Tx) error {
+		b := tx.Bucket(mailBucket)
+		if b == nil {
+			return nil
+		}
+		return b.Delete(idKey(id))
+	})
+}
+
+// ListMail returns all the mail, oldest first.
+func (db *Database) ListMail(ctx context.Context) ([]Mail, error) {
+	all := []Mail{}
+	err := db.view(ctx, func(tx *bolt.Tx) error {
+		b := tx.Bucket(mailBucket)
+		if b == nil {
+			return nil
+		}
+		return b.ForEach(func(k, v []byte) error {
+			m := Mail{}
+			if err := json.Unmarshal(v, &m); err != nil {
+				return err
+			}
+			all = append(all, m)
+			return nil
+		})
+	})
+	return all, err
+}
+
+// idKey keeps mail, reports, audit and log entries and UIDs sorted by their number in their buckets.
+func idKey(id uint64) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, id)
+	return key
+}
+
+// GetUID returns the UID of the named thing of the given kind, handing out a
+// new one the first time the thing is asked for.
+func (db *Database) GetUID(ctx context.Context, kind, name string) (area.UID, error) {
+	key := []byte(kind + ":" + name)
+	var uid uint64
+	err := db.update(ctx, func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists(uidBucket)
+		if err != nil {
+			return err
+		}
+		if val := b.Get(key); val != nil {
+			uid = binary.BigEndian.Uint64(val)
+			return nil
+		}
+		names, err := tx.CreateBucketIfNotExists(uidNameBucket)
+		if err != nil {
+			return err
+		}
+		if uid, err = b.NextSequence(); err != nil {
+			return err
+		}
+		if err := b.Put(key, idKey(uid)); err != nil {
+			return err
+		}
+		return names.Put(idKey(uid), key)
+	})
+	return area.UID(uid), err
+}
+
+// UIDName returns the kind and the name of the thing with the given UID, or
+// empty strings when the UID was never handed out.
+func (db *Database) UIDName(ctx context.Context, uid area.UID) (kind, name string, err error) {
+	err = db.view(ctx, func(tx *bolt.Tx) error {
+		b := tx.Bucket(uidNameBucket)
+		if b == nil {
+			return nil
+		}
+		val := b.Get(idKey(uint64(uid)))
+		if val == nil {
+			return nil
+		}
+		parts := strings.SplitN(string(val), ":", 2)
+		kind, name = parts[0], parts[1]
+		return nil
+	})
+	return kind, name, err
+}
+
+// Report is a bug, a typo or an idea sent in by a player, with where they
+// stood and what they did last.
+type Report struct {
+	ID       uint64    `json:"id"`
+	Kind     string    `json:"kind"`
+	Player   string    `json:"player"`
+	Text     string    `json:"text"`
+	Area     string    `json:"area"`
+	Room     string    `json:"room"`
+	Position string    `json:"position"`
+	Recent   []string  `json:"recent"` // The latest commands of the player, oldest first
+	Filed    time.Time `json:"filed"`
+	Status   string    `json:"status"`
+	// ClaimedBy is the admin working on the report.
+	ClaimedBy  string `json:"claimed_by,omitempty"`
+	Resolution string `json:"resolution,omitempty"`
+}
+
+// PutReport stores the report, giving it an ID when it has none yet.
+func (db *Database) PutReport(ctx context.Context, r *Report) error {
+	return db.update(ctx, func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists(reportBucket)
+		if err != nil {
+			return err
+		}
+		if r.ID == 0 {
+			if r.ID, err = b.NextSequence(); err != nil {
+				return err
+			}
+		}
+		val, err := json.Marshal(r)
+		if err != nil {
+			return err
+		}
+		return b.Put(idKey(r.ID), val)
+	})
+}
+
+// GetReport returns the report with the given ID, or nil when there is none.
+func (db *Database) GetReport(ctx context.Context, id uint64) (*Report, error) {
+	var r *Report
+	err := db.view(ctx, func(tx *bolt.Tx) error {
+		b := tx.Bucket(reportBucket)
+		if b == nil {
+			return nil
+		}
+		val := b.Get(idKey(id))
+		if val == nil {
+			return nil
+		}
+		r = &Report{}
+		return json.Unmarshal(val, r)
+	})
+	return r, err
+}
+
+// UpdateReport changes the report with the given ID in one transaction, so
+// admins in the game and over the API don't undo each other. It returns nil
+// when there is no such report, and stores nothing when change fails.
+func (db *Database) UpdateReport(ctx context.Context, id uint64, change func(*Report) error) (*Report, error) {
+	var r *Report
+	err := db.update(ctx, func(tx *bolt.Tx) error {
+		b := tx.Bucket(reportBucket)
+		if b == nil {
+			return nil
+		}
+		val := b.Get(idKey(id))
+		if val == nil {
+			return nil
+		}
+		cur := &Report{}
+		if err := json.Unmarshal(val, cur); err != nil {
+			return err
+		}
+		if err := change(cur); err != nil {
+			return err
+		}
+		changed, err := json.Marshal(cur)
+		if err != nil {
+			return err
+		}
+		r = cur
+		return b.Put(idKey(id), changed)
+	})
+	return r, err
+}
+
+// ListReports returns all the reports, oldest first.
+func (db *Database) ListReports(ctx context.Context) ([]Report, error) {
+	all := []Report{}
+	err := db.view(ctx, func(tx *bolt.Tx) error {
+		b := tx.Bucket(reportBucket)
+		if b == nil {
+			return nil
+		}
+		return b.ForEach(func(k, v []byte) error {
+			r := Report{}
+			if err := json.Unmarshal(v, &r); err != nil {
+				return err
+			}
+			all = append(all, r)
+			return nil
+		})
+	})
+	return all, err
+}
+
+// AuditEntry records something done to moderate a player.
+type AuditEntry struct {
+	ID     uint64    `json:"id"`
+	Time   time.Time `json:"time"`
+	Admin  string    `json:"admin"` // Admin who did it, or the escalation rules
+	Action string    `json:"action"`
+	Player string    `json:"player"`
+	Detail string    `json:"detail"`
+}
+
+// AddAudit appends the entry to the audit log.
+func (db *Database) AddAudit(ctx context.Context, e *AuditEntry) error {
+	return db.update(ctx, func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists(auditBucket)
+		if err != nil {
+			return err
+		}
+		if e.ID, err = b.NextSequence(); err != nil {
+			return err
+		}
+		val, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		return b.Put(idKey(e.ID), val)
+	})
+}
+
+// ListAudit returns the latest entries of the audit log, oldest first, up to
+// the given number. Only the entries about the player count, unless empty.
+func (db *Database) ListAudit(ctx context.Context, player string, max int) ([]AuditEntry, error) {
+	latest := []AuditEntry{}
+	err := db.view(ctx, func(tx *bolt.Tx) error {
+		b := tx.Bucket(auditBucket)
+		if b == nil {
+			return nil
+		}
+		c := b.Cursor()
+		for k, v := c.Last(); k != nil && len(latest) < max; k, v = c.Prev() {
+			e := AuditEntry{}
+			if err := json.Unmarshal(v, &e); err != nil {
+				return err
+			}
+			if player == "" || e.Player == player {
+				latest = append(latest, e)
+			}
+		}
+		return nil
+	})
+	for i, j := 0, len(latest)-1; i < j; i, j = i+1, j-1 {
+		latest[i], latest[j] = latest[j], latest[i]
+	}
+	return latest, err
+}
+
+// HistoryEntry records something that happened to a character.
+type HistoryEntry struct {
+	ID     uint64    `json:"id"`
+	Time   time.Time `json:"time"`
+	Player string    `json:"player"`
+	Kind   string    `json:"kind"`  // What happened, like a level or a death
+	Level  int       `json:"level"` // Level of the character at the time
+	Text   string    `json:"text"`
+}
+
+// AddHistory appends the entry to the history of the characters.
+func (db *Database) AddHistory(ctx context.Context, e *HistoryEntry) error {
+	return db.update(ctx, func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists(historyBucket)
+		if err != nil {
+			return err
+		}
+		if e.ID, err = b.NextSequence(); err != nil {
+			return err
+		}
+		val, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		return b.Put(idKey(e.ID), val)
+	})
+}
+
+// ListHistory returns the latest entries of the history that match, oldest
+// first, up to the given number.
+func (db *Database) ListHistory(ctx context.Context, match func(HistoryEntry) bool, max int) ([]HistoryEntry, error) {
+	latest := []HistoryEntry{}
+	err := db.view(ctx, func(tx *bolt.Tx) error {
+		b := tx.Bucket(historyBucket)
+		if b == nil {
+			return nil
+		}
+		c := b.Cursor()
+		for k, v := c.Last(); k != nil && len(latest) < max; k, v = c.Prev() {
+			e := HistoryEntry{}
+			if err := json.Unmarshal(v, &e); err != nil {
+				return err
+			}
+			if match(e) {
+				latest = append(latest, e)
+			}
+		}
+		return nil
+	})
+	for i, j := 0, len(latest)-1; i < j; i, j = i+1, j-1 {
+		latest[i], latest[j] = latest[j], latest[i]
+	}
+	return latest, err
+}
+
+// NewsItem is a piece of the news of the server, for its feeds.
+type NewsItem struct {
+	ID     uint64    `json:"id"`
+	Time   time.Time `json:"time"`
+	Kind   string    `json:"kind"`
+	Title  string    `json:"title"`
+	Text   string    `json:"text"`
+	Player string    `json:"player,omitempty"`
+	Level  int       `json:"level,omitempty"`
+}
+
+// AddNews appends the item to the news.
+func (db *Database) AddNews(ctx context.Context, n *NewsItem) error {
+	return db.update(ctx, func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists(newsBucket)
+		if err != nil {
+			return err
+		}
+		if n.ID, err = b.NextSequence(); err != nil {
+			return err
+		}
+		val, err := json.Marshal(n)
+		if err != nil {
+			return err
+		}
+		return b.Put(idKey(n.ID), val)
+	})
+}
+
+// ListNews returns the latest items of the news that match, latest first, up
+// to the given number.
+func (db *Database) ListNews(ctx context.Context, match func(NewsItem) bool, max int) ([]NewsItem, error) {
+	latest := []NewsItem{}
+	err := db.view(ctx, func(tx *bolt.Tx) error {
+		b := tx.Bucket(newsBucket)
+		if b == nil {
+			return nil
+		}
+		c := b.Cursor()
+		for k, v := c.Last(); k != nil && len(latest) < max; k, v = c.Prev() {
+			n := NewsItem{}
+			if err := json.Unmarshal(v, &n); err != nil {
+				return err
+			}
+			if match(n) {
+				latest = append(latest, n)
+			}
+		}
+		return nil
+	})
+	return latest, err
+}
+
+// AddLogEntries appends the entries to the log of their streams.
+func (db *Database) AddLogEntries(ctx context.Context, entries []LogEntry) error {
+	return db.update(ctx, func(tx *bolt.Tx) error {
+		root, err := tx.CreateBucketIfNotExists(chatLogBucket)
+		if err != nil {
+			return err
+		}
+		for _, e := range entries {
+			b, err := root.CreateBucketIfNotExists([]byte(e.Stream))
+			if err != nil {
+				return err
+			}
+			id, err := b.NextSequence()
+			if err != nil {
+				return err
+			}
+			val, err := json.Marshal(e)
+			if err != nil {
+				return err
+			}
+			if err := b.Put(idKey(id), val); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// PruneLog drops the entries of the streams older than what keep returns for
+// each. Streams kept for no time at all are dropped whole. Entries are stored
+// in the order they were logged, so each stream is pruned up to the first
+// entry to keep.
+func (db *Database) PruneLog(ctx context.Context, keep func(stream string) time.Duration) error {
+	now := time.Now()
+	return db.update(ctx, func(tx *bolt.Tx) error {
+		root := tx.Bucket(chatLogBucket)
+		if root == nil {
+			return nil
+		}
+		streams := []string{}
+		root.ForEach(func(k, v []byte) error {
+			streams = append(streams, string(k))
+			return nil
+		})
+		for _, stream := range streams {
+			d := keep(stream)
+			if d <= 0 {
+				if err := root.DeleteBucket([]byte(stream)); err != nil {
+					return err
+				}
+				continue
+			}
+			c := root.Bucket([]byte(stream)).Cursor()
+			for k, v := c.First(); k != nil; k, v = c.First() {
+				e := LogEntry{}
+				if err := json.Unmarshal(v, &e); err != nil {
+					return err
+				}
+				if now.Sub(e.Time) < d {
+					break
+				}
+				if err := c.Delete(); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// SearchLog returns the latest entries of the streams that match, oldest
+// first, up to the given number. Every stream is searched when none is given.
+func (db *Database) SearchLog(ctx context.Context, streams []string, match func(LogEntry) bool, max int) ([]LogEntry, error) {
+	found := []LogEntry{}
+	err := db.view(ctx, func(tx *bolt.Tx) error {
+		root := tx.Bucket(chatLogBucket)
+		if root == nil {
+			return nil
+		}
+		if len(streams) == 0 {
+			root.ForEach(func(k, v []byte) error {
+				streams = append(streams, string(k))
+				return nil
+			})
+		}
+		for _, stream := range streams {
+			b := root.Bucket([]byte(stream))
+			if b == nil {
+				continue
+			}
+			err := b.ForEach(func(k, v []byte) error {
+				e := LogEntry{}
+				if err := json.Unmarshal(v, &e); err != nil {
+					return err
+				}
+				if match(e) {
+					found = append(found, e)
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return latestEntries(found, max), err
+}
+
+// Identity is the key fingerprints and the IPs a player logged in from, with
+// when they were last seen.
+type Identity struct {
+	Fingerprints map[string]time.Time `json:"fingerprints"`
+	IPs          map[string]time.Time `json:"ips"`
+}
+
+// sightingKey is the key players are kept under in the sightings, for a key
+// fingerprint ("key") or an IP ("ip").
+func sightingKey(kind, value string) []byte {
+	return []byte(kind + ":" + value)
+}
+
+// RecordLogin notes that the player logged in with the key fingerprint from
+// the IP. Either may be empty.
+func (db *Database) RecordLogin(ctx context.Context, nick, fingerprint, ip string, at time.Time) error {
+	return db.update(ctx, func(tx *bolt.Tx) error {
+		identities, err := tx.CreateBucketIfNotExists(identityBucket)
+		if err != nil {
+			return err
+		}
+		sightings, err := tx.CreateBucketIfNotExists(sightingBucket)
+		if err != nil {
+			return err
+		}
+		id := Identity{}
+		if val := identities.Get([]byte(nick)); val != nil {
+			if err := json.Unmarshal(val, &id); err != nil {
+				return err
+			}
+		}
+		if id.Fingerprints == nil {
+			id.Fingerprints = map[string]time.Time{}
+		}
+		if id.IPs == nil {
+			id.IPs = map[string]time.Time{}
+		}
+		for kind, value := range map[string]string{"key": fingerprint, "ip": ip} {
+			if value == "" {
+				continue
+			}
+			if kind == "key" {
+				id.Fingerprints[value] = at
+			} else {
+				id.IPs[value] = at
+			}
+			seen := map[string]time.Time{}
+			key := sightingKey(kind, value)
+			if val := sightings.Get(key); val != nil {
+				if err := json.Unmarshal(val, &seen); err != nil {
+					return err
+				}
+			}
+			seen[nick] = at
+			val, err := json.Marshal(seen)
+			if err != nil {
+				return err
+			}
+			if err := sightings.Put(key, val); err != nil {
+				return err
+			}
+		}
+		val, err := json.Marshal(id)
+		if err != nil {
+			return err
+		}
+		return identities.Put([]byte(nick), val)
+	})
+}
+
+// GetIdentity returns where the player logged in from. Players who never
+// logged in get an empty one.
+func (db *Database) GetIdentity(ctx context.Context, nick string) (Identity, error) {
+	id := Identity{}
+	err := db.view(ctx, func(tx *bolt.Tx) error {
+		b := tx.Bucket(identityBucket)
+		if b == nil {
+			return nil
+		}
+		if val := b.Get([]byte(nick)); val != nil {
+			return json.Unmarshal(val, &id)
+		}
+		return nil
+	})
+	return id, err
+}
+
+// SeenWith returns the players who logged in with the key fingerprint
+// ("key") or from the IP ("ip"), and when they were last seen so.
+func (db *Database) SeenWith(ctx context.Context, kind, value string) (map[string]time.Time, error) {
+	seen := map[string]time.Time{}
+	err := db.view(ctx, func(tx *bolt.Tx) error {
+		b := tx.Bucket(sightingBucket)
+		if b == nil {
+			return nil
+		}
+		if val := b.Get(sightingKey(kind, value)); val != nil {
+			return json.Unmarshal(val, &seen)
+		}
+		return nil
+	})
+	return seen, err
+}
+
+// PutRoomDescription keeps the description a builder wrote for the room.
+func (db *Database) PutRoomDescription(ctx context.Context, areaName, room, text string) error {
+	return db.update(ctx, func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists(descriptionBucket)
+		if err != nil {
+			return err
+		}
+		return b.Put([]byte(areaName+"/"+room), []byte(text))
+	})
+}
+
+// RoomDescriptions returns the descriptions builders wrote, by "area/room".
+func (db *Database) RoomDescriptions(ctx context.Context) (map[string]string, error) {
+	descriptions := map[string]string{}
+	err := db.view(ctx, func(tx *bolt.Tx) error {
+		b := tx.Bucket(descriptionBucket)
+		if b == nil {
+			return nil
+		}
+		return b.ForEach(func(k, v []byte) error {
+			descriptions[string(k)] = string(v)
+			return nil
+		})
+	})
+	return descriptions, err
+}
+
+// AddGoldFlows adds the gold that came and went, by source, to that of the
+// day.
+func (db *Database) AddGoldFlows(ctx context.Context, day string, flows map[string]int) error {
+	return db.update(ctx, func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists(economyBucket)
+		if err != nil {
+			return err
+		}
+		total := map[string]int{}
+		if v := b.Get([]byte(day)); v != nil {
+			if err := json.Unmarshal(v, &total); err != nil {
+				return err
+			}
+		}
+		for source, amount := range flows {
+			total[source] += amount
+		}
+		v, err := json.Marshal(total)
+		if err != nil {
+			return err
+		}
+		return b.Put([]byte(day), v)
+	})
+}
+
+// GoldFlows returns the gold that came and went by the day, and by source.
+func (db *Database) GoldFlows(ctx context.Context) (map[string]map[string]int, error) {
+	flows := map[string]map[string]int{}
+	err := db.view(ctx, func(tx *bolt.Tx) error {
+		b := tx.Bucket(economyBucket)
+		if b == nil {
+			return nil
+		}
+		return b.ForEach(func(k, v []byte) error {
+			day := map[string]int{}
+			if err := json.Unmarshal(v, &day); err != nil {
+				return err
+			}
+			flows[string(k)] = day
+			return nil
+		})
+	})
+	return flows, err
+}
+
+// HintRecord is what an account was shown of the hints for new players, by
+// name, and whether it wants them at all.
+type HintRecord struct {
+	Shown []string `json:"shown"`
+	Off   bool     `json:"off"`
+}
+
+// GetHints returns the hints the account was shown, and whether it wants
+// them.
+func (db *Database) GetHints(ctx context.Context, account string) (HintRecord, error) {
+	record := HintRecord{}
+	err := db.view(ctx, func(tx *bolt.Tx) error {
+		b := tx.Bucket(hintBucket)
+		if b == nil {
+			return nil
+		}
+		v := b.Get([]byte(account))
+		if v == nil {
+			return nil
+		}
+		return json.Unmarshal(v, &record)
+	})
+	return record, err
+}
+
+// PutHints keeps the hints the account was shown, and whether it wants them.
+func (db *Database) PutHints(ctx context.Context, account string, record HintRecord) error {
+	return db.update(ctx, func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists(hintBucket)
+		if err != nil {
+			return err
+		}
+		v, err := json.Marshal(record)
+		if err != nil {
+			return err
+		}
+		return b.Put([]byte(account), v)
+	})
+}
+
+// Link ties an identity on an outside provider, like GitHub, to a player.
+type Link struct {
+	Provider string    `json:"provider"`
+	ID       string    `json:"id"`   // ID of the identity at the provider
+	Name     string    `json:"name"` // Name of the identity at the provider
+	Player   string    `json:"player"`
+	Linked   time.Time `json:"linked"`
+}
+
+func linkKey(provider, id string) []byte {
+	return []byte(provider + "/" + id)
+}
+
+// PutLink links the identity to the player, in place of the identity the
+// player had at the same provider. An identity linked to another player
+// stays theirs until they unlink it, and errLinkedElsewhere is returned.
+func (db *Database) PutLink(ctx context.Context, l Link) error {
+	return db.update(ctx, func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists(linkBucket)
+		if err != nil {
+			return err
+		}
+		if v := b.Get(linkKey(l.Provider, l.ID)); v != nil {
+			cur := Link{}
+			if err := json.Unmarshal(v, &cur); err != nil {
+				return err
+			}
+			if !strings.EqualFold(cur.Player, l.Player) {
+				return errLinkedElsewhere
+			}
+		}
+		old := [][]byte{}
+		b.ForEach(func(k, v []byte) error {
+			cur := Link{}
+			if json.Unmarshal(v, &cur) == nil && cur.Provider == l.Provider && strings.EqualFold(cur.Player, l.Player) {
+				old = append(old, k)
+			}
+			return nil
+		})
+		for _, k := range old {
+			if err := b.Delete(k); err != nil {
+				return err
+			}
+		}
+		v, err := json.Marshal(l)
+		if err != nil {
+			return err
+		}
+		return b.Put(linkKey(l.Provider, l.ID), v)
+	})
+}
+
+// GetLink returns the link of the identity at the provider, or nil when it
+// is not linked.
+func (db *Database) GetLink(ctx context.Context, provider, id string) (*Link, error) {
+	var l *Link
+	err := db.view(ctx, func(tx *bolt.Tx) error {
+		b := tx.Bucket(linkBucket)
+		if b == nil {
+			return nil
+		}
+		v := b.Get(linkKey(provider, id))
+		if v == nil {
+			return nil
+		}
+		l = &Link{}
+		return json.Unmarshal(v, l)
+	})
+	return l, err
+}
+
+// ListLinks returns the links of the player.
+func (db *Database) ListLinks(ctx context.Context, player string) ([]Link, error) {
+	links := []Link{}
+	err := db.view(ctx, func(tx *bolt.Tx) error {
+		b := tx.Bucket(linkBucket)
+		if b == nil {
+			return nil
+		}
+		return b.ForEach(func(k, v []byte) error {
+			l := Link{}
+			if err := json.Unmarshal(v, &l); err != nil {
+				return err
+			}
+			if strings.EqualFold(l.Player, player) {
+				links = append(links, l)
+			}
+			return nil
+		})
+	})
+	return links, err
+}
+
+// DeleteLink unlinks the identity the player has at the provider. It reports
+// whether there was one.
+func (db *Database) DeleteLink(ctx context.Context, player, provider string) (bool, error) {
+	found := false
+	err := db.update(ctx, func(tx *bolt.Tx) error {
+		b := tx.Bucket(linkBucket)
+		if b == nil {
+			return nil
+		}
+		old := [][]byte{}
+		b.ForEach(func(k, v []byte) error {
+			l := Link{}
+			if json.Unmarshal(v, &l) == nil && l.Provider == provider && strings.EqualFold(l.Player, player) {
+				old = append(old, k)
+			}
+			return nil
+		})
+		for _, k := range old {
+			if err := b.Delete(k); err != nil {
+				return err
+			}
+		}
+		found = len(old) > 0
+		return nil
+	})
+	return found, err
+}
+
+// view runs a read-only transaction, unless the context is done already.
+func (db *Database) view(ctx context.Context, fn func(*bolt.Tx) error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return db.View(fn)
+}
+
+// update runs a read-write transaction, unless the context is done already.
+func (db *Database) update(ctx context.Context, fn func(*bolt.Tx) error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return db.Update(fn)
+}
+
+// OpenDatabase opens the database for the commands run next to the server,
+// without resetting anything. It gives up after a second if the server has
+// it open.
+func OpenDatabase(loc string, readOnly bool) (*Database, error) {
+	b, err := bolt.Open(loc, 0600, &bolt.Options{Timeout: time.Second, ReadOnly: readOnly})
+	if err == bolt.ErrTimeout {
+		return nil, fmt.Errorf("%s is in use, stop the server first", loc)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Database error (%s)", err)
+	}
+	return &Database{DB: b}, nil
+}
+
+// Backup writes a copy of the whole database, as it is in one transaction.
+func (db *Database) Backup(w io.Writer) error {
+	return db.View(func(tx *bolt.Tx) error {
+		_, err := tx.WriteTo(w)
+		return err
+	})
+}
+
+// NewHostKey makes a new host key for the server, in place of the one it
+// had.
+func (db *Database) NewHostKey() (ssh.Signer, error) {
+	val, err := genPrivateKey()
+	if err != nil {
+		return nil, err
+	}
+	key, err := ssh.ParsePrivateKey(val)
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists(configBucket)
+		if err != nil {
+			return err
+		}
+		return b.Put(configSSHKey, val)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// HasHostKey reports whether the server has a host key already.
+func (db *Database) HasHostKey() bool {
+	found := false
+	db.View(func(tx *bolt.Tx) error {
+		if b := tx.Bucket(configBucket); b != nil {
+			found = b.Get(configSSHKey) != nil
+		}
+		return nil
+	})
+	return found
+}
+
+// CharacterKey returns the key the server signs the characters it exports
+// with, made the first time it is asked for.
+func (db *Database) CharacterKey() ([]byte, error) {
+	var key []byte
+	err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists(configBucket)
+		if err != nil {
+			return err
+		}
+		if k := b.Get(configCharacterKey); k != nil {
+			key = append([]byte(nil), k...)
+			return nil
+		}
+		key = make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			return err
+		}
+		return b.Put(configCharacterKey, key)
+	})
+	return key, err
+}
+
+// rewriteBucket hands every entry of the bucket to change, and puts back what
+// it returns, or deletes the entry when it returns nil. Buckets that are not
+// there have nothing to change.
+func rewriteBucket(b *bolt.Bucket, change func(k, v []byte) ([]byte, error)) error {
+	if b == nil {
+		return nil
+	}
+	keys, vals := [][]byte{}, [][]byte{}
+	err := b.ForEach(func(k, v []byte) error {
+		val, err := change(k, v)
+		if err != nil {
+			return err
+		}
+		if val == nil || string(val) != string(v) {
+			keys = append(keys, append([]byte(nil), k...))
+			vals = append(vals, val)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for i, k := range keys {
+		if vals[i] == nil {
+			err = b.Delete(k)
+		} else {
+			err = b.Put(k, vals[i])
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ForgetPlayer purges what the database holds of the player whose account was
+// deleted: where they logged in from, their links, notifications and hints,
+// and their mail. What they did in the world stays, with alias in place of
+// their name.
+func (db *Database) ForgetPlayer(ctx context.Context, nick, alias string) error {
+	return db.update(ctx, func(tx *bolt.Tx) error {
+		id := Identity{}
+		if b := tx.Bucket(identityBucket); b != nil {
+			if val := b.Get([]byte(nick)); val != nil {
+				if err := json.Unmarshal(val, &id); err != nil {
+					return err
+				}
+			}
+			if err := b.Delete([]byte(nick)); err != nil {
+				return err
+			}
+		}
+		// The hints go with the keys and the IPs no one else logged in with.
+		shared := map[string]bool{}
+		err := rewriteBucket(tx.Bucket(sightingBucket), func(k, v []byte) ([]byte, error) {
+			seen := map[string]time.Time{}
+			if err := json.Unmarshal(v, &seen); err != nil {
+				return nil, err
+			}
+			if _, ok := seen[nick]; !ok {
+				return v, nil
+			}
+			delete(seen, nick)
+			if len(seen) == 0 {
+				return nil, nil
+			}
+			shared[string(k)] = true
+			return json.Marshal(seen)
+		})
+		if err != nil {
+			return err
+		}
+		if b := tx.Bucket(hintBucket); b != nil {
+			for kind, values := range map[string]map[string]time.Time{"key": id.Fingerprints, "ip": id.IPs} {
+				for value := range values {
+					if shared[string(sightingKey(kind, value))] {
+						continue
+					}
+					if err := b.Delete([]byte(value)); err != nil {
+						return err
+					}
+				}
+			}
+		}
+
+		if b := tx.Bucket(notificationBucket); b != nil {
+			if err := b.Delete([]byte(nick)); err != nil {
+				return err
+			}
+		}
+		err = rewriteBucket(tx.Bucket(notificationBucket), func(k, v []byte) ([]byte, error) {
+			inbox := []Notification{}
+			if err := json.Unmarshal(v, &inbox); err != nil {
+				return nil, err
+			}
+			for i := range inbox {
+				if inbox[i].From == nick {
+					inbox[i].From = alias
+				}
+				inbox[i].Text = anonymize(inbox[i].Text, nick, alias)
+			}
+			return json.Marshal(inbox)
+		})
+		if err != nil {
+			return err
+		}
+		err = rewriteBucket(tx.Bucket(linkBucket), func(k, v []byte) ([]byte, error) {
+			l := Link{}
+			if err := json.Unmarshal(v, &l); err != nil {
+				return nil, err
+			}
+			if strings.EqualFold(l.Player, nick) {
+				return nil, nil
+			}
+			return v, nil
+		})
+		if err != nil {
+			return err
+		}
+
+		// Mail sent to the player goes back to its sender if it still holds
+		// something, and what they sent others is left to claim, without
+		// their name or their words.
+		err = rewriteBucket(tx.Bucket(mailBucket), func(k, v []byte) ([]byte, error) {
+			m := Mail{}
+			if err := json.Unmarshal(v, &m); err != nil {
+				return nil, err
+			}
+			switch {
+			case m.To == nick && m.From != nick && m.Status == mailSent && !m.Returned && (len(m.Items) > 0 || m.Gold > 0):
+				m.To, m.From = m.From, alias
+				m.Returned = true
+				m.Sent = time.Now()
+			case m.To == nick:
+				return nil, nil
+			case m.From == nick:
+				m.From = alias
+				m.Text = ""
+			default:
+				return v, nil
+			}
+			return json.Marshal(m)
+		})
+		if err != nil {
+			return err
+		}
+
+		err = rewriteBucket(tx.Bucket(historyBucket), func(k, v []byte) ([]byte, error) {
+			e := HistoryEntry{}
+			if err := json.Unmarshal(v, &e); err != nil {
+				return nil, err
+			}
+			if e.Player == nick {
+				e.Player = alias
+			}
+			e.Text = anonymize(e.Text, nick, alias)
+			return json.Marshal(e)
+		})
+		if err != nil {
+			return err
+		}
+		err = rewriteBucket(tx.Bucket(newsBucket), func(k, v []byte) ([]byte, error) {
+			n := NewsItem{}
+			if err := json.Unmarshal(v, &n); err != nil {
+				return nil, err
+			}
+			if n.Player == nick {
+				n.Player = alias
+			}
+			n.Title = anonymize(n.Title, nick, alias)
+			n.Text = anonymize(n.Text, nick, alias)
+			return json.Marshal(n)
+		})
+		if err != nil {
+			return err
+		}
+		err = rewriteBucket(tx.Bucket(auditBucket), func(k, v []byte) ([]byte, error) {
+			e := AuditEntry{}
+			if err := json.Unmarshal(v, &e); err != nil {
+				return nil, err
+			}
+			if e.Player == nick {
+				e.Player = alias
+			}
+			if e.Admin == nick {
+				e.Admin = alias
+			}
+			e.Detail = anonymize(e.Detail, nick, alias)
+			return json.Marshal(e)
+		})
+		if err != nil {
+			return err
+		}
+		return rewriteBucket(tx.Bucket(reportBucket), func(k, v []byte) ([]byte, error) {
+			r := Report{}
+			if err := json.Unmarshal(v, &r); err != nil {
+				return nil, err
+			}
+			if r.Player == nick {
+				r.Player = alias
+				r.Recent = nil
+			}
+			if r.ClaimedBy == nick {
+				r.ClaimedBy = alias
+			}
+			r.Text = anonymize(r.Text, nick, alias)
+			r.Resolution = anonymize(r.Resolution, nick, alias)
+			return json.Marshal(r)
+		})
+	})
+}
+
+// ForgetInLog drops the log entries of the player, and puts alias in place of
+// their name in those of the others.
+func (db *Database) ForgetInLog(ctx context.Context, nick, alias string) error {
+	return db.update(ctx, func(tx *bolt.Tx) error {
+		root := tx.Bucket(chatLogBucket)
+		if root == nil {
+			return nil
+		}
+		streams := [][]byte{}
+		root.ForEach(func(k, v []byte) error {
+			streams = append(streams, append([]byte(nil), k...))
+			return nil
+		})
+		for _, stream := range streams {
+			err := rewriteBucket(root.Bucket(stream), func(k, v []byte) ([]byte, error) {
+				e := LogEntry{}
+				if err := json.Unmarshal(v, &e); err != nil {
+					return nil, err
+				}
+				if e.Player == nick {
+					return nil, nil
+				}
+				e.Text = anonymize(e.Text, nick, alias)
+				return json.Marshal(e)
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// PruneAudit drops the entries of the audit log from before the time. It
+// returns how many it dropped.
+func (db *Database) PruneAudit(ctx context.Context, before time.Time) (int, error) {
+	dropped := 0
+	err := db.update(ctx, func(tx *bolt.Tx) error {
+		b := tx.Bucket(auditBucket)
+		if b == nil {
+			return nil
+		}
+		// Entries are kept in the order they were added.
+		c := b.Cursor()
+		for k, v := c.First(); k != nil; k, v = c.First() {
+			e := AuditEntry{}
+			if err := json.Unmarshal(v, &e); err != nil {
+				return err
+			}
+			if !e.Time.Before(before) {
+				break
+			}
+			if err := c.Delete(); err != nil {
+				return err
+			}
+			dropped++
+		}
+		return nil
+	})
+	return dropped, err
+}
+
+// PruneLogins forgets the key fingerprints and the IPs players were last seen
+// with before the time.
+func (db *Database) PruneLogins(ctx context.Context, before time.Time) error {
+	return db.update(ctx, func(tx *bolt.Tx) error {
+		err := rewriteBucket(tx.Bucket(identityBucket), func(k, v []byte) ([]byte, error) {
+			id := Identity{}
+			if err := json.Unmarshal(v, &id); err != nil {
+				return nil, err
+			}
+			for _, seen := range []map[string]time.Time{id.Fingerprints, id.IPs} {
+				for value, at := range seen {
+					if at.Before(before) {
+						delete(seen, value)
+					}
+				}
+			}
+			if len(id.Fingerprints) == 0 && len(id.IPs) == 0 {
+				return nil, nil
+			}
+			return json.Marshal(id)
+		})
+		if err != nil {
+			return err
+		}
+		return rewriteBucket(tx.Bucket(sightingBucket), func(k, v []byte) ([]byte, error) {
+			seen := map[string]time.Time{}
+			if err := json.Unmarshal(v, &seen); err != nil {
+				return nil, err
+			}
+			for nick, at := range seen {
+				if at.Before(before) {
+					delete(seen, nick)
+				}
+			}
+			if len(seen) == 0 {
+				return nil, nil
+			}
+			return json.Marshal(seen)
+		})
+	})
+}
+
+// FeatureSwitch is a subsystem an admin turned off, or back on, while the
+// server runs.
+type FeatureSwitch struct {
+	Feature string    `json:"feature"`
+	Off     bool      `json:"off"`
+	By      string    `json:"by"`
+	Reason  string    `json:"reason,omitempty"`
+	Changed time.Time `json:"changed"`
+}
+
+// GetFeatures returns the switches of the subsystems, by their name.
+func (db *Database) GetFeatures(ctx context.Context) (map[string]FeatureSwitch, error) {
+	switches := map[string]FeatureSwitch{}
+	err := db.view(ctx, func(tx *bolt.Tx) error {
+		b := tx.Bucket(featureBucket)
+		if b == nil {
+			return nil
+		}
+		return b.ForEach(func(k, v []byte) error {
+			f := FeatureSwitch{}
+			if err := json.Unmarshal(v, &f); err != nil {
+				return err
+			}
+			switches[string(k)] = f
+			return nil
+		})
+	})
+	return switches, err
+}
+
+// PutFeature keeps the switch of the subsystem. Subsystems turned back on
+// are kept too, so who turned them on and why is not lost.
+func (db *Database) PutFeature(ctx context.Context, f FeatureSwitch) error {
+	return db.update(ctx, func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists(featureBucket)
+		if err != nil {
+			return err
+		}
+		v, err := json.Marshal(f)
+		if err != nil {
+			return err
+		}
+		return b.Put([]byte(f.Feature), v)
+	})
+}
@@ -0,0 +1,185 @@
+package server
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/droslean/thyranew/area"
+	"github.com/droslean/thyranew/game"
+)
+
+const (
+	// corpseDecay is how long a corpse lasts before it rots away with its items.
+	corpseDecay = 30 * time.Minute
+	// resurrectionPrice is what healers charge for bringing a ghost back.
+	resurrectionPrice = 20
+	// deathWear is how much wear dying puts on the gear of the dead.
+	deathWear = 10
+	// resurrectionScroll is the item players use to resurrect each other.
+	resurrectionScroll = "Scroll of Resurrection"
+)
+
+// ghostCommands are all that ghosts can do.
+var ghostCommands = map[string]bool{
+	"": true, "quit": true, "release": true, "revive": true,
+	"time": true, "weather": true, "scan": true, "reputation": true,
+	"talk": true, "say": true, "bye": true,
+	"terminal": true, "mouse": true, "scroll": true, "walk": true,
+	"settings": true, "describe": true, "look": true,
+	"title": true, "keywords": true, "achievements": true,
+	"flag": true, "follow": true, "wanted": true,
+	"hints": true, "tutorial": true, "score": true, "compare": true,
+	"inspect": true, "keys": true, "tactics": true,
+	"cooldowns": true, "skills": true, "practice": true,
+	"history": true, "obituaries": true, "link": true, "unlink": true, "export": true, "delete": true,
+	"tell": true, "cluster": true, "intermud": true, "who": true,
+}
+
+// defaultBind is where players respawn unless they are bound elsewhere.
+var defaultBind = area.Exit{ToArea: "City", ToRoom: "Inn", ToCubeID: "1"}
+
+// Corpse holds what a dead player left behind.
+type Corpse struct {
+	area.Location
+	Owner string
+	Items []area.GroundItem // Lying where the corpse does, for the owner alone
+	Gold  int
+}
+
+// killPlayer turns the player into a ghost and leaves a corpse behind. What is
+// lost on death depends on the settings of the server.
+func (s *Server) killPlayer(roomsMap map[string]map[string][][]area.Cube, p *area.Player, killer string) {
+	p.HP = 0
+	p.Ghost = true
+	p.Effects = nil
+	wearGear(p, deathWear)
+
+	corpse := Corpse{Location: p.Location, Owner: p.Nickname}
+	switch s.config.ItemLoss {
+	case "keep":
+	case "destroy":
+		s.goldFlow(flowLost, -p.Gold)
+		// Items bound to the player are never lost.
+		p.DropLoose()
+		p.Gold = 0
+	default:
+		loose := p.DropLoose()
+		corpse.Items = loose.Lay(p.Location, p.Nickname)
+		corpse.Gold, p.Gold = p.Gold, 0
+	}
+	s.corpses[p.Nickname] = corpse
+	s.after(corpseDecay, func() {
+		if c, ok := s.corpses[corpse.Owner]; ok && c.Area == corpse.Area && c.Room == corpse.Room {
+			delete(s.corpses, corpse.Owner)
+			s.goldFlow(flowLost, -c.Gold)
+			if len(c.Items) > 0 || c.Gold > 0 {
+				s.notify(roomsMap, c.Owner, "System", fmt.Sprintf("Your corpse in %s rotted away with everything on it", c.Room))
+			}
+		}
+	})
+
+	lost := game.XPLoss(p.XP, s.config.XPLoss)
+	p.XP -= lost
+
+	s.printToRoom(roomsMap, p.Area, p.Room, tagged(TagCombat, fmt.Sprintf("%s dies\n", p.Nickname)))
+	if cl, ok := s.clientByNick(p.Nickname); ok {
+		msg := "You are dead. Type release to return as a ghost\n"
+		if lost > 0 {
+			msg += fmt.Sprintf("You lose %d experience\n", lost)
+		}
+		s.godPrintRoom([]Client{*cl}, roomsMap, tagged(TagCombat, msg), "")
+	}
+	s.hint(roomsMap, p, "death")
+
+	s.publish(WorldEvent{Type: EventPlayerDied, Player: p.Nickname, Area: p.Area, Room: p.Room, By: killer})
+	s.endFight(roomsMap, p.Nickname)
+}
+
+// release sends the ghost of the player to its bind point.
+func (s *Server) release(roomsMap map[string]map[string][][]area.Cube, p *area.Player) string {
+	if !p.Ghost {
+		return "You are not dead\n"
+	}
+	bind := p.Bind
+	if bind.ToArea == "" {
+		bind = defaultBind
+	}
+	if p.Area == bind.ToArea && p.Room == bind.ToRoom {
+		return "Your spirit is already home\n"
+	}
+	s.teleport(roomsMap, p, bind, "release")
+	s.publish(WorldEvent{Type: EventPlayerReleased, Player: p.Nickname, Area: p.Area, Room: p.Room})
+	return ""
+}
+
+// revive brings the ghost back to life at its corpse, with all it left there.
+func (s *Server) revive(p *area.Player) string {
+	if !p.Ghost {
+		return "You are not dead\n"
+	}
+	corpse, ok := s.corpses[p.Nickname]
+	if !ok || corpse.Area != p.Area || corpse.Room != p.Room {
+		return "Your corpse is not here\n"
+	}
+	delete(s.corpses, p.Nickname)
+	for _, item := range corpse.Items {
+		p.GiveItem(item.ItemInstance)
+	}
+	p.Gold += corpse.Gold
+	s.resurrect(p, game.MaxHP(&p.PC)/2, "corpse")
+	return fmt.Sprintf("%s rises from the dead\n", p.Nickname)
+}
+
+// resurrectOther brings another player back to life with a scroll.
+// Usage: resurrect <player>
+func (s *Server) resurrectOther(p *area.Player, args []string) string {
+	name := strings.Join(args, " ")
+	ghost, ok := s.playerHere(p, name)
+	if !ok || !ghost.Ghost {
+		return fmt.Sprintf("There is no ghost of %s here\n", name)
+	}
+	if !p.RemoveItem(resurrectionScroll) {
+		return fmt.Sprintf("You need a %s\n", resurrectionScroll)
+	}
+	s.resurrect(ghost, game.MaxHP(&ghost.PC)/2, p.Nickname)
+	return fmt.Sprintf("%s reads a scroll and %s comes back to life\n", p.Nickname, ghost.Nickname)
+}
+
+// resurrect brings the ghost back to life with the given hit points.
+func (s *Server) resurrect(p *area.Player, hp int, by string) {
+	if hp < 1 {
+		hp = 1
+	}
+	p.Ghost = false
+	p.HP = hp
+	s.publish(WorldEvent{Type: EventPlayerResurrected, Player: p.Nickname, Area: p.Area, Room: p.Room, By: by})
+}
+
+// healerResurrection is the service of healer NPCs: they bring ghosts back
+// where they stand, for a price and with resurrection sickness. The corpse
+// stays where it fell.
+func healerResurrection(s *Server, p *area.Player, npc *area.NPC) string {
+	if !p.Ghost {
+		return fmt.Sprintf("%s sees nothing wrong with you\n", npc.Name)
+	}
+	if p.Gold < resurrectionPrice {
+		return fmt.Sprintf("%s asks for %d gold\n", npc.Name, resurrectionPrice)
+	}
+	p.Gold -= resurrectionPrice
+	s.goldFlow(flowServices, -resurrectionPrice)
+	s.resurrect(p, 1, npc.Name)
+	addEffect(p, game.Effect{Name: "Resurrection Sickness", Ticks: 30})
+	return fmt.Sprintf("%s chants over you and you come back to life, feeling weak\n", npc.Name)
+}
+
+// onPlayerDied lets the systems the dead player was busy with forget about it.
+func (s *Server) onPlayerDied(e WorldEvent) {
+	cl, ok := s.clientByNick(e.Player)
+	if !ok {
+		return
+	}
+	endConversation(cl.Player)
+	cl.Player.Resting = game.Awake
+	cl.Player.HideRoll = 0
+}
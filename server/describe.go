@@ -0,0 +1,233 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/droslean/thyranew/area"
+	"github.com/droslean/thyranew/game"
+
+	log "gopkg.in/inconshreveable/log15.v2"
+)
+
+// keywordPattern is what keywords look like: a word of letters.
+var keywordPattern = regexp.MustCompile(`^[a-z]{1,20}$`)
+
+// describe opens the editor on the description of the player, or, for
+// builders, on the description of the room they are in.
+// Usage: describe [room]
+func (s *Server) describe(c *Client, args []string) string {
+	p := c.Player
+	if len(args) == 0 {
+		s.openModal(c, newEditor("Your description", p.Description, func(s *Server, c *Client, text string) string {
+			c.Player.Description = text
+			return s.savePreferences(c.Player, "Your description is saved\n")
+		}))
+		return ""
+	}
+	if len(args) != 1 || strings.ToLower(args[0]) != "room" {
+		return "Usage: describe [room]\n"
+	}
+	if !isBuilder(p) {
+		return "Only builders can describe rooms\n"
+	}
+	areaName, roomName := p.Area, p.Room
+	room, ok := s.Areas[areaName].Rooms[roomName]
+	if !ok {
+		return "This place can't be described\n"
+	}
+	s.openModal(c, newEditor("Description of "+room.Name, room.Description, func(s *Server, c *Client, text string) string {
+		return s.setRoomDescription(c.Player, areaName, roomName, text)
+	}))
+	return ""
+}
+
+// setRoomDescription gives the room the description the builder wrote, which
+// stands in for the one of the area file from then on. When the edits are
+// reviewed, it goes in the staged area file for an admin to publish instead.
+func (s *Server) setRoomDescription(builder *area.Player, areaName, roomName, text string) string {
+	room, ok := s.Areas[areaName].Rooms[roomName]
+	if !ok {
+		return "The room is gone\n"
+	}
+	if strings.TrimSpace(text) == "" {
+		return "Rooms can't be left without a description\n"
+	}
+	if s.config.Review {
+		return s.stageRoomDescription(builder, areaName, roomName, text)
+	}
+	if err := s.db.PutRoomDescription(s.ctxOf(builder.Nickname), areaName, roomName, text); err != nil {
+		log.Error(fmt.Sprintf("Cannot keep the description of %s/%s: %v", areaName, roomName, err))
+		return "The description can't be saved right now\n"
+	}
+	room.Description = text + "\n"
+	s.Areas[areaName].Rooms[roomName] = room
+	log.Info(fmt.Sprintf("%q described %s/%s", builder.Nickname, areaName, roomName))
+	return fmt.Sprintf("The description of %s is saved\n", room.Name)
+}
+
+// stageRoomDescription writes the description the builder wrote in the
+// staged area file, where an admin reviews it.
+func (s *Server) stageRoomDescription(builder *area.Player, areaName, roomName, text string) string {
+	if _, ok := s.areaFile(areaName); !ok {
+		return "Only the rooms of area files can be described\n"
+	}
+	_, err := s.editArea(areaName, builder.Nickname, false, func(a *area.Area) error {
+		room, ok := a.Rooms[roomName]
+		if !ok {
+			return fmt.Errorf("the room is not in the file")
+		}
+		room.Description = text + "\n"
+		a.Rooms[roomName] = room
+		return nil
+	})
+	if err != nil {
+		log.Error(fmt.Sprintf("Cannot stage the description of %s/%s: %v", areaName, roomName, err))
+		return "The description can't be staged right now\n"
+	}
+	return fmt.Sprintf("The description of %s waits for an admin to publish it\n", roomName)
+}
+
+// loadRoomDescriptions puts the descriptions builders wrote in place of those
+// of the area files.
+func (s *Server) loadRoomDescriptions() error {
+	descriptions, err := s.db.RoomDescriptions(context.Background())
+	if err != nil {
+		return err
+	}
+	for key, text := range descriptions {
+		parts := strings.SplitN(key, "/", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		room, ok := s.Areas[parts[0]].Rooms[parts[1]]
+		if !ok {
+			log.Warn(fmt.Sprintf("The description of %s is for a room that is gone", key))
+			continue
+		}
+		room.Description = text + "\n"
+		s.Areas[parts[0]].Rooms[parts[1]] = room
+	}
+	return nil
+}
+
+// keywords shows or sets the keywords others can call the player by.
+// Usage: keywords [word ...|none]
+func (s *Server) keywords(p *area.Player, args []string) string {
+	if len(args) == 0 {
+		if len(p.Keywords) == 0 {
+			return "Others call you by your name alone\n"
+		}
+		return fmt.Sprintf("Others can call you %s\n", strings.Join(p.Keywords, ", "))
+	}
+	if len(args) == 1 && strings.EqualFold(args[0], "none") {
+		p.Keywords = nil
+		return s.savePreferences(p, "Others call you by your name alone\n")
+	}
+	if len(args) > maxKeywords {
+		return fmt.Sprintf("You can have at most %d keywords\n", maxKeywords)
+	}
+	words := []string{}
+	for _, w := range args {
+		w = strings.ToLower(w)
+		if !keywordPattern.MatchString(w) {
+			return fmt.Sprintf("%q can't be a keyword, use letters alone\n", w)
+		}
+		words = append(words, w)
+	}
+	p.Keywords = words
+	return s.savePreferences(p, fmt.Sprintf("Others can call you %s\n", strings.Join(words, ", ")))
+}
+
+// playerHere returns the player in the room of the viewer that goes by the
+// name: their nickname, or some of their keywords.
+func (s *Server) playerHere(viewer *area.Player, name string) (*area.Player, bool) {
+	words := strings.Fields(strings.ToLower(name))
+	var match *area.Player
+	for _, c := range s.OnlineClientsGetByRoom(viewer.Area, viewer.Room) {
+		p := c.Player
+		if !canSee(viewer, p) {
+			continue
+		}
+		if strings.EqualFold(p.Nickname, name) {
+			return p, true
+		}
+		if match == nil && len(words) > 0 && calledBy(p, words) {
+			match = p
+		}
+	}
+	return match, match != nil
+}
+
+// calledBy reports whether every word is a keyword of the player.
+func calledBy(p *area.Player, words []string) bool {
+	for _, w := range words {
+		found := false
+		for _, k := range p.Keywords {
+			if k == w {
+				found = true
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// condition tells how hurt the player looks.
+func condition(p *area.Player) string {
+	if p.Ghost {
+		return "is a ghost"
+	}
+	max := game.MaxHP(&p.PC)
+	switch hp := p.HP * 100 / max; {
+	case hp >= 100:
+		return "is in perfect health"
+	case hp >= 75:
+		return "has a few scratches"
+	case hp >= 50:
+		return "is hurt"
+	case hp >= 25:
+		return "is badly hurt"
+	}
+	return "is near death"
+}
+
+// look shows another player in the room: who they are as they described
+// themselves, what they wield and wear, and how hurt they look.
+// Usage: look <player>
+func (s *Server) look(p *area.Player, args []string) string {
+	if len(args) == 0 {
+		return "Usage: look <player>\n"
+	}
+	o, ok := s.playerHere(p, strings.Join(args, " "))
+	if !ok {
+		return fmt.Sprintf("You don't see %s here\n", strings.Join(args, " "))
+	}
+	desc := strings.TrimSpace(stripMarkup(o.Description))
+	if desc == "" {
+		desc = "You see nothing special."
+	}
+	lines := []string{fmt.Sprintf("%s, a level %d %s", displayName(o), o.Level, o.Class), desc}
+	if tags := flagTags(o); len(tags) > 0 {
+		lines[0] += fmt.Sprintf(" [%s]", strings.Join(tags, ", "))
+	}
+	gear := []string{}
+	if o.Weapon != "" {
+		gear = append(gear, "wields "+o.Weapon)
+	}
+	if o.OffHand != "" {
+		gear = append(gear, "holds "+o.OffHand)
+	}
+	if o.Armor != "" {
+		gear = append(gear, "wears "+o.Armor)
+	}
+	if len(gear) > 0 {
+		lines = append(lines, fmt.Sprintf("%s %s", o.Nickname, strings.Join(gear, " and ")))
+	}
+	lines = append(lines, fmt.Sprintf("%s %s", o.Nickname, condition(o)))
+	return strings.Join(lines, "\n") + "\n"
+}
@@ -0,0 +1,140 @@
+package server
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	runtimepprof "runtime/pprof"
+	"sync"
+	"time"
+
+	log "gopkg.in/inconshreveable/log15.v2"
+)
+
+// Diagnostics are the stats of the server and the runtime it runs on.
+type Diagnostics struct {
+	World      WorldHealth
+	Players    int // Players in the world, linkdead or not
+	Linkdead   int
+	EventQueue int // Commands waiting for the world
+	Goroutines int
+	HeapAlloc  uint64 // Bytes of the heap in use
+	HeapObjs   uint64
+	NumGC      uint32
+	PauseTotal time.Duration // Time the garbage collector stopped the world for
+}
+
+// diagnostics gathers the stats of the server. It is safe to call from
+// anywhere.
+func (s *Server) diagnostics() Diagnostics {
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	d := Diagnostics{
+		World:      s.world.Health(),
+		EventQueue: len(s.Events),
+		Goroutines: runtime.NumGoroutine(),
+		HeapAlloc:  mem.HeapAlloc,
+		HeapObjs:   mem.HeapObjects,
+		NumGC:      mem.NumGC,
+		PauseTotal: time.Duration(mem.PauseTotalNs),
+	}
+	for _, c := range s.OnlineClients() {
+		d.Players++
+		if c.state() == StateLinkdead {
+			d.Linkdead++
+		}
+	}
+	return d
+}
+
+// publishOnce keeps expvar from publishing the stats twice, which it doesn't
+// allow.
+var publishOnce sync.Once
+
+// startDiagnostics serves pprof, expvar, goroutine dumps, the stats of the
+// server and the admin API over HTTP on the given address. Those of the
+// other worlds of a host are under /worlds/<name>/.
+func (s *Server) startDiagnostics(addr string, others []*Server) {
+	publishOnce.Do(func() {
+		expvar.Publish("thyra", expvar.Func(func() interface{} { return s.diagnostics() }))
+	})
+
+	mux := s.diagnosticsMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.Handle("/debug/vars", expvar.Handler())
+	mux.HandleFunc("/debug/goroutines", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		runtimepprof.Lookup("goroutine").WriteTo(w, 2)
+	})
+	for _, o := range others {
+		prefix := "/worlds/" + o.name
+		mux.Handle(prefix+"/", http.StripPrefix(prefix, o.diagnosticsMux()))
+	}
+
+	go func() {
+		log.Info(fmt.Sprintf("Serving diagnostics on http://%s/debug/", addr))
+		if err := http.ListenAndServe(addr, mux); err != nil {
+			log.Error(fmt.Sprintf("Cannot serve diagnostics: %v", err))
+		}
+	}()
+}
+
+// diagnosticsMux returns the handlers of the stats and the admin API of the
+// world. Those of the process are left to startDiagnostics.
+func (s *Server) diagnosticsMux() *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/stats", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(s.diagnostics())
+	})
+	mux.HandleFunc("/admin/reports", s.adminAPI(s.serveReports))
+	mux.HandleFunc("/admin/profile", s.adminAPI(s.serveProfile))
+	mux.HandleFunc("/admin/obituaries", s.adminAPI(s.serveObituaries))
+	mux.HandleFunc("/admin/links", s.adminAPI(s.serveLinks))
+	mux.HandleFunc("/admin/areas", s.adminAPI(s.serveAreas))
+	mux.HandleFunc("/admin/areas/", s.adminAPI(s.serveAreas))
+	mux.HandleFunc("/admin/items", s.adminAPI(s.serveItems))
+	mux.HandleFunc("/admin/items/", s.adminAPI(s.serveItems))
+	mux.HandleFunc("/admin/staging", s.adminAPI(s.serveStaging))
+	mux.HandleFunc("/admin/staging/", s.adminAPI(s.serveStaging))
+	mux.HandleFunc("/admin/maintenance", s.adminAPI(s.serveMaintenance))
+	mux.HandleFunc("/admin/features", s.adminAPI(s.serveFeatures))
+	return mux
+}
+
+// adminAPI lets only the callers with the admin token through to the
+// handler, when there is a token.
+func (s *Server) adminAPI(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token := s.config.AdminToken
+		if token != "" && subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte("Bearer "+token)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		h(w, r)
+	}
+}
+
+// diag sums up the diagnostics for admins.
+func (s *Server) diag() string {
+	d := s.diagnostics()
+	if !d.World.Running {
+		return "The world is stopped\n"
+	}
+	load := ""
+	if d.World.Shedding {
+		load = ", shedding load"
+	}
+	return fmt.Sprintf("%d players, %d linkdead\n%d commands and %d tasks waiting, tick lag %s, tick took %s%s\n%d goroutines, %d KB heap in %d objects\n%d GCs, paused %s in all\n",
+		d.Players, d.Linkdead, d.EventQueue, d.World.Queued, d.World.TickLag.Round(time.Microsecond), d.World.TickTime.Round(time.Microsecond), load,
+		d.Goroutines, d.HeapAlloc/1024, d.HeapObjs, d.NumGC, d.PauseTotal.Round(time.Microsecond))
+}
@@ -0,0 +1,222 @@
+package server
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/droslean/thyranew/area"
+	"github.com/droslean/thyranew/game"
+	"github.com/gothyra/toml"
+
+	log "gopkg.in/inconshreveable/log15.v2"
+)
+
+// dialogueScripts are the scripts dialogue options can run, by name.
+var dialogueScripts = map[string]func(s *Server, p *area.Player, npc *area.NPC) string{
+	// rest lets the player sleep at the inn for a couple of gold coins.
+	"rest": func(s *Server, p *area.Player, npc *area.NPC) string {
+		const price = 2
+		if p.Gold < price {
+			return fmt.Sprintf("%s shakes their head: a bed costs %d gold\n", npc.Name, price)
+		}
+		p.Gold -= price
+		s.goldFlow(flowServices, -price)
+		p.HP = game.MaxHP(&p.PC)
+		return "You sleep soundly and wake up rested\n"
+	},
+	"resurrect": healerResurrection,
+	"repair":    repairGear,
+	"identify":  identifyGear,
+	"enchant":   enchantWeapon,
+}
+
+// loadDialogues loads all the dialogues from the static directory into memory.
+func (s *Server) loadDialogues() error {
+	log.Info("Loading dialogues ...")
+	dialogueWalker := func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+
+		fileContent, fileIoErr := ioutil.ReadFile(path)
+		if fileIoErr != nil {
+			log.Info(fmt.Sprintf("%s could not be loaded: %v", path, fileIoErr))
+			return fileIoErr
+		}
+
+		dialogue := area.Dialogue{}
+		if _, err := toml.Decode(string(fileContent), &dialogue); err != nil {
+			log.Info(fmt.Sprintf("%s could not be unmarshaled: %v", path, err))
+			return err
+		}
+
+		log.Info(fmt.Sprintf("Loaded dialogue of %q", dialogue.NPC))
+		s.Dialogues[dialogue.NPC] = dialogue
+
+		return nil
+	}
+
+	return filepath.Walk(s.staticDir+"/dialogues/", dialogueWalker)
+}
+
+// talk starts a conversation with an NPC in the room of the player.
+func (s *Server) talk(p *area.Player, args []string) string {
+	name := strings.ToLower(strings.Join(args, " "))
+	if name == "" {
+		return "Who do you want to talk to?\n"
+	}
+
+	for _, npc := range s.npcsInRoom(p.Area, p.Room) {
+		if !strings.HasPrefix(strings.ToLower(npc.Name), name) {
+			continue
+		}
+		if npc.Target != "" {
+			return fmt.Sprintf("%s is in no mood for talking\n", npc.Name)
+		}
+		if npc.Activity == "sleeping" {
+			return fmt.Sprintf("%s is fast asleep\n", npc.Name)
+		}
+		d, ok := s.Dialogues[npc.Name]
+		if !ok {
+			return fmt.Sprintf("%s has nothing to say to you\n", npc.Name)
+		}
+		node, ok := d.Greeting(p, s.standingOf(p))
+		if !ok {
+			return fmt.Sprintf("%s ignores you\n", npc.Name)
+		}
+		p.Talking = npc.ID
+		p.Shop = ""
+		return s.sayNode(p, npc.Name, node)
+	}
+	return fmt.Sprintf("There is no %s here\n", name)
+}
+
+// respond answers the NPC the player is talking to, either with the number of
+// an option or with words that match its keywords.
+func (s *Server) respond(p *area.Player, said string) string {
+	npc, ok := s.talkingTo(p)
+	if !ok {
+		return "You are not talking to anyone\n"
+	}
+	node, ok := s.Dialogues[npc.Name].Node(p.DialogueNode)
+	if !ok {
+		return fmt.Sprintf("%s has nothing more to say\n", npc.Name)
+	}
+	options := node.Available(p, s.standingOf(p), s.contentOn)
+
+	var option area.DialogueOption
+	if n, err := strconv.Atoi(said); err == nil {
+		if n < 1 || n > len(options) {
+			return fmt.Sprintf("Pick an answer from 1 to %d\n", len(options))
+		}
+		option = options[n-1]
+	} else if option, ok = area.Match(options, said); !ok {
+		return fmt.Sprintf("%s does not follow you\n", npc.Name)
+	}
+
+	msg := s.runHooks(p, npc, option)
+	next, ok := s.Dialogues[npc.Name].Node(option.Next)
+	if !ok {
+		p.DialogueNode = ""
+		return msg
+	}
+	return msg + s.sayNode(p, npc.Name, next)
+}
+
+// runHooks applies what picking the option does, other than moving the conversation on.
+func (s *Server) runHooks(p *area.Player, npc *area.NPC, option area.DialogueOption) string {
+	msg := ""
+	if option.Quest != "" {
+		state := option.State
+		if state == "" {
+			state = "started"
+		}
+		if p.Quests == nil {
+			p.Quests = make(map[string]string)
+		}
+		p.Quests[option.Quest] = state
+		if err := s.savePlayer(p); err != nil {
+			log.Error(fmt.Sprintf("Cannot save player %q: %v", p.Nickname, err))
+		}
+		msg += fmt.Sprintf("Quest %s: %s\n", option.Quest, state)
+		if state == "done" {
+			s.publish(WorldEvent{Type: EventQuestDone, Player: p.Nickname, Area: p.Area, Room: p.Room, Detail: option.Quest})
+		}
+	}
+	if option.Shop != "" {
+		msg += s.openShop(p, npc, option.Shop)
+	}
+	if option.Script != "" {
+		script, ok := dialogueScripts[option.Script]
+		if !ok {
+			log.Error(fmt.Sprintf("Dialogue of %q runs unknown script %q", npc.Name, option.Script))
+		} else {
+			msg += script(s, p, npc)
+		}
+	}
+	return msg
+}
+
+// sayNode makes the NPC say the node and lists the answers of the player. The
+// message canvas fits only a few lines, so the extra answers are left out of
+// the menu, though they can still be picked by keyword.
+func (s *Server) sayNode(p *area.Player, name string, node area.DialogueNode) string {
+	p.DialogueNode = node.ID
+	msg := fmt.Sprintf("%s: %s\n", name, strings.TrimSpace(node.Text))
+	// Players with a mouse get a button to end the conversation.
+	if mouseActive(p) {
+		msg = "[bye] " + msg
+	}
+	for i, o := range node.Available(p, s.standingOf(p), s.contentOn) {
+		if i == maxMessageLines-1 {
+			break
+		}
+		msg += fmt.Sprintf(" %d) %s\n", i+1, o.Text)
+	}
+	return tagged(TagChat, msg)
+}
+
+// talkingTo returns the NPC the player is dealing with, as long as it is still
+// in the same room. Otherwise the conversation is over.
+func (s *Server) talkingTo(p *area.Player) (*area.NPC, bool) {
+	if p.Talking == 0 {
+		return nil, false
+	}
+	npc, areaName := s.findNPC(p.Talking)
+	if npc == nil || areaName != p.Area || npc.Room != p.Room || npc.Target != "" {
+		endConversation(p)
+		return nil, false
+	}
+	return npc, true
+}
+
+// endConversation stops the player from dealing with any NPC.
+func endConversation(p *area.Player) {
+	p.Talking = 0
+	p.DialogueNode = ""
+	p.Shop = ""
+}
+
+// bye ends the conversation of the player.
+func (s *Server) bye(p *area.Player) string {
+	npc, ok := s.talkingTo(p)
+	if !ok {
+		return "You are not talking to anyone\n"
+	}
+	endConversation(p)
+	return fmt.Sprintf("You take leave of %s\n", npc.Name)
+}
+
+// standingOf returns the standings of the player, for the conditions of the dialogues.
+func (s *Server) standingOf(p *area.Player) func(string) int {
+	return func(faction string) int {
+		return s.standing(p, faction)
+	}
+}
@@ -0,0 +1,131 @@
+package server
+
+import (
+	"fmt"
+
+	"github.com/droslean/thyranew/area"
+)
+
+// adjacentDoor returns the coordinates of a door right next to the player.
+func adjacentDoor(mapArray [][]area.Cube, p *area.Player) (int, int, bool) {
+	x, y, ok := area.FindCube(mapArray, p.Position)
+	if !ok {
+		return 0, 0, false
+	}
+	for _, d := range [][2]int{{1, 0}, {-1, 0}, {0, -1}, {0, 1}} {
+		nx, ny := x+d[0], y+d[1]
+		if nx < 0 || ny < 0 || nx >= len(mapArray) || ny >= len(mapArray[nx]) {
+			continue
+		}
+		if c := mapArray[nx][ny]; c.Type == "door" && len(c.Exits) > 0 {
+			return nx, ny, true
+		}
+	}
+	return 0, 0, false
+}
+
+// otherSide returns the door on the other side of the door of the room the
+// player is in: any door of the next room that leads back here, right next to
+// the cube this door leads to. It is nil when there is none.
+func otherSide(roomsMap map[string]map[string][][]area.Cube, p *area.Player, door *area.Cube) *area.Cube {
+	exit := door.Exits[0]
+	other := roomsMap[exit.ToArea][exit.ToRoom]
+	ax, ay, ok := area.FindCube(other, exit.ToCubeID)
+	if !ok {
+		return nil
+	}
+	for _, d := range area.Doors(other) {
+		c := &other[d[0]][d[1]]
+		if c.Exits[0].ToArea != p.Area || c.Exits[0].ToRoom != p.Room {
+			continue
+		}
+		if abs(d[0]-ax)+abs(d[1]-ay) == 1 {
+			return c
+		}
+	}
+	return nil
+}
+
+// changeDoor changes the door next to the player, along with the door on the
+// other side of it.
+func changeDoor(roomsMap map[string]map[string][][]area.Cube, p *area.Player, door *area.Cube, change func(c *area.Cube)) {
+	change(door)
+	if other := otherSide(roomsMap, p, door); other != nil {
+		change(other)
+	}
+}
+
+// setDoor opens or closes the door next to the player, along with the door
+// on the other side of it. A locked door opens when a key on the keyring of
+// the player fits it.
+func (s *Server) setDoor(roomsMap map[string]map[string][][]area.Cube, p *area.Player, closed bool) string {
+	mapArray := roomsMap[p.Area][p.Room]
+	x, y, ok := adjacentDoor(mapArray, p)
+	if !ok {
+		return "There is no door here\n"
+	}
+
+	door := &mapArray[x][y]
+	if door.Closed == closed {
+		if closed {
+			return "The door is already closed\n"
+		}
+		return "The door is already open\n"
+	}
+	if !closed && door.Locked {
+		key, ok := s.keyFor(p, door.Lock)
+		if !ok {
+			return "The door is locked\n"
+		}
+		changeDoor(roomsMap, p, door, func(c *area.Cube) { c.Locked, c.Closed = false, false })
+		return fmt.Sprintf("%s unlocks the door with the %s and opens it\n", p.Nickname, key.Item)
+	}
+	changeDoor(roomsMap, p, door, func(c *area.Cube) { c.Closed = closed })
+
+	if closed {
+		return fmt.Sprintf("%s closes the door\n", p.Nickname)
+	}
+	return fmt.Sprintf("%s opens the door\n", p.Nickname)
+}
+
+// lockDoor locks or unlocks the door next to the player with a key on their
+// keyring, along with the door on the other side of it. Doors are closed to
+// be locked.
+func (s *Server) lockDoor(roomsMap map[string]map[string][][]area.Cube, p *area.Player, locked bool) string {
+	mapArray := roomsMap[p.Area][p.Room]
+	x, y, ok := adjacentDoor(mapArray, p)
+	if !ok {
+		return "There is no door here\n"
+	}
+
+	door := &mapArray[x][y]
+	switch {
+	case door.Lock == "":
+		return "The door has no lock\n"
+	case door.Locked == locked && locked:
+		return "The door is already locked\n"
+	case door.Locked == locked:
+		return "The door is not locked\n"
+	}
+	key, ok := s.keyFor(p, door.Lock)
+	if !ok {
+		return "None of your keys fits the door\n"
+	}
+	if !locked {
+		changeDoor(roomsMap, p, door, func(c *area.Cube) { c.Locked = false })
+		return fmt.Sprintf("%s unlocks the door with the %s\n", p.Nickname, key.Item)
+	}
+	wasOpen := !door.Closed
+	changeDoor(roomsMap, p, door, func(c *area.Cube) { c.Locked, c.Closed = true, true })
+	if wasOpen {
+		return fmt.Sprintf("%s closes the door and locks it with the %s\n", p.Nickname, key.Item)
+	}
+	return fmt.Sprintf("%s locks the door with the %s\n", p.Nickname, key.Item)
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
@@ -0,0 +1,225 @@
+package server
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/droslean/thyranew/area"
+	"github.com/droslean/thyranew/game"
+
+	log "gopkg.in/inconshreveable/log15.v2"
+)
+
+// generateArea builds the rooms of a procedural area. Areas with a fixed seed
+// come out the same on every reset, the rest get a new seed every time.
+func (s *Server) generateArea(a *area.Area) error {
+	seed := a.Generator.Seed
+	if seed == 0 {
+		seed = s.rnd.Int63()
+	}
+	if err := area.Generate(a, seed); err != nil {
+		return err
+	}
+	// Every reset changes the locks, even of areas that come out the same.
+	a.Keying = s.rnd.Int63()
+	s.prepareNPCs(a)
+	log.Info(fmt.Sprintf("Generated area %q with seed %d", a.Name, seed))
+	return nil
+}
+
+// buildAreaRooms creates the cube arrays of all the rooms of the given area.
+func (s *Server) buildAreaRooms(roomsMap map[string]map[string][][]area.Cube, areaName string) {
+	roomsMap[areaName] = make(map[string][][]area.Cube)
+	for _, room := range s.Areas[areaName].Rooms {
+		roomsMap[areaName][room.Name] = s.CreateRoom(areaName, room.Name)
+	}
+}
+
+// sendToEntry moves the player to the entry of the given area.
+func sendToEntry(p *area.Player, a area.Area) {
+	sendTo(p, a.Entry)
+}
+
+// sendTo moves the player to the cube the exit leads to.
+func sendTo(p *area.Player, to area.Exit) {
+	p.PreviousArea = p.Area
+	p.PreviousRoom = p.Room
+	p.MoveTo(to)
+}
+
+// resetArea regenerates a procedural area and sends everyone inside back to its entry.
+// The instances of players are theirs alone, and are not reset.
+// Usage: reset <area>
+func (s *Server) resetArea(roomsMap map[string]map[string][][]area.Cube, p *area.Player, args []string) string {
+	if !isBuilder(p) {
+		return "Only builders can reset areas\n"
+	}
+	if len(args) == 0 {
+		return "Usage: reset <area>\n"
+	}
+
+	a, ok := s.Areas[args[0]]
+	if !ok || isInstance(a.Name) {
+		return fmt.Sprintf("There is no area %q\n", args[0])
+	}
+	if a.Generator.Style == "" {
+		return fmt.Sprintf("%s is not a generated area\n", a.Name)
+	}
+
+	if err := s.generateArea(&a); err != nil {
+		log.Error(fmt.Sprintf("Cannot reset area %q: %v", a.Name, err))
+		return fmt.Sprintf("%s could not be reset\n", a.Name)
+	}
+	s.Areas[a.Name] = a
+	s.buildAreaRooms(roomsMap, a.Name)
+	s.rekeyed(roomsMap, a.Name)
+
+	for _, c := range s.recipients(Broadcast{Scope: ScopeArea, Area: a.Name}) {
+		sendToEntry(c.Player, a)
+	}
+	s.broadcast(roomsMap, Broadcast{Scope: ScopeArea, Area: a.Name, Text: "The walls shift around you.\n"})
+
+	return fmt.Sprintf("%s has been reset\n", a.Name)
+}
+
+// enterInstance creates a private copy of a procedural area for the player and
+// sends them in, along with the rest of their group. The player has to stand
+// at the entrance of the dungeon, the room its way out leads to, while the
+// group may be anywhere. Entering again replaces the old copy with a fresh
+// one.
+// Usage: dungeon <area>
+func (s *Server) enterInstance(roomsMap map[string]map[string][][]area.Cube, p *area.Player, args []string) string {
+	if len(args) == 0 {
+		return "Usage: dungeon <area>\n"
+	}
+
+	template, ok := s.Areas[args[0]]
+	if !ok || template.Generator.Style == "" || isInstance(template.Name) {
+		return fmt.Sprintf("There is no dungeon %q\n", args[0])
+	}
+	if exit := template.Generator.Exit; !p.InRoom(exit.ToArea, exit.ToRoom) {
+		return fmt.Sprintf("The way into %s is in %s\n", template.Name, exit.ToRoom)
+	}
+	if gate := s.levelGate(p, template.Name); gate != "" {
+		return gate
+	}
+	if !s.canEscape(p, "dungeon") {
+		return "You can't slip into the dungeon in the middle of a fight\n"
+	}
+
+	instance := template
+	instance.Name = fmt.Sprintf("%s#%s", template.Name, p.Nickname)
+	// Instances always get a new seed, so they can be replayed.
+	instance.Generator.Seed = 0
+	if err := s.generateArea(&instance); err != nil {
+		log.Error(fmt.Sprintf("Cannot create instance %q: %v", instance.Name, err))
+		return fmt.Sprintf("%s could not be entered\n", template.Name)
+	}
+	group := []*area.Player{p}
+	for _, m := range s.group(p)[1:] {
+		if m.Ghost {
+			continue
+		}
+		if gate := s.levelGate(m, template.Name); gate != "" {
+			s.tellPlayer(roomsMap, m.Nickname, gate)
+			continue
+		}
+		group = append(group, m)
+	}
+	s.scaleInstance(&instance, group)
+	s.Areas[instance.Name] = instance
+	s.buildAreaRooms(roomsMap, instance.Name)
+	s.rekeyed(roomsMap, instance.Name)
+
+	// Nothing stands in an instance yet, so the leader takes the entry itself.
+	if !s.teleport(roomsMap, p, instance.Entry, "dungeon") {
+		return fmt.Sprintf("%s could not be entered\n", template.Name)
+	}
+	for _, f := range group[1:] {
+		to, ok := s.freeCube(roomsMap, instance.Entry.ToArea, instance.Entry.ToRoom)
+		if ok && !s.teleport(roomsMap, f, to, "dungeon") {
+			s.tellPlayer(roomsMap, f.Nickname, fmt.Sprintf("Your group enters %s without you, while you fight\n", template.Name))
+		}
+	}
+	if instance.Generator.Scaling.Level > 0 {
+		for _, m := range group {
+			s.tellPlayer(roomsMap, m.Nickname, fmt.Sprintf("%s stirs to meet a group of %d\n", template.Name, len(group)))
+		}
+	}
+	return ""
+}
+
+// isInstance reports whether the area is the private copy of a dungeon some
+// player entered.
+func isInstance(name string) bool {
+	return strings.Contains(name, "#")
+}
+
+// freeInstances takes the instances nobody is in any more out of the world,
+// along with their rooms and what their NPCs were up to. Players who lost
+// their link are still online, so an instance waits for them while they may
+// come back, and it waits for the corpses lying in it to be revived or rot
+// away. Players who left the game inside one are sent back where they
+// respawn when they return. It runs on every tick.
+func (s *Server) freeInstances(roomsMap map[string]map[string][][]area.Cube) {
+	occupied := map[string]bool{}
+	for _, c := range s.OnlineClients() {
+		occupied[c.Player.Area] = true
+	}
+	for _, c := range s.corpses {
+		occupied[c.Area] = true
+	}
+	for name, a := range s.Areas {
+		if !isInstance(name) || occupied[name] {
+			continue
+		}
+		for _, npc := range append(a.NPCs, s.dormant[name]...) {
+			delete(s.pursuits, npc.ID)
+			delete(s.threat, npc.ID)
+			delete(s.leashes, npc.ID)
+		}
+		delete(s.Areas, name)
+		delete(s.dormant, name)
+		delete(s.world.rands, name)
+		delete(roomsMap, name)
+		log.Info(fmt.Sprintf("Instance %q is empty and freed", name))
+	}
+}
+
+// placeLost sends the player back where they respawn if the area they left
+// the game in is gone, like an instance that was freed.
+func (s *Server) placeLost(p *area.Player) {
+	if _, ok := s.Areas[p.Area]; ok {
+		return
+	}
+	to := p.Bind
+	if _, ok := s.Areas[to.ToArea]; !ok {
+		to = defaultBind
+	}
+	log.Info(fmt.Sprintf("%q left the game in %s, which is gone, and is sent to %s", p.Nickname, p.Area, to.ToArea))
+	sendTo(p, to)
+}
+
+// scaleInstance fits the NPCs of the instance to the group entering it, when
+// the area scales: to the average level of the group, and the more of them,
+// the harder and the richer.
+func (s *Server) scaleInstance(a *area.Area, group []*area.Player) {
+	scaling := a.Generator.Scaling
+	if scaling.Level == 0 || len(group) == 0 {
+		return
+	}
+	total := 0
+	for _, p := range group {
+		total += p.Level
+	}
+	average := (total + len(group)/2) / len(group)
+	for i := range a.NPCs {
+		npc := &a.NPCs[i]
+		npc.Level = game.ScaleLevel(npc.Level, scaling.Level, average)
+		npc.HP = game.NPCHitPoints(npc.Level) * (100 + game.GroupBonus(len(group), scaling.HP)) / 100
+		npc.MaxHP = npc.HP
+		npc.AC = game.NPCArmorClass(npc.Level)
+		npc.Luck = game.GroupBonus(len(group), scaling.Loot)
+	}
+	log.Info(fmt.Sprintf("Scaled %q to a group of %d of level %d", a.Name, len(group), average))
+}
@@ -0,0 +1,339 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/droslean/thyranew/area"
+	"github.com/droslean/thyranew/game"
+	"github.com/gothyra/toml"
+
+	log "gopkg.in/inconshreveable/log15.v2"
+)
+
+// EconomyConfig tunes how gold comes into the world and leaves it. Zero turns
+// each of them off.
+type EconomyConfig struct {
+	// Elasticity is by how many percent the price of a ware goes up for each
+	// one bought lately, and MaxMarkup how many percent over its price it goes
+	// at most. Recovery is how many minutes it takes half the demand to fade.
+	Elasticity int `toml:"elasticity"`
+	MaxMarkup  int `toml:"maxmarkup"`
+	Recovery   int `toml:"recovery"`
+	// Repair is what smiths charge for each point of wear on the gear of a
+	// player.
+	Repair int `toml:"repair"`
+	// Identify is what they charge to identify an item.
+	Identify int `toml:"identify"`
+	// Upkeep is how many percent of the upkeep of their rooms the players
+	// bound to them pay every day.
+	Upkeep int `toml:"upkeep"`
+	// Inflation is by how many percent the gold of the players may grow in a
+	// day before the economy report raises the alarm.
+	Inflation int `toml:"inflation"`
+}
+
+// Where gold comes from and goes to. Loot and pickpocketing bring gold into
+// the world, and the rest take it out. Gold that changes hands between
+// players is neither.
+const (
+	flowLoot       = "loot"
+	flowPickpocket = "pickpocket"
+	flowShops      = "shops"
+	flowServices   = "services"
+	flowFines      = "fines"
+	flowRepairs    = "repairs"
+	flowUpkeep     = "upkeep"
+	flowLost       = "lost"
+)
+
+const (
+	// ledgerSweepTicks is every how many ticks the gold that came and went is
+	// written down.
+	ledgerSweepTicks = 30
+	// upkeepSweepTicks is every how many ticks the upkeep of the rooms online
+	// players are bound to is charged.
+	upkeepSweepTicks = 30
+	// upkeepDay is how long the upkeep of a room pays for.
+	upkeepDay = 24 * time.Hour
+)
+
+// goldFlow records gold coming into the world, or leaving it when the amount
+// is negative.
+func (s *Server) goldFlow(source string, amount int) {
+	if amount != 0 {
+		s.ledger[source] += amount
+	}
+}
+
+// flushLedger writes the gold that came and went since the last time down
+// for the day.
+func (s *Server) flushLedger() {
+	if s.ticks%ledgerSweepTicks != 0 || len(s.ledger) == 0 {
+		return
+	}
+	if err := s.db.AddGoldFlows(context.Background(), ledgerDay(s.now()), s.ledger); err != nil {
+		log.Error(fmt.Sprintf("Cannot write the ledger down: %v", err))
+		return
+	}
+	s.ledger = make(map[string]int)
+}
+
+// ledgerDay returns the day of the ledger the time falls on.
+func ledgerDay(t time.Time) string {
+	return t.UTC().Format("2006-01-02")
+}
+
+// demand is how many of a ware were bought lately, as of when.
+type demand struct {
+	units float64
+	at    time.Time
+}
+
+// demandOf returns how many of the ware of the shop were bought lately.
+func (s *Server) demandOf(shop, item string) float64 {
+	d := s.demand[shop+"/"+item]
+	return game.Fade(d.units, s.now().Sub(d.at), time.Duration(s.config.Economy.Recovery)*time.Minute)
+}
+
+// priceOf returns what the ware of the shop goes for now.
+func (s *Server) priceOf(shop string, w area.Ware) int {
+	eco := s.config.Economy
+	return game.Price(w.Price, s.demandOf(shop, w.Item), eco.Elasticity, eco.MaxMarkup)
+}
+
+// quote returns what n of the ware of the shop cost, each one a little more
+// than the last as demand goes up.
+func (s *Server) quote(shop string, w area.Ware, n int) int {
+	eco := s.config.Economy
+	units := s.demandOf(shop, w.Item)
+	total := 0
+	for i := 0; i < n; i++ {
+		total += game.Price(w.Price, units+float64(i), eco.Elasticity, eco.MaxMarkup)
+	}
+	return total
+}
+
+// sold puts up the demand for the ware of the shop.
+func (s *Server) sold(shop, item string, n int) {
+	s.demand[shop+"/"+item] = demand{units: s.demandOf(shop, item) + float64(n), at: s.now()}
+}
+
+// repairGear is the service of smith NPCs: they mend the armor of the player,
+// as much of it as the player can pay for.
+func repairGear(s *Server, p *area.Player, npc *area.NPC) string {
+	worn := s.held(p, p.Armor).instance.Wear
+	if worn == 0 {
+		return fmt.Sprintf("%s finds nothing to mend on your gear\n", npc.Name)
+	}
+	price := s.config.Economy.Repair
+	mended := worn
+	if price > 0 && p.Gold < mended*price {
+		mended = p.Gold / price
+	}
+	if mended == 0 {
+		return fmt.Sprintf("%s asks for %d gold to mend your gear\n", npc.Name, worn*price)
+	}
+	p.ChangeGear(p.Armor, func(e *area.ItemInstance) { e.Wear -= mended })
+	p.Gold -= mended * price
+	s.goldFlow(flowRepairs, -mended*price)
+	if worn > mended {
+		return fmt.Sprintf("%s mends what %d gold pays for, and your gear is still somewhat worn\n", npc.Name, mended*price)
+	}
+	return fmt.Sprintf("%s mends your gear for %d gold, and it is as good as new\n", npc.Name, mended*price)
+}
+
+// wearGear wears the armor the player has on down by the amount.
+func wearGear(p *area.Player, amount int) {
+	if p.Armor == "" {
+		return
+	}
+	p.ChangeGear(p.Armor, func(e *area.ItemInstance) { e.Wear = game.Wear(e.Wear, amount) })
+}
+
+// upkeepOf returns what a day in the room the player is bound to costs.
+func (s *Server) upkeepOf(p *area.Player) int {
+	room := s.Areas[p.Bind.ToArea].Rooms[p.Bind.ToRoom]
+	return room.Upkeep * s.config.Economy.Upkeep / 100
+}
+
+// chargeUpkeep makes the online players pay for the days in the rooms they
+// are bound to. Those who can't pay lose the room, and are bound where
+// everyone starts.
+func (s *Server) chargeUpkeep(roomsMap map[string]map[string][][]area.Cube) {
+	if s.ticks%upkeepSweepTicks != 0 {
+		return
+	}
+	for _, c := range s.OnlineClients() {
+		p := c.Player
+		upkeep := s.upkeepOf(p)
+		if upkeep == 0 || s.now().Before(p.Lodging) {
+			continue
+		}
+		// Rooms bound to before they had upkeep are paid for from now on.
+		if p.Lodging.IsZero() {
+			p.Lodging = s.now()
+		}
+		// The days the player was away are owed too.
+		paid := 0
+		for !s.now().Before(p.Lodging) && p.Gold >= upkeep {
+			p.Gold -= upkeep
+			paid += upkeep
+			p.Lodging = p.Lodging.Add(upkeepDay)
+		}
+		s.goldFlow(flowUpkeep, -paid)
+		if s.now().Before(p.Lodging) {
+			s.tellPlayer(roomsMap, p.Nickname, fmt.Sprintf("You pay %d gold of upkeep for your room in %s\n", paid, p.Bind.ToRoom))
+		} else {
+			s.notify(roomsMap, p.Nickname, "System", fmt.Sprintf("You could not pay the upkeep of your room in %s and lost it", p.Bind.ToRoom))
+			p.Bind = area.Exit{}
+			p.Lodging = time.Time{}
+		}
+		if err := s.savePlayer(p); err != nil {
+			log.Error(fmt.Sprintf("Cannot save player %q: %v", p.Nickname, err))
+		}
+	}
+}
+
+// goldHeld returns the gold of all the players, in their purses, in the mail
+// and on their corpses.
+func (s *Server) goldHeld(ctx context.Context) (purses, mail, corpses int, err error) {
+	online := map[string]bool{}
+	for _, c := range s.OnlineClients() {
+		online[c.Player.Nickname] = true
+		purses += c.Player.Gold
+	}
+	files, err := filepath.Glob(s.dataDir + "/player/*.toml")
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	for _, f := range files {
+		fileContent, err := ioutil.ReadFile(f)
+		if err != nil {
+			return 0, 0, 0, err
+		}
+		p := area.Player{}
+		if _, err := toml.Decode(string(fileContent), &p); err != nil {
+			log.Info(fmt.Sprintf("%s could not be unmarshaled: %v", f, err))
+			continue
+		}
+		if !online[p.Nickname] {
+			purses += p.Gold
+		}
+	}
+	all, err := s.db.ListMail(ctx)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	for _, m := range all {
+		// Gold in mail being sent or delivered is still in a purse.
+		if m.Status == mailSent {
+			mail += m.Gold
+		}
+	}
+	for _, c := range s.corpses {
+		corpses += c.Gold
+	}
+	return purses, mail, corpses, nil
+}
+
+// economy reports the gold that came into the world and left it over the
+// last days, how much of it players hold, and the prices demand put up. It
+// raises the alarm when the gold of the players grows too fast.
+// Usage: economy [days]
+func (s *Server) economy(p *area.Player, args []string) string {
+	days := 7
+	if len(args) > 0 {
+		n, err := strconv.Atoi(args[0])
+		if err != nil || n < 1 {
+			return "Usage: economy [days]\n"
+		}
+		days = n
+	}
+	ctx := s.ctxOf(p.Nickname)
+	flows, err := s.db.GoldFlows(ctx)
+	if err != nil {
+		log.Error(fmt.Sprintf("Cannot read the ledger: %v", err))
+		return "The ledger can't be read right now\n"
+	}
+	today := ledgerDay(s.now())
+	if flows[today] == nil {
+		flows[today] = map[string]int{}
+	}
+	for source, amount := range s.ledger {
+		flows[today][source] += amount
+	}
+	purses, mail, corpses, err := s.goldHeld(ctx)
+	if err != nil {
+		log.Error(fmt.Sprintf("Cannot count the gold of the players: %v", err))
+		return "The gold of the players can't be counted right now\n"
+	}
+	held := purses + mail + corpses
+
+	lines := []string{fmt.Sprintf("Gold held by players: %d (%d in purses, %d in the mail, %d on corpses)", held, purses, mail, corpses)}
+	lines = append(lines, fmt.Sprintf("Last %d days:", days))
+	totals := map[string]int{}
+	alarm := ""
+	// Going back a day at a time, the gold held at the end of each day is
+	// what is held now less what came in since.
+	end := held
+	for i := 0; i < days; i++ {
+		day := ledgerDay(s.now().AddDate(0, 0, -i))
+		in, out := 0, 0
+		for source, amount := range flows[day] {
+			totals[source] += amount
+			if amount > 0 {
+				in += amount
+			} else {
+				out -= amount
+			}
+		}
+		start := end - (in - out)
+		line := fmt.Sprintf("  %s  +%d in  -%d out  net %+d", day, in, out, in-out)
+		if start > 0 {
+			growth := float64(in-out) * 100 / float64(start)
+			line += fmt.Sprintf(" (%+.1f%%)", growth)
+			if limit := s.config.Economy.Inflation; limit > 0 && growth > float64(limit) && alarm == "" {
+				alarm = fmt.Sprintf("Inflation alarm: the gold of players grew %.1f%% on %s, over the %d%% allowed", growth, day, limit)
+			}
+		}
+		lines = append(lines, line)
+		end = start
+	}
+
+	sources := []string{}
+	for source := range totals {
+		sources = append(sources, source)
+	}
+	sort.Strings(sources)
+	if len(sources) > 0 {
+		lines = append(lines, "By source:")
+	}
+	for _, source := range sources {
+		lines = append(lines, fmt.Sprintf("  %-10s %+d", source, totals[source]))
+	}
+
+	wares := []string{}
+	for _, shop := range s.Shops {
+		for _, w := range shop.Wares {
+			if price := s.priceOf(shop.Name, w); price != w.Price {
+				wares = append(wares, fmt.Sprintf("  %s at %s: %d gold, up from %d", w.Item, shop.Name, price, w.Price))
+			}
+		}
+	}
+	sort.Strings(wares)
+	if len(wares) > 0 {
+		lines = append(lines, "Wares in demand:")
+		lines = append(lines, wares...)
+	}
+	if alarm != "" {
+		lines = append(lines, alarm)
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
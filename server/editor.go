@@ -0,0 +1,182 @@
+package server
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// maxEditorLines is how many lines a text written in the editor can have.
+const maxEditorLines = 40
+
+// editorHelp lists the commands of the editor.
+const editorHelp = "/s saves, /q aborts, /p previews, /f full screen, /d [n] deletes, /r <n> <text> replaces, /g <n> goes to a line, /c clears, {red}colours{x}"
+
+// editor is the modal players write long text in, a line at a time. Typed
+// lines go in where the cursor is, and lines starting with a slash are
+// commands of the editor. The arrows move the cursor.
+type editor struct {
+	title   string
+	lines   []string
+	at      int  // Where typed lines go in, between 0 and the number of lines
+	full    bool // The editor takes the whole screen
+	preview bool // The text is shown in its colours, as others will see it
+	changed bool
+	note    string // What the last command of the editor said
+	save    func(s *Server, c *Client, text string) string
+}
+
+// newEditor starts an editor on the text, that hands what the player saved
+// to save.
+func newEditor(title, text string, save func(s *Server, c *Client, text string) string) *editor {
+	e := &editor{title: title, save: save}
+	if text = strings.TrimRight(text, "\n"); text != "" {
+		e.lines = strings.Split(text, "\n")
+	}
+	e.at = len(e.lines)
+	return e
+}
+
+func (e *editor) view(height int) modalView {
+	v := modalView{
+		title: fmt.Sprintf("%s, line %d of %d", e.title, e.at+1, len(e.lines)+1),
+		hint:  "Type lines, /s saves, /q aborts, /h helps",
+		full:  e.full,
+	}
+	if e.note != "" {
+		v.hint = e.note
+	}
+	if e.preview {
+		v.title = e.title + ", preview"
+		v.hint = "/p goes back to writing"
+		for _, line := range e.lines {
+			plain, colors := renderMarkup(line)
+			v.lines = append(v.lines, modalLine{text: plain, colors: colors})
+		}
+		if len(v.lines) > height {
+			v.lines = v.lines[:height]
+		}
+		return v
+	}
+	if !e.full && height > maxMessageLines*2 {
+		height = maxMessageLines * 2
+	}
+	// The lines around the cursor are shown, and the cursor is a line of
+	// its own.
+	first := e.at - height/2
+	if first > len(e.lines)+1-height {
+		first = len(e.lines) + 1 - height
+	}
+	if first < 0 {
+		first = 0
+	}
+	for i := first; i <= len(e.lines) && len(v.lines) < height; i++ {
+		if i == e.at {
+			v.lines = append(v.lines, modalLine{text: "   > "})
+		}
+		if i < len(e.lines) && len(v.lines) < height {
+			v.lines = append(v.lines, modalLine{text: fmt.Sprintf("%3d %s", i+1, e.lines[i])})
+		}
+	}
+	return v
+}
+
+func (e *editor) key(s *Server, c *Client, key string) (string, bool) {
+	e.note = ""
+	switch key {
+	case keyUp:
+		if e.at > 0 {
+			e.at--
+		}
+	case keyDown:
+		if e.at < len(e.lines) {
+			e.at++
+		}
+	case keyEnter:
+		// Enter on an empty prompt starts a new paragraph.
+		e.insert("")
+	case keyEscape:
+		if !e.changed {
+			return "", true
+		}
+		s.openModal(c, newConfirm("Throw away what you wrote?", func(s *Server, c *Client) string {
+			s.closeModal(c, e)
+			return "You throw away what you wrote\n"
+		}, nil))
+	}
+	return "", false
+}
+
+func (e *editor) line(s *Server, c *Client, text string) (string, bool, bool) {
+	e.note = ""
+	if !strings.HasPrefix(text, "/") {
+		e.insert(text)
+		return "", false, true
+	}
+	fields := strings.Fields(text)
+	// The number of a line, or the line right before the cursor.
+	lineAt := func(i int) (int, bool) {
+		if len(fields) <= i {
+			return e.at - 1, e.at > 0
+		}
+		n, err := strconv.Atoi(fields[i])
+		return n - 1, err == nil && n >= 1 && n <= len(e.lines)
+	}
+	switch fields[0] {
+	case "/s", "/save":
+		return e.save(s, c, strings.Join(e.lines, "\n")), true, true
+	case "/q", "/abort":
+		return "You throw away what you wrote\n", true, true
+	case "/p", "/preview":
+		e.preview = !e.preview
+	case "/f", "/full":
+		e.full = !e.full
+	case "/c", "/clear":
+		e.lines, e.at, e.changed = nil, 0, true
+	case "/d", "/delete":
+		n, ok := lineAt(1)
+		if !ok {
+			e.note = "There is no such line"
+			break
+		}
+		e.lines = append(e.lines[:n], e.lines[n+1:]...)
+		if e.at > n {
+			e.at--
+		}
+		e.changed = true
+	case "/r", "/replace":
+		n, ok := lineAt(1)
+		if len(fields) < 2 || !ok {
+			e.note = "Usage: /r <n> <text>"
+			break
+		}
+		e.lines[n] = ""
+		if parts := strings.SplitN(text, " ", 3); len(parts) == 3 {
+			e.lines[n] = parts[2]
+		}
+		e.changed = true
+	case "/g", "/goto":
+		n, err := strconv.Atoi(strings.Join(fields[1:], ""))
+		if err != nil || n < 1 || n > len(e.lines)+1 {
+			e.note = "There is no such line"
+			break
+		}
+		e.at = n - 1
+	default:
+		e.note = editorHelp
+	}
+	return "", false, true
+}
+
+// insert puts the line in where the cursor is, and the cursor after it.
+func (e *editor) insert(line string) {
+	if len(e.lines) >= maxEditorLines {
+		e.note = fmt.Sprintf("The text is full at %d lines", maxEditorLines)
+		return
+	}
+	e.lines = append(e.lines, "")
+	copy(e.lines[e.at+1:], e.lines[e.at:])
+	e.lines[e.at] = line
+	e.at++
+	e.changed = true
+}
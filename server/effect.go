@@ -0,0 +1,187 @@
+package server
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/droslean/thyranew/area"
+	"github.com/droslean/thyranew/game"
+)
+
+// addEffect puts the effect on the player, by its stacking policy: unless it
+// says otherwise, it replaces any effect with the same name.
+func addEffect(p *area.Player, e game.Effect) {
+	p.Effects = game.AddEffect(p.Effects, e)
+}
+
+// castEffect returns the effect as the player puts it on, their own, with
+// their power kept when it snapshots.
+func castEffect(p *area.Player, e game.Effect) game.Effect {
+	e.Caster = p.Nickname
+	if e.Snapshot {
+		e.Power = game.EffectPower(&p.PC, e)
+	}
+	return e
+}
+
+// addNPCEffect puts the effect the player casts on the NPC, by its stacking
+// policy.
+func addNPCEffect(npc *area.NPC, p *area.Player, e game.Effect) {
+	npc.Effects = game.AddEffect(npc.Effects, castEffect(p, e))
+}
+
+// effectPower returns what the caster of the effect adds to its pulses now,
+// nothing when it has no caster or they are offline.
+func (s *Server) effectPower(e game.Effect) int {
+	if e.Caster == "" {
+		return 0
+	}
+	cl, ok := s.clientByNick(e.Caster)
+	if !ok {
+		return 0
+	}
+	return game.EffectPower(&cl.Player.PC, e)
+}
+
+// removeEffect takes the effect with the given name off the player.
+func removeEffect(p *area.Player, name string) {
+	for i := range p.Effects {
+		if p.Effects[i].Name == name {
+			p.Effects = append(p.Effects[:i], p.Effects[i+1:]...)
+			return
+		}
+	}
+}
+
+// hasEffect reports whether the effect with the given name is on the player.
+func hasEffect(p *area.Player, name string) bool {
+	for _, e := range p.Effects {
+		if e.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// applyEffects lets the effects on the player act, once per tick, and leaves
+// the world to tell them. Those who put the effects on are credited with the
+// damage and the healing they do, and with the kill.
+func (s *Server) applyEffects(roomsMap map[string]map[string][][]area.Cube, cl Client) []func() {
+	p := cl.Player
+	if len(p.Effects) == 0 {
+		return nil
+	}
+
+	var effects []func()
+	alive := p.HP > 0
+	msg := ""
+	killer := ""
+	remaining := []game.Effect{}
+	for _, e := range p.Effects {
+		acts, over := e.Pulse()
+		if acts && (p.HP > 0 || e.Damage < 0) {
+			amount := e.Amount(s.effectPower(e))
+			if amount > 0 {
+				amount, _ = s.gearBonus(p).defenses.Against(amount, e.Element)
+			}
+			if full := game.MaxHP(&p.PC); amount < 0 && p.HP-amount > full {
+				amount = 0
+				if p.HP < full {
+					amount = p.HP - full
+				}
+			}
+			p.HP -= amount
+			if e.Damage < 0 {
+				msg += tagged(TagHealing, e.Message)
+			} else {
+				msg += e.Message
+			}
+			e, amount := e, amount
+			effects = append(effects, func() { s.creditEffect(roomsMap, p, e, amount) })
+			if amount > 0 {
+				killer = e.Caster
+			}
+		}
+		if !over {
+			remaining = append(remaining, e)
+		}
+	}
+	p.Effects = remaining
+
+	switch {
+	case p.HP <= 0 && alive:
+		effects = append(effects, func() { s.killPlayer(roomsMap, p, killer) })
+	case p.HP > 0 && msg != "":
+		effects = append(effects, func() { s.godPrintRoom([]Client{cl}, roomsMap, msg, "") })
+	}
+	return effects
+}
+
+// creditEffect records what a pulse of the effect did to the player, and
+// credits its caster with it: the damage they dealt, or the threat of their
+// healing.
+func (s *Server) creditEffect(roomsMap map[string]map[string][][]area.Cube, p *area.Player, e game.Effect, amount int) {
+	switch {
+	case amount > 0:
+		s.recordCombat(CombatEvent{Player: p.Nickname, Kind: combatTaken, Source: e.Name, Amount: amount})
+		if e.Caster != "" && e.Caster != p.Nickname {
+			s.recordCombat(CombatEvent{Player: e.Caster, Kind: combatDealt, Source: e.Name, Amount: amount})
+		}
+	case amount < 0:
+		s.recordCombat(CombatEvent{Player: p.Nickname, Kind: combatHealed, Source: e.Name, Amount: -amount})
+		if cl, ok := s.clientByNick(e.Caster); ok {
+			s.healThreat(roomsMap, cl.Player, p, -amount)
+		}
+	}
+}
+
+// applyNPCEffects lets the effects players put on the NPCs of the area act,
+// once per tick, and leaves the world to tell the players. Their casters are
+// credited with the damage, the threat and the kill, so the effects of those
+// who went offline fade.
+func (s *Server) applyNPCEffects(roomsMap map[string]map[string][][]area.Cube, areaName string) []func() {
+	var effects, deaths []func()
+	a := s.Areas[areaName]
+	for i := range a.NPCs {
+		npc := &a.NPCs[i]
+		if len(npc.Effects) == 0 {
+			continue
+		}
+		var killer *Client
+		remaining := []game.Effect{}
+		for _, e := range npc.Effects {
+			cl, ok := s.clientByNick(e.Caster)
+			if !ok {
+				continue
+			}
+			acts, over := e.Pulse()
+			if acts && npc.HP > 0 {
+				amount, _ := npc.Defenses.Against(e.Amount(game.EffectPower(&cl.Player.PC, e)), e.Element)
+				if amount > 0 {
+					npc.HP -= amount
+					e, cl := e, cl
+					b := Broadcast{Scope: ScopeRoom, Area: areaName, Room: npc.Room, Kind: TagCombat, Text: fmt.Sprintf("$p %s hurts $N for %d\n", strings.ToLower(e.Name), amount)}
+					effects = append(effects, func() {
+						s.recordCombat(CombatEvent{Player: e.Caster, Kind: combatDealt, Source: e.Name, Amount: amount})
+						s.addThreat(npc, e.Caster, game.Threat(&cl.Player.PC, amount))
+						s.act(roomsMap, b, playerActor(cl.Player), npcActor(npc))
+					})
+					killer = cl
+				}
+			}
+			if !over {
+				remaining = append(remaining, e)
+			}
+		}
+		npc.Effects = remaining
+		switch {
+		case npc.HP <= 0 && killer != nil:
+			id := npc.ID
+			deaths = append(deaths, func() { s.killNPC(roomsMap, killer, id) })
+		case killer != nil:
+			effects = append(effects, func() { s.provoke(roomsMap, npc, killer.Player.Nickname) })
+		}
+	}
+	// Killing an NPC takes it out of its area, so it waits for the rest.
+	return append(effects, deaths...)
+}
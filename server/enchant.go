@@ -0,0 +1,111 @@
+package server
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/droslean/thyranew/area"
+	"github.com/droslean/thyranew/game"
+)
+
+// enchantScroll is the item that enchants another when read over it.
+const enchantScroll = "Scroll of Enchantment"
+
+// EnchantConfig tunes the enchanting of items.
+type EnchantConfig struct {
+	// Risk is by how many percent each enchantment an item took makes the
+	// next likelier to fail.
+	Risk int `toml:"risk"`
+	// Price is what smiths charge for each enchantment the weapon they
+	// enchant will have.
+	Price int `toml:"price"`
+}
+
+// enchantItem rolls the enchantment of the item, and tells what came of it.
+func (s *Server) enchantItem(p *area.Player, h heldItem) string {
+	outcome := game.Enchant(h.instance.Enchant, s.config.Enchant.Risk, s.rnd)
+	switch outcome {
+	case game.EnchantTakes:
+		changeItem(p, h.key(), func(e *area.ItemInstance) { e.Enchant++ })
+		return fmt.Sprintf("The %s glows as the enchantment takes, and is now %s\n", h.key(), s.held(p, h.key()).name())
+	case game.EnchantBackfires:
+		changeItem(p, h.key(), func(e *area.ItemInstance) { e.Enchant-- })
+		return fmt.Sprintf("The enchantment backfires, and the %s dulls to %s\n", h.key(), s.held(p, h.key()).name())
+	}
+	return fmt.Sprintf("The enchantment fizzles, and the %s is as it was\n", h.key())
+}
+
+// enchantable returns why the item can't be enchanted, or nothing when it can.
+func enchantable(h heldItem) string {
+	if h.Kind != area.ItemWeapon && h.Kind != area.ItemArmor && h.Kind != area.ItemShield {
+		return fmt.Sprintf("Only weapons, armor and shields take enchantments, not the %s\n", h.Name)
+	}
+	if h.instance.Enchant >= game.MaxEnchant {
+		return fmt.Sprintf("The %s takes no more enchantments\n", h.name())
+	}
+	return ""
+}
+
+// enchant reads a scroll of enchantment over a weapon or armor the player
+// has on or carries. The more it was enchanted, the likelier it fails.
+// Usage: enchant <item>
+func (s *Server) enchant(p *area.Player, args []string) string {
+	if len(args) == 0 {
+		return "Usage: enchant <item>\n"
+	}
+	name := strings.Join(args, " ")
+	h, ok := s.itemInReach(p, name, false)
+	if !ok {
+		return fmt.Sprintf("You have no %s\n", name)
+	}
+	if why := enchantable(h); why != "" {
+		return why
+	}
+	if !p.HasItem(enchantScroll) {
+		return fmt.Sprintf("You need a %s, or a smith, to enchant the %s\n", enchantScroll, h.Name)
+	}
+	p.RemoveItem(enchantScroll)
+	return s.enchantItem(p, h)
+}
+
+// socket sets a gem the player carries in a free socket of an item they have
+// on or carry, for good.
+// Usage: socket <gem> [in] <item>
+func (s *Server) socket(p *area.Player, args []string) string {
+	usage := "Usage: socket <gem> [in] <item>\n"
+	if len(args) < 2 {
+		return usage
+	}
+	gem, h, ok := s.splitItems(p, args, "in", false)
+	if !ok {
+		return usage
+	}
+	if !gem.Gem.Any() || !p.HasItem(gem.Name) {
+		return fmt.Sprintf("The %s is no gem to set in a socket\n", gem.Name)
+	}
+	if len(h.instance.Gems) >= h.Sockets {
+		return fmt.Sprintf("The %s has no free socket\n", h.name())
+	}
+	p.RemoveItem(gem.Name)
+	changeItem(p, h.key(), func(e *area.ItemInstance) { e.Gems = append(e.Gems, gem.Name) })
+	return fmt.Sprintf("You set the %s in the %s\n%s\n", gem.Name, h.name(), describeItem(p, s.held(p, h.key())))
+}
+
+// enchantWeapon is the service of smith NPCs that enchant the weapon the
+// player has on, with the same risk as a scroll.
+func enchantWeapon(s *Server, p *area.Player, npc *area.NPC) string {
+	h, ok := s.gearOf(p, area.ItemWeapon)
+	if !ok {
+		return fmt.Sprintf("%s finds no weapon on you to enchant\n", npc.Name)
+	}
+	if why := enchantable(h); why != "" {
+		return why
+	}
+	price := s.config.Enchant.Price * (h.instance.Enchant + 1)
+	if p.Gold < price {
+		return fmt.Sprintf("%s asks for %d gold to enchant your %s\n", npc.Name, price, h.name())
+	}
+	p.Gold -= price
+	s.goldFlow(flowServices, -price)
+	return fmt.Sprintf("%s works the %s for %d gold\n", npc.Name, h.name(), price) + s.enchantItem(p, h)
+}
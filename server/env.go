@@ -0,0 +1,121 @@
+package server
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// envPrefix starts the names of the environment variables the server reads.
+// The settings of server.toml are read from THYRA_ and their key in upper
+// case, with the tables they are in before it, like THYRA_PORT or
+// THYRA_CLUSTER_SECRET. Secrets are read from the file named by the
+// variable with _FILE at the end instead, like THYRA_ADMINTOKEN_FILE, for
+// those mounted into containers.
+const envPrefix = "THYRA_"
+
+// hostKeyEnv is the host key of the server, in PEM, so servers in
+// containers keep theirs without the database. THYRA_HOST_KEY_FILE names the
+// file it is in instead.
+const hostKeyEnv = envPrefix + "HOST_KEY"
+
+// lookupEnv returns the variable, or the content of the file its _FILE
+// names, and whether either is set.
+func lookupEnv(name string) (string, bool, error) {
+	if path, ok := os.LookupEnv(name + "_FILE"); ok {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return "", false, fmt.Errorf("%s_FILE: %v", name, err)
+		}
+		return strings.TrimRight(string(data), "\r\n"), true, nil
+	}
+	v, ok := os.LookupEnv(name)
+	return v, ok, nil
+}
+
+// applyEnv sets the settings the environment has over those of server.toml.
+// Lists are separated by commas. The tables of many entries, like the peers
+// of the cluster, are only read from server.toml.
+func applyEnv(c *Config) error {
+	return envStruct(reflect.ValueOf(c).Elem(), envPrefix)
+}
+
+func envStruct(v reflect.Value, prefix string) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		key := strings.Split(t.Field(i).Tag.Get("toml"), ",")[0]
+		if key == "" || key == "-" {
+			continue
+		}
+		name := prefix + strings.ToUpper(key)
+		f := v.Field(i)
+		if f.Kind() == reflect.Struct {
+			if err := envStruct(f, name+"_"); err != nil {
+				return err
+			}
+			continue
+		}
+		val, ok, err := lookupEnv(name)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			continue
+		}
+		switch f.Kind() {
+		case reflect.String:
+			f.SetString(val)
+		case reflect.Int:
+			n, err := strconv.Atoi(val)
+			if err != nil {
+				return fmt.Errorf("%s is not a number: %q", name, val)
+			}
+			f.SetInt(int64(n))
+		case reflect.Float64:
+			n, err := strconv.ParseFloat(val, 64)
+			if err != nil {
+				return fmt.Errorf("%s is not a number: %q", name, val)
+			}
+			f.SetFloat(n)
+		case reflect.Bool:
+			b, err := strconv.ParseBool(val)
+			if err != nil {
+				return fmt.Errorf("%s is not true or false: %q", name, val)
+			}
+			f.SetBool(b)
+		case reflect.Slice:
+			if f.Type().Elem().Kind() != reflect.String {
+				return fmt.Errorf("%s can only be set in server.toml", name)
+			}
+			list := []string{}
+			for _, s := range strings.Split(val, ",") {
+				if s = strings.TrimSpace(s); s != "" {
+					list = append(list, s)
+				}
+			}
+			f.Set(reflect.ValueOf(list))
+		default:
+			return fmt.Errorf("%s can only be set in server.toml", name)
+		}
+	}
+	return nil
+}
+
+// hostKeyFromEnv returns the host key the environment gives, or nil if it
+// gives none.
+func hostKeyFromEnv() (ssh.Signer, error) {
+	val, ok, err := lookupEnv(hostKeyEnv)
+	if err != nil || !ok {
+		return nil, err
+	}
+	key, err := ssh.ParsePrivateKey([]byte(val))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", hostKeyEnv, err)
+	}
+	return key, nil
+}
@@ -0,0 +1,123 @@
+package server
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/droslean/thyranew/area"
+	"github.com/droslean/thyranew/game"
+	"github.com/gothyra/toml"
+
+	log "gopkg.in/inconshreveable/log15.v2"
+)
+
+// loadFactions loads the factions from the static directory into memory.
+func (s *Server) loadFactions() error {
+	path := s.staticDir + "/factions.toml"
+	fileContent, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		log.Info(fmt.Sprintf("%s could not be loaded: %v", path, err))
+		return err
+	}
+
+	factions := struct {
+		Factions []game.Faction `toml:"factions"`
+	}{}
+	if _, err := toml.Decode(string(fileContent), &factions); err != nil {
+		log.Info(fmt.Sprintf("%s could not be unmarshaled: %v", path, err))
+		return err
+	}
+
+	for _, f := range factions.Factions {
+		log.Info(fmt.Sprintf("Loaded faction %q", f.Name))
+		s.Factions[f.Name] = f
+	}
+	return nil
+}
+
+// standing returns the standing of the player with the faction.
+func (s *Server) standing(p *area.Player, faction string) int {
+	if standing, ok := p.Reputation[faction]; ok {
+		return standing
+	}
+	return s.Factions[faction].Default
+}
+
+// meetsStanding reports whether the player is at least at the given standing
+// with the faction. Shops and quests of a faction use it to serve only friends.
+func (s *Server) meetsStanding(p *area.Player, faction string, min int) bool {
+	return s.standing(p, faction) >= min
+}
+
+// adjustReputation changes the standing of the player with the faction and
+// saves the player, so standings survive restarts.
+func (s *Server) adjustReputation(p *area.Player, faction string, delta int) {
+	if _, ok := s.Factions[faction]; !ok || delta == 0 {
+		return
+	}
+	if p.Reputation == nil {
+		p.Reputation = make(map[string]int)
+	}
+	p.Reputation[faction] = game.ClampStanding(s.standing(p, faction) + delta)
+	if err := s.savePlayer(p); err != nil {
+		log.Error(fmt.Sprintf("Cannot save player %q: %v", p.Nickname, err))
+	}
+}
+
+// reputationForKill adjusts the standings of the killer of the NPC: its faction
+// takes it badly, while the enemies of the faction are pleased.
+func (s *Server) reputationForKill(p *area.Player, npc area.NPC) {
+	if npc.Faction == "" {
+		return
+	}
+	s.adjustReputation(p, npc.Faction, -npc.Reputation)
+	for _, enemy := range s.Factions[npc.Faction].Enemies {
+		s.adjustReputation(p, enemy, npc.Reputation/2)
+	}
+}
+
+// guardsNotice makes the guards in the room of the player attack them on
+// sight, if the player is hostile to their faction or wanted by the law they
+// keep.
+func (s *Server) guardsNotice(roomsMap map[string]map[string][][]area.Cube, p *area.Player) {
+	for _, npc := range s.npcsInRoom(p.Area, p.Room) {
+		if p.Ghost || !npc.Guard || npc.Target != "" || (!game.IsHostile(s.standing(p, npc.Faction)) && !s.wantedBy(p, npc)) {
+			continue
+		}
+		if p.HideRoll > 0 && game.NPCPerception(npc.Level) < p.HideRoll {
+			continue
+		}
+		guard, _ := s.findNPC(npc.ID)
+		shout := fmt.Sprintf("%s shouts at %s and attacks\n", npc.Name, p.Nickname)
+		if s.arrests(npc, p) {
+			shout = fmt.Sprintf("%s shouts at %s to stop in the name of the law\n", npc.Name, p.Nickname)
+		}
+		s.printToRoom(roomsMap, p.Area, p.Room, tagged(TagCombat, shout))
+		s.provoke(roomsMap, guard, p.Nickname)
+	}
+}
+
+// reputation lists the standings of the player.
+func (s *Server) reputation(p *area.Player) string {
+	names := []string{}
+	for name := range s.Factions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	lines := []string{}
+	for _, name := range names {
+		standing := s.standing(p, name)
+		lines = append(lines, fmt.Sprintf("%s: %s (%d)", name, game.StandingLevel(standing), standing))
+	}
+	if len(lines) == 0 {
+		return "There are no factions\n"
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
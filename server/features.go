@@ -0,0 +1,226 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/droslean/thyranew/area"
+
+	log "gopkg.in/inconshreveable/log15.v2"
+)
+
+// Subsystems admins can turn off while the server runs, when one misbehaves,
+// and back on, without a restart. New subsystems are named here, and either
+// have their commands in featureCommands, or ask featureOn before they do
+// anything.
+const (
+	featurePvP        = "pvp"
+	featureShops      = "shops"
+	featureMail       = "mail"
+	featureScripts    = "scripts"
+	featureEnchanting = "enchanting"
+	featureTheft      = "theft"
+	featureVehicles   = "vehicles"
+	featureDungeons   = "dungeons"
+	featureIntermud   = "intermud"
+	featureRecording  = "recording"
+	featureEvents     = "events"
+	featureJobs       = "jobs"
+	// eventFeature goes before the name of a world event of the calendar, to
+	// turn it off alone.
+	eventFeature = "event:"
+)
+
+// subsystems are those that can be turned off, and what they are.
+var subsystems = []struct{ Name, What string }{
+	{featurePvP, "fights and theft between players, in the arenas too"},
+	{featureShops, "buying from the shops"},
+	{featureMail, "sending and reading mail"},
+	{featureScripts, "the scripts of players, stopping those running"},
+	{featureEnchanting, "enchanting, socketing and engraving items"},
+	{featureTheft, "stealing"},
+	{featureVehicles, "boarding and steering vehicles"},
+	{featureDungeons, "entering dungeons"},
+	{featureIntermud, "the channels shared with other MUDs"},
+	{featureRecording, "recording sessions and playing them back"},
+	{featureEvents, "the world events of the calendar, ending those on"},
+	{featureJobs, "the jobs the server runs on a schedule"},
+}
+
+// featureCommands are the commands of the subsystems, which go when their
+// subsystem is turned off.
+var featureCommands = map[string]string{
+	"list": featureShops, "buy": featureShops,
+	"mail":   featureMail,
+	"script": featureScripts, "run": featureScripts,
+	"enchant": featureEnchanting, "socket": featureEnchanting, "engrave": featureEnchanting,
+	"steal": featureTheft,
+	// Those aboard can still get off.
+	"board": featureVehicles, "pilot": featureVehicles, "steer": featureVehicles,
+	"dungeon": featureDungeons,
+	"record":  featureRecording, "playback": featureRecording,
+}
+
+// loadFeatures reads which subsystems admins turned off.
+func (s *Server) loadFeatures() error {
+	switches, err := s.db.GetFeatures(context.Background())
+	if err != nil {
+		return err
+	}
+	s.features = switches
+	for _, f := range switches {
+		if f.Off {
+			log.Warn(fmt.Sprintf("%s is turned off, since %s turned it off on %s", f.Feature, f.By, f.Changed.Format("2006-01-02 15:04")))
+		}
+	}
+	return nil
+}
+
+// featureOn reports whether the subsystem is on. Subsystems are on unless
+// an admin turned them off.
+func (s *Server) featureOn(name string) bool {
+	f, ok := s.features[name]
+	return !ok || !f.Off
+}
+
+// featureName returns the subsystem by the name given, whatever its case,
+// and false if it can't be turned off.
+func (s *Server) featureName(name string) (string, bool) {
+	for _, f := range subsystems {
+		if strings.EqualFold(f.Name, name) {
+			return f.Name, true
+		}
+	}
+	if strings.HasPrefix(strings.ToLower(name), eventFeature) {
+		for _, e := range s.calendar {
+			if strings.EqualFold(e.Name, name[len(eventFeature):]) {
+				return eventFeature + e.Name, true
+			}
+		}
+	}
+	return "", false
+}
+
+// setFeature turns the subsystem on or off, for good until an admin turns
+// it back.
+func (s *Server) setFeature(name string, on bool, by, reason string) error {
+	f := FeatureSwitch{Feature: name, Off: !on, By: by, Reason: reason, Changed: s.now()}
+	if err := s.db.PutFeature(context.Background(), f); err != nil {
+		return err
+	}
+	s.features[name] = f
+	log.Warn(fmt.Sprintf("%s turned %s %s: %s", by, name, onOffOf(on), reason))
+	return nil
+}
+
+// featureOff returns what players are told when they use a subsystem that
+// is turned off.
+func (s *Server) featureOff(name string) string {
+	if reason := s.features[name].Reason; reason != "" {
+		return fmt.Sprintf("That is turned off for now: %s\n", reason)
+	}
+	return "That is turned off for now\n"
+}
+
+// listFeatures tells admins which subsystems are on, and who turned off
+// those that are not.
+func (s *Server) listFeatures() string {
+	lines := []string{}
+	line := func(name, what string) string {
+		f, ok := s.features[name]
+		if !ok {
+			return fmt.Sprintf("%-12s on   %s", name, what)
+		}
+		text := fmt.Sprintf("%-12s %-4s %s, by %s on %s", name, onOffOf(!f.Off), what, f.By, f.Changed.Format("2006-01-02 15:04"))
+		if f.Reason != "" {
+			text += ": " + f.Reason
+		}
+		return text
+	}
+	for _, f := range subsystems {
+		lines = append(lines, line(f.Name, f.What))
+	}
+	events := []string{}
+	for name := range s.features {
+		if strings.HasPrefix(name, eventFeature) {
+			events = append(events, name)
+		}
+	}
+	sort.Strings(events)
+	for _, name := range events {
+		lines = append(lines, line(name, "the world event"))
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// toggleFeature lists the subsystems, or turns one of them on or off. World
+// events of the calendar are turned off alone as event:<name>.
+// Usage: features [<name> on|off [reason]]
+func (s *Server) toggleFeature(admin *area.Player, args []string) string {
+	if len(args) == 0 {
+		return s.listFeatures()
+	}
+	if len(args) < 2 || (args[1] != "on" && args[1] != "off") {
+		return "Usage: features [<name> on|off [reason]]\n"
+	}
+	name, ok := s.featureName(args[0])
+	if !ok {
+		return fmt.Sprintf("There is no %s to turn on or off, type features for those there are\n", args[0])
+	}
+	on := args[1] == "on"
+	if err := s.setFeature(name, on, admin.Nickname, strings.Join(args[2:], " ")); err != nil {
+		log.Error(fmt.Sprintf("Cannot turn %s %s: %v", name, args[1], err))
+		return "Something went wrong, try again\n"
+	}
+	return fmt.Sprintf("%s is turned %s\n", name, args[1])
+}
+
+// serveFeatures is the admin API of the subsystems. GET lists those that
+// were turned off or back on, as JSON; POST turns the feature on or off, as
+// on says, with the admin it is done by and an optional reason.
+func (s *Server) serveFeatures(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && s.config.AdminToken == "" {
+		http.Error(w, "features can only be turned on or off with an admin token", http.StatusForbidden)
+		return
+	}
+	switches := []FeatureSwitch{}
+	var err error
+	if !s.inWorld(r.Context(), func() {
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodPost:
+			name, known := s.featureName(r.FormValue("feature"))
+			by := r.FormValue("by")
+			on, perr := strconv.ParseBool(r.FormValue("on"))
+			switch {
+			case by == "":
+				err = apiErrorf(http.StatusBadRequest, "by is required")
+			case perr != nil:
+				err = apiErrorf(http.StatusBadRequest, "on is true or false")
+			case !known:
+				err = apiErrorf(http.StatusNotFound, "there is no feature %q", r.FormValue("feature"))
+			default:
+				err = s.setFeature(name, on, by, r.FormValue("reason"))
+			}
+		default:
+			err = apiErrorf(http.StatusMethodNotAllowed, "method not allowed")
+		}
+		for _, f := range s.features {
+			switches = append(switches, f)
+		}
+	}) {
+		return
+	}
+	if err != nil {
+		replyError(w, err)
+		return
+	}
+	sort.Slice(switches, func(i, j int) bool { return switches[i].Feature < switches[j].Feature })
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(switches)
+}
@@ -0,0 +1,359 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/droslean/thyranew/area"
+	"github.com/droslean/thyranew/game"
+	"github.com/droslean/thyranew/sftp"
+	"github.com/gothyra/toml"
+	"golang.org/x/crypto/ssh"
+
+	log "gopkg.in/inconshreveable/log15.v2"
+)
+
+// reloadDelay is how long what builders put waits before it is loaded again,
+// so a builder putting many files has them loaded once.
+const reloadDelay = 5 * time.Second
+
+// worldFile is a file of the static directory builders reach, or a directory
+// of them.
+type worldFile struct {
+	dir bool
+	// check reads a file put there the way the server reads it.
+	check func(data string) error
+	// reload loads the file put again while the game runs, on the world.
+	// Without it, what was put is loaded at the next start.
+	reload func(s *Server, file string) string
+}
+
+// worldFiles are what builders reach of the static directory, over SFTP. The
+// players, the settings of the server and the web pages are not among them.
+var worldFiles = map[string]worldFile{
+	"areas": {dir: true, check: func(data string) error {
+		a := area.Area{}
+		return decodeNamed(data, &a, &a.Name)
+	}, reload: reloadAreaFile},
+	"dialogues": {dir: true, check: func(data string) error {
+		d := area.Dialogue{}
+		return decodeNamed(data, &d, &d.NPC)
+	}, reload: reloadWith((*Server).loadDialogues, "dialogues")},
+	"loot": {dir: true, check: func(data string) error {
+		t := game.LootTable{}
+		return decodeNamed(data, &t, &t.Name)
+	}, reload: reloadWith((*Server).loadLootTables, "loot tables")},
+	"shops": {dir: true, check: func(data string) error {
+		shop := area.Shop{}
+		return decodeNamed(data, &shop, &shop.Name)
+	}, reload: reloadWith((*Server).loadShops, "shops")},
+	"vehicles": {dir: true, check: func(data string) error {
+		v := area.Vehicle{}
+		return decodeNamed(data, &v, &v.Name)
+	}, reload: func(s *Server, _ string) string { return s.reloadVehicles() }},
+	"wilderness": {dir: true, check: decodeAny, reload: reloadWith((*Server).loadWilderness, "wilderness")},
+	"items.toml": {check: func(data string) error {
+		items := struct {
+			Items []area.Item `toml:"items"`
+		}{}
+		_, err := toml.Decode(data, &items)
+		return err
+	}, reload: func(s *Server, _ string) string { return s.reloadItems() }},
+	"skills.toml": {check: func(data string) error {
+		tables := struct {
+			Classes []area.SkillTable `toml:"classes"`
+		}{}
+		_, err := toml.Decode(data, &tables)
+		return err
+	}, reload: reloadWith((*Server).loadSkillTables, "skills")},
+	"achievements.toml": {check: decodeAny, reload: reloadWith((*Server).loadAchievements, "achievements")},
+	"factions.toml":     {check: decodeAny, reload: reloadWith((*Server).loadFactions, "factions")},
+	"hints.toml":        {check: decodeAny, reload: reloadWith((*Server).loadHints, "hints")},
+	"socials.toml":      {check: decodeAny, reload: reloadWith((*Server).loadSocials, "socials")},
+	"calendar.toml":     {check: decodeAny, reload: reloadWith((*Server).loadCalendar, "world events")},
+	"content.toml":      {check: decodeAny, reload: reloadWith((*Server).loadContentFlags, "content flags")},
+}
+
+// decodeNamed reads a file of a directory, which has to give the name of
+// what it holds.
+func decodeNamed(data string, v interface{}, name *string) error {
+	if _, err := toml.Decode(data, v); err != nil {
+		return err
+	}
+	if *name == "" {
+		return fmt.Errorf("it has no name")
+	}
+	return nil
+}
+
+// decodeAny reads a file as TOML, whatever it holds.
+func decodeAny(data string) error {
+	v := map[string]interface{}{}
+	_, err := toml.Decode(data, &v)
+	return err
+}
+
+// reloadWith returns the reload of what the loader loads, whichever of its
+// files was put.
+func reloadWith(load func(s *Server) error, what string) func(s *Server, file string) string {
+	return func(s *Server, _ string) string {
+		if err := load(s); err != nil {
+			return fmt.Sprintf("The %s did not load again: %v", what, err)
+		}
+		return fmt.Sprintf("Reloaded the %s", what)
+	}
+}
+
+// reloadAreaFile puts the area the file holds in the world, the way
+// publishing it does, in place of the one it held before it was put, which
+// is its newest version.
+func reloadAreaFile(s *Server, file string) string {
+	data, err := ioutil.ReadFile(filepath.Join(s.staticDir, filepath.FromSlash(file)))
+	if err != nil {
+		return fmt.Sprintf("%s did not load again: %v", file, err)
+	}
+	var old []byte
+	if versions, err := s.versions(file); err == nil && len(versions) > 0 {
+		if old, err = ioutil.ReadFile(versions[len(versions)-1]); err != nil {
+			return fmt.Sprintf("%s did not load again: %v", file, err)
+		}
+	}
+	if err := s.checkWorldFile(file, data); err != nil {
+		return fmt.Sprintf("%s was not put in the world: %v", file, err)
+	}
+	if err := s.putArea(file, old, data); err != nil {
+		return fmt.Sprintf("%s was not put in the world: %v", file, err)
+	}
+	return fmt.Sprintf("Reloaded %s", file)
+}
+
+// reloadKey is what the file put is loaded again by: the file for areas,
+// which are put in the world one at a time, and where it is for the rest,
+// which are loaded whole.
+func reloadKey(file string) string {
+	if strings.HasPrefix(file, "areas/") {
+		return file
+	}
+	return strings.Split(file, "/")[0]
+}
+
+// worldVisible reports whether builders see the file of the static
+// directory, by its path there.
+func worldVisible(name string, dir bool) bool {
+	parts := strings.Split(name, "/")
+	wf, ok := worldFiles[parts[0]]
+	switch {
+	case !ok:
+		return false
+	case len(parts) == 1:
+		return dir == wf.dir
+	case !wf.dir:
+		return false
+	}
+	return dir || strings.HasSuffix(name, ".toml") || parts[0] == "wilderness" && strings.HasSuffix(name, ".map")
+}
+
+// sessionRequests waits for the session to ask for the game or for the
+// files. It returns the request of the subsystem if it asks for the files,
+// and the requests for the game, starting with those it sent first, either
+// way.
+func sessionRequests(ctx context.Context, reqs <-chan *ssh.Request) (*ssh.Request, <-chan *ssh.Request) {
+	early := []*ssh.Request{}
+	for {
+		var r *ssh.Request
+		open := true
+		select {
+		case r, open = <-reqs:
+		case <-ctx.Done():
+			open = false
+		}
+		if open && r.Type == "subsystem" {
+			return r, reqs
+		}
+		if open {
+			early = append(early, r)
+		}
+		// What the terminal is like comes before the shell.
+		if !open || r.Type != "env" {
+			break
+		}
+	}
+	replay := make(chan *ssh.Request, len(early))
+	for _, r := range early {
+		replay <- r
+	}
+	go func() {
+		defer close(replay)
+		for r := range reqs {
+			replay <- r
+		}
+	}()
+	return nil, replay
+}
+
+// serveFiles serves the files of the world to a builder over SFTP. Only the
+// keys the builder played with are taken, since the game lets anyone in
+// under a new name.
+func (s *Server) serveFiles(ctx context.Context, nick, hash string, ch ssh.Channel, req *ssh.Request, reqs <-chan *ssh.Request) {
+	go ssh.DiscardRequests(reqs)
+	defer ch.Close()
+
+	sub := struct{ Name string }{}
+	if err := ssh.Unmarshal(req.Payload, &sub); err != nil || sub.Name != "sftp" {
+		req.Reply(false, nil)
+		return
+	}
+	if why := s.mayBuild(ctx, nick, hash); why != "" {
+		log.Warn(fmt.Sprintf("%q refused the files of the world: %s", nick, why))
+		req.Reply(false, nil)
+		ch.Stderr().Write([]byte("Only builders reach the files of the world\r\n"))
+		return
+	}
+	req.Reply(true, nil)
+
+	log.Info(fmt.Sprintf("%q opened the files of the world", nick))
+	files := &sftp.Files{
+		Root:    s.staticDir,
+		Visible: worldVisible,
+		Put: func(name string, data []byte) error {
+			err := s.putWorldFile(ctx, nick, name, data)
+			if err != nil {
+				// Clients only say the put failed, but show what comes on
+				// the side.
+				ch.Stderr().Write([]byte(err.Error() + "\r\n"))
+			}
+			return err
+		},
+	}
+	if err := files.Serve(ch); err != nil && ctx.Err() == nil {
+		log.Warn(fmt.Sprintf("The files of the world for %q stopped: %v", nick, err))
+	}
+	log.Info(fmt.Sprintf("%q closed the files of the world", nick))
+}
+
+// mayBuild returns why the player may not reach the files of the world with
+// the key, or nothing if they may.
+func (s *Server) mayBuild(ctx context.Context, nick, hash string) string {
+	if !IsValidUsername(nick) || hash == "" {
+		return "no player or no key"
+	}
+	var p area.Player
+	found := false
+	if !s.inWorld(ctx, func() { p, found = s.playerOf(nick) }) {
+		return "the world is busy"
+	}
+	if !found || !isBuilder(&p) {
+		return "not a builder"
+	}
+	id, err := s.db.GetIdentity(ctx, nick)
+	if err != nil {
+		return fmt.Sprintf("cannot read the identity: %v", err)
+	}
+	if _, ok := id.Fingerprints[hash]; !ok {
+		return "a key never played with"
+	}
+	return ""
+}
+
+// putWorldFile puts the file a builder wrote in the static directory, if it
+// reads the way the server reads it, and queues what it holds to be loaded
+// again. When the edits are reviewed, it is staged for an admin to publish
+// instead, like the edits of the admin API.
+func (s *Server) putWorldFile(ctx context.Context, nick, name string, data []byte) error {
+	wf := worldFiles[strings.Split(name, "/")[0]]
+	if !utf8.Valid(data) {
+		return fmt.Errorf("%s is not text", name)
+	}
+	if strings.HasSuffix(name, ".toml") {
+		if err := wf.check(string(data)); err != nil {
+			return fmt.Errorf("%s does not load: %v", name, err)
+		}
+	}
+	var err error
+	if !s.inWorld(ctx, func() { err = s.putWorld(nick, path.Clean(name), data) }) {
+		return fmt.Errorf("%s cannot be written", name)
+	}
+	return err
+}
+
+// putWorld stages the file put, or writes it and queues it to be loaded
+// again. It runs on the world.
+func (s *Server) putWorld(nick, name string, data []byte) error {
+	if s.config.Review {
+		areaName := ""
+		if strings.HasPrefix(name, "areas/") {
+			a := area.Area{}
+			toml.Decode(string(data), &a)
+			areaName = a.Name
+		}
+		if _, err := s.writeWorld(name, areaName, nick, data); err != nil {
+			log.Error(fmt.Sprintf("Cannot stage %s for %q: %v", name, nick, err))
+			return fmt.Errorf("%s cannot be staged", name)
+		}
+		return nil
+	}
+
+	full := filepath.Join(s.staticDir, filepath.FromSlash(name))
+	key := reloadKey(name)
+	// What the file held before the builder put it stays as a version to
+	// roll back to, and to know what the reload replaces.
+	if _, queued := s.reloads[key]; !queued && keepsVersions(name) {
+		old, err := ioutil.ReadFile(full)
+		if err == nil || os.IsNotExist(err) {
+			err = s.keepVersion(name, old)
+		}
+		if err != nil {
+			log.Error(fmt.Sprintf("Cannot keep the version of %s before %q put it: %v", name, nick, err))
+			return fmt.Errorf("%s cannot be written", name)
+		}
+	}
+	if err := writeAtomic(full, data); err != nil {
+		log.Error(fmt.Sprintf("Cannot write %s for %q: %v", name, nick, err))
+		return fmt.Errorf("%s cannot be written", name)
+	}
+	log.Info(fmt.Sprintf("%q put %s", nick, name))
+	s.queueReload(key, nick)
+	return nil
+}
+
+// keepsVersions reports whether the versions of the file are kept, to roll
+// back to.
+func keepsVersions(file string) bool {
+	return file == "items.toml" || strings.HasPrefix(file, "areas/")
+}
+
+// queueReload queues what was put to be loaded again by the game, after the
+// others the builders put in the meantime.
+func (s *Server) queueReload(key, nick string) {
+	if len(s.reloads) == 0 {
+		s.after(reloadDelay, s.runReloads)
+	}
+	s.reloads[key] = nick
+}
+
+// runReloads loads again what the builders put, and tells those in the game
+// how it went.
+func (s *Server) runReloads() {
+	lines := []string{}
+	for key, nick := range s.reloads {
+		msg := fmt.Sprintf("%s is loaded at the next start", key)
+		if reload := worldFiles[strings.Split(key, "/")[0]].reload; reload != nil {
+			msg = strings.TrimSuffix(reload(s, key), "\n")
+		}
+		lines = append(lines, fmt.Sprintf("%s put %s: %s", nick, key, msg))
+	}
+	s.reloads = make(map[string]string)
+	sort.Strings(lines)
+	for _, line := range lines {
+		log.Info(line)
+	}
+	s.broadcast(s.world.rooms, Broadcast{Kind: TagSystem, Text: strings.Join(lines, "\n") + "\n", Filter: isBuilder})
+}
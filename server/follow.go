@@ -0,0 +1,127 @@
+package server
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/droslean/thyranew/area"
+)
+
+// follow makes the player go along wherever another player goes, or stop
+// following with no name.
+// Usage: follow [player]
+func (s *Server) follow(roomsMap map[string]map[string][][]area.Cube, p *area.Player, args []string) string {
+	if len(args) == 0 {
+		if p.Following == "" {
+			return "You are not following anyone\n"
+		}
+		leader := p.Following
+		p.Following = ""
+		s.tellPlayer(roomsMap, leader, fmt.Sprintf("%s stops following you\n", p.Nickname))
+		return fmt.Sprintf("You stop following %s\n", leader)
+	}
+	name := strings.Join(args, " ")
+	o, ok := s.playerHere(p, name)
+	if !ok {
+		return fmt.Sprintf("You don't see %s here\n", name)
+	}
+	if err := s.consent(p, o, actFollow); err != nil {
+		return err.Error() + "\n"
+	}
+	p.Following = o.Nickname
+	s.tellPlayer(roomsMap, o.Nickname, fmt.Sprintf("%s follows you\n", p.Nickname))
+	return fmt.Sprintf("You follow %s\n", o.Nickname)
+}
+
+// tellPlayer shows the message to the player, if online.
+func (s *Server) tellPlayer(roomsMap map[string]map[string][][]area.Cube, nick, msg string) {
+	if cl, ok := s.clientByNick(nick); ok {
+		s.godPrintRoom([]Client{*cl}, roomsMap, msg, "")
+	}
+}
+
+// lead takes the followers of the player along, once the player moved away
+// from the cube at in the direction. Followers who were in the room with the
+// player cross it to where the player was, and take the door the player took.
+// Those who no longer have the consent of the player stop following.
+func (s *Server) lead(roomsMap map[string]map[string][][]area.Cube, leader *area.Player, at area.Location, direction int) {
+	for _, other := range s.OnlineClientsGetByRoom(at.Area, at.Room) {
+		if other.Player.Following != leader.Nickname {
+			continue
+		}
+		cl, ok := s.clientByNick(other.Player.Nickname)
+		if !ok {
+			continue
+		}
+		f := cl.Player
+		if err := s.consent(f, leader, actFollow); err != nil {
+			f.Following = ""
+			s.godPrintRoom([]Client{*cl}, roomsMap, fmt.Sprintf("%s, so you stop following\n", err), "")
+			continue
+		}
+
+		steps := []int{direction}
+		if _, ok := s.Wilderness[at.Area]; !ok {
+			mapArray := roomsMap[at.Area][at.Room]
+			to := at.Position
+			if leader.Area != at.Area || leader.Room != at.Room {
+				// The cube the leader stepped on took them away.
+				to, _ = cubeToward(mapArray, at.Position, direction)
+			}
+			if steps, _, ok = cubePath(mapArray, f.Position, to); !ok {
+				s.godPrintRoom([]Client{*cl}, roomsMap, fmt.Sprintf("You lose track of %s\n", leader.Nickname), "")
+				continue
+			}
+		}
+		for _, dir := range steps {
+			before := f.Location
+			s.dispatch(roomsMap, Event{Client: cl, EventType: directionCommands[dir], walk: true})
+			// Whatever stopped the step stops the rest of the way.
+			if f.Location == before {
+				break
+			}
+		}
+	}
+}
+
+// cubeToward returns the cube next to the given one in the direction.
+func cubeToward(mapArray [][]area.Cube, id string, direction int) (string, bool) {
+	x, y, ok := area.FindCube(mapArray, id)
+	if !ok {
+		return "", false
+	}
+	x, y = x+directionOffsets[direction][0], y+directionOffsets[direction][1]
+	if x < 0 || x >= len(mapArray) || y < 0 || y >= len(mapArray[x]) {
+		return "", false
+	}
+	return mapArray[x][y].ID, true
+}
+
+// group returns the players in the room who go about with the player: the
+// player first, whoever they follow, whoever follows them, and so on.
+func (s *Server) group(p *area.Player) []*area.Player {
+	here := s.OnlineClientsGetByRoom(p.Area, p.Room)
+	present := map[string]bool{}
+	for _, c := range here {
+		present[c.Player.Nickname] = true
+	}
+	in := map[string]bool{p.Nickname: true}
+	// Each follower joins the group of their leader, and the other way around.
+	for grew := true; grew; {
+		grew = false
+		for _, c := range here {
+			a, b := c.Player.Nickname, c.Player.Following
+			if present[b] && in[a] != in[b] {
+				in[a], in[b] = true, true
+				grew = true
+			}
+		}
+	}
+	group := []*area.Player{p}
+	for _, c := range here {
+		if in[c.Player.Nickname] && c.Player.Nickname != p.Nickname {
+			group = append(group, c.Player)
+		}
+	}
+	return group
+}
@@ -0,0 +1,151 @@
+package server
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/droslean/thyranew/area"
+)
+
+// defaultFrameRate is how many times a second the screen of a player is
+// redrawn at most.
+const defaultFrameRate = 10
+
+// frameCellBytes is about how many bytes a cell of the screen takes in a
+// frame, with the colors.
+const frameCellBytes = 4
+
+// frameBuffers hold the bytes of a frame on their way to the client. A frame
+// of a big terminal runs to tens of kilobytes, so they are reused rather than
+// left to the garbage collector.
+var frameBuffers = sync.Pool{
+	New: func() interface{} {
+		b := make([]byte, 0, 16*1024)
+		return &b
+	},
+}
+
+// frame gathers the output for the screen of a player until it is drawn.
+type frame struct {
+	pieces []TaggedText
+	due    bool      // A redraw is scheduled
+	last   time.Time // When the screen was last drawn
+	screen *Screen   // What the screen was last drawn on
+	// What the mouse and the scrolling of the panes need to know.
+	mouse  bool            // The terminal reports the mouse
+	clicks map[cell]string // What clicking the cells of the last screen runs
+	past   []string        // The latest lines of messages
+	scroll map[string]int  // How far back each scrolled pane is
+}
+
+// frameOf returns the frame of the player, starting one if there is none.
+func (s *Server) frameOf(nick string) *frame {
+	f, ok := s.frames[nick]
+	if !ok {
+		f = &frame{}
+		s.frames[nick] = f
+	}
+	return f
+}
+
+// frameInterval returns how long the screen of the player waits between
+// redraws. A frame sent before the last reached the player only waits behind
+// it, so players on a slow link are redrawn no faster than the round trip.
+func (s *Server) frameInterval(nick string) time.Duration {
+	// The clock of a simulation doesn't move on its own, so every frame is
+	// drawn right away.
+	if s.sim != nil {
+		return 0
+	}
+	rate := s.config.FrameRate
+	if rate <= 0 {
+		rate = defaultFrameRate
+	}
+	interval := time.Second / time.Duration(rate)
+	if rtt, ok := s.pingOf(nick); ok && rtt > interval {
+		interval = rtt
+		if interval > maxFrameInterval {
+			interval = maxFrameInterval
+		}
+	}
+	return interval
+}
+
+// queueFrame adds the output to the next frame of the client. The screen is
+// drawn right away when the last frame is old enough. Otherwise the output
+// waits for the frame that is due, so a burst of updates in a busy fight is
+// drawn once. Only the status line is drawn in between, since that is what
+// players watch their vitals on.
+func (s *Server) queueFrame(c Client, roomsMap map[string]map[string][][]area.Cube, pieces []TaggedText) {
+	nick := c.Player.Nickname
+	f := s.frameOf(nick)
+	f.pieces = append(f.pieces, pieces...)
+	if f.due {
+		s.drawVitals(c)
+		return
+	}
+
+	wait := s.frameInterval(nick) - time.Since(f.last)
+	if wait <= 0 {
+		s.flushFrame(c, roomsMap)
+		return
+	}
+	f.due = true
+	s.after(wait, func() {
+		cl, ok := s.clientByNick(nick)
+		if !ok {
+			delete(s.frames, nick)
+			return
+		}
+		if !cl.Player.Replaying {
+			s.flushFrame(*cl, roomsMap)
+		}
+	})
+}
+
+// flushFrame draws the frame of the client with all the output gathered for it.
+func (s *Server) flushFrame(c Client, roomsMap map[string]map[string][][]area.Cube) {
+	f, ok := s.frames[c.Player.Nickname]
+	if !ok {
+		return
+	}
+	f.due, f.last = false, time.Now()
+	s.drawFrame(c, roomsMap, s.prioritize(c.Player, f.pieces))
+	// Nothing holds on to the output once it is drawn.
+	f.pieces = f.pieces[:0]
+}
+
+// frameScreen returns a blank screen to draw the next frame of the client on.
+// The screen of the last frame is reused, unless the terminal was resized.
+func (s *Server) frameScreen(c Client) *Screen {
+	f, ok := s.frames[c.Player.Nickname]
+	if !ok {
+		return NewScreen(c.w, c.h)
+	}
+	if f.screen == nil || f.screen.width != c.w || f.screen.height != c.h {
+		f.screen = NewScreen(c.w, c.h)
+		return f.screen
+	}
+	f.screen.reset()
+	return f.screen
+}
+
+// drawVitals redraws the status line alone and returns the cursor to the
+// prompt bar.
+func (s *Server) drawVitals(c Client) {
+	s.drawStatusLine(c)
+	c.writeGoto(c.h-1, c.promptBar.position+1)
+}
+
+// lastLines returns the last n lines of the text.
+func lastLines(text string, n int) string {
+	lines := strings.SplitAfter(text, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	if len(lines) <= n {
+		return text
+	}
+	return strings.Join(lines[len(lines)-n:], "")
+}
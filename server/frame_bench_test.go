@@ -0,0 +1,44 @@
+package server
+
+import (
+	"fmt"
+	"runtime"
+	"testing"
+)
+
+// benchPlayers is how many players are online while frames are drawn.
+const benchPlayers = 500
+
+// BenchmarkFrames draws a frame for every player online, with a line of
+// output for each. Besides the allocations it reports how many times the
+// garbage collector ran for each round of frames.
+func BenchmarkFrames(b *testing.B) {
+	b.Setenv("THYRA_MAXPLAYERS", fmt.Sprint(benchPlayers))
+	h := startHarness(b, &Simulation{Seed: 1, Start: scenarioStart})
+	players := make([]*FakePlayer, benchPlayers)
+	for i := range players {
+		players[i] = connect(b, h, fmt.Sprintf("player%d", i))
+	}
+	s := h.Server
+	pieces := []TaggedText{{Text: "The wind picks up.\n"}}
+
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if !s.inWorld(h.ctx, func() {
+			for _, c := range s.OnlineClients() {
+				s.queueFrame(c, s.world.rooms, pieces)
+			}
+		}) {
+			b.Fatal("the world stopped")
+		}
+		for _, p := range players {
+			p.pipe.discard()
+		}
+	}
+	b.StopTimer()
+	runtime.ReadMemStats(&after)
+	b.ReportMetric(float64(after.NumGC-before.NumGC)/float64(b.N), "gcs/op")
+}
@@ -4,10 +4,12 @@ import (
 	"bytes"
 	"fmt"
 	"strconv"
-	"sync"
+	"strings"
 	"time"
+	"unicode/utf8"
 
 	"github.com/droslean/thyranew/area"
+	"github.com/droslean/thyranew/game"
 
 	"github.com/jpillora/ansi"
 	log "gopkg.in/inconshreveable/log15.v2"
@@ -16,68 +18,827 @@ import (
 type Event struct {
 	Client    *Client
 	EventType string
+	walk      bool   // Sent by a walk the player clicked, not typed
+	key       string // A key that steers the open modal, instead of a typed line
 }
 
-func (s *Server) God(stopCh <-chan struct{}, wg *sync.WaitGroup) {
-	defer wg.Done()
+// dispatch runs the command of a player.
+func (s *Server) dispatch(roomsMap map[string]map[string][][]area.Cube, ev Event) {
+	log.Debug(fmt.Sprintf("Event type : %s", ev.EventType))
+	cl := ev.Client
+	online := s.OnlineClientsGetByRoom(cl.Player.Area, cl.Player.Room)
+	for i := range online {
+		log.Debug(fmt.Sprintf("Clients in room %s", online[i].Player.Nickname))
+	}
 
-	roomsMap := make(map[string]map[string][][]area.Cube)
-	for _, a := range s.Areas {
-		roomsMap[a.Name] = make(map[string][][]area.Cube)
-		for _, room := range a.Rooms {
-			roomsMap[a.Name][room.Name] = s.CreateRoom(a.Name, room.Name)
+	msg := ""
+	// event is what the command did that may show the player a hint.
+	event := ""
+	cmd, args := parseCommand(ev.EventType)
+	// A click runs what is under the mouse, as if it was typed.
+	if cmd == "click" {
+		if ev.EventType = s.clicked(cl, args); ev.EventType == "" {
+			return
+		}
+		cmd, args = parseCommand(ev.EventType)
+	}
+	// An open modal takes the keys, and the lines it knows what to do with.
+	if (ev.key != "" || len(s.modals[cl.Player.Nickname]) > 0) && !ev.walk && s.modalInput(roomsMap, cl, ev) {
+		return
+	}
+	// Moving by hand stops a walk.
+	if moveCommands[cmd] && !ev.walk {
+		delete(s.walks, cl.Player.Nickname)
+	}
+	if cl.Player.Ghost && !ghostCommands[cmd] && !moveCommands[cmd] && !isAnswer(cmd) {
+		// Whatever else ghosts try ends up here.
+		cmd = "ghost"
+	}
+	if cl.Player.Jail.Jailed && !jailCommands[cmd] && !moveCommands[cmd] {
+		cmd = "jailed"
+	}
+	if _, ok := s.migrating[cl.Player.Nickname]; ok && cmd != "" && cmd != "quit" {
+		cmd = "migrating"
+	}
+	// Players who record have their sessions recorded from their first
+	// command on, and again once a recording fills its share of the quota.
+	if cl.Player.Recording && !cl.recorder.recording() && s.featureOn(featureRecording) {
+		if err := s.startRecording(cl); err != nil {
+			log.Error(fmt.Sprintf("Cannot record the session of %q: %v", cl.Player.Nickname, err))
 		}
 	}
 
-	msg := ""
+	if cl.Player.Watching != "" && !spectatorCommands[cmd] {
+		cmd = "spectating"
+	}
+	if globalCooldownCommands[cmd] && cooldowns[0].left(cl.Player, s.now()) > 0 {
+		cmd = "recovering"
+	}
+	// Subsystems turned off take their commands with them.
+	if feature, ok := featureCommands[cmd]; ok && !s.featureOn(feature) {
+		cmd, args = "turned-off", []string{feature}
+	}
 
-	for {
-		select {
-		case <-stopCh:
-			log.Info("God is exiting.")
-			return
-		case ev := <-s.Events:
-			log.Debug(fmt.Sprintf("Event type : %s", ev.EventType))
-			cl := ev.Client
-			online := s.OnlineClientsGetByRoom(cl.Player.Area, cl.Player.Room)
-			for i := range online {
-				log.Debug(fmt.Sprintf("Clients in room %s", online[i].Player.Nickname))
-			}
+	s.rememberCommand(cl.Player.Nickname, ev.EventType)
+	s.logCommand(cl.Player, ev.EventType)
+	if back := backAtKeyboard(cl.Player, cmd); back != "" {
+		s.godPrintRoom([]Client{*cl}, roomsMap, back, "")
+	}
 
-			switch ev.EventType {
-			case "e", "east":
-				msg = doMove(*cl, online, roomsMap, 0)
+	switch cmd {
+	case "e", "east":
+		msg = s.move(*cl, online, roomsMap, 0)
 
-			case "w", "west":
-				msg = doMove(*cl, online, roomsMap, 1)
+	case "w", "west":
+		msg = s.move(*cl, online, roomsMap, 1)
 
-			case "n", "north":
-				msg = doMove(*cl, online, roomsMap, 2)
+	case "n", "north":
+		msg = s.move(*cl, online, roomsMap, 2)
 
-			case "s", "south":
-				msg = doMove(*cl, online, roomsMap, 3)
+	case "s", "south":
+		msg = s.move(*cl, online, roomsMap, 3)
 
-			case "quit":
-				ev.Client.conn.Write(ansi.EraseScreen)
-				ev.Client.conn.Close()
-				s.clientLoggedOut(ev.Client.Name)
-			}
+	case "quit":
+		ev.Client.conn.Write(ansi.EraseScreen)
+		s.disconnect(ev.Client)
 
-			if msg == "door" {
-				log.Info("Enter door")
-				onlineCurrentRoom := s.OnlineClientsGetByRoom(cl.Player.Area, cl.Player.Room)
-				s.godPrintRoom(onlineCurrentRoom, roomsMap, "", fmt.Sprintf("%s enter the room.\n", cl.Player.Nickname))
-
-				onlinePreviousRoom := s.OnlineClientsGetByRoom(cl.Player.PreviousArea, cl.Player.PreviousRoom)
-				if onlinePreviousRoom != nil {
-					s.godPrintRoom(onlinePreviousRoom, roomsMap, "", fmt.Sprintf("%s left the room.\n", cl.Player.Nickname))
-				}
-			} else {
-				s.godPrintRoom(online, roomsMap, msg, "")
-			}
-			log.Debug(fmt.Sprintf("%s : %s", ev.Client.Name, ev.EventType))
+	case "lootsim":
+		msg = s.lootSimulate(cl.Player, args)
+		// Simulation results are only meant for the builder.
+		online = []Client{*cl}
+
+	case "reset":
+		msg = s.resetArea(roomsMap, cl.Player, args)
+		online = []Client{*cl}
+
+	case "open":
+		msg = s.setDoor(roomsMap, cl.Player, false)
+
+	case "close":
+		msg = s.setDoor(roomsMap, cl.Player, true)
+
+	case "lock":
+		msg = s.lockDoor(roomsMap, cl.Player, true)
+
+	case "unlock":
+		msg = s.lockDoor(roomsMap, cl.Player, false)
+
+	case "keys":
+		msg = s.keys(cl.Player)
+		online = []Client{*cl}
+
+	case "scan":
+		msg = s.scan(roomsMap, cl.Player, args)
+		online = []Client{*cl}
+
+	case "hide":
+		msg = s.hide(cl.Player)
+		online = []Client{*cl}
+
+	case "sneak":
+		msg = toggleSneak(cl.Player)
+		online = []Client{*cl}
+
+	case "shoot", "throw", "cast":
+		msg = s.rangedAttack(roomsMap, cl, cmd, args)
+		online = []Client{*cl}
+
+	case "reputation":
+		msg = s.reputation(cl.Player)
+		online = []Client{*cl}
+
+	case "talk":
+		msg = s.talk(cl.Player, args)
+		online = []Client{*cl}
+		if npc, _ := s.findNPC(cl.Player.Talking); npc != nil {
+			event = "talk " + npc.Name
+		}
+
+	case "say":
+		msg = s.respond(cl.Player, strings.Join(args, " "))
+		online = []Client{*cl}
+
+	case "channel":
+		msg = s.channel(cl.Player, args)
+		online = []Client{*cl}
+
+	case "tell":
+		msg = s.tell(roomsMap, cl.Player, args)
+		online = []Client{*cl}
+
+	case "cluster":
+		msg = s.clusterStatus(cl.Player)
+		online = []Client{*cl}
+	case "intermud":
+		msg = s.intermudStatus()
+		online = []Client{*cl}
+
+	case "migrate":
+		msg = s.migrate(cl, args)
+		online = []Client{*cl}
+
+	case "migrating":
+		msg = fmt.Sprintf("You are on your way to %s\n", s.migrating[cl.Player.Nickname])
+		online = []Client{*cl}
+
+	case "chat":
+		msg = s.chat(roomsMap, cl.Player, args)
+		online = []Client{*cl}
+
+	case "speak":
+		msg = s.speak(cl.Player, args)
+		online = []Client{*cl}
+
+	case "gender":
+		msg = s.gender(cl.Player, args)
+		online = []Client{*cl}
+
+	case "theme":
+		msg = s.theme(cl.Player, args)
+		online = []Client{*cl}
+
+	case "settings":
+		msg = s.settings(cl)
+		online = []Client{*cl}
+
+	case "describe":
+		msg = s.describe(cl, args)
+		online = []Client{*cl}
+
+	case "look":
+		msg = s.look(cl.Player, args)
+		online = []Client{*cl}
+
+	case "title":
+		msg = s.title(cl.Player, args)
+		online = []Client{*cl}
+
+	case "keywords":
+		msg = s.keywords(cl.Player, args)
+		online = []Client{*cl}
+
+	case "flag":
+		msg = s.flag(cl.Player, args)
+		online = []Client{*cl}
+
+	case "follow":
+		msg = s.follow(roomsMap, cl.Player, args)
+		online = []Client{*cl}
+
+	case "recovering":
+		msg = s.recovering(cl.Player)
+		online = []Client{*cl}
+
+	case "cooldowns":
+		msg = s.showCooldowns(cl.Player)
+		online = []Client{*cl}
+
+	case "history":
+		msg = s.history(cl.Player, args)
+		online = []Client{*cl}
+
+	case "obituaries":
+		msg = s.obituaries(cl.Player)
+		online = []Client{*cl}
+
+	case "link":
+		msg = s.link(cl.Player, args)
+		online = []Client{*cl}
+
+	case "unlink":
+		msg = s.unlink(cl.Player, args)
+		online = []Client{*cl}
+
+	case "export":
+		msg = s.exportCharacter(cl.Player, args)
+		online = []Client{*cl}
+
+	case "delete":
+		msg = s.deleteAccount(cl, args)
+		online = []Client{*cl}
+
+	case "flee":
+		msg = s.flee(roomsMap, cl.Player, args)
+		online = []Client{*cl}
+
+	case "tactics":
+		msg = s.tactics(cl.Player, args)
+		online = []Client{*cl}
+
+	case "attack", "kill":
+		msg = s.attack(roomsMap, cl, args)
+		online = []Client{*cl}
+
+	case "wield":
+		msg = s.wield(cl.Player, args)
+		online = []Client{*cl}
+
+	case "hold":
+		msg = s.hold(cl.Player, args)
+		online = []Client{*cl}
+
+	case "skills":
+		msg = weaponSkills(cl.Player)
+		online = []Client{*cl}
+
+	case "taunt":
+		msg = s.taunt(roomsMap, cl.Player, args)
+		online = []Client{*cl}
+
+	case "wanted":
+		msg = s.wanted(roomsMap, cl.Player, args)
+		online = []Client{*cl}
+
+	case "steal":
+		msg = s.steal(roomsMap, cl.Player, args)
+		online = []Client{*cl}
+
+	case "achievements":
+		msg = s.achievements(cl.Player)
+		online = []Client{*cl}
+
+	case "ignore":
+		msg = s.ignore(cl.Player, args)
+		online = []Client{*cl}
+
+	case "unignore":
+		msg = s.unignore(cl.Player, args)
+		online = []Client{*cl}
+
+	case "bye":
+		msg = s.bye(cl.Player)
+		online = []Client{*cl}
+
+	case "list":
+		msg = s.list(cl.Player)
+		online = []Client{*cl}
+
+	case "buy":
+		msg = s.buy(cl, args)
+		online = []Client{*cl}
+
+	case "practice":
+		msg = s.practice(cl.Player)
+		online = []Client{*cl}
+
+	case "train":
+		msg = s.train(cl, args)
+		online = []Client{*cl}
+
+	case "compare":
+		msg = s.compare(cl.Player, args)
+		online = []Client{*cl}
+
+	case "identify":
+		msg = s.identify(cl.Player, args)
+		online = []Client{*cl}
+
+	case "inspect":
+		msg = s.inspect(cl.Player, args)
+		online = []Client{*cl}
+
+	case "enchant":
+		msg = s.enchant(cl.Player, args)
+		online = []Client{*cl}
+
+	case "socket":
+		msg = s.socket(cl.Player, args)
+		online = []Client{*cl}
+
+	case "engrave":
+		msg = s.engrave(cl.Player, args)
+		online = []Client{*cl}
+
+	case "drop":
+		msg = s.drop(cl.Player, args)
+		online = []Client{*cl}
+
+	case "loot":
+		msg = s.loot(roomsMap, cl.Player, args)
+		online = []Client{*cl}
+
+	case "roll":
+		msg = s.roll(cl.Player, args)
+		online = []Client{*cl}
+
+	case "time":
+		msg = s.showTime()
+		online = []Client{*cl}
+
+	case "who":
+		msg = s.who(cl.Player, args)
+		online = []Client{*cl}
+
+	case "weather":
+		msg = s.showWeather(cl.Player)
+		online = []Client{*cl}
+
+	case "eat", "drink":
+		msg = s.consume(cl.Player, cmd, args)
+		online = []Client{*cl}
+
+	case "rest":
+		msg = s.rest(cl.Player, game.Resting)
+		online = []Client{*cl}
+
+	case "sleep":
+		msg = s.rest(cl.Player, game.Sleeping)
+		online = []Client{*cl}
+
+	case "stand", "wake":
+		msg = s.rest(cl.Player, game.Awake)
+		online = []Client{*cl}
+
+	case "watch":
+		msg = s.watch(cl.Player, args)
+		online = []Client{*cl}
+
+	case "stop":
+		msg = stopWatching(cl.Player)
+		online = []Client{*cl}
+
+	case "spectators":
+		msg = s.spectators(cl.Player, args)
+		online = []Client{*cl}
+
+	case "cheer", "jeer":
+		msg = s.cheer(roomsMap, cl.Player, cmd)
+		online = []Client{*cl}
+
+	case "spectating":
+		msg = fmt.Sprintf("You are watching %s. Type stop to stop watching\n", cl.Player.Watching)
+		online = []Client{*cl}
+
+	case "ghost":
+		msg = "You are a ghost and can't do that\n"
+		online = []Client{*cl}
+
+	case "turned-off":
+		msg = s.featureOff(args[0])
+		online = []Client{*cl}
+
+	case "jailed":
+		msg = fmt.Sprintf("You are in jail %s and can't do that\n", untilText(cl.Player.Jail.Until))
+		online = []Client{*cl}
+
+	case "warnings":
+		msg = s.warnings(cl.Player, args)
+		online = []Client{*cl}
+
+	case "release":
+		msg = s.release(roomsMap, cl.Player)
+		online = []Client{*cl}
+
+	case "revive":
+		msg = s.revive(cl.Player)
+
+	case "resurrect":
+		msg = s.resurrectOther(cl.Player, args)
+
+	case "bind":
+		msg = s.bind(cl.Player)
+		online = []Client{*cl}
+
+	case "recall":
+		msg = s.recall(roomsMap, cl.Player)
+		online = []Client{*cl}
+
+	case "goto", "transfer":
+		online = []Client{*cl}
+		if !isAdmin(cl.Player) {
+			msg = "Huh?\n"
+		} else if cmd == "goto" {
+			msg = s.gotoPlace(roomsMap, cl.Player, args)
+		} else {
+			msg = s.transfer(roomsMap, cl.Player, args)
+		}
+
+	case "board":
+		msg = s.board(roomsMap, cl.Player, args)
+		online = []Client{*cl}
+
+	case "disembark":
+		msg = s.disembark(roomsMap, cl.Player)
+		online = []Client{*cl}
+
+	case "pilot":
+		msg = s.takeHelm(cl.Player)
+
+	case "steer":
+		msg = s.steer(roomsMap, cl.Player, args)
+
+	case "events":
+		msg = s.listEvents()
+		online = []Client{*cl}
+
+	case "mail":
+		msg = s.mail(roomsMap, cl, args)
+		online = []Client{*cl}
+
+	case "prompt":
+		msg = s.prompt(cl.Player, args)
+		online = []Client{*cl}
+
+	case "combatlog":
+		msg = s.combatLog(cl.Player, args)
+		online = []Client{*cl}
+
+	case "tags":
+		msg = tags(cl.Player, args)
+		online = []Client{*cl}
+
+	case "terminal":
+		msg = s.terminal(cl, args)
+		online = []Client{*cl}
+
+	case "mouse":
+		msg = s.mouse(cl, args)
+		online = []Client{*cl}
+
+	case "scroll":
+		msg = s.scroll(cl, args)
+		online = []Client{*cl}
+
+	case "walk":
+		msg = s.walkTo(roomsMap, cl, args)
+		online = []Client{*cl}
+
+	case "record":
+		msg = s.record(cl, args)
+		online = []Client{*cl}
+
+	case "playback":
+		online = []Client{*cl}
+		if !isAdmin(cl.Player) {
+			msg = "Huh?\n"
+		} else {
+			msg = s.playback(roomsMap, cl, args)
 		}
+
+	case "highlight":
+		msg = s.highlightCmd(cl.Player, args)
+		online = []Client{*cl}
+
+	case "unhighlight":
+		msg = s.unhighlight(cl.Player, args)
+		online = []Client{*cl}
+
+	case "gag":
+		msg = s.gag(cl.Player, args)
+		online = []Client{*cl}
+
+	case "ungag":
+		msg = s.ungag(cl.Player, args)
+		online = []Client{*cl}
+
+	case "script":
+		msg = s.script(cl.Player, args)
+		online = []Client{*cl}
+
+	case "run":
+		msg = s.run(cl.Player, args)
+		online = []Client{*cl}
+
+	case "scripts":
+		online = []Client{*cl}
+		if !isAdmin(cl.Player) {
+			msg = "Huh?\n"
+		} else {
+			msg = s.scripts(args)
+		}
+
+	case "health":
+		online = []Client{*cl}
+		if !isAdmin(cl.Player) {
+			msg = "Huh?\n"
+		} else {
+			msg = s.health()
+		}
+
+	case "diag":
+		online = []Client{*cl}
+		if !isAdmin(cl.Player) {
+			msg = "Huh?\n"
+		} else {
+			msg = s.diag()
+		}
+
+	case "ref":
+		online = []Client{*cl}
+		if !isAdmin(cl.Player) {
+			msg = "Huh?\n"
+		} else {
+			msg = s.ref(cl.Player, args)
+		}
+
+	case "bug", "typo", "idea":
+		online = []Client{*cl}
+		msg = s.fileReport(roomsMap, cl.Player, cmd, args)
+
+	case "reports":
+		online = []Client{*cl}
+		if !isAdmin(cl.Player) {
+			msg = "Huh?\n"
+		} else {
+			msg = s.reports(roomsMap, cl.Player, args)
+		}
+
+	case "mute":
+		online = []Client{*cl}
+		if !isAdmin(cl.Player) {
+			msg = "Huh?\n"
+		} else {
+			msg = s.mute(roomsMap, cl.Player, args)
+		}
+
+	case "unmute":
+		online = []Client{*cl}
+		if !isAdmin(cl.Player) {
+			msg = "Huh?\n"
+		} else {
+			msg = s.unmute(roomsMap, cl.Player, args)
+		}
+
+	case "jail":
+		online = []Client{*cl}
+		if !isAdmin(cl.Player) {
+			msg = "Huh?\n"
+		} else {
+			msg = s.jail(roomsMap, cl.Player, args)
+		}
+
+	case "unjail":
+		online = []Client{*cl}
+		if !isAdmin(cl.Player) {
+			msg = "Huh?\n"
+		} else {
+			msg = s.unjail(roomsMap, cl.Player, args)
+		}
+
+	case "warn":
+		online = []Client{*cl}
+		if !isAdmin(cl.Player) {
+			msg = "Huh?\n"
+		} else {
+			msg = s.warn(roomsMap, cl.Player, args)
+		}
+
+	case "audit":
+		online = []Client{*cl}
+		if !isAdmin(cl.Player) {
+			msg = "Huh?\n"
+		} else {
+			msg = s.auditLog(cl.Player, args)
+		}
+
+	case "review":
+		online = []Client{*cl}
+		if !isAdmin(cl.Player) {
+			msg = "Huh?\n"
+		} else {
+			msg = s.review(cl.Player, args)
+		}
+
+	case "alts":
+		online = []Client{*cl}
+		if !isAdmin(cl.Player) {
+			msg = "Huh?\n"
+		} else {
+			msg = s.alts(cl.Player, args)
+		}
+
+	case "logs":
+		online = []Client{*cl}
+		if !isAdmin(cl.Player) {
+			msg = "Huh?\n"
+		} else {
+			msg = s.searchLogs(roomsMap, cl, args)
+		}
+
+	case "economy":
+		online = []Client{*cl}
+		if !isAdmin(cl.Player) {
+			msg = "Huh?\n"
+		} else {
+			msg = s.economy(cl.Player, args)
+		}
+
+	case "content":
+		online = []Client{*cl}
+		if !isAdmin(cl.Player) {
+			msg = "Huh?\n"
+		} else {
+			msg = s.content(roomsMap, args)
+		}
+
+	case "reload":
+		online = []Client{*cl}
+		if !isAdmin(cl.Player) {
+			msg = "Huh?\n"
+		} else {
+			msg = s.reload(args)
+		}
+
+	case "validate":
+		online = []Client{*cl}
+		if !isAdmin(cl.Player) {
+			msg = "Huh?\n"
+		} else {
+			msg = s.validateWorld()
+		}
+
+	case "lint":
+		online = []Client{*cl}
+		if !isAdmin(cl.Player) {
+			msg = "Huh?\n"
+		} else {
+			msg = s.lint()
+		}
+
+	case "maintenance":
+		online = []Client{*cl}
+		if !isAdmin(cl.Player) {
+			msg = "Huh?\n"
+		} else {
+			msg = s.toggleMaintenance(cl.Player, args)
+		}
+
+	case "jobs":
+		online = []Client{*cl}
+		if !isAdmin(cl.Player) {
+			msg = "Huh?\n"
+		} else {
+			msg = s.jobsCommand(roomsMap, cl.Player, args)
+		}
+
+	case "features":
+		online = []Client{*cl}
+		if !isAdmin(cl.Player) {
+			msg = "Huh?\n"
+		} else {
+			msg = s.toggleFeature(cl.Player, args)
+		}
+
+	case "restore":
+		online = []Client{*cl}
+		if !isAdmin(cl.Player) {
+			msg = "Huh?\n"
+		} else {
+			msg = s.restore(args)
+		}
+
+	case "staged":
+		online = []Client{*cl}
+		if !isAdmin(cl.Player) {
+			msg = "Huh?\n"
+		} else {
+			msg = s.stagedChanges(args)
+		}
+
+	case "publish":
+		online = []Client{*cl}
+		if !isAdmin(cl.Player) {
+			msg = "Huh?\n"
+		} else {
+			msg = s.publishStaged(cl.Player, args)
+		}
+
+	case "rollback":
+		online = []Client{*cl}
+		if !isAdmin(cl.Player) {
+			msg = "Huh?\n"
+		} else {
+			msg = s.rollback(cl.Player, args)
+		}
+
+	case "announce":
+		online = []Client{*cl}
+		if !isAdmin(cl.Player) {
+			msg = "Huh?\n"
+		} else {
+			msg = s.announce(roomsMap, cl.Player, args)
+		}
+
+	case "kick":
+		online = []Client{*cl}
+		if !isAdmin(cl.Player) {
+			msg = "Huh?\n"
+		} else {
+			msg = s.kick(args)
+		}
+
+	case "notifications":
+		msg = s.notifications(cl.Player, args)
+		online = []Client{*cl}
+
+	case "score":
+		msg = s.score(cl)
+		online = []Client{*cl}
+
+	case "hints":
+		msg = s.hints(cl, args)
+		online = []Client{*cl}
+
+	case "tutorial":
+		msg = s.tutorial(cl.Player, args)
+		online = []Client{*cl}
+
+	case "dungeon":
+		msg = s.enterInstance(roomsMap, cl.Player, args)
+		online = []Client{*cl}
+
+	default:
+		// Answers to the NPC the player talks to are picked by number.
+		if isAnswer(cmd) && cl.Player.Talking != 0 {
+			msg = s.respond(cl.Player, cmd)
+			online = []Client{*cl}
+		} else if social, ok := s.Socials[cmd]; ok {
+			msg = s.social(roomsMap, cl.Player, social, args)
+			online = []Client{*cl}
+		}
+	}
+
+	if revealed := revealOnAction(cl.Player, cmd); revealed != "" && msg != "door" {
+		msg = revealed + msg
+		online = s.OnlineClientsGetByRoom(cl.Player.Area, cl.Player.Room)
+	}
+
+	if msg == "door" {
+		log.Info("Enter door")
+		s.announceMove(cl, roomsMap)
+		s.guardsNotice(roomsMap, cl.Player)
+		s.levelWarning(roomsMap, cl.Player)
+		event = "arrive " + cl.Player.Area + "/" + cl.Player.Room
+	} else {
+		login := !cl.Player.Welcomed
+		s.godPrintRoom(online, roomsMap, msg, "")
+		// The news of the login is for the player alone.
+		if notice := s.loginNotice(cl.Player); notice != "" {
+			s.godPrintRoom([]Client{*cl}, roomsMap, notice, "")
+		}
+		if login {
+			s.hint(roomsMap, cl.Player, "login")
+		}
+	}
+	if event != "" {
+		s.hint(roomsMap, cl.Player, event)
 	}
+	log.Debug(fmt.Sprintf("%s : %s", ev.Client.Name, ev.EventType))
+}
+
+// isAnswer reports whether the command is the number of an answer in a dialogue.
+func isAnswer(cmd string) bool {
+	_, err := strconv.Atoi(cmd)
+	return err == nil
+}
+
+// parseCommand splits the given command line into the command and its arguments.
+func parseCommand(line string) (string, []string) {
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return "", nil
+	}
+	return strings.ToLower(fields[0]), fields[1:]
 }
 
 func (s *Server) godPrintRoom(
@@ -86,67 +847,145 @@ func (s *Server) godPrintRoom(
 	msg string,
 	globalMsg string,
 ) {
+	s.printPieces(clients, roomsMap, untag(msg+globalMsg))
+}
 
+// printPieces queues the output for the frames of the clients, and passes
+// the fight in it on to whoever watches them.
+func (s *Server) printPieces(clients []Client, roomsMap map[string]map[string][][]area.Cube, pieces []TaggedText) {
+	for i := range clients {
+		if clients[i].Player.Replaying {
+			continue
+		}
+		s.queueFrame(clients[i], roomsMap, pieces)
+	}
+	s.forwardToSpectators(clients, roomsMap, pieces)
+}
+
+// drawFrame draws the whole screen of the client, with the output gathered
+// since the last frame.
+func (s *Server) drawFrame(c Client, roomsMap map[string]map[string][][]area.Cube, pieces []TaggedText) {
 	now := time.Now()
 	log.Debug(fmt.Sprintf("Start of print: %v", now))
 
-	positionToCurrent := map[string]bool{}
-	mapArray := roomsMap[clients[0].Player.Area][clients[0].Player.Room]
+	p := c.Player
+	// Spectators see the room of the player they watch.
+	view := s.viewOf(p)
+	mapArray := roomsMap[view.Area][view.Room]
 
-	for i := range clients {
-		c := clients[i]
-		positionToCurrent[c.Player.Position] = false
+	// Everyone in the room the player notices is drawn on the map, and
+	// so are the vehicles.
+	posToCurr := map[string]bool{}
+	for _, pos := range s.vehiclesAt(view.Area, view.Room) {
+		posToCurr[pos] = false
+	}
+	for _, other := range s.OnlineClientsGetByRoom(view.Area, view.Room) {
+		if canSee(p, other.Player) {
+			posToCurr[other.Player.Position] = other.Player.Nickname == view.Nickname
+		}
 	}
 
-	for i := range clients {
-		c := clients[i]
-		p := c.Player
+	// Each player keeps the screen they were drawn on, as long as the
+	// terminal keeps its size.
+	c.screen = s.frameScreen(c)
 
-		posToCurr := copyMapWithNewPos(positionToCurrent, c.Player.Position)
+	var bufmap, bufexits, buffintro bytes.Buffer
+	var cubes [][]string
+	if w, ok := s.Wilderness[view.Area]; ok {
+		bufmap = w.Render(view.Position, wildernessRadius, posToCurr)
+		bufexits = area.PrintExits(w.FindExits(view.Position))
+		buffintro = w.PrintIntro(view.Position)
+	} else {
+		bufmap, cubes = area.PlayerCentricMapCubes(view, posToCurr, s.symbolsIn(view.Area, view.Room), mapArray)
+		bufexits = area.PrintExits(area.FindExits(mapArray, view.Area, view.Room, view.Position))
+		buffintro = area.PrintIntro(s.Areas[view.Area].Rooms[view.Room])
+	}
 
-		// Re-create the Screen. Instead of clear
-		c.screen = NewScreen(c.w, c.h)
+	// Create map
+	c.screen.updateScreen("map", bufmap)
 
-		// Create map
-		bufmap := area.PlayerCentricMap(p, posToCurr, mapArray)
-		c.screen.updateScreen("map", bufmap)
+	// Create Available movement
+	c.screen.updateScreen("exits", bufexits)
 
-		// Create Available movement
-		bufexits := area.PrintExits(area.FindExits(mapArray, c.Player.Area, c.Player.Room, c.Player.Position))
-		c.screen.updateScreen("exits", bufexits)
+	// Create Name and Description of Room, in the colours of its markup.
+	intro, introColors := renderMarkupLines(buffintro.String())
+	c.screen.updateScreen("intro", *bytes.NewBufferString(intro))
+	c.screen.introColors = introColors
 
-		// Create Name and Description of Room
-		buffintro := area.PrintIntro(s.Areas[c.Player.Area].Rooms[c.Player.Room])
-		c.screen.updateScreen("intro", buffintro)
+	// TODO : Now messages are global. Seperate private messages.
+	// Create Messages. A frame may gather more output than fits, and the
+	// latest is what matters.
+	messages := s.messagesPane(p, gagged(p, s.combatOutput(p, pieces)))
+	c.screen.updateScreen("message", *bytes.NewBufferString(messages))
+	c.screen.highlight(p.Highlights, lineColors(p, pieces))
+	c.screen.updateScreen("combat", *bytes.NewBufferString(s.combatPane(p)))
 
-		// TODO : Now messages are global. Seperate private messages.
-		// Create Messages
-		c.screen.updateScreen("message", *bytes.NewBufferString(msg))
+	// Players who use the mouse click their own map, not the one they watch.
+	if mouseActive(p) {
+		if view != p {
+			cubes = nil
+		}
+		s.frameOf(p.Nickname).clicks = clickMap(c, cubes)
+	}
+	// An open modal is drawn over the rest, and only it can be clicked.
+	if clicks := s.drawModal(c); clicks != nil && mouseActive(p) {
+		s.frameOf(p.Nickname).clicks = clicks
+	}
 
-		// Finally Draw Screen
-		DrawScreen(c)
+	// Finally Draw Screen
+	DrawScreen(c)
+	s.drawStatusLine(c)
+	s.sendTags(c, pieces)
 
-		// Return cursor to prompt bar
-		c.writeGoto(c.h-1, c.promptBar.position+1)
+	// Return cursor to prompt bar
+	c.writeGoto(c.h-1, c.promptBar.position+1)
 
-		// Show cursor again
-		c.conn.Write(ansi.CursorShow)
-	}
+	// Show cursor again
+	c.conn.Write(ansi.CursorShow)
 
 	reallyNow := time.Now()
 	log.Debug(fmt.Sprintf("End of print: %v", reallyNow))
 	log.Debug(fmt.Sprintf("Printed after %f ms", reallyNow.Sub(now).Seconds()*1000))
 }
 
-func copyMapWithNewPos(m map[string]bool, currentPos string) map[string]bool {
-	copied := map[string]bool{}
-	for k, v := range m {
-		copied[k] = v
-		if k == currentPos {
-			copied[k] = true
+// printToRoom prints the message to everyone in the given room.
+func (s *Server) printToRoom(roomsMap map[string]map[string][][]area.Cube, areaName, room, msg string) {
+	s.broadcast(roomsMap, Broadcast{Scope: ScopeRoom, Area: areaName, Room: room, Text: msg})
+}
+
+// move initiates the movement to the desired direction, either across the
+// cubes of a room or across the wilderness.
+func (s *Server) move(c Client, online []Client, roomsMap map[string]map[string][][]area.Cube, direction int) string {
+	if c.Player.Resting != game.Awake {
+		return "You have to stand up first\n"
+	}
+	if why := s.slowed(c.Player); why != "" {
+		return why
+	}
+	if _, ok := s.Wilderness[c.Player.Area]; !ok {
+		to := area.FindExits(roomsMap[c.Player.Area][c.Player.Room], c.Player.Area, c.Player.Room, c.Player.Position)[direction]
+		if to[2] != c.Player.Room && !s.roomOpen(to[0], to[2]) {
+			return closedRoom(to[2])
+		}
+		// Leaving the room takes fleeing whatever fights the player in it.
+		if npc := s.engaged(c.Player); to[2] != c.Player.Room && npc != nil {
+			return fmt.Sprintf("%s won't let you turn your back, flee to get away\n", npc.Name)
 		}
+		if gate := s.levelGate(c.Player, to[0]); gate != "" {
+			return gate
+		}
+	}
+	at := c.Player.Location
+	var msg string
+	if _, ok := s.Wilderness[c.Player.Area]; ok {
+		msg = s.doWildMove(c, online, roomsMap, direction)
+	} else if msg = doMove(c, online, roomsMap, direction); msg == "" {
+		s.enterPortal(roomsMap, c.Player)
 	}
-	return copied
+	if c.Player.Location != at {
+		s.lead(roomsMap, c.Player, at, direction)
+	}
+	return msg
 }
 
 // Initiate the movement to the desired direction.
@@ -159,6 +998,10 @@ func doMove(c Client, online []Client, roomsMap map[string]map[string][][]area.C
 	newroom := posarray[direction][2]
 	newpos, _ := strconv.Atoi(posarray[direction][1])
 
+	if newPosType == "closed" {
+		return "The door is closed\n"
+	}
+
 	// Check if the destination cube is available.
 	isAvailable, info := isCubeAvailable(c, online, newarea, newroom, newpos)
 
@@ -204,7 +1047,13 @@ func isCubeAvailable(client Client, online []Client, area string, room string, c
 // TODO : Check for Canvas offset.
 // Append all Canvas to final ScreenRune and print it to user.
 func DrawScreen(c Client) {
-	u := make([]byte, 0)
+	out := frameBuffers.Get().(*[]byte)
+	u := (*out)[:0]
+	// Every cell takes a few bytes, so the buffer is grown to the terminal
+	// at once rather than while the frame is written.
+	if size := c.w * c.h * frameCellBytes; cap(u) < size {
+		u = make([]byte, 0, size)
+	}
 
 	// Add mapCanvas to screenRunes
 	for h := 0; h < len(c.screen.mapCanvas); h++ {
@@ -225,12 +1074,44 @@ func DrawScreen(c Client) {
 	for h := 0; h < len(c.screen.introCanvas); h++ {
 		for w := 0; w < len(c.screen.introCanvas[h]); w++ {
 			c.screen.screenRunes[h][w] = c.screen.introCanvas[h][w]
+			if h < len(c.screen.introColors) && w < len(c.screen.introColors[h]) {
+				c.screen.screenColors[h][w] = c.screen.introColors[h][w]
+			}
+		}
+	}
+
+	// Add Messages to screenRunes. Messages have room for maxMessageLines
+	// lines, right above the prompt bar.
+	for h := 0; h < len(c.screen.messagesCanvas) && h < maxMessageLines; h++ {
+		for w := 0; w < len(c.screen.messagesCanvas[h]) && c.w-50+w < c.w; w++ {
+			c.screen.screenRunes[c.h-8+h][c.w-50+w] = c.screen.messagesCanvas[h][w]
+			if h < len(c.screen.messageColors) {
+				c.screen.screenColors[c.h-8+h][c.w-50+w] = c.screen.messageColors[h][w]
+			}
 		}
 	}
 
-	// Add Messages to screenRunes
-	for msgCh := 0; msgCh < len(c.screen.messagesCanvas); msgCh++ {
-		c.screen.screenRunes[c.h-8][c.w-50+msgCh] = c.screen.messagesCanvas[msgCh]
+	// Add the combat pane to screenRunes, left of the messages.
+	for h := 0; h < len(c.screen.combatCanvas) && h < maxMessageLines; h++ {
+		for w := 0; w < len(c.screen.combatCanvas[h]) && w < c.w-52; w++ {
+			c.screen.screenRunes[c.h-8+h][w] = c.screen.combatCanvas[h][w]
+		}
+	}
+
+	// Add the open modal to screenRunes, over everything else.
+	for h := 0; h < len(c.screen.modalCanvas); h++ {
+		row := c.screen.modalTop + h
+		if row < 0 || row >= c.h-3 {
+			continue
+		}
+		for w := 0; w < len(c.screen.modalCanvas[h]) && c.screen.modalLeft+w < c.w; w++ {
+			c.screen.screenRunes[row][c.screen.modalLeft+w] = c.screen.modalCanvas[h][w]
+			color := noColor
+			if h < len(c.screen.modalColors) && w < len(c.screen.modalColors[h]) {
+				color = c.screen.modalColors[h][w]
+			}
+			c.screen.screenColors[row][c.screen.modalLeft+w] = color
+		}
 	}
 
 	// Hide Cursor and go to 0,0 potition of the screen.
@@ -239,13 +1120,35 @@ func DrawScreen(c Client) {
 	c.conn.Write(ansi.CursorHide)
 	c.conn.Write(ansi.Goto(0, 0))
 
-	// Write all the screen data.
+	// Write all the screen data, in the colours of the theme of the player.
+	theme := themeOf(c.Player)
+	ascii := c.Player.ClientInfo.ASCII
+	color := noColor
+	var r [utf8.UTFMax]byte
 	for x := 0; x < len(c.screen.screenRunes)-1; x++ {
-		u = append(u, []byte(string("\r"))...)
+		u = append(u, '\r')
 		for y := 0; y < len(c.screen.screenRunes[x]); y++ {
-			u = append(u, []byte(string(c.screen.screenRunes[x][y]))...)
+			// Colours are only switched where they change.
+			if next := c.screen.screenColors[x][y]; next != color {
+				u = append(u, theme.code(next)...)
+				color = next
+			}
+			ch := c.screen.screenRunes[x][y]
+			if ascii {
+				ch = asciiRune(ch)
+			}
+			n := utf8.EncodeRune(r[:], ch)
+			u = append(u, r[:n]...)
 		}
-		u = append(u, []byte(string("\n"))...)
+		u = append(u, '\n')
+	}
+	if color != noColor {
+		u = append(u, theme.code(noColor)...)
 	}
+	// The writers of the connection are done with the bytes once Write
+	// returns: the recording marshals them and the SSH channel copies them
+	// into its packets. So the buffer can go back to the pool right away.
 	c.conn.Write(u)
+	*out = u
+	frameBuffers.Put(out)
 }
@@ -0,0 +1,49 @@
+package server
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/droslean/thyranew/area"
+	"github.com/droslean/thyranew/worldgraph"
+	"github.com/droslean/thyranew/worldlint"
+)
+
+// validateWorld reports the broken and one-way exits of the world as it runs,
+// and the rooms that cannot be reached from where players are bound at first
+// or from the jail.
+// Usage: validate
+func (s *Server) validateWorld() string {
+	jail := s.jailCell()
+	g := worldgraph.Build(s.Areas, s.Wilderness, s.Vehicles, false)
+	return g.Validate(worldgraph.ID(defaultBind.ToArea, defaultBind.ToRoom), worldgraph.ID(jail.ToArea, jail.ToRoom)).String()
+}
+
+// lint checks the world as it runs for what breaks in the game without
+// failing to load, the way thyra lint checks the static directory. NPCs off
+// with their content flags are checked too, and instances are left out.
+// Usage: lint
+func (s *Server) lint() string {
+	jail := s.jailCell()
+	w := &worldlint.World{
+		Areas:        make(map[string]area.Area),
+		Wilderness:   s.Wilderness,
+		Vehicles:     s.Vehicles,
+		LootTables:   s.LootTables,
+		Dialogues:    s.Dialogues,
+		Achievements: s.Achievements,
+		Starts:       []string{worldgraph.ID(defaultBind.ToArea, defaultBind.ToRoom), worldgraph.ID(jail.ToArea, jail.ToRoom)},
+	}
+	for name, a := range s.Areas {
+		if strings.Contains(name, "#") {
+			continue
+		}
+		a.NPCs = append(append([]area.NPC{}, a.NPCs...), s.dormant[name]...)
+		w.Areas[name] = a
+	}
+	for _, it := range s.Items {
+		w.Items = append(w.Items, it)
+	}
+	sort.Slice(w.Items, func(i, j int) bool { return w.Items[i].Name < w.Items[j].Name })
+	return w.Lint().String()
+}
@@ -0,0 +1,237 @@
+package server
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/droslean/thyranew/area"
+)
+
+// The loot rules of groups, which their leader picks.
+const (
+	lootFree       = "free"       // Whoever gets to the loot first takes it
+	lootRoundRobin = "roundrobin" // The members get the items in turn
+	lootNeedGreed  = "needgreed"  // The members roll for each item
+)
+
+// lootRules are the loot rules leaders pick from.
+var lootRules = []string{lootFree, lootRoundRobin, lootNeedGreed}
+
+// lootRollTime is how long the group has to roll for an item. Those who
+// didn't roll by then pass.
+const lootRollTime = 30 * time.Second
+
+// The rolls for an item. Anyone who needs it beats all who are greedy.
+const (
+	rollNeed  = "need"
+	rollGreed = "greed"
+	rollPass  = "pass"
+)
+
+// lootRoll is a group rolling for an item on remains.
+type lootRoll struct {
+	remains  int // The ID of the remains the item is on
+	item     string
+	members  []string
+	rolls    map[string]string // What each member rolled, by nickname
+	menus    map[string]*menu  // The menus the members roll with, by nickname
+	roomsMap map[string]map[string][][]area.Cube
+}
+
+// groupLeader returns the leader of the group: the one of them who follows
+// none of the others.
+func groupLeader(group []*area.Player) *area.Player {
+	in := map[string]bool{}
+	for _, member := range group {
+		in[member.Nickname] = true
+	}
+	for _, member := range group {
+		if !in[member.Following] {
+			return member
+		}
+	}
+	return group[0]
+}
+
+// lootRule returns the loot rule of the group, which its leader picked.
+// Players on their own loot freely.
+func lootRule(group []*area.Player) string {
+	if len(group) < 2 {
+		return lootFree
+	}
+	if rule := groupLeader(group).Loot.Rule; rule != "" {
+		return rule
+	}
+	return lootFree
+}
+
+// nextTurn returns who of the group gets the next item under round-robin,
+// and remembers it was their turn.
+func (s *Server) nextTurn(group []*area.Player) string {
+	leader := groupLeader(group).Nickname
+	names := []string{}
+	for _, member := range group {
+		names = append(names, member.Nickname)
+	}
+	sort.Strings(names)
+	next := names[0]
+	for _, name := range names {
+		if name > s.lootTurns[leader] {
+			next = name
+			break
+		}
+	}
+	s.lootTurns[leader] = next
+	return next
+}
+
+// startRoll has the owners of the remains roll for the item, with a menu on
+// their screens, until all rolled or the time is up.
+func (s *Server) startRoll(roomsMap map[string]map[string][][]area.Cube, remainsID int, owners []string, item string) int {
+	s.lastRoll++
+	id := s.lastRoll
+	roll := &lootRoll{
+		remains:  remainsID,
+		item:     item,
+		members:  owners,
+		rolls:    map[string]string{},
+		menus:    map[string]*menu{},
+		roomsMap: roomsMap,
+	}
+	s.rolls[id] = roll
+	for _, nick := range roll.members {
+		cl, ok := s.clientByNick(nick)
+		if !ok {
+			continue
+		}
+		m := &menu{
+			title: fmt.Sprintf("Roll for %s", item),
+			choices: []choice{
+				{label: "Need", value: rollNeed},
+				{label: "Greed", value: rollGreed},
+				{label: "Pass", value: rollPass},
+			},
+			pick: func(s *Server, c *Client, ch choice) string {
+				delete(roll.menus, c.Player.Nickname)
+				return s.rollFor(id, c.Player, ch.value)
+			},
+		}
+		roll.menus[nick] = m
+		s.openModal(cl, m)
+		s.tellPlayer(roomsMap, nick, fmt.Sprintf("Roll for %s: need, greed or pass\n", item))
+	}
+	s.after(lootRollTime, func() { s.endRoll(id) })
+	return id
+}
+
+// rollFor rolls the player for the item, and ends the roll once all did.
+func (s *Server) rollFor(id int, p *area.Player, pick string) string {
+	roll, ok := s.rolls[id]
+	if !ok {
+		return "The roll is over\n"
+	}
+	if _, done := roll.rolls[p.Nickname]; done {
+		return fmt.Sprintf("You rolled for %s already\n", roll.item)
+	}
+	roll.rolls[p.Nickname] = pick
+	if len(roll.rolls) == len(roll.members) {
+		s.endRoll(id)
+		return ""
+	}
+	return fmt.Sprintf("You roll %s for %s\n", pick, roll.item)
+}
+
+// endRoll gives the item to whoever rolled highest, needs first, and tells
+// the group. When everyone passed, any of them may take it.
+func (s *Server) endRoll(id int) {
+	roll, ok := s.rolls[id]
+	if !ok {
+		return
+	}
+	delete(s.rolls, id)
+	for nick, m := range roll.menus {
+		if cl, ok := s.clientByNick(nick); ok {
+			s.closeModal(cl, m)
+		}
+	}
+
+	winner, best, bestPick := "", 0, ""
+	for _, pick := range []string{rollNeed, rollGreed} {
+		for _, nick := range roll.members {
+			if roll.rolls[nick] != pick {
+				continue
+			}
+			if n := s.rnd.Intn(100) + 1; n > best {
+				winner, best, bestPick = nick, n, pick
+			}
+		}
+		if winner != "" {
+			break
+		}
+	}
+	r, ok := s.remains[roll.remains]
+	if !ok {
+		return
+	}
+	msg := fmt.Sprintf("Everyone passes on %s\n", roll.item)
+	if winner != "" {
+		msg = fmt.Sprintf("%s wins %s, %s %d\n", winner, roll.item, bestPick, best)
+	}
+	for i := range r.Items {
+		if r.Items[i].Roll == id {
+			r.Items[i].Roll, r.Items[i].Owners = 0, []string{winner}
+		}
+	}
+	for _, nick := range roll.members {
+		s.tellPlayer(roll.roomsMap, nick, msg)
+	}
+	if cl, ok := s.clientByNick(winner); ok && !cl.Player.Loot.Manual {
+		if take := s.takeLoot(roll.roomsMap, cl.Player, &r, func(l lootItem) bool { return l.Item == roll.item }, false); take != "" {
+			s.tellPlayer(roll.roomsMap, winner, take)
+		}
+	}
+	s.keepRemains(roll.remains, r)
+}
+
+// roll rolls the player for the earliest item their group rolls for that
+// they didn't roll for yet, as the roll menu does.
+// Usage: roll need|greed|pass
+func (s *Server) roll(p *area.Player, args []string) string {
+	if len(args) != 1 || (args[0] != rollNeed && args[0] != rollGreed && args[0] != rollPass) {
+		return "Usage: roll need|greed|pass\n"
+	}
+	ids := []int{}
+	for id, roll := range s.rolls {
+		if _, done := roll.rolls[p.Nickname]; !done && hasName(roll.members, p.Nickname) {
+			ids = append(ids, id)
+		}
+	}
+	if len(ids) == 0 {
+		return "There is nothing for you to roll for\n"
+	}
+	sort.Ints(ids)
+	roll := s.rolls[ids[0]]
+	if m, ok := roll.menus[p.Nickname]; ok {
+		if cl, ok := s.clientByNick(p.Nickname); ok {
+			s.closeModal(cl, m)
+		}
+		delete(roll.menus, p.Nickname)
+	}
+	return s.rollFor(ids[0], p, args[0])
+}
+
+// setLootRule picks the loot rule of the groups the player leads.
+func setLootRule(p *area.Player, rule string) string {
+	for _, r := range lootRules {
+		if r == rule {
+			p.Loot.Rule = rule
+			if rule == lootFree {
+				p.Loot.Rule = ""
+			}
+			return fmt.Sprintf("Groups you lead loot by %s\n", rule)
+		}
+	}
+	return fmt.Sprintf("The loot rules are %s\n", strings.Join(lootRules, ", "))
+}
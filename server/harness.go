@@ -0,0 +1,307 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// Harness runs a server inside the process, for tests to play against. Fake
+// players connect through pipes and skip SSH, while real clients can still
+// connect on Addr.
+type Harness struct {
+	Server *Server
+	Addr   string // Where the server listens, on a port of its own choosing
+
+	dir      string
+	listener *net.TCPListener
+	ctx      context.Context
+	cancel   context.CancelFunc
+	wg       *sync.WaitGroup
+	// replayers are the players of a replay, by their names.
+	replayers map[string]*FakePlayer
+}
+
+// NewHarness starts a server with the static content of THYRA_STATIC. Its
+// database and the players it saves are kept in a temporary directory,
+// removed by Close, so every harness starts from scratch.
+func NewHarness() (*Harness, error) {
+	return newHarness(nil)
+}
+
+// NewSimulationHarness starts a server the way NewHarness does, as a
+// simulation. Its world only moves on with Advance.
+func NewSimulationHarness(sim Simulation) (*Harness, error) {
+	return newHarness(&sim)
+}
+
+func newHarness(sim *Simulation) (*Harness, error) {
+	dir, err := ioutil.TempDir("", "thyra-harness")
+	if err != nil {
+		return nil, err
+	}
+	return newHarnessIn(dir, sim)
+}
+
+// newHarnessIn starts a harness that keeps its database and players in the
+// directory, which Close removes.
+func newHarnessIn(dir string, sim *Simulation) (*Harness, error) {
+	h := &Harness{dir: dir, wg: &sync.WaitGroup{}, replayers: map[string]*FakePlayer{}}
+	if err := h.start(sim); err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+	return h, nil
+}
+
+func (h *Harness) start(sim *Simulation) error {
+	if err := os.MkdirAll(filepath.Join(h.dir, "player"), 0755); err != nil {
+		return err
+	}
+	db, err := NewDatabase(filepath.Join(h.dir, "thyra.db"), true)
+	if err != nil {
+		return err
+	}
+	listener, err := net.ListenTCP("tcp4", &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		db.Close()
+		return err
+	}
+	s, err := NewServerWith(db, Options{Port: listener.Addr().(*net.TCPAddr).Port, DataDir: h.dir, Simulation: sim})
+	if err != nil {
+		listener.Close()
+		db.Close()
+		return err
+	}
+	if err := s.world.Start(); err != nil {
+		listener.Close()
+		db.Close()
+		return err
+	}
+
+	h.Server, h.Addr, h.listener = s, listener.Addr().String(), listener
+	h.ctx, h.cancel = context.WithCancel(context.Background())
+	go s.accept(h.ctx, listener, h.wg)
+	return nil
+}
+
+// Close drops everyone, stops the server and removes what it saved.
+func (h *Harness) Close() {
+	h.cancel()
+	h.listener.Close()
+	h.wg.Wait()
+	h.Server.world.Stop()
+	h.Server.db.Close()
+	os.RemoveAll(h.dir)
+}
+
+// Advance moves the world of a simulation harness on by the given number of
+// ticks.
+func (h *Harness) Advance(ticks int) {
+	h.Server.Advance(ticks)
+}
+
+// Connect logs a fake player in, on a terminal of the given size, and returns
+// once the player can type.
+func (h *Harness) Connect(nick string, width, height int) (*FakePlayer, error) {
+	ctx, cancel := context.WithCancel(h.ctx)
+	life := newLifecycle()
+	if err := h.Server.connState(ctx, life, nick, StateAuthenticating); err != nil {
+		cancel()
+		return nil, err
+	}
+	pipe := newPipeChannel()
+	go func() {
+		<-ctx.Done()
+		pipe.Close()
+	}()
+	client, err := h.Server.newClient(ctx, cancel, life, nick, nick, "", pipe)
+	if err != nil {
+		h.Server.connState(ctx, life, nick, StateQuitting)
+		cancel()
+		return nil, err
+	}
+
+	h.wg.Add(1)
+	go func() {
+		defer h.wg.Done()
+		if !h.Server.join(ctx, client, h.wg) {
+			h.Server.connState(ctx, life, nick, StateQuitting)
+		}
+	}()
+	// Sizes are taken one at a time once the player is in, so the client
+	// is done with the first by the time the second is taken.
+	size := resize{width: uint32(width), height: uint32(height)}
+	client.resize(size)
+	client.resize(size)
+	if ctx.Err() != nil {
+		return nil, fmt.Errorf("%s could not get in", nick)
+	}
+	return &FakePlayer{Nick: nick, pipe: pipe, cancel: cancel}, nil
+}
+
+// FakePlayer is a player connected to a harness.
+type FakePlayer struct {
+	Nick   string
+	pipe   *pipeChannel
+	cancel context.CancelFunc
+}
+
+// Send types the command and presses enter.
+func (p *FakePlayer) Send(cmd string) error {
+	for _, key := range []byte(cmd) {
+		if err := p.pipe.key([]byte{key}); err != nil {
+			return err
+		}
+	}
+	return p.pipe.key([]byte{ENTER_KEY})
+}
+
+// Expect waits for the text to show up on the screen of the player, and
+// consumes the output up to it. Escape sequences are left out of the output,
+// so the text is matched the way the player reads it.
+func (p *FakePlayer) Expect(text string, timeout time.Duration) error {
+	deadline := time.After(timeout)
+	for {
+		changed := p.pipe.changed()
+		if p.pipe.consume(text) {
+			return nil
+		}
+		select {
+		case <-changed:
+		case <-deadline:
+			return fmt.Errorf("%s never saw %q, the screen shows:\n%s", p.Nick, text, p.Output())
+		}
+	}
+}
+
+// Output returns the output not consumed yet, without escape sequences.
+func (p *FakePlayer) Output() string {
+	return p.pipe.output()
+}
+
+// Drop drops the connection, leaving the player linkdead.
+func (p *FakePlayer) Drop() {
+	p.cancel()
+}
+
+// escapes matches the escape sequences of the screen.
+var escapes = regexp.MustCompile(`\x1b\[[0-9;?]*[A-Za-z]`)
+
+// pipeChannel stands in for the SSH channel of a fake player. The server reads
+// one key at a time off it, the way it reads a terminal.
+type pipeChannel struct {
+	keys   chan []byte
+	closed chan struct{}
+	once   sync.Once
+
+	mu     sync.Mutex
+	out    bytes.Buffer
+	notify chan struct{} // Closed whenever output comes in
+}
+
+func newPipeChannel() *pipeChannel {
+	return &pipeChannel{
+		keys:   make(chan []byte),
+		closed: make(chan struct{}),
+		notify: make(chan struct{}),
+	}
+}
+
+// key hands a key to the server.
+func (pc *pipeChannel) key(b []byte) error {
+	select {
+	case pc.keys <- b:
+		return nil
+	case <-pc.closed:
+		return io.EOF
+	}
+}
+
+func (pc *pipeChannel) Read(data []byte) (int, error) {
+	select {
+	case b := <-pc.keys:
+		return copy(data, b), nil
+	case <-pc.closed:
+		return 0, io.EOF
+	}
+}
+
+func (pc *pipeChannel) Write(data []byte) (int, error) {
+	select {
+	case <-pc.closed:
+		return 0, io.EOF
+	default:
+	}
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+	pc.out.Write(data)
+	close(pc.notify)
+	pc.notify = make(chan struct{})
+	return len(data), nil
+}
+
+func (pc *pipeChannel) Close() error {
+	pc.once.Do(func() { close(pc.closed) })
+	return nil
+}
+
+func (pc *pipeChannel) CloseWrite() error {
+	return nil
+}
+
+func (pc *pipeChannel) SendRequest(name string, wantReply bool, payload []byte) (bool, error) {
+	return false, errors.New("fake players take no requests")
+}
+
+func (pc *pipeChannel) Stderr() io.ReadWriter {
+	return &bytes.Buffer{}
+}
+
+// changed returns a channel that is closed once more output comes in.
+func (pc *pipeChannel) changed() <-chan struct{} {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+	return pc.notify
+}
+
+// consume drops the output up to the end of the text, if the text is there.
+func (pc *pipeChannel) consume(text string) bool {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+	plain := escapes.ReplaceAllString(pc.out.String(), "")
+	i := strings.Index(plain, text)
+	if i < 0 {
+		return false
+	}
+	pc.out.Reset()
+	pc.out.WriteString(plain[i+len(text):])
+	return true
+}
+
+// discard drops the output not consumed yet.
+func (pc *pipeChannel) discard() {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+	pc.out.Reset()
+}
+
+func (pc *pipeChannel) output() string {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+	return escapes.ReplaceAllString(pc.out.String(), "")
+}
+
+var _ ssh.Channel = (*pipeChannel)(nil)
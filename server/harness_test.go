@@ -0,0 +1,76 @@
+package server
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// expectTimeout is how long the tests wait for the screen of a fake player.
+const expectTimeout = 5 * time.Second
+
+// startHarness starts a harness on the static content of the repository,
+// keeping what it saves in a directory of the test.
+func startHarness(t testing.TB, sim *Simulation) *Harness {
+	t.Helper()
+	static, err := filepath.Abs(filepath.Join("..", "static"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("THYRA_STATIC", static)
+	h, err := newHarnessIn(t.TempDir(), sim)
+	if err != nil {
+		t.Fatalf("cannot start the harness: %v", err)
+	}
+	t.Cleanup(h.Close)
+	return h
+}
+
+// connect logs the fake player in.
+func connect(t testing.TB, h *Harness, nick string) *FakePlayer {
+	t.Helper()
+	p, err := h.Connect(nick, 160, 40)
+	if err != nil {
+		t.Fatalf("%s cannot connect: %v", nick, err)
+	}
+	return p
+}
+
+// send has the fake player type the command, and waits for each of the texts
+// to show up on their screen.
+func send(t *testing.T, p *FakePlayer, cmd string, texts ...string) {
+	t.Helper()
+	if err := p.Send(cmd); err != nil {
+		t.Fatalf("%s cannot type %q: %v", p.Nick, cmd, err)
+	}
+	for _, text := range texts {
+		if err := p.Expect(text, expectTimeout); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestHarnessTwoPlayers(t *testing.T) {
+	h := startHarness(t, nil)
+	alice := connect(t, h, "alice")
+	bob := connect(t, h, "bob")
+
+	tests := []struct {
+		player *FakePlayer
+		cmd    string
+		want   []string
+	}{
+		{alice, "who", []string{"2 players online", "alice", "bob"}},
+		{bob, "who", []string{"2 players online"}},
+		{alice, "look bob", []string{"bob, a level 1"}},
+		{bob, "look alice", []string{"alice, a level 1"}},
+		{alice, "look carol", []string{"You don't see carol here"}},
+		{bob, "say hello", []string{"You are not talking to anyone"}},
+	}
+	for _, tt := range tests {
+		send(t, tt.player, tt.cmd, tt.want...)
+	}
+
+	bob.Drop()
+	send(t, alice, "who", "2 players online")
+}
@@ -0,0 +1,90 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	log "gopkg.in/inconshreveable/log15.v2"
+)
+
+// stalled is how long the world may go without a tick before it counts as
+// hung.
+const stalled = 3 * tickInterval
+
+// startHealth serves the probes of orchestrators like Kubernetes over HTTP on
+// the given address: /healthz for whether the server lives, and /readyz for
+// whether it takes players. A host passes them when all its worlds do.
+func startHealth(addr string, worlds []*Server) {
+	check := func(why func(s *Server) string) string {
+		wrong := []string{}
+		for _, s := range worlds {
+			if w := why(s); w != "" && s.name != "" {
+				wrong = append(wrong, fmt.Sprintf("%s: %s", s.name, w))
+			} else if w != "" {
+				wrong = append(wrong, w)
+			}
+		}
+		return strings.Join(wrong, "\n")
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		probe(w, check((*Server).alive))
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		probe(w, check((*Server).readiness))
+	})
+	go func() {
+		log.Info(fmt.Sprintf("Serving the health probes on %s", addr))
+		if err := http.ListenAndServe(addr, mux); err != nil {
+			log.Error(fmt.Sprintf("Health listener on %s stopped: %v", addr, err))
+		}
+	}()
+}
+
+// probe answers a probe with what is wrong, or ok.
+func probe(w http.ResponseWriter, wrong string) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	if wrong != "" {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		fmt.Fprintln(w, wrong)
+		return
+	}
+	fmt.Fprintln(w, "ok")
+}
+
+// alive returns why the server is not alive, or nothing if it is: the world
+// stopped, or hangs without ticking.
+func (s *Server) alive() string {
+	h := s.world.Health()
+	last := h.LastTick
+	if last.IsZero() {
+		last = time.Now().Add(-h.Uptime)
+	}
+	switch {
+	case !h.Running:
+		return "the world is stopped"
+	case time.Since(last) > stalled:
+		return fmt.Sprintf("the world has not ticked for %s", time.Since(last).Round(time.Second))
+	}
+	return ""
+}
+
+// readiness returns why the server takes no players, or nothing if it
+// does: it is not alive, not listening yet or shutting down, or the world
+// falls behind.
+func (s *Server) readiness() string {
+	if why := s.alive(); why != "" {
+		return why
+	}
+	if atomic.LoadInt32(&s.ready) == 0 {
+		return "not accepting connections"
+	}
+	if s.world.Health().Shedding {
+		return "the world falls behind"
+	}
+	return ""
+}
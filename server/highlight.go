@@ -0,0 +1,204 @@
+package server
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/droslean/thyranew/area"
+	"github.com/jpillora/ansi"
+
+	log "gopkg.in/inconshreveable/log15.v2"
+)
+
+// maxRules is how many highlights, and how many gags, each player can have.
+const maxRules = 20
+
+// noColor marks the cells of the screen drawn in the colour of the terminal.
+const noColor = ID(255)
+
+// highlightColors are the colours players can highlight text with.
+var highlightColors = map[string]ansi.Attribute{
+	"red":     ansi.Red,
+	"green":   ansi.Green,
+	"yellow":  ansi.Yellow,
+	"blue":    ansi.Blue,
+	"magenta": ansi.Magenta,
+	"cyan":    ansi.Cyan,
+	"white":   ansi.White,
+}
+
+// gagged drops the lines of the message that match a gag of the player.
+func gagged(p *area.Player, msg string) string {
+	if len(p.Gags) == 0 || msg == "" {
+		return msg
+	}
+	kept := []string{}
+	for _, line := range strings.SplitAfter(msg, "\n") {
+		if !matchesAny(line, p.Gags) {
+			kept = append(kept, line)
+		}
+	}
+	return strings.Join(kept, "")
+}
+
+func matchesAny(line string, texts []string) bool {
+	line = strings.ToLower(line)
+	for _, text := range texts {
+		if strings.Contains(line, strings.ToLower(text)) {
+			return true
+		}
+	}
+	return false
+}
+
+// highlight colours the messages on the screen: whole lines in the colour of
+// their meaning, and the highlighted text on top.
+func (scr *Screen) highlight(highlights []area.Highlight, lineColors map[string]ID) {
+	scr.messageColors = make([][]ID, len(scr.messagesCanvas))
+	for i, line := range scr.messagesCanvas {
+		colors := make([]ID, len(line))
+		base, ok := lineColors[string(line)]
+		if !ok {
+			base = noColor
+		}
+		for j := range colors {
+			colors[j] = base
+		}
+		lower := []rune(strings.ToLower(string(line)))
+		for _, h := range highlights {
+			text := []rune(strings.ToLower(h.Text))
+			color, ok := highlightColors[h.Color]
+			if !ok || len(text) == 0 || len(lower) != len(line) {
+				continue
+			}
+			for at := 0; at+len(text) <= len(lower); at++ {
+				if string(lower[at:at+len(text)]) == string(text) {
+					for j := at; j < at+len(text); j++ {
+						colors[j] = ID(color)
+					}
+				}
+			}
+		}
+		scr.messageColors[i] = colors
+	}
+}
+
+// splitQuoted returns the quoted text at the start of the arguments, or the
+// first word when there are no quotes, followed by the rest of the arguments.
+func splitQuoted(args []string) (string, []string) {
+	joined := strings.Join(args, " ")
+	if strings.HasPrefix(joined, "\"") {
+		if end := strings.Index(joined[1:], "\""); end >= 0 {
+			return joined[1 : end+1], strings.Fields(joined[end+2:])
+		}
+		return strings.Trim(joined, "\""), nil
+	}
+	if len(args) == 0 {
+		return "", nil
+	}
+	return args[0], args[1:]
+}
+
+// highlightCmd lists or adds the highlights of the player.
+// Usage: highlight ["text" <color>]
+func (s *Server) highlightCmd(p *area.Player, args []string) string {
+	if len(args) == 0 {
+		if len(p.Highlights) == 0 {
+			return "You have no highlights\n"
+		}
+		lines := []string{}
+		for _, h := range p.Highlights {
+			lines = append(lines, fmt.Sprintf("%q %s", h.Text, h.Color))
+		}
+		return strings.Join(lines, ", ") + "\n"
+	}
+	text, rest := splitQuoted(args)
+	if text == "" || len(rest) != 1 {
+		return "Usage: highlight \"text\" <color>\n"
+	}
+	color := strings.ToLower(rest[0])
+	if _, ok := highlightColors[color]; !ok {
+		colors := []string{}
+		for name := range highlightColors {
+			colors = append(colors, name)
+		}
+		sort.Strings(colors)
+		return fmt.Sprintf("Colors: %s\n", strings.Join(colors, ", "))
+	}
+
+	for i := range p.Highlights {
+		if strings.EqualFold(p.Highlights[i].Text, text) {
+			p.Highlights[i].Color = color
+			return s.savePreferences(p, fmt.Sprintf("%q is now %s\n", text, color))
+		}
+	}
+	if len(p.Highlights) >= maxRules {
+		return fmt.Sprintf("You can't have more than %d highlights\n", maxRules)
+	}
+	p.Highlights = append(p.Highlights, area.Highlight{Text: text, Color: color})
+	return s.savePreferences(p, fmt.Sprintf("%q is now %s\n", text, color))
+}
+
+// unhighlight removes a highlight of the player.
+// Usage: unhighlight "text"
+func (s *Server) unhighlight(p *area.Player, args []string) string {
+	text, _ := splitQuoted(args)
+	for i := range p.Highlights {
+		if strings.EqualFold(p.Highlights[i].Text, text) {
+			p.Highlights = append(p.Highlights[:i], p.Highlights[i+1:]...)
+			return s.savePreferences(p, fmt.Sprintf("%q is no longer highlighted\n", text))
+		}
+	}
+	return "Usage: unhighlight \"text\"\n"
+}
+
+// gag lists or adds the gags of the player.
+// Usage: gag ["text"]
+func (s *Server) gag(p *area.Player, args []string) string {
+	if len(args) == 0 {
+		if len(p.Gags) == 0 {
+			return "You have no gags\n"
+		}
+		quoted := []string{}
+		for _, g := range p.Gags {
+			quoted = append(quoted, fmt.Sprintf("%q", g))
+		}
+		return strings.Join(quoted, ", ") + "\n"
+	}
+	text, _ := splitQuoted(args)
+	if text == "" {
+		return "Usage: gag \"text\"\n"
+	}
+	for _, g := range p.Gags {
+		if strings.EqualFold(g, text) {
+			return fmt.Sprintf("Lines with %q are already gagged\n", text)
+		}
+	}
+	if len(p.Gags) >= maxRules {
+		return fmt.Sprintf("You can't have more than %d gags\n", maxRules)
+	}
+	p.Gags = append(p.Gags, text)
+	return s.savePreferences(p, fmt.Sprintf("Lines with %q are gagged\n", text))
+}
+
+// ungag removes a gag of the player.
+// Usage: ungag "text"
+func (s *Server) ungag(p *area.Player, args []string) string {
+	text, _ := splitQuoted(args)
+	for i := range p.Gags {
+		if strings.EqualFold(p.Gags[i], text) {
+			p.Gags = append(p.Gags[:i], p.Gags[i+1:]...)
+			return s.savePreferences(p, fmt.Sprintf("Lines with %q are no longer gagged\n", text))
+		}
+	}
+	return "Usage: ungag \"text\"\n"
+}
+
+// savePreferences keeps what the player set up in the player file.
+func (s *Server) savePreferences(p *area.Player, msg string) string {
+	if err := s.savePlayer(p); err != nil {
+		log.Error(fmt.Sprintf("Cannot save %q: %v", p.Nickname, err))
+	}
+	return msg
+}
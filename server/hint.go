@@ -0,0 +1,205 @@
+package server
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/droslean/thyranew/area"
+	"github.com/gothyra/toml"
+
+	log "gopkg.in/inconshreveable/log15.v2"
+)
+
+// tutorialQuest is the quest the steps of the tutorial are kept under, by
+// their number, until it is done.
+const tutorialQuest = "Tutorial"
+
+// loadHints loads the hints for new players and the steps of the tutorial
+// from the static directory into memory.
+func (s *Server) loadHints() error {
+	path := s.staticDir + "/hints.toml"
+	fileContent, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		log.Info(fmt.Sprintf("%s could not be loaded: %v", path, err))
+		return err
+	}
+
+	hints := struct {
+		Hints    []area.Hint         `toml:"hints"`
+		Tutorial []area.TutorialStep `toml:"tutorial"`
+	}{}
+	if _, err := toml.Decode(string(fileContent), &hints); err != nil {
+		log.Info(fmt.Sprintf("%s could not be unmarshaled: %v", path, err))
+		return err
+	}
+
+	for _, h := range hints.Hints {
+		log.Info(fmt.Sprintf("Loaded hint %q", h.Name))
+	}
+	log.Info(fmt.Sprintf("Loaded %d tutorial steps", len(hints.Tutorial)))
+	s.Hints = hints.Hints
+	s.Tutorial = hints.Tutorial
+	return nil
+}
+
+// hintsOf returns what the account of the client was shown of the hints, or
+// nil when it can't be read.
+func (s *Server) hintsOf(cl *Client) *HintRecord {
+	if record, ok := s.hintRecords[cl.hash]; ok {
+		return record
+	}
+	record, err := s.db.GetHints(s.ctxOf(cl.Player.Nickname), cl.hash)
+	if err != nil {
+		log.Error(fmt.Sprintf("Cannot read the hints of %q: %v", cl.Player.Nickname, err))
+		return nil
+	}
+	s.hintRecords[cl.hash] = &record
+	return &record
+}
+
+// putHints writes down what the account of the client was shown of the
+// hints.
+func (s *Server) putHints(cl *Client, record *HintRecord) {
+	if err := s.db.PutHints(s.ctxOf(cl.Player.Nickname), cl.hash, *record); err != nil {
+		log.Error(fmt.Sprintf("Cannot write the hints of %q down: %v", cl.Player.Nickname, err))
+	}
+}
+
+// hint tells the player the hints the event shows that their account was
+// not shown yet, unless they turned hints off, and moves them along the
+// tutorial when the event is what its current step waits for.
+func (s *Server) hint(roomsMap map[string]map[string][][]area.Cube, p *area.Player, event string) {
+	cl, ok := s.clientByNick(p.Nickname)
+	if !ok {
+		return
+	}
+	s.showHints(roomsMap, cl, event)
+	s.advanceTutorial(roomsMap, p, event)
+}
+
+// showHints tells the client the hints the event shows that their account
+// was not shown yet, unless they turned hints off.
+func (s *Server) showHints(roomsMap map[string]map[string][][]area.Cube, cl *Client, event string) {
+	record := s.hintsOf(cl)
+	if record == nil || record.Off {
+		return
+	}
+	shown := map[string]bool{}
+	for _, name := range record.Shown {
+		shown[name] = true
+	}
+	told := false
+	for _, h := range s.Hints {
+		if shown[h.Name] || !area.Triggers(h.On, event) {
+			continue
+		}
+		record.Shown = append(record.Shown, h.Name)
+		s.tellPlayer(roomsMap, cl.Player.Nickname, tagged(TagSystem, fmt.Sprintf("Hint: %s\n", h.Text)))
+		told = true
+	}
+	if told {
+		s.putHints(cl, record)
+	}
+}
+
+// tutorialStep returns the number of the step of the tutorial the player is
+// on, or zero when they are not doing it.
+func (s *Server) tutorialStep(p *area.Player) int {
+	step, err := strconv.Atoi(p.Quests[tutorialQuest])
+	if err != nil || step < 1 || step > len(s.Tutorial) {
+		return 0
+	}
+	return step
+}
+
+// advanceTutorial moves the player to the next step of the tutorial when the
+// event is what their current step waits for.
+func (s *Server) advanceTutorial(roomsMap map[string]map[string][][]area.Cube, p *area.Player, event string) {
+	step := s.tutorialStep(p)
+	if step == 0 || !area.Triggers(s.Tutorial[step-1].Done, event) {
+		return
+	}
+	if step == len(s.Tutorial) {
+		p.Quests[tutorialQuest] = "done"
+		s.tellPlayer(roomsMap, p.Nickname, tagged(TagSystem, "Tutorial: you finished the tutorial, and are on your own from here\n"))
+		s.publish(WorldEvent{Type: EventQuestDone, Player: p.Nickname, Area: p.Area, Room: p.Room, Detail: tutorialQuest})
+	} else {
+		p.Quests[tutorialQuest] = strconv.Itoa(step + 1)
+		s.tellPlayer(roomsMap, p.Nickname, tagged(TagSystem, fmt.Sprintf("Tutorial: %s\n", s.Tutorial[step].Text)))
+	}
+	if err := s.savePlayer(p); err != nil {
+		log.Error(fmt.Sprintf("Cannot save player %q: %v", p.Nickname, err))
+	}
+}
+
+// hints shows whether the account of the player is shown hints, turns them
+// on or off, or shows them all over again.
+// Usage: hints [on|off|reset]
+func (s *Server) hints(cl *Client, args []string) string {
+	record := s.hintsOf(cl)
+	if record == nil {
+		return "Your hints can't be read right now\n"
+	}
+	if len(args) == 0 {
+		if record.Off {
+			return "Hints are off, type hints on to see them\n"
+		}
+		return fmt.Sprintf("Hints are on, and you were shown %d of %d\n", len(record.Shown), len(s.Hints))
+	}
+	msg := ""
+	switch strings.ToLower(args[0]) {
+	case "on":
+		record.Off = false
+		msg = "Hints are on\n"
+	case "off":
+		record.Off = true
+		msg = "Hints are off\n"
+	case "reset":
+		record.Shown = nil
+		msg = "You will be shown every hint again\n"
+	default:
+		return "Usage: hints [on|off|reset]\n"
+	}
+	s.putHints(cl, record)
+	return msg
+}
+
+// tutorial shows the step of the tutorial the player is on, or starts it
+// over or stops it.
+// Usage: tutorial [start|stop]
+func (s *Server) tutorial(p *area.Player, args []string) string {
+	if len(s.Tutorial) == 0 {
+		return "There is no tutorial\n"
+	}
+	if len(args) == 0 {
+		step := s.tutorialStep(p)
+		if step == 0 {
+			if p.Quests[tutorialQuest] == "done" {
+				return "You finished the tutorial, type tutorial start to do it again\n"
+			}
+			return "Type tutorial start to be guided through your first steps\n"
+		}
+		return fmt.Sprintf("Tutorial, step %d of %d: %s\n", step, len(s.Tutorial), s.Tutorial[step-1].Text)
+	}
+	switch strings.ToLower(args[0]) {
+	case "start":
+		if p.Quests == nil {
+			p.Quests = make(map[string]string)
+		}
+		p.Quests[tutorialQuest] = "1"
+		return s.savePreferences(p, fmt.Sprintf("Tutorial: %s\n", s.Tutorial[0].Text))
+	case "stop":
+		if s.tutorialStep(p) == 0 {
+			return "You are not doing the tutorial\n"
+		}
+		delete(p.Quests, tutorialQuest)
+		return s.savePreferences(p, "You stop the tutorial, type tutorial start to start it over\n")
+	}
+	return "Usage: tutorial [start|stop]\n"
+}
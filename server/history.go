@@ -0,0 +1,208 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/droslean/thyranew/area"
+
+	log "gopkg.in/inconshreveable/log15.v2"
+)
+
+// The kinds of entries in the history of a character.
+const (
+	historyLevel       = "level"
+	historyDeath       = "death"
+	historyLoot        = "loot"
+	historyQuest       = "quest"
+	historyAchievement = "achievement"
+)
+
+const (
+	// rareLoot is the rarity from which on loot makes it into the history of
+	// whoever takes it.
+	rareLoot = "rare"
+	// historyLines is how many entries the history and the obituaries show.
+	historyLines = 20
+)
+
+// recordHistory subscribes the history of the characters to the events it
+// is made of.
+func (s *Server) recordHistory() {
+	s.subscribe(EventPlayerLeveled, func(e WorldEvent) {
+		s.chronicle(e, historyLevel, func(p area.Player) string { return fmt.Sprintf("Reached level %d", p.Level) })
+	})
+	s.subscribe(EventPlayerDied, func(e WorldEvent) {
+		s.chronicle(e, historyDeath, func(area.Player) string {
+			if e.By == "" {
+				return fmt.Sprintf("Died in %s", e.Room)
+			}
+			return fmt.Sprintf("Killed by %s in %s", e.By, e.Room)
+		})
+	})
+	s.subscribe(EventRareLoot, func(e WorldEvent) {
+		s.chronicle(e, historyLoot, func(area.Player) string { return fmt.Sprintf("Found %s on %s", e.Detail, e.By) })
+	})
+	s.subscribe(EventQuestDone, func(e WorldEvent) {
+		s.chronicle(e, historyQuest, func(area.Player) string { return fmt.Sprintf("Completed the quest %s", e.Detail) })
+	})
+	s.subscribe(EventAchievement, func(e WorldEvent) {
+		s.chronicle(e, historyAchievement, func(area.Player) string { return fmt.Sprintf("Earned the achievement %s", e.Detail) })
+	})
+}
+
+// chronicle appends what the event did to the player to their history, as
+// the text tells it.
+func (s *Server) chronicle(e WorldEvent, kind string, text func(p area.Player) string) {
+	cl, ok := s.clientByNick(e.Player)
+	if !ok {
+		return
+	}
+	p := *cl.Player
+	entry := &HistoryEntry{Time: s.now(), Player: p.Nickname, Kind: kind, Level: p.Level, Text: text(p)}
+	if err := s.db.AddHistory(context.Background(), entry); err != nil {
+		log.Error(fmt.Sprintf("Cannot record the %s of %q in their history: %v", kind, p.Nickname, err))
+	}
+}
+
+// historyOf returns the latest entries of the history of the player, of the
+// kinds given, or of every kind.
+func (s *Server) historyOf(ctx context.Context, nick string, kinds ...string) ([]HistoryEntry, error) {
+	return s.db.ListHistory(ctx, func(e HistoryEntry) bool {
+		if nick != "" && !strings.EqualFold(e.Player, nick) {
+			return false
+		}
+		if len(kinds) == 0 {
+			return true
+		}
+		for _, kind := range kinds {
+			if e.Kind == kind {
+				return true
+			}
+		}
+		return false
+	}, historyLines)
+}
+
+// history shows the history of the player, or of another.
+// Usage: history [player]
+func (s *Server) history(p *area.Player, args []string) string {
+	nick := p.Nickname
+	if len(args) > 0 {
+		nick = args[0]
+	}
+	entries, err := s.historyOf(s.ctxOf(p.Nickname), nick)
+	if err != nil {
+		log.Error(fmt.Sprintf("Cannot read the history of %q: %v", nick, err))
+		return "The history cannot be read right now\n"
+	}
+	if len(entries) == 0 {
+		return fmt.Sprintf("Nothing is told of %s yet\n", nick)
+	}
+	lines := []string{}
+	for _, e := range entries {
+		lines = append(lines, fmt.Sprintf("%s level %d: %s", e.Time.Format("2006-01-02 15:04"), e.Level, e.Text))
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// obituaries tells who died lately, and to what.
+// Usage: obituaries
+func (s *Server) obituaries(p *area.Player) string {
+	entries, err := s.historyOf(s.ctxOf(p.Nickname), "", historyDeath)
+	if err != nil {
+		log.Error(fmt.Sprintf("Cannot read the obituaries: %v", err))
+		return "The obituaries cannot be read right now\n"
+	}
+	if len(entries) == 0 {
+		return "Nobody died lately\n"
+	}
+	lines := []string{}
+	for _, e := range entries {
+		lines = append(lines, fmt.Sprintf("%s %s, level %d: %s", e.Time.Format("2006-01-02 15:04"), e.Player, e.Level, e.Text))
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// Profile is the public face of a character, served by the admin API for the
+// web.
+type Profile struct {
+	Nickname     string         `json:"nickname"`
+	Title        string         `json:"title,omitempty"`
+	Class        string         `json:"class"`
+	Level        int            `json:"level"`
+	Achievements []string       `json:"achievements"`
+	Description  string         `json:"description,omitempty"`
+	Played       int            `json:"played"` // Seconds played up to the last logout
+	History      []HistoryEntry `json:"history"`
+}
+
+// playerOf returns the player, online or not. It has to be called from the
+// goroutine of the world.
+func (s *Server) playerOf(nick string) (area.Player, bool) {
+	if cl, online := s.clientByNick(nick); online {
+		return *cl.Player, true
+	}
+	if found, err := s.loadPlayer(nick); err == nil && found {
+		return s.Players[nick], true
+	}
+	return area.Player{}, false
+}
+
+// profileFrom returns the profile of the player, without their history.
+func profileFrom(p area.Player) Profile {
+	return Profile{Nickname: p.Nickname, Title: p.Title, Class: p.Class, Level: p.Level, Achievements: p.Achievements, Description: p.Description, Played: p.Played}
+}
+
+// serveObituaries serves the latest deaths.
+func (s *Server) serveObituaries(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	entries, err := s.historyOf(r.Context(), "", historyDeath)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(entries)
+}
+
+// serveProfile serves the profile of the player in the query, with their
+// history.
+func (s *Server) serveProfile(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	nick := r.FormValue("player")
+	if nick == "" {
+		http.Error(w, "player is required", http.StatusBadRequest)
+		return
+	}
+
+	var profile Profile
+	found := false
+	if !s.inWorld(r.Context(), func() {
+		if p, ok := s.playerOf(nick); ok {
+			profile, found = profileFrom(p), true
+		}
+	}) {
+		return
+	}
+	if !found {
+		http.Error(w, fmt.Sprintf("no player %q", nick), http.StatusNotFound)
+		return
+	}
+	var err error
+	if profile.History, err = s.historyOf(r.Context(), profile.Nickname); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(profile)
+}
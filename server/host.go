@@ -0,0 +1,192 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+
+	log "gopkg.in/inconshreveable/log15.v2"
+)
+
+// worldSeparator ends the names players log in with, before the world they
+// ask for, like alice+dev.
+const worldSeparator = "+"
+
+// Tenant is a world of a host, by its name.
+type Tenant struct {
+	Name   string
+	Server *Server
+}
+
+// Host serves many worlds from one process, for staging servers next to the
+// live one or themed shards. Every world keeps its own database, static and
+// data directories and settings, and its players never meet those of the
+// others.
+//
+// The worlds share the listener of the first, where players pick a world by
+// ending their name with + and its name, like alice+dev, and its host key.
+// Those who pick none play in the first. Worlds with a port of their own
+// take their players there too. They share the debug listener and the
+// health probes of the first as well, with the admin API of the others
+// under /worlds/<name>/.
+type Host struct {
+	worlds []*Server
+	byName map[string]*Server
+}
+
+// NewHost hosts the worlds. A host of one world is a plain server, and its
+// world needs no name.
+func NewHost(tenants ...Tenant) (*Host, error) {
+	if len(tenants) == 0 {
+		return nil, fmt.Errorf("a host needs a world")
+	}
+	h := &Host{byName: make(map[string]*Server)}
+	for _, t := range tenants {
+		name := strings.ToLower(t.Name)
+		if len(tenants) > 1 && !IsValidUsername(name) {
+			return nil, fmt.Errorf("%q is not a valid name for a world", t.Name)
+		}
+		if _, ok := h.byName[name]; ok {
+			return nil, fmt.Errorf("there are two worlds named %q", name)
+		}
+		t.Server.name = name
+		h.byName[name] = t.Server
+		h.worlds = append(h.worlds, t.Server)
+	}
+	if len(h.worlds) > 1 {
+		h.worlds[0].tenants = h
+	}
+	return h, nil
+}
+
+// pick returns the world the player asked for with the name they logged in
+// with, and the name they play under there. It returns no world if they
+// asked for one the host doesn't have.
+func (h *Host) pick(user string) (*Server, string) {
+	i := strings.LastIndex(user, worldSeparator)
+	if i < 0 {
+		return h.worlds[0], user
+	}
+	return h.byName[strings.ToLower(user[i+len(worldSeparator):])], user[:i]
+}
+
+// banner tells the players connecting which world they get, and what others
+// there are.
+func (h *Host) banner(user string) string {
+	names := []string{}
+	for _, s := range h.worlds {
+		names = append(names, s.name)
+	}
+	sort.Strings(names[1:])
+	worlds := fmt.Sprintf("The worlds here are %s. Log in as name%sworld to pick one.\r\n", strings.Join(names, ", "), worldSeparator)
+	s, _ := h.pick(user)
+	if s == nil {
+		return "There is no such world here. " + worlds
+	}
+	return fmt.Sprintf("Welcome to the %s world. %s", s.name, worlds)
+}
+
+// pick returns the world of the player logging in as user, and the name they
+// play under there.
+func (s *Server) pick(user string) (*Server, string) {
+	if s.tenants == nil {
+		return s, user
+	}
+	return s.tenants.pick(user)
+}
+
+// Start serves the worlds until the process is told to stop.
+func (h *Host) Start() {
+	first := h.worlds[0]
+	// Fatal errors kill the whole process, so they are the first world's.
+	first.catchFatal()
+	// Each world listens on its port, unless it shares the one of the first.
+	listeners := map[*Server]*net.TCPListener{}
+	for _, s := range h.worlds {
+		if s != first && s.port == first.port {
+			continue
+		}
+		l, err := net.ListenTCP("tcp4", &net.TCPAddr{Port: s.port})
+		if err != nil {
+			log.Error(fmt.Sprintf("Cannot listen on port %d: %v", s.port, err))
+			for _, l := range listeners {
+				l.Close()
+			}
+			return
+		}
+		log.Info(fmt.Sprintf("Listening for incoming connections%s on localhost:%d", h.of(s), s.port))
+		listeners[s] = l
+	}
+
+	if first.config.Debug != "" {
+		first.startDiagnostics(first.config.Debug, h.worlds[1:])
+	}
+	if first.config.Health != "" {
+		startHealth(first.config.Health, h.worlds)
+	}
+	for _, s := range h.worlds {
+		if s.config.Web != "" {
+			s.startWeb(s.config.Web)
+		}
+		s.startCluster()
+		s.startIntermud()
+
+		// The world has all the server-side logic.
+		if err := s.world.Start(); err != nil {
+			log.Error(fmt.Sprintf("Cannot start the world%s: %v", h.of(s), err))
+			return
+		}
+	}
+
+	// Cancelling the context gracefully shuts down all the rest of the threads.
+	ctx, cancel := context.WithCancel(context.Background())
+	wg := &sync.WaitGroup{}
+
+	// accept connections
+	for s, l := range listeners {
+		s, l := s, l
+		go supervise("Accepting connections", acceptPolicy, func() {
+			s.accept(ctx, l, wg)
+		}, nil, s.reportCrash)
+	}
+	for _, s := range h.worlds {
+		atomic.StoreInt32(&s.ready, 1)
+	}
+
+	// Orchestrators like Kubernetes stop servers with SIGTERM.
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	sig := <-signals
+	log.Warn(fmt.Sprintf("Server is terminating on %v...", sig))
+	for _, s := range h.worlds {
+		atomic.StoreInt32(&s.ready, 0)
+	}
+	cancel()
+	for _, l := range listeners {
+		l.Close()
+	}
+
+	for _, s := range h.worlds {
+		s.world.Stop()
+	}
+	wg.Wait()
+	for _, s := range h.worlds {
+		s.chatLog.close()
+	}
+	log.Warn("Server shutdown.")
+}
+
+// of names the world in the log, when the host has more than one.
+func (h *Host) of(s *Server) string {
+	if len(h.worlds) == 1 {
+		return ""
+	}
+	return fmt.Sprintf(" of the %s world", s.name)
+}
@@ -0,0 +1,140 @@
+package server
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/droslean/thyranew/area"
+
+	log "gopkg.in/inconshreveable/log15.v2"
+)
+
+// maxItemName is how long the name a player gives an item may be.
+const maxItemName = 24
+
+// receive gives the player an item of the named prototype, bound to them
+// when the prototype binds. Keys go on their keyring. It reports whether the
+// player could carry it.
+func (s *Server) receive(p *area.Player, name string) bool {
+	if s.itemDef(name).Kind == area.ItemKey {
+		s.collectKey(p, name)
+		return true
+	}
+	if !s.fits(p, name) {
+		return false
+	}
+	item := area.ItemInstance{Item: name}
+	if s.itemDef(name).Binds {
+		item.Bound = p.Nickname
+	}
+	p.GiveItem(item)
+	return true
+}
+
+// itemNameTaken returns why the player can't give an item the name, or
+// nothing when they can. Names may not pass for another item.
+func (s *Server) itemNameTaken(p *area.Player, name string) string {
+	if len(name) < 3 || len(name) > maxItemName {
+		return fmt.Sprintf("A name takes 3 to %d letters\n", maxItemName)
+	}
+	for _, r := range name {
+		if !unicode.IsLetter(r) && r != ' ' && r != '\'' && r != '-' {
+			return "A name takes only letters, spaces, hyphens and apostrophes\n"
+		}
+	}
+	if _, ok := s.Items[strings.ToLower(name)]; ok {
+		return fmt.Sprintf("There are items called %s already\n", name)
+	}
+	if _, ok := s.itemInReach(p, name, false); ok {
+		return fmt.Sprintf("You have something called %s already\n", name)
+	}
+	return ""
+}
+
+// engrave gives an item the player has on or carries a name of their own,
+// by which it shows and can be picked out. The item is bound to them after,
+// so it stays with them through death and theft.
+// Usage: engrave <item> as <name>
+func (s *Server) engrave(p *area.Player, args []string) string {
+	usage := "Usage: engrave <item> as <name>\n"
+	at := -1
+	for i, arg := range args {
+		if strings.EqualFold(arg, "as") {
+			at = i
+			break
+		}
+	}
+	if at < 1 || at == len(args)-1 {
+		return usage
+	}
+	item, name := strings.Join(args[:at], " "), strings.Join(args[at+1:], " ")
+	h, ok := s.itemInReach(p, item, false)
+	if !ok {
+		return fmt.Sprintf("You have no %s\n", item)
+	}
+	if why := s.itemNameTaken(p, name); why != "" {
+		return why
+	}
+	changeItem(p, h.key(), func(e *area.ItemInstance) {
+		e.Name = name
+		e.Bound = p.Nickname
+	})
+	return fmt.Sprintf("You engrave %s on the %s, and it is bound to you\n", name, h.name())
+}
+
+// reload loads what is named from the static directory again, while the
+// game runs.
+// Usage: reload items
+func (s *Server) reload(args []string) string {
+	if len(args) != 1 || !strings.EqualFold(args[0], "items") {
+		return "Usage: reload items\n"
+	}
+	return s.reloadItems()
+}
+
+// reloadItems loads the prototypes of the items again. Items in use only
+// hold the name of their prototype and look it up each time, so they take
+// the new one at once. A file that doesn't load leaves the old prototypes,
+// and prototypes gone from it are reported for the items players still
+// have.
+func (s *Server) reloadItems() string {
+	items, err := s.readItems()
+	if err != nil {
+		return fmt.Sprintf("The items file did not load, and the items stay as they were: %v\n", err)
+	}
+	old := s.Items
+	s.Items = items
+	log.Info(fmt.Sprintf("Reloaded %d items", len(items)))
+
+	gone := map[string][]string{}
+	for _, c := range s.OnlineClients() {
+		p := c.Player
+		names := append([]string{p.Weapon, p.Armor, p.OffHand}, p.Inventory...)
+		for _, e := range append(append([]area.ItemInstance{}, p.Gear...), p.Instances...) {
+			names = append(names, e.Gems...)
+		}
+		seen := map[string]bool{}
+		for _, name := range names {
+			key := strings.ToLower(name)
+			if _, was := old[key]; !was || seen[key] {
+				continue
+			}
+			if _, is := items[key]; !is {
+				seen[key] = true
+				gone[name] = append(gone[name], p.Nickname)
+			}
+		}
+	}
+	msg := fmt.Sprintf("Reloaded %d items\n", len(items))
+	if len(gone) == 0 {
+		return msg
+	}
+	lines := []string{}
+	for name, players := range gone {
+		lines = append(lines, fmt.Sprintf("%s, gone from the file, is still had by %s", name, strings.Join(players, ", ")))
+	}
+	sort.Strings(lines)
+	return msg + strings.Join(lines, "\n") + "\n"
+}
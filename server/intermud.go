@@ -0,0 +1,188 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/droslean/thyranew/area"
+	"github.com/droslean/thyranew/intermud"
+
+	log "gopkg.in/inconshreveable/log15.v2"
+)
+
+const (
+	// intermudRetry is how long the gateway waits before it connects again
+	// to the hub.
+	intermudRetry = time.Minute
+	// intermudQueue is how many messages wait for the hub before more are
+	// dropped.
+	intermudQueue = 100
+	// intermudVersion is how the server introduces itself to the network.
+	intermudVersion = "Thyra IMC2"
+)
+
+// intermudPolicy is the restart policy of the gateway.
+var intermudPolicy = RestartPolicy{MaxRestarts: 5, Window: time.Minute}
+
+// IntermudConfig is the intermud chat network the server is on, through a
+// hub speaking IMC2.
+type IntermudConfig struct {
+	// Hub is the host and port of the hub. The gateway is off when empty.
+	Hub string `toml:"hub"`
+	// Name is what the network knows the server by, and the passwords are
+	// those it was registered at the hub with.
+	Name           string `toml:"name"`
+	ClientPassword string `toml:"clientpassword"`
+	ServerPassword string `toml:"serverpassword"`
+	// Channels are the channels of the network, like Server01:ichat, by the
+	// channel the players join here to hear them.
+	Channels map[string]string `toml:"channels"`
+}
+
+// gateway passes the chat of the channels between the players and the
+// intermud network.
+type gateway struct {
+	config IntermudConfig
+	out    chan intermud.Message
+	mu     sync.Mutex
+	conn   *intermud.Conn // Nil while the hub is not reached
+}
+
+// startIntermud connects the gateway to the hub, and keeps connecting it
+// again whenever the connection drops.
+func (s *Server) startIntermud() {
+	c := s.config.Intermud
+	if c.Hub == "" {
+		return
+	}
+	for local := range c.Channels {
+		if !channelName.MatchString(local) {
+			log.Warn(fmt.Sprintf("Intermud channel %q has no valid name here, nobody can join it", local))
+		}
+	}
+	s.intermud = &gateway{config: c, out: make(chan intermud.Message, intermudQueue)}
+	go supervise("Intermud gateway", intermudPolicy, s.runIntermud, nil, s.reportCrash)
+}
+
+// runIntermud connects to the hub, and passes the messages both ways until
+// the connection drops, over and over.
+func (s *Server) runIntermud() {
+	g := s.intermud
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), intermudRetry)
+		conn, err := intermud.Dial(ctx, intermud.Config{
+			Hub:            g.config.Hub,
+			Name:           g.config.Name,
+			ClientPassword: g.config.ClientPassword,
+			ServerPassword: g.config.ServerPassword,
+			Version:        intermudVersion,
+		})
+		cancel()
+		if err != nil {
+			log.Warn(fmt.Sprintf("Cannot connect to the intermud hub %s: %v", g.config.Hub, err))
+			time.Sleep(intermudRetry)
+			continue
+		}
+		log.Info(fmt.Sprintf("Connected to %s through the hub %s as %q", conn.Network(), conn.Hub(), g.config.Name))
+		g.mu.Lock()
+		g.conn = conn
+		g.mu.Unlock()
+
+		done := make(chan struct{})
+		go func() {
+			for {
+				select {
+				case m := <-g.out:
+					if err := conn.Say(m.From, m.Channel, m.Text, m.Emote); err != nil {
+						conn.Close()
+						return
+					}
+				case <-done:
+					return
+				}
+			}
+		}()
+		for {
+			m, err := conn.Receive()
+			if err != nil {
+				log.Warn(fmt.Sprintf("Lost the intermud hub %s: %v", g.config.Hub, err))
+				break
+			}
+			s.worldTasks <- func() { s.hearIntermud(s.world.rooms, m) }
+		}
+		close(done)
+		conn.Close()
+		g.mu.Lock()
+		g.conn = nil
+		g.mu.Unlock()
+		time.Sleep(intermudRetry)
+	}
+}
+
+// hearIntermud tells the players listening to the channel what was said on
+// it on another MUD.
+func (s *Server) hearIntermud(roomsMap map[string]map[string][][]area.Cube, m *intermud.Message) {
+	if !s.featureOn(featureIntermud) {
+		return
+	}
+	name := ""
+	for local, network := range s.config.Intermud.Channels {
+		if strings.EqualFold(network, m.Channel) {
+			name = local
+		}
+	}
+	if name == "" {
+		return
+	}
+	from := m.From + "@" + m.Mud
+	text := fmt.Sprintf("[%s] %s: %s\n", name, from, m.Text)
+	if m.Emote {
+		text = fmt.Sprintf("[%s] %s %s\n", name, from, m.Text)
+	}
+	s.chatLog.add(LogEntry{Time: s.now(), Stream: chatStream(name), Player: from, Text: m.Text})
+	s.broadcast(roomsMap, Broadcast{Scope: ScopeChannel, Channel: name, From: from, Kind: TagChat, Text: text})
+}
+
+// relayIntermud passes what the player said on the channel on to the
+// network, if the channel is on it. When the hub falls behind, it is dropped.
+func (s *Server) relayIntermud(p *area.Player, channel, text string) {
+	g := s.intermud
+	if g == nil || !s.featureOn(featureIntermud) {
+		return
+	}
+	network, ok := g.config.Channels[channel]
+	if !ok {
+		return
+	}
+	select {
+	case g.out <- intermud.Message{From: p.Nickname, Channel: network, Text: text}:
+	default:
+		log.Warn(fmt.Sprintf("The intermud hub falls behind, dropped what %s said on %s", p.Nickname, channel))
+	}
+}
+
+// intermudStatus tells the player whether the server is on the network, and
+// which of the channels here are heard on other MUDs.
+// Usage: intermud
+func (s *Server) intermudStatus() string {
+	g := s.intermud
+	if g == nil {
+		return "This server is not on an intermud network\n"
+	}
+	g.mu.Lock()
+	conn := g.conn
+	g.mu.Unlock()
+	if conn == nil {
+		return fmt.Sprintf("The intermud hub %s can't be reached right now\n", g.config.Hub)
+	}
+	lines := []string{}
+	for local, network := range g.config.Channels {
+		lines = append(lines, fmt.Sprintf("  %s: %s", local, network))
+	}
+	sort.Strings(lines)
+	return fmt.Sprintf("On %s through the hub %s, with the channels:\n%s\n", conn.Network(), conn.Hub(), strings.Join(lines, "\n"))
+}
@@ -0,0 +1,512 @@
+package server
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/droslean/thyranew/area"
+	"github.com/droslean/thyranew/game"
+	"github.com/gothyra/toml"
+
+	log "gopkg.in/inconshreveable/log15.v2"
+)
+
+// identifyScroll is the item that reveals the hidden properties of another
+// when read over it.
+const identifyScroll = "Scroll of Identify"
+
+// loadItems loads what the items are like from the static directory into
+// memory. Without the file items are only their names.
+func (s *Server) loadItems() error {
+	items, err := s.readItems()
+	if err != nil {
+		return err
+	}
+	s.Items = items
+	log.Info(fmt.Sprintf("Loaded %d items", len(items)))
+	return nil
+}
+
+// readItems reads the prototypes of the items file, by their lowercase name.
+func (s *Server) readItems() (map[string]area.Item, error) {
+	byName := make(map[string]area.Item)
+	path := s.staticDir + "/items.toml"
+	fileContent, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return byName, nil
+	}
+	if err != nil {
+		log.Info(fmt.Sprintf("%s could not be loaded: %v", path, err))
+		return nil, err
+	}
+
+	items := struct {
+		Items []area.Item `toml:"items"`
+	}{}
+	if _, err := toml.Decode(string(fileContent), &items); err != nil {
+		log.Info(fmt.Sprintf("%s could not be unmarshaled: %v", path, err))
+		return nil, err
+	}
+
+	for _, it := range items.Items {
+		byName[strings.ToLower(it.Name)] = it
+	}
+	return byName, nil
+}
+
+// itemDef returns what the named item is like. Items missing from the items
+// file are only their name.
+func (s *Server) itemDef(name string) area.Item {
+	if it, ok := s.Items[strings.ToLower(name)]; ok {
+		return it
+	}
+	return area.Item{Name: name}
+}
+
+// identified reports whether the player knows the hidden properties of the
+// named item.
+func identified(p *area.Player, name string) bool {
+	for _, known := range p.Identified {
+		if strings.EqualFold(known, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// heldItem is an item as a player has it: its prototype, and what this one
+// has of its own.
+type heldItem struct {
+	area.Item
+	instance area.ItemInstance // Empty for a plain item
+}
+
+// name returns the name of the item, with its enchantments, after the name
+// its owner gave it.
+func (h heldItem) name() string {
+	name := h.Name
+	if h.instance.Enchant > 0 {
+		name = fmt.Sprintf("%s +%d", h.Name, h.instance.Enchant)
+	}
+	if h.instance.Name != "" {
+		return fmt.Sprintf("%s (%s)", h.instance.Name, name)
+	}
+	return name
+}
+
+// key returns the name that picks out this item of those the player has:
+// the name its owner gave it, or else that of its prototype.
+func (h heldItem) key() string {
+	if h.instance.Name != "" {
+		return h.instance.Name
+	}
+	return h.Name
+}
+
+// held returns the named item as the player has it: the one they have on,
+// or else one they carry. The name may be the one the player gave the item.
+func (s *Server) held(p *area.Player, name string) heldItem {
+	e := p.GearInstance(name)
+	if e == nil && !p.Wears(name) {
+		e = p.Instance(name)
+	}
+	if e == nil {
+		return heldItem{Item: s.itemDef(name)}
+	}
+	return heldItem{Item: s.itemDef(e.Item), instance: *e}
+}
+
+// changeItem changes what the named item has of its own: the one the player
+// has on, or else one they carry. It reports whether they have one.
+func changeItem(p *area.Player, name string, change func(e *area.ItemInstance)) bool {
+	if p.GearInstance(name) != nil || p.Wears(name) {
+		return p.ChangeGear(name, change)
+	}
+	return p.ChangeItem(name, change)
+}
+
+// bonus is what an item adds to whoever uses it, all told.
+type bonus struct {
+	hit, damage, ac int
+	defenses        game.Defenses
+}
+
+// add adds the properties to the bonus.
+func (b *bonus) add(props area.ItemProperties) {
+	b.hit += props.Hit
+	b.damage += props.Damage
+	b.ac += props.AC
+	if props.Resist != "" {
+		b.defenses.Resists = append(b.defenses.Resists, props.Resist)
+	}
+	if props.Immune != "" {
+		b.defenses.Immune = append(b.defenses.Immune, props.Immune)
+	}
+	if props.Vulnerable != "" {
+		b.defenses.Vulnerable = append(b.defenses.Vulnerable, props.Vulnerable)
+	}
+}
+
+// bonusOf returns what the item adds: its enchantments, the gems set in it,
+// and its hidden properties when they count.
+func (s *Server) bonusOf(h heldItem, hidden bool) bonus {
+	b := bonus{}
+	if hidden {
+		b.add(h.Hidden)
+	}
+	switch h.Kind {
+	case area.ItemWeapon:
+		b.hit += h.instance.Enchant
+		b.damage += h.instance.Enchant
+	case area.ItemArmor, area.ItemShield:
+		b.ac += h.instance.Enchant
+	}
+	for _, gem := range h.instance.Gems {
+		b.add(s.itemDef(gem).Gem)
+	}
+	return b
+}
+
+// gearBonus returns what the weapon, the armor and the off hand of the
+// player add, hidden properties and all.
+func (s *Server) gearBonus(p *area.Player) bonus {
+	b := bonus{}
+	for _, name := range []string{p.Weapon, p.Armor, p.OffHand} {
+		if name == "" {
+			continue
+		}
+		g := s.bonusOf(s.held(p, name), true)
+		b.hit, b.damage, b.ac = b.hit+g.hit, b.damage+g.damage, b.ac+g.ac
+		b.defenses = b.defenses.Add(g.defenses)
+	}
+	return b
+}
+
+// armorClass returns the armor class of the player, with what their gear,
+// their shield and their stance add and the wear of their armor and their
+// load take.
+func (s *Server) armorClass(p *area.Player) int {
+	shield, _ := s.gearOf(p, area.ItemShield)
+	return game.WornAC(p.AC+s.gearBonus(p).ac, s.held(p, p.Armor).instance.Wear) + shield.Armor + game.Stance(p.Tactics.Stance).AC - s.loadPenalty(p)
+}
+
+// itemProperties describes the item in a few words each, the way every
+// command that shows items does. Its hidden properties are only told when
+// known; otherwise the item only shows it holds more.
+func itemProperties(h heldItem, known bool) []string {
+	props := []string{}
+	switch h.Kind {
+	case area.ItemWeapon:
+		props = append(props, fmt.Sprintf("weapon, 1d%d damage", h.Die))
+		props = append(props, fmt.Sprintf("%s, a swing every %ds", weaponFamily(h.Item), weaponSpeed(h.Item)))
+		if h.Hands > 1 {
+			props = append(props, "two-handed")
+		}
+	case area.ItemShield:
+		props = append(props, fmt.Sprintf("shield +%d, blocks %d%% of blows", h.Armor, h.Block))
+	case area.ItemArmor:
+		props = append(props, fmt.Sprintf("armor +%d, dexterity up to %+d", h.Armor, h.MaxDex))
+	case "", area.ItemContainer:
+	default:
+		props = append(props, h.Kind)
+	}
+	if h.Value > 0 {
+		props = append(props, fmt.Sprintf("worth %d gold", h.Value))
+	}
+	if h.Weight > 0 {
+		props = append(props, fmt.Sprintf("weighs %d pounds", h.Weight))
+	}
+	if h.Kind == area.ItemContainer && h.Capacity > 0 {
+		props = append(props, fmt.Sprintf("carries %d pounds more", h.Capacity))
+	}
+	if h.instance.Enchant > 0 {
+		props = append(props, fmt.Sprintf("enchantment %+d", h.instance.Enchant))
+	}
+	if h.Sockets > 0 {
+		sockets := append([]string{}, h.instance.Gems...)
+		for len(sockets) < h.Sockets {
+			sockets = append(sockets, "empty")
+		}
+		props = append(props, fmt.Sprintf("sockets %s", strings.Join(sockets, " and ")))
+	}
+	if h.instance.Wear > 0 {
+		props = append(props, fmt.Sprintf("worn %d of %d", h.instance.Wear, game.MaxWear))
+	}
+	if h.instance.Bound != "" {
+		props = append(props, "bound to "+h.instance.Bound)
+	} else if h.Binds {
+		props = append(props, "binds when picked up")
+	}
+	if gem := h.Gem; gem.Any() {
+		props = append(props, fmt.Sprintf("set in a socket %s", strings.Join(propertyWords(gem), ", ")))
+	}
+	if !h.Hidden.Any() {
+		return props
+	}
+	if !known {
+		return append(props, "unidentified")
+	}
+	return append(props, propertyWords(h.Hidden)...)
+}
+
+// propertyWords describes each of the properties in a few words.
+func propertyWords(props area.ItemProperties) []string {
+	words := []string{}
+	if props.Hit != 0 {
+		words = append(words, fmt.Sprintf("%+d to hit", props.Hit))
+	}
+	if props.Damage != 0 {
+		words = append(words, fmt.Sprintf("%+d damage", props.Damage))
+	}
+	if props.AC != 0 {
+		words = append(words, fmt.Sprintf("%+d armor class", props.AC))
+	}
+	if props.Resist != "" {
+		words = append(words, "resists "+props.Resist)
+	}
+	if props.Immune != "" {
+		words = append(words, "wards off "+props.Immune)
+	}
+	if props.Vulnerable != "" {
+		words = append(words, "weak to "+props.Vulnerable)
+	}
+	if props.Cursed {
+		words = append(words, "cursed")
+	}
+	return words
+}
+
+// describeItem sums the item up in a line, as far as the player knows it.
+func describeItem(p *area.Player, h heldItem) string {
+	props := itemProperties(h, identified(p, h.Name))
+	if len(props) == 0 {
+		return h.name()
+	}
+	return fmt.Sprintf("%s: %s", h.name(), strings.Join(props, ", "))
+}
+
+// gearOf returns the gear of the kind the player has on: their weapon,
+// their armor or their shield.
+func (s *Server) gearOf(p *area.Player, kind string) (heldItem, bool) {
+	name := ""
+	switch kind {
+	case area.ItemWeapon:
+		name = p.Weapon
+	case area.ItemArmor:
+		name = p.Armor
+	case area.ItemShield:
+		name = p.OffHand
+	}
+	if name == "" {
+		return heldItem{}, false
+	}
+	h := s.held(p, name)
+	return h, h.Kind == kind
+}
+
+// gearDelta returns how much better, or worse, item a is than item b of the
+// same kind, for the player and as far as they know the two.
+func (s *Server) gearDelta(p *area.Player, a, b heldItem) string {
+	ba, bb := s.bonusOf(a, identified(p, a.Name)), s.bonusOf(b, identified(p, b.Name))
+	deltas := []string{}
+	if a.Kind == area.ItemWeapon {
+		if d := game.AverageDamage(a.Die, ba.damage) - game.AverageDamage(b.Die, bb.damage); d != 0 {
+			deltas = append(deltas, fmt.Sprintf("%+.1f damage", d))
+		}
+		if d := ba.hit - bb.hit; d != 0 {
+			deltas = append(deltas, fmt.Sprintf("%+d to hit", d))
+		}
+	}
+	ac := ba.ac - bb.ac
+	switch a.Kind {
+	case area.ItemArmor:
+		ac += game.ArmorAC(p.DEX, a.Armor, a.MaxDex) - game.ArmorAC(p.DEX, b.Armor, b.MaxDex)
+	case area.ItemShield:
+		ac += a.Armor - b.Armor
+	}
+	if ac != 0 {
+		deltas = append(deltas, fmt.Sprintf("%+d armor class", ac))
+	}
+	if d := a.Block - b.Block; a.Kind == area.ItemShield && d != 0 {
+		deltas = append(deltas, fmt.Sprintf("%+d%% blocked", d))
+	}
+	if len(deltas) == 0 {
+		return "no better, no worse"
+	}
+	return strings.Join(deltas, ", ")
+}
+
+// itemInReach returns the named item if the player has it on, carries it,
+// or the shop open to them sells it. Items the player named go by their name
+// as well.
+func (s *Server) itemInReach(p *area.Player, name string, shops bool) (heldItem, bool) {
+	for _, item := range append([]string{p.Weapon, p.Armor, p.OffHand}, p.Inventory...) {
+		if item != "" && strings.EqualFold(item, name) {
+			return s.held(p, item), true
+		}
+	}
+	if p.GearInstance(name) != nil || p.Instance(name) != nil {
+		return s.held(p, name), true
+	}
+	if _, ok := s.talkingTo(p); !shops || !ok || p.Shop == "" {
+		return heldItem{}, false
+	}
+	for _, w := range s.waresOf(s.Shops[p.Shop]) {
+		if strings.EqualFold(w.Item, name) {
+			return heldItem{Item: s.itemDef(w.Item)}, true
+		}
+	}
+	return heldItem{}, false
+}
+
+// splitItems splits the words into the names of two items within reach of
+// the player: at the word the player put between them, or where both halves
+// name an item.
+func (s *Server) splitItems(p *area.Player, args []string, between string, shops bool) (heldItem, heldItem, bool) {
+	for i := 1; i < len(args)-1; i++ {
+		if strings.EqualFold(args[i], between) {
+			a, okA := s.itemInReach(p, strings.Join(args[:i], " "), shops)
+			b, okB := s.itemInReach(p, strings.Join(args[i+1:], " "), shops)
+			return a, b, okA && okB
+		}
+	}
+	for i := 1; i < len(args); i++ {
+		a, okA := s.itemInReach(p, strings.Join(args[:i], " "), shops)
+		b, okB := s.itemInReach(p, strings.Join(args[i:], " "), shops)
+		if okA && okB {
+			return a, b, true
+		}
+	}
+	return heldItem{}, heldItem{}, false
+}
+
+// compare shows how the items measure up against the gear the player has on,
+// and against each other. They may be carried, worn, or for sale in the shop
+// open to the player.
+// Usage: compare <item> [item]
+func (s *Server) compare(p *area.Player, args []string) string {
+	if len(args) == 0 {
+		return "Usage: compare <item> [item]\n"
+	}
+	items := []heldItem{}
+	if h, ok := s.itemInReach(p, strings.Join(args, " "), true); ok {
+		items = append(items, h)
+	} else if a, b, ok := s.splitItems(p, args, "with", true); ok {
+		items = append(items, a, b)
+	}
+	if len(items) == 0 {
+		return fmt.Sprintf("You have no %s at hand to compare\n", strings.Join(args, " "))
+	}
+
+	lines := []string{}
+	for _, h := range items {
+		lines = append(lines, describeItem(p, h))
+		gear, ok := s.gearOf(p, h.Kind)
+		switch {
+		case h.Kind != area.ItemWeapon && h.Kind != area.ItemArmor && h.Kind != area.ItemShield:
+			lines = append(lines, "  Not something to wield or wear")
+		case !ok:
+			lines = append(lines, fmt.Sprintf("  You have no %s on to compare it with", h.Kind))
+		case strings.EqualFold(gear.Name, h.Name) && gear.instance.Name == h.instance.Name && gear.instance.Enchant == h.instance.Enchant:
+			lines = append(lines, "  You have it on")
+		default:
+			lines = append(lines, fmt.Sprintf("  Against your %s: %s", gear.name(), s.gearDelta(p, h, gear)))
+		}
+	}
+	if len(items) == 2 && items[0].Kind == items[1].Kind && (items[0].Kind == area.ItemWeapon || items[0].Kind == area.ItemArmor || items[0].Kind == area.ItemShield) {
+		lines = append(lines, fmt.Sprintf("%s against %s: %s", items[0].name(), items[1].name(), s.gearDelta(p, items[0], items[1])))
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// inspect shows an item the player has on or carries in full, as far as they
+// know it.
+// Usage: inspect <item>
+func (s *Server) inspect(p *area.Player, args []string) string {
+	if len(args) == 0 {
+		return "Usage: inspect <item>\n"
+	}
+	name := strings.Join(args, " ")
+	h, ok := s.itemInReach(p, name, true)
+	if !ok {
+		return fmt.Sprintf("You have no %s at hand\n", name)
+	}
+	return itemLore(p, h)
+}
+
+// identify reads a scroll of identify over an item the player carries, and
+// reveals what it hides. Items identified before are shown in full for free.
+// Usage: identify <item>
+func (s *Server) identify(p *area.Player, args []string) string {
+	if len(args) == 0 {
+		return "Usage: identify <item>\n"
+	}
+	name := strings.Join(args, " ")
+	h, ok := s.itemInReach(p, name, false)
+	if !ok {
+		return fmt.Sprintf("You carry no %s\n", name)
+	}
+	if identified(p, h.Name) {
+		return itemLore(p, h)
+	}
+	if !p.HasItem(identifyScroll) {
+		return fmt.Sprintf("You need a %s, or a smith, to identify the %s\n", identifyScroll, h.Name)
+	}
+	p.RemoveItem(identifyScroll)
+	p.Identified = append(p.Identified, h.Name)
+	if !h.Hidden.Any() {
+		return fmt.Sprintf("The scroll crumbles to dust, and finds nothing hidden in the %s\n", h.Name)
+	}
+	return "The scroll crumbles to dust as it reveals what the item hides\n" + itemLore(p, h)
+}
+
+// itemLore shows the item in full, as far as the player knows it, along with
+// its description and the lore identifying it told.
+func itemLore(p *area.Player, h heldItem) string {
+	lines := []string{describeItem(p, h)}
+	if h.Description != "" {
+		lines = append(lines, h.Description)
+	}
+	if identified(p, h.Name) && h.Hidden.Lore != "" {
+		lines = append(lines, h.Hidden.Lore)
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// identifyGear is the other service of smith NPCs: they identify the items
+// the player carries and has on that hide something, as many as the player
+// can pay for.
+func identifyGear(s *Server, p *area.Player, npc *area.NPC) string {
+	price := s.config.Economy.Identify
+	found := []heldItem{}
+	for _, item := range append([]string{p.Weapon, p.Armor, p.OffHand}, p.Inventory...) {
+		h := s.held(p, item)
+		if item == "" || !h.Hidden.Any() || identified(p, h.Name) {
+			continue
+		}
+		if p.Gold < price {
+			if len(found) == 0 {
+				return fmt.Sprintf("%s asks for %d gold to identify the %s\n", npc.Name, price, h.Name)
+			}
+			break
+		}
+		p.Gold -= price
+		s.goldFlow(flowServices, -price)
+		p.Identified = append(p.Identified, h.Name)
+		found = append(found, h)
+	}
+	if len(found) == 0 {
+		return fmt.Sprintf("%s finds nothing hidden in what you carry\n", npc.Name)
+	}
+	names := []string{}
+	for _, h := range found {
+		names = append(names, h.Name)
+	}
+	lines := []string{fmt.Sprintf("%s identifies the %s for %d gold", npc.Name, strings.Join(names, ", the "), len(found)*price)}
+	for _, h := range found {
+		lines = append(lines, describeItem(p, h))
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
@@ -0,0 +1,373 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/droslean/thyranew/area"
+	"github.com/gothyra/toml"
+
+	log "gopkg.in/inconshreveable/log15.v2"
+)
+
+const (
+	// jobsFile keeps the jobs as admins changed them in the game, in the data
+	// directory. Once there, it stands in for the jobs of server.toml.
+	jobsFile = "jobs.toml"
+	// maxBackups is how many backups the backup jobs keep, the newest.
+	maxBackups = 7
+)
+
+// Actions the jobs do.
+const (
+	jobAnnounce    = "announce"    // Tell everyone the text
+	jobMaintenance = "maintenance" // Take the server down, letting the players go in minutes
+	jobBackup      = "backup"      // Back the server up into backups in the data directory
+	jobContent     = "content"     // Turn the content flag on or off
+)
+
+// Job is something the server does at the times its cron expression gives,
+// in the time zone of the server, like warning of the daily reboot, starting
+// the weekly event or backing up.
+type Job struct {
+	Name    string `toml:"name"`
+	Cron    string `toml:"cron"` // Like "55 3 * * *" or "@weekly"
+	Action  string `toml:"action"`
+	Text    string `toml:"text"`    // What is announced, or told the players maintenance turns away
+	Minutes int    `toml:"minutes"` // How long the players have before maintenance lets them go
+	Flag    string `toml:"flag"`    // Content flag turned on, or off
+	On      bool   `toml:"on"`
+	Paused  bool   `toml:"paused"`
+}
+
+// scheduledJob is a job, and when it runs next.
+type scheduledJob struct {
+	Job
+	spec cronSpec
+	next time.Time
+}
+
+// check returns when the job runs, or why it can't.
+func (j Job) check() (cronSpec, error) {
+	if j.Name == "" {
+		return cronSpec{}, fmt.Errorf("a job has no name")
+	}
+	spec, err := parseCron(j.Cron)
+	if err != nil {
+		return spec, fmt.Errorf("job %s: %v", j.Name, err)
+	}
+	switch j.Action {
+	case jobAnnounce:
+		if j.Text == "" {
+			return spec, fmt.Errorf("job %s announces no text", j.Name)
+		}
+	case jobContent:
+		if j.Flag == "" {
+			return spec, fmt.Errorf("job %s sets no content flag", j.Name)
+		}
+	case jobMaintenance, jobBackup:
+	default:
+		return spec, fmt.Errorf("job %s: unknown action %q, it is one of %s, %s, %s or %s", j.Name, j.Action, jobAnnounce, jobMaintenance, jobBackup, jobContent)
+	}
+	return spec, nil
+}
+
+// localNow returns the time in the time zone of the server.
+func (s *Server) localNow() time.Time {
+	if s.location == nil {
+		return s.now()
+	}
+	return s.now().In(s.location)
+}
+
+// loadJobs sets the time zone of the server and loads its jobs, those admins
+// changed in the game if they did, or else those of server.toml.
+func (s *Server) loadJobs() error {
+	s.location = time.Local
+	if s.config.Timezone != "" {
+		loc, err := time.LoadLocation(s.config.Timezone)
+		if err != nil {
+			return fmt.Errorf("unknown time zone %q: %v", s.config.Timezone, err)
+		}
+		s.location = loc
+	}
+
+	jobs := s.config.Jobs
+	path := filepath.Join(s.dataDir, jobsFile)
+	fileContent, err := ioutil.ReadFile(path)
+	switch {
+	case err == nil:
+		saved := struct {
+			Jobs []Job `toml:"jobs"`
+		}{}
+		if _, err := toml.Decode(string(fileContent), &saved); err != nil {
+			log.Info(fmt.Sprintf("%s could not be unmarshaled: %v", path, err))
+			return err
+		}
+		log.Info(fmt.Sprintf("The jobs of %s stand in for those of server.toml", path))
+		jobs = saved.Jobs
+	case !os.IsNotExist(err):
+		return err
+	}
+
+	s.jobs = nil
+	for _, j := range jobs {
+		spec, err := j.check()
+		if err != nil {
+			return err
+		}
+		if s.job(j.Name) != nil {
+			return fmt.Errorf("there are two jobs named %q", j.Name)
+		}
+		s.jobs = append(s.jobs, &scheduledJob{Job: j, spec: spec})
+		log.Info(fmt.Sprintf("Loaded job %q", j.Name))
+	}
+	return nil
+}
+
+// saveJobs writes the jobs down, as admins changed them.
+func (s *Server) saveJobs() error {
+	saved := struct {
+		Jobs []Job `toml:"jobs"`
+	}{Jobs: []Job{}}
+	for _, j := range s.jobs {
+		saved.Jobs = append(saved.Jobs, j.Job)
+	}
+	var buf bytes.Buffer
+	if err := toml.NewEncoder(&buf).Encode(saved); err != nil {
+		return err
+	}
+	return writeAtomic(filepath.Join(s.dataDir, jobsFile), buf.Bytes())
+}
+
+// job returns the job of the name, or nil if there is none.
+func (s *Server) job(name string) *scheduledJob {
+	for _, j := range s.jobs {
+		if strings.EqualFold(j.Name, name) {
+			return j
+		}
+	}
+	return nil
+}
+
+// runJobs runs the jobs whose time has come. It runs on every tick. Jobs
+// whose time came while the server was down wait for the next.
+func (s *Server) runJobs(roomsMap map[string]map[string][][]area.Cube) {
+	now := s.localNow()
+	for _, j := range s.jobs {
+		if j.next.IsZero() {
+			j.next = j.spec.next(now)
+			continue
+		}
+		if now.Before(j.next) {
+			continue
+		}
+		j.next = j.spec.next(now)
+		if !j.Paused && s.featureOn(featureJobs) {
+			s.runJob(roomsMap, j.Job, "schedule")
+		}
+	}
+}
+
+// runJob does what the job does.
+func (s *Server) runJob(roomsMap map[string]map[string][][]area.Cube, j Job, by string) {
+	log.Info(fmt.Sprintf("Running job %q, by %s", j.Name, by))
+	switch j.Action {
+	case jobAnnounce:
+		s.broadcast(roomsMap, Broadcast{Scope: ScopeGlobal, Kind: TagSystem, Text: j.Text + "\n"})
+		s.publish(WorldEvent{Type: EventAnnouncement, By: j.Name, Detail: j.Text})
+	case jobMaintenance:
+		if s.maintenance == nil {
+			s.startMaintenance("job "+j.Name, j.Minutes, j.Text)
+		}
+	case jobBackup:
+		s.backupNow(j.Name)
+	case jobContent:
+		s.flagOverrides[j.Flag] = j.On
+	}
+}
+
+// backupsDir returns where the backup jobs keep the backups.
+func (s *Server) backupsDir() string {
+	return filepath.Join(s.dataDir, "backups")
+}
+
+// backupNow backs the server up off the world, into a file of the backups
+// named after when it was made, and forgets the oldest past maxBackups.
+func (s *Server) backupNow(job string) {
+	dir := s.backupsDir()
+	name := filepath.Join(dir, "thyra-"+s.now().UTC().Format(versionStamp)+".tar.gz")
+	go func() {
+		err := func() error {
+			if err := os.MkdirAll(dir, 0755); err != nil {
+				return err
+			}
+			f, err := os.Create(name + ".tmp")
+			if err != nil {
+				return err
+			}
+			if err := Backup(f, s.db, s.staticDir, s.dataDir, dir); err != nil {
+				f.Close()
+				os.Remove(name + ".tmp")
+				return err
+			}
+			if err := f.Close(); err != nil {
+				return err
+			}
+			return os.Rename(name+".tmp", name)
+		}()
+		if err != nil {
+			log.Error(fmt.Sprintf("Job %q cannot back the server up: %v", job, err))
+			return
+		}
+		log.Info(fmt.Sprintf("Job %q backed the server up to %s", job, name))
+		backups, err := filepath.Glob(filepath.Join(dir, "thyra-*.tar.gz"))
+		if err != nil {
+			return
+		}
+		sort.Strings(backups)
+		for len(backups) > maxBackups {
+			if err := os.Remove(backups[0]); err != nil {
+				log.Error(fmt.Sprintf("Cannot remove the old backup %s: %v", backups[0], err))
+				return
+			}
+			backups = backups[1:]
+		}
+	}()
+}
+
+// listJobs tells admins the jobs, and when they run next.
+func (s *Server) listJobs() string {
+	if len(s.jobs) == 0 {
+		return "There are no jobs\n"
+	}
+	lines := []string{fmt.Sprintf("It is %s in %s", s.localNow().Format("2006-01-02 15:04 Mon"), s.location)}
+	for _, j := range s.jobs {
+		next := "never"
+		switch {
+		case j.Paused:
+			next = "paused"
+		case !j.next.IsZero():
+			next = "next " + j.next.Format("2006-01-02 15:04 Mon")
+		default:
+			if t := j.spec.next(s.localNow()); !t.IsZero() {
+				next = "next " + t.Format("2006-01-02 15:04 Mon")
+			}
+		}
+		what := j.Action
+		switch j.Action {
+		case jobAnnounce:
+			what += ": " + j.Text
+		case jobMaintenance:
+			what += fmt.Sprintf(" in %d minutes", j.Minutes)
+		case jobContent:
+			what += fmt.Sprintf(" %s %s", j.Flag, onOffOf(j.On))
+		}
+		lines = append(lines, fmt.Sprintf("%-16s %-16s %-26s %s", j.Name, j.Cron, next, what))
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// jobsCommand lists the jobs, or lets an admin add one, remove one, pause
+// or resume one, or run one now. What the job does follows its action:
+// announce takes the text, maintenance the minutes and the text, and content
+// the flag and on or off.
+// Usage: jobs [add <name> <cron> <action> [...]|remove|pause|resume|run <name>]
+func (s *Server) jobsCommand(roomsMap map[string]map[string][][]area.Cube, admin *area.Player, args []string) string {
+	usage := "Usage: jobs [add <name> <cron> <action> [...]|remove|pause|resume|run <name>]\n"
+	if len(args) == 0 {
+		return s.listJobs()
+	}
+	if len(args) < 2 {
+		return usage
+	}
+	op, name := strings.ToLower(args[0]), args[1]
+	if op == "add" {
+		return s.addJob(admin, name, args[2:])
+	}
+	j := s.job(name)
+	if j == nil {
+		return fmt.Sprintf("There is no job %q\n", name)
+	}
+	msg := ""
+	switch op {
+	case "run":
+		s.runJob(roomsMap, j.Job, admin.Nickname)
+		return fmt.Sprintf("Ran %s\n", j.Name)
+	case "remove":
+		for i := range s.jobs {
+			if s.jobs[i] == j {
+				s.jobs = append(s.jobs[:i], s.jobs[i+1:]...)
+				break
+			}
+		}
+		msg = fmt.Sprintf("Removed %s\n", j.Name)
+	case "pause":
+		j.Paused = true
+		msg = fmt.Sprintf("Paused %s\n", j.Name)
+	case "resume":
+		j.Paused = false
+		msg = fmt.Sprintf("Resumed %s\n", j.Name)
+	default:
+		return usage
+	}
+	log.Info(fmt.Sprintf("%q: %s", admin.Nickname, strings.TrimSpace(msg)))
+	return s.jobsSaved(msg)
+}
+
+// addJob adds the job the arguments give: the cron expression, as five
+// fields or one like @daily, the action and what it takes.
+func (s *Server) addJob(admin *area.Player, name string, args []string) string {
+	if s.job(name) != nil {
+		return fmt.Sprintf("There is a job %q already\n", name)
+	}
+	fields := 5
+	if len(args) > 0 && strings.HasPrefix(args[0], "@") {
+		fields = 1
+	}
+	if len(args) < fields+1 {
+		return "Usage: jobs add <name> <minute> <hour> <day> <month> <weekday> <action> [...]\n"
+	}
+	j := Job{Name: name, Cron: strings.Join(args[:fields], " "), Action: strings.ToLower(args[fields])}
+	rest := args[fields+1:]
+	switch j.Action {
+	case jobAnnounce:
+		j.Text = strings.Join(rest, " ")
+	case jobMaintenance:
+		j.Minutes = defaultCountdown
+		if len(rest) > 0 {
+			if n, err := strconv.Atoi(rest[0]); err == nil && n >= 0 {
+				j.Minutes, rest = n, rest[1:]
+			}
+		}
+		j.Text = strings.Join(rest, " ")
+	case jobContent:
+		if len(rest) != 2 || (rest[1] != "on" && rest[1] != "off") {
+			return "Usage: jobs add <name> <cron> content <flag> on|off\n"
+		}
+		j.Flag, j.On = rest[0], rest[1] == "on"
+	}
+	spec, err := j.check()
+	if err != nil {
+		return fmt.Sprintf("%v\n", err)
+	}
+	s.jobs = append(s.jobs, &scheduledJob{Job: j, spec: spec})
+	log.Info(fmt.Sprintf("%q added the job %q", admin.Nickname, j.Name))
+	return s.jobsSaved(fmt.Sprintf("Added %s\n", j.Name))
+}
+
+// jobsSaved saves the jobs, and returns the message, or why they weren't.
+func (s *Server) jobsSaved(msg string) string {
+	if err := s.saveJobs(); err != nil {
+		log.Error(fmt.Sprintf("Cannot save the jobs: %v", err))
+		return msg + "The jobs can't be saved, they are back as they were on a restart\n"
+	}
+	return msg
+}
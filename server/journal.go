@@ -0,0 +1,251 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/droslean/thyranew/area"
+	"github.com/gothyra/toml"
+
+	log "gopkg.in/inconshreveable/log15.v2"
+)
+
+// Kinds of the entries of a journal.
+const (
+	// JournalStart is the first entry, with the seed of the world and when
+	// it started.
+	JournalStart = "start"
+	// JournalTick is the world moving on by itself.
+	JournalTick = "tick"
+	// JournalCommand is a command of a player, or a key of a modal.
+	JournalCommand = "command"
+	// JournalJoin is a player getting in, with the player as saved then.
+	JournalJoin = "join"
+	// JournalLeave is a player losing their link.
+	JournalLeave = "leave"
+	// JournalEvent is a world event published on the bus. It is what came
+	// out of the world rather than what went in, and what a replay is
+	// checked against.
+	JournalEvent = "event"
+)
+
+// maxJournalLine is the longest entry a journal is read with, a player saved
+// whole being the longest there is.
+const maxJournalLine = 16 << 20
+
+// JournalEntry is a line of a journal, as JSON.
+type JournalEntry struct {
+	Kind    string      `json:"kind"`
+	Time    time.Time   `json:"time"`
+	Seed    int64       `json:"seed,omitempty"`
+	Player  string      `json:"player,omitempty"`
+	Command string      `json:"command,omitempty"`
+	Walk    bool        `json:"walk,omitempty"`
+	Key     string      `json:"key,omitempty"`
+	Save    string      `json:"save,omitempty"`    // The player joining, as saved in TOML
+	Created bool        `json:"created,omitempty"` // The player joining was made on the way in
+	Event   *WorldEvent `json:"event,omitempty"`
+}
+
+func (e JournalEntry) String() string {
+	switch e.Kind {
+	case JournalCommand:
+		if e.Key != "" {
+			return fmt.Sprintf("%s presses %s", e.Player, e.Key)
+		}
+		return fmt.Sprintf("%s: %s", e.Player, e.Command)
+	case JournalJoin:
+		return e.Player + " joins"
+	case JournalLeave:
+		return e.Player + " loses their link"
+	case JournalEvent:
+		return fmt.Sprintf("%s %+v", e.Event.Type, *e.Event)
+	case JournalStart:
+		return fmt.Sprintf("start with seed %d", e.Seed)
+	}
+	return e.Kind
+}
+
+// journal writes what goes into the world, in the order it goes in, so a
+// simulation can play it back: the ticks, the commands of the players, and
+// the players coming and going, with the world events that came of them.
+// The randomness of the world comes from the seed it starts with, and the
+// time of each entry moves the clock of the simulation. What the admin API
+// changes is left out, and so is what the tasks of the timers do, which the
+// replay does again on its own.
+type journal struct {
+	sync.Mutex
+	f      *os.File
+	enc    *json.Encoder
+	broken bool
+}
+
+// openJournal starts the journal of the world in the file, and seeds the
+// world from it.
+func (s *Server) openJournal(path string) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	seed := time.Now().UnixNano()
+	s.rnd, s.seed = rand.New(rand.NewSource(seed)), seed
+	s.journal = &journal{f: f, enc: json.NewEncoder(f)}
+	s.journal.write(JournalEntry{Kind: JournalStart, Time: s.now(), Seed: seed})
+	log.Info(fmt.Sprintf("The world is journaled to %s", path))
+	return nil
+}
+
+// write adds the entry to the journal, if there is one. A journal that
+// can't be written to is given up on, rather than left with a hole.
+func (j *journal) write(e JournalEntry) {
+	if j == nil {
+		return
+	}
+	j.Lock()
+	defer j.Unlock()
+	if j.broken {
+		return
+	}
+	if err := j.enc.Encode(e); err != nil {
+		log.Error(fmt.Sprintf("Cannot write the journal, it stops here: %v", err))
+		j.broken = true
+		j.f.Close()
+	}
+}
+
+// journalJoin writes the player getting in. Those back from losing their
+// link are still in the world, and are written without their save.
+func (s *Server) journalJoin(c *Client, back bool) {
+	if s.journal == nil {
+		return
+	}
+	e := JournalEntry{Kind: JournalJoin, Time: s.now(), Player: c.Name, Created: c.created}
+	if !back {
+		var buf bytes.Buffer
+		if err := toml.NewEncoder(&buf).Encode(c.Player); err != nil {
+			log.Error(fmt.Sprintf("Cannot journal %q: %v", c.Name, err))
+		}
+		e.Save = buf.String()
+	}
+	s.journal.write(e)
+}
+
+// ReadJournal reads the entries of a journal.
+func ReadJournal(r io.Reader) ([]JournalEntry, error) {
+	entries := []JournalEntry{}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), maxJournalLine)
+	for n := 1; scanner.Scan(); n++ {
+		e := JournalEntry{}
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			return nil, fmt.Errorf("line %d: %v", n, err)
+		}
+		if e.Kind == JournalEvent && e.Event == nil {
+			return nil, fmt.Errorf("line %d: an event without the event", n)
+		}
+		entries = append(entries, e)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(entries) == 0 || entries[0].Kind != JournalStart {
+		return nil, fmt.Errorf("the journal does not begin with its start")
+	}
+	return entries, nil
+}
+
+// replayed are the world events a replay published, not yet taken. Owned by
+// the goroutine of the world.
+type replayed struct {
+	events []WorldEvent
+}
+
+// NewReplayHarness starts a simulation harness to play a journal back
+// against, from the seed and the time of its start. The fake players of a
+// replay type nothing: the world takes their commands from Replay alone.
+func NewReplayHarness(start JournalEntry) (*Harness, error) {
+	return newHarness(&Simulation{Seed: start.Seed, Start: start.Time, replay: true})
+}
+
+// Replay plays the entry of the journal against the world the way it went
+// in when it was written, and returns the world events published meanwhile,
+// the tasks of the timers due by its time included. Events and the start
+// are not played. A command that panics is an error, with the world going
+// on without the player, the way it did.
+func (h *Harness) Replay(e JournalEntry) ([]WorldEvent, error) {
+	s, w := h.Server, h.Server.world
+	var err error
+	switch e.Kind {
+	case JournalStart, JournalEvent:
+		return nil, nil
+	case JournalJoin:
+		if !s.inWorld(h.ctx, func() { w.catchUp(e.Time) }) {
+			return nil, fmt.Errorf("the replay is stopped")
+		}
+		if err := h.restore(e); err != nil {
+			return nil, err
+		}
+		p, err := h.Connect(e.Player, 160, 40)
+		if err != nil {
+			return nil, err
+		}
+		h.replayers[e.Player] = p
+	case JournalTick, JournalCommand, JournalLeave:
+		if !s.inWorld(h.ctx, func() {
+			w.catchUp(e.Time)
+			if e.Kind == JournalTick {
+				w.tick(w.rooms)
+				return
+			}
+			c, ok := s.clientByNick(e.Player)
+			if !ok {
+				err = fmt.Errorf("%s is not online", e.Player)
+				return
+			}
+			if e.Kind == JournalLeave {
+				s.linkdead(c)
+				return
+			}
+			panics := atomic.LoadUint64(&w.panics)
+			w.dispatch(w.rooms, Event{Client: c, EventType: e.Command, walk: e.Walk, key: e.Key})
+			if atomic.LoadUint64(&w.panics) != panics {
+				err = fmt.Errorf("%s panicked", e)
+			}
+		}) {
+			return nil, fmt.Errorf("the replay is stopped")
+		}
+	default:
+		return nil, fmt.Errorf("%q is not an entry of a journal", e.Kind)
+	}
+
+	// What the players see is of no use to a replay, and would only pile up.
+	for _, p := range h.replayers {
+		p.pipe.discard()
+	}
+	var events []WorldEvent
+	s.inWorld(h.ctx, func() {
+		events, s.replay.events = s.replay.events, nil
+	})
+	return events, err
+}
+
+// restore saves the player joining the way they were when they joined, for
+// the harness to load them. Those made on the way in are made again.
+func (h *Harness) restore(e JournalEntry) error {
+	if e.Save == "" || e.Created {
+		return nil
+	}
+	player := area.Player{}
+	if _, err := toml.Decode(e.Save, &player); err != nil {
+		return fmt.Errorf("%s cannot be restored: %v", e.Player, err)
+	}
+	return h.Server.savePlayer(&player)
+}
@@ -0,0 +1,239 @@
+package server
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/droslean/thyranew/area"
+	"github.com/droslean/thyranew/game"
+
+	log "gopkg.in/inconshreveable/log15.v2"
+)
+
+// crimesIn returns the crimes of the player the law of the area holds
+// against them.
+func (s *Server) crimesIn(p *area.Player, areaName string) []area.Crime {
+	law := s.Areas[areaName].Law
+	crimes := []area.Crime{}
+	for _, c := range p.Crimes {
+		if c.Area == areaName && law.Remembers(c, s.now()) {
+			crimes = append(crimes, c)
+		}
+	}
+	return crimes
+}
+
+// clearCrimes wipes the record of the player in the area, along with the
+// crimes nobody remembers anymore.
+func (s *Server) clearCrimes(p *area.Player, areaName string) {
+	kept := []area.Crime{}
+	for _, c := range p.Crimes {
+		if c.Area != areaName && s.Areas[c.Area].Law.Remembers(c, s.now()) {
+			kept = append(kept, c)
+		}
+	}
+	p.Crimes = kept
+}
+
+// wantedBy reports whether the guard keeps the law the player broke.
+func (s *Server) wantedBy(p *area.Player, npc area.NPC) bool {
+	law := s.Areas[p.Area].Law
+	return npc.Guard && law.Faction != "" && npc.Faction == law.Faction && len(s.crimesIn(p, p.Area)) > 0
+}
+
+// witnessed reports whether an NPC in the room, other than the one given,
+// sees what the player does. Sleeping NPCs see nothing, and hidden players
+// are seen only by those who notice them.
+func (s *Server) witnessed(p *area.Player, except int) bool {
+	for _, npc := range s.npcsInRoom(p.Area, p.Room) {
+		if npc.ID == except || npc.Activity == "sleeping" {
+			continue
+		}
+		if p.HideRoll == 0 || game.NPCPerception(npc.Level) >= p.HideRoll {
+			return true
+		}
+	}
+	return false
+}
+
+// reportCrime puts the crime on the record of the player, when the area has
+// a law, and calls its guards. In lawless areas the guards of the faction
+// wronged, if any, come instead. It returns how many guards were called.
+func (s *Server) reportCrime(roomsMap map[string]map[string][][]area.Cube, p *area.Player, kind, wronged string) int {
+	law := s.Areas[p.Area].Law
+	if law.Faction == "" {
+		return s.callGuards(roomsMap, p, wronged)
+	}
+	p.Crimes = append(p.Crimes, area.Crime{Area: p.Area, Kind: kind, Time: s.now()})
+	if err := s.savePlayer(p); err != nil {
+		log.Error(fmt.Sprintf("Cannot save player %q: %v", p.Nickname, err))
+	}
+	s.tellPlayer(roomsMap, p.Nickname, fmt.Sprintf("You are wanted in %s for %s\n", p.Area, kind))
+	return s.callGuards(roomsMap, p, law.Faction)
+}
+
+// callGuards sends the guards of the faction in the area of the player after
+// them. Guards busy with someone else stay where they are.
+func (s *Server) callGuards(roomsMap map[string]map[string][][]area.Cube, p *area.Player, faction string) int {
+	if faction == "" {
+		return 0
+	}
+	called := 0
+	for _, g := range s.Areas[p.Area].NPCs {
+		if !g.Guard || g.Target != "" || g.Faction != faction {
+			continue
+		}
+		guard, _ := s.findNPC(g.ID)
+		s.provoke(roomsMap, guard, p.Nickname)
+		called++
+	}
+	return called
+}
+
+// arrest takes the wanted player to jail for their crimes in the area,
+// which wipes their record there.
+func (s *Server) arrest(roomsMap map[string]map[string][][]area.Cube, guard *area.NPC, p *area.Player) {
+	areaName := p.Area
+	law := s.Areas[areaName].Law
+	crimes := s.crimesIn(p, areaName)
+
+	b := Broadcast{Scope: ScopeRoom, Area: p.Area, Room: p.Room, Text: "$n {arrest|arrests} $N in the name of the law\n"}
+	s.act(roomsMap, b, npcActor(guard), playerActor(p))
+	s.clearCrimes(p, areaName)
+	s.standDown(roomsMap, p)
+
+	reason := fmt.Sprintf("%s in %s", crimeList(crimes), areaName)
+	if err := s.jailPlayer(roomsMap, p.Nickname, guard.Name, s.until(law.Sentence(len(crimes))), reason); err != nil {
+		log.Error(fmt.Sprintf("Cannot jail %q: %v", p.Nickname, err))
+	}
+}
+
+// standDown calls off the guards of the law after the player, who is no
+// longer wanted.
+func (s *Server) standDown(roomsMap map[string]map[string][][]area.Cube, p *area.Player) {
+	faction := s.Areas[p.Area].Law.Faction
+	for _, npc := range s.Areas[p.Area].NPCs {
+		if npc.Guard && npc.Faction == faction {
+			s.forgetThreat(npc.ID, p.Nickname)
+			if npc.Target == p.Nickname {
+				guard, _ := s.findNPC(npc.ID)
+				guard.Target = ""
+			}
+		}
+	}
+	s.endFight(roomsMap, p.Nickname)
+}
+
+// arrests reports whether the guard arrests the player instead of fighting.
+func (s *Server) arrests(guard area.NPC, p *area.Player) bool {
+	return s.Areas[p.Area].Law.Arrest && s.wantedBy(p, guard)
+}
+
+// crimeList names the crimes, like "theft, theft, murder".
+func crimeList(crimes []area.Crime) string {
+	kinds := []string{}
+	for _, c := range crimes {
+		kinds = append(kinds, c.Kind)
+	}
+	return strings.Join(kinds, ", ")
+}
+
+// fine returns what the crimes cost in gold, and whether they can all be
+// paid off.
+func fine(law area.Law, crimes []area.Crime) (int, bool) {
+	total := 0
+	for _, c := range crimes {
+		gold, ok := law.Fines[c.Kind]
+		if !ok {
+			return 0, false
+		}
+		total += gold
+	}
+	return total, true
+}
+
+// wanted shows the record of the player and who is wanted where they are,
+// or pays off their crimes in the area to a guard in the room.
+// Usage: wanted [pay]
+func (s *Server) wanted(roomsMap map[string]map[string][][]area.Cube, p *area.Player, args []string) string {
+	if len(args) > 0 {
+		if !strings.EqualFold(args[0], "pay") {
+			return "Usage: wanted [pay]\n"
+		}
+		return s.payFine(roomsMap, p)
+	}
+
+	seen := map[string]bool{}
+	areas := []string{}
+	for _, c := range p.Crimes {
+		if !seen[c.Area] {
+			seen[c.Area] = true
+			areas = append(areas, c.Area)
+		}
+	}
+	sort.Strings(areas)
+	lines := []string{}
+	for _, name := range areas {
+		crimes := s.crimesIn(p, name)
+		if len(crimes) == 0 {
+			continue
+		}
+		line := fmt.Sprintf("%s: %s", name, crimeList(crimes))
+		if gold, ok := fine(s.Areas[name].Law, crimes); ok {
+			line += fmt.Sprintf(", a fine of %d gold", gold)
+		} else {
+			line += ", for jail alone"
+		}
+		lines = append(lines, line)
+	}
+	if len(lines) == 0 {
+		lines = append(lines, "Your record is clean")
+	}
+
+	if s.Areas[p.Area].Law.Faction != "" {
+		names := []string{}
+		for _, c := range s.OnlineClients() {
+			if c.Player.Area == p.Area && c.Player.Nickname != p.Nickname && len(s.crimesIn(c.Player, p.Area)) > 0 {
+				names = append(names, c.Player.Nickname)
+			}
+		}
+		sort.Strings(names)
+		if len(names) > 0 {
+			lines = append(lines, fmt.Sprintf("Wanted in %s: %s", p.Area, strings.Join(names, ", ")))
+		}
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// payFine pays off the crimes of the player in the area to a guard of its
+// law in the room, which wipes their record there.
+func (s *Server) payFine(roomsMap map[string]map[string][][]area.Cube, p *area.Player) string {
+	law := s.Areas[p.Area].Law
+	crimes := s.crimesIn(p, p.Area)
+	if len(crimes) == 0 {
+		return "You owe nothing here\n"
+	}
+	var guard *area.NPC
+	for _, npc := range s.npcsInRoom(p.Area, p.Room) {
+		if s.wantedBy(p, npc) {
+			guard, _ = s.findNPC(npc.ID)
+			break
+		}
+	}
+	if guard == nil {
+		return "There is no guard here to pay\n"
+	}
+	gold, ok := fine(law, crimes)
+	if !ok {
+		return "Your crimes here can't be paid off, only served in jail\n"
+	}
+	if p.Gold < gold {
+		return fmt.Sprintf("Your fine is %d gold, and you have %d\n", gold, p.Gold)
+	}
+	p.Gold -= gold
+	s.goldFlow(flowFines, -gold)
+	s.clearCrimes(p, p.Area)
+	s.standDown(roomsMap, p)
+	return s.savePreferences(p, fmt.Sprintf("You pay %s a fine of %d gold, and your record here is clean\n", guard.Name, gold))
+}
@@ -0,0 +1,79 @@
+package server
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/droslean/thyranew/area"
+)
+
+// collectKey puts a key of the named prototype on the keyring of the player,
+// cut for the area they are in. Keys just like one they have are left.
+func (s *Server) collectKey(p *area.Player, name string) {
+	key := area.Key{Item: name, Area: p.Area, Keying: s.Areas[p.Area].Keying}
+	for _, k := range p.Keyring {
+		if k == key {
+			return
+		}
+	}
+	p.Keyring = append(p.Keyring, key)
+}
+
+// keyFor returns a key on the keyring of the player that fits the lock in the
+// area they are in.
+func (s *Server) keyFor(p *area.Player, lock string) (area.Key, bool) {
+	for _, k := range p.Keyring {
+		if strings.EqualFold(s.itemDef(k.Item).Opens, lock) && k.Keying == s.Areas[p.Area].Keying {
+			return k, true
+		}
+	}
+	return area.Key{}, false
+}
+
+// expireKeys takes the keys of the areas that were reset since they were cut
+// off the keyring of the player, and tells them which.
+func (s *Server) expireKeys(p *area.Player) string {
+	kept, msg := []area.Key{}, ""
+	for _, k := range p.Keyring {
+		if s.Areas[k.Area].Keying != k.Keying {
+			msg += fmt.Sprintf("Your %s no longer fits\n", k.Item)
+			continue
+		}
+		kept = append(kept, k)
+	}
+	p.Keyring = kept
+	return msg
+}
+
+// rekeyed tells the players online whose keys the reset of the area made
+// useless, and takes those keys off their keyrings.
+func (s *Server) rekeyed(roomsMap map[string]map[string][][]area.Cube, areaName string) {
+	for _, c := range s.OnlineClients() {
+		for _, k := range c.Player.Keyring {
+			if k.Area == areaName {
+				if msg := s.expireKeys(c.Player); msg != "" {
+					s.tellPlayer(roomsMap, c.Player.Nickname, msg)
+				}
+				break
+			}
+		}
+	}
+}
+
+// keys lists the keys on the keyring of the player, and the locks they open.
+// Usage: keys
+func (s *Server) keys(p *area.Player) string {
+	msg := s.expireKeys(p)
+	if len(p.Keyring) == 0 {
+		return msg + "Your keyring is empty\n"
+	}
+	lines := []string{}
+	for _, k := range p.Keyring {
+		line := k.Item
+		if lock := s.itemDef(k.Item).Opens; lock != "" {
+			line = fmt.Sprintf("%s, for the %s", k.Item, lock)
+		}
+		lines = append(lines, fmt.Sprintf("  %s in %s", line, k.Area))
+	}
+	return msg + "Your keyring holds:\n" + strings.Join(lines, "\n") + "\n"
+}
@@ -0,0 +1,140 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/droslean/thyranew/area"
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	// pingInterval is how often the round trip to each client is measured.
+	pingInterval = 15 * time.Second
+	// pingRequest names the keepalive the round trip is timed with. Clients
+	// turn down requests they don't know, and turning it down is as good an
+	// answer as any.
+	pingRequest = "keepalive@thyra"
+	// maxFrameInterval is the longest the screen of a player waits between
+	// redraws, however slow their link.
+	maxFrameInterval = time.Second
+)
+
+// latency is the round trip to a client, as its keepalives measure it.
+type latency struct {
+	sync.Mutex
+	last    time.Duration // The latest round trip
+	average time.Duration // Weighted towards the latest, so a spike fades
+	samples int
+}
+
+// record adds a round trip measured.
+func (l *latency) record(rtt time.Duration) {
+	l.Lock()
+	defer l.Unlock()
+	l.last = rtt
+	if l.samples == 0 {
+		l.average = rtt
+	} else {
+		l.average += (rtt - l.average) / 4
+	}
+	l.samples++
+}
+
+// get returns the latest round trip and the average, and false until one is
+// measured.
+func (l *latency) get() (time.Duration, time.Duration, bool) {
+	if l == nil {
+		return 0, 0, false
+	}
+	l.Lock()
+	defer l.Unlock()
+	return l.last, l.average, l.samples > 0
+}
+
+// measureLatency times a keepalive to the client every pingInterval, until
+// the connection is gone. There is no telnet to speak GMCP over, so the
+// keepalives of SSH stand in for its ping.
+func measureLatency(ctx context.Context, conn ssh.Conn, l *latency) {
+	ticker := time.NewTicker(pingInterval)
+	defer ticker.Stop()
+	for {
+		start := time.Now()
+		if _, _, err := conn.SendRequest(pingRequest, true, nil); err != nil {
+			return
+		}
+		l.record(time.Since(start))
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// pingOf returns the round trip to the player, and false until it is known.
+func (s *Server) pingOf(nick string) (time.Duration, bool) {
+	c, ok := s.clientByNick(nick)
+	if !ok {
+		return 0, false
+	}
+	_, average, ok := c.ping.get()
+	return average, ok
+}
+
+// millis writes the duration in milliseconds.
+func millis(d time.Duration) string {
+	return fmt.Sprintf("%dms", d.Round(time.Millisecond)/time.Millisecond)
+}
+
+func pingWidget(s *Server, p *area.Player) (string, int, bool) {
+	rtt, ok := s.pingOf(p.Nickname)
+	if !ok {
+		return "", 0, false
+	}
+	text := "Ping " + millis(rtt)
+	return text, len(text), true
+}
+
+// who lists the players online, those hiding from the player left out.
+// Admins see where they are, how they connect and their round trip too.
+// Usage: who [-admin]
+func (s *Server) who(p *area.Player, args []string) string {
+	admin := len(args) == 1 && args[0] == "-admin"
+	switch {
+	case admin && !isAdmin(p):
+		return "Usage: who\n"
+	case len(args) > 0 && !admin:
+		return "Usage: who [-admin]\n"
+	}
+	online := s.OnlineClients()
+	lines := []string{}
+	for _, c := range online {
+		if !admin && !canSee(p, c.Player) {
+			continue
+		}
+		if !admin {
+			lines = append(lines, fmt.Sprintf("%-16s level %d", c.Player.Nickname, c.Player.Level))
+			continue
+		}
+		ping := "-"
+		if last, average, ok := c.ping.get(); ok {
+			ping = fmt.Sprintf("%s (last %s)", millis(average), millis(last))
+		}
+		lines = append(lines, fmt.Sprintf("%-16s %-10s %-16s %-20s %s", c.Player.Nickname, c.state(), c.ip, c.Player.Area+"/"+c.Player.Room, ping))
+	}
+	header := fmt.Sprintf("%d players online\n", len(lines))
+	if len(lines) == 1 {
+		header = "1 player online\n"
+	}
+	if admin {
+		header += fmt.Sprintf("%-16s %-10s %-16s %-20s %s\n", "Player", "State", "Address", "Where", "Ping")
+	}
+	if len(lines) == 0 {
+		return header
+	}
+	return header + strings.Join(lines, "\n") + "\n"
+}
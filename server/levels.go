@@ -0,0 +1,82 @@
+package server
+
+import (
+	"fmt"
+
+	"github.com/droslean/thyranew/area"
+	"github.com/droslean/thyranew/game"
+)
+
+// LevelConfig holds the rules that go by the level of the players. Zero
+// turns each of them off.
+type LevelConfig struct {
+	// Newbie is the level below which players can't fight or rob other
+	// players, nor be fought or robbed by them.
+	Newbie int `toml:"newbie"`
+	// Gates is what happens to players who go into areas outside the levels
+	// they are for: "block", the default, keeps them out, and "warn" lets
+	// them in with a warning.
+	Gates string `toml:"gates"`
+	// Bonus is by how many percent more experience a kill is worth for each
+	// level it is above the killer, up to MaxBonus percent. Penalty is how
+	// many percent less it is worth for each level below, and from Gap levels
+	// below on it is worth nothing.
+	Bonus    int `toml:"bonus"`
+	MaxBonus int `toml:"maxbonus"`
+	Penalty  int `toml:"penalty"`
+	Gap      int `toml:"gap"`
+}
+
+// gatesWarn is the rule for level gates that only warn.
+const gatesWarn = "warn"
+
+// killXP returns the experience the player earns for a kill of the level.
+func (s *Server) killXP(p *area.Player, level int) int {
+	rules := s.config.Levels
+	xp := game.ScaleXP(game.KillXP(level), level-p.Level, rules.Bonus, rules.MaxBonus, rules.Penalty, rules.Gap)
+	return xp * s.xpMultiplier()
+}
+
+// levelRange tells the levels the area is for, or an empty string when it
+// is for anyone.
+func levelRange(a area.Area) string {
+	switch {
+	case a.MinLevel > 0 && a.MaxLevel > 0:
+		return fmt.Sprintf("levels %d to %d", a.MinLevel, a.MaxLevel)
+	case a.MinLevel > 0:
+		return fmt.Sprintf("level %d and up", a.MinLevel)
+	case a.MaxLevel > 0:
+		return fmt.Sprintf("levels up to %d", a.MaxLevel)
+	}
+	return ""
+}
+
+// forLevel reports whether the area is for players of the level.
+func forLevel(a area.Area, level int) bool {
+	return (a.MinLevel == 0 || level >= a.MinLevel) && (a.MaxLevel == 0 || level <= a.MaxLevel)
+}
+
+// levelGate tells why the player can't go into the area, or an empty string
+// when they may. Players may always go on within the area they are in, and
+// where gates only warn.
+func (s *Server) levelGate(p *area.Player, areaName string) string {
+	a := s.Areas[areaName]
+	if areaName == p.Area || forLevel(a, p.Level) || s.config.Levels.Gates == gatesWarn || isAdmin(p) {
+		return ""
+	}
+	return fmt.Sprintf("%s is for %s, and you are level %d\n", a.Name, levelRange(a), p.Level)
+}
+
+// levelWarning warns the player who just came into an area that is not for
+// their level.
+func (s *Server) levelWarning(roomsMap map[string]map[string][][]area.Cube, p *area.Player) {
+	a := s.Areas[p.Area]
+	if p.Area == p.PreviousArea || forLevel(a, p.Level) {
+		return
+	}
+	msg := fmt.Sprintf("Beware, this area is for %s\n", levelRange(a))
+	if a.MaxLevel > 0 && p.Level > a.MaxLevel {
+		msg = fmt.Sprintf("This area is for %s, and there is little left to learn here\n", levelRange(a))
+	}
+	s.tellPlayer(roomsMap, p.Nickname, tagged(TagSystem, msg))
+}
@@ -0,0 +1,206 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	log "gopkg.in/inconshreveable/log15.v2"
+)
+
+// linkdeadTimeout is how long players who lost their link stay in the world,
+// waiting for them to connect again.
+const linkdeadTimeout = 2 * time.Minute
+
+// State is where a client is in its lifecycle.
+type State int
+
+// States of a client, in the order it goes through them.
+const (
+	StateConnecting     State = iota // The SSH handshake is under way
+	StateAuthenticating              // The key of the player is being checked
+	StateCreating                    // A new player is being made
+	StatePlaying
+	StateLinkdead // The connection dropped, the player is still in the world
+	StateQuitting
+)
+
+var stateNames = map[State]string{
+	StateConnecting:     "connecting",
+	StateAuthenticating: "authenticating",
+	StateCreating:       "creating",
+	StatePlaying:        "playing",
+	StateLinkdead:       "linkdead",
+	StateQuitting:       "quitting",
+}
+
+func (st State) String() string {
+	if name, ok := stateNames[st]; ok {
+		return name
+	}
+	return fmt.Sprintf("state %d", int(st))
+}
+
+// transitions are the changes of state a client can go through. A client can
+// quit from anywhere, and nothing comes after quitting.
+var transitions = map[State][]State{
+	StateConnecting:     {StateAuthenticating, StateQuitting},
+	StateAuthenticating: {StateCreating, StatePlaying, StateQuitting},
+	StateCreating:       {StatePlaying, StateQuitting},
+	StatePlaying:        {StateLinkdead, StateQuitting},
+	StateLinkdead:       {StatePlaying, StateQuitting},
+}
+
+// lifecycle keeps the state of a client. The connection and the world both
+// move it along, so it has a lock of its own.
+type lifecycle struct {
+	mu    sync.Mutex
+	state State
+	since time.Time
+}
+
+func newLifecycle() *lifecycle {
+	return &lifecycle{state: StateConnecting, since: time.Now()}
+}
+
+// get returns the state and since when the client is in it.
+func (l *lifecycle) get() (State, time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.state, l.since
+}
+
+// set moves to the given state, and returns the one it left.
+func (l *lifecycle) set(to State) (State, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, next := range transitions[l.state] {
+		if next == to {
+			from := l.state
+			l.state, l.since = to, time.Now()
+			return from, nil
+		}
+	}
+	return l.state, fmt.Errorf("cannot go from %s to %s", l.state, to)
+}
+
+// state returns the state of the client.
+func (c *Client) state() State {
+	st, _ := c.life.get()
+	return st
+}
+
+// setState moves the client to the given state and publishes the change. It
+// runs in the world.
+func (s *Server) setState(c *Client, to State) error {
+	from, err := c.life.set(to)
+	if err != nil {
+		log.Warn(fmt.Sprintf("Client %q: %v", c.Name, err))
+		return err
+	}
+	s.publish(WorldEvent{Type: EventPlayerState, Player: c.Name, From: from, To: to})
+	return nil
+}
+
+// connState moves a client that isn't in the world yet to the given state. The
+// change is published by the world, in the order the connection made it.
+func (s *Server) connState(ctx context.Context, life *lifecycle, nick string, to State) error {
+	from, err := life.set(to)
+	if err != nil {
+		log.Warn(fmt.Sprintf("Client %q: %v", nick, err))
+		return err
+	}
+	e := WorldEvent{Type: EventPlayerState, Player: nick, From: from, To: to}
+	select {
+	case s.worldTasks <- func() { s.publish(e) }:
+	case <-ctx.Done():
+	}
+	return nil
+}
+
+// enter lets the client into the world. A player who lost their link is taken
+// over by the new connection, right where they were.
+func (s *Server) enter(c *Client) {
+	// The connection may be gone before the world got to it.
+	if c.ctx.Err() != nil {
+		return
+	}
+	if old, ok := s.clientByNick(c.Name); ok && old.state() == StateLinkdead {
+		s.journalJoin(c, true)
+		c.Player = old.Player
+		stopRecording(old)
+		s.setState(old, StateQuitting)
+		log.Info(fmt.Sprintf("%q is back", c.Name))
+		// The new terminal has yet to hear about the mouse.
+		if f, ok := s.frames[c.Name]; ok {
+			f.mouse = false
+		}
+		if len(s.modals[c.Name]) > 0 {
+			atomic.StoreInt32(&c.modalOpen, 1)
+		}
+	} else {
+		s.journalJoin(c, false)
+		s.placeLost(c.Player)
+		// Those back from losing their link were never away.
+		s.restAway(c.Player)
+		c.Player.LoggedIn = s.now()
+		if wear := c.Player.Wear; wear > 0 {
+			c.Player.Wear = 0
+			wearGear(c.Player, wear)
+		}
+	}
+	s.detectTerminal(c)
+	s.clientLoggedIn(c)
+	s.setState(c, StatePlaying)
+	if c.created {
+		s.startCreation(c)
+	}
+}
+
+// linkdead keeps the player of a dropped connection in the world for a while,
+// so they can connect again. Clients that quit are already gone.
+func (s *Server) linkdead(c *Client) {
+	if cur, ok := s.clientByNick(c.Name); !ok || cur != c || c.state() != StatePlaying {
+		return
+	}
+	s.journal.write(JournalEntry{Kind: JournalLeave, Time: s.now(), Player: c.Name})
+	if err := s.setState(c, StateLinkdead); err != nil {
+		return
+	}
+	log.Info(fmt.Sprintf("%q lost their link", c.Name))
+	s.after(linkdeadTimeout, func() {
+		if c.state() == StateLinkdead {
+			s.disconnect(c)
+		}
+	})
+}
+
+// kick drops the player, linkdead or not, for good.
+// Usage: kick <player>
+func (s *Server) kick(args []string) string {
+	if len(args) != 1 {
+		return "Usage: kick <player>\n"
+	}
+	cl, ok := s.clientByNick(args[0])
+	if !ok {
+		return fmt.Sprintf("%s is not online\n", args[0])
+	}
+	if cl.state() == StatePlaying {
+		cl.writeString("You have been kicked out\r\n")
+	}
+	s.disconnect(cl)
+	return fmt.Sprintf("%s is kicked out\n", args[0])
+}
+
+// onPlayerState stops what players were doing once they lose their link.
+func (s *Server) onPlayerState(e WorldEvent) {
+	if e.To != StateLinkdead {
+		return
+	}
+	delete(s.running, e.Player)
+	if cl, ok := s.clientByNick(e.Player); ok {
+		cl.Player.Watching = ""
+	}
+}
@@ -0,0 +1,437 @@
+package server
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/droslean/thyranew/area"
+
+	log "gopkg.in/inconshreveable/log15.v2"
+)
+
+const (
+	// linkCodeLife is how long the codes players link their accounts with
+	// last, and how long they have to log in at the provider.
+	linkCodeLife = 10 * time.Minute
+	// linkCodeChars are what the codes are made of, without the characters
+	// easily taken for others.
+	linkCodeChars = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+	// linkCookie ties a login at a provider to the browser it was set out
+	// on, so nobody can have someone else finish a login they started.
+	linkCookie = "thyra_link"
+)
+
+// errLinkedElsewhere is returned when linking an identity that is linked to
+// another player already.
+var errLinkedElsewhere = errors.New("linked to another player")
+
+// linkPage asks the player to confirm the character before they log in at
+// the provider, so a link someone else sent them can't take their account.
+var linkPage = template.Must(template.New("link").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Link your {{.Title}} account</title></head>
+<body>
+<p>Link your {{.Title}} account to the character <strong>{{.Player}}</strong>?</p>
+<p>Only go on if you typed link in the game as {{.Player}} yourself. Whoever
+plays {{.Player}} will be known by your {{.Title}} account.</p>
+<form method="post"><input type="hidden" name="code" value="{{.Code}}"><button>Link to {{.Player}}</button></form>
+</body>
+</html>
+`))
+
+// OAuthConfig holds how players link their characters to their accounts at
+// outside providers, so the web pages and the bots of the community know who
+// they are without their game credentials.
+type OAuthConfig struct {
+	// URL is where the web pages are reached from outside. The providers
+	// send players back there once they logged in.
+	URL string `toml:"url"`
+	// Providers are the apps of the server at each provider, by the name of
+	// the provider: "github" or "discord".
+	Providers map[string]OAuthApp `toml:"providers"`
+}
+
+// OAuthApp is the app of the server at a provider.
+type OAuthApp struct {
+	ClientID string `toml:"clientid"`
+	Secret   string `toml:"secret"`
+}
+
+// oauthProvider is where a provider logs players in and tells who they are.
+type oauthProvider struct {
+	title string
+	auth  string // Where players log in
+	token string // Where the code they come back with is traded for a token
+	user  string // Where the token tells who they are
+	scope string
+	// identity reads the ID and the name of the player from what user
+	// returns.
+	identity func(body []byte) (id, name string, err error)
+}
+
+// oauthProviders are the providers accounts can be linked at, by name.
+var oauthProviders = map[string]oauthProvider{
+	"github": {
+		title: "GitHub",
+		auth:  "https://github.com/login/oauth/authorize",
+		token: "https://github.com/login/oauth/access_token",
+		user:  "https://api.github.com/user",
+		scope: "read:user",
+		identity: func(body []byte) (string, string, error) {
+			user := struct {
+				ID    json.Number `json:"id"`
+				Login string      `json:"login"`
+			}{}
+			err := json.Unmarshal(body, &user)
+			return user.ID.String(), user.Login, err
+		},
+	},
+	"discord": {
+		title: "Discord",
+		auth:  "https://discord.com/oauth2/authorize",
+		token: "https://discord.com/api/oauth2/token",
+		user:  "https://discord.com/api/users/@me",
+		scope: "identify",
+		identity: func(body []byte) (string, string, error) {
+			user := struct {
+				ID       string `json:"id"`
+				Username string `json:"username"`
+			}{}
+			err := json.Unmarshal(body, &user)
+			return user.ID, user.Username, err
+		},
+	},
+}
+
+// oauthClient talks to the providers.
+var oauthClient = &http.Client{Timeout: 10 * time.Second}
+
+// linkCode is a player on the way to linking their account at a provider.
+type linkCode struct {
+	Player   string
+	Provider string
+	Expires  time.Time
+}
+
+// linkProviders returns the names of the providers the server has an app at,
+// in order.
+func (s *Server) linkProviders() []string {
+	names := []string{}
+	if s.config.Web == "" || s.config.OAuth.URL == "" {
+		return names
+	}
+	for name := range s.config.OAuth.Providers {
+		if _, ok := oauthProviders[name]; ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// randomCode returns a code of the given length made of the given characters,
+// of which there are to be a power of two.
+func randomCode(chars string, length int) (string, error) {
+	b := make([]byte, length)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	for i := range b {
+		b[i] = chars[int(b[i])%len(chars)]
+	}
+	return string(b), nil
+}
+
+// link shows the accounts the player linked, or gives them a one-time code
+// to link their account at a provider with, on the web.
+// Usage: link [provider]
+func (s *Server) link(p *area.Player, args []string) string {
+	providers := s.linkProviders()
+	if len(providers) == 0 {
+		return "Linking accounts is off here\n"
+	}
+	usage := fmt.Sprintf("Usage: link [%s]\n", strings.Join(providers, "|"))
+	if len(args) == 0 {
+		links, err := s.db.ListLinks(s.ctxOf(p.Nickname), p.Nickname)
+		if err != nil {
+			log.Error(fmt.Sprintf("Cannot list the links of %q: %v", p.Nickname, err))
+			return "Your links cannot be read right now\n"
+		}
+		if len(links) == 0 {
+			return "You have no linked accounts\n" + usage
+		}
+		lines := []string{}
+		for _, l := range links {
+			lines = append(lines, fmt.Sprintf("%s: %s", oauthProviders[l.Provider].title, l.Name))
+		}
+		return strings.Join(lines, "\n") + "\n"
+	}
+	provider := strings.ToLower(args[0])
+	if _, ok := s.config.OAuth.Providers[provider]; !ok || len(args) > 1 {
+		return usage
+	}
+
+	now := s.now()
+	for code, c := range s.linkCodes {
+		if now.After(c.Expires) {
+			delete(s.linkCodes, code)
+		}
+	}
+	code, err := randomCode(linkCodeChars, 8)
+	if err != nil {
+		log.Error(fmt.Sprintf("Cannot make a link code: %v", err))
+		return "Something went wrong, try again\n"
+	}
+	s.linkCodes[code] = linkCode{Player: p.Nickname, Provider: provider, Expires: now.Add(linkCodeLife)}
+	return fmt.Sprintf("Within %d minutes, visit %s/link?code=%s to link your %s account\n",
+		int(linkCodeLife.Minutes()), strings.TrimSuffix(s.config.OAuth.URL, "/"), code, oauthProviders[provider].title)
+}
+
+// unlink unlinks the account the player has at the provider.
+// Usage: unlink <provider>
+func (s *Server) unlink(p *area.Player, args []string) string {
+	if len(args) != 1 {
+		return "Usage: unlink <provider>\n"
+	}
+	provider := strings.ToLower(args[0])
+	found, err := s.db.DeleteLink(s.ctxOf(p.Nickname), p.Nickname, provider)
+	if err != nil {
+		log.Error(fmt.Sprintf("Cannot unlink the %s account of %q: %v", provider, p.Nickname, err))
+		return "Your account cannot be unlinked right now\n"
+	}
+	if !found {
+		return fmt.Sprintf("You have no %s account linked\n", provider)
+	}
+	return fmt.Sprintf("Your %s account is unlinked\n", oauthProviders[provider].title)
+}
+
+// serveLink shows whom the code a player was given in the game links to, and
+// once they confirm, trades it for a login at the provider of the code. The
+// browser they confirm in is given a cookie the login has to come back with.
+func (s *Server) serveLink(w http.ResponseWriter, r *http.Request) {
+	code := strings.ToUpper(strings.TrimSpace(r.FormValue("code")))
+	confirmed := r.Method == http.MethodPost
+	var c linkCode
+	var state string
+	found := false
+	if !s.inWorld(r.Context(), func() {
+		c, found = s.linkCodes[code]
+		if !found || s.now().After(c.Expires) {
+			delete(s.linkCodes, code)
+			found = false
+			return
+		}
+		if !confirmed {
+			return
+		}
+		delete(s.linkCodes, code)
+		var err error
+		if state, err = randomCode("0123456789abcdef", 32); err != nil {
+			log.Error(fmt.Sprintf("Cannot make a link state: %v", err))
+			found = false
+			return
+		}
+		s.linkStates[state] = c
+	}) {
+		return
+	}
+	if !found {
+		http.Error(w, "The code is wrong or has expired, type link in the game for another", http.StatusBadRequest)
+		return
+	}
+	provider, app := oauthProviders[c.Provider], s.config.OAuth.Providers[c.Provider]
+	if !confirmed {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		page := struct{ Title, Player, Code string }{provider.title, c.Player, code}
+		if err := linkPage.Execute(w, page); err != nil {
+			log.Error(fmt.Sprintf("Cannot render the link page: %v", err))
+		}
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     linkCookie,
+		Value:    state,
+		Path:     "/",
+		MaxAge:   int(linkCodeLife.Seconds()),
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteLaxMode,
+	})
+	q := url.Values{
+		"client_id":     {app.ClientID},
+		"redirect_uri":  {s.linkCallback()},
+		"response_type": {"code"},
+		"scope":         {provider.scope},
+		"state":         {state},
+	}
+	http.Redirect(w, r, provider.auth+"?"+q.Encode(), http.StatusFound)
+}
+
+// linkCallback returns where the providers send players back to.
+func (s *Server) linkCallback() string {
+	return strings.TrimSuffix(s.config.OAuth.URL, "/") + "/link/callback"
+}
+
+// serveLinkCallback links the account the player logged in with at the
+// provider to their character, when they come back in the browser they set
+// out from. An account linked to another character stays theirs.
+func (s *Server) serveLinkCallback(w http.ResponseWriter, r *http.Request) {
+	state := r.FormValue("state")
+	cookie, err := r.Cookie(linkCookie)
+	if state == "" || err != nil || subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(state)) != 1 {
+		http.Error(w, "The login was started in another browser, type link in the game to start over", http.StatusForbidden)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{Name: linkCookie, Path: "/", MaxAge: -1, HttpOnly: true, Secure: true, SameSite: http.SameSiteLaxMode})
+	var c linkCode
+	found := false
+	if !s.inWorld(r.Context(), func() {
+		c, found = s.linkStates[state]
+		delete(s.linkStates, state)
+		found = found && !s.now().After(c.Expires)
+	}) {
+		return
+	}
+	if !found {
+		http.Error(w, "The login has expired, type link in the game to start over", http.StatusBadRequest)
+		return
+	}
+	if r.FormValue("error") != "" {
+		http.Error(w, "The login was denied", http.StatusForbidden)
+		return
+	}
+
+	id, name, err := s.oauthIdentity(c.Provider, r.FormValue("code"))
+	if err != nil {
+		log.Error(fmt.Sprintf("Cannot tell who %q is at %s: %v", c.Player, c.Provider, err))
+		http.Error(w, "The provider could not tell who you are", http.StatusBadGateway)
+		return
+	}
+	ctx := r.Context()
+	l := Link{Provider: c.Provider, ID: id, Name: name, Player: c.Player, Linked: time.Now()}
+	title := oauthProviders[c.Provider].title
+	switch err := s.db.PutLink(ctx, l); {
+	case err == errLinkedElsewhere:
+		http.Error(w, fmt.Sprintf("Your %s account %s is linked to another character, unlink it there first", title, name), http.StatusConflict)
+		return
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	n := Notification{From: "System", Text: fmt.Sprintf("Your %s account %s is linked", title, name), Time: time.Now()}
+	if err := s.db.AddNotification(ctx, c.Player, n); err != nil {
+		log.Error(fmt.Sprintf("Cannot notify %q: %v", c.Player, err))
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	fmt.Fprintf(w, "Your %s account %s is now linked to %s\n", title, name, c.Player)
+}
+
+// oauthIdentity trades the code for a token at the provider, and returns
+// who the token says the player is there.
+func (s *Server) oauthIdentity(name, code string) (string, string, error) {
+	provider, app := oauthProviders[name], s.config.OAuth.Providers[name]
+	form := url.Values{
+		"client_id":     {app.ClientID},
+		"client_secret": {app.Secret},
+		"code":          {code},
+		"grant_type":    {"authorization_code"},
+		"redirect_uri":  {s.linkCallback()},
+	}
+	req, err := http.NewRequest(http.MethodPost, provider.token, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	body, err := oauthCall(req)
+	if err != nil {
+		return "", "", err
+	}
+	token := struct {
+		AccessToken string `json:"access_token"`
+	}{}
+	if err := json.Unmarshal(body, &token); err != nil {
+		return "", "", err
+	}
+	if token.AccessToken == "" {
+		return "", "", fmt.Errorf("no token in %s", body)
+	}
+
+	if req, err = http.NewRequest(http.MethodGet, provider.user, nil); err != nil {
+		return "", "", err
+	}
+	req.Header.Set("Authorization", "Bearer "+token.AccessToken)
+	req.Header.Set("Accept", "application/json")
+	if body, err = oauthCall(req); err != nil {
+		return "", "", err
+	}
+	id, login, err := provider.identity(body)
+	if err == nil && id == "" {
+		err = fmt.Errorf("no ID in %s", body)
+	}
+	return id, login, err
+}
+
+// oauthCall sends the request to a provider and returns the body of what it
+// answers.
+func oauthCall(req *http.Request) ([]byte, error) {
+	resp, err := oauthClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s answered %s", req.URL.Host, resp.Status)
+	}
+	return body, nil
+}
+
+// serveLinks tells the bots and the web pages of the community which player
+// an identity at a provider belongs to, or the identities of a player.
+func (s *Server) serveLinks(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var data interface{}
+	if player := r.FormValue("player"); player != "" {
+		links, err := s.db.ListLinks(r.Context(), player)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		data = links
+	} else {
+		provider, id := r.FormValue("provider"), r.FormValue("id")
+		if provider == "" || id == "" {
+			http.Error(w, "player, or provider and id are required", http.StatusBadRequest)
+			return
+		}
+		l, err := s.db.GetLink(r.Context(), provider, id)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if l == nil {
+			http.Error(w, "not linked", http.StatusNotFound)
+			return
+		}
+		data = l
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(data)
+}
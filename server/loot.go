@@ -0,0 +1,112 @@
+package server
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/droslean/thyranew/area"
+	"github.com/droslean/thyranew/game"
+	"github.com/gothyra/toml"
+
+	log "gopkg.in/inconshreveable/log15.v2"
+)
+
+// maxLootSimulations caps the runs of a single lootsim command. The runs are
+// rolled in the world, which waits for them.
+const maxLootSimulations = 10000
+
+// loadLootTables loads all the loot tables from the static directory into memory.
+func (s *Server) loadLootTables() error {
+	log.Info("Loading loot tables ...")
+	lootWalker := func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+
+		fileContent, fileIoErr := ioutil.ReadFile(path)
+		if fileIoErr != nil {
+			log.Info(fmt.Sprintf("%s could not be loaded: %v", path, fileIoErr))
+			return fileIoErr
+		}
+
+		table := game.LootTable{}
+		if _, err := toml.Decode(string(fileContent), &table); err != nil {
+			log.Info(fmt.Sprintf("%s could not be unmarshaled: %v", path, err))
+			return err
+		}
+
+		log.Info(fmt.Sprintf("Loaded loot table %q", table.Name))
+		s.LootTables[table.Name] = table
+
+		return nil
+	}
+
+	return filepath.Walk(s.staticDir+"/loot/", lootWalker)
+}
+
+// lootContext returns the context used to roll loot of a creature of the given level for the player.
+func lootContext(p *area.Player, level int) game.LootContext {
+	return game.LootContext{
+		Level: level,
+		HasQuest: func(quest string) bool {
+			_, ok := p.Quests[quest]
+			return ok
+		},
+	}
+}
+
+// lootSimulate rolls a loot table many times and reports the drop rates.
+// Usage: lootsim <table> [runs] [level]
+func (s *Server) lootSimulate(p *area.Player, args []string) string {
+	if !isBuilder(p) {
+		return "Only builders can simulate loot\n"
+	}
+	if len(args) == 0 {
+		return "Usage: lootsim <table> [runs] [level]\n"
+	}
+
+	name := args[0]
+	if _, ok := s.LootTables[name]; !ok {
+		return fmt.Sprintf("There is no loot table %q\n", name)
+	}
+
+	runs := 1000
+	if len(args) > 1 {
+		n, err := strconv.Atoi(args[1])
+		if err != nil || n < 1 {
+			return "Runs should be a positive number\n"
+		}
+		if n > maxLootSimulations {
+			return fmt.Sprintf("Runs can be %d at most\n", maxLootSimulations)
+		}
+		runs = n
+	}
+
+	level := p.Level
+	if len(args) > 2 {
+		n, err := strconv.Atoi(args[2])
+		if err != nil || n < 0 {
+			return "Level should be a number\n"
+		}
+		level = n
+	}
+
+	report := game.SimulateLoot(s.LootTables, name, lootContext(p, level), runs, s.rnd)
+
+	lines := []string{fmt.Sprintf("%s: %d runs, level %d, %.1f gold", name, report.Runs, level, report.AvgGold)}
+	for i, rate := range report.Rates {
+		// The message pane has room only for the most common drops.
+		if i == 4 {
+			break
+		}
+		lines = append(lines, fmt.Sprintf("%s (%s) %.2f%%", rate.Item, rate.Rarity, rate.Rate*100))
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
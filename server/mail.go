@@ -0,0 +1,358 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/droslean/thyranew/area"
+	"github.com/gothyra/toml"
+
+	log "gopkg.in/inconshreveable/log15.v2"
+)
+
+const (
+	// mailExpiry is how long mail waits unread before it goes back to its sender.
+	mailExpiry = 7 * 24 * time.Hour
+	// mailSweepTicks is every how many ticks expired mail is sent back.
+	mailSweepTicks = 30
+	// maxParcelWeight is how many pounds of items a parcel holds.
+	maxParcelWeight = 20
+)
+
+// The state of a mail. Pending and delivering mail has its attachments in
+// flight, and the player files tell whether they arrived.
+const (
+	mailPending    = "pending"
+	mailSent       = "sent"
+	mailDelivering = "delivering"
+	mailRead       = "read"
+)
+
+// mail handles the mailbox of the player. Long letters are written in the
+// editor.
+// Usage: mail, mail read <id>, mail attach <item>|<amount> gold, mail send <player> <text>, mail write <player>
+func (s *Server) mail(roomsMap map[string]map[string][][]area.Cube, c *Client, args []string) string {
+	p := c.Player
+	if len(args) == 0 {
+		return s.listMail(p)
+	}
+	switch strings.ToLower(args[0]) {
+	case "read":
+		if len(args) < 2 {
+			return "Usage: mail read <id>\n"
+		}
+		id, err := strconv.ParseUint(args[1], 10, 64)
+		if err != nil {
+			return "Usage: mail read <id>\n"
+		}
+		return s.readMail(p, id)
+	case "attach":
+		return s.attach(p, args[1:])
+	case "send":
+		if len(args) < 3 {
+			return "Usage: mail send <player> <text>\n"
+		}
+		return s.sendMail(roomsMap, p, args[1], strings.Join(args[2:], " "))
+	case "write":
+		if len(args) != 2 {
+			return "Usage: mail write <player>\n"
+		}
+		to := args[1]
+		s.openModal(c, newEditor("Mail to "+to, "", func(s *Server, c *Client, text string) string {
+			if strings.TrimSpace(text) == "" {
+				return "You send nothing\n"
+			}
+			return s.sendMail(roomsMap, c.Player, to, text)
+		}))
+		return ""
+	}
+	return "Usage: mail [read <id>|attach <item>|send <player> <text>|write <player>]\n"
+}
+
+// listMail shows the unread mail of the player.
+func (s *Server) listMail(p *area.Player) string {
+	all, err := s.db.ListMail(s.ctxOf(p.Nickname))
+	if err != nil {
+		log.Error(fmt.Sprintf("Cannot list the mail: %v", err))
+		return "The post office is closed right now\n"
+	}
+	lines := []string{}
+	for _, m := range all {
+		if m.To != p.Nickname || m.Status != mailSent {
+			continue
+		}
+		// Letters written in the editor are listed by their first line.
+		text := strings.SplitN(stripMarkup(m.Text), "\n", 2)
+		if len(text) > 1 {
+			text[0] += " ..."
+		}
+		line := fmt.Sprintf("#%d from %s: %s", m.ID, m.From, text[0])
+		if m.Returned {
+			line = fmt.Sprintf("#%d returned by %s: %s", m.ID, m.From, text[0])
+		}
+		if len(m.Items) > 0 || m.Gold > 0 {
+			line += " (parcel)"
+		}
+		lines = append(lines, line)
+	}
+	if len(lines) == 0 {
+		return "You have no mail\n"
+	}
+	if len(lines) > maxMessageLines {
+		lines = append(lines[:maxMessageLines-1], fmt.Sprintf("and %d more", len(lines)-maxMessageLines+1))
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// attach adds an item or gold to the parcel of the next mail. Nothing leaves
+// the inventory until the mail is sent.
+func (s *Server) attach(p *area.Player, args []string) string {
+	if len(args) == 0 {
+		return "Usage: mail attach <item>|<amount> gold\n"
+	}
+	if len(args) == 2 && strings.EqualFold(args[1], "gold") {
+		amount, err := strconv.Atoi(args[0])
+		if err != nil || amount <= 0 {
+			return "Usage: mail attach <amount> gold\n"
+		}
+		if p.ParcelGold+amount > p.Gold {
+			return "You don't have that much gold\n"
+		}
+		p.ParcelGold += amount
+		return fmt.Sprintf("You attach %d gold\n", amount)
+	}
+
+	// Only plain items go by post; the rest have too much of their own.
+	item := strings.Join(args, " ")
+	carried := p.Plain(item)
+	for _, i := range p.Parcel {
+		if strings.EqualFold(i, item) {
+			carried--
+		}
+	}
+	if carried <= 0 {
+		return fmt.Sprintf("You have no %s to attach\n", item)
+	}
+	weight := s.weigh(item)
+	for _, i := range p.Parcel {
+		weight += s.weigh(i)
+	}
+	if weight > maxParcelWeight {
+		return fmt.Sprintf("The %s won't fit in the parcel, which holds %d pounds\n", item, maxParcelWeight)
+	}
+	p.Parcel = append(p.Parcel, item)
+	return fmt.Sprintf("You attach %s\n", item)
+}
+
+// sendMail sends a letter with the parcel of the player. The attachments go
+// into escrow: the mail is journaled as pending first, the player file that no
+// longer holds them is the point of no return, and only then is the mail sent.
+// A player who drops before the mail is journaled sends nothing; after that the
+// mail goes through whether they are still connected or not.
+func (s *Server) sendMail(roomsMap map[string]map[string][][]area.Cube, p *area.Player, to, text string) string {
+	ok, playerFileName := s.getPlayerFileName(to)
+	if !ok {
+		return fmt.Sprintf("There is no player %s\n", to)
+	}
+	if _, err := os.Stat(playerFileName); err != nil {
+		return fmt.Sprintf("There is no player %s\n", to)
+	}
+	for _, item := range p.Parcel {
+		if p.Plain(item) == 0 {
+			p.Parcel, p.ParcelGold = nil, 0
+			return fmt.Sprintf("You no longer have %s, the parcel is undone\n", item)
+		}
+	}
+	if p.ParcelGold > p.Gold {
+		p.Parcel, p.ParcelGold = nil, 0
+		return "You no longer have that much gold, the parcel is undone\n"
+	}
+
+	m := &Mail{From: p.Nickname, To: to, Text: text, Items: p.Parcel, Gold: p.ParcelGold, Sent: s.now(), Status: mailPending}
+	if err := s.db.PutMail(s.ctxOf(p.Nickname), m); err != nil {
+		log.Error(fmt.Sprintf("Cannot journal the mail of %q: %v", p.Nickname, err))
+		return "The post office is closed right now\n"
+	}
+
+	inventory, gold := p.Inventory, p.Gold
+	p.Inventory = append([]string(nil), p.Inventory...)
+	for _, item := range m.Items {
+		p.RemoveItem(item)
+	}
+	p.Gold -= m.Gold
+	p.Mail = append(p.Mail, m.ID)
+	if err := s.savePlayer(p); err != nil {
+		log.Error(fmt.Sprintf("Cannot hand the parcel of %q to the post office: %v", p.Nickname, err))
+		p.Inventory, p.Gold = inventory, gold
+		p.Mail = p.Mail[:len(p.Mail)-1]
+		s.db.DeleteMail(context.Background(), m.ID)
+		return "The post office is closed right now\n"
+	}
+	p.Parcel, p.ParcelGold = nil, 0
+
+	m.Status = mailSent
+	if err := s.db.PutMail(context.Background(), m); err != nil {
+		// The journal is settled on the next start of the server.
+		log.Error(fmt.Sprintf("Cannot send mail %d: %v", m.ID, err))
+	} else {
+		s.settleMail(p, m.ID)
+	}
+
+	s.notify(roomsMap, to, "Post", fmt.Sprintf("You have mail from %s", p.Nickname))
+	return fmt.Sprintf("You send mail to %s\n", to)
+}
+
+// readMail shows the mail to the player and hands over its attachments, the
+// same way sendMail takes them.
+func (s *Server) readMail(p *area.Player, id uint64) string {
+	ctx := s.ctxOf(p.Nickname)
+	m, err := s.db.GetMail(ctx, id)
+	if err != nil {
+		log.Error(fmt.Sprintf("Cannot read mail %d: %v", id, err))
+		return "The post office is closed right now\n"
+	}
+	if m == nil || m.To != p.Nickname || (m.Status != mailSent && m.Status != mailRead) {
+		return "There is no such mail\n"
+	}
+	msg := fmt.Sprintf("From %s: %s\n", m.From, stripMarkup(m.Text))
+	if m.Status == mailRead {
+		return msg
+	}
+	if !s.fits(p, m.Items...) {
+		return msg + "The parcel is more than you can carry. Make room and read it again\n"
+	}
+
+	m.Status = mailDelivering
+	if err := s.db.PutMail(ctx, m); err != nil {
+		log.Error(fmt.Sprintf("Cannot deliver mail %d: %v", id, err))
+		return "The post office is closed right now\n"
+	}
+	for _, item := range m.Items {
+		p.AddItem(item)
+	}
+	p.Gold += m.Gold
+	p.Mail = append(p.Mail, m.ID)
+	if err := s.savePlayer(p); err != nil {
+		log.Error(fmt.Sprintf("Cannot hand mail %d to %q: %v", id, p.Nickname, err))
+		p.Inventory = p.Inventory[:len(p.Inventory)-len(m.Items)]
+		p.Gold -= m.Gold
+		p.Mail = p.Mail[:len(p.Mail)-1]
+		m.Status = mailSent
+		s.db.PutMail(context.Background(), m)
+		return "The post office is closed right now\n"
+	}
+
+	m.Status = mailRead
+	if err := s.db.PutMail(context.Background(), m); err != nil {
+		log.Error(fmt.Sprintf("Cannot close mail %d: %v", id, err))
+	} else {
+		s.settleMail(p, m.ID)
+	}
+
+	if len(m.Items) > 0 {
+		msg += fmt.Sprintf("You take %s\n", strings.Join(m.Items, ", "))
+	}
+	if m.Gold > 0 {
+		msg += fmt.Sprintf("You take %d gold\n", m.Gold)
+	}
+	return msg
+}
+
+// settleMail drops the mail from the journal of the player file, once the post
+// office knows the attachments changed hands.
+func (s *Server) settleMail(p *area.Player, id uint64) {
+	for i := range p.Mail {
+		if p.Mail[i] == id {
+			p.Mail = append(p.Mail[:i], p.Mail[i+1:]...)
+			break
+		}
+	}
+	if err := s.savePlayer(p); err != nil {
+		log.Error(fmt.Sprintf("Cannot save %q: %v", p.Nickname, err))
+	}
+}
+
+// recoverMail settles the mail left in flight by a crash. The player file
+// tells whether the attachments changed hands before the server went down.
+func (s *Server) recoverMail() error {
+	all, err := s.db.ListMail(context.Background())
+	if err != nil {
+		return err
+	}
+	for i := range all {
+		m := &all[i]
+		switch m.Status {
+		case mailPending:
+			if s.journaled(m.From, m.ID) {
+				m.Status = mailSent
+				err = s.db.PutMail(context.Background(), m)
+			} else {
+				err = s.db.DeleteMail(context.Background(), m.ID)
+			}
+		case mailDelivering:
+			if s.journaled(m.To, m.ID) {
+				m.Status = mailRead
+			} else {
+				m.Status = mailSent
+			}
+			err = s.db.PutMail(context.Background(), m)
+		default:
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		log.Info(fmt.Sprintf("Recovered mail %d as %s", m.ID, m.Status))
+	}
+	return nil
+}
+
+// journaled reports whether the file of the player lists the mail.
+func (s *Server) journaled(nick string, id uint64) bool {
+	ok, playerFileName := s.getPlayerFileName(nick)
+	if !ok {
+		return false
+	}
+	p := area.Player{}
+	if _, err := toml.DecodeFile(playerFileName, &p); err != nil {
+		log.Info(fmt.Sprintf("%s could not be unmarshaled: %v", playerFileName, err))
+		return false
+	}
+	for _, m := range p.Mail {
+		if m == id {
+			return true
+		}
+	}
+	return false
+}
+
+// returnMail sends the mail left unread for too long back to its senders,
+// attachments and all. Returned mail stays until it is read.
+func (s *Server) returnMail(roomsMap map[string]map[string][][]area.Cube) {
+	if s.ticks%mailSweepTicks != 0 {
+		return
+	}
+	all, err := s.db.ListMail(context.Background())
+	if err != nil {
+		log.Error(fmt.Sprintf("Cannot list the mail: %v", err))
+		return
+	}
+	for i := range all {
+		m := &all[i]
+		if m.Status != mailSent || m.Returned || s.now().Sub(m.Sent) < mailExpiry {
+			continue
+		}
+		m.From, m.To = m.To, m.From
+		m.Returned = true
+		m.Sent = s.now()
+		if err := s.db.PutMail(context.Background(), m); err != nil {
+			log.Error(fmt.Sprintf("Cannot return mail %d: %v", m.ID, err))
+			continue
+		}
+		s.notify(roomsMap, m.To, "Post", fmt.Sprintf("Your mail to %s came back unread", m.From))
+	}
+}
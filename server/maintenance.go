@@ -0,0 +1,252 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/droslean/thyranew/area"
+
+	log "gopkg.in/inconshreveable/log15.v2"
+)
+
+const (
+	// defaultCountdown is how many minutes players online have before they
+	// are let go for maintenance, unless server.toml says otherwise.
+	defaultCountdown = 5
+	// defaultBanner is what players are told while the server is down for
+	// maintenance, unless server.toml says otherwise.
+	defaultBanner = "The server is down for maintenance, come back later."
+)
+
+// MaintenanceConfig says what players are told while the server is down for
+// maintenance, when only admins may log in.
+type MaintenanceConfig struct {
+	// On starts the server down for maintenance.
+	On bool `toml:"on"`
+	// Banner is what the players turned away are told.
+	Banner string `toml:"banner"`
+	// Countdown is how many minutes players online have before they are let
+	// go, unless the admin says otherwise.
+	Countdown int `toml:"countdown"`
+}
+
+// maintenance is the server being down for maintenance. Admins play on, and
+// try out what they changed, while everyone else is kept out.
+type maintenance struct {
+	By      string    `json:"by"`
+	Since   time.Time `json:"since"`
+	Message string    `json:"message,omitempty"` // What players are told, in place of the banner
+	// Kick is when the players online are let go, zero once they are.
+	Kick time.Time `json:"kick"`
+	// warned is how many minutes were left when players were last warned.
+	warned int
+}
+
+// maintenanceMessage returns what the players turned away for maintenance are told.
+func (s *Server) maintenanceMessage() string {
+	switch {
+	case s.maintenance.Message != "":
+		return s.maintenance.Message
+	case s.config.Maintenance.Banner != "":
+		return s.config.Maintenance.Banner
+	}
+	return defaultBanner
+}
+
+// turnedAway returns what the player logging in is told while the server is
+// down for maintenance, or nothing if they may log in.
+func (s *Server) turnedAway(ctx context.Context, nick string) string {
+	msg := ""
+	s.inWorld(ctx, func() {
+		if s.maintenance == nil {
+			return
+		}
+		if p, found := s.playerOf(nick); found && isAdmin(&p) {
+			return
+		}
+		msg = s.maintenanceMessage()
+	})
+	return msg
+}
+
+// maintenanceBanner returns what players connecting are shown before they
+// log in, while the server is down for maintenance.
+func (s *Server) maintenanceBanner(ctx context.Context) string {
+	msg := ""
+	s.inWorld(ctx, func() {
+		if s.maintenance != nil {
+			msg = s.maintenanceMessage() + "\r\n"
+		}
+	})
+	return msg
+}
+
+// startMaintenance takes the server down for maintenance. The players online
+// who are not admins are let go once the minutes are up, and warned until
+// then.
+func (s *Server) startMaintenance(by string, minutes int, message string) {
+	s.maintenance = &maintenance{By: by, Since: s.now(), Message: message, Kick: s.now().Add(time.Duration(minutes) * time.Minute)}
+	log.Warn(fmt.Sprintf("%s took the server down for maintenance, letting the players go in %d minutes", by, minutes))
+	s.countDownMaintenance(s.world.rooms)
+}
+
+// endMaintenance lets everyone log in again. It reports whether the server
+// was down for maintenance.
+func (s *Server) endMaintenance(by string) bool {
+	if s.maintenance == nil {
+		return false
+	}
+	s.maintenance = nil
+	log.Warn(fmt.Sprintf("%s ended the maintenance", by))
+	s.broadcast(s.world.rooms, Broadcast{Kind: TagSystem, Text: fmt.Sprintf("%s ended the maintenance, everyone may log in again\n", by), Filter: isAdmin})
+	return true
+}
+
+// countDownMaintenance warns the players online every minute that they are
+// about to be let go for maintenance, and lets them go once it is time.
+func (s *Server) countDownMaintenance(roomsMap map[string]map[string][][]area.Cube) {
+	m := s.maintenance
+	if m == nil || m.Kick.IsZero() {
+		return
+	}
+	notAdmin := func(p *area.Player) bool { return !isAdmin(p) }
+	left := m.Kick.Sub(s.now())
+	if left > 0 {
+		minutes := int(math.Ceil(left.Minutes()))
+		if minutes == m.warned {
+			return
+		}
+		m.warned = minutes
+		when := "a minute"
+		if minutes > 1 {
+			when = fmt.Sprintf("%d minutes", minutes)
+		}
+		s.broadcast(roomsMap, Broadcast{Kind: TagSystem, Text: fmt.Sprintf("The server goes down for maintenance in %s: %s\n", when, s.maintenanceMessage()), Filter: notAdmin})
+		return
+	}
+	m.Kick = time.Time{}
+	for _, c := range s.OnlineClients() {
+		cl, ok := s.clientByNick(c.Name)
+		if !ok || isAdmin(cl.Player) {
+			continue
+		}
+		cl.writeString(s.maintenanceMessage() + "\r\n")
+		s.disconnect(cl)
+	}
+	s.broadcast(roomsMap, Broadcast{Kind: TagSystem, Text: "The players are gone, the server is yours to look after\n", Filter: isAdmin})
+}
+
+// maintenanceStatus tells admins whether the server is down for maintenance.
+func (s *Server) maintenanceStatus() string {
+	m := s.maintenance
+	if m == nil {
+		return "The server is open to everyone\n"
+	}
+	msg := fmt.Sprintf("%s took the server down for maintenance on %s: %s\n", m.By, m.Since.Format("2006-01-02 15:04"), s.maintenanceMessage())
+	if !m.Kick.IsZero() {
+		msg += fmt.Sprintf("The players online are let go in %s\n", m.Kick.Sub(s.now()).Round(time.Second))
+	}
+	return msg
+}
+
+// toggleMaintenance takes the server down for maintenance, or brings it back,
+// or tells whether it is down. The players online have the minutes given
+// before they are let go, and are told the message instead of the banner.
+// Usage: maintenance [on [minutes] [message]|off]
+func (s *Server) toggleMaintenance(admin *area.Player, args []string) string {
+	if len(args) == 0 {
+		return s.maintenanceStatus()
+	}
+	switch strings.ToLower(args[0]) {
+	case "on":
+		minutes := s.config.Maintenance.Countdown
+		if minutes <= 0 {
+			minutes = defaultCountdown
+		}
+		rest := args[1:]
+		if len(rest) > 0 {
+			if n, err := strconv.Atoi(rest[0]); err == nil && n >= 0 {
+				minutes, rest = n, rest[1:]
+			}
+		}
+		if s.maintenance != nil {
+			return "The server is down for maintenance already, turn it off first\n"
+		}
+		s.startMaintenance(admin.Nickname, minutes, strings.Join(rest, " "))
+		return s.maintenanceStatus()
+	case "off":
+		if !s.endMaintenance(admin.Nickname) {
+			return "The server is not down for maintenance\n"
+		}
+		return ""
+	}
+	return "Usage: maintenance [on [minutes] [message]|off]\n"
+}
+
+// serveMaintenance is the admin API of maintenance. GET tells whether the
+// server is down for maintenance, as JSON; POST takes it down, with the
+// admin it is done by, and optional minutes and message; DELETE brings it
+// back.
+func (s *Server) serveMaintenance(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && s.config.AdminToken == "" {
+		http.Error(w, "maintenance can only be toggled with an admin token", http.StatusForbidden)
+		return
+	}
+	var status *maintenance
+	var err error
+	if !s.inWorld(r.Context(), func() {
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodPost:
+			admin := r.FormValue("by")
+			minutes := s.config.Maintenance.Countdown
+			if minutes <= 0 {
+				minutes = defaultCountdown
+			}
+			if m := r.FormValue("minutes"); m != "" {
+				if minutes, err = strconv.Atoi(m); err != nil || minutes < 0 {
+					err = apiErrorf(http.StatusBadRequest, "minutes is not a number of minutes")
+					return
+				}
+			}
+			switch {
+			case admin == "":
+				err = apiErrorf(http.StatusBadRequest, "by is required")
+			case s.maintenance != nil:
+				err = apiErrorf(http.StatusConflict, "the server is down for maintenance already")
+			default:
+				s.startMaintenance(admin, minutes, r.FormValue("message"))
+			}
+		case http.MethodDelete:
+			by := r.FormValue("by")
+			if by == "" {
+				by = "admin API"
+			}
+			s.endMaintenance(by)
+		default:
+			err = apiErrorf(http.StatusMethodNotAllowed, "method not allowed")
+		}
+		if s.maintenance != nil {
+			m := *s.maintenance
+			m.Message = s.maintenanceMessage()
+			status = &m
+		}
+	}) {
+		return
+	}
+	if err != nil {
+		replyError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		On bool `json:"on"`
+		*maintenance
+	}{status != nil, status})
+}
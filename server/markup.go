@@ -0,0 +1,57 @@
+package server
+
+import (
+	"regexp"
+	"strings"
+)
+
+// markupTag matches the colour tags of written text: the name of a colour in
+// braces, like {red}, starts it and {x} ends it. Colours end with their line.
+var markupTag = regexp.MustCompile(`\{([a-z]+)\}`)
+
+// renderMarkup returns the line without its colour tags, and the colour of
+// each of its runes. Braces that are no colour stay as they are.
+func renderMarkup(line string) (string, []ID) {
+	var plain strings.Builder
+	colors := []ID{}
+	color := noColor
+	write := func(text string) {
+		plain.WriteString(text)
+		for range text {
+			colors = append(colors, color)
+		}
+	}
+	last := 0
+	for _, loc := range markupTag.FindAllStringSubmatchIndex(line, -1) {
+		name := line[loc[2]:loc[3]]
+		attr, ok := highlightColors[name]
+		if !ok && name != "x" {
+			continue
+		}
+		write(line[last:loc[0]])
+		last = loc[1]
+		color = noColor
+		if ok {
+			color = ID(attr)
+		}
+	}
+	write(line[last:])
+	return plain.String(), colors
+}
+
+// renderMarkupLines renders each line of the text.
+func renderMarkupLines(text string) (string, [][]ID) {
+	lines := strings.Split(text, "\n")
+	colors := make([][]ID, len(lines))
+	for i, line := range lines {
+		lines[i], colors[i] = renderMarkup(line)
+	}
+	return strings.Join(lines, "\n"), colors
+}
+
+// stripMarkup returns the text without its colour tags, for where colours
+// are not drawn.
+func stripMarkup(text string) string {
+	plain, _ := renderMarkupLines(text)
+	return plain
+}
@@ -0,0 +1,312 @@
+package server
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/droslean/thyranew/area"
+	"github.com/droslean/thyranew/game"
+)
+
+// fists is the weapon of players who wield nothing. It is no item they
+// carry.
+const fists = "fist"
+
+// offHandDelay is how long after the main hand the off hand first swings.
+const offHandDelay = time.Second
+
+// weaponFamily returns the family of the weapon.
+func weaponFamily(item area.Item) string {
+	if item.Family == "" {
+		return game.FamilyUnarmed
+	}
+	return item.Family
+}
+
+// weaponSpeed returns how many seconds the weapon takes between swings.
+func weaponSpeed(item area.Item) int {
+	if item.Speed <= 0 {
+		return game.DefaultSpeed
+	}
+	return item.Speed
+}
+
+// weaponBlows returns the type of damage the weapon deals.
+func weaponBlows(item area.Item) string {
+	if item.Blows == "" {
+		return game.DamageBludgeon
+	}
+	return item.Blows
+}
+
+// mainHand returns the weapon the player fights with, their fists when they
+// wield nothing.
+func (s *Server) mainHand(p *area.Player) heldItem {
+	if p.Weapon == "" {
+		return s.held(p, fists)
+	}
+	return s.held(p, p.Weapon)
+}
+
+// offWeapon returns the weapon in the off hand of the player, and whether
+// they hold one there.
+func (s *Server) offWeapon(p *area.Player) (heldItem, bool) {
+	if p.OffHand == "" {
+		return heldItem{}, false
+	}
+	h := s.held(p, p.OffHand)
+	return h, h.Kind == area.ItemWeapon
+}
+
+// wield puts the weapon the player carries in their main hand, and what
+// they wielded before among their belongings. Weapons that take both hands
+// empty the off hand.
+// Usage: wield <weapon>|nothing
+func (s *Server) wield(p *area.Player, args []string) string {
+	name := strings.Join(args, " ")
+	if name == "" {
+		return "Usage: wield <weapon>|nothing\n"
+	}
+	// The fists new characters start with are no item to put away.
+	if p.Weapon == fists {
+		p.Weapon = ""
+	}
+	if strings.EqualFold(name, "nothing") {
+		if p.Weapon == "" {
+			return "You wield nothing already\n"
+		}
+		old := p.Weapon
+		p.Unequip(&p.Weapon)
+		return fmt.Sprintf("You put away %s\n", old)
+	}
+	proto, ok := p.Prototype(name)
+	if !ok {
+		return fmt.Sprintf("You carry no %s\n", name)
+	}
+	item := s.itemDef(proto)
+	if item.Kind != area.ItemWeapon {
+		return fmt.Sprintf("You can't wield %s\n", proto)
+	}
+	msg := ""
+	if item.Hands > 1 && p.OffHand != "" {
+		msg = fmt.Sprintf("You put away %s to free your hands\n", p.OffHand)
+		p.Unequip(&p.OffHand)
+	}
+	p.Equip(&p.Weapon, name)
+	return msg + fmt.Sprintf("You wield %s\n", s.held(p, p.Weapon).name())
+}
+
+// hold puts the one-handed weapon or the shield the player carries in their
+// off hand, and what they held there before among their belongings.
+// Usage: hold <weapon|shield>|nothing
+func (s *Server) hold(p *area.Player, args []string) string {
+	name := strings.Join(args, " ")
+	if name == "" {
+		return "Usage: hold <weapon|shield>|nothing\n"
+	}
+	if strings.EqualFold(name, "nothing") {
+		if p.OffHand == "" {
+			return "Your off hand is empty already\n"
+		}
+		old := p.OffHand
+		p.Unequip(&p.OffHand)
+		return fmt.Sprintf("You put away %s\n", old)
+	}
+	proto, ok := p.Prototype(name)
+	if !ok {
+		return fmt.Sprintf("You carry no %s\n", name)
+	}
+	item := s.itemDef(proto)
+	if item.Kind != area.ItemWeapon && item.Kind != area.ItemShield {
+		return fmt.Sprintf("You can't hold %s in your off hand\n", proto)
+	}
+	if main := s.mainHand(p); main.Hands > 1 {
+		return fmt.Sprintf("Your %s takes both hands\n", main.Name)
+	}
+	if item.Hands > 1 {
+		return fmt.Sprintf("%s takes both hands\n", proto)
+	}
+	p.Equip(&p.OffHand, name)
+	if item.Kind == area.ItemShield {
+		return fmt.Sprintf("You hold up %s\n", s.held(p, p.OffHand).name())
+	}
+	return fmt.Sprintf("You hold %s in your off hand\n", s.held(p, p.OffHand).name())
+}
+
+// attack starts fighting the NPC in the room hand to hand. The weapons of
+// the player swing at it by themselves, each as fast as it is, until one of
+// them falls or the player leaves.
+// Usage: attack <target>
+func (s *Server) attack(roomsMap map[string]map[string][][]area.Cube, cl *Client, args []string) string {
+	p := cl.Player
+	if len(args) == 0 {
+		return "Usage: attack <target>\n"
+	}
+	name := strings.Join(args, " ")
+	npc, _, ok := s.findRangedTarget(roomsMap, p, name, 0)
+	if !ok {
+		return fmt.Sprintf("You see no %s here\n", name)
+	}
+	if p.Swinging == npc.ID {
+		return fmt.Sprintf("You are fighting %s already\n", npc.Name)
+	}
+	s.startGlobalCooldown(roomsMap, p)
+	b := Broadcast{Scope: ScopeRoom, Area: p.Area, Room: p.Room, From: p.Nickname, Kind: TagCombat, Text: "$n {attack|attacks} $N\n"}
+	msg := s.act(roomsMap, b, playerActor(p), npcActor(npc))
+	s.startSwinging(roomsMap, p, npc)
+	return msg
+}
+
+// startSwinging has the weapons of the player swing at the NPC: the main
+// hand at once, and the off hand a moment later.
+func (s *Server) startSwinging(roomsMap map[string]map[string][][]area.Cube, p *area.Player, npc *area.NPC) {
+	s.lastSwing++
+	fight := s.lastSwing
+	nick, id := p.Nickname, npc.ID
+	p.Swinging = id
+	s.swings[nick] = fight
+	s.provoke(roomsMap, npc, nick)
+	s.swing(roomsMap, nick, id, fight, false)
+	s.after(offHandDelay, func() { s.swing(roomsMap, nick, id, fight, true) })
+}
+
+// stopSwinging ends the fight hand to hand of the player.
+func (s *Server) stopSwinging(p *area.Player) {
+	p.Swinging = 0
+	delete(s.swings, p.Nickname)
+}
+
+// swing swings a hand of the player at the NPC they fight, and again once
+// the weapon in it is ready, for as long as the fight lasts. Off hands
+// without a weapon wait.
+func (s *Server) swing(roomsMap map[string]map[string][][]area.Cube, nick string, id, fight int, offHand bool) {
+	cl, ok := s.clientByNick(nick)
+	if !ok || s.swings[nick] != fight {
+		return
+	}
+	p := cl.Player
+	npc, areaName := s.findNPC(id)
+	if p.Swinging != id || p.Ghost || npc == nil || areaName != p.Area || npc.Room != p.Room {
+		s.stopSwinging(p)
+		return
+	}
+	weapon := s.mainHand(p)
+	off, dual := s.offWeapon(p)
+	if offHand {
+		weapon = off
+	}
+	if !offHand || dual {
+		s.blow(roomsMap, cl, npc, areaName, weapon, dual, offHand)
+	}
+	if p.Swinging != id {
+		return
+	}
+	s.after(time.Duration(weaponSpeed(weapon.Item))*time.Second, func() {
+		s.swing(roomsMap, nick, id, fight, offHand)
+	})
+}
+
+// blow resolves a blow of the weapon of the player at the NPC. Fighting with
+// a weapon in each hand costs both to hit, the off hand more, and skill with
+// the family of the weapon adds to hit.
+func (s *Server) blow(roomsMap map[string]map[string][][]area.Cube, cl *Client, npc *area.NPC, areaName string, weapon heldItem, dual, offHand bool) {
+	p := cl.Player
+	family := weaponFamily(weapon.Item)
+	gear := s.gearBonus(p)
+	stance := game.Stance(p.Tactics.Stance)
+	bonus := gear.hit + stance.Hit - s.loadPenalty(p) + weaponRank(p, family)
+	switch {
+	case offHand:
+		bonus -= game.OffHandPenalty
+	case dual:
+		bonus -= game.MainHandPenalty
+	}
+	die := weapon.Die
+	if die == 0 {
+		die = p.Weapondie
+	}
+	name := strings.ToLower(weapon.Name)
+	if name == "" {
+		name = fists
+	}
+
+	b := Broadcast{Scope: ScopeRoom, Area: areaName, Room: npc.Room, Kind: TagCombat}
+	hit, damage := game.MeleeRoll(&p.PC, die, bonus, npc.AC, s.rnd)
+	if !hit {
+		s.recordCombat(CombatEvent{Player: p.Nickname, Kind: combatDealt, Source: weapon.Name})
+		b.Text = fmt.Sprintf("$p %s misses $N\n", name)
+		s.act(roomsMap, b, playerActor(p), npcActor(npc))
+		s.provoke(roomsMap, npc, p.Nickname)
+		return
+	}
+	s.landBlow(roomsMap, p, family)
+	blows := weaponBlows(weapon.Item)
+	damage, fared := npc.Defenses.Against(damage+gear.damage+stance.Damage, blows)
+	if fared == game.DamageImmune {
+		s.recordCombat(CombatEvent{Player: p.Nickname, Kind: combatDealt, Source: weapon.Name})
+		b.Text = defenseText(fared, blows, true)
+		s.act(roomsMap, b, playerActor(p), npcActor(npc))
+		s.provoke(roomsMap, npc, p.Nickname)
+		return
+	}
+
+	npc.HP -= damage
+	s.recordCombat(CombatEvent{Player: p.Nickname, Kind: combatDealt, Source: weapon.Name, Amount: damage})
+	s.addThreat(npc, p.Nickname, game.Threat(&p.PC, damage))
+	if npc.HP <= 0 {
+		s.stopSwinging(p)
+		s.killNPC(roomsMap, cl, npc.ID)
+		return
+	}
+	b.Text = fmt.Sprintf("$p %s hits $N for %d\n", name, damage) + defenseText(fared, blows, true)
+	s.act(roomsMap, b, playerActor(p), npcActor(npc))
+	s.provoke(roomsMap, npc, p.Nickname)
+}
+
+// weaponRank returns the rank of skill of the player with the family of
+// weapons, by the blows they landed with it and the ranks they trained.
+func weaponRank(p *area.Player, family string) int {
+	return game.WeaponRank(p.WeaponSkills[family], p.Trained[family])
+}
+
+// landBlow counts a blow the player landed with the family of weapons, and
+// tells them when their skill with it goes up a rank.
+func (s *Server) landBlow(roomsMap map[string]map[string][][]area.Cube, p *area.Player, family string) {
+	if p.WeaponSkills == nil {
+		p.WeaponSkills = map[string]int{}
+	}
+	was := weaponRank(p, family)
+	p.WeaponSkills[family]++
+	if rank := weaponRank(p, family); rank > was {
+		s.tellPlayer(roomsMap, p.Nickname, tagged(TagSystem, fmt.Sprintf("Your %s skill rises to %s\n", family, game.SkillTitles[rank])))
+	}
+}
+
+// skillLines describes the skill of the player with each family of weapons
+// they landed a blow with or trained in.
+func skillLines(p *area.Player) []string {
+	lines := []string{}
+	for _, family := range game.WeaponFamilies {
+		blows, trained := p.WeaponSkills[family], p.Trained[family]
+		if blows == 0 && trained == 0 {
+			continue
+		}
+		line := fmt.Sprintf("%s: %s, %d blows", strings.Title(family), game.SkillTitles[weaponRank(p, family)], blows)
+		if trained > 0 {
+			line += fmt.Sprintf(", %d ranks trained", trained)
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// weaponSkills shows how skilled the player is with each family of weapons.
+// Usage: skills
+func weaponSkills(p *area.Player) string {
+	lines := skillLines(p)
+	if len(lines) == 0 {
+		return "You have no skill with any weapon yet\n"
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
@@ -0,0 +1,375 @@
+package server
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/droslean/thyranew/area"
+)
+
+// The keys that steer a modal. The prompt bar sends them while one is open,
+// and resizeWatch sends keyResize for the modal to fit the new screen.
+const (
+	keyUp     = "up"
+	keyDown   = "down"
+	keyEnter  = "enter"
+	keyEscape = "esc"
+	keyResize = "resize"
+)
+
+// modal is a window drawn over the middle of the screen, that takes the keys
+// of the player and the lines it knows what to do with until it's done:
+// a menu, a list to pick from, a question, or a field to type in.
+type modal interface {
+	// view returns what the modal shows, in at most height lines.
+	view(height int) modalView
+	// key handles a key the player pressed. It returns what to tell the
+	// player, and whether the modal is done.
+	key(s *Server, c *Client, key string) (msg string, done bool)
+	// line handles a line the player typed. Lines the modal doesn't take
+	// are run as commands.
+	line(s *Server, c *Client, text string) (msg string, done, taken bool)
+}
+
+// modalView is what a modal shows.
+type modalView struct {
+	title string
+	lines []modalLine
+	hint  string // How to work the modal, on its last line
+	full  bool   // The modal takes the whole screen
+}
+
+// modalLine is a line of a modal.
+type modalLine struct {
+	text   string
+	click  string // What clicking the line types, empty for nothing
+	colors []ID   // The colours of the runes of the text, if it has any
+}
+
+// choice is one of the choices of a menu.
+type choice struct {
+	label string // What the menu shows
+	value string // What the pick gets, and typing it picks the choice too
+}
+
+// menu is a list of choices to pick one of, with the arrows and enter, by
+// number, or by value. Typing part of a label narrows the list down to the
+// choices that have it, which is what long lists are picked from with.
+type menu struct {
+	title   string
+	choices []choice
+	pick    func(s *Server, c *Client, ch choice) string
+	stay    bool   // The menu stays open after a pick, under whatever the pick opened
+	cursor  int    // The selected choice, among those shown
+	top     int    // The first choice shown, so the cursor stays in view
+	filter  string // What the list is narrowed down to
+}
+
+// shown returns the choices the menu shows.
+func (m *menu) shown() []choice {
+	if m.filter == "" {
+		return m.choices
+	}
+	shown := []choice{}
+	for _, ch := range m.choices {
+		if strings.Contains(strings.ToLower(ch.label), m.filter) {
+			shown = append(shown, ch)
+		}
+	}
+	return shown
+}
+
+func (m *menu) view(height int) modalView {
+	v := modalView{title: m.title, hint: "Arrows move, enter picks, esc closes"}
+	if m.filter != "" {
+		v.lines = append(v.lines, modalLine{text: fmt.Sprintf("Matching %q, esc shows all", m.filter)})
+		height--
+	}
+	shown := m.shown()
+	if len(shown) == 0 {
+		v.lines = append(v.lines, modalLine{text: "Nothing matches"})
+		return v
+	}
+	if height < 1 {
+		height = 1
+	}
+	// The list scrolls along with the cursor.
+	if m.cursor < m.top {
+		m.top = m.cursor
+	}
+	if m.cursor >= m.top+height {
+		m.top = m.cursor - height + 1
+	}
+	for i := m.top; i < len(shown) && i < m.top+height; i++ {
+		mark := "  "
+		if i == m.cursor {
+			mark = "> "
+		}
+		v.lines = append(v.lines, modalLine{
+			text:  fmt.Sprintf("%s%d) %s", mark, i+1, shown[i].label),
+			click: strconv.Itoa(i + 1),
+		})
+	}
+	return v
+}
+
+func (m *menu) key(s *Server, c *Client, key string) (string, bool) {
+	shown := m.shown()
+	switch key {
+	case keyUp:
+		if m.cursor > 0 {
+			m.cursor--
+		}
+	case keyDown:
+		if m.cursor < len(shown)-1 {
+			m.cursor++
+		}
+	case keyEnter:
+		if m.cursor < len(shown) {
+			return m.choose(s, c, shown[m.cursor])
+		}
+	case keyEscape:
+		if m.filter != "" {
+			m.filter, m.cursor, m.top = "", 0, 0
+			return "", false
+		}
+		return "", true
+	}
+	return "", false
+}
+
+func (m *menu) line(s *Server, c *Client, text string) (string, bool, bool) {
+	shown := m.shown()
+	if n, err := strconv.Atoi(text); err == nil {
+		if n < 1 || n > len(shown) {
+			return fmt.Sprintf("Pick a number from 1 to %d\n", len(shown)), false, true
+		}
+		m.cursor = n - 1
+		msg, done := m.choose(s, c, shown[n-1])
+		return msg, done, true
+	}
+	lower := strings.ToLower(text)
+	for _, ch := range shown {
+		if strings.ToLower(ch.value) == lower || strings.ToLower(ch.label) == lower {
+			msg, done := m.choose(s, c, ch)
+			return msg, done, true
+		}
+	}
+	// A single letter is more likely a command than part of a label.
+	if len(lower) < 2 {
+		return "", false, false
+	}
+	for _, ch := range m.choices {
+		if strings.Contains(strings.ToLower(ch.label), lower) {
+			m.filter, m.cursor, m.top = lower, 0, 0
+			return "", false, true
+		}
+	}
+	return "", false, false
+}
+
+// choose picks the choice.
+func (m *menu) choose(s *Server, c *Client, ch choice) (string, bool) {
+	return m.pick(s, c, ch), !m.stay
+}
+
+// newConfirm asks the player a question, and runs yes if they say yes, or no,
+// if there is one, if they say no.
+func newConfirm(question string, yes, no func(s *Server, c *Client) string) *menu {
+	return &menu{
+		title:   question,
+		choices: []choice{{label: "Yes", value: "y"}, {label: "No", value: "n"}},
+		pick: func(s *Server, c *Client, ch choice) string {
+			if ch.value == "y" {
+				return yes(s, c)
+			}
+			if no != nil {
+				return no(s, c)
+			}
+			return ""
+		},
+	}
+}
+
+// textInput is a field the player types a line into.
+type textInput struct {
+	title  string
+	prompt string
+	value  string // What enter on an empty prompt takes
+	check  func(text string) error
+	submit func(s *Server, c *Client, text string) string
+	err    string // Why the last line was turned down
+}
+
+func (t *textInput) view(height int) modalView {
+	v := modalView{title: t.title, hint: "Type and press enter, esc cancels"}
+	v.lines = append(v.lines, modalLine{text: t.prompt})
+	if t.value != "" {
+		v.lines = append(v.lines, modalLine{text: fmt.Sprintf("Enter alone takes %s", t.value), click: t.value})
+	}
+	if t.err != "" {
+		v.lines = append(v.lines, modalLine{text: t.err})
+	}
+	if len(v.lines) > height {
+		v.lines = v.lines[:height]
+	}
+	return v
+}
+
+func (t *textInput) key(s *Server, c *Client, key string) (string, bool) {
+	switch key {
+	case keyEnter:
+		if t.value != "" {
+			msg, done, _ := t.line(s, c, t.value)
+			return msg, done
+		}
+	case keyEscape:
+		return "", true
+	}
+	return "", false
+}
+
+func (t *textInput) line(s *Server, c *Client, text string) (string, bool, bool) {
+	if t.check != nil {
+		if err := t.check(text); err != nil {
+			t.err = err.Error()
+			return "", false, true
+		}
+	}
+	return t.submit(s, c, text), true, true
+}
+
+// openModal shows the modal to the player, over any they have open already.
+func (s *Server) openModal(c *Client, m modal) {
+	nick := c.Player.Nickname
+	s.modals[nick] = append(s.modals[nick], m)
+	atomic.StoreInt32(&c.modalOpen, 1)
+}
+
+// closeModal takes the modal off the screen of the player. The one under it,
+// if any, is back on top.
+func (s *Server) closeModal(c *Client, m modal) {
+	nick := c.Player.Nickname
+	stack := s.modals[nick]
+	for i := range stack {
+		if stack[i] == m {
+			stack = append(stack[:i], stack[i+1:]...)
+			break
+		}
+	}
+	if len(stack) == 0 {
+		delete(s.modals, nick)
+		atomic.StoreInt32(&c.modalOpen, 0)
+		return
+	}
+	s.modals[nick] = stack
+}
+
+// topModal returns the modal on top of the screen of the player.
+func (s *Server) topModal(nick string) (modal, bool) {
+	stack := s.modals[nick]
+	if len(stack) == 0 {
+		return nil, false
+	}
+	return stack[len(stack)-1], true
+}
+
+// modalInput hands the key or the line of the player to the modal on top of
+// their screen. It reports whether the modal took it.
+func (s *Server) modalInput(roomsMap map[string]map[string][][]area.Cube, c *Client, ev Event) bool {
+	m, ok := s.topModal(c.Player.Nickname)
+	if !ok {
+		// Keys for a modal that closed in the meantime go nowhere.
+		return ev.key != ""
+	}
+	var msg string
+	var done bool
+	if ev.key != "" {
+		msg, done = m.key(s, c, ev.key)
+	} else {
+		var taken bool
+		if msg, done, taken = m.line(s, c, strings.TrimSpace(ev.EventType)); !taken {
+			return false
+		}
+	}
+	if done {
+		s.closeModal(c, m)
+	}
+	s.godPrintRoom([]Client{*c}, roomsMap, msg, "")
+	return true
+}
+
+// drawModal lays the modal on top of the screen of the player over the
+// middle of their screen. It returns what clicking its lines types, nil when
+// no modal is open.
+func (s *Server) drawModal(c Client) map[cell]string {
+	m, ok := s.topModal(c.Player.Nickname)
+	if !ok {
+		return nil
+	}
+	// The box keeps a line clear above and below it, and takes a line for
+	// each border, the title, and the hint.
+	rows := c.h - 3
+	v := m.view(rows - 6)
+
+	width := len([]rune(v.title)) + 6
+	for _, l := range append(v.lines, modalLine{text: v.hint}) {
+		if n := len([]rune(l.text)) + 4; n > width {
+			width = n
+		}
+	}
+	if width > c.w-4 || v.full {
+		width = c.w - 4
+	}
+	for v.full && len(v.lines) < rows-6 {
+		v.lines = append(v.lines, modalLine{})
+	}
+	inner := width - 4
+	fit := func(text string) []rune {
+		r := []rune(text)
+		if len(r) > inner {
+			r = r[:inner]
+		}
+		return append(r, []rune(strings.Repeat(" ", inner-len(r)))...)
+	}
+
+	title := []rune(" " + v.title + " ")
+	if len(title) > width-4 {
+		title = title[:width-4]
+	}
+	top := append([]rune("┌─"), title...)
+	top = append(top, []rune(strings.Repeat("─", width-3-len(title)))...)
+	canvas := [][]rune{append(top, '┐')}
+	colors := [][]ID{nil}
+	for _, l := range v.lines {
+		canvas = append(canvas, append(append([]rune("│ "), fit(l.text)...), ' ', '│'))
+		row := make([]ID, width)
+		for w := range row {
+			row[w] = noColor
+			if w >= 2 && w-2 < len(l.colors) && w-2 < inner {
+				row[w] = l.colors[w-2]
+			}
+		}
+		colors = append(colors, row)
+	}
+	canvas = append(canvas, append(append([]rune("│ "), fit(v.hint)...), ' ', '│'))
+	canvas = append(canvas, append(append([]rune("└"), []rune(strings.Repeat("─", width-2))...), '┘'))
+
+	c.screen.modalCanvas = canvas
+	c.screen.modalColors = colors
+	c.screen.modalTop = (rows - len(canvas)) / 2
+	c.screen.modalLeft = (c.w - width) / 2
+
+	clicks := map[cell]string{}
+	for h, l := range v.lines {
+		if l.click == "" {
+			continue
+		}
+		for w := 0; w < width; w++ {
+			clicks[cell{c.screen.modalTop + 1 + h, c.screen.modalLeft + w}] = l.click
+		}
+	}
+	return clicks
+}
@@ -0,0 +1,513 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/droslean/thyranew/area"
+	"github.com/gothyra/toml"
+
+	log "gopkg.in/inconshreveable/log15.v2"
+)
+
+const (
+	// sentenceSweepTicks is every how many ticks served sentences end and
+	// lapsed mutes are lifted.
+	sentenceSweepTicks = 10
+	// auditLines is how many entries of the audit log admins are shown.
+	auditLines = 20
+	// escalationAdmin is who the audit log says punished players the
+	// escalation rules set off on.
+	escalationAdmin = "escalation"
+)
+
+// defaultJail is where jailed players are locked in, unless the server sets
+// a jail of its own.
+var defaultJail = area.Exit{ToArea: "Jail", ToRoom: "Cell", ToCubeID: "5"}
+
+// jailCommands are all that jailed players can do, besides walking around
+// their cell.
+var jailCommands = map[string]bool{
+	"": true, "quit": true, "time": true, "weather": true, "scan": true,
+	"channel": true, "chat": true, "warnings": true,
+	"bug": true, "typo": true, "idea": true,
+	"terminal": true, "mouse": true, "scroll": true, "walk": true,
+	"settings": true, "flag": true, "wanted": true, "hints": true,
+	"score": true, "compare": true, "identify": true, "inspect": true,
+	"drop": true, "keys": true, "loot": true, "tactics": true,
+	"cooldowns": true, "wield": true, "hold": true, "skills": true,
+	"practice": true, "history": true, "obituaries": true,
+	"link": true, "unlink": true, "export": true, "delete": true, "tell": true, "cluster": true,
+	"intermud": true, "who": true,
+}
+
+var errNoPlayer = errors.New("no such player")
+
+// loadModeration loads the escalation rules from the static directory. A
+// missing file leaves players to the admins alone.
+func (s *Server) loadModeration() error {
+	path := s.staticDir + "/moderation.toml"
+	fileContent, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		log.Info(fmt.Sprintf("%s could not be loaded: %v", path, err))
+		return err
+	}
+
+	moderation := struct {
+		Escalation []area.Escalation `toml:"escalation"`
+	}{}
+	if _, err := toml.Decode(string(fileContent), &moderation); err != nil {
+		log.Info(fmt.Sprintf("%s could not be unmarshaled: %v", path, err))
+		return err
+	}
+	for _, e := range moderation.Escalation {
+		if e.Action != "mute" && e.Action != "jail" {
+			return fmt.Errorf("unknown escalation action %q", e.Action)
+		}
+	}
+	log.Info(fmt.Sprintf("Loaded %d escalation rules", len(moderation.Escalation)))
+	s.escalations = moderation.Escalation
+	return nil
+}
+
+// jailCell returns the cube jailed players are sent to.
+func (s *Server) jailCell() area.Exit {
+	if s.config.Jail.ToArea != "" {
+		return s.config.Jail
+	}
+	return defaultJail
+}
+
+// audit records what was done to the player in the audit log.
+func (s *Server) audit(admin, action, player, detail string) {
+	e := &AuditEntry{Time: s.now(), Admin: admin, Action: action, Player: player, Detail: detail}
+	if detail != "" {
+		log.Info(fmt.Sprintf("%s: %s %s, %s", admin, action, player, detail))
+	} else {
+		log.Info(fmt.Sprintf("%s: %s %s", admin, action, player))
+	}
+	if err := s.db.AddAudit(context.Background(), e); err != nil {
+		log.Error(fmt.Sprintf("Cannot audit %s of %q: %v", action, player, err))
+	}
+}
+
+// moderate changes the player and saves them. Players who are not online are
+// changed in their file; change gets their client only when they are.
+func (s *Server) moderate(nick string, change func(p *area.Player, cl *Client)) error {
+	if cl, ok := s.clientByNick(nick); ok {
+		change(cl.Player, cl)
+		return s.savePlayer(cl.Player)
+	}
+	found, err := s.loadPlayer(nick)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return errNoPlayer
+	}
+	p := s.Players[nick]
+	change(&p, nil)
+	return s.savePlayer(&p)
+}
+
+// moderated turns what went wrong while moderating the player into a message
+// for the admin, or the given message when nothing did.
+func moderated(nick string, err error, msg string) string {
+	switch {
+	case err == errNoPlayer:
+		return fmt.Sprintf("There is no player %q\n", nick)
+	case err != nil:
+		log.Error(fmt.Sprintf("Cannot save player %q: %v", nick, err))
+		return fmt.Sprintf("%s could not be saved\n", nick)
+	}
+	return msg
+}
+
+// tellModerated tells the player, if online, what was done about them.
+func (s *Server) tellModerated(roomsMap map[string]map[string][][]area.Cube, cl *Client, text string) {
+	if cl != nil {
+		s.godPrintRoom([]Client{*cl}, roomsMap, tagged(TagSystem, text), "")
+	}
+}
+
+// parseTerm reads how long a punishment lasts, like "30m", "2h" or "3d".
+func parseTerm(arg string) (time.Duration, bool) {
+	if strings.HasSuffix(arg, "d") {
+		days, err := strconv.Atoi(strings.TrimSuffix(arg, "d"))
+		if err != nil || days <= 0 {
+			return 0, false
+		}
+		return time.Duration(days) * 24 * time.Hour, true
+	}
+	d, err := time.ParseDuration(arg)
+	if err != nil || d <= 0 {
+		return 0, false
+	}
+	return d, true
+}
+
+// termAndReason splits the arguments into an optional term and the reason.
+func termAndReason(args []string) (time.Duration, string) {
+	if len(args) > 0 {
+		if term, ok := parseTerm(args[0]); ok {
+			return term, strings.Join(args[1:], " ")
+		}
+	}
+	return 0, strings.Join(args, " ")
+}
+
+// until returns when a punishment of the given term ends, zero for one that
+// lasts until lifted.
+func (s *Server) until(term time.Duration) time.Time {
+	if term == 0 {
+		return time.Time{}
+	}
+	return s.now().Add(term)
+}
+
+func untilText(t time.Time) string {
+	if t.IsZero() {
+		return "until lifted"
+	}
+	return "until " + t.Format("2006-01-02 15:04")
+}
+
+func mutedNotice(m area.Mute) string {
+	where := "on " + m.Channel
+	if m.Channel == area.AllChannels {
+		where = "everywhere"
+	}
+	return fmt.Sprintf("You are muted %s %s\n", where, untilText(m.Until))
+}
+
+// mute keeps a player from talking on a channel, or everywhere.
+// Usage: mute <player> <channel|all> [term] [reason]
+func (s *Server) mute(roomsMap map[string]map[string][][]area.Cube, admin *area.Player, args []string) string {
+	if len(args) < 2 {
+		return "Usage: mute <player> <channel|all> [term] [reason]\n"
+	}
+	term, reason := termAndReason(args[2:])
+	m := area.Mute{Channel: strings.ToLower(args[1]), Until: s.until(term), By: admin.Nickname, Reason: reason}
+	err := s.mutePlayer(roomsMap, args[0], m)
+	return moderated(args[0], err, fmt.Sprintf("%s is muted %s\n", args[0], untilText(m.Until)))
+}
+
+// mutePlayer puts the mute on the player, in place of any other on the same
+// channel.
+func (s *Server) mutePlayer(roomsMap map[string]map[string][][]area.Cube, nick string, m area.Mute) error {
+	err := s.moderate(nick, func(p *area.Player, cl *Client) {
+		mutes := []area.Mute{m}
+		for _, old := range p.Mutes {
+			if old.Channel != m.Channel {
+				mutes = append(mutes, old)
+			}
+		}
+		p.Mutes = mutes
+		s.tellModerated(roomsMap, cl, mutedNotice(m))
+	})
+	if err == nil {
+		s.audit(m.By, "mute", nick, fmt.Sprintf("%s %s: %s", m.Channel, untilText(m.Until), m.Reason))
+	}
+	return err
+}
+
+// unmute lifts the mutes of the player on a channel, or all of them.
+// Usage: unmute <player> [channel|all]
+func (s *Server) unmute(roomsMap map[string]map[string][][]area.Cube, admin *area.Player, args []string) string {
+	if len(args) == 0 {
+		return "Usage: unmute <player> [channel|all]\n"
+	}
+	channel := ""
+	if len(args) > 1 {
+		channel = strings.ToLower(args[1])
+	}
+	lifted := 0
+	err := s.moderate(args[0], func(p *area.Player, cl *Client) {
+		mutes := []area.Mute{}
+		for _, m := range p.Mutes {
+			if channel != "" && m.Channel != channel {
+				mutes = append(mutes, m)
+			}
+		}
+		lifted = len(p.Mutes) - len(mutes)
+		p.Mutes = mutes
+		if lifted > 0 {
+			s.tellModerated(roomsMap, cl, "You are no longer muted\n")
+		}
+	})
+	if err == nil && lifted > 0 {
+		s.audit(admin.Nickname, "unmute", args[0], channel)
+	}
+	if err == nil && lifted == 0 {
+		return fmt.Sprintf("%s is not muted\n", args[0])
+	}
+	return moderated(args[0], err, fmt.Sprintf("%s is unmuted\n", args[0]))
+}
+
+// jail locks the player in the jail.
+// Usage: jail <player> [term] [reason]
+func (s *Server) jail(roomsMap map[string]map[string][][]area.Cube, admin *area.Player, args []string) string {
+	if len(args) == 0 {
+		return "Usage: jail <player> [term] [reason]\n"
+	}
+	term, reason := termAndReason(args[1:])
+	until := s.until(term)
+	err := s.jailPlayer(roomsMap, args[0], admin.Nickname, until, reason)
+	return moderated(args[0], err, fmt.Sprintf("%s is jailed %s\n", args[0], untilText(until)))
+}
+
+// jailPlayer sends the player to the jail, remembering where they stood.
+// Jailing a jailed player only changes the sentence.
+func (s *Server) jailPlayer(roomsMap map[string]map[string][][]area.Cube, nick, by string, until time.Time, reason string) error {
+	err := s.moderate(nick, func(p *area.Player, cl *Client) {
+		if !p.Jail.Jailed {
+			p.Jail.Return = area.Exit{ToArea: p.Area, ToRoom: p.Room, ToCubeID: p.Position}
+			if cl != nil {
+				p.Watching = ""
+				s.teleport(roomsMap, p, s.jailCell(), "jail")
+			} else {
+				sendTo(p, s.jailCell())
+			}
+		}
+		p.Jail.Jailed = true
+		p.Jail.Until = until
+		p.Jail.By = by
+		p.Jail.Reason = reason
+		s.tellModerated(roomsMap, cl, fmt.Sprintf("You are jailed %s\n", untilText(until)))
+	})
+	if err == nil {
+		s.audit(by, "jail", nick, fmt.Sprintf("%s: %s", untilText(until), reason))
+	}
+	return err
+}
+
+// unjail lets the player out of the jail.
+// Usage: unjail <player>
+func (s *Server) unjail(roomsMap map[string]map[string][][]area.Cube, admin *area.Player, args []string) string {
+	if len(args) == 0 {
+		return "Usage: unjail <player>\n"
+	}
+	released, err := s.releasePlayer(roomsMap, args[0], admin.Nickname)
+	if err == nil && !released {
+		return fmt.Sprintf("%s is not in jail\n", args[0])
+	}
+	return moderated(args[0], err, fmt.Sprintf("%s is released\n", args[0]))
+}
+
+// releasePlayer sends the jailed player back where they were jailed, or to
+// their bind if that place is gone, like the instance of a dungeon.
+func (s *Server) releasePlayer(roomsMap map[string]map[string][][]area.Cube, nick, by string) (bool, error) {
+	released := false
+	err := s.moderate(nick, func(p *area.Player, cl *Client) {
+		if !p.Jail.Jailed {
+			return
+		}
+		to := p.Jail.Return
+		if !s.placeExists(to) {
+			to = p.Bind
+			if to.ToArea == "" {
+				to = defaultBind
+			}
+		}
+		p.Jail = area.Jail{}
+		if cl != nil {
+			s.teleport(roomsMap, p, to, "release")
+		} else {
+			sendTo(p, to)
+		}
+		s.tellModerated(roomsMap, cl, "You are released from jail\n")
+		released = true
+	})
+	if err == nil && released {
+		s.audit(by, "unjail", nick, "")
+	}
+	return released, err
+}
+
+// placeExists reports whether the room or wilderness the exit leads to is
+// still in the world.
+func (s *Server) placeExists(to area.Exit) bool {
+	if to.ToRoom == area.WildernessRoom {
+		_, ok := s.Wilderness[to.ToArea]
+		return ok
+	}
+	_, ok := s.Areas[to.ToArea].Rooms[to.ToRoom]
+	return ok
+}
+
+// warn gives the player a formal warning, recorded on their account, which
+// may set off the escalation rules. The player is notified, online or not.
+// Usage: warn <player> <reason>
+func (s *Server) warn(roomsMap map[string]map[string][][]area.Cube, admin *area.Player, args []string) string {
+	if len(args) < 2 {
+		return "Usage: warn <player> <reason>\n"
+	}
+	nick := args[0]
+	w := area.Warning{Time: s.now(), By: admin.Nickname, Reason: strings.Join(args[1:], " ")}
+	var warned area.Player
+	err := s.moderate(nick, func(p *area.Player, cl *Client) {
+		p.Warnings = append(p.Warnings, w)
+		warned = *p
+	})
+	if err != nil {
+		return moderated(nick, err, "")
+	}
+	s.audit(w.By, "warn", nick, w.Reason)
+	if err := s.notify(roomsMap, nick, "Warning from "+w.By, w.Reason); err != nil {
+		return fmt.Sprintf("%s is warned, but could not be notified\n", nick)
+	}
+	msg := fmt.Sprintf("%s is warned, %d warnings in all\n", nick, len(warned.Warnings))
+	return msg + s.escalate(roomsMap, &warned)
+}
+
+// escalate punishes the player for every escalation rule their warnings just
+// set off, and tells what was done.
+func (s *Server) escalate(roomsMap map[string]map[string][][]area.Cube, p *area.Player) string {
+	msg := ""
+	for _, e := range s.escalations {
+		since := time.Time{}
+		if e.Days > 0 {
+			since = s.now().AddDate(0, 0, -e.Days)
+		}
+		if p.WarningsSince(since) != e.Warnings {
+			continue
+		}
+		term := time.Duration(e.Minutes) * time.Minute
+		reason := fmt.Sprintf("%d warnings", e.Warnings)
+		var err error
+		switch e.Action {
+		case "mute":
+			err = s.mutePlayer(roomsMap, p.Nickname, area.Mute{Channel: area.AllChannels, Until: s.until(term), By: escalationAdmin, Reason: reason})
+		case "jail":
+			err = s.jailPlayer(roomsMap, p.Nickname, escalationAdmin, s.until(term), reason)
+		}
+		if err != nil {
+			log.Error(fmt.Sprintf("Cannot %s %q: %v", e.Action, p.Nickname, err))
+			continue
+		}
+		msg += fmt.Sprintf("%s set off the rule to %s them %s\n", reason, e.Action, untilText(s.until(term)))
+	}
+	return msg
+}
+
+// warnings lists the warnings of the player. Admins can list those of
+// anyone.
+// Usage: warnings [player]
+func (s *Server) warnings(p *area.Player, args []string) string {
+	target := *p
+	if len(args) > 0 && isAdmin(p) {
+		if cl, ok := s.clientByNick(args[0]); ok {
+			target = *cl.Player
+		} else if found, err := s.loadPlayer(args[0]); err != nil || !found {
+			return fmt.Sprintf("There is no player %q\n", args[0])
+		} else {
+			target = s.Players[args[0]]
+		}
+	}
+	if len(target.Warnings) == 0 {
+		return fmt.Sprintf("%s has no warnings\n", target.Nickname)
+	}
+	lines := []string{}
+	for _, w := range target.Warnings {
+		lines = append(lines, fmt.Sprintf("%s by %s: %s", w.Time.Format("2006-01-02 15:04"), w.By, w.Reason))
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// review puts the player under review for a while, or ends it. What players
+// under review say in private is logged along with the rest.
+// Usage: review <player> <term>|off [reason]
+func (s *Server) review(admin *area.Player, args []string) string {
+	usage := "Usage: review <player> <term>|off [reason]\n"
+	if len(args) < 2 {
+		return usage
+	}
+	until := time.Time{}
+	if strings.ToLower(args[1]) != "off" {
+		term, ok := parseTerm(args[1])
+		if !ok {
+			return usage
+		}
+		until = s.now().Add(term)
+	}
+	reason := strings.Join(args[2:], " ")
+	err := s.moderate(args[0], func(p *area.Player, cl *Client) {
+		p.Review = until
+	})
+	if err != nil {
+		return moderated(args[0], err, "")
+	}
+	if until.IsZero() {
+		s.audit(admin.Nickname, "end review", args[0], reason)
+		return fmt.Sprintf("%s is no longer under review\n", args[0])
+	}
+	s.audit(admin.Nickname, "review", args[0], fmt.Sprintf("%s: %s", untilText(until), reason))
+	return fmt.Sprintf("%s is under review %s\n", args[0], untilText(until))
+}
+
+// auditLog shows the latest moderation, of everyone or of the player.
+// Usage: audit [player]
+func (s *Server) auditLog(admin *area.Player, args []string) string {
+	player := ""
+	if len(args) > 0 {
+		player = args[0]
+	}
+	entries, err := s.db.ListAudit(s.ctxOf(admin.Nickname), player, auditLines)
+	if err != nil {
+		log.Error(fmt.Sprintf("Cannot list the audit log: %v", err))
+		return "The audit log cannot be read right now\n"
+	}
+	if len(entries) == 0 {
+		return "The audit log is empty\n"
+	}
+	lines := []string{}
+	for _, e := range entries {
+		line := fmt.Sprintf("%s %s: %s %s", e.Time.Format("2006-01-02 15:04"), e.Admin, e.Action, e.Player)
+		if e.Detail != "" {
+			line += ", " + e.Detail
+		}
+		lines = append(lines, line)
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// endSentences releases the online players whose sentence is served, and lifts
+// the mutes that lapsed. Players offline at the time are seen to once they
+// are back.
+func (s *Server) endSentences(roomsMap map[string]map[string][][]area.Cube) {
+	if s.ticks%sentenceSweepTicks != 0 {
+		return
+	}
+	now := s.now()
+	for _, c := range s.OnlineClients() {
+		p := c.Player
+		if p.Jail.Served(now) {
+			if _, err := s.releasePlayer(roomsMap, p.Nickname, "sentence"); err != nil {
+				log.Error(fmt.Sprintf("Cannot release %q: %v", p.Nickname, err))
+			}
+		}
+		mutes := []area.Mute{}
+		for _, m := range p.Mutes {
+			if m.Active(now) {
+				mutes = append(mutes, m)
+			}
+		}
+		if len(mutes) == len(p.Mutes) {
+			continue
+		}
+		p.Mutes = mutes
+		s.tellModerated(roomsMap, &c, "A mute of yours has lapsed\n")
+		if err := s.savePlayer(p); err != nil {
+			log.Error(fmt.Sprintf("Cannot save player %q: %v", p.Nickname, err))
+		}
+	}
+}
@@ -0,0 +1,457 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/droslean/thyranew/area"
+)
+
+// mouseOn and mouseOff turn the reports of the mouse on and off: clicks and
+// the wheel, in the SGR encoding, which works on screens of any size.
+const (
+	mouseOn  = "\x1b[?1000h\x1b[?1006h"
+	mouseOff = "\x1b[?1000l\x1b[?1006l"
+)
+
+const (
+	// maxMouseReport is the longest a report of the mouse gets. Anything
+	// longer is not one.
+	maxMouseReport = 32
+	// messageHistoryLines is how many lines of messages players can scroll
+	// back through.
+	messageHistoryLines = 100
+	// walkStepDelay is how long a player takes for each step of a walk.
+	walkStepDelay = 250 * time.Millisecond
+)
+
+// The panes players can scroll.
+const (
+	paneMessages = "messages"
+	paneCombat   = "combat"
+)
+
+// mouseReportPrefix starts every report of the mouse.
+var mouseReportPrefix = []byte("\x1b[<")
+
+var (
+	// menuItem is an answer of a menu among the messages. Clicking anywhere
+	// on it picks it.
+	menuItem = regexp.MustCompile(`^\s*(\d+)\) `)
+	// button is a command among the messages, run when clicked.
+	button = regexp.MustCompile(`\[([a-z]+)\]`)
+)
+
+// arrowCommands are the commands of the arrows of the exits.
+var arrowCommands = map[rune]string{'→': "e", '←': "w", '↑': "n", '↓': "s"}
+
+// directionCommands are the commands that move the player, by the index of
+// the direction in FindExits.
+var directionCommands = []string{"e", "w", "n", "s"}
+
+// directionOffsets are how far each direction goes across the cubes of a
+// room, in the order of FindExits.
+var directionOffsets = [][2]int{{1, 0}, {-1, 0}, {0, -1}, {0, 1}}
+
+// cell is a place on the screen, by row and column from zero.
+type cell struct {
+	row, col int
+}
+
+// mouseReport is what the terminal told about the mouse.
+type mouseReport struct {
+	button   int
+	row, col int // From one, as the terminal counts
+	release  bool
+}
+
+// parseMouse reads the report of the mouse at the start of the input. It
+// returns the report and its length, zero while the report is not whole yet,
+// or -1 when the input doesn't start with one. Reports that make no sense
+// come back with a button of -1.
+func parseMouse(in []byte) (mouseReport, int) {
+	if !bytes.HasPrefix(in, mouseReportPrefix) {
+		return mouseReport{}, -1
+	}
+	end := bytes.IndexAny(in, "Mm")
+	if end < 0 {
+		if len(in) > maxMouseReport {
+			return mouseReport{}, -1
+		}
+		return mouseReport{}, 0
+	}
+	r := mouseReport{button: -1, release: in[end] == 'm'}
+	fields := strings.Split(string(in[len(mouseReportPrefix):end]), ";")
+	if len(fields) != 3 {
+		return r, end + 1
+	}
+	b, err1 := strconv.Atoi(fields[0])
+	col, err2 := strconv.Atoi(fields[1])
+	row, err3 := strconv.Atoi(fields[2])
+	if err1 != nil || err2 != nil || err3 != nil {
+		return r, end + 1
+	}
+	r.button, r.col, r.row = b, col, row
+	return r, end + 1
+}
+
+// splitMouse takes the reports of the mouse off the start of the input. It
+// returns the reports, the keys that follow them, and the start of a report
+// that is not whole yet.
+func splitMouse(in []byte) (reports []mouseReport, keys, partial []byte) {
+	for len(in) > 0 {
+		r, size := parseMouse(in)
+		switch {
+		case size < 0:
+			return reports, in, nil
+		case size == 0:
+			return reports, nil, in
+		}
+		if r.button >= 0 {
+			reports = append(reports, r)
+		}
+		in = in[size:]
+	}
+	return reports, nil, nil
+}
+
+// mouseCommand returns the command the report of the mouse stands for: a
+// click of the left button, or a turn of the wheel. Everything else the mouse
+// does is left alone.
+func mouseCommand(r mouseReport) (string, bool) {
+	switch {
+	case r.release:
+		return "", false
+	case r.button == 0:
+		return fmt.Sprintf("click %d %d", r.row, r.col), true
+	case r.button == 64:
+		return fmt.Sprintf("scroll up %d %d", r.row, r.col), true
+	case r.button == 65:
+		return fmt.Sprintf("scroll down %d %d", r.row, r.col), true
+	}
+	return "", false
+}
+
+// sendMouse hands what the mouse did to the world, as a command.
+func (c *Client) sendMouse(ctx context.Context, eventCh chan Event, r mouseReport) {
+	cmd, ok := mouseCommand(r)
+	if !ok {
+		return
+	}
+	select {
+	case eventCh <- Event{Client: c, EventType: cmd}:
+	case <-ctx.Done():
+	}
+}
+
+// mouseActive reports whether the mouse is reported for the player: they
+// want it, and their terminal does it.
+func mouseActive(p *area.Player) bool {
+	return p.Mouse && p.ClientInfo.Mouse
+}
+
+// applyMouse turns the reports of the mouse on or off on the terminal of the
+// player, when that changed. Terminals that never had them on are told
+// nothing, so those that don't know the sequences don't show them.
+func (s *Server) applyMouse(c *Client) {
+	f := s.frameOf(c.Player.Nickname)
+	on := mouseActive(c.Player)
+	if on == f.mouse {
+		return
+	}
+	f.mouse = on
+	if on {
+		c.writeString(mouseOn)
+	} else {
+		c.writeString(mouseOff)
+	}
+}
+
+// mouse turns the use of the mouse on or off for the player.
+// Usage: mouse [on|off]
+func (s *Server) mouse(c *Client, args []string) string {
+	p := c.Player
+	if len(args) == 0 {
+		switch {
+		case !p.Mouse:
+			return "The mouse is off\n"
+		case !p.ClientInfo.Mouse:
+			return "The mouse is on, but your terminal does not report it\n"
+		}
+		return "The mouse is on\n"
+	}
+	switch strings.ToLower(args[0]) {
+	case "on":
+		p.Mouse = true
+	case "off":
+		p.Mouse = false
+	default:
+		return "Usage: mouse [on|off]\n"
+	}
+	s.applyMouse(c)
+	msg := "The mouse is off\n"
+	switch {
+	case p.Mouse && !p.ClientInfo.Mouse:
+		msg = "Your terminal does not report the mouse, so it stays off. If it can, say so with: terminal mouse on\n"
+	case p.Mouse:
+		msg = "The mouse is on. Click the map to walk, and the answers to pick them\n"
+	}
+	return s.savePreferences(p, msg)
+}
+
+// clickMap works out what clicking each cell of the screen of the player
+// runs: walking to the cubes of the map, moving by the arrows of the exits,
+// and picking the answers and pressing the buttons among the messages. The
+// cubes are the IDs under the runes of the map, nil when it has none.
+func clickMap(c Client, cubes [][]string) map[cell]string {
+	clicks := map[cell]string{}
+	for h, line := range cubes {
+		for w, id := range line {
+			if id != "" && id != c.Player.Position {
+				clicks[cell{c.h - 30 + h, c.w - 20 + w}] = "walk " + id
+			}
+		}
+	}
+	for ex, r := range c.screen.exitCanvas {
+		if cmd, ok := arrowCommands[r]; ok {
+			clicks[cell{c.h - 10, c.w - 30 + ex}] = cmd
+		}
+	}
+	for h, line := range c.screen.messagesCanvas {
+		if h == maxMessageLines {
+			break
+		}
+		text := string(line)
+		if m := menuItem.FindStringSubmatch(text); m != nil {
+			for w := range line {
+				clicks[cell{c.h - 8 + h, c.w - 50 + w}] = m[1]
+			}
+			continue
+		}
+		for _, loc := range button.FindAllStringSubmatchIndex(text, -1) {
+			start := utf8.RuneCountInString(text[:loc[0]])
+			end := utf8.RuneCountInString(text[:loc[1]])
+			for w := start; w < end; w++ {
+				clicks[cell{c.h - 8 + h, c.w - 50 + w}] = text[loc[2]:loc[3]]
+			}
+		}
+	}
+	return clicks
+}
+
+// clicked returns the command under the cell the player clicked, empty when
+// there is nothing there.
+func (s *Server) clicked(c *Client, args []string) string {
+	if len(args) != 2 {
+		return ""
+	}
+	row, err1 := strconv.Atoi(args[0])
+	col, err2 := strconv.Atoi(args[1])
+	f, ok := s.frames[c.Player.Nickname]
+	if err1 != nil || err2 != nil || !ok {
+		return ""
+	}
+	return f.clicks[cell{row - 1, col - 1}]
+}
+
+// scroll moves a pane back or forth through what it showed: the messages,
+// unless the mouse is over the combat pane. Scrolling down past the latest
+// lines goes back to showing what is new.
+// Usage: scroll up|down [row column]
+func (s *Server) scroll(c *Client, args []string) string {
+	if len(args) != 1 && len(args) != 3 {
+		return "Usage: scroll up|down\n"
+	}
+	p := c.Player
+	f := s.frameOf(p.Nickname)
+	pane, lines := paneMessages, len(f.past)
+	if len(args) == 3 {
+		if col, err := strconv.Atoi(args[2]); err == nil && col-1 < c.w-52 {
+			pane, lines = paneCombat, len(s.combatLogs[p.Nickname])
+		}
+	}
+	if f.scroll == nil {
+		f.scroll = make(map[string]int)
+	}
+	off, scrolled := f.scroll[pane]
+	switch strings.ToLower(args[0]) {
+	case "up":
+		if scrolled {
+			off++
+		}
+	case "down":
+		if off == 0 {
+			delete(f.scroll, pane)
+			return ""
+		}
+		off--
+	default:
+		return "Usage: scroll up|down\n"
+	}
+	if max := lines - maxMessageLines; off > max {
+		off = max
+	}
+	if off < 0 {
+		off = 0
+	}
+	f.scroll[pane] = off
+	return ""
+}
+
+// messagesPane keeps the new messages of the player to scroll back through,
+// and returns what the messages pane shows: the latest of them, or older ones
+// while the pane is scrolled.
+func (s *Server) messagesPane(p *area.Player, text string) string {
+	f := s.frameOf(p.Nickname)
+	lines := []string{}
+	if trimmed := strings.TrimRight(text, "\n"); trimmed != "" {
+		lines = strings.Split(trimmed, "\n")
+	}
+	f.past = append(f.past, lines...)
+	if len(f.past) > messageHistoryLines {
+		f.past = f.past[len(f.past)-messageHistoryLines:]
+	}
+	off, scrolled := f.scroll[paneMessages]
+	if !scrolled {
+		return lastLines(text, maxMessageLines)
+	}
+	// What the player is reading stays in place as new lines come in.
+	if off > 0 {
+		off += len(lines)
+		if max := len(f.past) - maxMessageLines; off > max {
+			off = max
+		}
+		f.scroll[paneMessages] = off
+	}
+	return paneWindow(f.past, off)
+}
+
+// paneWindow returns the lines a pane shows when scrolled back by the offset.
+func paneWindow(lines []string, off int) string {
+	end := len(lines) - off
+	if end < 0 {
+		end = 0
+	}
+	start := end - maxMessageLines
+	if start < 0 {
+		start = 0
+	}
+	if start == end {
+		return ""
+	}
+	return strings.Join(lines[start:end], "\n") + "\n"
+}
+
+// walk is the way to a cube a player clicked, walked a step at a time.
+type walk struct {
+	area, room string
+	steps      []int    // Directions still to go, by their index in FindExits
+	cubes      []string // Cubes the steps end on
+	at         string   // Cube the player is on before the next step
+}
+
+// walkTo sets the player walking to the cube of the room they are in, along
+// the shortest way. Moving by hand stops the walk.
+// Usage: walk <cube>
+func (s *Server) walkTo(roomsMap map[string]map[string][][]area.Cube, cl *Client, args []string) string {
+	if len(args) != 1 {
+		return "Usage: walk <cube>\n"
+	}
+	p := cl.Player
+	if _, ok := s.Wilderness[p.Area]; ok {
+		return "You can't find a way there\n"
+	}
+	steps, cubes, ok := cubePath(roomsMap[p.Area][p.Room], p.Position, args[0])
+	if !ok {
+		return "You can't find a way there\n"
+	}
+	if len(steps) == 0 {
+		return ""
+	}
+	w := &walk{area: p.Area, room: p.Room, steps: steps, cubes: cubes, at: p.Position}
+	nick := p.Nickname
+	s.walks[nick] = w
+	s.after(0, func() { s.walkStep(roomsMap, nick, w) })
+	return ""
+}
+
+// walkStep takes the next step of the walk of the player. The walk is over
+// once the player ends up anywhere but where the last step should have taken
+// them, like when someone blocks the way.
+func (s *Server) walkStep(roomsMap map[string]map[string][][]area.Cube, nick string, w *walk) {
+	cl, ok := s.clientByNick(nick)
+	if !ok || s.walks[nick] != w {
+		return
+	}
+	p := cl.Player
+	if p.Area != w.area || p.Room != w.room || p.Position != w.at || len(w.steps) == 0 {
+		delete(s.walks, nick)
+		return
+	}
+	dir := w.steps[0]
+	w.at, w.steps, w.cubes = w.cubes[0], w.steps[1:], w.cubes[1:]
+	s.dispatch(roomsMap, Event{Client: cl, EventType: directionCommands[dir], walk: true})
+	if len(w.steps) == 0 {
+		delete(s.walks, nick)
+		return
+	}
+	s.after(walkStepDelay, func() { s.walkStep(roomsMap, nick, w) })
+}
+
+// cubePath finds the shortest way across the room from one cube to another.
+// Doors and portals take the player away, so the way can only end on them.
+// It returns the directions to go in, by their index in FindExits, and the
+// cubes each step ends on.
+func cubePath(mapArray [][]area.Cube, from, to string) ([]int, []string, bool) {
+	fx, fy, ok := area.FindCube(mapArray, from)
+	if !ok {
+		return nil, nil, false
+	}
+	if from == to {
+		return nil, nil, true
+	}
+	type step struct {
+		prev [2]int
+		dir  int
+	}
+	start := [2]int{fx, fy}
+	steps := map[[2]int]step{start: {}}
+	queue := [][2]int{start}
+	for len(queue) > 0 {
+		current := queue[0]
+		queue = queue[1:]
+		for dir, m := range directionOffsets {
+			x, y := current[0]+m[0], current[1]+m[1]
+			if x < 0 || x >= len(mapArray) || y < 0 || y >= len(mapArray[x]) {
+				continue
+			}
+			next := [2]int{x, y}
+			cube := mapArray[x][y]
+			if id, _ := strconv.Atoi(cube.ID); id <= 0 {
+				continue
+			}
+			if _, seen := steps[next]; seen {
+				continue
+			}
+			steps[next] = step{prev: current, dir: dir}
+			if cube.ID == to {
+				dirs, cubes := []int{}, []string{}
+				for k := next; k != start; k = steps[k].prev {
+					dirs = append([]int{steps[k].dir}, dirs...)
+					cubes = append([]string{mapArray[k[0]][k[1]].ID}, cubes...)
+				}
+				return dirs, cubes, true
+			}
+			if cube.Type != "door" && cube.Type != "portal" {
+				queue = append(queue, next)
+			}
+		}
+	}
+	return nil, nil, false
+}
@@ -0,0 +1,238 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/droslean/thyranew/area"
+
+	log "gopkg.in/inconshreveable/log15.v2"
+)
+
+// The kinds of news.
+const (
+	newsAnnouncement = "announcement"
+	newsBoss         = "boss"
+	newsRecord       = "record"
+)
+
+const (
+	// newsItems is how many items the feeds carry.
+	newsItems = 20
+	// feedTitle is the title of the feeds.
+	feedTitle = "Thyra"
+)
+
+// recordNews subscribes the news to the events it is made of: the
+// announcements, the kills of bosses and the records of level, which top the
+// leaderboard. The record so far is read back from the news.
+func (s *Server) recordNews() error {
+	records, err := s.db.ListNews(context.Background(), func(n NewsItem) bool { return n.Kind == newsRecord }, 1)
+	if err != nil {
+		return err
+	}
+	if len(records) > 0 {
+		s.levelRecord = records[0].Level
+	}
+
+	s.subscribe(EventAnnouncement, func(e WorldEvent) {
+		s.addNews(&NewsItem{Kind: newsAnnouncement, Title: "Announcement", Text: e.Detail})
+	})
+	s.subscribe(EventBossKilled, func(e WorldEvent) {
+		text := fmt.Sprintf("%s was slain in %s", e.Detail, e.Room)
+		if name, public := s.newsworthy(e.Player); public {
+			text = fmt.Sprintf("%s slew %s in %s", name, e.Detail, e.Room)
+		}
+		s.addNews(&NewsItem{Kind: newsBoss, Title: e.Detail + " slain", Text: text})
+	})
+	s.subscribe(EventPlayerLeveled, func(e WorldEvent) {
+		cl, ok := s.clientByNick(e.Player)
+		if !ok || cl.Player.Level <= s.levelRecord {
+			return
+		}
+		s.levelRecord = cl.Player.Level
+		n := &NewsItem{Kind: newsRecord, Title: fmt.Sprintf("New record: level %d", s.levelRecord), Level: s.levelRecord}
+		n.Text = fmt.Sprintf("Someone is the first to reach level %d", s.levelRecord)
+		if name, public := s.newsworthy(e.Player); public {
+			n.Player = name
+			n.Text = fmt.Sprintf("%s is the first to reach level %d", name, s.levelRecord)
+		}
+		s.addNews(n)
+	})
+	return nil
+}
+
+// newsworthy returns the name of the player for the news, and whether the
+// news may name them at all.
+func (s *Server) newsworthy(nick string) (string, bool) {
+	cl, ok := s.clientByNick(nick)
+	if !ok || cl.Player.Flags.Private {
+		return "", false
+	}
+	return cl.Player.Nickname, true
+}
+
+// addNews puts the item in the news.
+func (s *Server) addNews(n *NewsItem) {
+	n.Time = s.now()
+	if err := s.db.AddNews(context.Background(), n); err != nil {
+		log.Error(fmt.Sprintf("Cannot add %q to the news: %v", n.Title, err))
+	}
+}
+
+// announce tells everyone online the news, which goes in the feeds as well.
+// Usage: announce <text>
+func (s *Server) announce(roomsMap map[string]map[string][][]area.Cube, p *area.Player, args []string) string {
+	if len(args) == 0 {
+		return "Usage: announce <text>\n"
+	}
+	text := strings.Join(args, " ")
+	s.broadcast(roomsMap, Broadcast{Scope: ScopeGlobal, Kind: TagSystem, Text: text + "\n"})
+	s.publish(WorldEvent{Type: EventAnnouncement, Player: p.Nickname, By: p.Nickname, Detail: text})
+	return ""
+}
+
+type rssFeed struct {
+	XMLName xml.Name   `xml:"rss"`
+	Version string     `xml:"version,attr"`
+	Channel rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+	Title       string    `xml:"title"`
+	Link        string    `xml:"link"`
+	Description string    `xml:"description"`
+	Items       []rssItem `xml:"item"`
+}
+
+type rssItem struct {
+	Title       string  `xml:"title"`
+	Description string  `xml:"description"`
+	PubDate     string  `xml:"pubDate"`
+	GUID        rssGUID `xml:"guid"`
+	Category    string  `xml:"category"`
+}
+
+type rssGUID struct {
+	ID        string `xml:",chardata"`
+	Permalink bool   `xml:"isPermaLink,attr"`
+}
+
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	Title   string      `xml:"title"`
+	ID      string      `xml:"id"`
+	Updated string      `xml:"updated"`
+	Link    atomLink    `xml:"link"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomLink struct {
+	Href string `xml:"href,attr"`
+}
+
+type atomEntry struct {
+	Title    string       `xml:"title"`
+	ID       string       `xml:"id"`
+	Updated  string       `xml:"updated"`
+	Summary  string       `xml:"summary"`
+	Category atomCategory `xml:"category"`
+}
+
+type atomCategory struct {
+	Term string `xml:"term,attr"`
+}
+
+// jsonFeed is a feed in the JSON Feed format, version 1.1.
+type jsonFeed struct {
+	Version     string         `json:"version"`
+	Title       string         `json:"title"`
+	HomePageURL string         `json:"home_page_url"`
+	FeedURL     string         `json:"feed_url"`
+	Items       []jsonFeedItem `json:"items"`
+}
+
+type jsonFeedItem struct {
+	ID            string   `json:"id"`
+	Title         string   `json:"title"`
+	ContentText   string   `json:"content_text"`
+	DatePublished string   `json:"date_published"`
+	Tags          []string `json:"tags"`
+}
+
+// serveFeed serves the latest news in the format the path ends with: rss,
+// atom or json.
+func (s *Server) serveFeed(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	news, err := s.db.ListNews(r.Context(), func(NewsItem) bool { return true }, newsItems)
+	if err != nil {
+		http.Error(w, "The news cannot be read right now", http.StatusInternalServerError)
+		return
+	}
+	home := "http://" + r.Host + "/"
+	link := func(n NewsItem) string { return fmt.Sprintf("%s#news-%d", home, n.ID) }
+	updated := time.Time{}
+	if len(news) > 0 {
+		updated = news[0].Time
+	}
+
+	switch strings.TrimPrefix(r.URL.Path, "/feed.") {
+	case "rss":
+		feed := rssFeed{Version: "2.0", Channel: rssChannel{Title: feedTitle, Link: home, Description: "News of " + feedTitle}}
+		for _, n := range news {
+			feed.Channel.Items = append(feed.Channel.Items, rssItem{
+				Title:       n.Title,
+				Description: n.Text,
+				PubDate:     n.Time.Format(time.RFC1123Z),
+				GUID:        rssGUID{ID: link(n)},
+				Category:    n.Kind,
+			})
+		}
+		w.Header().Set("Content-Type", "application/rss+xml; charset=utf-8")
+		writeXML(w, feed)
+	case "atom":
+		feed := atomFeed{Title: feedTitle, ID: home, Updated: updated.Format(time.RFC3339), Link: atomLink{Href: home}}
+		for _, n := range news {
+			feed.Entries = append(feed.Entries, atomEntry{
+				Title:    n.Title,
+				ID:       link(n),
+				Updated:  n.Time.Format(time.RFC3339),
+				Summary:  n.Text,
+				Category: atomCategory{Term: n.Kind},
+			})
+		}
+		w.Header().Set("Content-Type", "application/atom+xml; charset=utf-8")
+		writeXML(w, feed)
+	case "json":
+		feed := jsonFeed{Version: "https://jsonfeed.org/version/1.1", Title: feedTitle, HomePageURL: home, FeedURL: home + "feed.json", Items: []jsonFeedItem{}}
+		for _, n := range news {
+			feed.Items = append(feed.Items, jsonFeedItem{
+				ID:            link(n),
+				Title:         n.Title,
+				ContentText:   n.Text,
+				DatePublished: n.Time.Format(time.RFC3339),
+				Tags:          []string{n.Kind},
+			})
+		}
+		w.Header().Set("Content-Type", "application/feed+json; charset=utf-8")
+		json.NewEncoder(w).Encode(feed)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// writeXML writes the feed as an XML document.
+func writeXML(w http.ResponseWriter, feed interface{}) {
+	w.Write([]byte(xml.Header))
+	if err := xml.NewEncoder(w).Encode(feed); err != nil {
+		log.Error(fmt.Sprintf("Cannot write the feed: %v", err))
+	}
+}
@@ -0,0 +1,104 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/droslean/thyranew/area"
+
+	log "gopkg.in/inconshreveable/log15.v2"
+)
+
+// maxInboxShown is how many notifications fit on the message canvas at once.
+const maxInboxShown = maxMessageLines - 1
+
+// notify leaves a notification in the inbox of the player. Players online are
+// told about it right away, the rest find it when they log in.
+func (s *Server) notify(roomsMap map[string]map[string][][]area.Cube, nick, from, text string) error {
+	n := Notification{From: from, Text: text, Time: time.Now()}
+	if err := s.db.AddNotification(context.Background(), nick, n); err != nil {
+		log.Error(fmt.Sprintf("Cannot notify %q: %v", nick, err))
+		return err
+	}
+	if cl, ok := s.clientByNick(nick); ok {
+		s.godPrintRoom([]Client{*cl}, roomsMap, tagged(TagSystem, "You have a new notification\n"), "")
+	}
+	return nil
+}
+
+// loginNotice tells the player about the unread notifications, and how rested
+// they are, once per login.
+func (s *Server) loginNotice(p *area.Player) string {
+	if p.Welcomed {
+		return ""
+	}
+	p.Welcomed = true
+	notice := restedNotice(p) + deletionNotice(p)
+	inbox, err := s.db.GetNotifications(s.ctxOf(p.Nickname), p.Nickname)
+	if err != nil {
+		log.Error(fmt.Sprintf("Cannot read the notifications of %q: %v", p.Nickname, err))
+		return notice
+	}
+	unread := 0
+	for _, n := range inbox {
+		if !n.Read {
+			unread++
+		}
+	}
+	if unread == 0 {
+		return notice
+	}
+	return notice + tagged(TagSystem, fmt.Sprintf("You have %d unread notifications, type notifications to read them\n", unread))
+}
+
+// notifications shows the unread notifications of the player and marks them read.
+// Usage: notifications [all|clear]
+func (s *Server) notifications(p *area.Player, args []string) string {
+	inbox, err := s.db.GetNotifications(s.ctxOf(p.Nickname), p.Nickname)
+	if err != nil {
+		log.Error(fmt.Sprintf("Cannot read the notifications of %q: %v", p.Nickname, err))
+		return "Your notifications can't be read right now\n"
+	}
+
+	option := ""
+	if len(args) > 0 {
+		option = strings.ToLower(args[0])
+	}
+	switch option {
+	case "clear":
+		unread := []Notification{}
+		for _, n := range inbox {
+			if !n.Read {
+				unread = append(unread, n)
+			}
+		}
+		if err := s.db.PutNotifications(s.ctxOf(p.Nickname), p.Nickname, unread); err != nil {
+			return "Your notifications can't be cleared right now\n"
+		}
+		return fmt.Sprintf("Cleared %d notifications\n", len(inbox)-len(unread))
+	case "", "all":
+	default:
+		return "Usage: notifications [all|clear]\n"
+	}
+
+	lines := []string{}
+	for i := range inbox {
+		if inbox[i].Read && option != "all" {
+			continue
+		}
+		if len(lines) < maxInboxShown {
+			n := inbox[i]
+			lines = append(lines, fmt.Sprintf("[%s] %s: %s", n.Time.Format("Jan 2 15:04"), n.From, n.Text))
+			inbox[i].Read = true
+		}
+	}
+	if len(lines) == 0 {
+		return "You have no new notifications\n"
+	}
+	if err := s.db.PutNotifications(s.ctxOf(p.Nickname), p.Nickname, inbox); err != nil {
+		log.Error(fmt.Sprintf("Cannot update the notifications of %q: %v", p.Nickname, err))
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
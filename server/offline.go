@@ -0,0 +1,183 @@
+package server
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/droslean/thyranew/area"
+	"github.com/gothyra/toml"
+	"golang.org/x/crypto/ssh"
+)
+
+// The commands run next to the server, like adding players and taking
+// backups, work on its files while it is stopped.
+
+// Roles are what players may be made, along with plain players.
+var Roles = []string{"builder", "admin"}
+
+// AddPlayer saves a new character in the data directory, with the role, and
+// lets the keys in as theirs, so builders reach the files of the world with
+// them before they ever played.
+func AddPlayer(db *Database, dataDir, nick, role string, keys []ssh.PublicKey) error {
+	if !IsValidUsername(nick) {
+		return fmt.Errorf("%q is not a valid name", nick)
+	}
+	known := role == ""
+	for _, r := range Roles {
+		known = known || r == role
+	}
+	if !known {
+		return fmt.Errorf("unknown role %q, it is one of %s", role, strings.Join(Roles, ", "))
+	}
+	s := &Server{dataDir: dataDir, Players: make(map[string]area.Player)}
+	_, path := s.getPlayerFileName(nick)
+	if _, err := os.Stat(path); err == nil {
+		return fmt.Errorf("%s exists already", nick)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	p := newPlayer(nick, time.Now().UnixNano())
+	p.Role = role
+	if err := s.savePlayer(&p); err != nil {
+		return err
+	}
+	for _, key := range keys {
+		if err := db.RecordLogin(context.Background(), nick, KeyFingerprint(key), "", time.Now()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReadPlayers reads the players saved in the data directory, all of them or
+// those named, in the order of their names.
+func ReadPlayers(dataDir string, nicks ...string) ([]area.Player, error) {
+	s := &Server{dataDir: dataDir}
+	if len(nicks) == 0 {
+		files, err := filepath.Glob(filepath.Join(dataDir, "player", "*.toml"))
+		if err != nil {
+			return nil, err
+		}
+		for _, f := range files {
+			nicks = append(nicks, strings.TrimSuffix(filepath.Base(f), ".toml"))
+		}
+	}
+	sort.Strings(nicks)
+
+	players := []area.Player{}
+	for _, nick := range nicks {
+		ok, path := s.getPlayerFileName(nick)
+		if !ok {
+			return nil, fmt.Errorf("%q is not a valid name", nick)
+		}
+		content, err := ioutil.ReadFile(path)
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("there is no player %s", nick)
+		}
+		if err != nil {
+			return nil, err
+		}
+		p := area.Player{}
+		if _, err := toml.Decode(string(content), &p); err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+		players = append(players, p)
+	}
+	return players, nil
+}
+
+// Backup writes the database, the static directory and the data directory,
+// if it is another, to a gzipped tar archive. The database goes in as
+// thyra.db, and the directories as static and data. The directories to leave
+// out, like where the backups are kept, are given last.
+func Backup(w io.Writer, db *Database, staticDir, dataDir string, leaveOut ...string) error {
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+
+	var snapshot bytes.Buffer
+	if err := db.Backup(&snapshot); err != nil {
+		return err
+	}
+	err := tw.WriteHeader(&tar.Header{Name: "thyra.db", Mode: 0600, Size: int64(snapshot.Len()), ModTime: time.Now(), Typeflag: tar.TypeReg})
+	if err != nil {
+		return err
+	}
+	if _, err := tw.Write(snapshot.Bytes()); err != nil {
+		return err
+	}
+
+	if err := archiveDir(tw, staticDir, "static", leaveOut); err != nil {
+		return err
+	}
+	if absPath(dataDir) != absPath(staticDir) {
+		if err := archiveDir(tw, dataDir, "data", leaveOut); err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// archiveDir adds the files of the directory to the archive, under the name.
+// Files half written, which end in .tmp, are left out, and so are the
+// directories to leave out.
+func archiveDir(tw *tar.Writer, dir, name string, leaveOut []string) error {
+	return filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		for _, out := range leaveOut {
+			if info.IsDir() && absPath(path) == absPath(out) {
+				return filepath.SkipDir
+			}
+		}
+		if strings.HasSuffix(path, ".tmp") || !info.Mode().IsRegular() && !info.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		hdr, err := tar.FileInfoHeader(info, "")
+		if err != nil {
+			return err
+		}
+		hdr.Name = filepath.ToSlash(filepath.Join(name, rel))
+		if info.IsDir() {
+			hdr.Name += "/"
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = io.Copy(tw, f)
+		return err
+	})
+}
+
+func absPath(path string) string {
+	if a, err := filepath.Abs(path); err == nil {
+		return a
+	}
+	return path
+}
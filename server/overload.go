@@ -0,0 +1,71 @@
+package server
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/droslean/thyranew/area"
+
+	log "gopkg.in/inconshreveable/log15.v2"
+)
+
+const (
+	// overloadAfter is how far behind a tick can leave the world, counting
+	// the wait for it and the time it took, before the world sheds load.
+	overloadAfter = time.Second
+	// calmTicks is how many ticks in a row the world has to keep up with
+	// before it stops shedding load.
+	calmTicks = 3
+)
+
+// checkLoad tells from the last tick whether the world falls behind. While it
+// does, it sheds load: NPCs act less often and ambient messages are left out,
+// until it keeps up again. Admins hear of it either way.
+func (w *World) checkLoad(roomsMap map[string]map[string][][]area.Cube, lag, took time.Duration) {
+	behind := lag+took > overloadAfter || len(w.s.Events) > cap(w.s.Events)/2
+	switch {
+	case behind && !w.shedding():
+		atomic.StoreInt32(&w.shed, 1)
+		w.calm = 0
+		msg := fmt.Sprintf("The world is falling behind: the tick waited %s and took %s, %d commands are waiting. Shedding load.",
+			lag.Round(time.Millisecond), took.Round(time.Millisecond), len(w.s.Events))
+		log.Warn(msg)
+		w.s.alertAdmins(roomsMap, msg)
+	case behind:
+		w.calm = 0
+	case w.shedding():
+		w.calm++
+		if w.calm >= calmTicks {
+			atomic.StoreInt32(&w.shed, 0)
+			msg := "The world keeps up again. No more shedding load."
+			log.Info(msg)
+			w.s.alertAdmins(roomsMap, msg)
+		}
+	}
+}
+
+// shedding reports whether the world sheds load. It is safe to call from
+// anywhere.
+func (w *World) shedding() bool {
+	return atomic.LoadInt32(&w.shed) == 1
+}
+
+// alertAdmins tells the admins online about the state of the server.
+func (s *Server) alertAdmins(roomsMap map[string]map[string][][]area.Cube, msg string) {
+	s.broadcast(roomsMap, Broadcast{Kind: TagSystem, Text: msg + "\n", Filter: isAdmin})
+}
+
+// npcDelay returns how long NPCs wait between their actions.
+func (s *Server) npcDelay() time.Duration {
+	if s.world.shedding() {
+		return 2 * npcStepDelay
+	}
+	return npcStepDelay
+}
+
+// ambient reports whether messages that only add colour are sent. They are
+// the first to go when the world falls behind.
+func (s *Server) ambient() bool {
+	return !s.world.shedding()
+}
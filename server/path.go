@@ -0,0 +1,56 @@
+package server
+
+import "github.com/droslean/thyranew/area"
+
+type roomKey struct {
+	area, room string
+}
+
+// roomPath finds the shortest way from one room to another through open doors.
+// It returns the exits to follow, one for every room on the way.
+func roomPath(roomsMap map[string]map[string][][]area.Cube, fromArea, fromRoom, toArea, toRoom string) ([]area.Exit, bool) {
+	from := roomKey{fromArea, fromRoom}
+	to := roomKey{toArea, toRoom}
+	if from == to {
+		return nil, true
+	}
+
+	type step struct {
+		prev roomKey
+		exit area.Exit
+	}
+	steps := map[roomKey]step{from: {}}
+	queue := []roomKey{from}
+
+	for len(queue) > 0 {
+		current := queue[0]
+		queue = queue[1:]
+
+		mapArray := roomsMap[current.area][current.room]
+		for _, d := range area.Doors(mapArray) {
+			door := mapArray[d[0]][d[1]]
+			exit := door.Exits[0]
+			next := roomKey{exit.ToArea, exit.ToRoom}
+			if door.Closed {
+				continue
+			}
+			if _, seen := steps[next]; seen {
+				continue
+			}
+			// Only rooms can be walked through, not the wilderness.
+			if _, ok := roomsMap[next.area][next.room]; !ok {
+				continue
+			}
+			steps[next] = step{prev: current, exit: exit}
+			if next == to {
+				path := []area.Exit{}
+				for k := to; k != from; k = steps[k].prev {
+					path = append([]area.Exit{steps[k].exit}, path...)
+				}
+				return path, true
+			}
+			queue = append(queue, next)
+		}
+	}
+	return nil, false
+}
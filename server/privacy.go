@@ -0,0 +1,229 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/droslean/thyranew/area"
+	"github.com/gothyra/toml"
+
+	log "gopkg.in/inconshreveable/log15.v2"
+)
+
+const (
+	// defaultCoolingOff is how many days players have to change their mind
+	// about deleting their account, unless server.toml says otherwise.
+	defaultCoolingOff = 7
+	// privacySweepTicks is every how many ticks the accounts due are deleted,
+	// and what is past its retention dropped.
+	privacySweepTicks = 360
+	// privacyTimeout is how long a sweep may take.
+	privacyTimeout = 5 * time.Minute
+	// formerPlayer stands in for the names of the players whose accounts were
+	// deleted, in what is kept of the world.
+	formerPlayer = "a former player"
+)
+
+// PrivacyConfig says how players delete their accounts, and how long what
+// tells of the players is kept. How long the chat and the commands are kept
+// is up to the chat log.
+type PrivacyConfig struct {
+	// CoolingOff is how many days go by between players asking for their
+	// account to be deleted and it being deleted, while they can change their
+	// mind.
+	CoolingOff int `toml:"coolingoff"`
+	// AuditDays is how many days the entries of the audit log are kept, and
+	// LoginDays the key fingerprints and the IPs players logged in with.
+	// They are kept for good when zero.
+	AuditDays int `toml:"auditdays"`
+	LoginDays int `toml:"logindays"`
+}
+
+// coolingOff returns how long players have to change their mind about
+// deleting their account.
+func (s *Server) coolingOff() time.Duration {
+	days := s.config.Privacy.CoolingOff
+	if days <= 0 {
+		days = defaultCoolingOff
+	}
+	return time.Duration(days) * 24 * time.Hour
+}
+
+// deleteAccount deletes the account of the player, once they confirm and the
+// cooling off is over, unless they cancel it before.
+// Usage: delete account|cancel
+func (s *Server) deleteAccount(cl *Client, args []string) string {
+	p := cl.Player
+	option := ""
+	if len(args) == 1 {
+		option = strings.ToLower(args[0])
+	}
+	switch option {
+	case "account":
+		if !p.Deleting.IsZero() {
+			return fmt.Sprintf("Your account is deleted on %s already, type delete cancel to keep it\n", p.Deleting.Format("2006-01-02 15:04"))
+		}
+		cooling := s.coolingOff()
+		question := fmt.Sprintf("Delete %s and all that is kept of you in %d days?", p.Nickname, int(cooling.Hours()/24))
+		s.openModal(cl, newConfirm(question, func(s *Server, c *Client) string {
+			c.Player.Deleting = s.now().Add(cooling)
+			log.Info(fmt.Sprintf("%q asked for their account to be deleted on %s", c.Player.Nickname, c.Player.Deleting.Format(time.RFC3339)))
+			return s.savePreferences(c.Player, fmt.Sprintf("Your account is deleted on %s. Until then, type delete cancel to keep it, or export for a copy of your character\n",
+				c.Player.Deleting.Format("2006-01-02 15:04")))
+		}, nil))
+		return ""
+	case "cancel":
+		if p.Deleting.IsZero() {
+			return "Your account is not to be deleted\n"
+		}
+		p.Deleting = time.Time{}
+		log.Info(fmt.Sprintf("%q kept their account", p.Nickname))
+		return s.savePreferences(p, "Your account is kept\n")
+	}
+	return "Usage: delete account|cancel\n"
+}
+
+// deletionNotice reminds players logging in that their account is to be
+// deleted.
+func deletionNotice(p *area.Player) string {
+	if p.Deleting.IsZero() {
+		return ""
+	}
+	return tagged(TagSystem, fmt.Sprintf("Your account is deleted on %s, type delete cancel to keep it\n", p.Deleting.Format("2006-01-02 15:04")))
+}
+
+// sweepPrivacy deletes the accounts whose cooling off is over, and drops what
+// is past its retention, off the world.
+func (s *Server) sweepPrivacy() {
+	if s.ticks%privacySweepTicks != 0 {
+		return
+	}
+	now := s.now()
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), privacyTimeout)
+		defer cancel()
+		s.pruneRetention(ctx, now)
+		for _, nick := range s.dueDeletions(now) {
+			purged := false
+			if !s.inWorld(ctx, func() { purged = s.purgeAccount(nick, now) }) {
+				return
+			}
+			if purged {
+				s.forgetAccount(ctx, nick)
+			}
+		}
+	}()
+}
+
+// pruneRetention drops the entries of the audit log and the logins past
+// their retention.
+func (s *Server) pruneRetention(ctx context.Context, now time.Time) {
+	c := s.config.Privacy
+	if c.AuditDays > 0 {
+		dropped, err := s.db.PruneAudit(ctx, now.AddDate(0, 0, -c.AuditDays))
+		if err != nil {
+			log.Error(fmt.Sprintf("Cannot prune the audit log: %v", err))
+		} else if dropped > 0 {
+			log.Info(fmt.Sprintf("Dropped %d entries of the audit log older than %d days", dropped, c.AuditDays))
+		}
+	}
+	if c.LoginDays > 0 {
+		if err := s.db.PruneLogins(ctx, now.AddDate(0, 0, -c.LoginDays)); err != nil {
+			log.Error(fmt.Sprintf("Cannot prune the logins: %v", err))
+		}
+	}
+}
+
+// dueDeletions returns the players whose accounts are due to be deleted. Only
+// when the deletion is due is read of their files.
+func (s *Server) dueDeletions(now time.Time) []string {
+	files, err := filepath.Glob(filepath.Join(s.dataDir, "player", "*.toml"))
+	if err != nil {
+		log.Error(fmt.Sprintf("Cannot list the players: %v", err))
+		return nil
+	}
+	due := []string{}
+	for _, file := range files {
+		p := struct {
+			Nickname string    `toml:"nickname"`
+			Deleting time.Time `toml:"deleting"`
+		}{}
+		if _, err := toml.DecodeFile(file, &p); err != nil || p.Deleting.IsZero() || now.Before(p.Deleting) {
+			continue
+		}
+		due = append(due, p.Nickname)
+	}
+	return due
+}
+
+// purgeAccount removes the files of the player whose account is due to be
+// deleted: their character, its exports and their recordings. Players online
+// are left until they log out. It reports whether the account was deleted.
+func (s *Server) purgeAccount(nick string, now time.Time) bool {
+	if _, online := s.clientByNick(nick); online {
+		return false
+	}
+	p, found := s.playerOf(nick)
+	if !found || p.Deleting.IsZero() || now.Before(p.Deleting) {
+		return false
+	}
+	_, file := s.getPlayerFileName(nick)
+	for _, path := range []string{file, file + ".migrated"} {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			log.Error(fmt.Sprintf("Cannot delete the account of %q: %v", nick, err))
+			return false
+		}
+	}
+	delete(s.Players, nick)
+	for _, dir := range []string{s.exportsDir(nick), s.recordingsDir(nick)} {
+		if err := os.RemoveAll(dir); err != nil {
+			log.Error(fmt.Sprintf("Cannot delete %s of %q: %v", dir, nick, err))
+		}
+	}
+	log.Info(fmt.Sprintf("Deleted the account of %q", nick))
+	return true
+}
+
+// forgetAccount purges the rest of the personal data of the player whose
+// account was deleted: where they logged in from, what they said and were
+// told, and what was sent them. What they did in the world stays, without
+// their name.
+func (s *Server) forgetAccount(ctx context.Context, nick string) {
+	if err := s.db.ForgetPlayer(ctx, nick, formerPlayer); err != nil {
+		log.Error(fmt.Sprintf("Cannot forget %q in the database: %v", nick, err))
+	}
+	if err := s.chatLog.forget(nick, formerPlayer); err != nil {
+		log.Error(fmt.Sprintf("Cannot forget %q in the chat log: %v", nick, err))
+	}
+}
+
+// anonymize puts alias in place of the name of the player in the text,
+// where it stands as a name of its own.
+func anonymize(text, nick, alias string) string {
+	if nick == "" || !strings.Contains(strings.ToLower(text), strings.ToLower(nick)) {
+		return text
+	}
+	var b strings.Builder
+	from := 0
+	for _, at := range regexp.MustCompile(`(?i)`+regexp.QuoteMeta(nick)).FindAllStringIndex(text, -1) {
+		if (at[0] > 0 && nameChar(text[at[0]-1])) || (at[1] < len(text) && nameChar(text[at[1]])) {
+			continue
+		}
+		b.WriteString(text[from:at[0]])
+		b.WriteString(alias)
+		from = at[1]
+	}
+	b.WriteString(text[from:])
+	return b.String()
+}
+
+// nameChar reports whether the character can be part of the name of a
+// player.
+func nameChar(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_' || c == '-'
+}
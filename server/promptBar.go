@@ -1,9 +1,11 @@
 package server
 
 import (
+	"context"
 	"fmt"
 	"strings"
 	"sync"
+	"sync/atomic"
 
 	"github.com/jpillora/ansi"
 	log "gopkg.in/inconshreveable/log15.v2"
@@ -145,18 +147,29 @@ var (
 	}
 )
 
-func (p *PromptBar) promptBar(player *Client, eventCh chan Event, stopCh <-chan struct{}, wg *sync.WaitGroup) {
+func (p *PromptBar) promptBar(ctx context.Context, player *Client, eventCh chan Event, wg *sync.WaitGroup) {
 	defer wg.Done()
 
 	for {
 		var b []byte
 		select {
 		case b = <-p.promptChan:
-		case <-stopCh:
+		case <-ctx.Done():
 			log.Info("promptBar is exiting.")
 			return
 		}
 
+		// While the world shows a modal, it is steered with the arrows,
+		// enter on an empty prompt, and escape.
+		if key := p.modalKey(player, b); key != "" {
+			select {
+			case eventCh <- Event{Client: player, key: key}:
+			case <-ctx.Done():
+				return
+			}
+			continue
+		}
+
 		// Parse Arrows
 		if len(b) == 3 && b[0] == ansi.Esc && b[1] == 91 {
 			cursorBehavor := []byte{0, 0, 0}
@@ -245,7 +258,7 @@ func (p *PromptBar) promptBar(player *Client, eventCh chan Event, stopCh <-chan
 		// Enter key
 		case n == ENTER_KEY:
 			if len(p.command) > 0 {
-				p.enterKey(player, eventCh, stopCh)
+				p.enterKey(ctx, player, eventCh)
 			}
 
 		// Space key
@@ -273,7 +286,7 @@ func (p *PromptBar) promptBar(player *Client, eventCh chan Event, stopCh <-chan
 			}
 
 		// Delete Key
-		case n == DELETE_KEY && b[2] == 51:
+		case n == DELETE_KEY && len(b) > 2 && b[2] == 51:
 			if p.position < len(p.command) {
 				p.deletePartofCommand(p.position)
 				p.clearPromptBar(player)
@@ -290,6 +303,25 @@ func (p *PromptBar) promptBar(player *Client, eventCh chan Event, stopCh <-chan
 	}
 }
 
+// modalKey returns the key of a modal the bytes are, empty when no modal is
+// open or they are something else.
+func (p *PromptBar) modalKey(player *Client, b []byte) string {
+	if atomic.LoadInt32(&player.modalOpen) == 0 || len(b) == 0 {
+		return ""
+	}
+	switch {
+	case len(b) == 3 && b[0] == ansi.Esc && b[1] == 91 && b[2] == ARROW_UP:
+		return keyUp
+	case len(b) == 3 && b[0] == ansi.Esc && b[1] == 91 && b[2] == ARROW_DOWN:
+		return keyDown
+	case len(b) == 1 && b[0] == ansi.Esc:
+		return keyEscape
+	case len(b) == 1 && b[0] == ENTER_KEY && len(p.command) == 0:
+		return keyEnter
+	}
+	return ""
+}
+
 func (p *PromptBar) getCommandAsString() string {
 	cmd := ""
 	for i := range p.command {
@@ -312,8 +344,9 @@ func (p *PromptBar) fillPromptBar(player *Client) string {
 	return promptBar
 }
 
+// drawPromptBar draws the line below the prompt. The line above it is the
+// status line, which the server draws with the rest of the screen.
 func (p *PromptBar) drawPromptBar(player *Client) {
-	player.conn.Write([]byte(string(ansi.Goto(uint16(player.h)-2, 1)) + p.fillPromptBar(player)))
 	player.conn.Write([]byte(string(ansi.Goto(uint16(player.h), 1)) + p.fillPromptBar(player)))
 	player.conn.Write(ansi.Goto(uint16(player.h)-1, 1))
 }
@@ -364,8 +397,8 @@ func (p *PromptBar) arrowDown(player *Client) {
 }
 
 // This function sends an event to s.Events channel.
-// GOD thread will handle those events.
-func (p *PromptBar) enterKey(player *Client, eventCh chan Event, stopCh <-chan struct{}) {
+// The world will handle those events.
+func (p *PromptBar) enterKey(ctx context.Context, player *Client, eventCh chan Event) {
 	p.clearPromptBar(player)
 	player.conn.Write(ansi.CursorHide)
 
@@ -377,7 +410,7 @@ func (p *PromptBar) enterKey(player *Client, eventCh chan Event, stopCh <-chan s
 	event := Event{Client: player, EventType: p.getCommandAsString()}
 	select {
 	case eventCh <- event:
-	case <-stopCh:
+	case <-ctx.Done():
 		return
 	}
 
@@ -0,0 +1,303 @@
+package server
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/droslean/thyranew/area"
+	"golang.org/x/crypto/ssh"
+
+	log "gopkg.in/inconshreveable/log15.v2"
+)
+
+const (
+	// defaultRecordQuota is how many kilobytes of recordings each player can
+	// keep, unless the server says otherwise.
+	defaultRecordQuota = 1024
+	// maxPlaybackPause is the longest pause of a playback, however long the
+	// player stayed idle.
+	maxPlaybackPause = 2 * time.Second
+)
+
+// recordingChannel is the connection of a client, which copies the output to
+// the recording of the session while there is one.
+type recordingChannel struct {
+	ssh.Channel
+	mu        sync.Mutex
+	file      *os.File
+	start     time.Time
+	written   int64
+	remaining int64 // What is left of the quota of the player
+}
+
+// Write sends the output to the client, and to the recording.
+func (rc *recordingChannel) Write(data []byte) (int, error) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	if rc.file != nil {
+		rc.record(data)
+	}
+	return rc.Channel.Write(data)
+}
+
+// record adds an output event to the recording, in the asciicast format.
+// Recordings that reach the quota stop there.
+func (rc *recordingChannel) record(data []byte) {
+	line, err := json.Marshal([]interface{}{time.Since(rc.start).Seconds(), "o", string(data)})
+	if err != nil {
+		return
+	}
+	line = append(line, '\n')
+	if int64(len(line)) > rc.remaining {
+		log.Info(fmt.Sprintf("Recording %s reached the quota", rc.file.Name()))
+		rc.stop()
+		return
+	}
+	if _, err := rc.file.Write(line); err != nil {
+		log.Error(fmt.Sprintf("Cannot write to %s: %v", rc.file.Name(), err))
+		rc.stop()
+		return
+	}
+	rc.remaining -= int64(len(line))
+}
+
+// recording reports whether the session is being recorded.
+func (rc *recordingChannel) recording() bool {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	return rc.file != nil
+}
+
+// stop closes the recording. The lock must be held.
+func (rc *recordingChannel) stop() {
+	if rc.file != nil {
+		rc.file.Close()
+		rc.file = nil
+	}
+}
+
+// recordingsDir returns where the recordings of the player are kept.
+func (s *Server) recordingsDir(nick string) string {
+	return filepath.Join(s.dataDir, "recordings", nick)
+}
+
+// recordings returns the files of the recordings of the player, oldest first.
+func (s *Server) recordings(nick string) ([]os.FileInfo, error) {
+	files, err := ioutil.ReadDir(s.recordingsDir(nick))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].Name() < files[j].Name() })
+	return files, nil
+}
+
+// startRecording records the session of the client into a new file. The oldest
+// recordings of the player are dropped to make room under the quota.
+func (s *Server) startRecording(c *Client) error {
+	if c.recorder.recording() {
+		return nil
+	}
+	quota := int64(s.config.RecordQuota)
+	if quota <= 0 {
+		quota = defaultRecordQuota
+	}
+	quota *= 1024
+
+	dir := s.recordingsDir(c.Player.Nickname)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	files, err := s.recordings(c.Player.Nickname)
+	if err != nil {
+		return err
+	}
+	used := int64(0)
+	for _, f := range files {
+		used += f.Size()
+	}
+	for len(files) > 0 && used > quota/2 {
+		if err := os.Remove(filepath.Join(dir, files[0].Name())); err != nil {
+			return err
+		}
+		used -= files[0].Size()
+		files = files[1:]
+	}
+
+	now := time.Now()
+	file, err := os.Create(filepath.Join(dir, now.Format("20060102-150405")+".cast"))
+	if err != nil {
+		return err
+	}
+	header, _ := json.Marshal(map[string]interface{}{"version": 2, "width": c.w, "height": c.h, "timestamp": now.Unix()})
+	if _, err := file.Write(append(header, '\n')); err != nil {
+		file.Close()
+		return err
+	}
+
+	c.recorder.mu.Lock()
+	c.recorder.file = file
+	c.recorder.start = now
+	c.recorder.remaining = quota - used - int64(len(header)) - 1
+	c.recorder.mu.Unlock()
+	log.Info(fmt.Sprintf("Recording the session of %q into %s", c.Player.Nickname, file.Name()))
+	return nil
+}
+
+// stopRecording closes the recording of the session of the client.
+func stopRecording(c *Client) {
+	c.recorder.mu.Lock()
+	defer c.recorder.mu.Unlock()
+	c.recorder.stop()
+}
+
+// record turns the recording of sessions on or off. Admins can turn it on or
+// off for anyone.
+// Usage: record [on|off], record <player> on|off
+func (s *Server) record(cl *Client, args []string) string {
+	target := cl
+	if len(args) == 2 {
+		if !isAdmin(cl.Player) {
+			return "Usage: record [on|off]\n"
+		}
+		other, ok := s.clientByNick(args[0])
+		if !ok {
+			return fmt.Sprintf("%s is not online\n", args[0])
+		}
+		target, args = other, args[1:]
+	}
+
+	if len(args) == 0 {
+		if target.recorder.recording() {
+			return "Your session is being recorded\n"
+		}
+		return "Your session is not being recorded\n"
+	}
+	switch strings.ToLower(args[0]) {
+	case "on":
+		target.Player.Recording = true
+		if err := s.startRecording(target); err != nil {
+			log.Error(fmt.Sprintf("Cannot record the session of %q: %v", target.Player.Nickname, err))
+			target.Player.Recording = false
+			return "The session can't be recorded right now\n"
+		}
+	case "off":
+		target.Player.Recording = false
+		stopRecording(target)
+	default:
+		return "Usage: record [on|off]\n"
+	}
+	if err := s.savePlayer(target.Player); err != nil {
+		log.Error(fmt.Sprintf("Cannot save %q: %v", target.Player.Nickname, err))
+	}
+	if target != cl {
+		return fmt.Sprintf("Recording of %s is %s\n", target.Player.Nickname, strings.ToLower(args[0]))
+	}
+	return fmt.Sprintf("Recording is %s\n", strings.ToLower(args[0]))
+}
+
+// playback replays a recording of the player on the screen of the admin. With
+// no number it lists the recordings instead.
+// Usage: playback <player> [number|stop]
+func (s *Server) playback(roomsMap map[string]map[string][][]area.Cube, cl *Client, args []string) string {
+	if len(args) == 1 && strings.ToLower(args[0]) == "stop" {
+		if stop, ok := s.playbacks[cl.Player.Nickname]; ok {
+			close(stop)
+			delete(s.playbacks, cl.Player.Nickname)
+		}
+		return "Playback stopped\n"
+	}
+	if len(args) == 0 {
+		return "Usage: playback <player> [number|stop]\n"
+	}
+	files, err := s.recordings(args[0])
+	if err != nil {
+		log.Error(fmt.Sprintf("Cannot list the recordings of %q: %v", args[0], err))
+	}
+	if len(files) == 0 {
+		return fmt.Sprintf("There are no recordings of %s\n", args[0])
+	}
+
+	if len(args) == 1 {
+		lines := []string{}
+		// The latest recordings are the ones that fit.
+		first := 0
+		if len(files) > maxMessageLines {
+			first = len(files) - maxMessageLines
+		}
+		for i := first; i < len(files); i++ {
+			lines = append(lines, fmt.Sprintf("%d) %s %d KB", i+1, strings.TrimSuffix(files[i].Name(), ".cast"), files[i].Size()/1024))
+		}
+		return strings.Join(lines, "\n") + "\n"
+	}
+
+	n, err := strconv.Atoi(args[1])
+	if err != nil || n < 1 || n > len(files) {
+		return "There is no such recording\n"
+	}
+	if _, ok := s.playbacks[cl.Player.Nickname]; ok {
+		return "You are already playing back a recording\n"
+	}
+	file, err := os.Open(filepath.Join(s.recordingsDir(args[0]), files[n-1].Name()))
+	if err != nil {
+		log.Error(fmt.Sprintf("Cannot open recording: %v", err))
+		return "The recording can't be played back\n"
+	}
+
+	stop := make(chan struct{})
+	s.playbacks[cl.Player.Nickname] = stop
+	cl.Player.Replaying = true
+	admin := *cl
+	go func() {
+		replay(admin, file, stop)
+		file.Close()
+		s.worldTasks <- func() {
+			if s.playbacks[admin.Player.Nickname] == stop {
+				delete(s.playbacks, admin.Player.Nickname)
+			}
+			admin.Player.Replaying = false
+			s.godPrintRoom([]Client{admin}, roomsMap, "Playback is over\n", "")
+		}
+	}()
+	return ""
+}
+
+// replay writes the output of the recording to the client, keeping the pace
+// it was recorded at.
+func replay(c Client, file *os.File, stop <-chan struct{}) {
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	// The first line is the header.
+	scanner.Scan()
+	last := 0.0
+	for scanner.Scan() {
+		var event []interface{}
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil || len(event) != 3 {
+			continue
+		}
+		at, _ := event[0].(float64)
+		data, _ := event[2].(string)
+		pause := time.Duration((at - last) * float64(time.Second))
+		if pause > maxPlaybackPause {
+			pause = maxPlaybackPause
+		}
+		last = at
+		select {
+		case <-stop:
+			return
+		case <-time.After(pause):
+		}
+		c.writeString(data)
+	}
+}
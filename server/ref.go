@@ -0,0 +1,133 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/droslean/thyranew/area"
+
+	log "gopkg.in/inconshreveable/log15.v2"
+)
+
+// uid returns the UID of the named thing of the given kind. Zero means the
+// database could not be reached, and the thing goes without for now.
+func (s *Server) uid(kind, name string) area.UID {
+	uid, err := s.db.GetUID(context.Background(), kind, name)
+	if err != nil {
+		log.Error(fmt.Sprintf("No UID for %s %q: %v", kind, name, err))
+		return 0
+	}
+	return uid
+}
+
+// npcName names the NPC for its UID. NPCs of the same name in an area are told
+// apart by the order they come in.
+func npcName(areaName, name string, nth int) string {
+	return fmt.Sprintf("%s/%s/%d", areaName, name, nth)
+}
+
+// roomRef returns the ref of the room.
+func (s *Server) roomRef(areaName, room string) area.Ref {
+	return area.Ref{Kind: area.KindRoom, UID: s.uid(area.KindRoom, areaName+"/"+room)}
+}
+
+// itemRef returns the ref of the item. All the copies of an item share it.
+func (s *Server) itemRef(name string) area.Ref {
+	return area.Ref{Kind: area.KindItem, UID: s.uid(area.KindItem, strings.ToLower(name))}
+}
+
+// npcByUID returns the NPC with the given UID along with the name of its area.
+func (s *Server) npcByUID(uid area.UID) (*area.NPC, string) {
+	for name, a := range s.Areas {
+		for i := range a.NPCs {
+			if a.NPCs[i].UID == uid {
+				return &a.NPCs[i], name
+			}
+		}
+	}
+	return nil, ""
+}
+
+// resolve finds the thing the ref refers to. It returns its name, and where it
+// is for NPCs and rooms. Items are nowhere in particular.
+func (s *Server) resolve(ref area.Ref) (name, areaName, room string, err error) {
+	if ref.Kind == area.KindNPC {
+		npc, areaName := s.npcByUID(ref.UID)
+		if npc == nil {
+			return "", "", "", fmt.Errorf("%s is not in the world", ref)
+		}
+		return npc.Name, areaName, npc.Room, nil
+	}
+
+	kind, key, err := s.db.UIDName(context.Background(), ref.UID)
+	if err != nil {
+		return "", "", "", err
+	}
+	if kind != ref.Kind {
+		return "", "", "", fmt.Errorf("%s refers to nothing", ref)
+	}
+	if kind == area.KindItem {
+		return key, "", "", nil
+	}
+	parts := strings.SplitN(key, "/", 2)
+	if len(parts) != 2 {
+		return "", "", "", fmt.Errorf("%s refers to nothing", ref)
+	}
+	if _, ok := s.Areas[parts[0]].Rooms[parts[1]]; !ok {
+		return "", "", "", fmt.Errorf("%s is not in the world", ref)
+	}
+	return parts[1], parts[0], parts[1], nil
+}
+
+// ref shows admins the refs of things, and what refs refer to.
+// Usage: ref here, ref npc <name>, ref item <name> or ref <ref>
+func (s *Server) ref(p *area.Player, args []string) string {
+	usage := "Usage: ref here, ref npc <name>, ref item <name> or ref <ref>\n"
+	if len(args) == 0 {
+		return usage
+	}
+	switch strings.ToLower(args[0]) {
+	case "here":
+		return fmt.Sprintf("%s/%s is %s\n", p.Area, p.Room, s.roomRef(p.Area, p.Room))
+	case "item":
+		if len(args) < 2 {
+			return usage
+		}
+		name := strings.Join(args[1:], " ")
+		return fmt.Sprintf("%s is %s\n", name, s.itemRef(name))
+	case "npc":
+		if len(args) < 2 {
+			return usage
+		}
+		name := strings.Join(args[1:], " ")
+		lines := []string{}
+		for areaName, a := range s.Areas {
+			for _, npc := range a.NPCs {
+				if strings.EqualFold(npc.Name, name) {
+					lines = append(lines, fmt.Sprintf("%s in %s/%s is %s", npc.Name, areaName, npc.Room,
+						area.Ref{Kind: area.KindNPC, UID: npc.UID}))
+				}
+			}
+		}
+		if len(lines) == 0 {
+			return fmt.Sprintf("There is no %s in the world\n", name)
+		}
+		sort.Strings(lines)
+		return strings.Join(lines, "\n") + "\n"
+	}
+
+	ref, err := area.ParseRef(args[0])
+	if err != nil {
+		return usage
+	}
+	name, areaName, room, err := s.resolve(ref)
+	if err != nil {
+		return fmt.Sprintf("%v\n", err)
+	}
+	if areaName == "" {
+		return fmt.Sprintf("%s is %s\n", ref, name)
+	}
+	return fmt.Sprintf("%s is %s, in %s/%s\n", ref, name, areaName, room)
+}
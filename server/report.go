@@ -0,0 +1,253 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/droslean/thyranew/area"
+
+	log "gopkg.in/inconshreveable/log15.v2"
+)
+
+// recentCommandLines is how many of their latest commands are kept for each
+// player, to go along with their reports.
+const recentCommandLines = 10
+
+// The state of a report.
+const (
+	reportOpen     = "open"
+	reportClaimed  = "claimed"
+	reportResolved = "resolved"
+)
+
+// reportKinds are the commands players send reports with.
+var reportKinds = map[string]bool{"bug": true, "typo": true, "idea": true}
+
+// rememberCommand keeps the command among the latest of the player. Reports
+// are left out, so they don't crowd out what led to them.
+func (s *Server) rememberCommand(nick, line string) {
+	if cmd, _ := parseCommand(line); cmd == "" || reportKinds[cmd] {
+		return
+	}
+	recent := append(s.lastCommands[nick], line)
+	if len(recent) > recentCommandLines {
+		recent = recent[len(recent)-recentCommandLines:]
+	}
+	s.lastCommands[nick] = recent
+}
+
+// fileReport stores a bug, typo or idea of the player and tells the admins.
+// Usage: bug <text>, typo <text>, idea <text>
+func (s *Server) fileReport(roomsMap map[string]map[string][][]area.Cube, p *area.Player, kind string, args []string) string {
+	if len(args) == 0 {
+		return fmt.Sprintf("Usage: %s <text>\n", kind)
+	}
+	r := &Report{
+		Kind:     kind,
+		Player:   p.Nickname,
+		Text:     strings.Join(args, " "),
+		Area:     p.Area,
+		Room:     p.Room,
+		Position: p.Position,
+		Recent:   append([]string(nil), s.lastCommands[p.Nickname]...),
+		Filed:    time.Now(),
+		Status:   reportOpen,
+	}
+	if err := s.db.PutReport(s.ctxOf(p.Nickname), r); err != nil {
+		log.Error(fmt.Sprintf("Cannot store the %s of %q: %v", kind, p.Nickname, err))
+		return "Your report could not be sent, please try again later\n"
+	}
+	log.Info(fmt.Sprintf("%s filed %s #%d", p.Nickname, kind, r.ID))
+	s.alertAdmins(roomsMap, fmt.Sprintf("New %s #%d from %s in %s/%s", kind, r.ID, p.Nickname, p.Area, p.Room))
+	return fmt.Sprintf("Thank you, your %s was filed as #%d\n", kind, r.ID)
+}
+
+// reports lets admins go through the reports of players.
+// Usage: reports [list [all]], reports show <id>, reports claim <id>, reports resolve <id> [note]
+func (s *Server) reports(roomsMap map[string]map[string][][]area.Cube, p *area.Player, args []string) string {
+	usage := "Usage: reports [list [all]|show <id>|claim <id>|resolve <id> [note]]\n"
+	if len(args) == 0 || strings.ToLower(args[0]) == "list" {
+		return s.listReports(p, len(args) > 1 && strings.ToLower(args[1]) == "all")
+	}
+	if len(args) < 2 {
+		return usage
+	}
+	id, err := strconv.ParseUint(strings.TrimPrefix(args[1], "#"), 10, 64)
+	if err != nil {
+		return usage
+	}
+	switch strings.ToLower(args[0]) {
+	case "show":
+		r, err := s.db.GetReport(s.ctxOf(p.Nickname), id)
+		if err != nil || r == nil {
+			return fmt.Sprintf("There is no report #%d\n", id)
+		}
+		return showReport(r)
+	case "claim":
+		r, err := s.claimReport(s.ctxOf(p.Nickname), id, p.Nickname)
+		if err != nil {
+			return err.Error() + "\n"
+		}
+		return fmt.Sprintf("You claimed %s #%d\n", r.Kind, r.ID)
+	case "resolve":
+		r, err := s.resolveReport(s.ctxOf(p.Nickname), id, p.Nickname, strings.Join(args[2:], " "))
+		if err != nil {
+			return err.Error() + "\n"
+		}
+		s.notify(roomsMap, r.Player, p.Nickname, resolvedNotice(r))
+		return fmt.Sprintf("You resolved %s #%d\n", r.Kind, r.ID)
+	}
+	return usage
+}
+
+// listReports lists the reports still to be resolved, or all of them.
+func (s *Server) listReports(p *area.Player, all bool) string {
+	reports, err := s.db.ListReports(s.ctxOf(p.Nickname))
+	if err != nil {
+		log.Error(fmt.Sprintf("Cannot list the reports: %v", err))
+		return "The reports cannot be listed right now\n"
+	}
+	lines := []string{}
+	for _, r := range reports {
+		if r.Status == reportResolved && !all {
+			continue
+		}
+		status := r.Status
+		if r.Status == reportClaimed {
+			status = "claimed by " + r.ClaimedBy
+		}
+		lines = append(lines, fmt.Sprintf("#%d %s from %s in %s/%s, %s: %s", r.ID, r.Kind, r.Player, r.Area, r.Room, status, r.Text))
+	}
+	if len(lines) == 0 {
+		return "There are no reports\n"
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
+
+func showReport(r *Report) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "#%d %s from %s, %s\n", r.ID, r.Kind, r.Player, r.Filed.Format("2006-01-02 15:04"))
+	fmt.Fprintf(&b, "At %s/%s, cube %s\n", r.Area, r.Room, r.Position)
+	fmt.Fprintf(&b, "%s\n", r.Text)
+	if len(r.Recent) > 0 {
+		fmt.Fprintf(&b, "Latest commands: %s\n", strings.Join(r.Recent, "; "))
+	}
+	switch r.Status {
+	case reportClaimed:
+		fmt.Fprintf(&b, "Claimed by %s\n", r.ClaimedBy)
+	case reportResolved:
+		fmt.Fprintf(&b, "Resolved by %s", r.ClaimedBy)
+		if r.Resolution != "" {
+			fmt.Fprintf(&b, ": %s", r.Resolution)
+		}
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
+// errResolved is returned when changing a report that is resolved already.
+var errResolved = errors.New("resolved already")
+
+// claimReport hands the report to the admin. Resolved reports cannot be
+// claimed.
+func (s *Server) claimReport(ctx context.Context, id uint64, admin string) (*Report, error) {
+	return s.changeReport(ctx, id, "claimed", func(r *Report) {
+		r.Status = reportClaimed
+		r.ClaimedBy = admin
+	})
+}
+
+// resolveReport closes the report, with an optional note for the player.
+func (s *Server) resolveReport(ctx context.Context, id uint64, admin, note string) (*Report, error) {
+	return s.changeReport(ctx, id, "resolved", func(r *Report) {
+		r.Status = reportResolved
+		r.ClaimedBy = admin
+		r.Resolution = note
+	})
+}
+
+// changeReport changes a report that is not resolved yet, turning what went
+// wrong into an error fit for admins.
+func (s *Server) changeReport(ctx context.Context, id uint64, done string, change func(*Report)) (*Report, error) {
+	r, err := s.db.UpdateReport(ctx, id, func(r *Report) error {
+		if r.Status == reportResolved {
+			return errResolved
+		}
+		change(r)
+		return nil
+	})
+	switch {
+	case err == errResolved:
+		return nil, fmt.Errorf("Report #%d is resolved already", id)
+	case err != nil:
+		log.Error(fmt.Sprintf("Cannot store report #%d: %v", id, err))
+		return nil, fmt.Errorf("Report #%d could not be %s", id, done)
+	case r == nil:
+		return nil, fmt.Errorf("There is no report #%d", id)
+	}
+	return r, nil
+}
+
+// resolvedNotice is what the player who sent the report is told once it is
+// resolved.
+func resolvedNotice(r *Report) string {
+	text := fmt.Sprintf("Your %s #%d was resolved", r.Kind, r.ID)
+	if r.Resolution != "" {
+		text += ": " + r.Resolution
+	}
+	return text
+}
+
+// serveReports is the admin API of the reports. GET lists them, as JSON;
+// POST with an action of claim or resolve, the id of the report and the
+// admin it is done by changes one, with an optional note when resolving.
+func (s *Server) serveReports(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	switch r.Method {
+	case http.MethodGet:
+		reports, err := s.db.ListReports(ctx)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(reports)
+	case http.MethodPost:
+		id, err := strconv.ParseUint(r.FormValue("id"), 10, 64)
+		admin := r.FormValue("by")
+		if err != nil || admin == "" {
+			http.Error(w, "id and by are required", http.StatusBadRequest)
+			return
+		}
+		var report *Report
+		switch r.FormValue("action") {
+		case "claim":
+			report, err = s.claimReport(ctx, id, admin)
+		case "resolve":
+			report, err = s.resolveReport(ctx, id, admin, r.FormValue("note"))
+			if err == nil {
+				n := Notification{From: admin, Text: resolvedNotice(report), Time: time.Now()}
+				if err := s.db.AddNotification(ctx, report.Player, n); err != nil {
+					log.Error(fmt.Sprintf("Cannot notify %q: %v", report.Player, err))
+				}
+			}
+		default:
+			http.Error(w, "action must be claim or resolve", http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(report)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
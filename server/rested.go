@@ -0,0 +1,45 @@
+package server
+
+import (
+	"fmt"
+
+	"github.com/droslean/thyranew/area"
+	"github.com/droslean/thyranew/game"
+)
+
+// RestedConfig tunes the experience players earn while logged out. Zero
+// turns it off.
+type RestedConfig struct {
+	// Rate is how many percent of what their level takes players earn every
+	// hour away, up to Max percent of it.
+	Rate int `toml:"rate"`
+	Max  int `toml:"max"`
+	// Bonus is by how many percent more their kills are worth while it lasts.
+	Bonus int `toml:"bonus"`
+}
+
+// restAway gives the player who logs in the rested experience of the time they
+// were away.
+func (s *Server) restAway(p *area.Player) {
+	rules := s.config.Rested
+	if p.LastSeen.IsZero() || rules.Rate == 0 {
+		return
+	}
+	p.Rested = game.RestedXP(p.Rested, p.Level, s.now().Sub(p.LastSeen), rules.Rate, rules.Max)
+}
+
+// restedBonus returns what a kill worth xp is worth on top for the player,
+// and takes it off their rested experience.
+func (s *Server) restedBonus(p *area.Player, xp int) int {
+	extra := game.RestedBonus(xp, p.Rested, s.config.Rested.Bonus)
+	p.Rested -= extra
+	return extra
+}
+
+// restedNotice tells the player who logs in how rested they are.
+func restedNotice(p *area.Player) string {
+	if p.Rested == 0 {
+		return ""
+	}
+	return tagged(TagSystem, fmt.Sprintf("You feel rested, with %d experience to earn on top of your kills\n", p.Rested))
+}
@@ -0,0 +1,195 @@
+package server
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/droslean/thyranew/area"
+	"github.com/droslean/thyranew/game"
+)
+
+const (
+	// maxScanDepth is how many rooms away a scan reaches.
+	maxScanDepth = 3
+	// scanWildRange is how many cells away a scan reaches in the wilderness.
+	scanWildRange = 12
+)
+
+// scan shows the creatures that can be seen through the doors of the room,
+// up to a few rooms away.
+// Usage: scan [depth]
+func (s *Server) scan(roomsMap map[string]map[string][][]area.Cube, p *area.Player, args []string) string {
+	if w, ok := s.Wilderness[p.Area]; ok {
+		x, y, _ := w.Cell(p.Position)
+		return joinScan(s.scanWilderness(w, p, x, y))
+	}
+
+	depth := maxScanDepth
+	if len(args) > 0 {
+		n, err := strconv.Atoi(args[0])
+		if err != nil || n < 1 {
+			return "Usage: scan [depth]\n"
+		}
+		if n < depth {
+			depth = n
+		}
+	}
+
+	if depth -= game.VisibilityPenalty(s.weatherAt(p)); depth < 1 {
+		return "You can't see a thing in this weather\n"
+	}
+
+	mapArray := roomsMap[p.Area][p.Room]
+	lines := []string{}
+	for _, d := range area.Doors(mapArray) {
+		door := mapArray[d[0]][d[1]]
+		if door.Closed {
+			continue
+		}
+		visited := map[string]bool{p.Area + "/" + p.Room: true}
+		seen := s.scanThrough(roomsMap, p, door.Exits[0], 1, depth, visited)
+		if len(seen) > 0 {
+			lines = append(lines, fmt.Sprintf("%s: %s", door.Exits[0].ToRoom, strings.Join(seen, ", ")))
+		}
+	}
+	return joinScan(lines)
+}
+
+func joinScan(lines []string) string {
+	if len(lines) == 0 {
+		return "You see nobody around\n"
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// scanThrough follows the exit and returns the creatures seen behind it,
+// along with their distance in rooms.
+func (s *Server) scanThrough(
+	roomsMap map[string]map[string][][]area.Cube,
+	viewer *area.Player,
+	exit area.Exit,
+	distance, depth int,
+	visited map[string]bool,
+) []string {
+	if w, ok := s.Wilderness[exit.ToArea]; ok {
+		x, y, _ := w.Cell(exit.ToCubeID)
+		return s.scanWilderness(w, viewer, x, y)
+	}
+
+	key := exit.ToArea + "/" + exit.ToRoom
+	if visited[key] {
+		return nil
+	}
+	visited[key] = true
+
+	seen := []string{}
+	for _, name := range s.visibleInRoom(viewer, exit.ToArea, exit.ToRoom) {
+		seen = append(seen, fmt.Sprintf("%s [%d]", name, distance))
+	}
+	if distance >= depth {
+		return seen
+	}
+
+	next := roomsMap[exit.ToArea][exit.ToRoom]
+	for _, d := range area.Doors(next) {
+		door := next[d[0]][d[1]]
+		if door.Closed {
+			continue
+		}
+		seen = append(seen, s.scanThrough(roomsMap, viewer, door.Exits[0], distance+1, depth, visited)...)
+	}
+	return seen
+}
+
+// visibleInRoom returns the names of the creatures the viewer can make out in
+// the given room from afar.
+func (s *Server) visibleInRoom(viewer *area.Player, areaName, room string) []string {
+	if s.Areas[areaName].Rooms[room].Dark {
+		return nil
+	}
+
+	names := []string{}
+	for _, c := range s.OnlineClientsGetByRoom(areaName, room) {
+		if c.Player.Nickname != viewer.Nickname && canSee(viewer, c.Player) {
+			names = append(names, c.Player.Nickname)
+		}
+	}
+	for _, npc := range s.npcsInRoom(areaName, room) {
+		names = append(names, npc.Name)
+	}
+	return names
+}
+
+// npcsInRoom returns the NPCs of the given room.
+func (s *Server) npcsInRoom(areaName, room string) []area.NPC {
+	npcs := []area.NPC{}
+	for _, npc := range s.Areas[areaName].NPCs {
+		if npc.InRoom(areaName, room) {
+			npcs = append(npcs, npc)
+		}
+	}
+	return npcs
+}
+
+// symbolsIn returns the symbols of the NPCs in the room that have a look, by
+// the cube they stand on.
+func (s *Server) symbolsIn(areaName, room string) map[string]string {
+	symbols := map[string]string{}
+	for _, npc := range s.npcsInRoom(areaName, room) {
+		if look := npc.Appearance(); look.Symbol != "" {
+			symbols[npc.Position] = look.Symbol
+		}
+	}
+	return symbols
+}
+
+// scanWilderness returns the players around the given cell of the wilderness,
+// along with how far and in which direction they are.
+func (s *Server) scanWilderness(w *area.Wilderness, viewer *area.Player, x, y int) []string {
+	seen := []string{}
+	for _, c := range s.OnlineClientsGetByRoom(w.Name, area.WildernessRoom) {
+		if c.Player.Nickname == viewer.Nickname || !canSee(viewer, c.Player) {
+			continue
+		}
+		cx, cy, ok := w.Cell(c.Player.Position)
+		if !ok {
+			continue
+		}
+		dx, dy := cx-x, cy-y
+		distance := abs(dx)
+		if abs(dy) > distance {
+			distance = abs(dy)
+		}
+		if distance > scanWildRange {
+			continue
+		}
+		seen = append(seen, fmt.Sprintf("%s [%d %s]", c.Player.Nickname, distance, compass(dx, dy)))
+	}
+	return seen
+}
+
+// compass returns the direction of the given offset.
+func compass(dx, dy int) string {
+	direction := ""
+	switch {
+	case dy < 0:
+		direction = "north"
+	case dy > 0:
+		direction = "south"
+	}
+	switch {
+	case dx > 0 && direction != "":
+		direction += "-east"
+	case dx > 0:
+		direction = "east"
+	case dx < 0 && direction != "":
+		direction += "-west"
+	case dx < 0:
+		direction = "west"
+	}
+	if direction == "" {
+		return "here"
+	}
+	return direction
+}
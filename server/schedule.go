@@ -0,0 +1,147 @@
+package server
+
+import (
+	"fmt"
+	"math/rand"
+	"time"
+
+	"github.com/droslean/thyranew/area"
+	"github.com/droslean/thyranew/game"
+)
+
+const (
+	// gameHour is how long an hour of the game world lasts in real time.
+	gameHour = 2 * time.Minute
+	// tickInterval is how often the world moves on by itself.
+	tickInterval = 10 * time.Second
+)
+
+// worldEpoch is the real time the game world started at.
+var worldEpoch = time.Date(2016, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+// worldTime returns the current time of the game world.
+func (s *Server) worldTime() game.GameTime {
+	return game.WorldTime(s.now().Sub(worldEpoch), gameHour)
+}
+
+// tick moves the world on by itself. The world calls it every tickInterval.
+func (s *Server) tick(roomsMap map[string]map[string][][]area.Cube) {
+	s.ticks++
+	s.scriptBudgets = make(map[string]int)
+	now := s.worldTime()
+	newHour := now.Hour != s.lastHour || len(s.weather) == 0
+	s.lastHour = now.Hour
+	s.checkCalendar(roomsMap)
+	s.checkContent(roomsMap)
+	s.runAreas(roomsMap, now, newHour)
+	s.returnMail(roomsMap)
+	s.checkAchievements(roomsMap)
+	s.endSentences(roomsMap)
+	s.endStaleFights(roomsMap)
+	s.checkSpectators(roomsMap)
+	s.chargeUpkeep(roomsMap)
+	s.flushLedger()
+	s.sweepPrivacy()
+	s.countDownMaintenance(roomsMap)
+	s.runJobs(roomsMap)
+	s.freeInstances(roomsMap)
+}
+
+// showTime tells the player the time of the game world.
+func (s *Server) showTime() string {
+	now := s.worldTime()
+	return fmt.Sprintf("It is %s in %s, %s\n", now.PartOfDay(), now.Season(), now)
+}
+
+// runAreas does the work of every area for the tick on the shards. The
+// weather turns with the hour, and NPCs with a schedule move one room closer
+// to where they are meant to be. The players in the area keep afloat, hunger
+// and heal, and the effects on them and on the NPCs act.
+func (s *Server) runAreas(roomsMap map[string]map[string][][]area.Cube, now game.GameTime, newHour bool) {
+	players := map[string][]Client{}
+	for _, cl := range s.OnlineClients() {
+		players[cl.Player.Area] = append(players[cl.Player.Area], cl)
+	}
+	// NPCs keep to their schedules every other tick while the world sheds
+	// load.
+	schedules := !s.world.shedding() || s.ticks%2 == 0
+
+	s.world.each(func(areaName string, rnd *rand.Rand) []func() {
+		var effects []func()
+		if newHour {
+			effects = append(effects, s.rollWeather(roomsMap, areaName, rnd)...)
+		}
+		if schedules {
+			effects = append(effects, s.runAreaSchedules(roomsMap, areaName, now)...)
+		}
+		for _, cl := range players[areaName] {
+			s.swim(cl.Player, rnd)
+			effects = append(effects, s.metabolize(roomsMap, cl)...)
+			effects = append(effects, s.regenerate(cl.Player)...)
+			effects = append(effects, s.applyEffects(roomsMap, cl)...)
+		}
+		return append(effects, s.applyNPCEffects(roomsMap, areaName)...)
+	})
+}
+
+// runAreaSchedules moves the NPCs of the area along their schedules. It only
+// changes the area, and returns what the world has to do about the moves.
+func (s *Server) runAreaSchedules(roomsMap map[string]map[string][][]area.Cube, areaName string, now game.GameTime) []func() {
+	var effects []func()
+	a := s.Areas[areaName]
+	for i := range a.NPCs {
+		npc := &a.NPCs[i]
+		if npc.Target != "" {
+			continue
+		}
+		entry, ok := activeEntry(npc.Schedule, now.Hour)
+		if !ok {
+			continue
+		}
+		npc.Activity = entry.Activity
+
+		destination := entry.Room
+		if len(entry.Patrol) > 0 {
+			destination = entry.Patrol[npc.PatrolStep%len(entry.Patrol)]
+			if npc.Room == destination {
+				npc.PatrolStep++
+				destination = entry.Patrol[npc.PatrolStep%len(entry.Patrol)]
+			}
+		}
+
+		if npc.Room == destination {
+			if entry.Position != "" {
+				npc.Position = entry.Position
+			}
+			continue
+		}
+		path, ok := roomPath(roomsMap, areaName, npc.Room, areaName, destination)
+		if !ok || len(path) == 0 {
+			continue
+		}
+		name, from, to, guard := npc.Name, npc.Room, path[0].ToRoom, npc.Guard
+		npc.MoveTo(path[0])
+		effects = append(effects, func() {
+			if s.ambient() {
+				s.printToRoom(roomsMap, areaName, from, fmt.Sprintf("%s leaves the room\n", name))
+				s.printToRoom(roomsMap, areaName, to, fmt.Sprintf("%s walks in\n", name))
+			}
+			if guard {
+				for _, c := range s.OnlineClientsGetByRoom(areaName, to) {
+					s.guardsNotice(roomsMap, c.Player)
+				}
+			}
+		})
+	}
+	return effects
+}
+
+// activeEntry returns the schedule entry covering the given hour.
+func activeEntry(schedule []area.ScheduleEntry, hour int) (area.ScheduleEntry, bool) {
+	for _, e := range schedule {
+		if game.Within(hour, e.From, e.To) {
+			return e, true
+		}
+	}
+	return area.ScheduleEntry{}, false
+}
@@ -7,15 +7,25 @@ import (
 	log "gopkg.in/inconshreveable/log15.v2"
 )
 
+// maxMessageLines is the number of message lines that fit in the screen.
+const maxMessageLines = 5
+
 type Screen struct {
 	width          int
 	height         int
 	exitCanvas     []rune
-	messagesCanvas []rune
+	messagesCanvas [][]rune
+	messageColors  [][]ID // Colours of the highlights among the messages
+	combatCanvas   [][]rune
 	mapCanvas      [][]rune
 	introCanvas    [][]rune
 	screenRunes    [][]rune
-	screenColors   [][]ID // the player's view of the screen
+	screenColors   [][]ID   // the player's view of the screen
+	modalCanvas    [][]rune // The open modal, drawn over the rest
+	modalColors    [][]ID
+	introColors    [][]ID // Colours of the marked up room description
+	modalTop       int
+	modalLeft      int
 }
 
 // Initialize new Screen
@@ -40,7 +50,8 @@ func NewScreen(width, height int) *Screen {
 		width:          width,
 		height:         height,
 		exitCanvas:     make([]rune, 0),
-		messagesCanvas: make([]rune, 0),
+		messagesCanvas: make([][]rune, 0),
+		combatCanvas:   make([][]rune, 0),
 		mapCanvas:      make([][]rune, 0),
 		introCanvas:    make([][]rune, 0),
 		screenRunes:    screenRunes,
@@ -49,6 +60,26 @@ func NewScreen(width, height int) *Screen {
 
 }
 
+// reset blanks the screen for the next frame. The rows are kept, so drawing
+// a frame doesn't allocate the whole screen again.
+func (scr *Screen) reset() {
+	for h := range scr.screenRunes {
+		for w := range scr.screenRunes[h] {
+			scr.screenRunes[h][w] = ' '
+			scr.screenColors[h][w] = ID(255)
+		}
+	}
+	scr.exitCanvas = scr.exitCanvas[:0]
+	scr.messagesCanvas = scr.messagesCanvas[:0]
+	scr.messageColors = nil
+	scr.combatCanvas = scr.combatCanvas[:0]
+	scr.mapCanvas = scr.mapCanvas[:0]
+	scr.introCanvas = scr.introCanvas[:0]
+	scr.modalCanvas = scr.modalCanvas[:0]
+	scr.modalColors = nil
+	scr.introColors = nil
+}
+
 // TODO : Check for offsets. Add limitation to all Canvas
 func (scr *Screen) updateScreen(frame string, bufToUpdate bytes.Buffer) {
 	runes := make([]rune, 0)
@@ -104,7 +135,21 @@ func (scr *Screen) updateScreen(frame string, bufToUpdate bytes.Buffer) {
 				break
 			}
 			if char == '\n' {
-				scr.messagesCanvas = runes
+				scr.messagesCanvas = append(scr.messagesCanvas, runes)
+				runes = []rune{}
+			} else {
+				runes = append(runes, char)
+			}
+		}
+
+	case "combat":
+		for {
+			char, _, err := buf.ReadRune()
+			if err != nil {
+				break
+			}
+			if char == '\n' {
+				scr.combatCanvas = append(scr.combatCanvas, runes)
 				runes = []rune{}
 			} else {
 				runes = append(runes, char)
@@ -0,0 +1,209 @@
+package server
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/droslean/thyranew/area"
+)
+
+const (
+	// scriptStepDelay is the least time between two commands of a script.
+	scriptStepDelay = 500 * time.Millisecond
+	// scriptBudget is how many script commands each player can run per tick.
+	scriptBudget = 10
+	// maxScriptSteps is how long a script can be.
+	maxScriptSteps = 20
+	// maxScripts is how many scripts each player can keep.
+	maxScripts = 10
+	// maxScriptWait is the longest wait in a script, in seconds.
+	maxScriptWait = 30
+)
+
+// scriptForbidden are the commands scripts can't run, so they never start
+// scripts themselves or loop.
+var scriptForbidden = map[string]bool{"script": true, "run": true, "quit": true}
+
+// runningScript is a script a player runs.
+type runningScript struct {
+	Name    string
+	Steps   []string
+	Next    int // Index of the next step
+	Started time.Time
+}
+
+// script lists, shows, defines or deletes the scripts of the player.
+// Usage: script, script <name> [cmd;cmd;...], script delete <name>
+func (s *Server) script(p *area.Player, args []string) string {
+	if len(args) == 0 {
+		if len(p.Scripts) == 0 {
+			return "You have no scripts\n"
+		}
+		names := []string{}
+		for name := range p.Scripts {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return fmt.Sprintf("Scripts: %s\n", strings.Join(names, ", "))
+	}
+
+	if strings.ToLower(args[0]) == "delete" && len(args) == 2 {
+		name := strings.ToLower(args[1])
+		if _, ok := p.Scripts[name]; !ok {
+			return fmt.Sprintf("You have no script %s\n", name)
+		}
+		delete(p.Scripts, name)
+		return s.savePreferences(p, fmt.Sprintf("Script %s is deleted\n", name))
+	}
+
+	name := strings.ToLower(args[0])
+	if len(args) == 1 {
+		body, ok := p.Scripts[name]
+		if !ok {
+			return fmt.Sprintf("You have no script %s\n", name)
+		}
+		return fmt.Sprintf("%s: %s\n", name, body)
+	}
+
+	steps := scriptSteps(strings.Join(args[1:], " "))
+	if len(steps) > maxScriptSteps {
+		return fmt.Sprintf("Scripts can't be longer than %d commands\n", maxScriptSteps)
+	}
+	for _, step := range steps {
+		cmd, stepArgs := parseCommand(step)
+		if scriptForbidden[cmd] {
+			return fmt.Sprintf("Scripts can't %s\n", cmd)
+		}
+		if cmd == "wait" {
+			if len(stepArgs) != 1 {
+				return "Usage: wait <seconds>\n"
+			}
+			if seconds, err := strconv.Atoi(stepArgs[0]); err != nil || seconds < 1 || seconds > maxScriptWait {
+				return fmt.Sprintf("Scripts can wait from 1 to %d seconds\n", maxScriptWait)
+			}
+		}
+	}
+	if _, ok := p.Scripts[name]; !ok && len(p.Scripts) >= maxScripts {
+		return fmt.Sprintf("You can't have more than %d scripts\n", maxScripts)
+	}
+	if p.Scripts == nil {
+		p.Scripts = make(map[string]string)
+	}
+	p.Scripts[name] = strings.Join(steps, ";")
+	return s.savePreferences(p, fmt.Sprintf("Script %s is saved\n", name))
+}
+
+// scriptSteps splits the body of a script into its commands.
+func scriptSteps(body string) []string {
+	steps := []string{}
+	for _, step := range strings.Split(body, ";") {
+		if step = strings.TrimSpace(step); step != "" {
+			steps = append(steps, step)
+		}
+	}
+	return steps
+}
+
+// run starts a script of the player, or stops the one running.
+// Usage: run <name>|stop
+func (s *Server) run(p *area.Player, args []string) string {
+	if len(args) != 1 {
+		return "Usage: run <name>|stop\n"
+	}
+	name := strings.ToLower(args[0])
+	if name == "stop" {
+		rs, ok := s.running[p.Nickname]
+		if !ok {
+			return "You aren't running a script\n"
+		}
+		delete(s.running, p.Nickname)
+		return fmt.Sprintf("Script %s is stopped\n", rs.Name)
+	}
+	if rs, ok := s.running[p.Nickname]; ok {
+		return fmt.Sprintf("Script %s is still running\n", rs.Name)
+	}
+	body, ok := p.Scripts[name]
+	if !ok {
+		return fmt.Sprintf("You have no script %s\n", name)
+	}
+
+	rs := &runningScript{Name: name, Steps: scriptSteps(body), Started: time.Now()}
+	s.running[p.Nickname] = rs
+	nick := p.Nickname
+	s.after(0, func() {
+		s.scriptStep(nick, rs)
+	})
+	return fmt.Sprintf("You run %s\n", name)
+}
+
+// scriptStep runs the next command of the script, as if the player typed it.
+// Players who ran out of budget wait for the next tick.
+func (s *Server) scriptStep(nick string, rs *runningScript) {
+	if s.running[nick] != rs {
+		return
+	}
+	cl, ok := s.clientByNick(nick)
+	if !ok {
+		delete(s.running, nick)
+		return
+	}
+	if rs.Next >= len(rs.Steps) || !s.featureOn(featureScripts) {
+		delete(s.running, nick)
+		return
+	}
+
+	step := rs.Steps[rs.Next]
+	next := func() {
+		s.scriptStep(nick, rs)
+	}
+	if cmd, args := parseCommand(step); cmd == "wait" {
+		rs.Next++
+		seconds, _ := strconv.Atoi(args[0])
+		s.after(time.Duration(seconds)*time.Second, next)
+		return
+	}
+	if s.scriptBudgets[nick] >= scriptBudget {
+		s.after(scriptStepDelay, next)
+		return
+	}
+	s.scriptBudgets[nick]++
+	rs.Next++
+
+	// The world is the one reading the events, so they are sent from elsewhere.
+	go func() {
+		s.Events <- Event{Client: cl, EventType: step}
+		s.after(scriptStepDelay, next)
+	}()
+}
+
+// scripts lets admins see and stop the scripts that run.
+// Usage: scripts [stop <player>]
+func (s *Server) scripts(args []string) string {
+	if len(args) == 2 && strings.ToLower(args[0]) == "stop" {
+		if _, ok := s.running[args[1]]; !ok {
+			return fmt.Sprintf("%s isn't running a script\n", args[1])
+		}
+		delete(s.running, args[1])
+		return fmt.Sprintf("The script of %s is stopped\n", args[1])
+	}
+	if len(s.running) == 0 {
+		return "No scripts are running\n"
+	}
+	nicks := []string{}
+	for nick := range s.running {
+		nicks = append(nicks, nick)
+	}
+	sort.Strings(nicks)
+	lines := []string{}
+	for _, nick := range nicks {
+		rs := s.running[nick]
+		lines = append(lines, fmt.Sprintf("%s: %s, step %d of %d, for %s", nick, rs.Name, rs.Next, len(rs.Steps), time.Since(rs.Started).Round(time.Second)))
+	}
+	if len(lines) > maxMessageLines {
+		lines = append(lines[:maxMessageLines-1], fmt.Sprintf("and %d more", len(lines)-maxMessageLines+1))
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
@@ -2,22 +2,28 @@ package server
 
 import (
 	"bytes"
+	"context"
 	"crypto/md5"
 	"encoding/binary"
 	"encoding/hex"
+	"errors"
 	"fmt"
+	"hash/fnv"
 	"io/ioutil"
+	"math"
 	"math/rand"
 	"net"
 	"os"
-	"os/signal"
 	"path/filepath"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/droslean/thyranew/area"
+	"github.com/droslean/thyranew/cluster"
 	"github.com/droslean/thyranew/game"
 	"github.com/gothyra/toml"
 
@@ -35,48 +41,381 @@ var (
 type Server struct {
 	sync.RWMutex
 	port          int
+	db            *Database
 	addresses     string
 	idPool        <-chan ID
 	logf          func(format string, args ...interface{})
 	privateKey    ssh.Signer
-	newPlayers    chan *Client
 	onlineClients map[string]*Client
 	Players       map[string]area.Player
 	Events        chan Event
 	Areas         map[string]area.Area
+	LootTables    map[string]game.LootTable
+	Wilderness    map[string]*area.Wilderness
+	Factions      map[string]game.Faction
+	Dialogues     map[string]area.Dialogue
+	Socials       map[string]area.Social
+	Shops         map[string]area.Shop
+	SkillTables   map[string]area.SkillTable // By class
+	Achievements  []area.Achievement
+	Items         map[string]area.Item // By the lower case name of the item
+	Hints         []area.Hint
+	Tutorial      []area.TutorialStep
+	Vehicles      map[string]*area.Vehicle
 	staticDir     string
+	dataDir       string // Where the players and their recordings are saved
+	rnd           *rand.Rand
+	seed          int64     // What rnd was seeded with
+	sim           *simClock // The clock of a simulation, nil for the real one
+	// worldTasks are run by the world, so timers can safely change it.
+	worldTasks   chan func()
+	pursuits     map[int]bool // NPCs chasing a player
+	weather      map[string]string
+	config       Config
+	ticks        int // Ticks since the server started
+	handlers     map[string][]func(WorldEvent)
+	corpses      map[string]Corpse // Corpses by the nickname of their owner
+	remains      map[int]Remains   // Corpses of NPCs with loot on them, by their own ID
+	lastRemains  int               // ID of the latest remains
+	rolls        map[int]*lootRoll // Groups rolling for loot, by the ID of the roll
+	lastRoll     int               // ID of the latest roll
+	lootTurns    map[string]string // Who got the last item under round-robin, by the leader of the group
+	swings       map[string]int    // The fight each player swings their weapons in, by its ID
+	lastSwing    int               // ID of the latest fight hand to hand
+	calendar     []area.CalendarEvent
+	escalations  []area.Escalation
+	activeEvents map[string]bool
+	lastHour     int // Game hour of the last tick
+	levelRecord  int // Highest level anyone reached, for the news
+	lastNPCID    int
+	fights       map[string]*Fight         // Fights by the nickname of the player
+	combatLogs   map[string][]string       // Latest combat seen by each player
+	lastCommands map[string][]string       // Latest commands of each player, for their reports
+	playbacks    map[string]chan struct{}  // Stops the playback on the screen of an admin
+	running      map[string]*runningScript // Scripts by the nickname of the player
+	frames       map[string]*frame         // Output waiting to be drawn, by the nickname of the player
+	walks        map[string]*walk          // Walks to the cubes players clicked
+	modals       map[string][]modal        // Modals open on the screens of players, the top one last
+	picked       map[int]time.Time         // Until when robbed NPCs are wary of thieves, by ID
+	threat       map[int]map[string]int    // Threat of each player on the mind of an NPC, by ID
+	leashes      map[int]area.Location     // Where the fights of NPCs started, by ID
+	ledger       map[string]int            // Gold that came and went since it was last written down, by source
+	demand       map[string]demand         // Wares bought lately, by shop and item
+	// contentFlags are the windows of seasonal content, flagsOn whether each
+	// is on by name, and flagOverrides those admins set on or off.
+	contentFlags  []area.ContentFlag
+	flagsOn       map[string]bool
+	flagOverrides map[string]bool
+	dormant       map[string][]area.NPC // NPCs whose content flag is off, by area
+	// hintRecords are what the accounts online were shown of the hints, by
+	// account.
+	hintRecords map[string]*HintRecord
+	// scriptBudgets counts the script commands of each player since the last tick.
+	scriptBudgets map[string]int
+	world         *World
+	chatLog       *chatLog // Nil when nothing is logged
+	// linkCodes are the one-time codes players link their accounts with, by
+	// code, and linkStates the logins at the providers under way, by state.
+	linkCodes  map[string]linkCode
+	linkStates map[string]linkCode
+	// peers are the other servers of the cluster, by name, and migrating the
+	// players on their way to one of them, to its name.
+	peers     map[string]*cluster.Client
+	migrating map[string]string
+	intermud  *gateway // Nil when not on an intermud network
+	// reloads are the world files builders put that wait to be loaded
+	// again, to who put them.
+	reloads map[string]string
+	// areaFiles are the files the areas were loaded from, by area, from the
+	// static directory with slashes.
+	areaFiles map[string]string
+	// staged are the changes to the files of the world waiting for an admin,
+	// by file.
+	staged map[string]worldChange
+	// ready is 1 while the server accepts players, for the readiness probe.
+	ready int32
+	// name is the name of the world among those of its host, and tenants
+	// the host of the worlds players reach through its listener, nil when it
+	// is alone.
+	name    string
+	tenants *Host
+	// exportCodes are the one-time codes players fetch their exported
+	// characters from the web with, by code.
+	exportCodes map[string]exportCode
+	// maintenance is set while the server is down for maintenance, and only
+	// admins may log in.
+	maintenance *maintenance
+	// location is the time zone of the server, and jobs what it does on a
+	// schedule.
+	location *time.Location
+	jobs     []*scheduledJob
+	// features are the subsystems admins turned off, or back on.
+	features map[string]FeatureSwitch
+	// trail is the latest traffic of the event bus, for the crash bundles.
+	trail busTrail
+	// journal writes what goes into the world, when it is journaled, and
+	// replay keeps what comes out of it, when it is a replay.
+	journal *journal
+	replay  *replayed
 }
 
+// defaultPort is the port the server listens on, unless server.toml or the
+// options say otherwise.
+const defaultPort = 3030
+
+// defaultMaxPlayers is how many players can be online at once, unless
+// server.toml says otherwise.
+const defaultMaxPlayers = 100
+
+// newIDPool returns the IDs of the given number of players, the most there is
+// room for in an ID at most.
+func newIDPool(players int) chan ID {
+	if players <= 0 {
+		players = defaultMaxPlayers
+	}
+	if players > math.MaxUint16 {
+		players = math.MaxUint16
+	}
+	pool := make(chan ID, players)
+	for id := 1; id <= players; id++ {
+		pool <- ID(id)
+	}
+	return pool
+}
+
+// Options are where a server keeps its files, and what of its settings come
+// from elsewhere than server.toml, like the flags of the command line.
+type Options struct {
+	// Port overrides the port of server.toml when set.
+	Port int
+	// StaticDir is where the world is read from. It is THYRA_STATIC, or the
+	// static directory of the working directory, unless set.
+	StaticDir string
+	// DataDir is where the players, their recordings and the staged edits
+	// are saved. It is the static directory unless set.
+	DataDir string
+	// Config changes the settings read from server.toml, before anything is
+	// loaded with them.
+	Config func(c *Config)
+	// Journal is the file the world writes what goes into it to, for thyra
+	// replay to play it back, when set.
+	Journal string
+	// Simulation makes the server a simulation when set. It is one from the
+	// start, so the areas generated on the way up come from its seed too.
+	Simulation *Simulation
+}
+
+// NewServer loads the world of THYRA_STATIC, for the server to listen on the
+// port.
 func NewServer(db *Database, port int) (*Server, error) {
-	// Environment variables
-	staticDir := os.Getenv("THYRA_STATIC")
+	return NewServerWith(db, Options{Port: port})
+}
+
+// NewServerWith loads the world the options point to.
+func NewServerWith(db *Database, opts Options) (*Server, error) {
+	staticDir := opts.StaticDir
+	if len(staticDir) == 0 {
+		staticDir = os.Getenv("THYRA_STATIC")
+	}
 	if len(staticDir) == 0 {
 		pwd, _ := os.Getwd()
 		staticDir = filepath.Join(pwd, "static")
 		log.Warn("Set THYRA_STATIC if you wish to configure the directory for static content")
 	}
 	log.Info(fmt.Sprintf("Using %s for static content", staticDir))
-
-	idPool := make(chan ID, 100)
-	for id := 1; id <= 100; id++ {
-		idPool <- ID(id)
+	dataDir := opts.DataDir
+	if len(dataDir) == 0 {
+		dataDir = staticDir
 	}
 
+	seed := time.Now().UnixNano()
 	s := &Server{
-		port:          port,
-		idPool:        idPool,
+		db:            db,
 		onlineClients: make(map[string]*Client),
-		Events:        make(chan Event),
+		Events:        make(chan Event, 100),
 		Areas:         make(map[string]area.Area),
+		LootTables:    make(map[string]game.LootTable),
+		Wilderness:    make(map[string]*area.Wilderness),
+		Factions:      make(map[string]game.Faction),
+		Dialogues:     make(map[string]area.Dialogue),
+		Socials:       make(map[string]area.Social),
+		Shops:         make(map[string]area.Shop),
+		SkillTables:   make(map[string]area.SkillTable),
+		Items:         make(map[string]area.Item),
+		Vehicles:      make(map[string]*area.Vehicle),
 		staticDir:     staticDir,
+		dataDir:       dataDir,
 		Players:       make(map[string]area.Player),
+		rnd:           rand.New(rand.NewSource(seed)),
+		seed:          seed,
+		worldTasks:    make(chan func(), 100),
+		pursuits:      make(map[int]bool),
+		weather:       make(map[string]string),
+		handlers:      make(map[string][]func(WorldEvent)),
+		corpses:       make(map[string]Corpse),
+		remains:       make(map[int]Remains),
+		rolls:         make(map[int]*lootRoll),
+		lootTurns:     make(map[string]string),
+		swings:        make(map[string]int),
+		fights:        make(map[string]*Fight),
+		combatLogs:    make(map[string][]string),
+		lastCommands:  make(map[string][]string),
+		playbacks:     make(map[string]chan struct{}),
+		running:       make(map[string]*runningScript),
+		frames:        make(map[string]*frame),
+		walks:         make(map[string]*walk),
+		modals:        make(map[string][]modal),
+		picked:        make(map[int]time.Time),
+		threat:        make(map[int]map[string]int),
+		leashes:       make(map[int]area.Location),
+		ledger:        make(map[string]int),
+		demand:        make(map[string]demand),
+		flagsOn:       make(map[string]bool),
+		flagOverrides: make(map[string]bool),
+		dormant:       make(map[string][]area.NPC),
+		hintRecords:   make(map[string]*HintRecord),
+		scriptBudgets: make(map[string]int),
+		activeEvents:  make(map[string]bool),
+		linkCodes:     make(map[string]linkCode),
+		linkStates:    make(map[string]linkCode),
+		peers:         make(map[string]*cluster.Client),
+		migrating:     make(map[string]string),
+		reloads:       make(map[string]string),
+		areaFiles:     make(map[string]string),
+		staged:        make(map[string]worldChange),
+		exportCodes:   make(map[string]exportCode),
+	}
+
+	if err := s.loadConfig(); err != nil {
+		return nil, err
+	}
+	if err := applyEnv(&s.config); err != nil {
+		return nil, err
+	}
+	if opts.Config != nil {
+		opts.Config(&s.config)
+	}
+	if opts.Port != 0 {
+		s.config.Port = opts.Port
+	}
+	if s.config.Port == 0 {
+		s.config.Port = defaultPort
+	}
+	s.port = s.config.Port
+	s.idPool = newIDPool(s.config.MaxPlayers)
+	// The randomness of the world is settled before anything is generated
+	// with it.
+	if opts.Simulation != nil {
+		s.simulate(*opts.Simulation)
+	}
+	if opts.Journal != "" {
+		if err := s.openJournal(opts.Journal); err != nil {
+			return nil, err
+		}
+	}
+	if s.config.Maintenance.On {
+		s.maintenance = &maintenance{By: "server.toml", Since: time.Now()}
+	}
+	if err := s.loadJobs(); err != nil {
+		return nil, err
+	}
+	if err := s.loadFeatures(); err != nil {
+		return nil, err
 	}
 
 	if err := s.loadAreas(); err != nil {
 		os.Exit(1)
 	}
 
-	if err := db.GetPrivateKey(s); err != nil {
+	if err := s.loadRoomDescriptions(); err != nil {
+		return nil, err
+	}
+
+	if err := s.loadStaged(); err != nil {
+		return nil, err
+	}
+
+	if err := s.loadLootTables(); err != nil {
+		return nil, err
+	}
+
+	if err := s.loadWilderness(); err != nil {
+		return nil, err
+	}
+
+	if err := s.loadFactions(); err != nil {
+		return nil, err
+	}
+
+	if err := s.loadAchievements(); err != nil {
+		return nil, err
+	}
+
+	if err := s.loadDialogues(); err != nil {
+		return nil, err
+	}
+
+	if err := s.loadSocials(); err != nil {
+		return nil, err
+	}
+
+	if err := s.loadShops(); err != nil {
+		return nil, err
+	}
+
+	if err := s.loadSkillTables(); err != nil {
+		return nil, err
+	}
+
+	if err := s.loadItems(); err != nil {
+		return nil, err
+	}
+
+	if err := s.loadVehicles(); err != nil {
+		return nil, err
+	}
+
+	if err := s.loadCalendar(); err != nil {
+		return nil, err
+	}
+
+	if err := s.loadContentFlags(); err != nil {
+		return nil, err
+	}
+
+	if err := s.loadHints(); err != nil {
+		return nil, err
+	}
+
+	if err := s.loadModeration(); err != nil {
+		return nil, err
+	}
+
+	chatLog, err := s.openChatLog()
+	if err != nil {
+		return nil, err
+	}
+	s.chatLog = chatLog
+
+	if err := s.recoverMail(); err != nil {
+		return nil, err
+	}
+
+	s.subscribe(EventPlayerDied, s.onPlayerDied)
+	s.subscribe(EventPlayerState, s.onPlayerState)
+	s.recordHistory()
+	if err := s.recordNews(); err != nil {
+		return nil, err
+	}
+	s.world = newWorld(s)
+
+	key, err := hostKeyFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	if key != nil {
+		s.privateKey = key
+	} else if err := db.GetPrivateKey(s); err != nil {
 		return nil, err
 	}
 	if addrs, err := net.InterfaceAddrs(); err == nil {
@@ -92,68 +431,98 @@ func NewServer(db *Database, port int) (*Server, error) {
 	return s, nil
 }
 
+// Port returns the port the server listens on.
+func (s *Server) Port() int {
+	return s.port
+}
+
+// StartServer serves the world until the process is told to stop.
 func (s *Server) StartServer() {
-	// bind to provided port
-	server, err := net.ListenTCP("tcp4", &net.TCPAddr{Port: s.port})
+	h, err := NewHost(Tenant{Server: s})
 	if err != nil {
-		log.Info(fmt.Sprintf("%v", err))
+		log.Error(err.Error())
+		return
 	}
-	log.Info(fmt.Sprintf("Listening for incoming connections on localhost:%d", s.port))
-
-	// Channel for gracefully shutting down all the rest of the threads.
-	stopCh := make(chan struct{})
-	wg := &sync.WaitGroup{}
-
-	// God has all the server-side logic.
-	wg.Add(1)
-	go s.God(stopCh, wg)
-
-	// accept connections
-	// wg.Add(1)
-	go func() {
-		// defer wg.Done()
+	h.Start()
+}
 
-		for {
-			// TODO: Timeout after some time to unblock the loop occasionally
-			// and check for graceful termination.
-			tcpConn, err := server.AcceptTCP()
-			if err != nil {
-				log.Warn(fmt.Sprintf("accept error (%s)", err))
-				continue
+// accept hands the connections coming in to handle, until the context is done.
+func (s *Server) accept(ctx context.Context, listener *net.TCPListener, wg *sync.WaitGroup) {
+	for {
+		// TODO: Timeout after some time to unblock the loop occasionally
+		// and check for graceful termination.
+		tcpConn, err := listener.AcceptTCP()
+		if ctx.Err() != nil {
+			if err == nil {
+				tcpConn.Close()
 			}
-			wg.Add(1)
-			go s.handle(tcpConn, stopCh, wg)
+			return
 		}
-	}()
-
-	signals := make(chan os.Signal, 1)
-	signal.Notify(signals, os.Interrupt, os.Kill)
-	select {
-	case <-signals:
-		log.Warn("Server is terminating...")
-		close(stopCh)
+		if err != nil {
+			log.Warn(fmt.Sprintf("accept error (%s)", err))
+			continue
+		}
+		wg.Add(1)
+		go s.handle(ctx, tcpConn, wg)
 	}
-
-	wg.Wait()
-	log.Warn("Server shutdown.")
 }
 
-func (s *Server) handle(tcpConn *net.TCPConn, stopCh <-chan struct{}, wg *sync.WaitGroup) {
+// handle serves a connection from the handshake until it drops. The context of
+// the connection is done when it drops, or when the server shuts down, and
+// everything running for the player stops along with it.
+func (s *Server) handle(ctx context.Context, tcpConn *net.TCPConn, wg *sync.WaitGroup) {
 	defer wg.Done()
+	// A panic during the handshake only loses the connection.
+	defer s.guard(nil, "handle")
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		<-ctx.Done()
+		tcpConn.Close()
+	}()
 
 	//extract these from connection
 	var sshName, hash string
+	// world is the world the player asked for, when this server hosts
+	// others.
+	world := s
+	life := newLifecycle()
+	entered := false
+	defer func() {
+		// Connections that never made it into the world are done with.
+		if !entered {
+			world.connState(ctx, life, sshName, StateQuitting)
+		}
+	}()
 	// perform handshake
 	config := &ssh.ServerConfig{
 		PublicKeyCallback: func(conn ssh.ConnMetadata, publicKey ssh.PublicKey) (*ssh.Permissions, error) {
-			sshName = conn.User()
+			// Clients may offer more than one key.
+			if st, _ := life.get(); st == StateConnecting {
+				picked, nick := s.pick(conn.User())
+				if picked == nil {
+					return nil, fmt.Errorf("no world for %q", conn.User())
+				}
+				world, sshName = picked, nick
+				world.connState(ctx, life, sshName, StateAuthenticating)
+			}
 			if publicKey != nil {
-				m := md5.Sum(publicKey.Marshal())
-				hash = hex.EncodeToString(m[:])
+				hash = KeyFingerprint(publicKey)
 			}
 			return nil, nil
 		},
 	}
+	config.BannerCallback = func(conn ssh.ConnMetadata) string {
+		banner := ""
+		if s.tenants != nil {
+			banner = s.tenants.banner(conn.User())
+		}
+		if picked, _ := s.pick(conn.User()); picked != nil {
+			banner += picked.maintenanceBanner(ctx)
+		}
+		return banner
+	}
 	config.AddHostKey(s.privateKey)
 	sshConn, chans, globalReqs, err := ssh.NewServerConn(tcpConn, config)
 	if err != nil {
@@ -162,19 +531,18 @@ func (s *Server) handle(tcpConn *net.TCPConn, stopCh <-chan struct{}, wg *sync.W
 	}
 	// global requests must be serviced - discard
 	go ssh.DiscardRequests(globalReqs)
-	// protect against XTR (cross terminal renderering) attacks
-	name := filtername.ReplaceAllString(sshName, "")
-	// trim name
-	maxlen := 100
-	if len(name) > maxlen {
-		name = string([]rune(name)[:maxlen])
-	}
+	entered = world.session(ctx, cancel, tcpConn, sshConn, chans, life, sshName, hash, wg)
+}
+
+// session serves the session of a connection that got through the handshake,
+// and reports whether the player entered the world.
+func (s *Server) session(ctx context.Context, cancel context.CancelFunc, tcpConn *net.TCPConn, sshConn *ssh.ServerConn, chans <-chan ssh.NewChannel, life *lifecycle, sshName, hash string, wg *sync.WaitGroup) bool {
 	// get the first channel
 	var c ssh.NewChannel
 	select {
 	case c = <-chans:
-	case <-stopCh:
-		return
+	case <-ctx.Done():
+		return false
 	}
 	// channel requests must be serviced - reject rest
 	go func() {
@@ -186,64 +554,48 @@ func (s *Server) handle(tcpConn *net.TCPConn, stopCh <-chan struct{}, wg *sync.W
 	if t := c.ChannelType(); t != "session" {
 		c.Reject(ssh.UnknownChannelType, fmt.Sprintf("unknown channel type: %s", t))
 		sshConn.Close()
-		return
+		return false
 	}
 	conn, chanReqs, err := c.Accept()
 	if err != nil {
 		log.Warn(fmt.Sprintf("could not accept channel (%s)", err))
 		sshConn.Close()
-		return
-	}
-	// non-blocking pull off the id pool
-	id := ID(0)
-	select {
-	case id, _ = <-s.idPool:
-	case <-stopCh:
-		return
-	default:
+		return false
 	}
-	// show fullgame error
-	if id == 0 {
-		conn.Write([]byte("This game is full.\r\n"))
+	// Builders ask for the files of the world rather than the game.
+	sub, chanReqs := sessionRequests(ctx, chanReqs)
+	if sub != nil {
+		s.serveFiles(ctx, sshName, hash, conn, sub, chanReqs)
 		sshConn.Close()
-		return
-	}
-	// default name using id
-	if name == "" {
-		name = fmt.Sprintf("player-%d", id)
+		return false
 	}
-	// if user has no public key for some strange reason, use their ip as their unique id
-	if hash == "" {
-		if ip, _, err := net.SplitHostPort(tcpConn.RemoteAddr().String()); err == nil {
-			hash = ip
+	ip, _, _ := net.SplitHostPort(tcpConn.RemoteAddr().String())
+	client, err := s.newClient(ctx, cancel, life, sshName, hash, ip, conn)
+	if err != nil {
+		if ctx.Err() == nil {
+			conn.Write([]byte(err.Error() + "\r\n"))
 		}
-	}
-	log.Info(fmt.Sprintf("Creating new client %q: id: %d, hash: %s", name, id, hash))
-
-	exists, err := s.loadPlayer(name)
-	if !exists {
-		log.Info(fmt.Sprintf("Player %s doesn't exists", name))
 		sshConn.Close()
+		return false
 	}
-
-	player, _ := s.GetPlayerByNick(name)
-	client := NewClient(id, sshName, name, hash, conn, &player)
-	s.clientLoggedIn(client)
-
-	// Client threads that handle all the output from the server are started here.
-	wg.Add(1)
-	client.prepareClient(s.Events, stopCh, wg)
+	go measureLatency(ctx, sshConn, client.ping)
 
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
+		defer s.guard(client, "the request handler")
 
 		for {
 			select {
-			case <-stopCh:
+			case <-ctx.Done():
 				log.Info("handle exiting.")
 				return
-			case r := <-chanReqs:
+			case r, open := <-chanReqs:
+				if !open {
+					// The channel is closed, and the connection with it.
+					cancel()
+					return
+				}
 				ok := false
 				log.Warn(fmt.Sprintf("[%s] response: %#v", r.Type, r))
 
@@ -259,9 +611,18 @@ func (s *Server) handle(tcpConn *net.TCPConn, stopCh <-chan struct{}, wg *sync.W
 					// know we have a pty ready for input
 					ok = true
 					strlen := r.Payload[3]
-					client.resizes <- parseDims(r.Payload[strlen+4:])
+					client.probe.setTerm(string(r.Payload[4 : strlen+4]))
+					s.after(0, func() { s.detectTerminal(client) })
+					client.resize(parseDims(r.Payload[strlen+4:]))
+				case "env":
+					env := struct{ Name, Value string }{}
+					if err := ssh.Unmarshal(r.Payload, &env); err == nil {
+						ok = true
+						client.probe.setEnv(env.Name, env.Value)
+						s.after(0, func() { s.detectTerminal(client) })
+					}
 				case "window-change":
-					client.resizes <- parseDims(r.Payload)
+					client.resize(parseDims(r.Payload))
 					continue // no response
 				}
 				log.Info(fmt.Sprintf("replying ok to a %q request", r.Type))
@@ -270,10 +631,105 @@ func (s *Server) handle(tcpConn *net.TCPConn, stopCh <-chan struct{}, wg *sync.W
 		}
 	}()
 
+	return s.join(ctx, client, wg)
+}
+
+// newClient makes the client of a connection that got through the handshake,
+// creating the player if they are new. The errors are meant for the player.
+// The hash is the fingerprint of their key, if they have one.
+func (s *Server) newClient(ctx context.Context, cancel context.CancelFunc, life *lifecycle, sshName, hash, ip string, conn ssh.Channel) (*Client, error) {
+	key := hash
+	// if user has no public key for some strange reason, use their ip as their unique id
+	if hash == "" {
+		hash = ip
+	}
+	// protect against XTR (cross terminal renderering) attacks
+	name := filtername.ReplaceAllString(sshName, "")
+	// trim name
+	maxlen := 100
+	if len(name) > maxlen {
+		name = string([]rune(name)[:maxlen])
+	}
+	// non-blocking pull off the id pool
+	id := ID(0)
+	select {
+	case id, _ = <-s.idPool:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	default:
+	}
+	// show fullgame error
+	if id == 0 {
+		return nil, errors.New("This game is full.")
+	}
+	// default name using id
+	if name == "" {
+		name = fmt.Sprintf("player-%d", id)
+	}
+	log.Info(fmt.Sprintf("Creating new client %q: id: %d, hash: %s", name, id, hash))
+
+	exists, err := s.loadPlayer(name)
+	if err != nil {
+		return nil, errors.New("Your player can't be loaded.")
+	}
+	if msg := s.turnedAway(ctx, name); msg != "" {
+		return nil, errors.New(msg)
+	}
+	if err := s.checkAlts(name, key, ip, exists); err != nil {
+		return nil, err
+	}
+	if !exists {
+		log.Info(fmt.Sprintf("Player %s doesn't exists, creating it", name))
+		if s.connState(ctx, life, name, StateCreating) != nil {
+			return nil, errors.New("Your player can't be created.")
+		}
+		s.CreatePlayer(name)
+	}
+	player, ok := s.GetPlayerByNick(name)
+	if !ok {
+		return nil, errors.New("That name can't be played.")
+	}
+	if !exists {
+		if err := s.savePlayer(&player); err != nil {
+			log.Error(fmt.Sprintf("Cannot save player %q: %v", name, err))
+		}
+	}
+	if err := s.db.RecordLogin(ctx, name, key, ip, time.Now()); err != nil {
+		log.Error(fmt.Sprintf("Cannot record the login of %q: %v", name, err))
+	}
+	client := NewClient(ctx, cancel, life, id, sshName, name, hash, conn, &player)
+	client.ip = ip
+	client.created = !exists
+	return client, nil
+}
+
+// join lets the client into the world and keeps the player there until the
+// connection drops. It reports whether the client got in at all.
+func (s *Server) join(ctx context.Context, client *Client, wg *sync.WaitGroup) bool {
+	// The world lets the player in, unless the connection drops first.
+	in := make(chan struct{})
 	select {
-	case s.newPlayers <- client:
-	case <-stopCh:
+	case s.worldTasks <- func() {
+		s.enter(client)
+		close(in)
+	}:
+	case <-ctx.Done():
+		return false
 	}
+	select {
+	case <-in:
+		// Client threads that handle all the output from the server are started here.
+		wg.Add(1)
+		s.prepareClient(client, wg)
+	case <-ctx.Done():
+	}
+
+	// The player stays until the connection drops.
+	<-ctx.Done()
+	s.after(0, func() {
+		s.linkdead(client)
+	})
+	return true
 }
 
 // parseDims extracts two uint32s from the provided buffer.
@@ -301,6 +757,15 @@ func fingerprintKey(k ssh.PublicKey) string {
 	return strings.Join(strbytes, ":")
 }
 
+// ctxOf returns the context of the connection of the player. Players who
+// aren't online get one that is never done.
+func (s *Server) ctxOf(nick string) context.Context {
+	if c, ok := s.clientByNick(nick); ok {
+		return c.ctx
+	}
+	return context.Background()
+}
+
 // OnlineClients returns all the online players in the server.
 func (s *Server) OnlineClients() []Client {
 	s.RLock()
@@ -310,10 +775,33 @@ func (s *Server) OnlineClients() []Client {
 	for _, onlineClient := range s.onlineClients {
 		online = append(online, *onlineClient)
 	}
+	// Players are always gone through in the same order, so simulations
+	// play out the same.
+	sort.Slice(online, func(i, j int) bool { return online[i].Name < online[j].Name })
 
 	return online
 }
 
+// clientByNick returns the online client playing the given character.
+func (s *Server) clientByNick(nickname string) (*Client, bool) {
+	s.RLock()
+	defer s.RUnlock()
+
+	c, ok := s.onlineClients[nickname]
+	return c, ok
+}
+
+// after runs the task in the world once the given time has passed.
+func (s *Server) after(d time.Duration, task func()) {
+	if s.sim != nil {
+		s.sim.schedule(d, task)
+		return
+	}
+	time.AfterFunc(d, func() {
+		s.worldTasks <- task
+	})
+}
+
 // clientLoggedIn stores the logged in player into an internal cache that holds
 // all online players.
 func (s *Server) clientLoggedIn(client *Client) {
@@ -353,9 +841,20 @@ func (s *Server) loadAreas() error {
 			return err
 		}
 
+		if area.Generator.Style != "" {
+			if err := s.generateArea(&area); err != nil {
+				log.Info(fmt.Sprintf("%s could not be generated: %v", path, err))
+				return err
+			}
+		}
+
+		s.prepareNPCs(&area)
+
 		log.Info(fmt.Sprintf("Loaded area %q", area.Name))
 		// TODO: Lock
 		s.Areas[area.Name] = area
+		rel, _ := filepath.Rel(s.staticDir, path)
+		s.areaFiles[area.Name] = filepath.ToSlash(rel)
 
 		return nil
 	}
@@ -370,7 +869,7 @@ func (s *Server) OnlineClientsGetByRoom(area, room string) []Client {
 
 	for i := range clients {
 		c := clients[i]
-		if area == c.Player.Area && room == c.Player.Room {
+		if c.Player.InRoom(area, room) {
 			clientsSameRoom = append(clientsSameRoom, c)
 		}
 	}
@@ -490,11 +989,35 @@ func (s *Server) loadPlayer(playerName string) (bool, error) {
 	return true, nil
 }
 
+// savePlayer writes the player back to its file.
+func (s *Server) savePlayer(p *area.Player) error {
+	ok, playerFileName := s.getPlayerFileName(p.Nickname)
+	if !ok {
+		return fmt.Errorf("invalid player name %q", p.Nickname)
+	}
+
+	var buf bytes.Buffer
+	if err := toml.NewEncoder(&buf).Encode(p); err != nil {
+		return err
+	}
+	// The file is replaced in one go, so a crash never leaves half a player.
+	if err := ioutil.WriteFile(playerFileName+".tmp", buf.Bytes(), 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(playerFileName+".tmp", playerFileName); err != nil {
+		return err
+	}
+
+	// TODO: Lock
+	s.Players[p.Nickname] = *p
+	return nil
+}
+
 func (s *Server) getPlayerFileName(playerName string) (bool, string) {
 	if !IsValidUsername(playerName) {
 		return false, ""
 	}
-	return true, s.staticDir + "/player/" + playerName + ".toml"
+	return true, s.dataDir + "/player/" + playerName + ".toml"
 }
 
 // IsValidUsername checks if the given player name is a valid one.
@@ -509,6 +1032,12 @@ func IsValidUsername(playerName string) bool {
 	return true
 }
 
+// KeyFingerprint returns the fingerprint players are known by for the key.
+func KeyFingerprint(key ssh.PublicKey) string {
+	m := md5.Sum(key.Marshal())
+	return hex.EncodeToString(m[:])
+}
+
 // CreatePlayer creates a player with the given nickname.
 func (s *Server) CreatePlayer(nick string) {
 	ok, playerFileName := s.getPlayerFileName(nick)
@@ -522,17 +1051,27 @@ func (s *Server) CreatePlayer(nick string) {
 		}
 		return
 	}
-	player := area.Player{
-		Nickname: nick,
-		PC:       *game.NewPC(),
-		Area:     "City",
-		Room:     "Inn",
-		Position: "1",
-	}
+	player := newPlayer(nick, s.seed)
 	// TODO: Lock
 	s.Players[player.Nickname] = player
 }
 
+// newPlayer returns the player a new character starts as. The character is
+// rolled with dice of their own, seeded from the world and their nickname,
+// so the same world rolls them the same whenever they are made.
+func newPlayer(nick string, seed int64) area.Player {
+	h := fnv.New64a()
+	h.Write([]byte(nick))
+	dice := rand.New(rand.NewSource(seed ^ int64(h.Sum64())))
+	return area.Player{
+		Nickname: nick,
+		PC:       *game.RollPC(dice),
+		Location: area.Location{Area: "City", Room: "Inn", Position: "1"},
+		Food:     game.MaxFood,
+		Drink:    game.MaxDrink,
+	}
+}
+
 // GetPlayerByNick returns the player by nickname.
 func (s *Server) GetPlayerByNick(nickname string) (area.Player, bool) {
 	player, ok := s.Players[nickname]
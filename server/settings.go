@@ -0,0 +1,191 @@
+package server
+
+import (
+	"sort"
+
+	"github.com/droslean/thyranew/area"
+	"github.com/droslean/thyranew/game"
+)
+
+// setting is one of the things the settings screen sets, by running the
+// command that sets it.
+type setting struct {
+	label   string
+	current func(c *Client) string
+	values  func() []string
+	set     func(s *Server, c *Client, value string) string
+}
+
+// onOffValues are the values of settings that are on or off.
+func onOffValues() []string {
+	return []string{"on", "off"}
+}
+
+// settingsList are the settings of the settings screen.
+var settingsList = []setting{
+	{
+		label:   "Theme",
+		current: func(c *Client) string { return themeName(c.Player) },
+		values: func() []string {
+			names := []string{}
+			for name := range themes {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			return names
+		},
+		set: func(s *Server, c *Client, v string) string { return s.theme(c.Player, []string{v}) },
+	},
+	{
+		label: "Combat output",
+		current: func(c *Client) string {
+			if c.Player.CombatLog == "" {
+				return combatVerbose
+			}
+			return c.Player.CombatLog
+		},
+		values: func() []string { return []string{combatVerbose, combatTerse, combatPane} },
+		set:    func(s *Server, c *Client, v string) string { return s.combatLog(c.Player, []string{v}) },
+	},
+	{
+		label:   "Colours",
+		current: func(c *Client) string { return themeOf(c.Player).Depth },
+		values:  func() []string { return []string{area.ColorNone, area.Color16, area.Color256, area.ColorTrue} },
+		set:     func(s *Server, c *Client, v string) string { return s.terminal(c, []string{"color", v}) },
+	},
+	{
+		label:   "Mouse",
+		current: func(c *Client) string { return onOffOf(c.Player.ClientInfo.Mouse) },
+		values:  onOffValues,
+		set:     func(s *Server, c *Client, v string) string { return s.mouse(c, []string{v}) },
+	},
+	{
+		label:   "Plain text",
+		current: func(c *Client) string { return onOffOf(c.Player.ClientInfo.Plain) },
+		values:  onOffValues,
+		set:     func(s *Server, c *Client, v string) string { return s.terminal(c, []string{"plain", v}) },
+	},
+	{
+		label:   "Output tags",
+		current: func(c *Client) string { return onOffOf(c.Player.Tags) },
+		values:  onOffValues,
+		set:     func(s *Server, c *Client, v string) string { return tags(c.Player, []string{v}) },
+	},
+	{
+		label: "Pronouns",
+		current: func(c *Client) string {
+			if c.Player.Gender == "" {
+				return "none"
+			}
+			return c.Player.Gender
+		},
+		values: func() []string { return []string{"male", "female", "none"} },
+		set:    func(s *Server, c *Client, v string) string { return s.gender(c.Player, []string{v}) },
+	},
+	{
+		label:   "Autoloot",
+		current: func(c *Client) string { return onOffOf(!c.Player.Loot.Manual) },
+		values:  onOffValues,
+		set:     func(s *Server, c *Client, v string) string { return s.lootSettings(c.Player, []string{"auto", v}) },
+	},
+	{
+		label:   "Split gold",
+		current: func(c *Client) string { return onOffOf(c.Player.Loot.Split) },
+		values:  onOffValues,
+		set:     func(s *Server, c *Client, v string) string { return s.lootSettings(c.Player, []string{"split", v}) },
+	},
+	{
+		label: "Loot rarity",
+		current: func(c *Client) string {
+			if c.Player.Loot.Rarity == "" {
+				return game.Rarities[0]
+			}
+			return c.Player.Loot.Rarity
+		},
+		values: func() []string { return game.Rarities },
+		set:    func(s *Server, c *Client, v string) string { return s.lootSettings(c.Player, []string{"rarity", v}) },
+	},
+	{
+		label:   "Stance",
+		current: func(c *Client) string { return stanceOf(c.Player) },
+		values:  func() []string { return game.Stances },
+		set:     func(s *Server, c *Client, v string) string { return s.tactics(c.Player, []string{"stance", v}) },
+	},
+	{
+		label:   "Assist group",
+		current: func(c *Client) string { return onOffOf(c.Player.Tactics.Assist) },
+		values:  onOffValues,
+		set:     func(s *Server, c *Client, v string) string { return s.tactics(c.Player, []string{"assist", v}) },
+	},
+	{
+		label:   "Rescue group",
+		current: func(c *Client) string { return onOffOf(c.Player.Tactics.Rescue) },
+		values:  onOffValues,
+		set:     func(s *Server, c *Client, v string) string { return s.tactics(c.Player, []string{"rescue", v}) },
+	},
+	{
+		label: "Group loot",
+		current: func(c *Client) string {
+			if c.Player.Loot.Rule == "" {
+				return lootFree
+			}
+			return c.Player.Loot.Rule
+		},
+		values: func() []string { return lootRules },
+		set:    func(s *Server, c *Client, v string) string { return setLootRule(c.Player, v) },
+	},
+}
+
+// onOffOf returns "on" or "off".
+func onOffOf(on bool) string {
+	if on {
+		return "on"
+	}
+	return "off"
+}
+
+// settings opens the settings screen, where the player picks a setting and
+// then its value. The screen stays open until the player closes it.
+// Usage: settings
+func (s *Server) settings(c *Client) string {
+	choices := []choice{}
+	for _, st := range settingsList {
+		choices = append(choices, choice{label: st.label, value: st.label})
+	}
+	s.openModal(c, &menu{
+		title:   "Settings",
+		choices: choices,
+		stay:    true,
+		pick: func(s *Server, c *Client, ch choice) string {
+			for _, st := range settingsList {
+				if st.label == ch.value {
+					s.openModal(c, settingMenu(c, st))
+				}
+			}
+			return ""
+		},
+	})
+	return ""
+}
+
+// settingMenu lets the player pick a value of the setting.
+func settingMenu(c *Client, st setting) *menu {
+	values := []choice{}
+	for _, v := range st.values() {
+		values = append(values, choice{label: v, value: v})
+	}
+	m := &menu{
+		title:   st.label + ", now " + st.current(c),
+		choices: values,
+		pick: func(s *Server, c *Client, ch choice) string {
+			return st.set(s, c, ch.value)
+		},
+	}
+	// The cursor starts on the value the setting has.
+	for i, v := range values {
+		if v.value == st.current(c) {
+			m.cursor = i
+		}
+	}
+	return m
+}
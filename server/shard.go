@@ -0,0 +1,141 @@
+package server
+
+import (
+	"fmt"
+	"math/rand"
+	"runtime"
+	"sort"
+)
+
+// shards share the areas and the wilderness of the world out between
+// goroutines, so the work of each area in a tick runs on a core of its own:
+// its NPCs keeping to their schedules, its weather, and the players in it
+// swimming, hungering, healing and feeling their effects, along with the
+// effects on its NPCs. An area only ever changes its own state, the NPCs and
+// the players in it, and rolls its own randomness. Anything that reaches
+// beyond it, output to players, the combat log, threat, deaths and the moves
+// they bring, is sent back to the world as effects, and run by the world in
+// the order of the areas once every shard is done.
+//
+// The world waits for its shards, so they never run alongside the commands of
+// the players. Commands, like walking from one area to another or chatting
+// across the world, and the tasks of the timers, like the rounds of fights and
+// NPCs chasing players, don't come in ticks, and stay on the world.
+type shards struct {
+	jobs []chan shardJob
+	// crashed is told of the panics of the shards.
+	crashed crashFunc
+}
+
+// shardJob is the work a shard does for a tick.
+type shardJob struct {
+	areas []string
+	rands []*rand.Rand // The randomness of each of the areas
+	work  func(areaName string, rnd *rand.Rand) []func()
+	out   chan<- areaEffects
+}
+
+// areaEffects are what the work of an area leaves for the world to do.
+type areaEffects struct {
+	area    string
+	effects []func()
+}
+
+// newShards starts the given number of shards, one for each CPU unless set.
+func newShards(n int, crashed crashFunc) *shards {
+	if n <= 0 {
+		n = runtime.NumCPU()
+	}
+	sh := &shards{jobs: make([]chan shardJob, n), crashed: crashed}
+	for i := range sh.jobs {
+		sh.jobs[i] = make(chan shardJob)
+		go sh.run(i, sh.jobs[i])
+	}
+	return sh
+}
+
+// run is the goroutine of a shard.
+func (sh *shards) run(i int, jobs <-chan shardJob) {
+	name := fmt.Sprintf("Shard %d", i)
+	for job := range jobs {
+		for k, areaName := range job.areas {
+			var effects []func()
+			// A panic costs the area its work for the tick, and nothing else.
+			panics(fmt.Sprintf("%s in %s", name, areaName), func() {
+				effects = job.work(areaName, job.rands[k])
+			}, sh.crashed)
+			job.out <- areaEffects{area: areaName, effects: effects}
+		}
+	}
+}
+
+// stop stops the shards.
+func (sh *shards) stop() {
+	for _, jobs := range sh.jobs {
+		close(jobs)
+	}
+}
+
+// size returns the number of shards.
+func (sh *shards) size() int {
+	return len(sh.jobs)
+}
+
+// each runs the work for every area and wilderness on the shards, and then
+// the effects it leaves, in the order of their names. It is called by the
+// world.
+func (w *World) each(work func(areaName string, rnd *rand.Rand) []func()) {
+	names := make([]string, 0, len(w.s.Areas)+len(w.s.Wilderness))
+	for name := range w.s.Areas {
+		names = append(names, name)
+	}
+	for name := range w.s.Wilderness {
+		if _, ok := w.s.Areas[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	// The areas are seeded in the order of their names, so simulations
+	// play out the same.
+	for _, name := range names {
+		if _, ok := w.rands[name]; !ok {
+			w.rands[name] = rand.New(rand.NewSource(w.s.rnd.Int63()))
+		}
+	}
+
+	// A simulation has no shards, and works through the areas itself.
+	sh := w.shards
+	if sh == nil {
+		for _, name := range names {
+			for _, effect := range work(name, w.rands[name]) {
+				effect()
+			}
+		}
+		return
+	}
+
+	n := sh.size()
+	jobs := make([]shardJob, n)
+	out := make(chan areaEffects, len(names))
+	for i, name := range names {
+		job := &jobs[i%n]
+		job.areas = append(job.areas, name)
+		job.rands = append(job.rands, w.rands[name])
+	}
+	for i, job := range jobs {
+		if len(job.areas) > 0 {
+			job.work, job.out = work, out
+			sh.jobs[i] <- job
+		}
+	}
+	byArea := make(map[string][]func(), len(names))
+	for range names {
+		e := <-out
+		byArea[e.area] = e.effects
+	}
+	for _, name := range names {
+		for _, effect := range byArea[name] {
+			effect()
+		}
+	}
+}
@@ -0,0 +1,278 @@
+package server
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/droslean/thyranew/area"
+	"github.com/droslean/thyranew/game"
+)
+
+// sheetGap is the space between the columns of a sheet.
+const sheetGap = 3
+
+// sheetSection is a titled part of a sheet.
+type sheetSection struct {
+	title string
+	lines []string
+}
+
+// sheet is a modal that takes the whole screen to show sections side by
+// side, in as many columns as fit, and scrolls with the arrows. Its sections
+// are built again each time it is drawn, so it stays up to date while open.
+type sheet struct {
+	title    string
+	sections func() []sheetSection
+	width    int // The width the sections are laid out in
+	top      int // The first line shown
+}
+
+// newSheet returns a sheet that fits the screen of the player.
+func newSheet(c *Client, title string, sections func() []sheetSection) *sheet {
+	return &sheet{title: title, sections: sections, width: c.w - 8}
+}
+
+func (sh *sheet) view(height int) modalView {
+	v := modalView{title: sh.title, hint: "Arrows scroll, esc closes", full: true}
+	lines := layoutSections(sh.sections(), sh.width)
+	if height < 1 {
+		height = 1
+	}
+	if sh.top > len(lines)-height {
+		sh.top = len(lines) - height
+	}
+	if sh.top < 0 {
+		sh.top = 0
+	}
+	for i := sh.top; i < len(lines) && i < sh.top+height; i++ {
+		v.lines = append(v.lines, modalLine{text: lines[i]})
+	}
+	return v
+}
+
+func (sh *sheet) key(s *Server, c *Client, key string) (string, bool) {
+	switch key {
+	case keyUp:
+		if sh.top > 0 {
+			sh.top--
+		}
+	case keyDown:
+		// The view stops it at the last line.
+		sh.top++
+	case keyResize:
+		sh.width = c.w - 8
+	case keyEnter, keyEscape:
+		return "", true
+	}
+	return "", false
+}
+
+// line leaves whatever the player types to be run as a command, so the
+// sheet can stay open while they play.
+func (sh *sheet) line(s *Server, c *Client, text string) (string, bool, bool) {
+	return "", false, false
+}
+
+// layoutSections lays the sections out in the width: side by side in two
+// columns when they fit, one under the other when they don't.
+func layoutSections(sections []sheetSection, width int) []string {
+	blocks := [][]string{}
+	colWidth := 0
+	for _, sec := range sections {
+		block := []string{sec.title}
+		for _, l := range sec.lines {
+			block = append(block, "  "+l)
+		}
+		for _, l := range block {
+			if n := len([]rune(l)); n > colWidth {
+				colWidth = n
+			}
+		}
+		blocks = append(blocks, append(block, ""))
+	}
+	if 2*colWidth+sheetGap > width {
+		lines := []string{}
+		for _, block := range blocks {
+			lines = append(lines, block...)
+		}
+		return lines
+	}
+	// Each section goes to the shorter column, in order.
+	var left, right []string
+	for _, block := range blocks {
+		if len(left) <= len(right) {
+			left = append(left, block...)
+		} else {
+			right = append(right, block...)
+		}
+	}
+	lines := []string{}
+	for i := 0; i < len(left) || i < len(right); i++ {
+		var l, r string
+		if i < len(left) {
+			l = left[i]
+		}
+		if i < len(right) {
+			r = right[i]
+		}
+		pad := colWidth + sheetGap - len([]rune(l))
+		lines = append(lines, strings.TrimRight(l+strings.Repeat(" ", pad)+r, " "))
+	}
+	return lines
+}
+
+// plainSections writes the sections out as plain lines of text, for screen
+// readers.
+func plainSections(sections []sheetSection) string {
+	var b strings.Builder
+	for _, sec := range sections {
+		fmt.Fprintf(&b, "%s:\n", sec.title)
+		for _, l := range sec.lines {
+			fmt.Fprintf(&b, "%s\n", l)
+		}
+	}
+	return b.String()
+}
+
+// score shows the player the full sheet of their character: their stats and
+// resources, what they resist and suffer from, what they carry and own, how
+// long they have played and what they achieved. Players on plain text get it
+// as lines of text instead.
+// Usage: score
+func (s *Server) score(c *Client) string {
+	p := c.Player
+	if p.ClientInfo.Plain {
+		return plainSections(s.scoreSections(p))
+	}
+	s.openModal(c, newSheet(c, displayName(p), func() []sheetSection { return s.scoreSections(p) }))
+	return ""
+}
+
+// scoreSections returns the sections of the score of the player.
+func (s *Server) scoreSections(p *area.Player) []sheetSection {
+	attrs := []struct {
+		name  string
+		value int
+	}{{"STR", p.STR}, {"DEX", p.DEX}, {"CON", p.CON}, {"INT", p.INT}, {"WIS", p.WIS}, {"CHA", p.CHA}}
+	stats := []string{}
+	saves := []string{}
+	for i := 0; i < len(attrs); i += 3 {
+		row, save := []string{}, []string{}
+		for _, a := range attrs[i : i+3] {
+			row = append(row, fmt.Sprintf("%s %2d", a.name, a.value))
+			save = append(save, fmt.Sprintf("%s %+d", a.name, game.SaveBonus(&p.PC, a.name)))
+		}
+		stats = append(stats, strings.Join(row, "  "))
+		saves = append(saves, strings.Join(save, "  "))
+	}
+	gear := s.gearBonus(p)
+	stats = append(stats,
+		fmt.Sprintf("Attack bonus %+d", p.BAB+gear.hit+game.Stance(p.Tactics.Stance).Hit-s.loadPenalty(p)),
+		fmt.Sprintf("Armor class %d", s.armorClass(p)),
+		fmt.Sprintf("Stance: %s", stanceOf(p)),
+	)
+
+	resources := []string{
+		fmt.Sprintf("Hit points: %d of %d", p.HP, game.MaxHP(&p.PC)),
+		fmt.Sprintf("Experience: %d of %d for level %d", p.XP, game.LevelXP(p.Level), p.Level+1),
+		fmt.Sprintf("Rested: %d", p.Rested),
+	}
+	if s.config.Survival {
+		resources = append(resources,
+			fmt.Sprintf("Food: %d of %d", p.Food, game.MaxFood),
+			fmt.Sprintf("Drink: %d of %d", p.Drink, game.MaxDrink),
+		)
+	}
+
+	// Players have no defenses of their own, only those their gear gives.
+	resists := append([]string{"Saving throws:"}, saves...)
+	resists = append(resists, defensesOf(gear.defenses)...)
+
+	effects := []string{}
+	for _, e := range p.Effects {
+		name := e.Name
+		if e.Stacks > 1 {
+			name = fmt.Sprintf("%s x%d", e.Name, e.Stacks)
+		}
+		if e.Caster != "" && e.Caster != p.Nickname {
+			name += " from " + e.Caster
+		}
+		if e.Ticks == 0 {
+			effects = append(effects, name)
+			continue
+		}
+		effects = append(effects, fmt.Sprintf("%s, %d ticks left", name, e.Ticks))
+	}
+	if len(effects) == 0 {
+		effects = []string{"None"}
+	}
+
+	equipment := []string{
+		fmt.Sprintf("Weapon: %s", orNone(s.held(p, p.Weapon).name())),
+		fmt.Sprintf("Off hand: %s", orNone(s.held(p, p.OffHand).name())),
+		fmt.Sprintf("Armor: %s", orNone(s.held(p, p.Armor).name())),
+		fmt.Sprintf("Wear: %d of %d", s.held(p, p.Armor).instance.Wear, game.MaxWear),
+		fmt.Sprintf("Load: %d of %d pounds", p.Load(s.weigh), s.capacity(p)),
+	}
+	if load := s.encumbrance(p); load != game.Unburdened {
+		equipment = append(equipment, fmt.Sprintf("You are %s", load))
+	}
+
+	wealth := []string{
+		fmt.Sprintf("Gold: %d", p.Gold),
+		fmt.Sprintf("Items carried: %d", len(p.Inventory)),
+	}
+	if upkeep := s.upkeepOf(p); upkeep > 0 {
+		wealth = append(wealth, fmt.Sprintf("Lodging: %s, %d gold a day", p.Bind.ToRoom, upkeep))
+	}
+
+	session := time.Duration(0)
+	if !p.LoggedIn.IsZero() {
+		session = s.now().Sub(p.LoggedIn).Round(time.Second)
+	}
+	playtime := []string{
+		fmt.Sprintf("In all: %s", (time.Duration(playtime(p, s.now())) * time.Second).String()),
+		fmt.Sprintf("This time: %s", session),
+	}
+
+	skills := skillLines(p)
+	if ranks := p.Trained[game.SkillStealth]; ranks > 0 {
+		skills = append(skills, fmt.Sprintf("Stealth: %d ranks trained", ranks))
+	}
+	skills = append(skills, fmt.Sprintf("Practices: %d", p.Practices))
+
+	achieved := append([]string{}, p.Achievements...)
+	if len(achieved) == 0 {
+		achieved = []string{"None yet"}
+	}
+
+	return []sheetSection{
+		{"Character", []string{displayName(p), fmt.Sprintf("Level %d %s", p.Level, p.Class)}},
+		{"Stats", stats},
+		{"Resources", resources},
+		{"Resistances", resists},
+		{"Effects", effects},
+		{"Equipment", equipment},
+		{"Skills", skills},
+		{"Wealth", wealth},
+		{"Playtime", playtime},
+		{"Achievements", achieved},
+	}
+}
+
+// orNone returns the name, or "none" when it's empty.
+func orNone(name string) string {
+	if name == "" {
+		return "none"
+	}
+	return name
+}
+
+// playtime returns how long, in seconds, the player has played by now.
+func playtime(p *area.Player, now time.Time) int {
+	if p.LoggedIn.IsZero() {
+		return p.Played
+	}
+	return p.Played + int(now.Sub(p.LoggedIn)/time.Second)
+}
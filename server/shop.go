@@ -0,0 +1,199 @@
+package server
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/droslean/thyranew/area"
+	"github.com/gothyra/toml"
+
+	log "gopkg.in/inconshreveable/log15.v2"
+)
+
+// loadShops loads all the shops from the static directory into memory.
+func (s *Server) loadShops() error {
+	log.Info("Loading shops ...")
+	shopWalker := func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+
+		fileContent, fileIoErr := ioutil.ReadFile(path)
+		if fileIoErr != nil {
+			log.Info(fmt.Sprintf("%s could not be loaded: %v", path, fileIoErr))
+			return fileIoErr
+		}
+
+		shop := area.Shop{}
+		if _, err := toml.Decode(string(fileContent), &shop); err != nil {
+			log.Info(fmt.Sprintf("%s could not be unmarshaled: %v", path, err))
+			return err
+		}
+
+		log.Info(fmt.Sprintf("Loaded shop %q", shop.Name))
+		s.Shops[shop.Name] = shop
+
+		return nil
+	}
+
+	return filepath.Walk(s.staticDir+"/shops/", shopWalker)
+}
+
+// openShop opens the shop to the player, if the player is welcome there.
+func (s *Server) openShop(p *area.Player, npc *area.NPC, name string) string {
+	shop, ok := s.Shops[name]
+	if !ok {
+		log.Error(fmt.Sprintf("Dialogue of %q opens unknown shop %q", npc.Name, name))
+		return ""
+	}
+	if npc.Activity == "closed" {
+		return fmt.Sprintf("%s is closed for the night, come back at dawn\n", npc.Name)
+	}
+	if !s.featureOn(featureShops) {
+		return s.featureOff(featureShops)
+	}
+	if shop.Faction != "" && !s.meetsStanding(p, shop.Faction, shop.Standing) {
+		return fmt.Sprintf("%s refuses to trade with you\n", npc.Name)
+	}
+	p.Shop = name
+	return s.listWares(shop)
+}
+
+// waresOf returns the wares the shop sells now, leaving out those whose
+// content flag is off.
+func (s *Server) waresOf(shop area.Shop) []area.Ware {
+	wares := []area.Ware{}
+	for _, w := range shop.Wares {
+		if s.contentOn(w.Flag) {
+			wares = append(wares, w)
+		}
+	}
+	return wares
+}
+
+// listWares lists what the shop sells, at the prices of the day.
+func (s *Server) listWares(shop area.Shop) string {
+	wares := []string{}
+	for _, w := range s.waresOf(shop) {
+		wares = append(wares, fmt.Sprintf("%s (%d gold)", w.Item, s.priceOf(shop.Name, w)))
+	}
+	return fmt.Sprintf("For sale: %s\n", strings.Join(wares, ", "))
+}
+
+// list shows the wares of the open shop.
+func (s *Server) list(p *area.Player) string {
+	if _, ok := s.talkingTo(p); !ok || p.Shop == "" {
+		return "There is nothing for sale here\n"
+	}
+	return s.listWares(s.Shops[p.Shop])
+}
+
+// maxBuy is the most of a ware that is bought at once.
+const maxBuy = 20
+
+// buy buys an item from the open shop. Without an item, the wares are picked
+// from a list.
+// Usage: buy [item]
+func (s *Server) buy(c *Client, args []string) string {
+	p := c.Player
+	if _, ok := s.talkingTo(p); !ok || p.Shop == "" {
+		return "There is nothing for sale here\n"
+	}
+	if len(args) == 0 {
+		s.openModal(c, s.waresMenu(p.Shop))
+		return ""
+	}
+	return s.buyWares(p, strings.Join(args, " "), 1)
+}
+
+// waresMenu lets the player pick a ware of the shop, and how many of it to
+// buy.
+func (s *Server) waresMenu(name string) *menu {
+	shop := s.Shops[name]
+	wares := []choice{}
+	for _, w := range s.waresOf(shop) {
+		wares = append(wares, choice{label: fmt.Sprintf("%s (%d gold)", w.Item, s.priceOf(name, w)), value: w.Item})
+	}
+	return &menu{
+		title:   "Buy from " + shop.Name,
+		choices: wares,
+		pick: func(s *Server, c *Client, ware choice) string {
+			s.openModal(c, &textInput{
+				title:  "Buy " + ware.value,
+				prompt: fmt.Sprintf("How many? You have %d gold", c.Player.Gold),
+				value:  "1",
+				check: func(text string) error {
+					if n, err := strconv.Atoi(text); err != nil || n < 1 || n > maxBuy {
+						return fmt.Errorf("Type a number from 1 to %d", maxBuy)
+					}
+					return nil
+				},
+				submit: func(s *Server, c *Client, text string) string {
+					n, _ := strconv.Atoi(text)
+					price := 0
+					for _, w := range s.waresOf(s.Shops[name]) {
+						if w.Item == ware.value {
+							price = s.quote(name, w, n)
+						}
+					}
+					question := fmt.Sprintf("Buy %d %s for %d gold?", n, ware.value, price)
+					s.openModal(c, newConfirm(question, func(s *Server, c *Client) string {
+						return s.buyWares(c.Player, ware.value, n)
+					}, nil))
+					return ""
+				},
+			})
+			return ""
+		},
+	}
+}
+
+// itemCount names n of the item.
+func itemCount(item string, n int) string {
+	if n == 1 {
+		return item
+	}
+	return fmt.Sprintf("%d %s", n, item)
+}
+
+// buyWares buys n of the item from the open shop.
+func (s *Server) buyWares(p *area.Player, item string, n int) string {
+	npc, ok := s.talkingTo(p)
+	if !ok || p.Shop == "" {
+		return "There is nothing for sale here\n"
+	}
+
+	name := strings.ToLower(item)
+	for _, w := range s.waresOf(s.Shops[p.Shop]) {
+		if strings.ToLower(w.Item) != name {
+			continue
+		}
+		price := s.quote(p.Shop, w, n)
+		if p.Gold < price {
+			return fmt.Sprintf("You can't afford %s\n", w.Item)
+		}
+		bought := make([]string, n)
+		for i := range bought {
+			bought[i] = w.Item
+		}
+		if !s.fits(p, bought...) {
+			return fmt.Sprintf("You can't carry %s on top of what you do\n", itemCount(w.Item, n))
+		}
+		p.Gold -= price
+		s.goldFlow(flowShops, -price)
+		s.sold(p.Shop, w.Item, n)
+		before := s.encumbrance(p)
+		for i := 0; i < n; i++ {
+			s.receive(p, w.Item)
+		}
+		return fmt.Sprintf("You buy %s from %s for %d gold\n", itemCount(w.Item, n), npc.Name, price) + s.loadNote(p, before)
+	}
+	return fmt.Sprintf("%s does not sell %s\n", npc.Name, item)
+}
@@ -0,0 +1,102 @@
+package server
+
+import (
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Simulation is a world that only moves when told to. Its clock starts at
+// Start and goes forward a tick at a time, and all of its randomness comes
+// from Seed, so a scenario plays out the same way every time it is run.
+type Simulation struct {
+	Seed  int64
+	Start time.Time // The world epoch, unless set
+	// replay has the commands of the players come from a journal alone.
+	replay bool
+}
+
+// simClock is the clock of a simulation, with the tasks waiting on it.
+type simClock struct {
+	mu     sync.Mutex
+	now    time.Time
+	seq    int // Keeps the order of timers due at the same time
+	timers []simTimer
+}
+
+type simTimer struct {
+	at   time.Time
+	seq  int
+	task func()
+}
+
+// advance asks the world to go through a number of ticks.
+type advance struct {
+	ticks int
+	done  chan struct{}
+}
+
+// simulate turns the server into a simulation. It is called before the world
+// is loaded, by NewServerWith.
+func (s *Server) simulate(sim Simulation) {
+	start := sim.Start
+	if start.IsZero() {
+		start = worldEpoch
+	}
+	s.rnd, s.seed = rand.New(rand.NewSource(sim.Seed)), sim.Seed
+	s.sim = &simClock{now: start}
+	if sim.replay {
+		s.replay = &replayed{}
+	}
+}
+
+// Advance moves a simulation on by the given number of ticks, running the
+// tasks that come due on the way. It returns once the world is done with them.
+func (s *Server) Advance(ticks int) {
+	a := advance{ticks: ticks, done: make(chan struct{})}
+	s.world.advances <- a
+	<-a.done
+}
+
+// now returns the time of the world. It is the real time, unless the world is
+// a simulation.
+func (s *Server) now() time.Time {
+	if s.sim == nil {
+		return time.Now()
+	}
+	s.sim.mu.Lock()
+	defer s.sim.mu.Unlock()
+	return s.sim.now
+}
+
+// schedule runs the task once the clock has moved on by d.
+func (c *simClock) schedule(d time.Duration, task func()) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.seq++
+	c.timers = append(c.timers, simTimer{at: c.now.Add(d), seq: c.seq, task: task})
+	sort.Slice(c.timers, func(i, j int) bool {
+		if c.timers[i].at.Equal(c.timers[j].at) {
+			return c.timers[i].seq < c.timers[j].seq
+		}
+		return c.timers[i].at.Before(c.timers[j].at)
+	})
+}
+
+// next moves the clock to the first task due by the given time, and returns
+// it. Once there are none left, the clock is left at that time.
+func (c *simClock) next(until time.Time) (func(), bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.timers) == 0 || c.timers[0].at.After(until) {
+		c.now = until
+		return nil, false
+	}
+	t := c.timers[0]
+	c.timers = c.timers[1:]
+	if t.at.After(c.now) {
+		c.now = t.at
+	}
+	return t.task, true
+}
@@ -0,0 +1,91 @@
+package server
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/droslean/thyranew/game"
+)
+
+// scenarioStart is when the worlds of the scenarios start.
+var scenarioStart = time.Date(2020, time.March, 1, 6, 0, 0, 0, time.UTC)
+
+// runScenario plays a scenario in a simulation of the given seed, on the given
+// number of shards, none for the world to work through the areas itself. The
+// players log in poisoned and hungry, the NPCs burn, and the world moves on
+// for a few hours of the game. It returns how the world ended up.
+func runScenario(t *testing.T, seed int64, shards int) string {
+	t.Helper()
+	h := startHarness(t, &Simulation{Seed: seed, Start: scenarioStart})
+	players := []string{"alice", "bob", "carol", "dave"}
+	for _, nick := range players {
+		connect(t, h, nick)
+	}
+
+	s := h.Server
+	if !s.inWorld(h.ctx, func() {
+		if shards > 0 {
+			s.world.shards = newShards(shards, s.reportCrash)
+		}
+		s.config.Survival = true
+		for i, cl := range s.OnlineClients() {
+			cl.Player.Food, cl.Player.HP = 21, 12
+			addEffect(cl.Player, game.Effect{Name: "Poison", Damage: 1, Ticks: 10, Caster: players[(i+1)%len(players)], Message: "You feel sick\n"})
+		}
+		for _, a := range s.Areas {
+			for i := range a.NPCs {
+				a.NPCs[i].Effects = append(a.NPCs[i].Effects, game.Effect{Name: "Burn", Damage: 2, Ticks: 5, Caster: "alice"})
+			}
+		}
+	}) {
+		t.Fatal("the world stopped")
+	}
+	h.Advance(200)
+
+	var lines []string
+	if !s.inWorld(h.ctx, func() {
+		for _, cl := range s.OnlineClients() {
+			p := cl.Player
+			lines = append(lines, fmt.Sprintf("%s %+v hp %d food %d drink %d ghost %v at %s/%s/%s effects %v",
+				p.Nickname, p.PC, p.HP, p.Food, p.Drink, p.Ghost, p.Area, p.Room, p.Position, p.Effects))
+		}
+		for name, a := range s.Areas {
+			for _, npc := range a.NPCs {
+				lines = append(lines, fmt.Sprintf("%s %d %s hp %d at %s/%s effects %v", name, npc.ID, npc.Name, npc.HP, npc.Room, npc.Position, npc.Effects))
+			}
+		}
+		for name, w := range s.weather {
+			lines = append(lines, fmt.Sprintf("weather %s %s", name, w))
+		}
+		if s.world.shards != nil {
+			s.world.shards.stop()
+			s.world.shards = nil
+		}
+	}) {
+		t.Fatal("the world stopped")
+	}
+	sort.Strings(lines)
+	return strings.Join(lines, "\n")
+}
+
+func TestSimulationReplays(t *testing.T) {
+	first, second := runScenario(t, 42, 0), runScenario(t, 42, 0)
+	if first != second {
+		t.Fatalf("the same seed played out differently:\n%s\n\nand then:\n%s", first, second)
+	}
+	if other := runScenario(t, 7, 0); other == first {
+		t.Errorf("another seed played out the same:\n%s", other)
+	}
+}
+
+func TestSimulationShards(t *testing.T) {
+	alone := runScenario(t, 42, 0)
+	for _, shards := range []int{1, 3, 8} {
+		if sharded := runScenario(t, 42, shards); sharded != alone {
+			t.Errorf("on %d shards the scenario played out differently:\n%s\n\nfrom the world alone:\n%s", shards, sharded, alone)
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package server
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/droslean/thyranew/area"
+	"github.com/gothyra/toml"
+
+	log "gopkg.in/inconshreveable/log15.v2"
+)
+
+// loadSocials loads the socials from the static directory into memory.
+func (s *Server) loadSocials() error {
+	path := s.staticDir + "/socials.toml"
+	fileContent, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		log.Info(fmt.Sprintf("%s could not be loaded: %v", path, err))
+		return err
+	}
+
+	socials := struct {
+		Socials []area.Social `toml:"socials"`
+	}{}
+	if _, err := toml.Decode(string(fileContent), &socials); err != nil {
+		log.Info(fmt.Sprintf("%s could not be unmarshaled: %v", path, err))
+		return err
+	}
+
+	for _, social := range socials.Socials {
+		log.Info(fmt.Sprintf("Loaded social %q", social.Name))
+		s.Socials[social.Name] = social
+	}
+	return nil
+}
+
+// social does the social for the room to see, on its own or at a player or an
+// NPC in the room.
+// Usage: <social> [target]
+func (s *Server) social(roomsMap map[string]map[string][][]area.Cube, p *area.Player, social area.Social, args []string) string {
+	if m, muted := p.MutedOn(area.AllChannels, s.now()); muted {
+		return mutedNotice(m)
+	}
+	b := Broadcast{Scope: ScopeRoom, Area: p.Area, Room: p.Room, From: p.Nickname, Text: social.Alone + "\n"}
+	if len(args) == 0 {
+		return s.act(roomsMap, b, playerActor(p), nil)
+	}
+
+	name := strings.Join(args, " ")
+	var target *Actor
+	if other, ok := s.playerHere(p, name); ok {
+		target = playerActor(other)
+	}
+	if target == nil {
+		for _, npc := range s.npcsInRoom(p.Area, p.Room) {
+			if strings.EqualFold(npc.Name, name) {
+				target = npcActor(&npc)
+				break
+			}
+		}
+	}
+	if target == nil {
+		return fmt.Sprintf("There is no %s here\n", name)
+	}
+	b.Text = social.Target + "\n"
+	return s.act(roomsMap, b, playerActor(p), target)
+}
@@ -0,0 +1,180 @@
+package server
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/droslean/thyranew/area"
+)
+
+// spectatorCommands are all that spectators can do. Anything else has to wait
+// until they stop watching.
+var spectatorCommands = map[string]bool{
+	"": true, "quit": true, "stop": true, "watch": true, "spectators": true,
+	"cheer": true, "jeer": true, "time": true, "weather": true, "chat": true,
+	"combatlog": true, "prompt": true, "tags": true, "theme": true,
+	"terminal": true, "mouse": true, "scroll": true, "settings": true,
+	"describe": true, "flag": true, "wanted": true, "hints": true,
+	"score": true, "compare": true, "inspect": true, "keys": true,
+	"tactics": true, "cooldowns": true, "skills": true, "practice": true,
+	"history": true, "obituaries": true, "link": true, "unlink": true, "export": true, "delete": true,
+	"tell": true, "cluster": true, "intermud": true, "who": true,
+}
+
+// watchable reports whether others can watch the fights of the player: those
+// in an arena, and those fighting a boss.
+func (s *Server) watchable(p *area.Player) bool {
+	if s.Areas[p.Area].Arena {
+		return true
+	}
+	npc := s.opponent(p)
+	return npc != nil && npc.Boss
+}
+
+// viewOf returns the player whose room the player sees.
+func (s *Server) viewOf(p *area.Player) *area.Player {
+	if p.Watching == "" {
+		return p
+	}
+	if cl, ok := s.clientByNick(p.Watching); ok {
+		return cl.Player
+	}
+	return p
+}
+
+// watch starts watching the fights of another player.
+// Usage: watch <player>
+func (s *Server) watch(p *area.Player, args []string) string {
+	if len(args) == 0 {
+		return "Usage: watch <player>\n"
+	}
+	cl, ok := s.clientByNick(args[0])
+	if !ok || cl.Player.Nickname == p.Nickname {
+		return fmt.Sprintf("%s is not around\n", args[0])
+	}
+	if s.inCombat(p) {
+		return "You are too busy fighting\n"
+	}
+	if cl.Player.Watching != "" {
+		return fmt.Sprintf("%s is watching someone else\n", cl.Player.Nickname)
+	}
+	if !s.watchable(cl.Player) {
+		return fmt.Sprintf("%s is not in the arena or fighting a boss\n", cl.Player.Nickname)
+	}
+	p.Watching = cl.Player.Nickname
+	return fmt.Sprintf("You watch %s\n", p.Watching)
+}
+
+// stopWatching goes back to the room of the player.
+func stopWatching(p *area.Player) string {
+	if p.Watching == "" {
+		return "You aren't watching anyone\n"
+	}
+	msg := fmt.Sprintf("You stop watching %s\n", p.Watching)
+	p.Watching = ""
+	return msg
+}
+
+// spectatorsOf returns the online players watching the given player.
+func (s *Server) spectatorsOf(nick string) []Client {
+	spectators := []Client{}
+	for _, cl := range s.OnlineClients() {
+		if cl.Player.Watching == nick {
+			spectators = append(spectators, cl)
+		}
+	}
+	return spectators
+}
+
+// spectators lists who watches the fight of the player, or of the player
+// someone else watches.
+// Usage: spectators [player]
+func (s *Server) spectators(p *area.Player, args []string) string {
+	nick := p.Nickname
+	if len(args) > 0 {
+		nick = args[0]
+	} else if p.Watching != "" {
+		nick = p.Watching
+	}
+	names := []string{}
+	for _, cl := range s.spectatorsOf(nick) {
+		names = append(names, cl.Player.Nickname)
+	}
+	if len(names) == 0 {
+		return fmt.Sprintf("Nobody is watching %s\n", nick)
+	}
+	return fmt.Sprintf("Watching %s: %s\n", nick, strings.Join(names, ", "))
+}
+
+// cheer lets spectators cheer for or jeer at the player they watch. The
+// contestants hear it, and so do the other spectators.
+func (s *Server) cheer(roomsMap map[string]map[string][][]area.Cube, p *area.Player, cmd string) string {
+	if p.Watching == "" {
+		return fmt.Sprintf("You have nobody to %s\n", cmd)
+	}
+	cl, ok := s.clientByNick(p.Watching)
+	if !ok {
+		return stopWatching(p)
+	}
+	msg := fmt.Sprintf("%s cheers for %s\n", p.Nickname, cl.Player.Nickname)
+	if cmd == "jeer" {
+		msg = fmt.Sprintf("%s jeers at %s\n", p.Nickname, cl.Player.Nickname)
+	}
+	s.broadcast(roomsMap, Broadcast{Scope: ScopeRoom, Area: cl.Player.Area, Room: cl.Player.Room, Kind: TagChat, Text: msg, From: p.Nickname})
+	others := []string{}
+	for _, spectator := range s.spectatorsOf(cl.Player.Nickname) {
+		others = append(others, spectator.Player.Nickname)
+	}
+	s.broadcast(roomsMap, Broadcast{Scope: ScopeGroup, Nicks: others, Kind: TagChat, Text: msg, From: p.Nickname})
+	return msg
+}
+
+// forwardToSpectators sends the combat the clients saw to whoever watches them.
+// Spectators never pass it on, so it goes no further than one hop.
+func (s *Server) forwardToSpectators(clients []Client, roomsMap map[string]map[string][][]area.Cube, pieces []TaggedText) {
+	fight := ""
+	for _, piece := range pieces {
+		if piece.Kind == TagCombat || piece.Kind == TagSummary {
+			fight += tagged(piece.Kind, piece.Text)
+		}
+	}
+	if fight == "" {
+		return
+	}
+
+	printed := map[string]bool{}
+	for _, c := range clients {
+		printed[c.Player.Nickname] = true
+	}
+	spectators := []Client{}
+	for _, c := range clients {
+		if c.Player.Watching != "" {
+			continue
+		}
+		for _, spectator := range s.spectatorsOf(c.Player.Nickname) {
+			if !printed[spectator.Player.Nickname] {
+				printed[spectator.Player.Nickname] = true
+				spectators = append(spectators, spectator)
+			}
+		}
+	}
+	if len(spectators) > 0 {
+		s.godPrintRoom(spectators, roomsMap, fight, "")
+	}
+}
+
+// checkSpectators sends the spectators back once there is nothing left to
+// watch, or when they are attacked themselves.
+func (s *Server) checkSpectators(roomsMap map[string]map[string][][]area.Cube) {
+	for _, cl := range s.OnlineClients() {
+		p := cl.Player
+		if p.Watching == "" {
+			continue
+		}
+		contestant, ok := s.clientByNick(p.Watching)
+		if ok && s.watchable(contestant.Player) && !s.inCombat(p) {
+			continue
+		}
+		s.godPrintRoom([]Client{cl}, roomsMap, stopWatching(p), "")
+	}
+}
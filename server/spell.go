@@ -0,0 +1,152 @@
+package server
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/droslean/thyranew/area"
+	"github.com/droslean/thyranew/game"
+)
+
+// castArea casts a spell on the caster, their group, or everyone around
+// them, and resolves it on all it reaches at once.
+func (s *Server) castArea(roomsMap map[string]map[string][][]area.Cube, cl *Client, spell game.RangedAttack) string {
+	p := cl.Player
+	players, npcs := s.spellTargets(roomsMap, p, spell)
+	name := strings.ToLower(spell.Name)
+	if len(players)+len(npcs) == 0 {
+		return fmt.Sprintf("Your %s reaches nobody\n", name)
+	}
+	s.startGlobalCooldown(roomsMap, p)
+
+	var msg strings.Builder
+	if spell.Heal {
+		for _, o := range players {
+			heal := game.HealRoll(&p.PC, spell, s.rnd)
+			if full := game.MaxHP(&o.PC); o.HP+heal > full {
+				heal = full - o.HP
+			}
+			o.HP += heal
+			s.healThreat(roomsMap, p, o, heal)
+			if spell.Effect.Name != "" {
+				addEffect(o, castEffect(p, spell.Effect))
+			}
+			text := fmt.Sprintf("$p %s mends $P wounds by %d\n", name, heal)
+			if o == p {
+				text = fmt.Sprintf("$p %s mends $s wounds by %d\n", name, heal)
+			}
+			b := Broadcast{Scope: ScopeRoom, Area: o.Area, Room: o.Room, From: p.Nickname, Text: text}
+			msg.WriteString(s.act(roomsMap, b, playerActor(p), playerActor(o)))
+		}
+		return msg.String()
+	}
+
+	weather := s.weatherAt(p)
+	if game.ElementalDamage(weather, spell.Element, spell.Die) == 0 {
+		return tagged(TagCombat, fmt.Sprintf("The storm puts out your %s\n", name))
+	}
+	for _, id := range npcs {
+		npc, areaName := s.findNPC(id)
+		if npc == nil {
+			continue
+		}
+		_, damage, fared := s.rollHit(p, spell, 0, &npc.PC, npc.Defenses)
+		damage = game.ElementalDamage(weather, spell.Element, damage)
+		npc.HP -= damage
+		s.recordCombat(CombatEvent{Player: p.Nickname, Kind: combatDealt, Source: spell.Name, Amount: damage})
+		s.addThreat(npc, p.Nickname, game.Threat(&p.PC, damage))
+		b := Broadcast{Scope: ScopeRoom, Area: areaName, Room: npc.Room, From: p.Nickname, Kind: TagCombat, Text: spellHitText(name, damage, fared, spell.Element, true)}
+		msg.WriteString(s.act(roomsMap, b, playerActor(p), npcActor(npc)))
+		if npc.HP <= 0 {
+			s.killNPC(roomsMap, cl, id)
+			continue
+		}
+		if spell.Effect.Name != "" && fared != game.DamageImmune {
+			addNPCEffect(npc, p, spell.Effect)
+		}
+		s.provoke(roomsMap, npc, p.Nickname)
+	}
+	for _, o := range players {
+		p.LastHostile = s.now()
+		_, damage, fared := s.rollHit(p, spell, 0, &o.PC, s.gearBonus(o).defenses)
+		damage = game.ElementalDamage(weather, spell.Element, damage)
+		o.HP -= damage
+		s.recordCombat(CombatEvent{Player: p.Nickname, Kind: combatDealt, Source: spell.Name, Amount: damage})
+		s.recordCombat(CombatEvent{Player: o.Nickname, Kind: combatTaken, Source: p.Nickname, Amount: damage})
+		b := Broadcast{Scope: ScopeRoom, Area: o.Area, Room: o.Room, From: p.Nickname, Kind: TagCombat, Text: spellHitText(name, damage, fared, spell.Element, false)}
+		msg.WriteString(s.act(roomsMap, b, playerActor(p), playerActor(o)))
+		if o.HP <= 0 {
+			s.killPlayer(roomsMap, o, p.Nickname)
+			if s.witnessed(p, 0) {
+				s.reportCrime(roomsMap, p, area.CrimeMurder, "")
+			}
+		} else if spell.Effect.Name != "" && fared != game.DamageImmune {
+			addEffect(o, castEffect(p, spell.Effect))
+		}
+	}
+	return msg.String()
+}
+
+// spellTargets resolves who the spell reaches: the players, and the NPCs by
+// their IDs. Spells that heal reach players alone. Spells that hurt spare the
+// caster and their group, and reach other players only when they take part
+// in fights between players.
+func (s *Server) spellTargets(roomsMap map[string]map[string][][]area.Cube, p *area.Player, spell game.RangedAttack) ([]*area.Player, []int) {
+	switch spell.Target {
+	case game.TargetSelf:
+		return []*area.Player{p}, nil
+	case game.TargetGroup:
+		return s.group(p), nil
+	}
+
+	rooms := []roomKey{{p.Area, p.Room}}
+	if spell.Target == game.TargetAdjacent {
+		mapArray := roomsMap[p.Area][p.Room]
+		for _, d := range area.Doors(mapArray) {
+			if door := mapArray[d[0]][d[1]]; !door.Closed {
+				rooms = append(rooms, roomKey{door.Exits[0].ToArea, door.Exits[0].ToRoom})
+			}
+		}
+	}
+	spared := map[string]bool{}
+	if !spell.Heal {
+		for _, m := range s.group(p) {
+			spared[m.Nickname] = true
+		}
+	}
+
+	players := []*area.Player{}
+	npcs := []int{}
+	seen := map[roomKey]bool{}
+	for _, k := range rooms {
+		if seen[k] {
+			continue
+		}
+		seen[k] = true
+		for _, c := range s.OnlineClientsGetByRoom(k.area, k.room) {
+			o := c.Player
+			if o.Ghost || spared[o.Nickname] {
+				continue
+			}
+			if !spell.Heal && (!canSee(p, o) || s.consent(p, o, actAttack) != nil) {
+				continue
+			}
+			players = append(players, o)
+		}
+		if !spell.Heal {
+			for _, npc := range s.npcsInRoom(k.area, k.room) {
+				npcs = append(npcs, npc.ID)
+			}
+		}
+	}
+	return players, npcs
+}
+
+// spellHitText returns the template that tells what the spell did to a
+// target, and how it fared against their defenses.
+func spellHitText(name string, damage int, fared, kind string, npcTarget bool) string {
+	if fared == game.DamageImmune {
+		return defenseText(fared, kind, npcTarget)
+	}
+	return fmt.Sprintf("$p %s hits $N for %d\n", name, damage) + defenseText(fared, kind, npcTarget)
+}
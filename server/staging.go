@@ -0,0 +1,655 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/droslean/thyranew/area"
+	"github.com/gothyra/toml"
+
+	log "gopkg.in/inconshreveable/log15.v2"
+)
+
+const (
+	// maxVersions is how many versions each file of the world keeps from
+	// before it was last changed, to roll back to.
+	maxVersions = 10
+	// versionStamp is how the versions are named, so they sort by time.
+	versionStamp = "20060102-150405.000000000"
+	// diffContext is how many lines around what changed the diffs show.
+	diffContext = 2
+)
+
+// worldChange is a change to a file of the world that waits in the staging
+// directory for an admin to publish it. Files to be taken away are staged
+// empty.
+type worldChange struct {
+	File string    `toml:"file"`           // From the static directory, with slashes
+	Area string    `toml:"area,omitempty"` // The area the file holds, if it does
+	By   string    `toml:"by"`
+	At   time.Time `toml:"at"`
+}
+
+// name is what the change goes by in the commands: its area, or the file.
+func (c worldChange) name() string {
+	if c.Area != "" {
+		return c.Area
+	}
+	return strings.TrimSuffix(c.File, ".toml")
+}
+
+// stagingDir is where the staged files are kept, along with what they are.
+func (s *Server) stagingDir() string {
+	return filepath.Join(s.dataDir, "staging")
+}
+
+// versionsDir is where the versions of the file are kept.
+func (s *Server) versionsDir(file string) string {
+	return filepath.Join(s.dataDir, "versions", filepath.FromSlash(file))
+}
+
+// loadStaged reads the changes that were waiting for an admin when the
+// server stopped.
+func (s *Server) loadStaged() error {
+	changes := struct {
+		Changes []worldChange `toml:"changes"`
+	}{}
+	if _, err := toml.DecodeFile(filepath.Join(s.stagingDir(), "changes.toml"), &changes); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	for _, c := range changes.Changes {
+		s.staged[c.File] = c
+	}
+	if len(s.staged) > 0 {
+		log.Info(fmt.Sprintf("%d changes to the world wait to be published", len(s.staged)))
+	}
+	return nil
+}
+
+// saveStaged writes what the staged files are.
+func (s *Server) saveStaged() error {
+	changes := struct {
+		Changes []worldChange `toml:"changes"`
+	}{}
+	for _, c := range s.staged {
+		changes.Changes = append(changes.Changes, c)
+	}
+	sort.Slice(changes.Changes, func(i, j int) bool { return changes.Changes[i].File < changes.Changes[j].File })
+	var buf bytes.Buffer
+	if err := toml.NewEncoder(&buf).Encode(changes); err != nil {
+		return err
+	}
+	return writeAtomic(filepath.Join(s.stagingDir(), "changes.toml"), buf.Bytes())
+}
+
+// writeAtomic replaces the file in one go, so the server never reads half
+// of it.
+func writeAtomic(name string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(name+".tmp", data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(name+".tmp", name)
+}
+
+// worldData returns what the file of the world holds, with the change
+// waiting for an admin if there is one. Files that are not there, or are to
+// be taken away, return an error os.IsNotExist reports.
+func (s *Server) worldData(file string) ([]byte, error) {
+	name := filepath.Join(s.staticDir, filepath.FromSlash(file))
+	if _, ok := s.staged[file]; ok {
+		name = filepath.Join(s.stagingDir(), filepath.FromSlash(file))
+	}
+	data, err := ioutil.ReadFile(name)
+	if err == nil && len(data) == 0 {
+		return nil, os.ErrNotExist
+	}
+	return data, err
+}
+
+// writeWorld writes the file of the world and puts what it holds in the
+// game, or takes it away without data. When the edits are reviewed, it is
+// staged for an admin to publish instead, and reports so. It runs on the
+// world.
+func (s *Server) writeWorld(file, areaName, by string, data []byte) (bool, error) {
+	if !s.config.Review {
+		return false, s.publishFile(file, data, true)
+	}
+	if err := writeAtomic(filepath.Join(s.stagingDir(), filepath.FromSlash(file)), data); err != nil {
+		return false, err
+	}
+	c := worldChange{File: file, Area: areaName, By: by, At: s.now()}
+	s.staged[file] = c
+	if err := s.saveStaged(); err != nil {
+		return false, err
+	}
+	log.Info(fmt.Sprintf("%q staged a change to %s", by, file))
+	s.broadcast(s.world.rooms, Broadcast{Kind: TagSystem, Text: fmt.Sprintf("%s staged a change to %s, see staged %s\n", by, c.name(), c.name()), Filter: isAdmin})
+	return true, nil
+}
+
+// publishFile writes the file of the static directory, or takes it away
+// without data, and puts what it holds in the game. What the file held
+// before is kept as a version to roll back to if keep is set. It runs on the
+// world.
+func (s *Server) publishFile(file string, data []byte, keep bool) error {
+	name := filepath.Join(s.staticDir, filepath.FromSlash(file))
+	old, err := ioutil.ReadFile(name)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if keep {
+		if err := s.keepVersion(file, old); err != nil {
+			return err
+		}
+	}
+	if len(data) > 0 {
+		err = writeAtomic(name, data)
+	} else if err = os.Remove(name); os.IsNotExist(err) {
+		err = nil
+	}
+	if err != nil {
+		return err
+	}
+
+	switch top := strings.Split(file, "/")[0]; {
+	case file == "items.toml":
+		log.Info(strings.TrimSpace(s.reloadItems()))
+	case strings.HasPrefix(file, "areas/"):
+		return s.putArea(file, old, data)
+	case worldFiles[top].reload != nil:
+		log.Info(strings.TrimSpace(worldFiles[top].reload(s, file)))
+	}
+	return nil
+}
+
+// putArea puts the area the file now holds in place of the one it held, or
+// takes it out of the world when it holds none. It runs on the world.
+func (s *Server) putArea(file string, old, data []byte) error {
+	before := area.Area{}
+	if _, err := toml.Decode(string(old), &before); err != nil {
+		return err
+	}
+	if len(data) == 0 {
+		delete(s.areaFiles, before.Name)
+		s.replaceArea(s.world.rooms, area.Area{Name: before.Name})
+		delete(s.Areas, before.Name)
+		delete(s.dormant, before.Name)
+		delete(s.world.rooms, before.Name)
+		log.Info(fmt.Sprintf("Area %q was taken out of the world", before.Name))
+		return nil
+	}
+
+	a := area.Area{}
+	if _, err := toml.Decode(string(data), &a); err != nil {
+		return err
+	}
+	if a.Generator.Style != "" {
+		if err := s.generateArea(&a); err != nil {
+			return err
+		}
+	} else {
+		s.prepareNPCs(&a)
+	}
+	s.areaFiles[a.Name] = file
+	s.syncDescriptions(before, a)
+	s.replaceArea(s.world.rooms, a)
+	log.Info(fmt.Sprintf("Area %q was put in the world from %s", a.Name, file))
+	return nil
+}
+
+// syncDescriptions gives the rooms whose descriptions the file changed those
+// descriptions, in place of the ones builders wrote in the game, which would
+// stand in for them otherwise.
+func (s *Server) syncDescriptions(before, after area.Area) {
+	written, err := s.db.RoomDescriptions(context.Background())
+	if err != nil {
+		log.Error(fmt.Sprintf("Cannot read the descriptions builders wrote: %v", err))
+		return
+	}
+	for name, room := range after.Rooms {
+		text := strings.TrimSuffix(room.Description, "\n")
+		if _, ok := written[after.Name+"/"+name]; !ok || text == "" || room.Description == before.Rooms[name].Description {
+			continue
+		}
+		if err := s.db.PutRoomDescription(context.Background(), after.Name, name, text); err != nil {
+			log.Error(fmt.Sprintf("Cannot keep the description of %s/%s: %v", after.Name, name, err))
+		}
+	}
+}
+
+// checkWorldFile reports what is wrong with what the file of the world is to
+// hold, against the world as it runs.
+func (s *Server) checkWorldFile(file string, data []byte) error {
+	// Files builders put that hold neither areas nor items were read when
+	// they were put, and have nothing of the running world to fit.
+	if file != "items.toml" && !strings.HasPrefix(file, "areas/") {
+		return nil
+	}
+	if file == "items.toml" {
+		items := itemsFile{}
+		_, err := toml.Decode(string(data), &items)
+		return err
+	}
+	if len(data) > 0 {
+		a := area.Area{}
+		if _, err := toml.Decode(string(data), &a); err != nil {
+			return err
+		}
+		return s.checkArea(a)
+	}
+	for name, f := range s.areaFiles {
+		if f != file {
+			continue
+		}
+		if ways := s.waysInto(name, func(string, string) bool { return false }); len(ways) > 0 {
+			return fmt.Errorf("%s is still reached from %s", name, strings.Join(ways, ", "))
+		}
+	}
+	return nil
+}
+
+// keepVersion keeps what the file held as its newest version, and forgets
+// the oldest ones past maxVersions. Files that were not there are kept
+// empty.
+func (s *Server) keepVersion(file string, data []byte) error {
+	dir := s.versionsDir(file)
+	if err := writeAtomic(filepath.Join(dir, s.now().UTC().Format(versionStamp)+".toml"), data); err != nil {
+		return err
+	}
+	versions, err := s.versions(file)
+	if err != nil {
+		return err
+	}
+	for len(versions) > maxVersions {
+		if err := os.Remove(versions[0]); err != nil {
+			return err
+		}
+		versions = versions[1:]
+	}
+	return nil
+}
+
+// versions returns the versions kept of the file, the oldest first.
+func (s *Server) versions(file string) ([]string, error) {
+	names, err := filepath.Glob(filepath.Join(s.versionsDir(file), "*.toml"))
+	sort.Strings(names)
+	return names, err
+}
+
+// stagedFile returns the file of the change that goes by the name.
+func (s *Server) stagedFile(name string) (string, bool) {
+	for file, c := range s.staged {
+		if strings.EqualFold(c.name(), name) {
+			return file, true
+		}
+	}
+	return "", false
+}
+
+// versionedFile returns the file of the area, or of the items, that goes by
+// the name, even if the area was taken out of the world.
+func (s *Server) versionedFile(name string) (string, bool) {
+	if strings.EqualFold(name, "items") {
+		return "items.toml", true
+	}
+	for n, file := range s.areaFiles {
+		if strings.EqualFold(n, name) {
+			return file, true
+		}
+	}
+	dirs, _ := filepath.Glob(filepath.Join(s.dataDir, "versions", "areas", "*.toml"))
+	for _, dir := range dirs {
+		file := path.Join("areas", filepath.Base(dir))
+		versions, err := s.versions(file)
+		if err != nil || len(versions) == 0 {
+			continue
+		}
+		a := area.Area{}
+		if _, err := toml.DecodeFile(versions[len(versions)-1], &a); err == nil && strings.EqualFold(a.Name, name) {
+			return file, true
+		}
+	}
+	return "", false
+}
+
+// stagedChanges lists the changes to the world waiting for an admin, shows
+// what one of them changes, or discards it.
+// Usage: staged [<area>|<file> [discard]]
+func (s *Server) stagedChanges(args []string) string {
+	if len(args) == 0 {
+		if len(s.staged) == 0 {
+			return "No changes wait to be published\n"
+		}
+		lines := []string{}
+		for _, c := range s.staged {
+			_, err := os.Stat(filepath.Join(s.staticDir, filepath.FromSlash(c.File)))
+			what := "changed"
+			if _, staged := s.worldData(c.File); os.IsNotExist(staged) {
+				what = "taken away"
+			} else if os.IsNotExist(err) {
+				what = "new"
+			}
+			lines = append(lines, fmt.Sprintf("  %s (%s), %s by %s on %s", c.name(), c.File, what, c.By, c.At.Format("Jan 2 15:04")))
+		}
+		sort.Strings(lines)
+		return fmt.Sprintf("Changes waiting to be published:\n%s\n", strings.Join(lines, "\n"))
+	}
+
+	file, ok := s.stagedFile(args[0])
+	if !ok {
+		return fmt.Sprintf("No changes to %s wait to be published\n", args[0])
+	}
+	switch {
+	case len(args) == 1:
+		return s.stagedDiff(file) + "\n"
+	case len(args) == 2 && strings.EqualFold(args[1], "discard"):
+		if err := s.discard(file); err != nil {
+			log.Error(fmt.Sprintf("Cannot discard the change to %s: %v", file, err))
+			return "The change can't be discarded right now\n"
+		}
+		return fmt.Sprintf("The change to %s is discarded\n", args[0])
+	}
+	return "Usage: staged [<area>|<file> [discard]]\n"
+}
+
+// stagedDiff shows what the staged file changes of the live one. Both are
+// written the way the admin API writes them first, so that only what they
+// hold is compared.
+func (s *Server) stagedDiff(file string) string {
+	c := s.staged[file]
+	live, _ := ioutil.ReadFile(filepath.Join(s.staticDir, filepath.FromSlash(file)))
+	staged, _ := s.worldData(file)
+	diff := diffLines(normalized(file, live), normalized(file, staged))
+	if diff == "" {
+		diff = "It changes nothing"
+	}
+	return fmt.Sprintf("--- %s\n+++ %s, staged by %s\n%s", file, file, c.By, diff)
+}
+
+// normalized writes what the file holds the way the admin API writes it, if
+// it can be read.
+func normalized(file string, data []byte) string {
+	if len(data) == 0 {
+		return ""
+	}
+	var v interface{}
+	switch {
+	case file == "items.toml":
+		v = &itemsFile{}
+	case strings.HasPrefix(file, "areas/"):
+		v = &area.Area{}
+	default:
+		return string(data)
+	}
+	var buf bytes.Buffer
+	if _, err := toml.Decode(string(data), v); err != nil {
+		return string(data)
+	}
+	if err := toml.NewEncoder(&buf).Encode(v); err != nil {
+		return string(data)
+	}
+	return buf.String()
+}
+
+// discard forgets the staged change to the file.
+func (s *Server) discard(file string) error {
+	delete(s.staged, file)
+	if err := os.Remove(filepath.Join(s.stagingDir(), filepath.FromSlash(file))); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return s.saveStaged()
+}
+
+// publishStaged puts the change waiting for an admin in the world, if it
+// still fits the world as it runs.
+// Usage: publish <area>|<file>
+func (s *Server) publishStaged(admin *area.Player, args []string) string {
+	if len(args) != 1 {
+		return "Usage: publish <area>|<file>\n"
+	}
+	file, ok := s.stagedFile(args[0])
+	if !ok {
+		return fmt.Sprintf("No changes to %s wait to be published\n", args[0])
+	}
+	if err := s.publishChange(file, admin.Nickname); err != nil {
+		return fmt.Sprintf("The change to %s can't be published: %v\n", args[0], err)
+	}
+	return fmt.Sprintf("The change to %s is published\n", args[0])
+}
+
+// publishChange checks the staged change to the file and puts it live. It
+// runs on the world.
+func (s *Server) publishChange(file, by string) error {
+	c := s.staged[file]
+	data, err := ioutil.ReadFile(filepath.Join(s.stagingDir(), filepath.FromSlash(file)))
+	if err != nil {
+		return err
+	}
+	if err := s.checkWorldFile(file, data); err != nil {
+		return err
+	}
+	if err := s.publishFile(file, data, true); err != nil {
+		log.Error(fmt.Sprintf("Cannot publish the change to %s: %v", file, err))
+		return fmt.Errorf("it can't be written")
+	}
+	if err := s.discard(file); err != nil {
+		log.Error(fmt.Sprintf("Cannot forget the published change to %s: %v", file, err))
+	}
+	log.Info(fmt.Sprintf("%q published the change %q staged to %s", by, c.By, file))
+	return nil
+}
+
+// rollback puts the area, or the items, back the way they were before they
+// last changed. Rolling back again goes further back.
+// Usage: rollback <area>|items
+func (s *Server) rollback(admin *area.Player, args []string) string {
+	if len(args) != 1 {
+		return "Usage: rollback <area>|items\n"
+	}
+	file, ok := s.versionedFile(args[0])
+	if !ok {
+		return fmt.Sprintf("There is no area %q\n", args[0])
+	}
+	versions, err := s.versions(file)
+	if err != nil || len(versions) == 0 {
+		return fmt.Sprintf("No versions of %s are kept from before\n", args[0])
+	}
+	newest := versions[len(versions)-1]
+	data, err := ioutil.ReadFile(newest)
+	if err != nil {
+		log.Error(fmt.Sprintf("Cannot read the version %s: %v", newest, err))
+		return "The version before can't be read right now\n"
+	}
+	if err := s.checkWorldFile(file, data); err != nil {
+		return fmt.Sprintf("%s can't be rolled back: %v\n", args[0], err)
+	}
+	if err := s.publishFile(file, data, false); err != nil {
+		log.Error(fmt.Sprintf("Cannot roll %s back: %v", file, err))
+		return fmt.Sprintf("%s can't be rolled back right now\n", args[0])
+	}
+	if err := os.Remove(newest); err != nil {
+		log.Error(fmt.Sprintf("Cannot forget the version %s: %v", newest, err))
+	}
+	log.Info(fmt.Sprintf("%q rolled %s back to %s", admin.Nickname, file, filepath.Base(newest)))
+	return fmt.Sprintf("%s is back the way it was before it last changed, %d older versions are kept\n", args[0], len(versions)-1)
+}
+
+// diffLines returns the lines that differ between before and after, with
+// some around them, the way diff -u shows them.
+func diffLines(before, after string) string {
+	a, b := strings.Split(strings.TrimSuffix(before, "\n"), "\n"), strings.Split(strings.TrimSuffix(after, "\n"), "\n")
+	if before == "" {
+		a = nil
+	}
+	if after == "" {
+		b = nil
+	}
+	// common[i][j] is how many lines a[i:] and b[j:] have in common.
+	common := make([][]int, len(a)+1)
+	for i := range common {
+		common[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			switch {
+			case a[i] == b[j]:
+				common[i][j] = common[i+1][j+1] + 1
+			case common[i+1][j] >= common[i][j+1]:
+				common[i][j] = common[i+1][j]
+			default:
+				common[i][j] = common[i][j+1]
+			}
+		}
+	}
+
+	// Each line is kept, taken away or added, after as many lines of before
+	// and after.
+	type line struct {
+		op     byte
+		text   string
+		ai, bi int
+	}
+	lines := []line{}
+	for i, j := 0, 0; i < len(a) || j < len(b); {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			lines = append(lines, line{' ', a[i], i, j})
+			i, j = i+1, j+1
+		case i < len(a) && (j == len(b) || common[i+1][j] >= common[i][j+1]):
+			lines = append(lines, line{'-', a[i], i, j})
+			i++
+		default:
+			lines = append(lines, line{'+', b[j], i, j})
+			j++
+		}
+	}
+
+	out := []string{}
+	for k := 0; k < len(lines); {
+		if lines[k].op == ' ' {
+			k++
+			continue
+		}
+		// The hunk runs on while the changes are close enough to share
+		// their context.
+		last := k
+		for e := k; e < len(lines) && e-last <= 2*diffContext; e++ {
+			if lines[e].op != ' ' {
+				last = e
+			}
+		}
+		start, end := k-diffContext, last+diffContext+1
+		if start < 0 {
+			start = 0
+		}
+		if end > len(lines) {
+			end = len(lines)
+		}
+		na, nb := 0, 0
+		body := []string{}
+		for _, l := range lines[start:end] {
+			if l.op != '+' {
+				na++
+			}
+			if l.op != '-' {
+				nb++
+			}
+			body = append(body, string(l.op)+l.text)
+		}
+		// Hunks with no lines on a side start after the line before them.
+		fromA, fromB := lines[start].ai+1, lines[start].bi+1
+		if na == 0 {
+			fromA--
+		}
+		if nb == 0 {
+			fromB--
+		}
+		out = append(out, fmt.Sprintf("@@ -%d,%d +%d,%d @@", fromA, na, fromB, nb))
+		out = append(out, body...)
+		k = end
+	}
+	return strings.Join(out, "\n")
+}
+
+// stagedSummary is a change in the list of them.
+type stagedSummary struct {
+	Name string    `json:"name"`
+	File string    `json:"file"`
+	By   string    `json:"by"`
+	At   time.Time `json:"at"`
+}
+
+// serveStaging serves the changes waiting for an admin: the list of them,
+// and what each changes as a diff, to publish or discard it.
+func (s *Server) serveStaging(w http.ResponseWriter, r *http.Request) {
+	if !s.editable(w, r) {
+		return
+	}
+	parts := apiPath(r, "/admin/staging")
+	if len(parts) > 1 {
+		http.NotFound(w, r)
+		return
+	}
+	var list []stagedSummary
+	var diff string
+	var err error
+	if !s.inWorld(r.Context(), func() {
+		if len(parts) == 0 {
+			if r.Method != http.MethodGet {
+				err = apiErrorf(http.StatusMethodNotAllowed, "method not allowed")
+				return
+			}
+			list = []stagedSummary{}
+			for _, c := range s.staged {
+				list = append(list, stagedSummary{Name: c.name(), File: c.File, By: c.By, At: c.At})
+			}
+			return
+		}
+		file, ok := s.stagedFile(parts[0])
+		if !ok {
+			err = apiErrorf(http.StatusNotFound, "no changes to %s wait to be published", parts[0])
+			return
+		}
+		switch r.Method {
+		case http.MethodGet:
+			diff = s.stagedDiff(file) + "\n"
+		case http.MethodPost:
+			if err = s.publishChange(file, "admin API"); err != nil {
+				err = apiErrorf(http.StatusUnprocessableEntity, "%v", err)
+			}
+		case http.MethodDelete:
+			err = s.discard(file)
+		default:
+			err = apiErrorf(http.StatusMethodNotAllowed, "method not allowed")
+		}
+	}) {
+		return
+	}
+	switch {
+	case err != nil:
+		replyError(w, err)
+	case list != nil:
+		sort.Slice(list, func(i, j int) bool { return list[i].File < list[j].File })
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(list)
+	case diff != "":
+		w.Header().Set("Content-Type", "text/x-diff; charset=utf-8")
+		io.WriteString(w, diff)
+	default:
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
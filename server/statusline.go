@@ -0,0 +1,252 @@
+package server
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/droslean/thyranew/area"
+	"github.com/droslean/thyranew/game"
+	"github.com/jpillora/ansi"
+
+	log "gopkg.in/inconshreveable/log15.v2"
+)
+
+const (
+	// barWidth is how many cells the bars of the status line take.
+	barWidth = 10
+	// segmentSep goes between the segments of the status line.
+	segmentSep = " | "
+)
+
+// defaultPrompt is the status line of players who never picked their own. It
+// has every widget there is.
+var defaultPrompt = []string{"hp", "target", "cooldown", "xp", "rested", "load", "position", "time", "mail", "ping"}
+
+// A widget renders one segment of the status line. It returns the segment as
+// it is written, the number of cells it takes on the screen, and false when
+// there is nothing to show.
+type widget func(s *Server, p *area.Player) (string, int, bool)
+
+// widgets are all the segments players can put on their status line.
+var widgets = map[string]widget{
+	"hp":       hpWidget,
+	"target":   targetWidget,
+	"cooldown": cooldownWidget,
+	"xp":       xpWidget,
+	"rested":   restedWidget,
+	"load":     loadWidget,
+	"position": positionWidget,
+	"time":     timeWidget,
+	"mail":     mailWidget,
+	"ping":     pingWidget,
+}
+
+func hpWidget(s *Server, p *area.Player) (string, int, bool) {
+	t := themeOf(p)
+	return bar("HP", p.HP, game.MaxHP(&p.PC), t.bars(t.Gauge), t.Depth)
+}
+
+func targetWidget(s *Server, p *area.Player) (string, int, bool) {
+	npc := s.opponent(p)
+	if npc == nil {
+		return "", 0, false
+	}
+	t := themeOf(p)
+	return bar(npc.Name, npc.HP, npc.MaxHP, t.bars(t.Gauge), t.Depth)
+}
+
+func xpWidget(s *Server, p *area.Player) (string, int, bool) {
+	t := themeOf(p)
+	return bar("XP", p.XP, game.LevelXP(p.Level), t.bars(blues), t.Depth)
+}
+
+func restedWidget(s *Server, p *area.Player) (string, int, bool) {
+	if p.Rested == 0 {
+		return "", 0, false
+	}
+	text := fmt.Sprintf("Rested %d", p.Rested)
+	return text, len(text), true
+}
+
+func loadWidget(s *Server, p *area.Player) (string, int, bool) {
+	load := s.encumbrance(p)
+	if load == game.Unburdened {
+		return "", 0, false
+	}
+	text := strings.Title(load)
+	return text, len(text), true
+}
+
+func positionWidget(s *Server, p *area.Player) (string, int, bool) {
+	text := p.Room
+	if _, ok := s.Wilderness[p.Area]; ok {
+		text = p.Area
+	}
+	return text, len([]rune(text)), true
+}
+
+func timeWidget(s *Server, p *area.Player) (string, int, bool) {
+	now := s.worldTime()
+	text := fmt.Sprintf("%s %02d:%02d", now.PartOfDay(), now.Hour, now.Minute)
+	return text, len(text), true
+}
+
+func mailWidget(s *Server, p *area.Player) (string, int, bool) {
+	unread := s.unread(p)
+	if unread == 0 {
+		return "", 0, false
+	}
+	text := fmt.Sprintf("Mail %d", unread)
+	return text, len(text), true
+}
+
+// unread returns how many notifications and mail the player has yet to read.
+func (s *Server) unread(p *area.Player) int {
+	count := 0
+	ctx := s.ctxOf(p.Nickname)
+	inbox, err := s.db.GetNotifications(ctx, p.Nickname)
+	if err != nil {
+		log.Error(fmt.Sprintf("Cannot read the notifications of %q: %v", p.Nickname, err))
+	}
+	for _, n := range inbox {
+		if !n.Read {
+			count++
+		}
+	}
+	all, err := s.db.ListMail(ctx)
+	if err != nil {
+		log.Error(fmt.Sprintf("Cannot list the mail: %v", err))
+	}
+	for _, m := range all {
+		if m.To == p.Nickname && m.Status == mailSent {
+			count++
+		}
+	}
+	return count
+}
+
+// bar renders a labelled bar, each cell in the colour the palette gives to its
+// place along the bar, as close as the colour depth gets. Without a palette
+// the bar has no colours.
+func bar(label string, value, max int, palette func(float64) (int, int, int), depth string) (string, int, bool) {
+	if max <= 0 {
+		return "", 0, false
+	}
+	full := value * barWidth / max
+	if full < 0 {
+		full = 0
+	}
+	if full > barWidth {
+		full = barWidth
+	}
+	var buf strings.Builder
+	buf.WriteString(label + " ")
+	for i := 0; i < barWidth; i++ {
+		switch {
+		case palette == nil && i < full:
+			buf.WriteString("█")
+		case palette == nil:
+			buf.WriteString("░")
+		case i < full:
+			r, g, b := palette(float64(i+1) / barWidth)
+			buf.WriteString(rgbCode(depth, r, g, b) + "█")
+		default:
+			buf.WriteString(rgbCode(depth, 80, 80, 80) + "░")
+		}
+	}
+	if palette != nil {
+		buf.WriteString(string(ansi.Set(ansi.Reset)))
+	}
+	numbers := fmt.Sprintf(" %d/%d", value, max)
+	buf.WriteString(numbers)
+	return buf.String(), len([]rune(label)) + 1 + barWidth + len(numbers), true
+}
+
+// redToGreen fades from red when empty, through yellow, to green when full.
+func redToGreen(fraction float64) (int, int, int) {
+	if fraction < 0 {
+		fraction = 0
+	}
+	if fraction > 1 {
+		fraction = 1
+	}
+	if fraction < 0.5 {
+		return 220, int(440 * fraction), 40
+	}
+	return int(440 * (1 - fraction)), 200, 40
+}
+
+// blues fades from dark to light blue as the bar fills up.
+func blues(fraction float64) (int, int, int) {
+	if fraction < 0 {
+		fraction = 0
+	}
+	if fraction > 1 {
+		fraction = 1
+	}
+	return int(40 + 100*fraction), int(80 + 120*fraction), 230
+}
+
+// drawStatusLine writes the status line of the player over the prompt bar,
+// dropping the segments that don't fit.
+func (s *Server) drawStatusLine(c Client) {
+	layout := c.Player.Prompt
+	if len(layout) == 0 {
+		layout = defaultPrompt
+	}
+	var line strings.Builder
+	width := 0
+	for _, name := range layout {
+		w, ok := widgets[name]
+		if !ok {
+			continue
+		}
+		segment, cells, ok := w(s, c.Player)
+		if !ok {
+			continue
+		}
+		if width > 0 {
+			cells += len(segmentSep)
+			segment = segmentSep + segment
+		}
+		if width+cells > c.w {
+			break
+		}
+		line.WriteString(segment)
+		width += cells
+	}
+	c.conn.Write(ansi.Goto(uint16(c.h)-2, 1))
+	c.conn.Write(ansi.EraseLine)
+	c.writeString(line.String())
+}
+
+// prompt shows or changes the widgets on the status line of the player.
+// Usage: prompt [reset|<widget>...]
+func (s *Server) prompt(p *area.Player, args []string) string {
+	available := strings.Join(defaultPrompt, " ")
+	if len(args) == 0 {
+		layout := p.Prompt
+		if len(layout) == 0 {
+			layout = defaultPrompt
+		}
+		return fmt.Sprintf("Status line: %s\nWidgets: %s\n", strings.Join(layout, " "), available)
+	}
+
+	if len(args) == 1 && strings.ToLower(args[0]) == "reset" {
+		p.Prompt = nil
+	} else {
+		layout := []string{}
+		for _, name := range args {
+			name = strings.ToLower(name)
+			if _, ok := widgets[name]; !ok {
+				return fmt.Sprintf("There is no widget %s. Widgets: %s\n", name, available)
+			}
+			layout = append(layout, name)
+		}
+		p.Prompt = layout
+	}
+	if err := s.savePlayer(p); err != nil {
+		log.Error(fmt.Sprintf("Cannot save %q: %v", p.Nickname, err))
+	}
+	return "Your status line is set\n"
+}
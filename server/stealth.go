@@ -0,0 +1,144 @@
+package server
+
+import (
+	"fmt"
+
+	"github.com/droslean/thyranew/area"
+	"github.com/droslean/thyranew/game"
+)
+
+// quietCommands do not give away a hidden player. Movement is quiet only
+// while sneaking.
+var quietCommands = map[string]bool{
+	"":        true,
+	"hide":    true,
+	"sneak":   true,
+	"steal":   true,
+	"scan":    true,
+	"lootsim": true,
+	"time":    true,
+	"weather": true,
+	"rest":    true,
+	"sleep":   true,
+	"scroll":  true,
+	"walk":    true,
+	// Setting up the client does nothing in the world.
+	"settings": true,
+	"describe": true,
+	"look":     true,
+	"title":    true,
+	"keywords": true,
+	"flag":     true,
+	"follow":   true,
+	"wanted":   true,
+	"hints":    true,
+	"tutorial": true,
+	"score":    true,
+	"compare":  true,
+	"identify": true,
+	"inspect":  true,
+	"enchant":  true,
+	"socket":   true,
+	"engrave":  true,
+	"drop":     true,
+	"keys":     true,
+	"loot":     true,
+	"roll":     true,
+	"tactics":  true,
+	"wield":    true,
+	"hold":     true,
+	// Cooldowns, skills and practices only tell the player about themselves.
+	"cooldowns": true,
+	"skills":    true,
+	"practice":  true,
+	// The history and the obituaries are read from the records.
+	"history":    true,
+	"obituaries": true,
+	// So is linking accounts, exporting the character and deleting it.
+	"link":   true,
+	"unlink": true,
+	"export": true,
+	"delete": true,
+	// And asking after the other servers, and who is online.
+	"cluster":  true,
+	"intermud": true,
+	"who":      true,
+}
+
+var moveCommands = map[string]bool{
+	"e": true, "east": true,
+	"w": true, "west": true,
+	"n": true, "north": true,
+	"s": true, "south": true,
+}
+
+// canSee reports whether the viewer notices the target.
+func canSee(viewer, target *area.Player) bool {
+	if viewer.Nickname == target.Nickname || target.HideRoll == 0 {
+		return true
+	}
+	return game.Perception(&viewer.PC) >= target.HideRoll
+}
+
+// stealthRoll rolls how well the player hides or sneaks, with the ranks of
+// stealth they trained.
+func (s *Server) stealthRoll(p *area.Player) int {
+	return game.StealthRoll(&p.PC, s.rnd) + p.Trained[game.SkillStealth]
+}
+
+// hide makes the player hide in the shadows.
+func (s *Server) hide(p *area.Player) string {
+	p.HideRoll = s.stealthRoll(p)
+	// Hiding always succeeds from the point of view of the player, who
+	// never learns how good the roll was.
+	return "You slip into the shadows\n"
+}
+
+// toggleSneak turns sneaking on or off. Sneaking players move without being
+// announced and stay hidden while moving.
+func toggleSneak(p *area.Player) string {
+	p.Sneaking = !p.Sneaking
+	if p.Sneaking {
+		return "You start moving silently\n"
+	}
+	return "You stop sneaking\n"
+}
+
+// revealOnAction brings a hidden player out of the shadows when the command
+// gives them away. It returns the message shown to the room, if any.
+func revealOnAction(p *area.Player, cmd string) string {
+	if p.HideRoll == 0 || quietCommands[cmd] || (moveCommands[cmd] && p.Sneaking) {
+		return ""
+	}
+	p.HideRoll = 0
+	return fmt.Sprintf("%s steps out of the shadows\n", p.Nickname)
+}
+
+// announceMove tells the rooms the player moved between. Sneaking players
+// are announced only to those who notice them.
+func (s *Server) announceMove(cl *Client, roomsMap map[string]map[string][][]area.Cube) {
+	p := cl.Player
+	roll := 0
+	if p.Sneaking {
+		roll = s.stealthRoll(p)
+	}
+	noticed := func(viewer *area.Player) bool {
+		return !p.Sneaking || viewer.Nickname == p.Nickname || game.Perception(&viewer.PC) >= roll
+	}
+
+	for _, c := range s.OnlineClientsGetByRoom(p.Area, p.Room) {
+		msg := ""
+		if noticed(c.Player) && canSee(c.Player, p) {
+			msg = fmt.Sprintf("%s enter the room.\n", p.Nickname)
+		}
+		s.godPrintRoom([]Client{c}, roomsMap, "", msg)
+	}
+
+	for _, c := range s.OnlineClientsGetByRoom(p.PreviousArea, p.PreviousRoom) {
+		msg := ""
+		if noticed(c.Player) && canSee(c.Player, p) {
+			msg = fmt.Sprintf("%s left the room.\n", p.Nickname)
+		}
+		s.godPrintRoom([]Client{c}, roomsMap, "", msg)
+	}
+}
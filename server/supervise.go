@@ -0,0 +1,118 @@
+package server
+
+import (
+	"fmt"
+	"runtime/debug"
+	"time"
+
+	log "gopkg.in/inconshreveable/log15.v2"
+)
+
+// RestartPolicy tells how often a core subsystem is started again after it
+// panics. Past MaxRestarts within Window it is left stopped.
+type RestartPolicy struct {
+	MaxRestarts int
+	Window      time.Duration
+}
+
+var (
+	// worldPolicy is the restart policy of the world.
+	worldPolicy = RestartPolicy{MaxRestarts: 5, Window: time.Minute}
+	// acceptPolicy is the restart policy of the loop accepting connections.
+	acceptPolicy = RestartPolicy{MaxRestarts: 10, Window: time.Minute}
+)
+
+// supervise runs the subsystem until it returns, starting it again each time it
+// panics, as long as the policy allows. restarted is called before each restart,
+// and crashed, if there is one, of each panic.
+func supervise(name string, policy RestartPolicy, run func(), restarted func(), crashed crashFunc) {
+	restarts := []time.Time{}
+	for {
+		if !panics(name, run, crashed) {
+			return
+		}
+
+		now := time.Now()
+		recent := restarts[:0]
+		for _, t := range restarts {
+			if now.Sub(t) < policy.Window {
+				recent = append(recent, t)
+			}
+		}
+		restarts = recent
+		if len(restarts) >= policy.MaxRestarts {
+			log.Crit(fmt.Sprintf("%s panicked %d times in %s, it stays stopped", name, len(restarts)+1, policy.Window))
+			return
+		}
+		restarts = append(restarts, now)
+		log.Warn(fmt.Sprintf("Restarting %s", name))
+		if restarted != nil {
+			restarted()
+		}
+	}
+}
+
+// panics runs the function and reports whether it panicked. crashed, if
+// there is one, is told of the panic.
+func panics(name string, run func(), crashed crashFunc) (panicked bool) {
+	defer func() {
+		if r := recover(); r != nil {
+			stack := debug.Stack()
+			log.Crit(fmt.Sprintf("%s panicked: %v\n%s", name, r, stack))
+			if crashed != nil {
+				crashed(name, r, stack)
+			}
+			panicked = true
+		}
+	}()
+	run()
+	return false
+}
+
+// guard is deferred by the goroutines of each client. A panic there is logged,
+// and bundled, and only costs the player their connection. Clients that
+// aren't logged in yet are nil.
+func (s *Server) guard(c *Client, name string) {
+	r := recover()
+	if r == nil {
+		return
+	}
+	stack := debug.Stack()
+	if c == nil {
+		log.Crit(fmt.Sprintf("%s panicked: %v\n%s", name, r, stack))
+		s.reportCrash(name, r, stack)
+		return
+	}
+	log.Crit(fmt.Sprintf("%s of %q panicked: %v\n%s", name, c.Name, r, stack))
+	s.reportCrash(fmt.Sprintf("%s of %q", name, c.Name), r, stack)
+	// Dropping the connection ends the rest of the goroutines of the client,
+	// and leaves the player linkdead.
+	c.cancel()
+}
+
+// disconnect saves the player and lets the client go. It runs in the world.
+func (s *Server) disconnect(c *Client) {
+	if cur, ok := s.clientByNick(c.Name); !ok || cur != c {
+		return
+	}
+	s.setState(c, StateQuitting)
+	c.Player.LastSeen = s.now()
+	c.Player.Played = playtime(c.Player, s.now())
+	if err := s.savePlayer(c.Player); err != nil {
+		log.Error(fmt.Sprintf("Cannot save player %q: %v", c.Player.Nickname, err))
+	}
+	stopRecording(c)
+	// The terminal is left the way the player found it.
+	if f, ok := s.frames[c.Player.Nickname]; ok && f.mouse {
+		c.writeString(mouseOff)
+	}
+	delete(s.running, c.Player.Nickname)
+	delete(s.frames, c.Player.Nickname)
+	delete(s.walks, c.Player.Nickname)
+	delete(s.modals, c.Player.Nickname)
+	delete(s.lastCommands, c.Player.Nickname)
+	delete(s.hintRecords, c.hash)
+	c.conn.Close()
+	c.cancel()
+	s.clientLoggedOut(c.Name)
+}
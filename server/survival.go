@@ -0,0 +1,124 @@
+package server
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/droslean/thyranew/area"
+	"github.com/droslean/thyranew/game"
+)
+
+const (
+	// metabolismTicks is every how many ticks players get hungrier and thirstier.
+	metabolismTicks = 6
+	// survivalWarning is the meter level players are warned at.
+	survivalWarning = 20
+)
+
+// regenerate heals the player a little, faster when they rest or sleep, and
+// leaves the world to log it. Starving or parched players do not heal at all.
+func (s *Server) regenerate(p *area.Player) []func() {
+	if p.HP <= 0 || s.ticks%game.RegenInterval(p.Resting) != 0 {
+		return nil
+	}
+	if hasEffect(p, "Resurrection Sickness") {
+		return nil
+	}
+	if s.config.Survival && (p.Food == 0 || p.Drink == 0) {
+		return nil
+	}
+	if p.HP >= game.MaxHP(&p.PC) {
+		return nil
+	}
+	p.HP++
+	return []func(){func() {
+		s.recordCombat(CombatEvent{Player: p.Nickname, Kind: combatHealed, Source: "regeneration", Amount: 1})
+	}}
+}
+
+// metabolize makes the player hungrier and thirstier, and leaves the world to
+// tell them. Empty meters hurt the player until they eat or drink.
+func (s *Server) metabolize(roomsMap map[string]map[string][][]area.Cube, cl Client) []func() {
+	p := cl.Player
+	if !s.config.Survival || s.ticks%metabolismTicks != 0 || p.Ghost {
+		return nil
+	}
+	msg := ""
+	if p.Food = drain(p.Food, 1); p.Food == survivalWarning {
+		msg += "You are getting hungry\n"
+	} else if p.Food == 0 {
+		addEffect(p, game.Effect{Name: "Starving", Damage: 1, Message: "You are starving\n"})
+	}
+	// Thirst comes faster than hunger.
+	if p.Drink = drain(p.Drink, 2); p.Drink == survivalWarning {
+		msg += "You are getting thirsty\n"
+	} else if p.Drink == 0 {
+		addEffect(p, game.Effect{Name: "Parched", Damage: 1, Message: "Your throat is parched\n"})
+	}
+	if msg == "" {
+		return nil
+	}
+	return []func(){func() { s.godPrintRoom([]Client{cl}, roomsMap, msg, "") }}
+}
+
+func drain(meter, amount int) int {
+	if meter -= amount; meter < 0 {
+		return 0
+	}
+	return meter
+}
+
+// consume eats or drinks an item of the player.
+// Usage: eat <item>, drink <item>
+func (s *Server) consume(p *area.Player, cmd string, args []string) string {
+	if !s.config.Survival {
+		return "You are neither hungry nor thirsty\n"
+	}
+	item := strings.Join(args, " ")
+	if item == "" {
+		return fmt.Sprintf("What do you want to %s?\n", cmd)
+	}
+
+	points, ok := game.Foods[strings.ToLower(item)]
+	meter, max, effect := &p.Food, game.MaxFood, "Starving"
+	if cmd == "drink" {
+		points, ok = game.Drinks[strings.ToLower(item)]
+		meter, max, effect = &p.Drink, game.MaxDrink, "Parched"
+	}
+	if !ok {
+		return fmt.Sprintf("You can't %s that\n", cmd)
+	}
+	if !p.HasItem(item) {
+		return fmt.Sprintf("You have no %s\n", item)
+	}
+
+	p.RemoveItem(item)
+	removeEffect(p, effect)
+	if *meter += points; *meter > max {
+		*meter = max
+	}
+	return fmt.Sprintf("You %s the %s (%d/%d)\n", cmd, strings.ToLower(item), *meter, max)
+}
+
+// rest makes the player rest or sleep, or stand up again.
+func (s *Server) rest(p *area.Player, state string) string {
+	if !s.config.Survival {
+		return "There is no time for resting\n"
+	}
+	switch {
+	case state == game.Awake && p.Resting == game.Awake:
+		return "You are already standing\n"
+	case state == game.Awake:
+		p.Resting = game.Awake
+		return "You stand up\n"
+	case s.inCombat(p):
+		return "You can't rest while you are fighting\n"
+	case state == p.Resting:
+		return fmt.Sprintf("You are already %s\n", state)
+	}
+	p.Resting = state
+	if state == game.Sleeping {
+		return "You lie down and fall asleep\n"
+	}
+	return "You sit down and rest\n"
+}
@@ -0,0 +1,234 @@
+package server
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/droslean/thyranew/area"
+	"github.com/droslean/thyranew/game"
+)
+
+// stanceOf returns the stance the player fights in.
+func stanceOf(p *area.Player) string {
+	if p.Tactics.Stance == "" {
+		return game.StanceNormal
+	}
+	return p.Tactics.Stance
+}
+
+// fightRound has the group of the player, whom the NPC is about to attack,
+// follow their tactics: a rescuer in the group may take the blow in their
+// place, and those who assist join the fight. It returns who the NPC
+// attacks.
+func (s *Server) fightRound(roomsMap map[string]map[string][][]area.Cube, npc *area.NPC, p *area.Player) *area.Player {
+	target := s.rescue(roomsMap, npc, p)
+	for _, member := range s.group(target)[1:] {
+		if !member.Tactics.Assist || member.Ghost || s.inCombat(member) {
+			continue
+		}
+		bow := bestBow(member)
+		if bow.Name == "" || !member.HasItem(bow.Ammo) {
+			continue
+		}
+		s.tellPlayer(roomsMap, member.Nickname, s.fire(roomsMap, member, npc, bow, 0))
+	}
+	return target
+}
+
+// rescue has a member of the group of the player who rescues others turn
+// the NPC on themselves, when the player is down to less than half their
+// hit points and the rescuer has more than half of theirs. It returns who
+// the NPC goes after.
+func (s *Server) rescue(roomsMap map[string]map[string][][]area.Cube, npc *area.NPC, p *area.Player) *area.Player {
+	if p.HP*2 >= game.MaxHP(&p.PC) {
+		return p
+	}
+	for _, member := range s.group(p)[1:] {
+		if !member.Tactics.Rescue || member.Ghost || member.HP*2 <= game.MaxHP(&member.PC) {
+			continue
+		}
+		s.addThreat(npc, member.Nickname, game.TauntThreat(s.topThreat(npc, member.Nickname))-s.threat[npc.ID][member.Nickname])
+		npc.Target = member.Nickname
+		s.endFight(roomsMap, p.Nickname)
+		b := Broadcast{Scope: ScopeRoom, Area: member.Area, Room: member.Room, Kind: TagCombat, Text: "$n {rescue|rescues} $N\n"}
+		s.act(roomsMap, b, playerActor(member), playerActor(p))
+		return member
+	}
+	return p
+}
+
+// CombatConfig holds how hard it is for players to get out of fights.
+type CombatConfig struct {
+	// Teleports are the ways players may still teleport away by while an NPC
+	// is after them: "recall", "portal", "board", "disembark", "dungeon" or
+	// "wilderness", the links out of the wilderness. Being moved by others
+	// works regardless.
+	Teleports []string `toml:"teleports"`
+	// GiveUp is how many rooms away NPCs stop chasing players, five unless
+	// set. NPCs may chase as far as they set of their own.
+	GiveUp int `toml:"giveup"`
+}
+
+// fleeCooldown is how long players wait to try to flee again after they
+// failed.
+const fleeCooldown = 3 * time.Second
+
+// fleeWays are the directions players may flee in, by the way they step on
+// the map of the room.
+var fleeWays = map[string][2]int{
+	"e": {1, 0}, "east": {1, 0},
+	"w": {-1, 0}, "west": {-1, 0},
+	"n": {0, -1}, "north": {0, -1},
+	"s": {0, 1}, "south": {0, 1},
+}
+
+// engaged returns the NPC that fights the player in their room, or nil when
+// none does. Players can't just walk away from it.
+func (s *Server) engaged(p *area.Player) *area.NPC {
+	for id := range s.pursuits {
+		npc, areaName := s.findNPC(id)
+		if npc != nil && npc.Target == p.Nickname && areaName == p.Area && npc.Room == p.Room {
+			return npc
+		}
+	}
+	return nil
+}
+
+// wimpy makes the player flee once the blow they took leaves them under the
+// hit points they set to flee at.
+func (s *Server) wimpy(roomsMap map[string]map[string][][]area.Cube, p *area.Player) {
+	if game.Flees(p.HP, game.MaxHP(&p.PC), p.Tactics.Wimpy, p.Tactics.Stance) {
+		s.tellPlayer(roomsMap, p.Nickname, tagged(TagCombat, s.runAway(roomsMap, p, "")))
+	}
+}
+
+// flee tries to run away from the fight the player is in, through a door in
+// the direction or to the room given, or through any door.
+// Usage: flee [direction|room]
+func (s *Server) flee(roomsMap map[string]map[string][][]area.Cube, p *area.Player, args []string) string {
+	if !s.inCombat(p) {
+		return "You are not fighting anyone\n"
+	}
+	if p.Tactics.Stance == game.StanceBerserk {
+		return "Berserkers don't flee\n"
+	}
+	if wait := fleeCooldown - s.now().Sub(p.LastFlee); wait > 0 {
+		return fmt.Sprintf("You can try to flee again in %s\n", shortDuration(wait))
+	}
+	return s.runAway(roomsMap, p, strings.ToLower(strings.Join(args, " ")))
+}
+
+// fleeDoors returns where the open doors of the room of the player lead,
+// those that lie in the direction or lead to the room given, or all of them
+// when it is empty.
+func fleeDoors(roomsMap map[string]map[string][][]area.Cube, p *area.Player, way string) []area.Exit {
+	mapArray := roomsMap[p.Area][p.Room]
+	px, py, _ := area.FindCube(mapArray, p.Position)
+	dir, byDirection := fleeWays[way]
+	doors := []area.Exit{}
+	for _, d := range area.Doors(mapArray) {
+		door := mapArray[d[0]][d[1]]
+		if door.Closed || len(door.Exits) == 0 {
+			continue
+		}
+		to := door.Exits[0]
+		switch {
+		case way == "":
+		case byDirection:
+			if (d[0]-px)*dir[0]+(d[1]-py)*dir[1] <= 0 {
+				continue
+			}
+		case !strings.HasPrefix(strings.ToLower(to.ToRoom), way):
+			continue
+		}
+		doors = append(doors, to)
+	}
+	return doors
+}
+
+// runAway takes the player out of the room through an open door that way,
+// picked at random, once they get past whatever fights them in the room.
+// Whatever they fought may follow.
+func (s *Server) runAway(roomsMap map[string]map[string][][]area.Cube, p *area.Player, way string) string {
+	ways := fleeDoors(roomsMap, p, way)
+	if len(ways) == 0 {
+		if way != "" {
+			return fmt.Sprintf("There is no way out to %s\n", way)
+		}
+		return "There is nowhere to flee\n"
+	}
+	if npc := s.engaged(p); npc != nil && game.FleeRoll(&p.PC, p.Tactics.Stance, s.rnd) < game.FleeDC(npc.Level) {
+		p.LastFlee = s.now()
+		b := Broadcast{Scope: ScopeRoom, Area: p.Area, Room: p.Room, From: p.Nickname, Kind: TagCombat, Text: "$n {try|tries} to flee, but $N blocks the way\n"}
+		return s.act(roomsMap, b, playerActor(p), npcActor(npc))
+	}
+	to := ways[s.rnd.Intn(len(ways))]
+	p.Resting = game.Awake
+	s.stopSwinging(p)
+	s.publish(WorldEvent{Type: EventPlayerDeparted, Player: p.Nickname, Area: p.Area, Room: p.Room, By: "flee"})
+	sendTo(p, to)
+	s.printToRoom(roomsMap, p.PreviousArea, p.PreviousRoom, tagged(TagCombat, fmt.Sprintf("%s flees\n", p.Nickname)))
+	s.printToRoom(roomsMap, p.Area, p.Room, fmt.Sprintf("%s rushes in, fleeing\n", p.Nickname))
+	s.publish(WorldEvent{Type: EventPlayerArrived, Player: p.Nickname, Area: p.Area, Room: p.Room, By: "flee"})
+	return fmt.Sprintf("You flee to %s\n", p.Room)
+}
+
+// tactics shows how the player fights, or sets their stance, the share of
+// their hit points they flee at, and whether they assist and rescue the
+// members of their group.
+// Usage: tactics [stance <stance>|wimpy <percent>|assist on|off|rescue on|off]
+func (s *Server) tactics(p *area.Player, args []string) string {
+	t := &p.Tactics
+	if len(args) == 0 {
+		wimpy := "off"
+		if t.Wimpy > 0 {
+			wimpy = fmt.Sprintf("under %d%% of your hit points", t.Wimpy)
+		}
+		return fmt.Sprintf("Stance: %s\nFlee: %s\nAssist: %s\nRescue: %s\n",
+			stanceOf(p), wimpy, onOffOf(t.Assist), onOffOf(t.Rescue))
+	}
+	usage := "Usage: tactics [stance <stance>|wimpy <percent>|assist on|off|rescue on|off]\n"
+	if len(args) != 2 {
+		return usage
+	}
+	value := strings.ToLower(args[1])
+	switch strings.ToLower(args[0]) {
+	case "stance":
+		for _, stance := range game.Stances {
+			if stance == value {
+				t.Stance = value
+				if value == game.StanceNormal {
+					t.Stance = ""
+				}
+				return fmt.Sprintf("You fight in the %s stance\n", value)
+			}
+		}
+		return fmt.Sprintf("The stances are %s\n", strings.Join(game.Stances, ", "))
+	case "wimpy":
+		if value == "off" {
+			value = "0"
+		}
+		n, err := strconv.Atoi(strings.TrimSuffix(value, "%"))
+		if err != nil || n < 0 || n > 90 {
+			return "Flee at 0 to 90 percent of your hit points\n"
+		}
+		t.Wimpy = n
+		if n == 0 {
+			return "You fight to the end\n"
+		}
+		return fmt.Sprintf("You flee under %d%% of your hit points\n", n)
+	case "assist", "rescue":
+		if value != "on" && value != "off" {
+			return usage
+		}
+		if strings.ToLower(args[0]) == "assist" {
+			t.Assist = value == "on"
+			return fmt.Sprintf("Assisting your group is %s\n", value)
+		}
+		t.Rescue = value == "on"
+		return fmt.Sprintf("Rescuing your group is %s\n", value)
+	}
+	return usage
+}
@@ -0,0 +1,145 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/droslean/thyranew/area"
+
+	log "gopkg.in/inconshreveable/log15.v2"
+)
+
+// Kinds of output that players can have tagged for the triggers of their client.
+// Output without a kind is sent as a plain message.
+const (
+	TagCombat  = "Combat"
+	TagChat    = "Chat"
+	TagSystem  = "System"
+	TagRoom    = "Room"
+	TagMessage = "Message"
+	TagSummary = "Summary"
+	TagHealing = "Healing"
+	// TagCooldown carries a cooldown that started, for clients to time it.
+	TagCooldown = "Cooldown"
+)
+
+const (
+	// tagMark and tagSep enclose tagged output until it is printed. They never
+	// reach the screen.
+	tagMark = "\x1e"
+	tagSep  = "\x1f"
+	// tagOSC is the operating system command that carries the tags. Terminals
+	// ignore the ones they don't know, so untagged clients see nothing.
+	tagOSC = "7713"
+)
+
+// TaggedText is a piece of output along with its kind.
+type TaggedText struct {
+	Kind string `json:"-"`
+	Text string `json:"text"`
+	// Around is set for output broadcast to everyone around, rather than
+	// meant for the player alone.
+	Around bool `json:"-"`
+}
+
+// RoomInfo is sent to tagged clients every time the player sees a new room.
+type RoomInfo struct {
+	Area        string `json:"area"`
+	Room        string `json:"room"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+// tagged marks the message as output of the given kind.
+func tagged(kind, msg string) string {
+	if msg == "" {
+		return ""
+	}
+	return tagMark + kind + tagSep + msg + tagMark
+}
+
+// untag splits the message into its pieces of output, without the marks.
+func untag(msg string) []TaggedText {
+	pieces := []TaggedText{}
+	for i, part := range strings.Split(msg, tagMark) {
+		kind, text := TagMessage, part
+		// Every other part is enclosed by marks.
+		if i%2 == 1 {
+			if sep := strings.Index(part, tagSep); sep >= 0 {
+				kind, text = part[:sep], part[sep+len(tagSep):]
+			}
+		}
+		if text != "" {
+			pieces = append(pieces, TaggedText{Kind: kind, Text: text})
+		}
+	}
+	return pieces
+}
+
+// plainText joins the pieces of output, leaving out the kinds the player
+// doesn't want among the messages.
+func plainText(pieces []TaggedText, hidden string) string {
+	var plain strings.Builder
+	for _, piece := range pieces {
+		if piece.Kind != hidden {
+			plain.WriteString(piece.Text)
+		}
+	}
+	return plain.String()
+}
+
+// sendTags writes the tagged output to a client that opted in, or that speaks
+// GMCP, after the screen is drawn.
+func (s *Server) sendTags(c Client, pieces []TaggedText) {
+	p := c.Player
+	if !p.Tags && !p.ClientInfo.GMCP {
+		return
+	}
+	if room := p.Area + "/" + p.Room; p.TaggedRoom != room {
+		p.TaggedRoom = room
+		r := s.Areas[p.Area].Rooms[p.Room]
+		writeTag(c, TagRoom, RoomInfo{Area: p.Area, Room: p.Room, Name: r.Name, Description: strings.TrimSpace(stripMarkup(r.Description))})
+	}
+	for _, piece := range pieces {
+		if strings.TrimSpace(piece.Text) == "" {
+			continue
+		}
+		piece.Text = strings.TrimRight(piece.Text, "\n")
+		writeTag(c, piece.Kind, piece)
+	}
+	s.sendCooldowns(c)
+}
+
+// writeTag sends one package to the client, GMCP style: the name of the
+// package followed by its data in JSON.
+func writeTag(c Client, kind string, data interface{}) {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		log.Error(fmt.Sprintf("Cannot tag %s output: %v", kind, err))
+		return
+	}
+	c.writeString(fmt.Sprintf("\x1b]%s;Thyra.%s %s\x07", tagOSC, kind, payload))
+}
+
+// tags turns the tagging of output on or off for the player.
+// Usage: tags [on|off]
+func tags(p *area.Player, args []string) string {
+	if len(args) == 0 {
+		if p.Tags {
+			return "Output tags are on\n"
+		}
+		return "Output tags are off\n"
+	}
+	switch strings.ToLower(args[0]) {
+	case "on":
+		p.Tags = true
+		// The room is sent again, for the scripts to catch up.
+		p.TaggedRoom = ""
+		return "Output tags are on\n"
+	case "off":
+		p.Tags = false
+		return "Output tags are off\n"
+	}
+	return "Usage: tags [on|off]\n"
+}
@@ -0,0 +1,198 @@
+package server
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/droslean/thyranew/area"
+)
+
+// recallCooldown is how long players have to wait between two recalls.
+const recallCooldown = 15 * time.Minute
+
+// escapeWays are the ways players teleport away by of their own will. Fights
+// keep them from these unless the config lets them.
+var escapeWays = map[string]bool{"recall": true, "portal": true, "board": true, "disembark": true, "dungeon": true, "wilderness": true}
+
+// canEscape reports whether the player may teleport away the way they try.
+// Players an NPC is after can't, unless the way is let by the config, or is
+// not their choice.
+func (s *Server) canEscape(p *area.Player, how string) bool {
+	if !escapeWays[how] || !s.inCombat(p) {
+		return true
+	}
+	for _, way := range s.config.Combat.Teleports {
+		if way == how {
+			return true
+		}
+	}
+	return false
+}
+
+// teleport moves the player to the cube the exit leads to in an instant.
+// Both rooms are told, and the departure and the arrival are published on
+// the event bus. Everything that moves players around without walking goes
+// through here, so it is here that fights keep players from escaping. It
+// reports whether the player was moved.
+func (s *Server) teleport(roomsMap map[string]map[string][][]area.Cube, p *area.Player, to area.Exit, how string) bool {
+	if !s.canEscape(p, how) {
+		return false
+	}
+	s.publish(WorldEvent{Type: EventPlayerDeparted, Player: p.Nickname, Area: p.Area, Room: p.Room, By: how})
+	sendTo(p, to)
+	s.printToRoom(roomsMap, p.PreviousArea, p.PreviousRoom, fmt.Sprintf("%s vanishes\n", p.Nickname))
+	s.printToRoom(roomsMap, p.Area, p.Room, fmt.Sprintf("%s appears\n", p.Nickname))
+	s.publish(WorldEvent{Type: EventPlayerArrived, Player: p.Nickname, Area: p.Area, Room: p.Room, By: how})
+	s.levelWarning(roomsMap, p)
+	s.hint(roomsMap, p, "arrive "+p.Area+"/"+p.Room)
+	return true
+}
+
+// freeCube returns a cube of the room nobody stands on, to teleport to.
+func (s *Server) freeCube(roomsMap map[string]map[string][][]area.Cube, areaName, room string) (area.Exit, bool) {
+	taken := map[string]bool{}
+	for _, c := range s.OnlineClientsGetByRoom(areaName, room) {
+		taken[c.Player.Position] = true
+	}
+	for _, column := range roomsMap[areaName][room] {
+		for _, cube := range column {
+			if cube.ID != "" && cube.Type == "" && !taken[cube.ID] {
+				return area.Exit{ToArea: areaName, ToRoom: room, ToCubeID: cube.ID}, true
+			}
+		}
+	}
+	return area.Exit{}, false
+}
+
+// bind makes the current room the place the player recalls and respawns at.
+// Rooms with upkeep take the first day of it up front.
+func (s *Server) bind(p *area.Player) string {
+	if !s.Areas[p.Area].Rooms[p.Room].Bind {
+		return "You can't bind yourself here\n"
+	}
+	was := p.Bind
+	p.Bind = area.Exit{ToArea: p.Area, ToRoom: p.Room, ToCubeID: p.Position}
+	msg := fmt.Sprintf("You are now bound to %s\n", p.Room)
+	if upkeep := s.upkeepOf(p); upkeep > 0 && (was.ToArea != p.Area || was.ToRoom != p.Room) {
+		if p.Gold < upkeep {
+			p.Bind = was
+			return fmt.Sprintf("A room here costs %d gold a day, and you have %d\n", upkeep, p.Gold)
+		}
+		p.Gold -= upkeep
+		s.goldFlow(flowUpkeep, -upkeep)
+		p.Lodging = s.now().Add(upkeepDay)
+		msg = fmt.Sprintf("You take a room in %s for %d gold a day, and are now bound to it\n", p.Room, upkeep)
+	}
+	if err := s.savePlayer(p); err != nil {
+		return "Something went wrong, try again\n"
+	}
+	return msg
+}
+
+// recall takes the player back to its bind point.
+func (s *Server) recall(roomsMap map[string]map[string][][]area.Cube, p *area.Player) string {
+	if wait := p.LastRecall.Add(recallCooldown).Sub(s.now()); wait > 0 {
+		return fmt.Sprintf("You can recall again in %d minutes\n", int(wait.Minutes())+1)
+	}
+	if !s.canEscape(p, "recall") {
+		return "You can't concentrate while fighting\n"
+	}
+	bind := p.Bind
+	if bind.ToArea == "" {
+		bind = defaultBind
+	}
+	p.LastRecall = s.now()
+	s.teleport(roomsMap, p, bind, "recall")
+	return ""
+}
+
+// enterPortal teleports the player standing on a portal to where it leads.
+func (s *Server) enterPortal(roomsMap map[string]map[string][][]area.Cube, p *area.Player) {
+	mapArray := roomsMap[p.Area][p.Room]
+	x, y, ok := area.FindCube(mapArray, p.Position)
+	if !ok || mapArray[x][y].Type != "portal" || len(mapArray[x][y].Exits) == 0 {
+		return
+	}
+	to := mapArray[x][y].Exits[0]
+	if !s.roomOpen(to.ToArea, to.ToRoom) {
+		s.tellPlayer(roomsMap, p.Nickname, closedRoom(to.ToRoom))
+		return
+	}
+	if gate := s.levelGate(p, to.ToArea); gate != "" {
+		s.tellPlayer(roomsMap, p.Nickname, gate)
+		return
+	}
+	if !s.canEscape(p, "portal") {
+		s.tellPlayer(roomsMap, p.Nickname, "The portal won't take you in the middle of a fight\n")
+		return
+	}
+	s.teleport(roomsMap, p, to, "portal")
+}
+
+// gotoPlace takes an admin to a player, to a room, or to where a ref is.
+// Usage: goto <player>, goto <area> <room> or goto <ref>
+func (s *Server) gotoPlace(roomsMap map[string]map[string][][]area.Cube, p *area.Player, args []string) string {
+	var areaName, room string
+	switch len(args) {
+	case 1:
+		if ref, err := area.ParseRef(args[0]); err == nil {
+			var err error
+			if _, areaName, room, err = s.resolve(ref); err != nil {
+				return fmt.Sprintf("%v\n", err)
+			}
+			if areaName == "" {
+				return fmt.Sprintf("%s is nowhere to go to\n", ref)
+			}
+			break
+		}
+		target, ok := s.clientByNick(args[0])
+		if !ok {
+			return fmt.Sprintf("%s is not online\n", args[0])
+		}
+		areaName, room = target.Player.Area, target.Player.Room
+	case 2:
+		areaName, room = args[0], args[1]
+	default:
+		return "Usage: goto <player>, goto <area> <room> or goto <ref>\n"
+	}
+
+	to, ok := s.freeCube(roomsMap, areaName, room)
+	if !ok {
+		return fmt.Sprintf("There is no room to stand in %s/%s\n", areaName, room)
+	}
+	s.teleport(roomsMap, p, to, "goto")
+	return ""
+}
+
+// transfer brings a player to the room of the admin.
+// Usage: transfer <player>
+func (s *Server) transfer(roomsMap map[string]map[string][][]area.Cube, p *area.Player, args []string) string {
+	if len(args) != 1 {
+		return "Usage: transfer <player>\n"
+	}
+	target, ok := s.clientByNick(args[0])
+	if !ok {
+		return fmt.Sprintf("%s is not online\n", args[0])
+	}
+	if strings.EqualFold(target.Player.Nickname, p.Nickname) {
+		return "You are already here\n"
+	}
+	to, ok := s.freeCube(roomsMap, p.Area, p.Room)
+	if !ok {
+		return "There is no room to stand here\n"
+	}
+	s.teleport(roomsMap, target.Player, to, "transfer")
+	return fmt.Sprintf("%s has been transferred\n", target.Player.Nickname)
+}
+
+// isAdmin reports whether the player may use the admin commands.
+func isAdmin(p *area.Player) bool {
+	return p.Role == "admin"
+}
+
+// isBuilder reports whether the player may change the files of the world.
+// Admins are builders too.
+func isBuilder(p *area.Player) bool {
+	return p.Role == "builder" || isAdmin(p)
+}
@@ -0,0 +1,198 @@
+package server
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/droslean/thyranew/area"
+)
+
+// clientProbe gathers what the client tells about itself over SSH: the
+// terminal type of its pty request and the environment it sends.
+type clientProbe struct {
+	sync.Mutex
+	term string
+	env  map[string]string
+}
+
+func newClientProbe() *clientProbe {
+	return &clientProbe{env: make(map[string]string)}
+}
+
+// setTerm records the terminal type of the pty request.
+func (cp *clientProbe) setTerm(term string) {
+	cp.Lock()
+	cp.term = term
+	cp.Unlock()
+}
+
+// setEnv records a variable of the environment of the client.
+func (cp *clientProbe) setEnv(name, value string) {
+	cp.Lock()
+	cp.env[name] = value
+	cp.Unlock()
+}
+
+// info returns what the client can do, going by what it told so far.
+func (cp *clientProbe) info() area.ClientInfo {
+	cp.Lock()
+	defer cp.Unlock()
+	return detectClient(cp.term, cp.env)
+}
+
+// detectClient works out what a client can do from its terminal type and its
+// environment. Clients that tell nothing are taken to do everything, as
+// every client was before there was anything to detect.
+func detectClient(term string, env map[string]string) area.ClientInfo {
+	info := area.ClientInfo{Terminal: term, Color: area.ColorTrue}
+	t := strings.ToLower(term)
+	colorterm := strings.ToLower(env["COLORTERM"])
+	switch {
+	case t == "dumb":
+		info.Color = area.ColorNone
+	case colorterm == "truecolor" || colorterm == "24bit", strings.Contains(t, "direct"):
+		info.Color = area.ColorTrue
+	case strings.Contains(t, "256color"):
+		info.Color = area.Color256
+	case t != "":
+		info.Color = area.Color16
+	}
+	// The first of these that is set is the locale of the client.
+	for _, name := range []string{"LC_ALL", "LC_CTYPE", "LANG"} {
+		if locale := strings.ToLower(env[name]); locale != "" {
+			info.ASCII = !strings.Contains(locale, "utf-8") && !strings.Contains(locale, "utf8")
+			break
+		}
+	}
+	// Terminals of the xterm family report the mouse the way the screen
+	// reads it.
+	for _, family := range mouseTerminals {
+		if strings.HasPrefix(t, family) {
+			info.Mouse = true
+		}
+	}
+	// There is no telnet to negotiate GMCP with, so clients that want the
+	// tagged output say so in their environment.
+	if gmcp := env["GMCP"]; gmcp != "" && gmcp != "0" {
+		info.GMCP = true
+	}
+	return info
+}
+
+// detectTerminal sets what the client of the player can do to what was
+// detected, unless the player picked it themselves.
+func (s *Server) detectTerminal(c *Client) {
+	if !c.Player.ClientInfo.Override {
+		// Plain text is the choice of the player, not something to detect.
+		plain := c.Player.ClientInfo.Plain
+		c.Player.ClientInfo = c.probe.info()
+		c.Player.ClientInfo.Plain = plain
+	}
+	s.applyMouse(c)
+}
+
+// mouseTerminals are the terminal types that report the mouse like xterm.
+var mouseTerminals = []string{"xterm", "screen", "tmux", "rxvt", "alacritty", "kitty", "foot", "wezterm", "putty"}
+
+// asciiRunes stand in for the runes of the screen on terminals that don't
+// take UTF-8.
+var asciiRunes = map[rune]rune{
+	'█': '#',
+	'░': '.',
+	'─': '-',
+	'│': '|',
+	'┌': '+',
+	'┐': '+',
+	'└': '+',
+	'┘': '+',
+}
+
+// asciiRune returns the rune as it is drawn on a terminal without UTF-8.
+func asciiRune(r rune) rune {
+	if r < 0x80 {
+		return r
+	}
+	if a, ok := asciiRunes[r]; ok {
+		return a
+	}
+	return '?'
+}
+
+// rgbCode returns the escape sequence of the foreground colour, as close as
+// the colour depth of the terminal gets to it.
+func rgbCode(depth string, r, g, b int) string {
+	if depth == area.Color256 {
+		cube := func(v int) int { return (v*5 + 127) / 255 }
+		return fmt.Sprintf("\x1b[38;5;%dm", 16+36*cube(r)+6*cube(g)+cube(b))
+	}
+	return fmt.Sprintf("\x1b[38;2;%d;%d;%dm", r, g, b)
+}
+
+// terminal shows what the client of the player can do, detects it again, or
+// lets the player set it.
+// Usage: terminal [detect], terminal color none|16|256|truecolor, terminal utf8|gmcp|mouse|plain on|off
+func (s *Server) terminal(c *Client, args []string) string {
+	usage := "Usage: terminal [detect], terminal color none|16|256|truecolor, terminal utf8|gmcp|mouse|plain on|off\n"
+	p := c.Player
+	if len(args) == 0 {
+		return describeClient(p.ClientInfo)
+	}
+	switch strings.ToLower(args[0]) {
+	case "detect":
+		p.ClientInfo.Override = false
+		s.detectTerminal(c)
+		return s.savePreferences(p, describeClient(p.ClientInfo))
+	case "color", "colour":
+		if len(args) != 2 {
+			return usage
+		}
+		switch depth := strings.ToLower(args[1]); depth {
+		case area.ColorNone, area.Color16, area.Color256, area.ColorTrue:
+			p.ClientInfo.Color = depth
+		default:
+			return usage
+		}
+	case "utf8", "gmcp", "mouse", "plain":
+		if len(args) != 2 {
+			return usage
+		}
+		on := strings.ToLower(args[1])
+		if on != "on" && on != "off" {
+			return usage
+		}
+		switch strings.ToLower(args[0]) {
+		case "utf8":
+			p.ClientInfo.ASCII = on == "off"
+		case "gmcp":
+			p.ClientInfo.GMCP = on == "on"
+		case "mouse":
+			p.ClientInfo.Mouse = on == "on"
+		case "plain":
+			p.ClientInfo.Plain = on == "on"
+		}
+	default:
+		return usage
+	}
+	p.ClientInfo.Override = true
+	s.applyMouse(c)
+	return s.savePreferences(p, describeClient(p.ClientInfo))
+}
+
+// describeClient tells the player what their client can do.
+func describeClient(info area.ClientInfo) string {
+	term := info.Terminal
+	if term == "" {
+		term = "unknown"
+	}
+	color := info.Color
+	if color == "" {
+		color = area.ColorTrue
+	}
+	onOff := map[bool]string{true: "on", false: "off"}
+	how := "detected"
+	if info.Override {
+		how = "set by you"
+	}
+	return fmt.Sprintf("Terminal %s, colours %s, UTF-8 %s, GMCP %s, mouse %s, plain text %s (%s)\n", term, color, onOff[!info.ASCII], onOff[info.GMCP], onOff[info.Mouse], onOff[info.Plain], how)
+}
@@ -0,0 +1,168 @@
+package server
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/droslean/thyranew/area"
+	"github.com/droslean/thyranew/game"
+)
+
+// pocketsWary is how long NPCs who were robbed, or caught a thief, keep a
+// hand on their purse.
+const pocketsWary = 10 * time.Minute
+
+// theftReputation is the least standing a caught thief loses with the
+// faction of the NPC who caught them.
+const theftReputation = 5
+
+// steal takes something another player or an NPC in the room carries: an
+// item by name, gold, or whatever comes to hand when nothing is named.
+// Players have to consent to theft, and NPCs who notice the thief call the
+// guards.
+// Usage: steal [item|gold] from <target>
+func (s *Server) steal(roomsMap map[string]map[string][][]area.Cube, p *area.Player, args []string) string {
+	from := -1
+	for i, arg := range args {
+		if strings.EqualFold(arg, "from") {
+			from = i
+		}
+	}
+	if from < 0 || from == len(args)-1 {
+		return "Usage: steal [item|gold] from <target>\n"
+	}
+	what, name := strings.Join(args[:from], " "), strings.Join(args[from+1:], " ")
+	if o, ok := s.playerHere(p, name); ok {
+		return s.stealFromPlayer(roomsMap, p, o, what)
+	}
+	lower := strings.ToLower(name)
+	for _, npc := range s.npcsInRoom(p.Area, p.Room) {
+		if strings.HasPrefix(strings.ToLower(npc.Name), lower) {
+			return s.stealFromNPC(roomsMap, p, npc, what)
+		}
+	}
+	return fmt.Sprintf("You don't see %s here\n", name)
+}
+
+// stealFromPlayer robs another player who takes part in fights between
+// players. A noticed thief gets nothing, and the mark learns who tried.
+func (s *Server) stealFromPlayer(roomsMap map[string]map[string][][]area.Cube, p, o *area.Player, what string) string {
+	if err := s.consent(p, o, actSteal); err != nil {
+		return err.Error() + "\n"
+	}
+	p.LastHostile = s.now()
+
+	if s.stealthRoll(p) < game.Perception(&o.PC) {
+		s.tellPlayer(roomsMap, o.Nickname, fmt.Sprintf("You catch %s reaching for your belongings\n", p.Nickname))
+		// The law punishes theft between players too, if it sees it.
+		if s.witnessed(p, 0) {
+			s.reportCrime(roomsMap, p, area.CrimeTheft, "")
+		}
+		return fmt.Sprintf("%s notices you and pulls away\n", o.Nickname)
+	}
+	switch {
+	case strings.EqualFold(what, "gold"), what == "" && len(o.Inventory) == 0:
+		// A tenth of the purse is what fits in a quick hand.
+		n := o.Gold / 10
+		if n == 0 && o.Gold > 0 {
+			n = 1
+		}
+		if n == 0 {
+			return fmt.Sprintf("%s carries nothing worth taking\n", o.Nickname)
+		}
+		o.Gold -= n
+		p.Gold += n
+		return fmt.Sprintf("You lift %d gold from %s\n", n, o.Nickname)
+	case what == "":
+		what = o.Inventory[s.rnd.Intn(len(o.Inventory))]
+	}
+	for _, item := range o.Inventory {
+		if strings.EqualFold(item, what) {
+			if !s.fits(p, item) {
+				return tooHeavy(item)
+			}
+			taken, ok := o.TakeItem(item)
+			if !ok {
+				return fmt.Sprintf("The %s is bound to %s and won't leave them\n", item, o.Nickname)
+			}
+			p.GiveItem(taken)
+			return fmt.Sprintf("You lift %s from %s\n", item, o.Nickname)
+		}
+	}
+	return fmt.Sprintf("You find no %s on %s\n", what, o.Nickname)
+}
+
+// stealFromNPC picks the pockets of the NPC, who carries what its loot table
+// gives. Robbed or not, the NPC is wary of thieves for a while after.
+func (s *Server) stealFromNPC(roomsMap map[string]map[string][][]area.Cube, p *area.Player, npc area.NPC, what string) string {
+	if npc.Target != "" {
+		return fmt.Sprintf("%s is moving around too much\n", npc.Name)
+	}
+	if until, ok := s.picked[npc.ID]; ok && s.now().Before(until) {
+		return fmt.Sprintf("%s is wary of thieves and keeps out of reach\n", npc.Name)
+	}
+	s.picked[npc.ID] = s.now().Add(pocketsWary)
+
+	if s.stealthRoll(p) < game.PickpocketDC(npc.Level, npc.Activity == "sleeping") {
+		return s.caughtStealing(roomsMap, p, npc)
+	}
+
+	drops, gold := game.RollLoot(s.LootTables, npc.Loot, lootContext(p, npc.Level), s.rnd)
+	switch {
+	case strings.EqualFold(what, "gold"):
+		drops = nil
+	case what != "":
+		var match []game.Drop
+		for _, d := range drops {
+			if strings.EqualFold(d.Item, what) {
+				match = []game.Drop{d}
+				break
+			}
+		}
+		if match == nil {
+			return fmt.Sprintf("You find no %s on %s\n", what, npc.Name)
+		}
+		drops, gold = match, 0
+	case len(drops) > 0:
+		// A quick hand comes away with one thing.
+		drops, gold = drops[s.rnd.Intn(len(drops)):][:1], 0
+	}
+	taken := []string{}
+	for _, d := range drops {
+		if !s.receive(p, d.Item) {
+			return tooHeavy(d.Item)
+		}
+		taken = append(taken, d.Item)
+	}
+	if gold > 0 {
+		p.Gold += gold
+		s.goldFlow(flowPickpocket, gold)
+		taken = append(taken, fmt.Sprintf("%d gold", gold))
+	}
+	if len(taken) == 0 {
+		return fmt.Sprintf("You find nothing worth taking on %s\n", npc.Name)
+	}
+	return fmt.Sprintf("You lift %s from %s\n", strings.Join(taken, ", "), npc.Name)
+}
+
+// caughtStealing makes the NPC who caught the thief raise the alarm. The
+// faction of the NPC thinks less of the thief, and the guards come after
+// them.
+func (s *Server) caughtStealing(roomsMap map[string]map[string][][]area.Cube, p *area.Player, npc area.NPC) string {
+	p.HideRoll = 0
+	if npc.Faction != "" {
+		loss := npc.Reputation / 2
+		if loss < theftReputation {
+			loss = theftReputation
+		}
+		s.adjustReputation(p, npc.Faction, -loss)
+	}
+	called := s.reportCrime(roomsMap, p, area.CrimeTheft, npc.Faction)
+
+	b := Broadcast{Scope: ScopeRoom, Area: p.Area, Room: p.Room, From: p.Nickname, Text: "$N catches $n with a hand in $S pocket\n"}
+	if called > 0 {
+		b.Text = "$N catches $n with a hand in $S pocket and shouts for the guards\n"
+	}
+	return s.act(roomsMap, b, playerActor(p), npcActor(&npc))
+}
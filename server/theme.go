@@ -0,0 +1,201 @@
+package server
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/droslean/thyranew/area"
+	"github.com/jpillora/ansi"
+)
+
+// Meanings a theme gives colours to.
+const (
+	semanticDamage  = "damage"
+	semanticHealing = "healing"
+	semanticChat    = "chat"
+)
+
+// semanticKinds tells which kinds of output are coloured for their meaning.
+var semanticKinds = map[string]string{
+	TagCombat:  semanticDamage,
+	TagHealing: semanticHealing,
+	TagChat:    semanticChat,
+}
+
+// Theme is how the screen is coloured for a player. Every colour drawn goes
+// through the palette of the theme, so players who can't tell some colours
+// apart never get to see them.
+type Theme struct {
+	// Palette swaps colours for others. Colours it leaves out are drawn as
+	// they are.
+	Palette map[ansi.Attribute]ansi.Attribute
+	Bright  bool // Colours are drawn bright
+	Mono    bool // Nothing is coloured at all
+	// Depth is the colour depth of the terminal, for the bars.
+	Depth string
+	// Semantic gives the colour of the output of each meaning.
+	Semantic map[string]ansi.Attribute
+	// Gauge colours the HP bars of the status line.
+	Gauge func(float64) (int, int, int)
+}
+
+// themes are all the themes players can pick.
+var themes = map[string]Theme{
+	"default": {
+		Semantic: map[string]ansi.Attribute{semanticDamage: ansi.Red, semanticHealing: ansi.Green, semanticChat: ansi.Cyan},
+		Gauge:    redToGreen,
+	},
+	// Red and green look alike to deuteranopes, blue and yellow don't.
+	"deuteranopia": {
+		Palette:  map[ansi.Attribute]ansi.Attribute{ansi.Red: ansi.Magenta, ansi.Green: ansi.Cyan},
+		Semantic: map[string]ansi.Attribute{semanticDamage: ansi.Yellow, semanticHealing: ansi.Blue, semanticChat: ansi.Cyan},
+		Gauge:    orangeToBlue,
+	},
+	// Dark colours are hard to read on a dark terminal.
+	"high-contrast": {
+		Palette:  map[ansi.Attribute]ansi.Attribute{ansi.Blue: ansi.Cyan, ansi.Black: ansi.White},
+		Bright:   true,
+		Semantic: map[string]ansi.Attribute{semanticDamage: ansi.Red, semanticHealing: ansi.Green, semanticChat: ansi.Yellow},
+		Gauge:    redToGreen,
+	},
+	"monochrome": {
+		Mono: true,
+	},
+}
+
+// themeOf returns the theme of the player, as their terminal can draw it.
+func themeOf(p *area.Player) Theme {
+	t, ok := themes[p.Theme]
+	if !ok {
+		t = themes["default"]
+	}
+	t.Depth = p.ClientInfo.Color
+	if t.Depth == area.ColorNone {
+		t.Mono = true
+	}
+	return t
+}
+
+// code returns the escape sequence that switches to the colour, as the theme
+// draws it.
+func (t Theme) code(color ID) []byte {
+	if color == noColor || t.Mono {
+		return ansi.Set(ansi.Reset)
+	}
+	attr := ansi.Attribute(color)
+	if swapped, ok := t.Palette[attr]; ok {
+		attr = swapped
+	}
+	if t.Bright {
+		return ansi.Set(ansi.Bright, attr)
+	}
+	return ansi.Set(attr)
+}
+
+// bars returns the palette the theme draws the bars in, nil for no colours.
+// Terminals of 16 colours cannot draw the fades of the palettes.
+func (t Theme) bars(palette func(float64) (int, int, int)) func(float64) (int, int, int) {
+	if t.Mono || t.Depth == area.Color16 {
+		return nil
+	}
+	return palette
+}
+
+// semanticColor returns the colour of the output of the meaning, for the
+// player. Players can override the colours of each theme.
+func semanticColor(p *area.Player, meaning string) ID {
+	if name, ok := p.ThemeColors[themeName(p)+"."+meaning]; ok {
+		if color, ok := highlightColors[name]; ok {
+			return ID(color)
+		}
+	}
+	if color, ok := themeOf(p).Semantic[meaning]; ok {
+		return ID(color)
+	}
+	return noColor
+}
+
+// themeName returns the name of the theme of the player.
+func themeName(p *area.Player) string {
+	if _, ok := themes[p.Theme]; ok {
+		return p.Theme
+	}
+	return "default"
+}
+
+// lineColors returns the colour of each line of the output that has a meaning.
+func lineColors(p *area.Player, pieces []TaggedText) map[string]ID {
+	colors := map[string]ID{}
+	for _, piece := range pieces {
+		meaning, ok := semanticKinds[piece.Kind]
+		if !ok {
+			continue
+		}
+		color := semanticColor(p, meaning)
+		if color == noColor {
+			continue
+		}
+		for _, line := range strings.Split(strings.TrimRight(piece.Text, "\n"), "\n") {
+			colors[line] = color
+		}
+	}
+	return colors
+}
+
+// orangeToBlue fades from orange when empty to blue when full.
+func orangeToBlue(fraction float64) (int, int, int) {
+	if fraction < 0 {
+		fraction = 0
+	}
+	if fraction > 1 {
+		fraction = 1
+	}
+	return int(230 - 230*fraction), int(159 - 45*fraction), int(178 * fraction)
+}
+
+// theme shows or picks the theme of the player, or sets the colour of one of
+// its meanings.
+// Usage: theme [name], theme damage|healing|chat <color>|reset
+func (s *Server) theme(p *area.Player, args []string) string {
+	if len(args) == 0 {
+		names := []string{}
+		for name := range themes {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return fmt.Sprintf("Your theme is %s. Themes: %s\n", themeName(p), strings.Join(names, ", "))
+	}
+
+	name := strings.ToLower(args[0])
+	if _, ok := themes[name]; ok && len(args) == 1 {
+		p.Theme = name
+		if name == "default" {
+			p.Theme = ""
+		}
+		return s.savePreferences(p, fmt.Sprintf("Your theme is %s\n", name))
+	}
+
+	switch name {
+	case semanticDamage, semanticHealing, semanticChat:
+	default:
+		return "Usage: theme [name], theme damage|healing|chat <color>|reset\n"
+	}
+	if len(args) != 2 {
+		return "Usage: theme damage|healing|chat <color>|reset\n"
+	}
+	key := themeName(p) + "." + name
+	color := strings.ToLower(args[1])
+	if color == "reset" {
+		delete(p.ThemeColors, key)
+		return s.savePreferences(p, fmt.Sprintf("%s is back to the colour of %s\n", strings.Title(name), themeName(p)))
+	}
+	if _, ok := highlightColors[color]; !ok {
+		return "Colors: blue, cyan, green, magenta, red, white, yellow\n"
+	}
+	if p.ThemeColors == nil {
+		p.ThemeColors = make(map[string]string)
+	}
+	p.ThemeColors[key] = color
+	return s.savePreferences(p, fmt.Sprintf("%s is %s in %s\n", strings.Title(name), color, themeName(p)))
+}
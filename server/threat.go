@@ -0,0 +1,170 @@
+package server
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/droslean/thyranew/area"
+	"github.com/droslean/thyranew/game"
+)
+
+const (
+	// npcLeash is how many rooms from where its fight started an NPC gives
+	// up and goes back.
+	npcLeash = 4
+	// tauntCooldown is how often a player can taunt.
+	tauntCooldown = 8 * time.Second
+)
+
+// addThreat puts the threat on the mind of the NPC, against the player.
+func (s *Server) addThreat(npc *area.NPC, nick string, amount int) {
+	table, ok := s.threat[npc.ID]
+	if !ok {
+		table = make(map[string]int)
+		s.threat[npc.ID] = table
+	}
+	table[nick] += amount
+}
+
+// topThreat returns the highest threat on the mind of the NPC, of anyone
+// but the player.
+func (s *Server) topThreat(npc *area.NPC, except string) int {
+	highest := 0
+	for nick, t := range s.threat[npc.ID] {
+		if nick != except && t > highest {
+			highest = t
+		}
+	}
+	return highest
+}
+
+// forgetThreat takes the player off the mind of the NPC.
+func (s *Server) forgetThreat(id int, nick string) {
+	delete(s.threat[id], nick)
+}
+
+// healThreat turns the NPCs fighting the healed player against the healer.
+func (s *Server) healThreat(roomsMap map[string]map[string][][]area.Cube, healer, healed *area.Player, amount int) {
+	if amount <= 0 {
+		return
+	}
+	for id, table := range s.threat {
+		if _, ok := table[healed.Nickname]; !ok {
+			continue
+		}
+		if npc, _ := s.findNPC(id); npc != nil {
+			s.addThreat(npc, healer.Nickname, game.HealThreat(amount))
+			s.provoke(roomsMap, npc, healer.Nickname)
+		}
+	}
+}
+
+// chooseTarget picks who the NPC goes after among those on its mind, and
+// forgets those who are gone. It sticks to its target until someone else
+// causes clearly more threat.
+func (s *Server) chooseTarget(roomsMap map[string]map[string][][]area.Cube, npc *area.NPC, areaName string) (*Client, bool) {
+	table := s.threat[npc.ID]
+	targets := map[string]*Client{}
+	for nick := range table {
+		cl, ok := s.clientByNick(nick)
+		if !ok || cl.Player.Area != areaName || cl.Player.Ghost {
+			delete(table, nick)
+			continue
+		}
+		targets[nick] = cl
+	}
+
+	nicks := []string{}
+	for nick := range targets {
+		nicks = append(nicks, nick)
+	}
+	// The order of the nicknames settles ties the same way every time.
+	sort.Strings(nicks)
+	best := ""
+	for _, nick := range nicks {
+		if best == "" || table[nick] > table[best] {
+			best = nick
+		}
+	}
+	if _, ok := targets[npc.Target]; ok && best != npc.Target {
+		if !game.SwitchesTarget(table[npc.Target], table[best], targets[best].Player.Room == npc.Room) {
+			best = npc.Target
+		}
+	}
+
+	old := npc.Target
+	npc.Target = best
+	if old != "" && old != best {
+		if best != "" {
+			s.printToRoom(roomsMap, areaName, npc.Room, tagged(TagCombat, fmt.Sprintf("%s turns on %s\n", npc.Name, best)))
+		}
+		s.endFight(roomsMap, old)
+	}
+	if best == "" {
+		return nil, false
+	}
+	return targets[best], true
+}
+
+// resetNPC sends the NPC back to where its fight started, whole again, with
+// nobody on its mind.
+func (s *Server) resetNPC(roomsMap map[string]map[string][][]area.Cube, npc *area.NPC, areaName string) {
+	if home, ok := s.leashes[npc.ID]; ok && home.Room != npc.Room {
+		if s.ambient() {
+			s.printToRoom(roomsMap, areaName, npc.Room, fmt.Sprintf("%s gives up and heads back\n", npc.Name))
+		}
+		npc.Room, npc.Position = home.Room, home.Position
+	}
+	npc.HP = npc.MaxHP
+	npc.Effects = nil
+	old := npc.Target
+	npc.Target = ""
+	delete(s.threat, npc.ID)
+	delete(s.leashes, npc.ID)
+	delete(s.pursuits, npc.ID)
+	if old != "" {
+		s.endFight(roomsMap, old)
+	}
+}
+
+// leashed reports whether the NPC was dragged too far from where its fight
+// started.
+func (s *Server) leashed(roomsMap map[string]map[string][][]area.Cube, npc *area.NPC, areaName string) bool {
+	home, ok := s.leashes[npc.ID]
+	if !ok {
+		return false
+	}
+	path, ok := roomPath(roomsMap, areaName, home.Room, areaName, npc.Room)
+	return !ok || len(path) > npcLeash
+}
+
+// taunt makes an NPC in the room turn on the player, whoever it fought.
+// Usage: taunt <npc>
+func (s *Server) taunt(roomsMap map[string]map[string][][]area.Cube, p *area.Player, args []string) string {
+	if len(args) == 0 {
+		return "Usage: taunt <npc>\n"
+	}
+	if wait := tauntCooldown - s.now().Sub(p.LastTaunt); wait > 0 {
+		return fmt.Sprintf("You can taunt again in %s\n", wait.Round(time.Second))
+	}
+	name := strings.ToLower(strings.Join(args, " "))
+	for _, n := range s.npcsInRoom(p.Area, p.Room) {
+		if !strings.HasPrefix(strings.ToLower(n.Name), name) {
+			continue
+		}
+		npc, _ := s.findNPC(n.ID)
+		p.LastTaunt = s.now()
+		s.startGlobalCooldown(roomsMap, p)
+		s.addThreat(npc, p.Nickname, game.TauntThreat(s.topThreat(npc, p.Nickname))-s.threat[npc.ID][p.Nickname])
+		s.provoke(roomsMap, npc, p.Nickname)
+		if old := npc.Target; old != p.Nickname {
+			npc.Target = p.Nickname
+			s.endFight(roomsMap, old)
+		}
+		b := Broadcast{Scope: ScopeRoom, Area: p.Area, Room: p.Room, From: p.Nickname, Kind: TagCombat, Text: "$n {taunt|taunts} $N, who turns on $m\n"}
+		return s.act(roomsMap, b, playerActor(p), npcActor(npc))
+	}
+	return fmt.Sprintf("There is no %s here\n", name)
+}
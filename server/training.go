@@ -0,0 +1,185 @@
+package server
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/droslean/thyranew/area"
+	"github.com/droslean/thyranew/game"
+	"github.com/gothyra/toml"
+
+	log "gopkg.in/inconshreveable/log15.v2"
+)
+
+// loadSkillTables loads what every class can learn from trainers from the
+// static directory into memory.
+func (s *Server) loadSkillTables() error {
+	path := s.staticDir + "/skills.toml"
+	fileContent, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		log.Info(fmt.Sprintf("%s could not be loaded: %v", path, err))
+		return err
+	}
+
+	tables := struct {
+		Classes []area.SkillTable `toml:"classes"`
+	}{}
+	if _, err := toml.Decode(string(fileContent), &tables); err != nil {
+		log.Info(fmt.Sprintf("%s could not be unmarshaled: %v", path, err))
+		return err
+	}
+
+	for _, t := range tables.Classes {
+		for _, l := range t.Skills {
+			if !game.IsSkill(l.Skill) {
+				log.Error(fmt.Sprintf("%s teaches the unknown skill %q to the %s class", path, l.Skill, t.Class))
+			}
+		}
+		log.Info(fmt.Sprintf("Loaded the skills of the %s class", t.Class))
+		s.SkillTables[t.Class] = t
+	}
+	return nil
+}
+
+// trainerHere returns the trainer in the room of the player who is willing
+// to teach.
+func (s *Server) trainerHere(p *area.Player) (area.NPC, bool) {
+	for _, npc := range s.npcsInRoom(p.Area, p.Room) {
+		if len(npc.Teaches) > 0 && npc.Target == "" && npc.Activity != "sleeping" {
+			return npc, true
+		}
+	}
+	return area.NPC{}, false
+}
+
+// lessonsOf returns the lessons of the trainer the class of the player can
+// take, whether or not the player is ready for them yet.
+func (s *Server) lessonsOf(p *area.Player, trainer area.NPC) []area.Lesson {
+	lessons := []area.Lesson{}
+	table := s.SkillTables[p.Class]
+	for _, skill := range trainer.Teaches {
+		if l, ok := table.Lesson(skill); ok {
+			lessons = append(lessons, l)
+		}
+	}
+	return lessons
+}
+
+// lessonCost returns the practices and the gold the next rank of the lesson
+// costs the player.
+func lessonCost(p *area.Player, l area.Lesson) (int, int) {
+	trained := p.Trained[l.Skill]
+	return game.TrainingCost(l.Practices, trained), game.TrainingCost(l.Gold, trained)
+}
+
+// lessonLine describes the lesson to the player: the ranks they trained and
+// what the next costs, or why they can't take it.
+func lessonLine(p *area.Player, l area.Lesson) string {
+	trained := p.Trained[l.Skill]
+	line := fmt.Sprintf("%s: rank %d of %d", strings.Title(l.Skill), trained, l.Ranks)
+	switch {
+	case trained >= l.Ranks:
+		return line + ", learned in full"
+	case p.Level < l.Level:
+		return line + fmt.Sprintf(", from level %d", l.Level)
+	}
+	practices, gold := lessonCost(p, l)
+	return line + fmt.Sprintf(", %d practices and %d gold", practices, gold)
+}
+
+// practice shows the practices of the player, and what the trainer in the
+// room can teach them at what cost.
+// Usage: practice
+func (s *Server) practice(p *area.Player) string {
+	msg := fmt.Sprintf("You have %d practices\n", p.Practices)
+	trainer, ok := s.trainerHere(p)
+	if !ok {
+		return msg + "There is no trainer here\n"
+	}
+	lessons := s.lessonsOf(p, trainer)
+	if len(lessons) == 0 {
+		return msg + fmt.Sprintf("%s has nothing to teach a %s\n", trainer.Name, p.Class)
+	}
+	msg += fmt.Sprintf("%s teaches:\n", trainer.Name)
+	for _, l := range lessons {
+		msg += "  " + lessonLine(p, l) + "\n"
+	}
+	return msg
+}
+
+// train learns the next rank of the skill from the trainer in the room, for
+// practices and gold. Without a skill, the lessons are picked from a list.
+// Usage: train [skill]
+func (s *Server) train(c *Client, args []string) string {
+	p := c.Player
+	trainer, ok := s.trainerHere(p)
+	if !ok {
+		return "There is no trainer here\n"
+	}
+	if len(args) == 0 {
+		lessons := s.lessonsOf(p, trainer)
+		if len(lessons) == 0 {
+			return fmt.Sprintf("%s has nothing to teach a %s\n", trainer.Name, p.Class)
+		}
+		s.openModal(c, s.lessonsMenu(p, trainer, lessons))
+		return ""
+	}
+	return s.trainSkill(p, trainer, strings.ToLower(strings.Join(args, " ")))
+}
+
+// lessonsMenu lets the player pick a lesson of the trainer to take.
+func (s *Server) lessonsMenu(p *area.Player, trainer area.NPC, lessons []area.Lesson) *menu {
+	choices := []choice{}
+	for _, l := range lessons {
+		choices = append(choices, choice{label: lessonLine(p, l), value: l.Skill})
+	}
+	return &menu{
+		title:   fmt.Sprintf("Train with %s, %d practices left", trainer.Name, p.Practices),
+		choices: choices,
+		pick: func(s *Server, c *Client, lesson choice) string {
+			return s.train(c, []string{lesson.value})
+		},
+	}
+}
+
+// trainSkill has the trainer teach the player the next rank of the skill.
+func (s *Server) trainSkill(p *area.Player, trainer area.NPC, skill string) string {
+	var lesson area.Lesson
+	found := false
+	for _, l := range s.lessonsOf(p, trainer) {
+		if strings.HasPrefix(l.Skill, skill) {
+			lesson, found = l, true
+			break
+		}
+	}
+	if !found {
+		return fmt.Sprintf("%s can't teach you %s\n", trainer.Name, skill)
+	}
+	trained := p.Trained[lesson.Skill]
+	if trained >= lesson.Ranks {
+		return fmt.Sprintf("%s has nothing more to teach you of %s\n", trainer.Name, lesson.Skill)
+	}
+	if p.Level < lesson.Level {
+		return fmt.Sprintf("Come back at level %d to learn %s\n", lesson.Level, lesson.Skill)
+	}
+	practices, gold := lessonCost(p, lesson)
+	if p.Practices < practices {
+		return fmt.Sprintf("You need %d practices to train %s, and have %d\n", practices, lesson.Skill, p.Practices)
+	}
+	if p.Gold < gold {
+		return fmt.Sprintf("%s asks %d gold to train you, and you have %d\n", trainer.Name, gold, p.Gold)
+	}
+	p.Practices -= practices
+	p.Gold -= gold
+	s.goldFlow(flowServices, -gold)
+	if p.Trained == nil {
+		p.Trained = map[string]int{}
+	}
+	p.Trained[lesson.Skill]++
+	return fmt.Sprintf("%s trains you in %s, to rank %d of %d\n", trainer.Name, lesson.Skill, trained+1, lesson.Ranks)
+}
@@ -0,0 +1,251 @@
+package server
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/droslean/thyranew/area"
+	"github.com/gothyra/toml"
+
+	log "gopkg.in/inconshreveable/log15.v2"
+)
+
+var directionNames = []string{"east", "west", "north", "south"}
+
+// loadVehicles loads all the vehicles from the static directory into memory.
+func (s *Server) loadVehicles() error {
+	log.Info("Loading vehicles ...")
+	vehicleWalker := func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+
+		fileContent, fileIoErr := ioutil.ReadFile(path)
+		if fileIoErr != nil {
+			log.Info(fmt.Sprintf("%s could not be loaded: %v", path, fileIoErr))
+			return fileIoErr
+		}
+
+		v := &area.Vehicle{}
+		if _, err := toml.Decode(string(fileContent), v); err != nil {
+			log.Info(fmt.Sprintf("%s could not be unmarshaled: %v", path, err))
+			return err
+		}
+		if _, ok := s.Areas[v.Area].Rooms[v.Deck]; !ok {
+			log.Info(fmt.Sprintf("%s has no deck %s/%s", path, v.Area, v.Deck))
+			return fmt.Errorf("vehicle %q has no deck", v.Name)
+		}
+
+		log.Info(fmt.Sprintf("Loaded vehicle %q", v.Name))
+		s.Vehicles[v.Name] = v
+
+		return nil
+	}
+
+	return filepath.Walk(s.staticDir+"/vehicles/", vehicleWalker)
+}
+
+// reloadVehicles loads the vehicles again while the game runs. Those already
+// out keep where they stand and who is at their helm.
+func (s *Server) reloadVehicles() string {
+	running := s.Vehicles
+	s.Vehicles = make(map[string]*area.Vehicle)
+	if err := s.loadVehicles(); err != nil {
+		s.Vehicles = running
+		return fmt.Sprintf("The vehicles did not load again, and stay as they were: %v", err)
+	}
+	for name, v := range s.Vehicles {
+		if old, ok := running[name]; ok {
+			v.Location, v.Pilot = old.Location, old.Pilot
+		}
+	}
+	return fmt.Sprintf("Reloaded %d vehicles", len(s.Vehicles))
+}
+
+// vehicleByArea returns the vehicle whose inside is the given area.
+func (s *Server) vehicleByArea(areaName string) (*area.Vehicle, bool) {
+	for _, v := range s.Vehicles {
+		if v.Area == areaName {
+			return v, true
+		}
+	}
+	return nil, false
+}
+
+// vehiclesAt returns the positions of the vehicles standing in the given room.
+func (s *Server) vehiclesAt(areaName, room string) []string {
+	positions := []string{}
+	for _, v := range s.Vehicles {
+		if v.Location.ToArea == areaName && v.Location.ToRoom == room {
+			positions = append(positions, v.Location.ToCubeID)
+		}
+	}
+	return positions
+}
+
+// board takes the player aboard a vehicle next to them.
+// Usage: board <vehicle>
+func (s *Server) board(roomsMap map[string]map[string][][]area.Cube, p *area.Player, args []string) string {
+	name := strings.ToLower(strings.Join(args, " "))
+	for _, v := range s.Vehicles {
+		if name == "" || !strings.HasPrefix(strings.ToLower(v.Name), name) {
+			continue
+		}
+		if !s.nextTo(roomsMap, p, v.Location) {
+			return fmt.Sprintf("The %s is too far away\n", v.Name)
+		}
+		if !s.canEscape(p, "board") {
+			return "You can't climb aboard in the middle of a fight\n"
+		}
+		s.teleport(roomsMap, p, area.Exit{ToArea: v.Area, ToRoom: v.Deck, ToCubeID: v.Hatch}, "board")
+		return ""
+	}
+	return "There is nothing like that to board\n"
+}
+
+// nextTo reports whether the player stands on or right next to the given place.
+func (s *Server) nextTo(roomsMap map[string]map[string][][]area.Cube, p *area.Player, place area.Exit) bool {
+	if p.Area != place.ToArea || p.Room != place.ToRoom {
+		return false
+	}
+	if w, ok := s.Wilderness[p.Area]; ok {
+		px, py, _ := w.Cell(p.Position)
+		vx, vy, _ := w.Cell(place.ToCubeID)
+		return abs(px-vx)+abs(py-vy) <= 1
+	}
+	mapArray := roomsMap[p.Area][p.Room]
+	px, py, _ := area.FindCube(mapArray, p.Position)
+	vx, vy, _ := area.FindCube(mapArray, place.ToCubeID)
+	return abs(px-vx)+abs(py-vy) <= 1
+}
+
+// disembark takes the player off the vehicle, onto free ground next to it.
+func (s *Server) disembark(roomsMap map[string]map[string][][]area.Cube, p *area.Player) string {
+	v, ok := s.vehicleByArea(p.Area)
+	if !ok || p.Room != v.Deck {
+		return "You can only disembark from a deck\n"
+	}
+	if !s.canEscape(p, "disembark") {
+		return "You can't climb off in the middle of a fight\n"
+	}
+	to, ok := s.landing(roomsMap, v)
+	if !ok {
+		return "There is no ground to step on here\n"
+	}
+	if v.Pilot == p.Nickname {
+		v.Pilot = ""
+	}
+	s.teleport(roomsMap, p, to, "disembark")
+	return ""
+}
+
+// landing returns a free place next to the vehicle the passengers can step on.
+func (s *Server) landing(roomsMap map[string]map[string][][]area.Cube, v *area.Vehicle) (area.Exit, bool) {
+	loc := v.Location
+	taken := map[string]bool{}
+	for _, c := range s.OnlineClientsGetByRoom(loc.ToArea, loc.ToRoom) {
+		taken[c.Player.Position] = true
+	}
+
+	if w, ok := s.Wilderness[loc.ToArea]; ok {
+		x, y, _ := w.Cell(loc.ToCubeID)
+		for direction := 0; direction < 4; direction++ {
+			nx, ny := area.Step(x, y, direction)
+			t, ok := w.TerrainAt(nx, ny)
+			if !ok || !w.Passable(nx, ny) || t.Water || taken[w.CellID(nx, ny)] {
+				continue
+			}
+			return area.Exit{ToArea: loc.ToArea, ToRoom: loc.ToRoom, ToCubeID: w.CellID(nx, ny)}, true
+		}
+		return area.Exit{}, false
+	}
+
+	for _, exit := range area.FindExits(roomsMap[loc.ToArea][loc.ToRoom], loc.ToArea, loc.ToRoom, loc.ToCubeID) {
+		if exit[1] != "0" && exit[3] == "cube" && !taken[exit[1]] {
+			return area.Exit{ToArea: loc.ToArea, ToRoom: loc.ToRoom, ToCubeID: exit[1]}, true
+		}
+	}
+	return area.Exit{}, false
+}
+
+// takeHelm makes the player the pilot of the vehicle.
+func (s *Server) takeHelm(p *area.Player) string {
+	v, ok := s.vehicleByArea(p.Area)
+	if !ok || p.Room != v.Deck {
+		return "There is no helm here\n"
+	}
+	if pilot, online := s.clientByNick(v.Pilot); online && pilot.Player.Area == v.Area && pilot.Player.Nickname != p.Nickname {
+		return fmt.Sprintf("%s is at the helm\n", v.Pilot)
+	}
+	v.Pilot = p.Nickname
+	return fmt.Sprintf("%s takes the helm of the %s\n", p.Nickname, v.Name)
+}
+
+// steer moves the vehicle the player pilots one step to the desired direction.
+// Usage: steer <e|w|n|s>
+func (s *Server) steer(roomsMap map[string]map[string][][]area.Cube, p *area.Player, args []string) string {
+	v, ok := s.vehicleByArea(p.Area)
+	if !ok || v.Pilot != p.Nickname || p.Room != v.Deck {
+		return "You are not at the helm\n"
+	}
+	direction := -1
+	if len(args) == 1 {
+		for i, name := range directionNames {
+			if strings.HasPrefix(name, strings.ToLower(args[0])) {
+				direction = i
+			}
+		}
+	}
+	if direction < 0 {
+		return "Usage: steer <east|west|north|south>\n"
+	}
+
+	to, ok := s.vehicleStep(roomsMap, v, direction)
+	if !ok {
+		return fmt.Sprintf("The %s can't go that way\n", v.Name)
+	}
+	from := v.Location
+	v.Location = to
+
+	moved := fmt.Sprintf("The %s moves %s\n", v.Name, directionNames[direction])
+	s.printToRoom(roomsMap, from.ToArea, from.ToRoom, moved)
+	if to.ToArea != from.ToArea || to.ToRoom != from.ToRoom {
+		s.printToRoom(roomsMap, to.ToArea, to.ToRoom, fmt.Sprintf("The %s arrives\n", v.Name))
+	}
+	for room := range s.Areas[v.Area].Rooms {
+		if room != v.Deck {
+			s.printToRoom(roomsMap, v.Area, room, moved)
+		}
+	}
+	return moved
+}
+
+// vehicleStep returns where the vehicle ends up moving to the given direction.
+func (s *Server) vehicleStep(roomsMap map[string]map[string][][]area.Cube, v *area.Vehicle, direction int) (area.Exit, bool) {
+	loc := v.Location
+	if w, ok := s.Wilderness[loc.ToArea]; ok {
+		x, y, _ := w.Cell(loc.ToCubeID)
+		nx, ny := area.Step(x, y, direction)
+		t, ok := w.TerrainAt(nx, ny)
+		if !ok || !v.CanCross(t) {
+			return area.Exit{}, false
+		}
+		return area.Exit{ToArea: loc.ToArea, ToRoom: loc.ToRoom, ToCubeID: w.CellID(nx, ny)}, true
+	}
+
+	exit := area.FindExits(roomsMap[loc.ToArea][loc.ToRoom], loc.ToArea, loc.ToRoom, loc.ToCubeID)[direction]
+	if exit[1] == "0" || exit[3] == "closed" {
+		return area.Exit{}, false
+	}
+	// Vehicles only roll through doors into other rooms, not into the wilderness.
+	if _, wild := s.Wilderness[exit[0]]; wild {
+		return area.Exit{}, false
+	}
+	return area.Exit{ToArea: exit[0], ToRoom: exit[2], ToCubeID: exit[1]}, true
+}
@@ -0,0 +1,110 @@
+package server
+
+import (
+	"math/rand"
+	"time"
+
+	"github.com/droslean/thyranew/area"
+	"github.com/droslean/thyranew/game"
+)
+
+// snowMoveDelay is how long it takes to take a step through the snow.
+const snowMoveDelay = time.Second
+
+var weatherMessages = map[string]string{
+	game.Clear: "The sky clears up\n",
+	game.Rain:  "It starts raining\n",
+	game.Storm: "A storm breaks out\n",
+	game.Snow:  "It starts snowing\n",
+}
+
+// climate returns the climate of the area or wilderness, if it has one.
+func (s *Server) climate(name string) string {
+	if w, ok := s.Wilderness[name]; ok {
+		return w.Climate
+	}
+	return s.Areas[name].Climate
+}
+
+// rollWeather rolls the weather of the area for the new hour on its shard,
+// and leaves the world to change it and tell the players outdoors.
+func (s *Server) rollWeather(roomsMap map[string]map[string][][]area.Cube, name string, rnd *rand.Rand) []func() {
+	climate := s.climate(name)
+	if climate == "" {
+		return nil
+	}
+	current := s.weather[name]
+	next := game.NextWeather(climate, current, rnd)
+	return []func(){func() {
+		s.weather[name] = next
+		if current == "" || next == current || !s.ambient() {
+			return
+		}
+		s.broadcast(roomsMap, Broadcast{Scope: ScopeArea, Area: name, Text: weatherMessages[next], Filter: s.outdoors})
+	}}
+}
+
+// outdoors reports whether the player is exposed to the weather.
+func (s *Server) outdoors(p *area.Player) bool {
+	if _, ok := s.Wilderness[p.Area]; ok {
+		return true
+	}
+	return s.Areas[p.Area].Rooms[p.Room].Outdoors
+}
+
+// weatherAt returns the weather the player is exposed to. Aboard a vehicle it
+// is the weather of wherever the vehicle is.
+func (s *Server) weatherAt(p *area.Player) string {
+	areaName := p.Area
+	if v, ok := s.vehicleByArea(areaName); ok {
+		areaName = v.Location.ToArea
+	}
+	if w, ok := s.weather[areaName]; ok && s.outdoors(p) {
+		return w
+	}
+	return game.Clear
+}
+
+// showWeather tells the player what the weather is like.
+func (s *Server) showWeather(p *area.Player) string {
+	if !s.outdoors(p) {
+		return "You are indoors\n"
+	}
+	switch s.weatherAt(p) {
+	case game.Rain:
+		return "It is raining\n"
+	case game.Storm:
+		return "A storm is raging\n"
+	case game.Snow:
+		return "It is snowing\n"
+	}
+	return "The sky is clear\n"
+}
+
+// inWater reports whether the player is in water that has to be swum across.
+func (s *Server) inWater(p *area.Player) bool {
+	if w, ok := s.Wilderness[p.Area]; ok {
+		x, y, _ := w.Cell(p.Position)
+		t, ok := w.TerrainAt(x, y)
+		return ok && t.Water
+	}
+	return s.Areas[p.Area].Rooms[p.Room].Water
+}
+
+// swim makes the player keep afloat in deep water, once per tick. Those who
+// fail start drowning until they make it out of the water.
+func (s *Server) swim(p *area.Player, rnd *rand.Rand) {
+	if !s.inWater(p) {
+		removeEffect(p, "Drowning")
+		return
+	}
+	if p.HP <= 0 || game.SwimCheck(&p.PC, rnd) {
+		return
+	}
+	addEffect(p, game.Effect{
+		Name:    "Drowning",
+		Damage:  2,
+		Ticks:   1,
+		Message: "You swallow water and struggle to keep afloat\n",
+	})
+}
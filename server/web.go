@@ -0,0 +1,140 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"html/template"
+	"net/http"
+	"path/filepath"
+	"strings"
+
+	log "gopkg.in/inconshreveable/log15.v2"
+)
+
+// Home is what the homepage of the server shows.
+type Home struct {
+	Online int            // Players in the world, linkdead or not
+	Events []HistoryEntry // What players did lately, in the order they did it
+}
+
+// startWeb serves the public web pages on the given address: the homepage
+// of the server and the profiles of the players, rendered from the
+// templates in the static directory, and the feeds of the news. Players with
+// their private flag on are kept off all of them.
+func (s *Server) startWeb(addr string) {
+	pattern := filepath.Join(s.staticDir, "web", "*.html")
+	pages, err := template.ParseGlob(pattern)
+	if err != nil {
+		log.Error(fmt.Sprintf("Cannot load the web templates %s: %v", pattern, err))
+		return
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		s.serveHome(w, r, pages)
+	})
+	mux.HandleFunc("/players/", func(w http.ResponseWriter, r *http.Request) {
+		s.servePublicProfile(w, r, pages)
+	})
+	mux.HandleFunc("/feed.rss", s.serveFeed)
+	mux.HandleFunc("/feed.atom", s.serveFeed)
+	mux.HandleFunc("/feed.json", s.serveFeed)
+	mux.HandleFunc("/link", s.serveLink)
+	mux.HandleFunc("/link/callback", s.serveLinkCallback)
+	mux.HandleFunc("/export/", s.serveExport)
+	go func() {
+		log.Info(fmt.Sprintf("Serving the web pages on %s", addr))
+		if err := http.ListenAndServe(addr, mux); err != nil {
+			log.Error(fmt.Sprintf("Web listener on %s stopped: %v", addr, err))
+		}
+	}()
+}
+
+// inWorld runs the task on the goroutine of the world and waits for it. It
+// reports false when the context is done first.
+func (s *Server) inWorld(ctx context.Context, task func()) bool {
+	done := make(chan struct{})
+	select {
+	case s.worldTasks <- func() {
+		task()
+		close(done)
+	}:
+	case <-ctx.Done():
+		return false
+	}
+	select {
+	case <-done:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// serveHome renders the homepage, with how many are online and the latest
+// history of the players who don't keep it private.
+func (s *Server) serveHome(w http.ResponseWriter, r *http.Request, pages *template.Template) {
+	entries, err := s.historyOf(r.Context(), "")
+	if err != nil {
+		http.Error(w, "The history cannot be read right now", http.StatusInternalServerError)
+		return
+	}
+	home := Home{Online: len(s.OnlineClients())}
+	public := map[string]bool{}
+	if !s.inWorld(r.Context(), func() {
+		for _, e := range entries {
+			if _, seen := public[e.Player]; !seen {
+				p, ok := s.playerOf(e.Player)
+				public[e.Player] = ok && !p.Flags.Private
+			}
+		}
+	}) {
+		return
+	}
+	for _, e := range entries {
+		if public[e.Player] {
+			home.Events = append(home.Events, e)
+		}
+	}
+	renderPage(w, pages, "home.html", home)
+}
+
+// servePublicProfile renders the profile of the player the path names.
+// Private players are as good as missing.
+func (s *Server) servePublicProfile(w http.ResponseWriter, r *http.Request, pages *template.Template) {
+	nick := strings.TrimPrefix(r.URL.Path, "/players/")
+	if nick == "" || strings.Contains(nick, "/") {
+		http.NotFound(w, r)
+		return
+	}
+	var profile Profile
+	found := false
+	if !s.inWorld(r.Context(), func() {
+		if p, ok := s.playerOf(nick); ok && !p.Flags.Private {
+			profile, found = profileFrom(p), true
+		}
+	}) {
+		return
+	}
+	if !found {
+		http.NotFound(w, r)
+		return
+	}
+	var err error
+	if profile.History, err = s.historyOf(r.Context(), profile.Nickname); err != nil {
+		http.Error(w, "The history cannot be read right now", http.StatusInternalServerError)
+		return
+	}
+	renderPage(w, pages, "profile.html", profile)
+}
+
+// renderPage writes the page made from the template.
+func renderPage(w http.ResponseWriter, pages *template.Template, name string, data interface{}) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := pages.ExecuteTemplate(w, name, data); err != nil {
+		log.Error(fmt.Sprintf("Cannot render %s: %v", name, err))
+	}
+}
@@ -0,0 +1,126 @@
+package server
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/droslean/thyranew/area"
+	"github.com/droslean/thyranew/game"
+)
+
+// unknownWeight is what items missing from the items file weigh, in pounds.
+const unknownWeight = 1
+
+// loadMoveDelay is how much longer each step takes under the load.
+var loadMoveDelay = map[string]time.Duration{
+	game.Burdened: 300 * time.Millisecond,
+	game.Strained: 700 * time.Millisecond,
+}
+
+// weigh returns what an item of the named prototype weighs, in pounds.
+func (s *Server) weigh(name string) int {
+	if it, ok := s.Items[strings.ToLower(name)]; ok {
+		return it.Weight
+	}
+	return unknownWeight
+}
+
+// capacity returns how many pounds the player can carry: what their strength
+// allows, and what the roomiest container they carry adds.
+func (s *Server) capacity(p *area.Player) int {
+	bag := 0
+	for _, name := range p.Inventory {
+		if it := s.itemDef(name); it.Kind == area.ItemContainer && it.Capacity > bag {
+			bag = it.Capacity
+		}
+	}
+	return game.Capacity(p.STR) + bag
+}
+
+// encumbrance returns how burdened the player is by what they carry.
+func (s *Server) encumbrance(p *area.Player) string {
+	return game.Encumbrance(p.Load(s.weigh), s.capacity(p))
+}
+
+// loadPenalty returns how much the load of the player takes off their attack
+// rolls and armor class.
+func (s *Server) loadPenalty(p *area.Player) int {
+	return game.EncumbrancePenalty(s.encumbrance(p))
+}
+
+// fits reports whether the player can carry the named items on top of what
+// they do. Every item players get goes through it, save for their own given
+// back to them.
+func (s *Server) fits(p *area.Player, names ...string) bool {
+	load := p.Load(s.weigh)
+	for _, name := range names {
+		load += s.weigh(name)
+	}
+	return load <= s.capacity(p)
+}
+
+// tooHeavy tells the player the item is more than they can carry.
+func tooHeavy(name string) string {
+	return fmt.Sprintf("The %s is more than you can carry\n", name)
+}
+
+// loadNote tells the player how burdened they are, when it changed from
+// before.
+func (s *Server) loadNote(p *area.Player, before string) string {
+	after := s.encumbrance(p)
+	switch {
+	case after == before:
+		return ""
+	case after == game.Unburdened:
+		return "You are no longer burdened\n"
+	case after == game.Overloaded:
+		return "You are overloaded, and can't move until you drop something\n"
+	}
+	return fmt.Sprintf("You are %s by your load\n", after)
+}
+
+// slowed returns why the player can't take the next step yet: too much to
+// carry, or a step that is slow to take. Otherwise it holds the player back
+// for as long as the next step takes.
+func (s *Server) slowed(p *area.Player) string {
+	load := s.encumbrance(p)
+	if load == game.Overloaded {
+		return "You carry too much to move\n"
+	}
+	delay, why := loadMoveDelay[load], "You stagger under your load\n"
+	if s.weatherAt(p) == game.Snow {
+		delay, why = delay+snowMoveDelay, "You trudge through the deep snow\n"
+	}
+	if delay == 0 {
+		return ""
+	}
+	now := s.now()
+	if now.Before(p.NextMove) {
+		return why
+	}
+	p.NextMove = now.Add(delay)
+	return ""
+}
+
+// drop leaves an item the player carries behind, for good. Plain items go
+// before those with something of their own, unless those are named.
+// Usage: drop <item>
+func (s *Server) drop(p *area.Player, args []string) string {
+	if len(args) == 0 {
+		return "Usage: drop <item>\n"
+	}
+	name := strings.Join(args, " ")
+	proto, ok := p.Prototype(name)
+	if !ok {
+		return fmt.Sprintf("You carry no %s\n", name)
+	}
+	before := s.encumbrance(p)
+	h := heldItem{Item: s.itemDef(proto)}
+	if e := p.Instance(name); e != nil && (e.Name != "" || p.Plain(proto) == 0) {
+		h.instance = *e
+		p.ChangeItem(name, func(e *area.ItemInstance) { *e = area.ItemInstance{Item: e.Item} })
+	}
+	p.RemoveItem(proto)
+	return fmt.Sprintf("You drop the %s and leave it behind\n", h.name()) + s.loadNote(p, before)
+}
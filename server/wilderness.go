@@ -0,0 +1,99 @@
+package server
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/droslean/thyranew/area"
+	"github.com/gothyra/toml"
+
+	log "gopkg.in/inconshreveable/log15.v2"
+)
+
+// wildernessRadius is how many cells around the player are drawn on the map.
+const wildernessRadius = 8
+
+// loadWilderness loads all the wilderness maps from the static directory into memory.
+func (s *Server) loadWilderness() error {
+	log.Info("Loading wilderness ...")
+	dir := s.staticDir + "/wilderness/"
+	wildWalker := func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		// Terrain maps are loaded along with the file describing them.
+		if info.IsDir() || filepath.Ext(path) != ".toml" {
+			return nil
+		}
+
+		fileContent, fileIoErr := ioutil.ReadFile(path)
+		if fileIoErr != nil {
+			log.Info(fmt.Sprintf("%s could not be loaded: %v", path, fileIoErr))
+			return fileIoErr
+		}
+
+		w := &area.Wilderness{}
+		if _, err := toml.Decode(string(fileContent), w); err != nil {
+			log.Info(fmt.Sprintf("%s could not be unmarshaled: %v", path, err))
+			return err
+		}
+
+		mapContent, mapIoErr := ioutil.ReadFile(filepath.Join(dir, w.MapFile))
+		if mapIoErr != nil {
+			log.Info(fmt.Sprintf("%s could not be loaded: %v", w.MapFile, mapIoErr))
+			return mapIoErr
+		}
+		w.SetMap(string(mapContent))
+
+		log.Info(fmt.Sprintf("Loaded wilderness %q", w.Name))
+		s.Wilderness[w.Name] = w
+
+		return nil
+	}
+
+	return filepath.Walk(dir, wildWalker)
+}
+
+// doWildMove moves the player across the wilderness to the desired direction.
+// Stepping on a link teleports the player out of the wilderness, to the area
+// it leads to.
+func (s *Server) doWildMove(c Client, online []Client, roomsMap map[string]map[string][][]area.Cube, direction int) string {
+	w := s.Wilderness[c.Player.Area]
+
+	x, y, ok := w.Cell(c.Player.Position)
+	if !ok {
+		return "You can't go that way\n"
+	}
+	nx, ny := area.Step(x, y, direction)
+	if !w.Passable(nx, ny) {
+		return "You can't go that way\n"
+	}
+
+	if link, ok := w.LinkAt(nx, ny); ok {
+		if !s.roomOpen(link.ToArea, link.ToRoom) {
+			return closedRoom(link.ToRoom)
+		}
+		if gate := s.levelGate(c.Player, link.ToArea); gate != "" {
+			return gate
+		}
+		newpos, _ := strconv.Atoi(link.ToCubeID)
+		if isAvailable, info := isCubeAvailable(c, online, link.ToArea, link.ToRoom, newpos); !isAvailable {
+			return info
+		}
+		if !s.teleport(roomsMap, c.Player, area.Exit{ToArea: link.ToArea, ToRoom: link.ToRoom, ToCubeID: link.ToCubeID}, "wilderness") {
+			return "You can't find the way out in the middle of a fight\n"
+		}
+		s.guardsNotice(roomsMap, c.Player)
+		return ""
+	}
+
+	newpos, _ := strconv.Atoi(w.CellID(nx, ny))
+	if isAvailable, info := isCubeAvailable(c, online, w.Name, area.WildernessRoom, newpos); !isAvailable {
+		return info
+	}
+	c.Player.Position = w.CellID(nx, ny)
+	return ""
+}
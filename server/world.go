@@ -0,0 +1,274 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"runtime/debug"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/droslean/thyranew/area"
+
+	log "gopkg.in/inconshreveable/log15.v2"
+)
+
+// World runs the game. Its subsystems, the tick loop, the dispatch of the
+// commands of the players and the tasks of the timers, all run on the one
+// goroutine of the world, so nothing else ever touches the state of the world.
+// The work of each area in a tick is the exception: it is shared out between
+// shards while the world waits for them. There is a single World for each
+// server, made along with it.
+type World struct {
+	s *Server
+
+	mu      sync.Mutex
+	stopCh  chan struct{}
+	done    chan struct{}
+	started time.Time
+
+	advances chan advance // Ticks asked for by a simulation
+	shards   *shards      // Owned by the goroutine of the world
+	// rands are the randomness of each area and wilderness on the shards,
+	// seeded from the world the first time they run. Owned by the goroutine
+	// of the world, and by the shard of the area while it runs.
+	rands map[string]*rand.Rand
+	// rooms are the rooms the world runs, for the tasks that come from
+	// outside and tell players. Owned by the goroutine of the world too.
+	rooms map[string]map[string][][]area.Cube
+
+	lastTick atomic.Value // time.Time of the last tick
+	ticks    uint64
+	events   uint64
+	tasks    uint64
+	panics   uint64
+	restarts uint64
+	tickLag  int64 // Nanoseconds the last tick waited for the world
+	tickTime int64 // Nanoseconds the last tick took
+	shed     int32 // 1 while the world sheds load
+	calm     int   // Ticks in a row the world kept up with while shedding
+	nshards  int32
+}
+
+// WorldHealth tells how the world is doing.
+type WorldHealth struct {
+	Running  bool
+	Uptime   time.Duration
+	LastTick time.Time
+	Ticks    uint64 // Ticks of the world since it started
+	Events   uint64 // Commands of the players dispatched
+	Tasks    uint64 // Tasks of the timers run
+	Queued   int    // Tasks waiting to run
+	Online   int
+	Panics   uint64 // Commands that panicked
+	Restarts uint64 // Times the world was started again after a panic
+	// TickLag is how long the last tick waited for the world to get to it,
+	// and TickTime how long it took.
+	TickLag  time.Duration
+	TickTime time.Duration
+	Shedding bool // The world falls behind and sheds load
+	Shards   int  // Goroutines the areas are shared out between
+}
+
+func newWorld(s *Server) *World {
+	return &World{s: s, advances: make(chan advance), rands: map[string]*rand.Rand{}}
+}
+
+// Start starts the world. A world runs once at a time.
+func (w *World) Start() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.stopCh != nil {
+		return errors.New("the world is already running")
+	}
+	w.stopCh = make(chan struct{})
+	w.done = make(chan struct{})
+	w.started = time.Now()
+	go w.run(w.stopCh, w.done)
+	log.Info("The world is running")
+	return nil
+}
+
+// Stop stops the world and waits for whatever it is doing to finish.
+func (w *World) Stop() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.stopCh == nil {
+		return
+	}
+	close(w.stopCh)
+	<-w.done
+	w.stopCh, w.done = nil, nil
+	log.Info("The world is stopped")
+}
+
+// Health reports the state of the world. It is safe to call from anywhere.
+func (w *World) Health() WorldHealth {
+	w.mu.Lock()
+	running, started := w.stopCh != nil, w.started
+	if running {
+		// A world that panicked too often is done without being stopped.
+		select {
+		case <-w.done:
+			running = false
+		default:
+		}
+	}
+	w.mu.Unlock()
+
+	h := WorldHealth{
+		Running:  running,
+		Ticks:    atomic.LoadUint64(&w.ticks),
+		Events:   atomic.LoadUint64(&w.events),
+		Tasks:    atomic.LoadUint64(&w.tasks),
+		Queued:   len(w.s.worldTasks),
+		Online:   len(w.s.OnlineClients()),
+		Panics:   atomic.LoadUint64(&w.panics),
+		Restarts: atomic.LoadUint64(&w.restarts),
+		TickLag:  time.Duration(atomic.LoadInt64(&w.tickLag)),
+		TickTime: time.Duration(atomic.LoadInt64(&w.tickTime)),
+		Shedding: w.shedding(),
+		Shards:   int(atomic.LoadInt32(&w.nshards)),
+	}
+	if running {
+		h.Uptime = time.Since(started)
+	}
+	if last, ok := w.lastTick.Load().(time.Time); ok {
+		h.LastTick = last
+	}
+	return h
+}
+
+// run is the goroutine of the world. The world starts again when it panics,
+// as long as worldPolicy allows.
+func (w *World) run(stopCh <-chan struct{}, done chan<- struct{}) {
+	defer close(done)
+	supervise("The world", worldPolicy, func() {
+		w.loop(stopCh)
+	}, func() {
+		atomic.AddUint64(&w.restarts, 1)
+	}, w.s.reportCrash)
+}
+
+// loop runs the world until it is stopped.
+func (w *World) loop(stopCh <-chan struct{}) {
+	s := w.s
+
+	roomsMap := make(map[string]map[string][][]area.Cube)
+	for _, a := range s.Areas {
+		s.buildAreaRooms(roomsMap, a.Name)
+	}
+	w.rooms = roomsMap
+
+	// A simulation has no ticker, and ticks when it is told to. It has no
+	// shards either, so it runs the same way wherever it runs.
+	var ticks <-chan time.Time
+	if s.sim == nil {
+		ticker := time.NewTicker(tickInterval)
+		defer ticker.Stop()
+		ticks = ticker.C
+
+		w.shards = newShards(s.config.Shards, s.reportCrash)
+		atomic.StoreInt32(&w.nshards, int32(w.shards.size()))
+		defer func() {
+			w.shards.stop()
+			w.shards = nil
+			atomic.StoreInt32(&w.nshards, 0)
+		}()
+	}
+
+	for {
+		select {
+		case <-stopCh:
+			log.Info("The world is exiting.")
+			return
+		case t := <-ticks:
+			lag := time.Since(t)
+			atomic.StoreInt64(&w.tickLag, int64(lag))
+			took := w.tick(roomsMap)
+			w.checkLoad(roomsMap, lag, took)
+		case a := <-w.advances:
+			for i := 0; i < a.ticks; i++ {
+				w.step(roomsMap)
+			}
+			close(a.done)
+		case task := <-s.worldTasks:
+			task()
+			atomic.AddUint64(&w.tasks, 1)
+		case ev := <-s.Events:
+			// The commands of a replay come from its journal alone.
+			if s.replay != nil {
+				continue
+			}
+			w.dispatch(roomsMap, ev)
+			atomic.AddUint64(&w.events, 1)
+		}
+	}
+}
+
+// tick moves the world on by itself, and returns how long it took.
+func (w *World) tick(roomsMap map[string]map[string][][]area.Cube) time.Duration {
+	w.s.journal.write(JournalEntry{Kind: JournalTick, Time: w.s.now()})
+	start := time.Now()
+	w.s.tick(roomsMap)
+	took := time.Since(start)
+	atomic.StoreInt64(&w.tickTime, int64(took))
+	atomic.AddUint64(&w.ticks, 1)
+	w.lastTick.Store(w.s.now())
+	return took
+}
+
+// step moves the clock of a simulation on by a tick. The tasks that come due
+// meanwhile run in the order they are due, and then the world ticks.
+func (w *World) step(roomsMap map[string]map[string][][]area.Cube) {
+	w.catchUp(w.s.now().Add(tickInterval))
+	w.tick(roomsMap)
+}
+
+// catchUp moves the clock of a simulation on to the time given, running the
+// tasks that come due meanwhile in the order they are due. The clock never
+// goes back.
+func (w *World) catchUp(until time.Time) {
+	if until.Before(w.s.now()) {
+		return
+	}
+	for {
+		task, ok := w.s.sim.next(until)
+		if !ok {
+			break
+		}
+		task()
+		atomic.AddUint64(&w.tasks, 1)
+	}
+}
+
+// dispatch runs the command of a player. A command that panics costs only the
+// player who gave it their connection, and the world goes on.
+func (w *World) dispatch(roomsMap map[string]map[string][][]area.Cube, ev Event) {
+	defer func() {
+		if r := recover(); r != nil {
+			atomic.AddUint64(&w.panics, 1)
+			stack := debug.Stack()
+			log.Crit(fmt.Sprintf("Command %q of %q panicked: %v\n%s", ev.EventType, ev.Client.Name, r, stack))
+			w.s.reportCrash(fmt.Sprintf("Command %q of %q", ev.EventType, ev.Client.Name), r, stack)
+			w.s.disconnect(ev.Client)
+		}
+	}()
+	w.s.journal.write(JournalEntry{Kind: JournalCommand, Time: w.s.now(), Player: ev.Client.Name, Command: ev.EventType, Walk: ev.walk, Key: ev.key})
+	w.s.dispatch(roomsMap, ev)
+}
+
+// health shows the state of the world to admins.
+func (s *Server) health() string {
+	h := s.world.Health()
+	if !h.Running {
+		return "The world is stopped\n"
+	}
+	lastTick := "never"
+	if !h.LastTick.IsZero() {
+		lastTick = fmt.Sprintf("%s ago", time.Since(h.LastTick).Round(time.Second))
+	}
+	return fmt.Sprintf("Up for %s, last tick %s\n%d ticks, %d commands, %d tasks, %d queued\n%d panics, %d restarts\n%d players online, %d shards\n",
+		h.Uptime.Round(time.Second), lastTick, h.Ticks, h.Events, h.Tasks, h.Queued, h.Panics, h.Restarts, h.Online, h.Shards)
+}
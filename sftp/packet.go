@@ -0,0 +1,212 @@
+package sftp
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// The types of the packets of version 3 of the protocol.
+const (
+	fxpInit     = 1
+	fxpVersion  = 2
+	fxpOpen     = 3
+	fxpClose    = 4
+	fxpRead     = 5
+	fxpWrite    = 6
+	fxpLstat    = 7
+	fxpFstat    = 8
+	fxpSetstat  = 9
+	fxpFsetstat = 10
+	fxpOpendir  = 11
+	fxpReaddir  = 12
+	fxpRemove   = 13
+	fxpRealpath = 16
+	fxpStat     = 17
+	fxpStatus   = 101
+	fxpHandle   = 102
+	fxpData     = 103
+	fxpName     = 104
+	fxpAttrs    = 105
+)
+
+// The codes of the status packets.
+const (
+	fxOK               = 0
+	fxEOF              = 1
+	fxNoSuchFile       = 2
+	fxPermissionDenied = 3
+	fxFailure          = 4
+	fxBadMessage       = 5
+	fxOpUnsupported    = 8
+)
+
+// The flags of the attributes.
+const (
+	attrSize        = 0x1
+	attrUIDGID      = 0x2
+	attrPermissions = 0x4
+	attrACModTime   = 0x8
+	attrExtended    = 0x80000000
+)
+
+// The flags of the open packets.
+const (
+	openRead   = 0x1
+	openWrite  = 0x2
+	openAppend = 0x4
+	openCreate = 0x8
+	openTrunc  = 0x10
+)
+
+// maxPacket is the size of the biggest packet taken, in bytes. Clients send
+// at most 32 kilobytes of data in each.
+const maxPacket = 256 << 10
+
+var errShort = errors.New("short packet")
+
+// readPacket reads the type and the payload of the next packet.
+func readPacket(r io.Reader) (byte, []byte, error) {
+	head := make([]byte, 4)
+	if _, err := io.ReadFull(r, head); err != nil {
+		return 0, nil, err
+	}
+	size := binary.BigEndian.Uint32(head)
+	if size == 0 || size > maxPacket {
+		return 0, nil, errors.New("packet of a wrong size")
+	}
+	b := make([]byte, size)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return 0, nil, err
+	}
+	return b[0], b[1:], nil
+}
+
+// reader reads the fields of a payload. The first error sticks, and the
+// fields after it read as zero.
+type reader struct {
+	b   []byte
+	err error
+}
+
+func (r *reader) uint32() uint32 {
+	if len(r.b) < 4 {
+		r.err = errShort
+		return 0
+	}
+	v := binary.BigEndian.Uint32(r.b)
+	r.b = r.b[4:]
+	return v
+}
+
+func (r *reader) uint64() uint64 {
+	if len(r.b) < 8 {
+		r.err = errShort
+		return 0
+	}
+	v := binary.BigEndian.Uint64(r.b)
+	r.b = r.b[8:]
+	return v
+}
+
+func (r *reader) bytes() []byte {
+	n := r.uint32()
+	if uint32(len(r.b)) < n {
+		r.err = errShort
+		return nil
+	}
+	v := r.b[:n]
+	r.b = r.b[n:]
+	return v
+}
+
+func (r *reader) string() string {
+	return string(r.bytes())
+}
+
+// attrs skips the attributes, which the server doesn't set.
+func (r *reader) attrs() {
+	flags := r.uint32()
+	if flags&attrSize != 0 {
+		r.uint64()
+	}
+	if flags&attrUIDGID != 0 {
+		r.uint32()
+		r.uint32()
+	}
+	if flags&attrPermissions != 0 {
+		r.uint32()
+	}
+	if flags&attrACModTime != 0 {
+		r.uint32()
+		r.uint32()
+	}
+	if flags&attrExtended != 0 {
+		for n := r.uint32(); n > 0 && r.err == nil; n-- {
+			r.string()
+			r.string()
+		}
+	}
+}
+
+// packet builds a packet to send.
+type packet []byte
+
+func newPacket(kind byte) *packet {
+	p := packet{0, 0, 0, 0, kind}
+	return &p
+}
+
+func (p *packet) uint32(v uint32) *packet {
+	*p = binary.BigEndian.AppendUint32(*p, v)
+	return p
+}
+
+func (p *packet) uint64(v uint64) *packet {
+	*p = binary.BigEndian.AppendUint64(*p, v)
+	return p
+}
+
+func (p *packet) bytes(b []byte) *packet {
+	p.uint32(uint32(len(b)))
+	*p = append(*p, b...)
+	return p
+}
+
+func (p *packet) string(s string) *packet {
+	return p.bytes([]byte(s))
+}
+
+// attrs writes the size, the permissions and the times of the file.
+func (p *packet) attrs(fi os.FileInfo) *packet {
+	mode := uint32(fi.Mode().Perm())
+	if fi.IsDir() {
+		mode |= 0040000
+	} else {
+		mode |= 0100000
+	}
+	mtime := uint32(fi.ModTime().Unix())
+	return p.uint32(attrSize | attrPermissions | attrACModTime).
+		uint64(uint64(fi.Size())).
+		uint32(mode).
+		uint32(mtime).
+		uint32(mtime)
+}
+
+// finish sets the length of the packet, and returns it.
+func (p *packet) finish() []byte {
+	binary.BigEndian.PutUint32(*p, uint32(len(*p)-4))
+	return *p
+}
+
+// longName is the line ls -l would show for the file, which clients show.
+func longName(fi os.FileInfo) string {
+	when := fi.ModTime().Format("Jan _2 15:04")
+	if time.Since(fi.ModTime()) > 180*24*time.Hour {
+		when = fi.ModTime().Format("Jan _2  2006")
+	}
+	return fmt.Sprintf("%s    1 thyra    thyra    %8d %s %s", fi.Mode(), fi.Size(), when, fi.Name())
+}
@@ -0,0 +1,377 @@
+// Package sftp serves files over version 3 of the SSH File Transfer Protocol,
+// which sftp speaks, and scp too from OpenSSH 9 on. It serves one directory,
+// with what of it shows decided by the caller, and hands the files written to
+// the caller whole once they are closed, so they can be checked before they
+// take the place of the old ones. Until then they are kept in memory, so a
+// client may have only so many files open, and so much written to them.
+//
+// Files can be read, listed and written. Removing, renaming and making
+// directories are not supported, nor are links, which are left out.
+package sftp
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const (
+	// maxFile is the size of the biggest file taken, in bytes.
+	maxFile = 4 << 20
+	// maxRead is the most read from a file in one packet, in bytes.
+	maxRead = 32 << 10
+	// dirChunk is how many files of a directory are listed in one packet.
+	dirChunk = 100
+	// maxHandles is how many files and directories a client may have open at
+	// once.
+	maxHandles = 64
+	// maxBuffered is the most a client may have written, all told, to the
+	// files it has open, in bytes. It is kept in memory until they are closed.
+	maxBuffered = 16 << 20
+)
+
+// Files is the directory served.
+type Files struct {
+	Root string
+	// Visible reports whether the file shows, by its path from the root with
+	// slashes, and whether it is a directory.
+	Visible func(name string, dir bool) bool
+	// Put takes what was written to the file, by its path from the root with
+	// slashes, when it is closed. The error is why it was refused, and the
+	// file stays as it was. Without Put nothing can be written.
+	Put func(name string, data []byte) error
+}
+
+// handle is a file or a directory a client opened.
+type handle struct {
+	name string
+	file *os.File // The file read
+	// write is whether the file is written, with what it holds so far in
+	// data.
+	write bool
+	data  []byte
+	isDir bool
+	dir   []os.FileInfo // What is left to list of the directory
+}
+
+// session is the files one client opened.
+type session struct {
+	files   *Files
+	w       io.Writer
+	handles map[string]*handle
+	next    int
+	// buffered is how many bytes the files open for writing hold.
+	buffered int
+}
+
+// Serve serves the files to the client until it goes away.
+func (f *Files) Serve(rw io.ReadWriter) error {
+	s := &session{files: f, w: rw, handles: make(map[string]*handle)}
+	defer func() {
+		for _, h := range s.handles {
+			if h.file != nil {
+				h.file.Close()
+			}
+		}
+	}()
+
+	kind, _, err := readPacket(rw)
+	if err != nil {
+		return err
+	}
+	if kind != fxpInit {
+		return fmt.Errorf("the client did not start with init but with %d", kind)
+	}
+	if err := s.send(newPacket(fxpVersion).uint32(3)); err != nil {
+		return err
+	}
+	for {
+		kind, payload, err := readPacket(rw)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := s.handle(kind, &reader{b: payload}); err != nil {
+			return err
+		}
+	}
+}
+
+func (s *session) send(p *packet) error {
+	_, err := s.w.Write(p.finish())
+	return err
+}
+
+func (s *session) status(id uint32, code uint32, msg string) error {
+	return s.send(newPacket(fxpStatus).uint32(id).uint32(code).string(msg).string(""))
+}
+
+// fail answers the request with the status the error stands for.
+func (s *session) fail(id uint32, err error) error {
+	switch {
+	case os.IsNotExist(err):
+		return s.status(id, fxNoSuchFile, "No such file")
+	case os.IsPermission(err):
+		return s.status(id, fxPermissionDenied, "Permission denied")
+	}
+	return s.status(id, fxFailure, err.Error())
+}
+
+// handle answers a request.
+func (s *session) handle(kind byte, r *reader) error {
+	id := r.uint32()
+	switch kind {
+	case fxpOpen:
+		name, flags := r.string(), r.uint32()
+		r.attrs()
+		if r.err != nil {
+			return s.status(id, fxBadMessage, r.err.Error())
+		}
+		return s.open(id, name, flags)
+	case fxpClose:
+		return s.close(id, r.string())
+	case fxpRead:
+		h, offset, size := s.handles[r.string()], r.uint64(), r.uint32()
+		if h == nil || h.file == nil {
+			return s.status(id, fxFailure, "Not a file open for reading")
+		}
+		if size > maxRead {
+			size = maxRead
+		}
+		b := make([]byte, size)
+		n, err := h.file.ReadAt(b, int64(offset))
+		if n == 0 && err == io.EOF {
+			return s.status(id, fxEOF, "End of file")
+		}
+		if n == 0 && err != nil {
+			return s.fail(id, err)
+		}
+		return s.send(newPacket(fxpData).uint32(id).bytes(b[:n]))
+	case fxpWrite:
+		h, offset, data := s.handles[r.string()], r.uint64(), r.bytes()
+		if h == nil || !h.write {
+			return s.status(id, fxFailure, "Not a file open for writing")
+		}
+		// The offset is checked before it is added to, so a huge one can't
+		// wrap around.
+		if offset > maxFile || uint64(len(data)) > maxFile-offset {
+			return s.status(id, fxFailure, fmt.Sprintf("Files are at most %d bytes", maxFile))
+		}
+		end := offset + uint64(len(data))
+		if end > uint64(len(h.data)) {
+			grow := int(end - uint64(len(h.data)))
+			if s.buffered+grow > maxBuffered {
+				return s.status(id, fxFailure, fmt.Sprintf("At most %d bytes can be written before the files are closed", maxBuffered))
+			}
+			h.data = append(h.data, make([]byte, grow)...)
+			s.buffered += grow
+		}
+		copy(h.data[offset:], data)
+		return s.status(id, fxOK, "")
+	case fxpStat, fxpLstat:
+		_, _, fi, err := s.files.resolve(r.string())
+		if err != nil {
+			return s.fail(id, err)
+		}
+		return s.send(newPacket(fxpAttrs).uint32(id).attrs(fi))
+	case fxpFstat:
+		h := s.handles[r.string()]
+		switch {
+		case h == nil:
+			return s.status(id, fxFailure, "No such handle")
+		case h.write:
+			return s.send(newPacket(fxpAttrs).uint32(id).uint32(attrSize).uint64(uint64(len(h.data))))
+		case h.file != nil:
+			fi, err := h.file.Stat()
+			if err != nil {
+				return s.fail(id, err)
+			}
+			return s.send(newPacket(fxpAttrs).uint32(id).attrs(fi))
+		}
+		return s.status(id, fxFailure, "Not a file")
+	case fxpSetstat, fxpFsetstat:
+		// The modes and the times of the files are the server's own.
+		return s.status(id, fxOK, "")
+	case fxpOpendir:
+		return s.opendir(id, r.string())
+	case fxpReaddir:
+		h := s.handles[r.string()]
+		if h == nil || !h.isDir {
+			return s.status(id, fxFailure, "Not a directory")
+		}
+		if len(h.dir) == 0 {
+			return s.status(id, fxEOF, "End of directory")
+		}
+		n := len(h.dir)
+		if n > dirChunk {
+			n = dirChunk
+		}
+		p := newPacket(fxpName).uint32(id).uint32(uint32(n))
+		for _, fi := range h.dir[:n] {
+			p.string(fi.Name()).string(longName(fi)).attrs(fi)
+		}
+		h.dir = h.dir[n:]
+		return s.send(p)
+	case fxpRealpath:
+		name := "/" + clean(r.string())
+		return s.send(newPacket(fxpName).uint32(id).uint32(1).string(name).string(name).uint32(0))
+	case fxpRemove:
+		return s.status(id, fxPermissionDenied, "Files are not removed here")
+	}
+	return s.status(id, fxOpUnsupported, "Not supported")
+}
+
+// open opens a file for reading, or for writing it whole.
+func (s *session) open(id uint32, name string, flags uint32) error {
+	if flags&(openWrite|openAppend) == 0 {
+		full, _, fi, err := s.files.resolve(name)
+		if err != nil {
+			return s.fail(id, err)
+		}
+		if fi.IsDir() {
+			return s.status(id, fxFailure, "Is a directory")
+		}
+		file, err := os.Open(full)
+		if err != nil {
+			return s.fail(id, err)
+		}
+		return s.newHandle(id, &handle{name: clean(name), file: file})
+	}
+
+	if s.files.Put == nil {
+		return s.status(id, fxPermissionDenied, "Files are read only here")
+	}
+	rel := clean(name)
+	parent := path.Dir("/" + rel)
+	if _, _, fi, err := s.files.resolve(parent); err != nil || !fi.IsDir() {
+		return s.status(id, fxNoSuchFile, "No such directory")
+	}
+	h := &handle{name: rel, write: true}
+	full, _, fi, err := s.files.resolve(name)
+	switch {
+	case err == nil && fi.IsDir():
+		return s.status(id, fxFailure, "Is a directory")
+	case err == nil && flags&openTrunc == 0:
+		// What isn't written over stays.
+		if h.data, err = ioutil.ReadFile(full); err != nil {
+			return s.fail(id, err)
+		}
+	case err != nil && !os.IsNotExist(err):
+		return s.fail(id, err)
+	case err != nil && (flags&openCreate == 0 || !s.files.visible(rel, false)):
+		return s.status(id, fxNoSuchFile, "No such file")
+	}
+	return s.newHandle(id, h)
+}
+
+// opendir opens a directory to list what shows of it.
+func (s *session) opendir(id uint32, name string) error {
+	full, rel, fi, err := s.files.resolve(name)
+	if err != nil {
+		return s.fail(id, err)
+	}
+	if !fi.IsDir() {
+		return s.status(id, fxFailure, "Not a directory")
+	}
+	all, err := ioutil.ReadDir(full)
+	if err != nil {
+		return s.fail(id, err)
+	}
+	h := &handle{name: rel, isDir: true}
+	for _, fi := range all {
+		if fi.Mode()&os.ModeSymlink == 0 && s.files.Visible(path.Join(rel, fi.Name()), fi.IsDir()) {
+			h.dir = append(h.dir, fi)
+		}
+	}
+	return s.newHandle(id, h)
+}
+
+// close closes the handle, and puts what was written in the file.
+func (s *session) close(id uint32, key string) error {
+	h, ok := s.handles[key]
+	if !ok {
+		return s.status(id, fxFailure, "No such handle")
+	}
+	delete(s.handles, key)
+	if h.file != nil {
+		h.file.Close()
+	}
+	s.buffered -= len(h.data)
+	if h.write {
+		if err := s.files.Put(h.name, h.data); err != nil {
+			return s.status(id, fxFailure, err.Error())
+		}
+	}
+	return s.status(id, fxOK, "")
+}
+
+// newHandle hands the client a handle to what it opened, unless it has too
+// many open or they hold too much.
+func (s *session) newHandle(id uint32, h *handle) error {
+	if len(s.handles) >= maxHandles {
+		if h.file != nil {
+			h.file.Close()
+		}
+		return s.status(id, fxFailure, fmt.Sprintf("At most %d files can be open at once", maxHandles))
+	}
+	if s.buffered+len(h.data) > maxBuffered {
+		return s.status(id, fxFailure, fmt.Sprintf("At most %d bytes can be written before the files are closed", maxBuffered))
+	}
+	s.buffered += len(h.data)
+	s.next++
+	key := strconv.Itoa(s.next)
+	s.handles[key] = h
+	return s.send(newPacket(fxpHandle).uint32(id).string(key))
+}
+
+// clean returns the path of the file from the root, with slashes. Paths
+// can't lead out of the root.
+func clean(name string) string {
+	return path.Clean("/" + name)[1:]
+}
+
+// resolve returns where the file is on disk, its path from the root and what
+// it is. Files that don't show don't exist, and neither do those with a link
+// anywhere on the way to them, so no link leads out of the root.
+func (f *Files) resolve(name string) (string, string, os.FileInfo, error) {
+	rel := clean(name)
+	full := filepath.Join(f.Root, filepath.FromSlash(rel))
+	fi, err := os.Lstat(f.Root)
+	if err != nil {
+		return "", "", nil, err
+	}
+	if rel != "" {
+		at := f.Root
+		for _, part := range strings.Split(rel, "/") {
+			at = filepath.Join(at, part)
+			if fi, err = os.Lstat(at); err != nil {
+				return "", "", nil, err
+			}
+			if fi.Mode()&os.ModeSymlink != 0 {
+				return "", "", nil, os.ErrNotExist
+			}
+		}
+		if !f.visible(rel, fi.IsDir()) {
+			return "", "", nil, os.ErrNotExist
+		}
+	}
+	return full, rel, fi, nil
+}
+
+// visible reports whether the file shows, along with the directories it is
+// in.
+func (f *Files) visible(rel string, dir bool) bool {
+	for p := path.Dir(rel); p != "."; p = path.Dir(p) {
+		if !f.Visible(p, true) {
+			return false
+		}
+	}
+	return f.Visible(rel, dir)
+}
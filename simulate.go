@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/droslean/thyranew/server"
+)
+
+// step is a line of a scenario: a player typing a command, a player
+// expecting to see something, or the world moving on.
+type step struct {
+	line  int
+	nick  string
+	text  string // What is typed, or expected
+	check bool   // The text is expected rather than typed
+	ticks int
+}
+
+// simulate plays a scenario against a simulated world, which only moves on
+// when told to and rolls the same dice for the same seed, so the scenario
+// plays out the same every time. It reads the scenario from the file, or
+// the standard input, a step a line:
+//
+//	# Comments and blank lines are skipped
+//	Alice: look           Alice types look, connecting first if not in yet
+//	expect Alice: Inn     Alice has to see Inn
+//	tick 10               The world moves on by ten ticks
+//
+// It returns 1 if a player did not see what was expected, and 2 if the
+// scenario did not read or the world did not start.
+func simulate(args []string) int {
+	fs := newFlags("simulate", "[scenario]")
+	static := staticFlag(fs)
+	seed := fs.Int64("seed", 1, "Seed all the randomness of the world comes from")
+	timeout := fs.Duration("timeout", 5*time.Second, "How long players wait to see what they expect")
+	width := fs.Int("width", 160, "Width of the terminals of the players")
+	height := fs.Int("height", 40, "Height of the terminals of the players")
+	verbose := fs.Bool("v", false, "Write the steps as they are played")
+	fs.Parse(args)
+	quiet()
+
+	var in io.Reader = os.Stdin
+	name := "the standard input"
+	if fs.NArg() > 0 {
+		f, err := os.Open(fs.Arg(0))
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 2
+		}
+		defer f.Close()
+		in, name = f, fs.Arg(0)
+	}
+	steps, err := readScenario(in)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", name, err)
+		return 2
+	}
+
+	// The harness reads the world where the server does.
+	os.Setenv("THYRA_STATIC", staticDir(*static))
+	h, err := server.NewSimulationHarness(server.Simulation{Seed: *seed})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "The world did not start: %v\n", err)
+		return 2
+	}
+	defer h.Close()
+
+	players := map[string]*server.FakePlayer{}
+	ticks := 0
+	for _, st := range steps {
+		if *verbose {
+			fmt.Printf("%d: %s\n", st.line, st)
+		}
+		if st.nick == "" {
+			h.Advance(st.ticks)
+			ticks += st.ticks
+			continue
+		}
+		p, ok := players[st.nick]
+		if !ok {
+			if p, err = h.Connect(st.nick, *width, *height); err != nil {
+				fmt.Fprintf(os.Stderr, "%s:%d: %v\n", name, st.line, err)
+				return 1
+			}
+			players[st.nick] = p
+		}
+		if st.check {
+			err = p.Expect(st.text, *timeout)
+		} else {
+			err = p.Send(st.text)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s:%d: %v\n", name, st.line, err)
+			return 1
+		}
+	}
+	fmt.Printf("Played %d steps over %d ticks\n", len(steps), ticks)
+	return 0
+}
+
+func (st step) String() string {
+	switch {
+	case st.nick == "":
+		return fmt.Sprintf("tick %d", st.ticks)
+	case st.check:
+		return fmt.Sprintf("expect %s: %s", st.nick, st.text)
+	}
+	return fmt.Sprintf("%s: %s", st.nick, st.text)
+}
+
+// readScenario reads the steps of a scenario.
+func readScenario(r io.Reader) ([]step, error) {
+	steps := []step{}
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		st := step{line: n}
+		fields := strings.Fields(line)
+		switch {
+		case fields[0] == "tick":
+			st.ticks = 1
+			if len(fields) > 2 {
+				return nil, fmt.Errorf("line %d: tick takes how many ticks, and nothing else", n)
+			}
+			if len(fields) == 2 {
+				t, err := strconv.Atoi(fields[1])
+				if err != nil || t < 1 {
+					return nil, fmt.Errorf("line %d: %q is not a number of ticks", n, fields[1])
+				}
+				st.ticks = t
+			}
+		default:
+			if fields[0] == "expect" {
+				st.check = true
+				line = strings.TrimSpace(strings.TrimPrefix(line, "expect"))
+			}
+			i := strings.Index(line, ":")
+			if i < 0 {
+				return nil, fmt.Errorf("line %d: no player before a colon", n)
+			}
+			st.nick, st.text = strings.TrimSpace(line[:i]), strings.TrimSpace(line[i+1:])
+			if !server.IsValidUsername(st.nick) {
+				return nil, fmt.Errorf("line %d: %q is not a valid name", n, st.nick)
+			}
+			if st.check && st.text == "" {
+				return nil, fmt.Errorf("line %d: nothing to expect", n)
+			}
+		}
+		steps = append(steps, st)
+	}
+	return steps, scanner.Err()
+}
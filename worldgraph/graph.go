@@ -0,0 +1,155 @@
+// Package worldgraph turns the areas of the world into a graph of rooms, so
+// builders can draw it with tools like Graphviz or Gephi and find the rooms
+// nobody can get to.
+//
+// Every room is a node, named after its area and itself, and every exit an
+// edge. A wilderness is a single node, since walking across it takes no exits.
+package worldgraph
+
+import (
+	"sort"
+
+	"github.com/droslean/thyranew/area"
+)
+
+// Kinds of the edges. Doors and portals are the types of the cubes they leave
+// from; links lead out of a wilderness, and vehicles carry players aboard and
+// back ashore.
+const (
+	KindDoor    = "door"
+	KindPortal  = "portal"
+	KindLink    = "link"
+	KindVehicle = "vehicle"
+)
+
+// Node is a room, or a whole wilderness.
+type Node struct {
+	ID         string   `json:"id"`
+	Area       string   `json:"area"`
+	Room       string   `json:"room"`
+	Wilderness bool     `json:"wilderness,omitempty"`
+	Dark       bool     `json:"dark,omitempty"`
+	Outdoors   bool     `json:"outdoors,omitempty"`
+	Water      bool     `json:"water,omitempty"`
+	NPCs       []string `json:"npcs,omitempty"` // Names of the NPCs spawned in the room
+}
+
+// Edge is a way from a room to another. Rooms with many doors to the same
+// room get a single edge, closed only if all of them are.
+type Edge struct {
+	From   string `json:"from"`
+	To     string `json:"to"`
+	Kind   string `json:"kind"`
+	Closed bool   `json:"closed,omitempty"`
+}
+
+// Graph holds the rooms of the world and the ways between them.
+type Graph struct {
+	Nodes []Node `json:"nodes"`
+	Edges []Edge `json:"edges"`
+	// Entries are rooms players get to by command rather than by exit, like
+	// the entries of dungeons.
+	Entries []string `json:"entries,omitempty"`
+}
+
+// ID returns the ID of the node of the given room.
+func ID(areaName, room string) string {
+	return areaName + "/" + room
+}
+
+// Build makes the graph of the given areas, wildernesses and vehicles. With
+// npcs set, nodes list the NPCs spawned in them.
+func Build(areas map[string]area.Area, wild map[string]*area.Wilderness, vehicles map[string]*area.Vehicle, npcs bool) *Graph {
+	g := &Graph{}
+	edges := map[[3]string]*Edge{}
+	addEdge := func(from, to, kind string, closed bool) {
+		key := [3]string{from, to, kind}
+		if e, ok := edges[key]; ok {
+			e.Closed = e.Closed && closed
+			return
+		}
+		edges[key] = &Edge{From: from, To: to, Kind: kind, Closed: closed}
+	}
+
+	for _, a := range areas {
+		for _, room := range a.Rooms {
+			node := Node{
+				ID:       ID(a.Name, room.Name),
+				Area:     a.Name,
+				Room:     room.Name,
+				Dark:     room.Dark,
+				Outdoors: room.Outdoors,
+				Water:    room.Water,
+			}
+			if npcs {
+				for _, npc := range a.NPCs {
+					if npc.Room == room.Name {
+						node.NPCs = append(node.NPCs, npc.Name)
+					}
+				}
+			}
+			g.Nodes = append(g.Nodes, node)
+
+			for _, cube := range room.Cubes {
+				if len(cube.Exits) == 0 {
+					continue
+				}
+				kind := KindDoor
+				if cube.Type == KindPortal {
+					kind = KindPortal
+				}
+				to := cube.Exits[0]
+				addEdge(node.ID, ID(to.ToArea, to.ToRoom), kind, cube.Closed)
+			}
+		}
+		if a.Generator.Style != "" && a.Entry.ToArea != "" {
+			g.Entries = append(g.Entries, ID(a.Entry.ToArea, a.Entry.ToRoom))
+		}
+	}
+
+	for _, w := range wild {
+		node := Node{ID: ID(w.Name, area.WildernessRoom), Area: w.Name, Room: area.WildernessRoom, Wilderness: true, Outdoors: true}
+		g.Nodes = append(g.Nodes, node)
+		for _, l := range w.Links {
+			addEdge(node.ID, ID(l.ToArea, l.ToRoom), KindLink, false)
+		}
+	}
+
+	for _, v := range vehicles {
+		deck := ID(v.Area, v.Deck)
+		ashore := ID(v.Location.ToArea, v.Location.ToRoom)
+		addEdge(ashore, deck, KindVehicle, false)
+		addEdge(deck, ashore, KindVehicle, false)
+	}
+
+	for _, e := range edges {
+		g.Edges = append(g.Edges, *e)
+	}
+	sort.Slice(g.Nodes, func(i, j int) bool { return g.Nodes[i].ID < g.Nodes[j].ID })
+	sort.Slice(g.Edges, func(i, j int) bool {
+		a, b := g.Edges[i], g.Edges[j]
+		if a.From != b.From {
+			return a.From < b.From
+		}
+		if a.To != b.To {
+			return a.To < b.To
+		}
+		return a.Kind < b.Kind
+	})
+	sort.Strings(g.Entries)
+	return g
+}
+
+// Areas returns the names of the areas in the graph, sorted.
+func (g *Graph) Areas() []string {
+	seen := map[string]bool{}
+	names := []string{}
+	for _, n := range g.Nodes {
+		if !seen[n.Area] {
+			seen[n.Area] = true
+			names = append(names, n.Area)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,92 @@
+package worldgraph
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/droslean/thyranew/area"
+	"github.com/gothyra/toml"
+)
+
+// Load builds the graph of the areas, wildernesses and vehicles of the given
+// static directory, the way the server loads them. Generated areas are built
+// with their own seed, or with the seed 1 if they have none, so the rooms of
+// the graph come out the same every time.
+func Load(staticDir string, npcs bool) (*Graph, error) {
+	areas := map[string]area.Area{}
+	err := walkTOML(filepath.Join(staticDir, "areas"), func(content []byte) error {
+		a := area.Area{}
+		if _, err := toml.Decode(string(content), &a); err != nil {
+			return err
+		}
+		if a.Generator.Style != "" {
+			seed := a.Generator.Seed
+			if seed == 0 {
+				seed = 1
+			}
+			if err := area.Generate(&a, seed); err != nil {
+				return err
+			}
+		}
+		areas[a.Name] = a
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Only the links of the wildernesses are needed, not their maps.
+	wild := map[string]*area.Wilderness{}
+	err = walkTOML(filepath.Join(staticDir, "wilderness"), func(content []byte) error {
+		w := &area.Wilderness{}
+		if _, err := toml.Decode(string(content), w); err != nil {
+			return err
+		}
+		wild[w.Name] = w
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	vehicles := map[string]*area.Vehicle{}
+	err = walkTOML(filepath.Join(staticDir, "vehicles"), func(content []byte) error {
+		v := &area.Vehicle{}
+		if _, err := toml.Decode(string(content), v); err != nil {
+			return err
+		}
+		vehicles[v.Name] = v
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return Build(areas, wild, vehicles, npcs), nil
+}
+
+// walkTOML calls load with the content of every TOML file in the directory.
+// Directories that do not exist hold nothing.
+func walkTOML(dir string, load func([]byte) error) error {
+	if _, err := os.Stat(dir); os.IsNotExist(err) {
+		return nil
+	}
+	return filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() || filepath.Ext(path) != ".toml" {
+			return nil
+		}
+		content, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		if err := load(content); err != nil {
+			return fmt.Errorf("%s: %v", path, err)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,98 @@
+package worldgraph
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// Report lists what is wrong with the connections of the world.
+type Report struct {
+	// Dangling are the edges leading to rooms that do not exist.
+	Dangling []Edge
+	// OneWay are the edges with no way back. Vehicles are left out, since
+	// they always carry players back where they came from, and so are the
+	// exits of entries, which players come to by command.
+	OneWay []Edge
+	// Unreachable are the rooms that cannot be walked to from the starts or
+	// the entries of the graph.
+	Unreachable []string
+}
+
+// OK reports whether nothing was found.
+func (r Report) OK() bool {
+	return len(r.Dangling) == 0 && len(r.OneWay) == 0 && len(r.Unreachable) == 0
+}
+
+// Validate checks the edges of the graph and walks it from the given starts,
+// which are IDs of rooms, and from the entries of the graph.
+func (g *Graph) Validate(starts ...string) Report {
+	r := Report{}
+	nodes := map[string]bool{}
+	for _, n := range g.Nodes {
+		nodes[n.ID] = true
+	}
+	entries := map[string]bool{}
+	for _, id := range g.Entries {
+		entries[id] = true
+	}
+	back := map[[2]string]bool{}
+	next := map[string][]string{}
+	for _, e := range g.Edges {
+		back[[2]string{e.From, e.To}] = true
+		next[e.From] = append(next[e.From], e.To)
+	}
+
+	for _, e := range g.Edges {
+		switch {
+		case !nodes[e.To]:
+			r.Dangling = append(r.Dangling, e)
+		case e.Kind != KindVehicle && !entries[e.From] && e.From != e.To && !back[[2]string{e.To, e.From}]:
+			r.OneWay = append(r.OneWay, e)
+		}
+	}
+
+	// Closed doors can be opened, so they count as ways through.
+	reached := map[string]bool{}
+	queue := append(append([]string{}, starts...), g.Entries...)
+	for len(queue) > 0 {
+		id := queue[0]
+		queue = queue[1:]
+		if reached[id] || !nodes[id] {
+			continue
+		}
+		reached[id] = true
+		queue = append(queue, next[id]...)
+	}
+	for _, n := range g.Nodes {
+		if !reached[n.ID] {
+			r.Unreachable = append(r.Unreachable, n.ID)
+		}
+	}
+	return r
+}
+
+func (r Report) String() string {
+	if r.OK() {
+		return "All rooms are connected.\n"
+	}
+	var b bytes.Buffer
+	if len(r.Dangling) > 0 {
+		fmt.Fprintf(&b, "Exits to rooms that do not exist (%d):\n", len(r.Dangling))
+		for _, e := range r.Dangling {
+			fmt.Fprintf(&b, "  %s -> %s (%s)\n", e.From, e.To, e.Kind)
+		}
+	}
+	if len(r.OneWay) > 0 {
+		fmt.Fprintf(&b, "One-way exits (%d):\n", len(r.OneWay))
+		for _, e := range r.OneWay {
+			fmt.Fprintf(&b, "  %s -> %s (%s)\n", e.From, e.To, e.Kind)
+		}
+	}
+	if len(r.Unreachable) > 0 {
+		fmt.Fprintf(&b, "Unreachable rooms (%d):\n", len(r.Unreachable))
+		for _, id := range r.Unreachable {
+			fmt.Fprintf(&b, "  %s\n", id)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,171 @@
+package worldgraph
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// WriteDOT writes the graph for Graphviz. The rooms of every area are drawn
+// together, portals are dashed and closed doors dotted.
+func (g *Graph) WriteDOT(w io.Writer) error {
+	ew := &errWriter{w: w}
+	ew.printf("digraph world {\n")
+	ew.printf("\tnode [shape=box];\n")
+	for i, name := range g.Areas() {
+		ew.printf("\tsubgraph cluster_%d {\n", i)
+		ew.printf("\t\tlabel=%s;\n", strconv.Quote(name))
+		for _, n := range g.Nodes {
+			if n.Area != name {
+				continue
+			}
+			label := n.Room
+			if len(n.NPCs) > 0 {
+				label += "\n" + strings.Join(n.NPCs, ", ")
+			}
+			attrs := []string{"label=" + strconv.Quote(label)}
+			if n.Wilderness {
+				attrs = append(attrs, "shape=ellipse")
+			}
+			if n.Dark {
+				attrs = append(attrs, "style=filled", "fillcolor=gray")
+			} else if n.Water {
+				attrs = append(attrs, "style=filled", "fillcolor=lightblue")
+			}
+			ew.printf("\t\t%s [%s];\n", strconv.Quote(n.ID), strings.Join(attrs, ", "))
+		}
+		ew.printf("\t}\n")
+	}
+	for _, e := range g.Edges {
+		attrs := []string{}
+		switch {
+		case e.Kind == KindPortal:
+			attrs = append(attrs, "style=dashed")
+		case e.Kind == KindVehicle:
+			attrs = append(attrs, "style=bold")
+		case e.Closed:
+			attrs = append(attrs, "style=dotted")
+		}
+		ew.printf("\t%s -> %s", strconv.Quote(e.From), strconv.Quote(e.To))
+		if len(attrs) > 0 {
+			ew.printf(" [%s]", strings.Join(attrs, ", "))
+		}
+		ew.printf(";\n")
+	}
+	ew.printf("}\n")
+	return ew.err
+}
+
+// errWriter keeps the first error of a run of writes.
+type errWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (ew *errWriter) printf(format string, args ...interface{}) {
+	if ew.err == nil {
+		_, ew.err = fmt.Fprintf(ew.w, format, args...)
+	}
+}
+
+// WriteJSON writes the graph as JSON.
+func (g *Graph) WriteJSON(w io.Writer) error {
+	b, err := json.MarshalIndent(g, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(b, '\n'))
+	return err
+}
+
+type graphML struct {
+	XMLName xml.Name     `xml:"graphml"`
+	XMLNS   string       `xml:"xmlns,attr"`
+	Keys    []graphMLKey `xml:"key"`
+	Graph   struct {
+		EdgeDefault string        `xml:"edgedefault,attr"`
+		Nodes       []graphMLNode `xml:"node"`
+		Edges       []graphMLEdge `xml:"edge"`
+	} `xml:"graph"`
+}
+
+type graphMLKey struct {
+	ID   string `xml:"id,attr"`
+	For  string `xml:"for,attr"`
+	Name string `xml:"attr.name,attr"`
+	Type string `xml:"attr.type,attr"`
+}
+
+type graphMLData struct {
+	Key   string `xml:"key,attr"`
+	Value string `xml:",chardata"`
+}
+
+type graphMLNode struct {
+	ID   string        `xml:"id,attr"`
+	Data []graphMLData `xml:"data"`
+}
+
+type graphMLEdge struct {
+	Source string        `xml:"source,attr"`
+	Target string        `xml:"target,attr"`
+	Data   []graphMLData `xml:"data"`
+}
+
+// WriteGraphML writes the graph as GraphML, which Gephi, yEd and Cytoscape
+// read.
+func (g *Graph) WriteGraphML(w io.Writer) error {
+	doc := graphML{
+		XMLNS: "http://graphml.graphdrawing.org/xmlns",
+		Keys: []graphMLKey{
+			{ID: "area", For: "node", Name: "area", Type: "string"},
+			{ID: "room", For: "node", Name: "room", Type: "string"},
+			{ID: "wilderness", For: "node", Name: "wilderness", Type: "boolean"},
+			{ID: "dark", For: "node", Name: "dark", Type: "boolean"},
+			{ID: "outdoors", For: "node", Name: "outdoors", Type: "boolean"},
+			{ID: "water", For: "node", Name: "water", Type: "boolean"},
+			{ID: "npcs", For: "node", Name: "npcs", Type: "string"},
+			{ID: "kind", For: "edge", Name: "kind", Type: "string"},
+			{ID: "closed", For: "edge", Name: "closed", Type: "boolean"},
+		},
+	}
+	doc.Graph.EdgeDefault = "directed"
+	for _, n := range g.Nodes {
+		doc.Graph.Nodes = append(doc.Graph.Nodes, graphMLNode{
+			ID: n.ID,
+			Data: []graphMLData{
+				{Key: "area", Value: n.Area},
+				{Key: "room", Value: n.Room},
+				{Key: "wilderness", Value: strconv.FormatBool(n.Wilderness)},
+				{Key: "dark", Value: strconv.FormatBool(n.Dark)},
+				{Key: "outdoors", Value: strconv.FormatBool(n.Outdoors)},
+				{Key: "water", Value: strconv.FormatBool(n.Water)},
+				{Key: "npcs", Value: strings.Join(n.NPCs, ", ")},
+			},
+		})
+	}
+	for _, e := range g.Edges {
+		doc.Graph.Edges = append(doc.Graph.Edges, graphMLEdge{
+			Source: e.From,
+			Target: e.To,
+			Data: []graphMLData{
+				{Key: "kind", Value: e.Kind},
+				{Key: "closed", Value: strconv.FormatBool(e.Closed)},
+			},
+		})
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(doc); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
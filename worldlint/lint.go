@@ -0,0 +1,349 @@
+// Package worldlint checks the data of the world for what breaks in the game
+// without failing to load: exits to rooms that do not exist and rooms nobody
+// can get to, NPCs dropping from loot tables that are gone, quests of NPCs
+// that are gone, and items that can't be used the way they are made.
+//
+// Problems are errors, which break something, or warnings, which may be
+// meant. Reports are written for people or as JSON, for build pipelines.
+package worldlint
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/droslean/thyranew/area"
+	"github.com/droslean/thyranew/game"
+	"github.com/droslean/thyranew/worldgraph"
+)
+
+// Severities of the problems.
+const (
+	Error   = "error"
+	Warning = "warning"
+)
+
+// Checks the problems are found by.
+const (
+	CheckDanglingExit     = "dangling-exit"
+	CheckOneWayExit       = "one-way-exit"
+	CheckUnreachableRoom  = "unreachable-room"
+	CheckMissingRoom      = "missing-room"
+	CheckMissingLootTable = "missing-loot-table"
+	CheckMissingItem      = "missing-item"
+	CheckMissingNPC       = "missing-npc"
+	CheckMissingNode      = "missing-node"
+	CheckUnknownQuest     = "unknown-quest"
+	CheckDuplicateItem    = "duplicate-item"
+	CheckInvalidSlot      = "invalid-slot"
+)
+
+// Problem is something wrong with the world.
+type Problem struct {
+	Severity string `json:"severity"`
+	Check    string `json:"check"`
+	Where    string `json:"where"` // The room, NPC, item, table or dialogue it is in
+	Message  string `json:"message"`
+}
+
+// World is the data of the world that is checked.
+type World struct {
+	Areas      map[string]area.Area
+	Wilderness map[string]*area.Wilderness
+	Vehicles   map[string]*area.Vehicle
+	// Items are the prototypes of the items file, in its order, so names
+	// given twice show.
+	Items        []area.Item
+	LootTables   map[string]game.LootTable
+	Dialogues    map[string]area.Dialogue // By the name of their NPC
+	Achievements []area.Achievement
+	// Starts are the rooms players get to without exits, like where they
+	// start and the jail, as area/room.
+	Starts []string
+}
+
+// Report is what was found wrong with the world.
+type Report struct {
+	Problems []Problem `json:"problems"`
+}
+
+// Errors returns how many of the problems are errors.
+func (r Report) Errors() int {
+	n := 0
+	for _, p := range r.Problems {
+		if p.Severity == Error {
+			n++
+		}
+	}
+	return n
+}
+
+// OK reports whether no errors were found. Warnings alone are fine.
+func (r Report) OK() bool {
+	return r.Errors() == 0
+}
+
+// Lint checks the world.
+func (w *World) Lint() Report {
+	l := &linter{w: w}
+	l.rooms()
+	l.npcs()
+	l.loot()
+	l.quests()
+	l.items()
+	sort.SliceStable(l.problems, func(i, j int) bool {
+		a, b := l.problems[i], l.problems[j]
+		if a.Severity != b.Severity {
+			return a.Severity == Error
+		}
+		if a.Check != b.Check {
+			return a.Check < b.Check
+		}
+		return a.Where < b.Where
+	})
+	return Report{Problems: l.problems}
+}
+
+// linter gathers the problems of a world.
+type linter struct {
+	w        *World
+	problems []Problem
+}
+
+func (l *linter) add(severity, check, where, format string, args ...interface{}) {
+	l.problems = append(l.problems, Problem{Severity: severity, Check: check, Where: where, Message: fmt.Sprintf(format, args...)})
+}
+
+// rooms checks the exits between the rooms, and that every room can be
+// walked to.
+func (l *linter) rooms() {
+	g := worldgraph.Build(l.w.Areas, l.w.Wilderness, l.w.Vehicles, false)
+	r := g.Validate(l.w.Starts...)
+	for _, e := range r.Dangling {
+		l.add(Error, CheckDanglingExit, e.From, "The %s to %s leads to a room that does not exist", e.Kind, e.To)
+	}
+	for _, e := range r.OneWay {
+		l.add(Warning, CheckOneWayExit, e.From, "The %s to %s has no way back", e.Kind, e.To)
+	}
+	for _, id := range r.Unreachable {
+		l.add(Error, CheckUnreachableRoom, id, "Nobody can get to the room from where players start")
+	}
+}
+
+// npcs checks that the NPCs stand in rooms there are, and drop from loot
+// tables there are. Those of generated areas are placed as the rooms are
+// built.
+func (l *linter) npcs() {
+	for _, a := range l.w.Areas {
+		for _, npc := range a.NPCs {
+			where := fmt.Sprintf("%s in %s", npc.Name, a.Name)
+			room, ok := a.Rooms[npc.Room]
+			switch {
+			case a.Generator.Style != "":
+			case !ok:
+				l.add(Error, CheckMissingRoom, where, "The NPC stands in %q, which is not a room of the area", npc.Room)
+			case npc.Position != "" && !hasCube(room, npc.Position):
+				l.add(Error, CheckMissingRoom, where, "The NPC stands on cube %q, which is not in %s", npc.Position, npc.Room)
+			}
+			if _, ok := l.w.LootTables[npc.Loot]; npc.Loot != "" && !ok {
+				l.add(Error, CheckMissingLootTable, where, "The NPC drops from the loot table %q, which does not exist", npc.Loot)
+			}
+		}
+	}
+}
+
+// loot checks that the loot tables drop items there are, and roll tables
+// there are.
+func (l *linter) loot() {
+	items := l.itemNames()
+	for _, t := range l.w.LootTables {
+		where := "loot table " + t.Name
+		for _, e := range t.Entries {
+			if _, ok := l.w.LootTables[e.Table]; e.Table != "" && !ok {
+				l.add(Error, CheckMissingLootTable, where, "An entry rolls the table %q, which does not exist", e.Table)
+			}
+			if e.Item != "" && !items[strings.ToLower(e.Item)] {
+				l.add(Warning, CheckMissingItem, where, "An entry drops %q, which is not in the items file and is only its name", e.Item)
+			}
+		}
+	}
+}
+
+// quests checks that the dialogues, which give the quests, are held by NPCs
+// there are, lead to nodes there are, and that the quests asked for are
+// given somewhere.
+func (l *linter) quests() {
+	npcs := map[string]bool{}
+	for _, a := range l.w.Areas {
+		for _, npc := range a.NPCs {
+			npcs[npc.Name] = true
+		}
+	}
+	given := map[string]bool{}
+	for _, d := range l.w.Dialogues {
+		for _, n := range d.Nodes {
+			for _, o := range n.Options {
+				if o.Quest != "" {
+					given[o.Quest] = true
+				}
+			}
+		}
+	}
+
+	for _, d := range l.w.Dialogues {
+		where := "dialogue of " + d.NPC
+		quests := map[string]bool{}
+		nodes := map[string]bool{}
+		for _, n := range d.Nodes {
+			nodes[n.ID] = true
+		}
+		for _, n := range d.Nodes {
+			l.quest(given, where, n.If.Quest)
+			for _, o := range n.Options {
+				l.quest(given, where, o.If.Quest)
+				if o.Quest != "" {
+					quests[o.Quest] = true
+				}
+				if o.Next != "" && !nodes[o.Next] {
+					l.add(Error, CheckMissingNode, where, "An option of %q leads to the node %q, which does not exist", n.ID, o.Next)
+				}
+			}
+		}
+		if !npcs[d.NPC] {
+			what := "the dialogue is never held"
+			if len(quests) > 0 {
+				what = fmt.Sprintf("the quests %s are never given", strings.Join(sortedKeys(quests), ", "))
+			}
+			l.add(Error, CheckMissingNPC, where, "No area has the NPC, so %s", what)
+		}
+	}
+	for _, a := range l.w.Achievements {
+		l.quest(given, "achievement "+a.Name, a.Quest)
+	}
+	for _, t := range l.w.LootTables {
+		for _, e := range t.Entries {
+			l.quest(given, "loot table "+t.Name, e.Quest)
+		}
+	}
+}
+
+// quest reports the quest, if no dialogue gives it.
+func (l *linter) quest(given map[string]bool, where, quest string) {
+	if quest != "" && !given[quest] {
+		l.add(Warning, CheckUnknownQuest, where, "The quest %q is asked for, but no dialogue gives it", quest)
+	}
+}
+
+// items checks that the items are named once, and are made for where they
+// go: what is wielded, worn, held, set in sockets, carried on keyrings or
+// carried things in.
+func (l *linter) items() {
+	seen := map[string]bool{}
+	for _, it := range l.w.Items {
+		where := "item " + it.Name
+		key := strings.ToLower(it.Name)
+		if seen[key] {
+			l.add(Error, CheckDuplicateItem, where, "The item is in the items file more than once, and only the last counts")
+		}
+		seen[key] = true
+
+		gear := it.Kind == area.ItemWeapon || it.Kind == area.ItemArmor || it.Kind == area.ItemShield
+		switch {
+		case it.Hands < 0 || it.Hands > 2:
+			l.add(Error, CheckInvalidSlot, where, "The item takes %d hands", it.Hands)
+		case it.Hands == 2 && it.Kind != area.ItemWeapon:
+			l.add(Error, CheckInvalidSlot, where, "Only weapons take both hands, not a %s", kind(it))
+		}
+		if it.Die > 0 && it.Kind != area.ItemWeapon {
+			l.add(Error, CheckInvalidSlot, where, "The item has a damage die, but only weapons deal damage, not a %s", kind(it))
+		}
+		if it.Kind == area.ItemWeapon && it.Die <= 0 {
+			l.add(Error, CheckInvalidSlot, where, "The weapon has no damage die")
+		}
+		if it.Block > 0 && it.Kind != area.ItemShield {
+			l.add(Error, CheckInvalidSlot, where, "The item blocks, but only shields do, not a %s", kind(it))
+		}
+		if it.Sockets > 0 && !gear {
+			l.add(Error, CheckInvalidSlot, where, "The item has sockets, but gems are only set in weapons, armor and shields, not a %s", kind(it))
+		}
+		if it.Gem.Any() && it.Kind != "gem" {
+			l.add(Error, CheckInvalidSlot, where, "The item adds to what it is set in, but only gems are set, not a %s", kind(it))
+		}
+		if it.Capacity > 0 && it.Kind != area.ItemContainer {
+			l.add(Error, CheckInvalidSlot, where, "The item carries things, but only containers do, not a %s", kind(it))
+		}
+		switch {
+		case it.Kind == area.ItemKey && it.Opens == "":
+			l.add(Error, CheckInvalidSlot, where, "The key opens no lock")
+		case it.Kind != area.ItemKey && it.Opens != "":
+			l.add(Error, CheckInvalidSlot, where, "The item opens a lock, but only keys go on keyrings, not a %s", kind(it))
+		}
+	}
+}
+
+// kind is what the item is, for the messages.
+func kind(it area.Item) string {
+	if it.Kind == "" {
+		return "plain item"
+	}
+	return it.Kind
+}
+
+// itemNames returns the lower case names of the items.
+func (l *linter) itemNames() map[string]bool {
+	names := map[string]bool{}
+	for _, it := range l.w.Items {
+		names[strings.ToLower(it.Name)] = true
+	}
+	return names
+}
+
+func hasCube(room area.Room, id string) bool {
+	for _, c := range room.Cubes {
+		if c.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
+func sortedKeys(m map[string]bool) []string {
+	keys := []string{}
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (r Report) String() string {
+	if len(r.Problems) == 0 {
+		return "The world is consistent.\n"
+	}
+	var b bytes.Buffer
+	for _, p := range r.Problems {
+		fmt.Fprintf(&b, "%s %s: %s: %s\n", p.Severity, p.Check, p.Where, p.Message)
+	}
+	fmt.Fprintf(&b, "%d errors, %d warnings\n", r.Errors(), len(r.Problems)-r.Errors())
+	return b.String()
+}
+
+// WriteJSON writes the report as JSON, with how many errors and warnings it
+// has.
+func (r Report) WriteJSON(w io.Writer) error {
+	problems := r.Problems
+	if problems == nil {
+		problems = []Problem{}
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(struct {
+		OK       bool      `json:"ok"`
+		Errors   int       `json:"errors"`
+		Warnings int       `json:"warnings"`
+		Problems []Problem `json:"problems"`
+	}{r.OK(), r.Errors(), len(problems) - r.Errors(), problems})
+}
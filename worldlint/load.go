@@ -0,0 +1,140 @@
+package worldlint
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/droslean/thyranew/area"
+	"github.com/droslean/thyranew/game"
+	"github.com/gothyra/toml"
+)
+
+// Load reads the world of the given static directory, the way the server
+// loads it. Generated areas are built with their own seed, or with the seed
+// 1 if they have none, so the report comes out the same every time.
+func Load(staticDir string, starts []string) (*World, error) {
+	w := &World{
+		Areas:      map[string]area.Area{},
+		Wilderness: map[string]*area.Wilderness{},
+		Vehicles:   map[string]*area.Vehicle{},
+		LootTables: map[string]game.LootTable{},
+		Dialogues:  map[string]area.Dialogue{},
+		Starts:     starts,
+	}
+	err := walkTOML(filepath.Join(staticDir, "areas"), func(content []byte) error {
+		a := area.Area{}
+		if _, err := toml.Decode(string(content), &a); err != nil {
+			return err
+		}
+		if a.Generator.Style != "" {
+			seed := a.Generator.Seed
+			if seed == 0 {
+				seed = 1
+			}
+			if err := area.Generate(&a, seed); err != nil {
+				return err
+			}
+		}
+		w.Areas[a.Name] = a
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = walkTOML(filepath.Join(staticDir, "wilderness"), func(content []byte) error {
+		wild := &area.Wilderness{}
+		if _, err := toml.Decode(string(content), wild); err != nil {
+			return err
+		}
+		w.Wilderness[wild.Name] = wild
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = walkTOML(filepath.Join(staticDir, "vehicles"), func(content []byte) error {
+		v := &area.Vehicle{}
+		if _, err := toml.Decode(string(content), v); err != nil {
+			return err
+		}
+		w.Vehicles[v.Name] = v
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = walkTOML(filepath.Join(staticDir, "loot"), func(content []byte) error {
+		t := game.LootTable{}
+		if _, err := toml.Decode(string(content), &t); err != nil {
+			return err
+		}
+		w.LootTables[t.Name] = t
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = walkTOML(filepath.Join(staticDir, "dialogues"), func(content []byte) error {
+		d := area.Dialogue{}
+		if _, err := toml.Decode(string(content), &d); err != nil {
+			return err
+		}
+		w.Dialogues[d.NPC] = d
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	items := struct {
+		Items []area.Item `toml:"items"`
+	}{}
+	if err := decodeFile(filepath.Join(staticDir, "items.toml"), &items); err != nil {
+		return nil, err
+	}
+	w.Items = items.Items
+	achievements := struct {
+		Achievements []area.Achievement `toml:"achievements"`
+	}{}
+	if err := decodeFile(filepath.Join(staticDir, "achievements.toml"), &achievements); err != nil {
+		return nil, err
+	}
+	w.Achievements = achievements.Achievements
+	return w, nil
+}
+
+// decodeFile reads the TOML file into v. Files that do not exist hold
+// nothing.
+func decodeFile(path string, v interface{}) error {
+	_, err := toml.DecodeFile(path, v)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+	return nil
+}
+
+// walkTOML calls load with the content of every TOML file in the directory.
+// Directories that do not exist hold nothing.
+func walkTOML(dir string, load func([]byte) error) error {
+	if _, err := os.Stat(dir); os.IsNotExist(err) {
+		return nil
+	}
+	return filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() || filepath.Ext(path) != ".toml" {
+			return nil
+		}
+		content, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		if err := load(content); err != nil {
+			return fmt.Errorf("%s: %v", path, err)
+		}
+		return nil
+	})
+}